@@ -16,8 +16,9 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
 )
 
@@ -51,6 +52,9 @@ type AccessContextID int64
 //
 //     - IN:south:HYD:BR-101
 //     - sbu-08/client-0249/prj-006348
+//
+// The delimiter is given by the setting `SettingACDelimiter`.  Use
+// `ListChildrenOf`, `ListByLevel` and `Match` to query the hierarchy.
 type AccessContext struct {
 	ID     AccessContextID `json:"ID"`               // Unique identifier of this access context
 	Name   string          `json:"Name,omitempty"`   // Globally-unique namespace; can be a department, project, location, branch, etc.
@@ -81,40 +85,36 @@ var AccessContexts _AccessContexts
 // New creates a new access context with the globally-unique name
 // given.
 func (_AccessContexts) New(otx *sql.Tx, name string) (AccessContextID, error) {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return 0, err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, errors.New("access context name should be non-empty")
+	var v validator
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_access_contexts", name, 0)
+	if err := v.result(); err != nil {
+		return 0, err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	var acID int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `INSERT INTO wf_access_contexts(name, active) VALUES(?, 1)`
+		res, err := tx.Exec(q, name)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		acID, err = res.LastInsertId()
+		if err != nil {
+			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `INSERT INTO wf_access_contexts(name, active) VALUES(?, 1)`
-	res, err := tx.Exec(q, name)
-	if err != nil {
-		return 0, err
-	}
-	acID, err := res.LastInsertId()
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	if otx == nil {
-		err := tx.Commit()
-		if err != nil {
-			return 0, err
-		}
-	}
-
 	return AccessContextID(acID), nil
 }
 
@@ -125,7 +125,7 @@ func (_AccessContexts) New(otx *sql.Tx, name string) (AccessContextID, error) {
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContext, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit should be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit should be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -143,7 +143,7 @@ func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContex
 		ORDER BY id
 		LIMIT ? OFFSET ?
 		`
-		rows, err = db.Query(q, limit, offset)
+		rows, err = readDB().Query(q, limit, offset)
 	} else {
 		q = `
 		SELECT id, name, active
@@ -152,7 +152,7 @@ func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContex
 		ORDER BY id
 		LIMIT ? OFFSET ?
 		`
-		rows, err = db.Query(q, prefix+"%", limit, offset)
+		rows, err = readDB().Query(q, prefix+"%", limit, offset)
 	}
 
 	if err != nil {
@@ -176,6 +176,143 @@ func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContex
 	return ary, nil
 }
 
+// AccessContextsListInput specifies a set of filtering criteria on
+// access contexts.  All the given criteria should be met.
+type AccessContextsListInput struct {
+	ActiveOnly bool   // List only the active access contexts?
+	Search     string // List access contexts whose names contain this
+}
+
+// where answers the SQL condition and the arguments of this
+// specification.
+func (input *AccessContextsListInput) where() (string, []interface{}) {
+	conds := []string{`1 = 1`}
+	args := []interface{}{}
+
+	if input.ActiveOnly {
+		conds = append(conds, `ac.active = 1`)
+	}
+	if search := strings.TrimSpace(input.Search); search != "" {
+		conds = append(conds, `ac.name LIKE ?`)
+		args = append(args, "%"+likeEscape(search)+"%")
+	}
+
+	return strings.Join(conds, ` AND `), args
+}
+
+// AccessContextSummary is an access context, together with figures
+// describing its use.
+type AccessContextSummary struct {
+	AccessContext
+	Groups   int64 `json:"Groups"`   // Number of groups included in this access context
+	OpenDocs int64 `json:"OpenDocs"` // Number of root documents, of all types, not in the state of an end node
+}
+
+// Search answers a subset of the access contexts meeting the given
+// criteria, with the number of their groups and open documents.  The
+// documents are counted once per document type, for all the access
+// contexts answered together.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_AccessContexts) Search(input *AccessContextsListInput, offset, limit int64) ([]*AccessContextSummary, error) {
+	if input == nil {
+		return nil, newError(CodeValidation, "input should be non-nil")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit should be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	where, args := input.where()
+	q := `
+	SELECT ac.id, ac.name, ac.active,
+		(SELECT COUNT(*) FROM wf_ac_group_hierarchy auh WHERE auh.ac_id = ac.id)
+	FROM wf_access_contexts ac
+	WHERE ` + where + `
+	ORDER BY ac.id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+
+	ary := make([]*AccessContextSummary, 0, 10)
+	byID := map[AccessContextID]*AccessContextSummary{}
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
+		var elem AccessContextSummary
+		if err := scan(&elem.ID, &elem.Name, &elem.Active, &elem.Groups); err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		byID[elem.ID] = &elem
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ary) == 0 {
+		return ary, nil
+	}
+
+	dts, err := DocTypes.List(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	acArgs := make([]interface{}, 0, len(ary))
+	for _, elem := range ary {
+		acArgs = append(acArgs, elem.ID)
+	}
+	for _, dt := range dts {
+		q = `
+		SELECT docs.ac_id, COUNT(*)
+		FROM ` + DocTypes.docStorName(dt.ID) + ` docs
+		WHERE docs.ac_id IN ` + inPlaceholders(len(acArgs)) + `
+		AND docs.path = ''
+		AND docs.docstate_id NOT IN (
+			SELECT docstate_id
+			FROM wf_workflow_nodes
+			WHERE doctype_id = ?
+			AND type = 'end'
+		)
+		GROUP BY docs.ac_id
+		`
+		err = scanEach(readDB().Query, q, append(acArgs, dt.ID), func(scan func(...interface{}) error) error {
+			var acID AccessContextID
+			var n int64
+			if err := scan(&acID, &n); err != nil {
+				return err
+			}
+			if elem, ok := byID[acID]; ok {
+				elem.OpenDocs += n
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ary, nil
+}
+
+// Count answers the number of access contexts meeting the given
+// criteria.
+func (_AccessContexts) Count(input *AccessContextsListInput) (int64, error) {
+	if input == nil {
+		return 0, newError(CodeValidation, "input should be non-nil")
+	}
+
+	where, args := input.where()
+	var n int64
+	err := readDB().QueryRow(`SELECT COUNT(*) FROM wf_access_contexts ac WHERE `+where, args...).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 // ListByGroup answers a list of access contexts in which the given
 // group is included.
 //
@@ -184,7 +321,7 @@ func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContex
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_AccessContexts) ListByGroup(gid GroupID, offset, limit int64) ([]*AccessContext, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit should be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit should be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -198,7 +335,7 @@ func (_AccessContexts) ListByGroup(gid GroupID, offset, limit int64) ([]*AccessC
 	ORDER BY agh.ac_id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, gid, limit, offset)
+	rows, err := readDB().Query(q, gid, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -228,7 +365,7 @@ func (_AccessContexts) ListByGroup(gid GroupID, offset, limit int64) ([]*AccessC
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_AccessContexts) ListByUser(uid UserID, offset, limit int64) ([]*AccessContext, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit should be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit should be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -243,12 +380,12 @@ func (_AccessContexts) ListByUser(uid UserID, offset, limit int64) ([]*AccessCon
 		FROM wf_groups_master gm
 		JOIN wf_group_users gu ON gu.group_id = gm.id
 		WHERE gu.user_id = ?
-		AND gm.group_type = 'S'
+		AND gm.group_type = ` + GroupSingleton.sql() + `
 	)
 	ORDER BY agh.ac_id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, uid, limit, offset)
+	rows, err := readDB().Query(q, uid, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -278,11 +415,11 @@ func (_AccessContexts) Get(id AccessContextID) (*AccessContext, error) {
 	FROM wf_access_contexts
 	WHERE id = ?
 	`
-	res := db.QueryRow(q, id)
+	res := readDB().QueryRow(q, id)
 	var elem AccessContext
 	err := res.Scan(&elem.ID, &elem.Name, &elem.Active)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrAccessContextNotFound)
 	}
 
 	return &elem, nil
@@ -291,80 +428,68 @@ func (_AccessContexts) Get(id AccessContextID) (*AccessContext, error) {
 // Rename changes the name of the given access context to the
 // specified new name.
 func (_AccessContexts) Rename(otx *sql.Tx, id AccessContextID, name string) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return errors.New("access context name should be non-empty")
+	var v validator
+	v.positive("id", int64(id))
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_access_contexts", name, int64(id))
+	if err := v.result(); err != nil {
+		return err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_access_contexts
+		SET name = ?
+		WHERE id = ?
+		`
+		_, err := tx.Exec(q, name, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `
-	UPDATE wf_access_contexts
-	SET name = ?
-	WHERE id = ?
-	`
-	_, err = tx.Exec(q, name, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err := tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
 // SetActive updates the given access context with the new active
 // status.
 func (_AccessContexts) SetActive(otx *sql.Tx, id AccessContextID, active bool) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return err
+	}
+
 	act := 0
 	if active {
 		act = 1
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_access_contexts
+		SET active = ?
+		WHERE id = ?
+		`
+		_, err := tx.Exec(q, act, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `
-	UPDATE wf_access_contexts
-	SET active = ?
-	WHERE id = ?
-	`
-	_, err = tx.Exec(q, act, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err := tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
@@ -372,13 +497,13 @@ func (_AccessContexts) SetActive(otx *sql.Tx, id AccessContextID, active bool) e
 // context.
 func (_AccessContexts) GroupRoles(id AccessContextID, gids []GroupID, offset, limit int64) (map[GroupID]*AcGroupRoles, error) {
 	if id <= 0 {
-		return nil, errors.New("access context ID should be a positive integer")
+		return nil, newError(CodeValidation, "access context ID should be a positive integer")
 	}
 	if len(gids) == 0 {
-		return nil, errors.New("list of group IDs should be non-empty")
+		return nil, newError(CodeValidation, "list of group IDs should be non-empty")
 	}
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit should be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit should be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -441,76 +566,168 @@ func (_AccessContexts) GroupRoles(id AccessContextID, gids []GroupID, offset, li
 }
 
 // AddGroupRole assigns the specified role to the given group, if it
-// is not already assigned.
+// is not already assigned.  The number of roles that the group can have
+// in the access context is limited as per `RoleLimit`; a
+// `RoleLimitError` is answered should the group have reached it.  See
+// `OverrideGroupRole`.
 func (_AccessContexts) AddGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return err
+	}
+
+	return addGroupRole(otx, id, gid, rid, true)
+}
+
+// OverrideGroupRole assigns the specified role to the given group, as
+// `AddGroupRole` does, but beyond the limit on the number of roles of
+// the group in the access context.  When acting through `AsAdmin`, the
+// user should administer both access contexts and security.
+func (_AccessContexts) OverrideGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return err
+	}
+	if err := checkAdmin(otx, AdminSecurity); err != nil {
+		return err
+	}
+
+	if err := addGroupRole(otx, id, gid, rid, false); err != nil {
+		return err
+	}
+	writeLog(LogWarn, "role limit overridden", F("ac", id), F("group", gid), F("role", rid))
+	return nil
+}
+
+// addGroupRole assigns the specified role to the given group,
+// enforcing the limit on the number of its roles if so requested.
+func addGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID, enforce bool) error {
 	if gid <= 0 || rid <= 0 {
-		return errors.New("group ID and role ID should be positive integers")
+		return newError(CodeValidation, "group ID and role ID should be positive integers")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		if enforce {
+			if err := checkRoleLimit(tx, id, gid); err != nil {
+				return err
+			}
+		}
+
+		if err := checkHoldsPermissions(tx, gid); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO wf_ac_group_roles(ac_id, group_id, role_id) VALUES(?, ?, ?)`, id, gid, rid)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	_, err = tx.Exec(`INSERT INTO wf_ac_group_roles(ac_id, group_id, role_id) VALUES(?, ?, ?)`, id, gid, rid)
+		return refreshDynamicGroups(tx, rid)
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err := tx.Commit()
-		if err != nil {
-			return err
+	return nil
+}
+
+// RoleLimitError is answered by `AccessContexts.AddGroupRole` when the
+// group already has the maximum number of roles in the access context.
+// It matches `ErrRoleLimitReached`, and has the code `CodeConflict`.
+type RoleLimitError struct {
+	AccCtx AccessContextID // Access context
+	Group  GroupID         // Group having the maximum number of roles
+	Max    int             // Limit in force
+}
+
+// Error implements the `error` interface.
+func (e *RoleLimitError) Error() string {
+	return fmt.Sprintf("%s : group %d has %d roles in access context %d", ErrRoleLimitReached, e.Group, e.Max, e.AccCtx)
+}
+
+// Is answers `true` if the target is `ErrRoleLimitReached`, or its
+// code.
+func (e *RoleLimitError) Is(target error) bool {
+	return target == ErrRoleLimitReached || target == CodeConflict
+}
+
+// SetRoleLimit limits the number of roles that a group can have in the
+// given access context.  A limit of `0` removes that of the context,
+// which then follows the global limit, if any; see
+// `SettingACRoleLimit`.  Existing assignments are not affected.
+func (_AccessContexts) SetRoleLimit(otx *sql.Tx, id AccessContextID, max int) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return err
+	}
+	if id <= 0 || max < 0 {
+		return newError(CodeValidation, "access context ID should be positive, and limit non-negative")
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if max == 0 {
+			return storeSetting(tx, SettingACRoleLimit, SettingScope{AccCtx: id}, nil)
 		}
+		val := strconv.Itoa(max)
+		return storeSetting(tx, SettingACRoleLimit, SettingScope{AccCtx: id}, &val)
+	})
+}
+
+// RoleLimit answers the maximum number of roles that a group can have
+// in the given access context; `0` if there is no limit.
+func (_AccessContexts) RoleLimit(id AccessContextID) (int, error) {
+	if id <= 0 {
+		return 0, newError(CodeValidation, "access context ID should be a positive integer")
+	}
+
+	n, err := settingInt(readDB().QueryRow, SettingACRoleLimit, SettingScope{AccCtx: id}, 0)
+	return int(n), err
+}
+
+// checkRoleLimit answers a `RoleLimitError` if the given group has the
+// maximum number of roles in the given access context.
+func checkRoleLimit(tx *sql.Tx, id AccessContextID, gid GroupID) error {
+	max, err := settingInt(tx.QueryRow, SettingACRoleLimit, SettingScope{AccCtx: id}, 0)
+	if err != nil || max <= 0 {
+		return err
 	}
 
+	var n int64
+	q := `SELECT COUNT(*) FROM wf_ac_group_roles WHERE ac_id = ? AND group_id = ? FOR UPDATE`
+	if err = tx.QueryRow(q, id, gid).Scan(&n); err != nil {
+		return err
+	}
+	if n >= max {
+		return &RoleLimitError{AccCtx: id, Group: gid, Max: int(max)}
+	}
 	return nil
 }
 
 // RemoveGroupRole unassigns the specified role from the given group.
 func (_AccessContexts) RemoveGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return err
+	}
+
 	if gid <= 0 || rid <= 0 {
-		return errors.New("group ID and role ID should be positive integers")
+		return newError(CodeValidation, "group ID and role ID should be positive integers")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM wf_ac_group_roles WHERE ac_id = ? AND group_id = ? AND role_id = ?`, id, gid, rid)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	_, err = tx.Exec(`DELETE FROM wf_ac_group_roles WHERE ac_id = ? AND group_id = ? AND role_id = ?`, id, gid, rid)
+		return refreshDynamicGroups(tx, rid)
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err := tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
 // Groups retrieves the users included in this access context.
 func (_AccessContexts) Groups(id AccessContextID, offset, limit int64) (map[GroupID]*AcGroup, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit should be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit should be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -525,7 +742,7 @@ func (_AccessContexts) Groups(id AccessContextID, offset, limit int64) (map[Grou
 	ORDER BY auh.group_id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, id, limit, offset)
+	rows, err := readDB().Query(q, id, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -552,69 +769,56 @@ func (_AccessContexts) Groups(id AccessContextID, offset, limit int64) (map[Grou
 // specified reporting authority within the hierarchy of this access
 // context.
 func (_AccessContexts) AddGroup(otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return err
+	}
+
 	if gid <= 0 || reportsTo < 0 {
-		return errors.New("group ID should be a positive integer; reporting authority ID should be a non-negative integer")
+		return newError(CodeValidation, "group ID should be a positive integer; reporting authority ID should be a non-negative integer")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		if err := checkHoldsPermissions(tx, gid); err != nil {
+			return err
+		}
+		q := `INSERT INTO wf_ac_group_hierarchy(ac_id, group_id, reports_to) VALUES (?, ?, ?)`
+		_, err := tx.Exec(q, id, gid, reportsTo)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `INSERT INTO wf_ac_group_hierarchy(ac_id, group_id, reports_to) VALUES (?, ?, ?)`
-	_, err = tx.Exec(q, id, gid, reportsTo)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err := tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
 // DeleteGroup removes the given group from this access context.
 func (_AccessContexts) DeleteGroup(otx *sql.Tx, id AccessContextID, gid GroupID) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
+		return err
+	}
+
 	if gid <= 0 {
-		return errors.New("user ID should be positive integer")
+		return newError(CodeValidation, "user ID should be positive integer")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `DELETE FROM wf_ac_group_hierarchy WHERE ac_id = ? AND group_id = ?`
+		_, err := tx.Exec(q, id, gid)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `DELETE FROM wf_ac_group_hierarchy WHERE ac_id = ? AND group_id = ?`
-	_, err = tx.Exec(q, id, gid)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err := tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
@@ -627,7 +831,7 @@ func (_AccessContexts) GroupReportsTo(id AccessContextID, uid GroupID) (GroupID,
 	WHERE ac_id = ?
 	AND group_id = ?
 	`
-	row := db.QueryRow(q, id, uid)
+	row := readDB().QueryRow(q, id, uid)
 	var repID int64
 	err := row.Scan(&repID)
 	if err != nil {
@@ -646,7 +850,7 @@ func (_AccessContexts) GroupReportees(id AccessContextID, uid GroupID) ([]GroupI
 	WHERE ac_id = ?
 	AND reports_to = ?
 	`
-	rows, err := db.Query(q, id, uid)
+	rows, err := readDB().Query(q, id, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -668,41 +872,84 @@ func (_AccessContexts) GroupReportees(id AccessContextID, uid GroupID) ([]GroupI
 	return ary, nil
 }
 
-// ChangeReporting reassigns the group to a different reporting
-// authority.
-func (_AccessContexts) ChangeReporting(otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error {
-	if gid <= 0 || reportsTo < 0 {
-		return errors.New("group ID should be positive integer; reporting authority ID should be a non-negative integer")
+// userSubtree answers the groups of the given user in this access
+// context, together with all the groups that report to them, directly
+// or indirectly.
+func (_AccessContexts) userSubtree(id AccessContextID, uid UserID) ([]GroupID, error) {
+	q := `
+	SELECT agh.group_id
+	FROM wf_ac_group_hierarchy agh
+	JOIN wf_group_users gu ON gu.group_id = agh.group_id
+	WHERE agh.ac_id = ?
+	AND gu.user_id = ?
+	`
+	rows, err := readDB().Query(q, id, uid)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	seen := map[GroupID]bool{}
+	ary := []GroupID{}
+	for rows.Next() {
+		var gid int64
+		if err = rows.Scan(&gid); err != nil {
+			return nil, err
+		}
+		if !seen[GroupID(gid)] {
+			seen[GroupID(gid)] = true
+			ary = append(ary, GroupID(gid))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Walk down the hierarchy, level by level.  The set of groups seen
+	// guards against cycles.
+	for i := 0; i < len(ary); i++ {
+		reps, err := AccessContexts.GroupReportees(id, ary[i])
 		if err != nil {
-			return err
+			return nil, err
+		}
+		for _, gid := range reps {
+			if !seen[gid] {
+				seen[gid] = true
+				ary = append(ary, gid)
+			}
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
 	}
 
-	q := `
-	UPDATE wf_ac_group_hierarchy
-	SET reports_to = ?
-	WHERE ac_id = ?
-	AND group_id = ?
-	`
-	_, err = tx.Exec(q, reportsTo, id, gid)
-	if err != nil {
+	return ary, nil
+}
+
+// ChangeReporting reassigns the group to a different reporting
+// authority.
+func (_AccessContexts) ChangeReporting(otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error {
+	if err := checkAdmin(otx, AdminAccessContexts); err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err := tx.Commit()
+	if gid <= 0 || reportsTo < 0 {
+		return newError(CodeValidation, "group ID should be positive integer; reporting authority ID should be a non-negative integer")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_ac_group_hierarchy
+		SET reports_to = ?
+		WHERE ac_id = ?
+		AND group_id = ?
+		`
+		_, err := tx.Exec(q, reportsTo, id, gid)
 		if err != nil {
 			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -712,7 +959,7 @@ func (_AccessContexts) ChangeReporting(otx *sql.Tx, id AccessContextID, gid, rep
 // access context.
 func (_AccessContexts) IncludesGroup(id AccessContextID, gid GroupID) (bool, error) {
 	if gid <= 0 {
-		return false, errors.New("group ID should be a positive integer")
+		return false, newError(CodeValidation, "group ID should be a positive integer")
 	}
 
 	q := `
@@ -722,7 +969,7 @@ func (_AccessContexts) IncludesGroup(id AccessContextID, gid GroupID) (bool, err
 	AND group_id = ?
 	`
 	var repTo int64
-	row := db.QueryRow(q, id, gid)
+	row := readDB().QueryRow(q, id, gid)
 	err := row.Scan(&repTo)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -738,7 +985,7 @@ func (_AccessContexts) IncludesGroup(id AccessContextID, gid GroupID) (bool, err
 // access context.
 func (_AccessContexts) IncludesUser(id AccessContextID, uid UserID) (bool, error) {
 	if uid <= 0 {
-		return false, errors.New("user ID should be a positive integer")
+		return false, newError(CodeValidation, "user ID should be a positive integer")
 	}
 
 	q := `
@@ -753,8 +1000,11 @@ func (_AccessContexts) IncludesUser(id AccessContextID, uid UserID) (bool, error
 	)
 	`
 	var count int64
-	row := db.QueryRow(q, id, uid)
-	err := row.Scan(&count)
+	row, err := readStmts().queryRow(nil, q, id, uid)
+	if err != nil {
+		return false, err
+	}
+	err = row.Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -770,17 +1020,18 @@ func (_AccessContexts) IncludesUser(id AccessContextID, uid UserID) (bool, error
 // given user in this access context.
 func (_AccessContexts) UserPermissions(id AccessContextID, uid UserID) (map[DocTypeID][]DocAction, error) {
 	if uid <= 0 {
-		return nil, errors.New("user ID should be a positive integer")
+		return nil, newError(CodeValidation, "user ID should be a positive integer")
 	}
 
 	q := `
-	SELECT acpv.doctype_id, acpv.docaction_id, dam.name, dam.reconfirm
+	SELECT acpv.doctype_id, acpv.docaction_id, dam.name, dam.reconfirm, dam.active
 	FROM wf_ac_perms_v acpv
 	JOIN wf_docactions_master dam ON dam.id = acpv.docaction_id
 	WHERE acpv.ac_id = ?
+	AND dam.active = 1
 	AND acpv.user_id = ?
 	`
-	rows, err := db.Query(q, id, uid)
+	rows, err := readDB().Query(q, id, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -790,7 +1041,7 @@ func (_AccessContexts) UserPermissions(id AccessContextID, uid UserID) (map[DocT
 	for rows.Next() {
 		var dtid int64
 		var da DocAction
-		err = rows.Scan(&dtid, &da.ID, &da.Name, &da.Reconfirm)
+		err = rows.Scan(&dtid, &da.ID, &da.Name, &da.Reconfirm, &da.Active)
 		if err != nil {
 			return nil, err
 		}
@@ -814,18 +1065,19 @@ func (_AccessContexts) UserPermissions(id AccessContextID, uid UserID) (map[DocT
 // access context.
 func (_AccessContexts) UserPermissionsByDocType(id AccessContextID, dtype DocTypeID, uid UserID) ([]DocAction, error) {
 	if id <= 0 || dtype <= 0 || uid <= 0 {
-		return nil, errors.New("all identifiers should be positive integers")
+		return nil, newError(CodeValidation, "all identifiers should be positive integers")
 	}
 
 	q := `
-	SELECT acpv.docaction_id, dam.name, dam.reconfirm
+	SELECT acpv.docaction_id, dam.name, dam.reconfirm, dam.active
 	FROM wf_ac_perms_v acpv
 	JOIN wf_docactions_master dam ON dam.id = acpv.docaction_id
 	WHERE acpv.ac_id = ?
+	AND dam.active = 1
 	AND acpv.doctype_id = ?
 	AND acpv.user_id = ?
 	`
-	rows, err := db.Query(q, id, dtype, uid)
+	rows, err := readDB().Query(q, id, dtype, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -834,7 +1086,7 @@ func (_AccessContexts) UserPermissionsByDocType(id AccessContextID, dtype DocTyp
 	res := []DocAction{}
 	for rows.Next() {
 		var da DocAction
-		err = rows.Scan(&da.ID, &da.Name, &da.Reconfirm)
+		err = rows.Scan(&da.ID, &da.Name, &da.Reconfirm, &da.Active)
 		if err != nil {
 			return nil, err
 		}
@@ -852,17 +1104,18 @@ func (_AccessContexts) UserPermissionsByDocType(id AccessContextID, dtype DocTyp
 // given user in this access context.
 func (_AccessContexts) GroupPermissions(id AccessContextID, gid GroupID) (map[DocTypeID][]DocAction, error) {
 	if gid <= 0 {
-		return nil, errors.New("group ID should be a positive integer")
+		return nil, newError(CodeValidation, "group ID should be a positive integer")
 	}
 
 	q := `
-	SELECT acpv.doctype_id, acpv.docaction_id, dam.name, dam.reconfirm
+	SELECT acpv.doctype_id, acpv.docaction_id, dam.name, dam.reconfirm, dam.active
 	FROM wf_ac_perms_v acpv
 	JOIN wf_docactions_master dam ON dam.id = acpv.docaction_id
 	WHERE acpv.ac_id = ?
+	AND dam.active = 1
 	AND acpv.group_id = ?
 	`
-	rows, err := db.Query(q, id, gid)
+	rows, err := readDB().Query(q, id, gid)
 	if err != nil {
 		return nil, err
 	}
@@ -872,7 +1125,7 @@ func (_AccessContexts) GroupPermissions(id AccessContextID, gid GroupID) (map[Do
 	for rows.Next() {
 		var dtid int64
 		var da DocAction
-		err = rows.Scan(&dtid, &da.ID, &da.Name, &da.Reconfirm)
+		err = rows.Scan(&dtid, &da.ID, &da.Name, &da.Reconfirm, &da.Active)
 		if err != nil {
 			return nil, err
 		}
@@ -896,18 +1149,19 @@ func (_AccessContexts) GroupPermissions(id AccessContextID, gid GroupID) (map[Do
 // access context.
 func (_AccessContexts) GroupPermissionsByDocType(id AccessContextID, dtype DocTypeID, gid GroupID) ([]DocAction, error) {
 	if id <= 0 || dtype <= 0 || gid <= 0 {
-		return nil, errors.New("all identifiers should be positive integers")
+		return nil, newError(CodeValidation, "all identifiers should be positive integers")
 	}
 
 	q := `
-	SELECT acpv.docaction_id, dam.name, dam.reconfirm
+	SELECT acpv.docaction_id, dam.name, dam.reconfirm, dam.active
 	FROM wf_ac_perms_v acpv
 	JOIN wf_docactions_master dam ON dam.id = acpv.docaction_id
 	WHERE acpv.ac_id = ?
+	AND dam.active = 1
 	AND acpv.doctype_id = ?
 	AND acpv.group_id = ?
 	`
-	rows, err := db.Query(q, id, dtype, gid)
+	rows, err := readDB().Query(q, id, dtype, gid)
 	if err != nil {
 		return nil, err
 	}
@@ -916,7 +1170,7 @@ func (_AccessContexts) GroupPermissionsByDocType(id AccessContextID, dtype DocTy
 	res := []DocAction{}
 	for rows.Next() {
 		var da DocAction
-		err = rows.Scan(&da.ID, &da.Name, &da.Reconfirm)
+		err = rows.Scan(&da.ID, &da.Name, &da.Reconfirm, &da.Active)
 		if err != nil {
 			return nil, err
 		}
@@ -935,7 +1189,7 @@ func (_AccessContexts) GroupPermissionsByDocType(id AccessContextID, dtype DocTy
 // otherwise.
 func (_AccessContexts) UserHasPermission(id AccessContextID, uid UserID, dtype DocTypeID, action DocActionID) (bool, error) {
 	if uid <= 0 || dtype <= 0 || action <= 0 {
-		return false, errors.New("invalid user ID or document type or document action")
+		return false, newError(CodeValidation, "invalid user ID or document type or document action")
 	}
 
 	q := `
@@ -946,9 +1200,12 @@ func (_AccessContexts) UserHasPermission(id AccessContextID, uid UserID, dtype D
 	AND docaction_id = ?
 	LIMIT 1
 	`
-	row := db.QueryRow(q, id, uid, dtype, action)
+	row, err := readStmts().queryRow(nil, q, id, uid, dtype, action)
+	if err != nil {
+		return false, err
+	}
 	var roleID int64
-	err := row.Scan(&roleID)
+	err = row.Scan(&roleID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -963,7 +1220,7 @@ func (_AccessContexts) UserHasPermission(id AccessContextID, uid UserID, dtype D
 // otherwise.
 func (ac *AccessContext) GroupHasPermission(id AccessContextID, gid GroupID, dtype DocTypeID, action DocActionID) (bool, error) {
 	if gid <= 0 || dtype <= 0 || action <= 0 {
-		return false, errors.New("invalid group ID or document type or document action")
+		return false, newError(CodeValidation, "invalid group ID or document type or document action")
 	}
 
 	q := `
@@ -974,7 +1231,7 @@ func (ac *AccessContext) GroupHasPermission(id AccessContextID, gid GroupID, dty
 	AND docaction_id = ?
 	LIMIT 1
 	`
-	row := db.QueryRow(q, id, gid, dtype, action)
+	row := readDB().QueryRow(q, id, gid, dtype, action)
 	var roleID int64
 	err := row.Scan(&roleID)
 	if err != nil {
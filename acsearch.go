@@ -0,0 +1,208 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefACDelimiter is the delimiter of the levels of hierarchical names
+// of access contexts, when `SettingACDelimiter` is not set.
+const DefACDelimiter = ":"
+
+// acDelimiter answers the delimiter in force.
+func acDelimiter() (string, error) {
+	d, err := Settings.String(SettingACDelimiter, SettingScope{}, DefACDelimiter)
+	if err != nil {
+		return "", err
+	}
+	if utf8.RuneCountInString(d) != 1 {
+		return "", errorf(CodeValidation, "setting %s should be a single character", SettingACDelimiter)
+	}
+	return d, nil
+}
+
+// likeEscape escapes the wildcards of `LIKE` in the given text.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// listACs answers the access contexts read by the given query, ordered
+// by their IDs, keeping those accepted by `keep`, if given.  The
+// offset and limit apply to the accepted ones.
+func listACs(q string, args []interface{}, keep func(string) bool, offset, limit int64) ([]*AccessContext, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit should be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+	if keep == nil {
+		q += `
+		LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+
+	ary := make([]*AccessContext, 0, 10)
+	var skipped int64
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
+		var elem AccessContext
+		if err := scan(&elem.ID, &elem.Name, &elem.Active); err != nil {
+			return err
+		}
+		if keep != nil {
+			if !keep(elem.Name) || int64(len(ary)) >= limit {
+				return nil
+			}
+			if skipped < offset {
+				skipped++
+				return nil
+			}
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// ListChildrenOf answers the access contexts immediately below the
+// given one in the naming hierarchy; e.g. `IN:south:HYD` and
+// `IN:south:BLR` for `IN:south`.  The parent itself need not exist.
+// Levels are separated by the delimiter in `SettingACDelimiter`.
+//
+// Result set skips the first `offset` matching access contexts, and
+// has not more than `limit` elements.  A value of `0` for `limit`
+// fetches until the end.
+func (_AccessContexts) ListChildrenOf(parent string, offset, limit int64) ([]*AccessContext, error) {
+	parent = strings.TrimSpace(parent)
+	if parent == "" {
+		return nil, newError(CodeValidation, "parent name should be non-empty")
+	}
+	d, err := acDelimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := likeEscape(parent + d)
+	q := `
+	SELECT id, name, active
+	FROM wf_access_contexts
+	WHERE name LIKE ?
+	AND name NOT LIKE ?
+	AND name <> ?
+	ORDER BY id`
+	return listACs(q, []interface{}{prefix + "%", prefix + "%" + likeEscape(d) + "%", parent + d}, nil, offset, limit)
+}
+
+// ListByLevel answers the access contexts at the given level of the
+// naming hierarchy: those having names of `level` components.  Level
+// `1` has the top-level contexts, e.g. `IN`; level `2` has their
+// children, e.g. `IN:south`; and so on.
+//
+// Result set skips the first `offset` matching access contexts, and
+// has not more than `limit` elements.  A value of `0` for `limit`
+// fetches until the end.
+func (_AccessContexts) ListByLevel(level int, offset, limit int64) ([]*AccessContext, error) {
+	if level <= 0 {
+		return nil, newError(CodeValidation, "level should be a positive integer")
+	}
+	d, err := acDelimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT id, name, active
+	FROM wf_access_contexts
+	WHERE (CHAR_LENGTH(name) - CHAR_LENGTH(REPLACE(name, ?, ''))) / CHAR_LENGTH(?) = ?
+	ORDER BY id`
+	return listACs(q, []interface{}{d, d, level - 1}, nil, offset, limit)
+}
+
+// Match answers the access contexts whose names match the given glob
+// pattern.  In it, `*` matches any text within a level, `**` matches
+// any text across levels, and `?` matches a single character other
+// than the delimiter.  For instance, `IN:*:HYD:**` matches all the
+// contexts below `HYD` in all the zones of `IN`.
+//
+// Result set skips the first `offset` matching access contexts, and
+// has not more than `limit` elements.  A value of `0` for `limit`
+// fetches until the end.
+func (_AccessContexts) Match(pattern string, offset, limit int64) ([]*AccessContext, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, newError(CodeValidation, "pattern should be non-empty")
+	}
+	d, err := acDelimiter()
+	if err != nil {
+		return nil, err
+	}
+	re, err := acGlob(pattern, d)
+	if err != nil {
+		return nil, err
+	}
+
+	// Narrow the candidates using the literal prefix of the pattern.
+	lit := pattern
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		lit = pattern[:i]
+	}
+	q := `
+	SELECT id, name, active
+	FROM wf_access_contexts
+	WHERE name LIKE ?
+	ORDER BY id`
+	return listACs(q, []interface{}{likeEscape(lit) + "%"}, re.MatchString, offset, limit)
+}
+
+// acGlob compiles the given glob pattern of access context names,
+// having levels separated by the given delimiter.
+func acGlob(pattern, d string) (*regexp.Regexp, error) {
+	nd := `[^` + regexp.QuoteMeta(d) + `]`
+
+	var sb strings.Builder
+	sb.WriteString(`^`)
+	rs := []rune(pattern)
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case rs[i] == '*' && i+1 < len(rs) && rs[i+1] == '*':
+			sb.WriteString(`.*`)
+			i++
+
+		case rs[i] == '*':
+			sb.WriteString(nd + `*`)
+
+		case rs[i] == '?':
+			sb.WriteString(nd)
+
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(rs[i])))
+		}
+	}
+	sb.WriteString(`$`)
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, errorf(CodeValidation, "invalid pattern : %v", err)
+	}
+	return re, nil
+}
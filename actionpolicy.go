@@ -0,0 +1,226 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ActionPolicy governs how a document action is performed: whether the
+// user should be prompted to reconfirm it, and whether the event
+// should carry a reason.
+//
+// A policy can apply to the action everywhere, in an access context, to
+// the holders of a role -- in an access context, or in all -- so that,
+// e.g., `REJECT` requires a justification from reviewers alone.  The
+// most specific policies applicable to a user are in force: those of
+// the user's roles in the access context, then those of the roles in
+// all contexts, then that of the access context, and finally that of
+// the action.  Should several roles of the user have policies, the
+// strictest combination of them is in force.  Without any policy,
+// `DocAction.Reconfirm` is in force, and no reason is required.
+type ActionPolicy struct {
+	Action         DocActionID     `json:"Action"`         // Action governed
+	AccCtx         AccessContextID `json:"AccCtx"`         // Access context, if specific to one; `0` otherwise
+	Role           RoleID          `json:"Role"`           // Role, if specific to one; `0` otherwise
+	Reconfirm      bool            `json:"Reconfirm"`      // Should the user be prompted for a reconfirmation?
+	ReasonRequired bool            `json:"ReasonRequired"` // Should the event's text be a non-blank reason?
+	MinReasonLen   int             `json:"MinReasonLen"`   // Minimum length of the reason, in characters, if required
+}
+
+// specificity ranks this policy by how narrowly it applies.
+func (p *ActionPolicy) specificity() int {
+	n := 0
+	if p.Role > 0 {
+		n += 2
+	}
+	if p.AccCtx > 0 {
+		n++
+	}
+	return n
+}
+
+// SetPolicy creates or replaces the policy of the given scope.
+func (_DocActions) SetPolicy(otx *sql.Tx, p *ActionPolicy) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
+	var v validator
+	if p == nil {
+		v.fail("policy", "should be given")
+	} else {
+		v.positive("Action", int64(p.Action))
+		if p.AccCtx < 0 || p.Role < 0 {
+			v.fail("policy", "access context and role should be non-negative")
+		}
+		if p.MinReasonLen < 0 || (p.MinReasonLen > 0 && !p.ReasonRequired) {
+			v.fail("MinReasonLen", "should be positive only when a reason is required")
+		}
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_action_policies(docaction_id, ac_id, role_id, reconfirm, reason_required, min_reason_len)
+		VALUES(?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE reconfirm = VALUES(reconfirm), reason_required = VALUES(reason_required),
+			min_reason_len = VALUES(min_reason_len)
+		`
+		_, err := tx.Exec(q, p.Action, p.AccCtx, p.Role, p.Reconfirm, p.ReasonRequired, p.MinReasonLen)
+		return err
+	})
+}
+
+// DeletePolicy removes the policy of the given scope, if any.
+func (_DocActions) DeletePolicy(otx *sql.Tx, id DocActionID, acID AccessContextID, rid RoleID) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
+	q := `
+	DELETE FROM wf_action_policies
+	WHERE docaction_id = ?
+	AND ac_id = ?
+	AND role_id = ?
+	`
+	_, err := stmts.exec(otx, q, id, acID, rid)
+	return err
+}
+
+// Policies answers all the policies of the given action, the broadest
+// first.
+func (_DocActions) Policies(id DocActionID) ([]*ActionPolicy, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "ID should be a positive integer")
+	}
+
+	q := `
+	SELECT docaction_id, ac_id, role_id, reconfirm, reason_required, min_reason_len
+	FROM wf_action_policies
+	WHERE docaction_id = ?
+	ORDER BY role_id > 0, ac_id > 0, role_id, ac_id
+	`
+	return actionPolicies(readDB().Query, q, id)
+}
+
+// actionPolicies reads the policies answered by the given query.
+func actionPolicies(query func(string, ...interface{}) (*sql.Rows, error), q string,
+	args ...interface{}) ([]*ActionPolicy, error) {
+	ary := []*ActionPolicy{}
+	err := scanEach(query, q, args, func(scan func(...interface{}) error) error {
+		var elem ActionPolicy
+		err := scan(&elem.Action, &elem.AccCtx, &elem.Role, &elem.Reconfirm, &elem.ReasonRequired, &elem.MinReasonLen)
+		if err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ary, nil
+}
+
+// Policy answers the policy in force when the given (singleton) group
+// performs the given action in the given access context.  User
+// interfaces should consult it to decide whether to prompt for a
+// reconfirmation or a reason.
+func (_DocActions) Policy(id DocActionID, acID AccessContextID, gid GroupID) (*ActionPolicy, error) {
+	if id <= 0 || acID <= 0 || gid <= 0 {
+		return nil, newError(CodeValidation, "all identifiers should be positive integers")
+	}
+
+	return effectivePolicy(readDB().Query, id, acID, gid)
+}
+
+// effectivePolicy combines the policies applicable to the given group
+// performing the given action in the given access context.
+func effectivePolicy(query func(string, ...interface{}) (*sql.Rows, error), id DocActionID,
+	acID AccessContextID, gid GroupID) (*ActionPolicy, error) {
+	q := `
+	SELECT docaction_id, ac_id, role_id, reconfirm, reason_required, min_reason_len
+	FROM wf_action_policies
+	WHERE docaction_id = ?
+	AND ac_id IN (0, ?)
+	AND (role_id = 0 OR role_id IN (
+		SELECT agrs.role_id
+		FROM wf_ac_group_roles agrs
+		JOIN wf_group_users gus ON gus.group_id = agrs.group_id
+		JOIN wf_group_users sgus ON sgus.user_id = gus.user_id
+		WHERE agrs.ac_id = ?
+		AND sgus.group_id = ?
+	))
+	`
+	ary, err := actionPolicies(query, q, id, acID, acID, gid)
+	if err != nil {
+		return nil, err
+	}
+
+	var res *ActionPolicy
+	for _, p := range ary {
+		switch {
+		case res == nil || p.specificity() > res.specificity():
+			cp := *p
+			res = &cp
+
+		case p.specificity() == res.specificity():
+			// Several roles of the user: the strictest combination.
+			res.Reconfirm = res.Reconfirm || p.Reconfirm
+			res.ReasonRequired = res.ReasonRequired || p.ReasonRequired
+			if p.MinReasonLen > res.MinReasonLen {
+				res.MinReasonLen = p.MinReasonLen
+			}
+		}
+	}
+	if res != nil {
+		return res, nil
+	}
+
+	da, err := DocActions.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &ActionPolicy{Action: id, Reconfirm: da.Reconfirm}, nil
+}
+
+// checkReason enforces the reason requirement of the policy in force
+// for the given event input, raised in the given access context.
+func checkReason(tx *sql.Tx, input *DocEventsNewInput, acID AccessContextID) error {
+	p, err := effectivePolicy(tx.Query, input.DocActionID, acID, input.GroupID)
+	if err != nil {
+		return err
+	}
+	if !p.ReasonRequired {
+		return nil
+	}
+
+	var v validator
+	text := strings.TrimSpace(input.Text)
+	switch {
+	case text == "":
+		v.fail("Text", "this action requires a reason")
+
+	case utf8.RuneCountInString(text) < p.MinReasonLen:
+		v.fail("Text", fmt.Sprintf("this action requires a reason of at least %d characters", p.MinReasonLen))
+	}
+	return v.result()
+}
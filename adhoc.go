@@ -0,0 +1,249 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// AdHocStepID is the type of unique identifiers of ad-hoc steps.
+type AdHocStepID int64
+
+// AdHocStepStatus enumerates the states of an ad-hoc step.
+type AdHocStepStatus uint8
+
+const (
+	// AdHocStepPending : awaiting the decision of the approver
+	AdHocStepPending AdHocStepStatus = iota + 1
+	// AdHocStepApproved : approved; the action can be performed
+	AdHocStepApproved
+	// AdHocStepRejected : rejected; the action remains blocked
+	AdHocStepRejected
+)
+
+// AdHocStep is an additional approval, required of a group before a
+// given action can be performed on a specific document in its current
+// state.  It is inserted at run time, for an exceptional case, without
+// altering the workflow.
+//
+// Steps apply only while the document stays in the state in which
+// they were added; they lapse once it moves on.
+type AdHocStep struct {
+	ID       AdHocStepID     `json:"ID"`       // Unique identifier of this step
+	DocType  DocTypeID       `json:"DocType"`  // Type of the document
+	DocID    DocumentID      `json:"DocID"`    // The document
+	State    DocStateID      `json:"DocState"` // State in which the step applies
+	Action   DocActionID     `json:"Action"`   // Action blocked until approval
+	Approver GroupID         `json:"Approver"` // Group whose approval is required
+	Status   AdHocStepStatus `json:"Status"`   // Current status of this step
+	User     UserID          `json:"User"`     // User who decided, if decided
+	Text     string          `json:"Text"`     // Comments of the decision, if any
+	Ctime    time.Time       `json:"Ctime"`    // Time when this step was added
+	Mtime    time.Time       `json:"Mtime"`    // Time of the most recent decision
+}
+
+// AddAdHocStep requires the approval of the given group before the
+// given action can be performed on the given document, in its current
+// state.  Events performing the action fail with
+// `ErrAdHocStepPending` until the group approves.
+//
+// Inserting steps is authorised as administration of workflows; see
+// `AsAdmin`.
+func (_Workflows) AddAdHocStep(otx *sql.Tx, dtype DocTypeID, did DocumentID, approver GroupID,
+	action DocActionID) (AdHocStepID, error) {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return 0, err
+	}
+
+	var v validator
+	v.positive("dtype", int64(dtype))
+	v.positive("docID", int64(did))
+	v.positive("approver", int64(approver))
+	v.positive("action", int64(action))
+	if err := v.result(); err != nil {
+		return 0, err
+	}
+
+	if _, err := Groups.Get(approver); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		doc, err := Documents.Get(tx, dtype, did)
+		if err != nil {
+			return err
+		}
+		ts, err := DocTypes._Transitions(dtype, doc.State.ID)
+		if err != nil {
+			return err
+		}
+		if _, ok := ts[action]; !ok {
+			return ErrWorkflowInvalidAction
+		}
+
+		q := `
+		INSERT INTO wf_adhoc_steps(doctype_id, doc_id, docstate_id, docaction_id, group_id, status, active, ctime, mtime)
+		VALUES(?, ?, ?, ?, ?, 'P', 1, NOW(), NOW())
+		`
+		res, err := tx.Exec(q, dtype, did, doc.State.ID, action, approver)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return AdHocStepID(id), nil
+}
+
+// DecideAdHocStep records the decision of the given user, who should
+// be a member of the step's approving group, on the given step.  A
+// decision can be revised while the step applies.
+func (_Workflows) DecideAdHocStep(otx *sql.Tx, id AdHocStepID, uid UserID, approve bool, text string) error {
+	if id <= 0 || uid <= 0 {
+		return newError(CodeValidation, "step ID and user ID must be positive integers")
+	}
+	text = strings.TrimSpace(text)
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		var gid GroupID
+		var active bool
+		q := `SELECT group_id, active FROM wf_adhoc_steps WHERE id = ? FOR UPDATE`
+		err := tx.QueryRow(q, id).Scan(&gid, &active)
+		if err != nil {
+			return notFound(err, ErrAdHocStepNotFound)
+		}
+		if !active {
+			return errorf(CodeConflict, "ad-hoc step %d has lapsed", id)
+		}
+		ok, err := Groups.HasUser(gid, uid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrPermissionDenied
+		}
+
+		status := "R"
+		if approve {
+			status = "A"
+		}
+		q = `
+		UPDATE wf_adhoc_steps
+		SET status = ?, user_id = ?, data = ?, mtime = NOW()
+		WHERE id = ?
+		`
+		_, err = tx.Exec(q, status, uid, text, id)
+		return err
+	})
+}
+
+// AdHocSteps answers the ad-hoc steps currently applying to the given
+// document, in the order in which they were added.
+func (_Workflows) AdHocSteps(dtype DocTypeID, did DocumentID) ([]*AdHocStep, error) {
+	if dtype <= 0 || did <= 0 {
+		return nil, newError(CodeValidation, "document type and document ID must be positive integers")
+	}
+
+	q := `
+	SELECT id, doctype_id, doc_id, docstate_id, docaction_id, group_id, status, user_id, data, ctime, mtime
+	FROM wf_adhoc_steps
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND active = 1
+	ORDER BY id
+	`
+	ary := make([]*AdHocStep, 0, 2)
+	err := scanEach(readDB().Query, q, []interface{}{dtype, did}, func(scan func(...interface{}) error) error {
+		var elem AdHocStep
+		var status string
+		var uid sql.NullInt64
+		var text sql.NullString
+		err := scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Approver,
+			&status, &uid, &text, &elem.Ctime, &elem.Mtime)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case "P":
+			elem.Status = AdHocStepPending
+
+		case "A":
+			elem.Status = AdHocStepApproved
+
+		case "R":
+			elem.Status = AdHocStepRejected
+
+		default:
+			return errorf(CodeInternal, "unknown ad-hoc step status : %s", status)
+		}
+		elem.User = UserID(uid.Int64)
+		elem.Text = text.String
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// checkAdHocSteps answers `ErrAdHocStepPending` if the given event
+// performs an action awaiting an ad-hoc approval.
+func checkAdHocSteps(otx *sql.Tx, event *DocEvent) error {
+	q := `
+	SELECT COUNT(*)
+	FROM wf_adhoc_steps
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND docstate_id = ?
+	AND docaction_id = ?
+	AND active = 1
+	AND status <> 'A'
+	`
+	row, err := stmts.queryRow(otx, q, event.DocType, event.DocID, event.State, event.Action)
+	if err != nil {
+		return err
+	}
+	var count int64
+	if err = row.Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAdHocStepPending
+	}
+	return nil
+}
+
+// lapseAdHocSteps retires the ad-hoc steps of the given document,
+// which is transitioning.
+func lapseAdHocSteps(otx *sql.Tx, dtype DocTypeID, did DocumentID) error {
+	q := `
+	UPDATE wf_adhoc_steps
+	SET active = 0
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND active = 1
+	`
+	_, err := stmts.exec(otx, q, dtype, did)
+	return err
+}
@@ -0,0 +1,221 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// AdminArea enumerates the areas of master data that are administered
+// separately.
+type AdminArea uint8
+
+// The following are the defined areas of administration.
+const (
+	// AdminDocTypes : document types, states, actions and transitions
+	AdminDocTypes AdminArea = iota + 1
+	// AdminWorkflows : workflows, nodes, message templates and webhooks
+	AdminWorkflows
+	// AdminRoles : roles and their permissions
+	AdminRoles
+	// AdminGroups : groups and their members
+	AdminGroups
+	// AdminAccessContexts : access contexts, their groups and roles
+	AdminAccessContexts
+	// AdminSecurity : administration roles and their assignment
+	AdminSecurity
+	// AdminSettings : settings and feature flags
+	AdminSettings
+)
+
+// actors holds the acting users of transactions begun by `AsAdmin`.
+var actorsMu sync.RWMutex
+var actors = map[*sql.Tx]UserID{}
+
+// AsAdmin runs the given function in a new transaction, on behalf of
+// the given user.  Mutating master-data operations performed in that
+// transaction are authorised against the user's administration roles,
+// and fail with `ErrPermissionDenied` unless one of those roles covers
+// the operation's area.  The transaction is committed if the function
+// succeeds, and rolled back otherwise.
+//
+// Operations performed in other transactions, or without one, are not
+// checked.  Applications backing a self-service administration UI
+// should route all its operations through `AsAdmin`.  Bootstrapping
+// the first administrator, naturally, needs an unchecked transaction.
+func AsAdmin(uid UserID, fn func(tx *sql.Tx) error) error {
+	if uid <= 0 {
+		return newError(CodeValidation, "user ID should be a positive integer")
+	}
+
+	return withTx(nil, func(tx *sql.Tx) error {
+		actorsMu.Lock()
+		actors[tx] = uid
+		actorsMu.Unlock()
+		defer func() {
+			actorsMu.Lock()
+			delete(actors, tx)
+			actorsMu.Unlock()
+		}()
+
+		return fn(tx)
+	})
+}
+
+// qAdminAllowed counts the administration roles of a user that cover
+// an area.
+const qAdminAllowed = `
+SELECT COUNT(*)
+FROM wf_admin_group_roles agr
+JOIN wf_admin_role_areas ara ON ara.role_id = agr.role_id
+JOIN wf_group_users gu ON gu.group_id = agr.group_id
+WHERE gu.user_id = ?
+AND ara.area = ?
+`
+
+// checkAdmin answers `ErrPermissionDenied` if the given transaction
+// has an acting user, who may not administer the given area.
+func checkAdmin(otx *sql.Tx, area AdminArea) error {
+	if otx == nil {
+		return nil
+	}
+	actorsMu.RLock()
+	uid, ok := actors[otx]
+	actorsMu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	var n int64
+	if err := otx.QueryRow(qAdminAllowed, uid, area).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// Unexported type, only for convenience methods.
+type _Admins struct{}
+
+// Admins provides a resource-like interface to administration roles.
+//
+// An administration role is an ordinary role, to which areas of
+// administration are added.  Such roles are assigned to groups
+// globally, not in access contexts.  A user may administer the areas
+// of all the roles assigned to all of the user's groups.
+var Admins _Admins
+
+// AddArea adds the given area of administration to the given role.
+func (_Admins) AddArea(otx *sql.Tx, rid RoleID, area AdminArea) error {
+	if err := checkAdmin(otx, AdminSecurity); err != nil {
+		return err
+	}
+	if rid <= 0 || area < AdminDocTypes || area > AdminSettings {
+		return newError(CodeValidation, "role ID should be a positive integer, and area should be known")
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT IGNORE INTO wf_admin_role_areas(role_id, area) VALUES(?, ?)", rid, area)
+		return err
+	})
+}
+
+// RemoveArea removes the given area of administration from the given
+// role.
+func (_Admins) RemoveArea(otx *sql.Tx, rid RoleID, area AdminArea) error {
+	if err := checkAdmin(otx, AdminSecurity); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_admin_role_areas WHERE role_id = ? AND area = ?", rid, area)
+		return err
+	})
+}
+
+// Areas answers the areas of administration of the given role.
+func (_Admins) Areas(rid RoleID) ([]AdminArea, error) {
+	if rid <= 0 {
+		return nil, newError(CodeValidation, "role ID should be a positive integer")
+	}
+
+	rows, err := readDB().Query("SELECT area FROM wf_admin_role_areas WHERE role_id = ? ORDER BY area", rid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := []AdminArea{}
+	for rows.Next() {
+		var area AdminArea
+		if err = rows.Scan(&area); err != nil {
+			return nil, err
+		}
+		ary = append(ary, area)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// AssignRole assigns the given administration role to the given
+// group.
+func (_Admins) AssignRole(otx *sql.Tx, gid GroupID, rid RoleID) error {
+	if err := checkAdmin(otx, AdminSecurity); err != nil {
+		return err
+	}
+	if gid <= 0 || rid <= 0 {
+		return newError(CodeValidation, "all identifiers should be positive integers")
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if err := checkHoldsPermissions(tx, gid); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT IGNORE INTO wf_admin_group_roles(group_id, role_id) VALUES(?, ?)", gid, rid)
+		return err
+	})
+}
+
+// UnassignRole withdraws the given administration role from the given
+// group.
+func (_Admins) UnassignRole(otx *sql.Tx, gid GroupID, rid RoleID) error {
+	if err := checkAdmin(otx, AdminSecurity); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_admin_group_roles WHERE group_id = ? AND role_id = ?", gid, rid)
+		return err
+	})
+}
+
+// Allowed answers if the given user may administer the given area.
+func (_Admins) Allowed(uid UserID, area AdminArea) (bool, error) {
+	if uid <= 0 {
+		return false, newError(CodeValidation, "user ID should be a positive integer")
+	}
+
+	var n int64
+	if err := readDB().QueryRow(qAdminAllowed, uid, area).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,405 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GroupActionCount is the number of times that a group performed an
+// action.
+type GroupActionCount struct {
+	Group  Group     `json:"Group"`  // Group that performed the action
+	Action DocAction `json:"Action"` // Action performed
+	Count  int64     `json:"Count"`  // Number of events
+}
+
+// ContextSummary summarises the operations in an access context, over
+// a window of time ending when it was computed.
+type ContextSummary struct {
+	AccCtx       AccessContextID     `json:"AccCtx"`       // Access context summarised
+	From         time.Time           `json:"From"`         // Beginning of the window
+	To           time.Time           `json:"To"`           // End of the window
+	Opened       int64               `json:"Opened"`       // Documents created in the window
+	Closed       int64               `json:"Closed"`       // Documents that completed their workflows in the window
+	AvgCycleTime time.Duration       `json:"AvgCycleTime"` // Average time from creation to completion of those closed
+	SLABreaches  int64               `json:"SLABreaches"`  // Breaches of node SLAs recorded in the window
+	Actions      []*GroupActionCount `json:"Actions"`      // Actions performed in the window, by group; by count, descending
+}
+
+// Unexported type, only for convenience methods.
+type _Analytics struct{}
+
+// Analytics provides operational summaries of workflows, computed from
+// the documents and their event history.
+var Analytics _Analytics
+
+// ContextSummary summarises the operations in the given access context
+// over the given window, ending now.  Only root documents are counted.
+func (_Analytics) ContextSummary(acID AccessContextID, window time.Duration) (*ContextSummary, error) {
+	if acID <= 0 {
+		return nil, newError(CodeValidation, "access context ID should be a positive integer")
+	}
+	if window <= 0 {
+		return nil, newError(CodeValidation, "window should be positive")
+	}
+
+	dts, err := DocTypes.List(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	to := time.Now().Truncate(time.Second)
+	s := &ContextSummary{AccCtx: acID, From: to.Add(-window), To: to, Actions: []*GroupActionCount{}}
+	actions := map[[2]int64]*GroupActionCount{}
+	var cycleSecs float64
+	for _, dt := range dts {
+		var cycles float64
+		if err = summariseDocType(s, dt.ID, &cycles, actions); err != nil {
+			return nil, err
+		}
+		cycleSecs += cycles
+	}
+
+	q := `
+	SELECT COUNT(DISTINCT timer_id)
+	FROM wf_sla_breaches
+	WHERE ac_id = ?
+	AND breached_at >= ?
+	AND breached_at < ?
+	`
+	if err = readDB().QueryRow(q, acID, s.From, s.To).Scan(&s.SLABreaches); err != nil {
+		return nil, err
+	}
+
+	if s.Closed > 0 {
+		s.AvgCycleTime = time.Duration(cycleSecs/float64(s.Closed)) * time.Second
+	}
+
+	for _, gac := range actions {
+		s.Actions = append(s.Actions, gac)
+	}
+	sort.Slice(s.Actions, func(i, j int) bool {
+		a, b := s.Actions[i], s.Actions[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Group.ID != b.Group.ID {
+			return a.Group.ID < b.Group.ID
+		}
+		return a.Action.ID < b.Action.ID
+	})
+
+	return s, nil
+}
+
+// summariseDocType adds the figures of documents of the given type to
+// the given summary.  The total cycle time, in seconds, of the
+// documents closed is added to `cycles`.
+func summariseDocType(s *ContextSummary, dtype DocTypeID, cycles *float64, actions map[[2]int64]*GroupActionCount) error {
+	tbl := DocTypes.docStorName(dtype)
+	rdb := readDB()
+
+	q := `
+	SELECT COUNT(*)
+	FROM ` + tbl + `
+	WHERE ac_id = ?
+	AND path = ''
+	AND ctime >= ?
+	AND ctime < ?
+	`
+	var n int64
+	if err := rdb.QueryRow(q, s.AccCtx, s.From, s.To).Scan(&n); err != nil {
+		return err
+	}
+	s.Opened += n
+
+	q = `
+	SELECT COUNT(*), COALESCE(SUM(TIMESTAMPDIFF(SECOND, docs.ctime, COALESCE(dea.ctime, de.ctime))), 0)
+	FROM wf_docevent_application dea
+	JOIN wf_docevents de ON de.id = dea.docevent_id
+	JOIN ` + tbl + ` docs ON docs.id = dea.doc_id
+	JOIN wf_workflow_nodes wn ON wn.doctype_id = dea.doctype_id AND wn.docstate_id = dea.to_state_id
+	WHERE dea.doctype_id = ?
+	AND docs.ac_id = ?
+	AND docs.path = ''
+	AND wn.type = 'end'
+	AND COALESCE(dea.ctime, de.ctime) >= ?
+	AND COALESCE(dea.ctime, de.ctime) < ?
+	`
+	var secs float64
+	if err := rdb.QueryRow(q, dtype, s.AccCtx, s.From, s.To).Scan(&n, &secs); err != nil {
+		return err
+	}
+	s.Closed += n
+	*cycles += secs
+
+	q = `
+	SELECT de.group_id, gm.name, gm.group_type, de.docaction_id, dam.name, dam.reconfirm, COUNT(*)
+	FROM wf_docevents de
+	JOIN ` + tbl + ` docs ON docs.id = de.doc_id
+	JOIN wf_groups_master gm ON gm.id = de.group_id
+	JOIN wf_docactions_master dam ON dam.id = de.docaction_id
+	WHERE de.doctype_id = ?
+	AND docs.ac_id = ?
+	AND docs.path = ''
+	AND de.status = 'A'
+	AND de.ctime >= ?
+	AND de.ctime < ?
+	GROUP BY de.group_id, gm.name, gm.group_type, de.docaction_id, dam.name, dam.reconfirm
+	`
+	return scanEach(rdb.Query, q, []interface{}{dtype, s.AccCtx, s.From, s.To}, func(scan func(...interface{}) error) error {
+		var elem GroupActionCount
+		err := scan(&elem.Group.ID, &elem.Group.Name, &elem.Group.GroupType, &elem.Action.ID, &elem.Action.Name,
+			&elem.Action.Reconfirm, &elem.Count)
+		if err != nil {
+			return err
+		}
+		k := [2]int64{int64(elem.Group.ID), int64(elem.Action.ID)}
+		if gac, ok := actions[k]; ok {
+			gac.Count += elem.Count
+			return nil
+		}
+		actions[k] = &elem
+		return nil
+	})
+}
+
+// WriteCSV writes this summary to the given writer as CSV, one figure
+// per row.  Action counts follow the totals, one row per group and
+// action.
+func (s *ContextSummary) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	recs := [][]string{
+		{"Metric", "Group", "Action", "Value"},
+		{"AccCtx", "", "", exportID(int64(s.AccCtx))},
+		{"From", "", "", exportTime(s.From)},
+		{"To", "", "", exportTime(s.To)},
+		{"Opened", "", "", exportID(s.Opened)},
+		{"Closed", "", "", exportID(s.Closed)},
+		{"AvgCycleSecs", "", "", strconv.FormatInt(int64(s.AvgCycleTime/time.Second), 10)},
+		{"SLABreaches", "", "", exportID(s.SLABreaches)},
+	}
+	for _, gac := range s.Actions {
+		recs = append(recs, []string{"Actions", gac.Group.Name, gac.Action.Name, exportID(gac.Count)})
+	}
+	if err := cw.WriteAll(recs); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// SLABreach records a document that stayed at a node beyond the node's
+// service level.  A breach is recorded once for each group that was
+// notified of the document's arrival at the node.
+type SLABreach struct {
+	ID       int64           `json:"ID"`              // Unique identifier of this record
+	Node     NodeID          `json:"Node"`            // Node whose service level was breached
+	DocType  DocTypeID       `json:"DocType"`         // Type of the document
+	DocID    DocumentID      `json:"DocID"`           // The document
+	State    DocStateID      `json:"DocState"`        // State of the node
+	AccCtx   AccessContextID `json:"AccCtx"`          // Access context of the document
+	Group    GroupID         `json:"Group,omitempty"` // Group responsible, if any was notified
+	SLA      time.Duration   `json:"SLA"`             // Service level in force
+	Duration time.Duration   `json:"Duration"`        // Time spent at the node; so far, if still there
+	Entered  time.Time       `json:"Entered"`         // Time when the document arrived at the node
+	Breached time.Time       `json:"Breached"`        // Time when the breach was recorded
+	Left     *time.Time      `json:"Left,omitempty"`  // Time when the document left the node, if it has
+}
+
+// SLABreachesInput specifies a set of filtering criteria on SLA
+// breaches.  Zero values match all.
+type SLABreachesInput struct {
+	DocTypeID                 // Breaches of documents of this type
+	AccessContextID           // Breaches of documents in this access context
+	GroupID                   // Breaches for which this group is responsible
+	DocStateID                // Breaches in this state
+	From            time.Time // Breaches recorded at or after this time
+	To              time.Time // Breaches recorded before this time
+}
+
+// where answers the conditions and arguments of this input.
+func (input *SLABreachesInput) where() (string, []interface{}) {
+	where := []string{"1 = 1"}
+	args := []interface{}{}
+	if input == nil {
+		return where[0], args
+	}
+	if input.DocTypeID > 0 {
+		where = append(where, "slab.doctype_id = ?")
+		args = append(args, input.DocTypeID)
+	}
+	if input.AccessContextID > 0 {
+		where = append(where, "slab.ac_id = ?")
+		args = append(args, input.AccessContextID)
+	}
+	if input.GroupID > 0 {
+		where = append(where, "slab.group_id = ?")
+		args = append(args, input.GroupID)
+	}
+	if input.DocStateID > 0 {
+		where = append(where, "slab.docstate_id = ?")
+		args = append(args, input.DocStateID)
+	}
+	if !input.From.IsZero() {
+		where = append(where, "slab.breached_at >= ?")
+		args = append(args, input.From)
+	}
+	if !input.To.IsZero() {
+		where = append(where, "slab.breached_at < ?")
+		args = append(args, input.To)
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// SLABreaches answers the SLA breaches matching the given criteria,
+// newest first.
+func (_Analytics) SLABreaches(input *SLABreachesInput, offset, limit int64) ([]*SLABreach, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	where, args := input.where()
+	q := `
+	SELECT slab.id, slab.node_id, slab.doctype_id, slab.doc_id, slab.docstate_id, slab.ac_id, slab.group_id,
+		slab.sla_secs, TIMESTAMPDIFF(SECOND, slab.entered_at, COALESCE(slab.left_at, NOW())),
+		slab.entered_at, slab.breached_at, slab.left_at
+	FROM wf_sla_breaches slab
+	WHERE ` + where + `
+	ORDER BY slab.id DESC
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+	ary := make([]*SLABreach, 0, 10)
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
+		var elem SLABreach
+		var gid sql.NullInt64
+		var sla, dur int64
+		var left sql.NullTime
+		err := scan(&elem.ID, &elem.Node, &elem.DocType, &elem.DocID, &elem.State, &elem.AccCtx, &gid,
+			&sla, &dur, &elem.Entered, &elem.Breached, &left)
+		if err != nil {
+			return err
+		}
+		elem.Group = GroupID(gid.Int64)
+		elem.SLA = time.Duration(sla) * time.Second
+		elem.Duration = time.Duration(dur) * time.Second
+		if left.Valid {
+			elem.Left = &left.Time
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// SLAAggregate enumerates the dimensions along which SLA breaches can
+// be aggregated.
+type SLAAggregate uint8
+
+const (
+	// SLAByGroup : by the group responsible
+	SLAByGroup SLAAggregate = iota + 1
+	// SLAByState : by the state in which the document waited
+	SLAByState
+)
+
+// SLABreachCount aggregates the SLA breaches of a group or a state.
+type SLABreachCount struct {
+	Group       *Group        `json:"Group,omitempty"` // Group responsible, when aggregating by group
+	State       *DocState     `json:"State,omitempty"` // State, when aggregating by state
+	Breaches    int64         `json:"Breaches"`        // Number of breaches
+	AvgDuration time.Duration `json:"AvgDuration"`     // Average time spent at the node
+	MaxDuration time.Duration `json:"MaxDuration"`     // Longest time spent at the node
+}
+
+// SLABreachCounts aggregates the SLA breaches matching the given
+// criteria along the given dimension, in the descending order of the
+// number of breaches.  When aggregating by state, a breach notified to
+// several groups is counted once.  Breaches without a responsible
+// group are not counted by group.
+func (_Analytics) SLABreachCounts(input *SLABreachesInput, by SLAAggregate) ([]*SLABreachCount, error) {
+	where, args := input.where()
+	var q string
+	switch by {
+	case SLAByGroup:
+		q = `
+		SELECT gm.id, gm.name, gm.group_type, COUNT(*),
+			AVG(TIMESTAMPDIFF(SECOND, slab.entered_at, COALESCE(slab.left_at, NOW()))),
+			MAX(TIMESTAMPDIFF(SECOND, slab.entered_at, COALESCE(slab.left_at, NOW())))
+		FROM wf_sla_breaches slab
+		JOIN wf_groups_master gm ON gm.id = slab.group_id
+		WHERE ` + where + `
+		GROUP BY gm.id, gm.name, gm.group_type
+		ORDER BY COUNT(*) DESC, gm.id
+		`
+
+	case SLAByState:
+		q = `
+		SELECT dsm.id, dsm.name, COUNT(DISTINCT slab.timer_id),
+			AVG(TIMESTAMPDIFF(SECOND, slab.entered_at, COALESCE(slab.left_at, NOW()))),
+			MAX(TIMESTAMPDIFF(SECOND, slab.entered_at, COALESCE(slab.left_at, NOW())))
+		FROM wf_sla_breaches slab
+		JOIN wf_docstates_master dsm ON dsm.id = slab.docstate_id
+		WHERE ` + where + `
+		GROUP BY dsm.id, dsm.name
+		ORDER BY COUNT(DISTINCT slab.timer_id) DESC, dsm.id
+		`
+
+	default:
+		return nil, errorf(CodeValidation, "unknown SLA aggregate : %d", by)
+	}
+
+	ary := make([]*SLABreachCount, 0, 10)
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
+		var elem SLABreachCount
+		var avg float64
+		var max int64
+		var err error
+		if by == SLAByGroup {
+			elem.Group = &Group{}
+			err = scan(&elem.Group.ID, &elem.Group.Name, &elem.Group.GroupType, &elem.Breaches, &avg, &max)
+		} else {
+			elem.State = &DocState{}
+			err = scan(&elem.State.ID, &elem.State.Name, &elem.Breaches, &avg, &max)
+		}
+		if err != nil {
+			return err
+		}
+		elem.AvgDuration = time.Duration(avg) * time.Second
+		elem.MaxDuration = time.Duration(max) * time.Second
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
@@ -0,0 +1,518 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"crypto"
+	"database/sql"
+	"io"
+	"time"
+)
+
+// The resource accessors of `flow` -- `Documents`, `Workflows`,
+// `Mailboxes`, etc. -- are singletons of unexported types.  The
+// interfaces below describe them, so that applications can accept the
+// accessors as dependencies, and inject fakes in their own tests:
+//
+//     type Approvals struct {
+//         Docs flow.DocumentsAPI
+//     }
+//
+//     a := &Approvals{Docs: flow.Documents}
+//
+// Each interface lists all the exported methods of its accessor.
+
+// AccessContextsAPI is the interface of `AccessContexts`.
+type AccessContextsAPI interface {
+	AddGroup(otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error
+	AddGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error
+	ChangeReporting(otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error
+	Count(input *AccessContextsListInput) (int64, error)
+	DeleteGroup(otx *sql.Tx, id AccessContextID, gid GroupID) error
+	Get(id AccessContextID) (*AccessContext, error)
+	GroupPermissions(id AccessContextID, gid GroupID) (map[DocTypeID][]DocAction, error)
+	GroupPermissionsByDocType(id AccessContextID, dtype DocTypeID, gid GroupID) ([]DocAction, error)
+	GroupReportees(id AccessContextID, uid GroupID) ([]GroupID, error)
+	GroupReportsTo(id AccessContextID, uid GroupID) (GroupID, error)
+	GroupRoles(id AccessContextID, gids []GroupID, offset, limit int64) (map[GroupID]*AcGroupRoles, error)
+	Groups(id AccessContextID, offset, limit int64) (map[GroupID]*AcGroup, error)
+	IncludesGroup(id AccessContextID, gid GroupID) (bool, error)
+	IncludesUser(id AccessContextID, uid UserID) (bool, error)
+	List(prefix string, offset, limit int64) ([]*AccessContext, error)
+	ListByGroup(gid GroupID, offset, limit int64) ([]*AccessContext, error)
+	ListByLevel(level int, offset, limit int64) ([]*AccessContext, error)
+	ListByUser(uid UserID, offset, limit int64) ([]*AccessContext, error)
+	ListChildrenOf(parent string, offset, limit int64) ([]*AccessContext, error)
+	Match(pattern string, offset, limit int64) ([]*AccessContext, error)
+	New(otx *sql.Tx, name string) (AccessContextID, error)
+	OverrideGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error
+	RemoveGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error
+	Rename(otx *sql.Tx, id AccessContextID, name string) error
+	RoleLimit(id AccessContextID) (int, error)
+	Search(input *AccessContextsListInput, offset, limit int64) ([]*AccessContextSummary, error)
+	SetActive(otx *sql.Tx, id AccessContextID, active bool) error
+	SetRoleLimit(otx *sql.Tx, id AccessContextID, max int) error
+	UserHasPermission(id AccessContextID, uid UserID, dtype DocTypeID, action DocActionID) (bool, error)
+	UserPermissions(id AccessContextID, uid UserID) (map[DocTypeID][]DocAction, error)
+	UserPermissionsByDocType(id AccessContextID, dtype DocTypeID, uid UserID) ([]DocAction, error)
+}
+
+// AdminsAPI is the interface of `Admins`.
+type AdminsAPI interface {
+	AddArea(otx *sql.Tx, rid RoleID, area AdminArea) error
+	Allowed(uid UserID, area AdminArea) (bool, error)
+	Areas(rid RoleID) ([]AdminArea, error)
+	AssignRole(otx *sql.Tx, gid GroupID, rid RoleID) error
+	FindStuckDocuments(dtype DocTypeID) ([]*StuckDocument, error)
+	ForceSetState(otx *sql.Tx, dtype DocTypeID, did DocumentID, state DocStateID,
+		acid AccessContextID, reason string) error
+	ForcedStates(dtype DocTypeID, did DocumentID) ([]*ForcedState, error)
+	Integrity(ctx context.Context, fix bool) (*IntegrityReport, error)
+	RemoveArea(otx *sql.Tx, rid RoleID, area AdminArea) error
+	UnassignRole(otx *sql.Tx, gid GroupID, rid RoleID) error
+}
+
+// AnalyticsAPI is the interface of `Analytics`.
+type AnalyticsAPI interface {
+	ContextSummary(acID AccessContextID, window time.Duration) (*ContextSummary, error)
+	SLABreachCounts(input *SLABreachesInput, by SLAAggregate) ([]*SLABreachCount, error)
+	SLABreaches(input *SLABreachesInput, offset, limit int64) ([]*SLABreach, error)
+}
+
+// DirectorySyncAPI is the interface of `DirectorySync`.
+type DirectorySyncAPI interface {
+	Apply(otx *sql.Tx, diff *DirectorySyncDiff) error
+	Plan(ctx context.Context, p DirectoryProvider) (*DirectorySyncDiff, error)
+	Run(ctx context.Context, p DirectoryProvider, interval time.Duration, review DirectorySyncReviewer) error
+}
+
+// DocActionsAPI is the interface of `DocActions`.
+type DocActionsAPI interface {
+	Delete(otx *sql.Tx, id DocActionID) error
+	DeletePolicy(otx *sql.Tx, id DocActionID, acID AccessContextID, rid RoleID) error
+	Get(id DocActionID, opts ...ReadOption) (*DocAction, error)
+	GetByName(name string, opts ...ReadOption) (*DocAction, error)
+	List(offset, limit int64, opts ...ReadOption) ([]*DocAction, error)
+	New(otx *sql.Tx, name string, reconfirm bool) (DocActionID, error)
+	Policies(id DocActionID) ([]*ActionPolicy, error)
+	Policy(id DocActionID, acID AccessContextID, gid GroupID) (*ActionPolicy, error)
+	Rename(otx *sql.Tx, id DocActionID, name string) error
+	SetActive(otx *sql.Tx, id DocActionID, active bool) error
+	SetDisplay(otx *sql.Tx, id DocActionID, d *Display) error
+	SetPolicy(otx *sql.Tx, p *ActionPolicy) error
+}
+
+// DocEventsAPI is the interface of `DocEvents`.
+type DocEventsAPI interface {
+	Applications(dtype DocTypeID, did DocumentID) ([]*DocEventApplication, error)
+	ApplicationsByEvent(eid DocEventID) ([]*DocEventApplication, error)
+	Export(w io.Writer, format ExportFormat, input *DocEventsListInput) error
+	ExportAudit(w io.Writer, format ExportFormat, dtype DocTypeID, did DocumentID) error
+	ExportAuditCSV(w io.Writer, dtype DocTypeID, did DocumentID) error
+	ExportCSV(w io.Writer, input *DocEventsListInput) error
+	Get(eid DocEventID) (*DocEvent, error)
+	Import(otx *sql.Tx, input *DocEventsImportInput) (DocEventID, error)
+	List(input *DocEventsListInput, offset, limit int64) ([]*DocEvent, error)
+	New(otx *sql.Tx, input *DocEventsNewInput) (DocEventID, error)
+	VerifySignature(eid DocEventID, pubkey crypto.PublicKey) error
+}
+
+// DocStatesAPI is the interface of `DocStates`.
+type DocStatesAPI interface {
+	Get(id DocStateID, opts ...ReadOption) (*DocState, error)
+	GetByName(name string, opts ...ReadOption) (*DocState, error)
+	List(offset, limit int64, opts ...ReadOption) ([]*DocState, error)
+	New(otx *sql.Tx, name string) (DocStateID, error)
+	Rename(otx *sql.Tx, id DocStateID, name string) error
+	SetDisplay(otx *sql.Tx, id DocStateID, d *Display) error
+}
+
+// DocTypesAPI is the interface of `DocTypes`.
+type DocTypesAPI interface {
+	AddDerivedField(otx *sql.Tx, f *DerivedField) error
+	AddTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID,
+		action DocActionID, toState DocStateID) error
+	DataConfig(dtype DocTypeID) (*DataConfig, error)
+	DerivedFields(dtype DocTypeID) ([]*DerivedField, error)
+	Get(id DocTypeID) (*DocType, error)
+	GetByName(name string) (*DocType, error)
+	List(offset, limit int64) ([]*DocType, error)
+	New(otx *sql.Tx, name string) (DocTypeID, error)
+	RecomputeDerivedFields(ctx context.Context, dtype DocTypeID) (int64, error)
+	RemoveDerivedField(otx *sql.Tx, dtype DocTypeID, name string) error
+	RemoveTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID, action DocActionID) error
+	Rename(otx *sql.Tx, id DocTypeID, name string) error
+	SetDataConfig(otx *sql.Tx, dtype DocTypeID, cfg *DataConfig) error
+	SetDeriveFunc(dtype DocTypeID, name string, fn DeriveFunc) error
+	Transitions(dtype DocTypeID, from DocStateID) (map[DocStateID]*TransitionMap, error)
+}
+
+// DocumentsAPI is the interface of `Documents`.
+type DocumentsAPI interface {
+	AddBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, blob *Blob) error
+	AddTags(otx *sql.Tx, dtype DocTypeID, id DocumentID, tags ...string) error
+	Blobs(dtype DocTypeID, id DocumentID) ([]*Blob, error)
+	Branches(dtype DocTypeID, did DocumentID) ([]*Branch, error)
+	ChangesSince(dtype DocTypeID, id DocumentID, cursor int64) ([]*DocumentChange, error)
+	ChildCounts(dtype DocTypeID, id DocumentID) (map[DocTypeID]int64, error)
+	Children(dtype DocTypeID, id DocumentID, offset, limit int64, opts ...ReadOption) ([]*Document, error)
+	ChildrenIDs(dtype DocTypeID, id DocumentID) ([]struct {
+		DocTypeID
+		DocumentID
+	}, error)
+	CreateShareToken(otx *sql.Tx, dtype DocTypeID, id DocumentID, ttl time.Duration,
+		scope ShareScope) (string, error)
+	DeleteBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, sha1 string) error
+	Export(w io.Writer, format ExportFormat, input *DocumentsListInput, opts ...ReadOption) error
+	ExportCSV(w io.Writer, input *DocumentsListInput, opts ...ReadOption) error
+	Get(otx *sql.Tx, dtype DocTypeID, id DocumentID, opts ...ReadOption) (*Document, error)
+	GetBlob(dtype DocTypeID, id DocumentID, blob *Blob) error
+	GetMany(otx *sql.Tx, dtype DocTypeID, ids []DocumentID, opts ...ReadOption) ([]*Document, error)
+	GetManyRefs(otx *sql.Tx, refs []DocumentRef, opts ...ReadOption) ([]*Document, error)
+	GetParent(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Document, error)
+	GetShared(token, from string) (*Document, error)
+	GetSharedBlob(token, from string, blob *Blob) error
+	List(input *DocumentsListInput, offset, limit int64, opts ...ReadOption) ([]*Document, error)
+	New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error)
+	OnTerminal(name string, fn TerminalHook) error
+	OpenData(dtype DocTypeID, id DocumentID) (io.ReadCloser, error)
+	RegisterRenderer(dtype DocTypeID, fn Renderer)
+	RemoveTag(otx *sql.Tx, dtype DocTypeID, id DocumentID, tag string) error
+	Render(dtype DocTypeID, id DocumentID, format RenderFormat) ([]byte, error)
+	RenderBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, format RenderFormat,
+		name string) (*Blob, error)
+	ReturnTargets(dtype DocTypeID, did DocumentID) ([]*DocState, error)
+	RevokeShareToken(otx *sql.Tx, id ShareTokenID) error
+	SetChangeLog(dtype DocTypeID, enabled bool)
+	SetData(otx *sql.Tx, dtype DocTypeID, id DocumentID, data string) error
+	SetDataFrom(otx *sql.Tx, dtype DocTypeID, id DocumentID, r io.Reader) error
+	SetDuplicatePolicy(dtype DocTypeID, fn DuplicateKeyFunc)
+	SetTitle(otx *sql.Tx, dtype DocTypeID, id DocumentID, title string) error
+	ShareAccesses(dtype DocTypeID, id DocumentID, offset, limit int64) ([]*ShareAccess, error)
+	ShareTokens(dtype DocTypeID, id DocumentID) ([]*ShareToken, error)
+	Tags(dtype DocTypeID, id DocumentID) ([]string, error)
+	Vote(otx *sql.Tx, input *DocumentsVoteInput) (*VoteTally, error)
+	Votes(dtype DocTypeID, did DocumentID) (*VoteTally, error)
+}
+
+// EmailDeliveriesAPI is the interface of `EmailDeliveries`.
+type EmailDeliveriesAPI interface {
+	List(mid MessageID) ([]*EmailDelivery, error)
+	Retry(otx *sql.Tx, id EmailDeliveryID) error
+	SetEnabled(on bool)
+}
+
+// EventStreamAPI is the interface of `EventStream`.
+type EventStreamAPI interface {
+	SetPublisher(p EventPublisher)
+}
+
+// ExternalTasksAPI is the interface of `ExternalTasks`.
+type ExternalTasksAPI interface {
+	Complete(otx *sql.Tx, id ExternalTaskID, action DocActionID) error
+	Get(id ExternalTaskID) (*ExternalTask, error)
+	List(input *ExternalTasksListInput, offset, limit int64) ([]*ExternalTask, error)
+}
+
+// GroupsAPI is the interface of `Groups`.
+type GroupsAPI interface {
+	AddUser(otx *sql.Tx, gid GroupID, uid UserID) error
+	Delete(otx *sql.Tx, id GroupID) error
+	DynamicRole(gid GroupID) (RoleID, AccessContextID, error)
+	Get(id GroupID) (*Group, error)
+	HasUser(gid GroupID, uid UserID) (bool, error)
+	List(offset, limit int64) ([]*Group, error)
+	New(otx *sql.Tx, name string, gtype GroupType) (GroupID, error)
+	NewDynamic(otx *sql.Tx, name string, rid RoleID, acID AccessContextID) (GroupID, error)
+	NewSingleton(otx *sql.Tx, uid UserID) (GroupID, error)
+	RemoveUser(otx *sql.Tx, gid GroupID, uid UserID) error
+	Rename(otx *sql.Tx, id GroupID, name string) error
+	SingletonUser(gid GroupID) (*User, error)
+	Users(gid GroupID) ([]*User, error)
+}
+
+// I18nAPI is the interface of `I18n`.
+type I18nAPI interface {
+	Delete(otx *sql.Tx, entity I18nEntity, id int64, locale string) error
+	List(entity I18nEntity, id int64) ([]*I18nLabel, error)
+	Set(otx *sql.Tx, entity I18nEntity, id int64, locale, label string) error
+}
+
+// LocksAPI is the interface of `Locks`.
+type LocksAPI interface {
+	Acquire(name string, ttl time.Duration) (*Lock, error)
+}
+
+// MailboxesAPI is the interface of `Mailboxes`.
+type MailboxesAPI interface {
+	CountByGroup(gid GroupID, unread bool) (int64, error)
+	CountByUser(uid UserID, unread bool) (int64, error)
+	CountForUserAllGroups(uid UserID, unread bool) (int64, error)
+	CountUnreadByGroupUser(gid GroupID, uid UserID) (int64, error)
+	CountsByUser(uid UserID, perDoc bool) ([]*UnreadCount, error)
+	GetMessage(msgID MessageID) (*Notification, error)
+	ListByDocument(gid GroupID, dtype DocTypeID, docID DocumentID) (*MessageThread, error)
+	ListByGroup(gid GroupID, input *MailboxesListInput, offset, limit int64) ([]*Notification, error)
+	ListByUser(uid UserID, input *MailboxesListInput, offset, limit int64) ([]*Notification, error)
+	ListForUserAllGroups(uid UserID, input *MailboxesListInput, offset, limit int64) ([]*Notification, error)
+	MarkAllRead(otx *sql.Tx, gid GroupID, before time.Time) error
+	ReadBy(gid GroupID, msgID MessageID) ([]*MessageRead, error)
+	ReassignMessage(otx *sql.Tx, fgid, tgid GroupID, msgID MessageID) error
+	SetReadByUser(otx *sql.Tx, gid GroupID, uid UserID, msgID MessageID, read bool) error
+	SetStatusBulk(otx *sql.Tx, gid GroupID, msgIDs []MessageID, status bool) error
+	SetStatusByGroup(otx *sql.Tx, gid GroupID, msgID MessageID, status bool) error
+	SetStatusByUser(otx *sql.Tx, uid UserID, msgID MessageID, status bool) error
+	Snooze(otx *sql.Tx, gid GroupID, msgID MessageID, until time.Time) error
+}
+
+// MentionsAPI is the interface of `Mentions`.
+type MentionsAPI interface {
+	CountByUser(uid UserID, unread bool) (int64, error)
+	ListByUser(uid UserID, offset, limit int64) ([]*Mention, error)
+}
+
+// NodesAPI is the interface of `Nodes`.
+type NodesAPI interface {
+	AddWebhook(otx *sql.Tx, id NodeID, endpoint, secret string, onEntry, onExit bool) (NodeWebhookID, error)
+	AutoAction(id NodeID) (*AutoAction, error)
+	Capacity(id NodeID) (*CapacityLimit, error)
+	Compensation(id NodeID) (*Compensation, error)
+	ExternalTask(id NodeID) (*ExternalTaskPolicy, error)
+	Get(id NodeID) (*Node, error)
+	GetByState(dtype DocTypeID, state DocStateID) (*Node, error)
+	List(id WorkflowID) ([]*Node, error)
+	Reminder(id NodeID) (*ReminderPolicy, error)
+	RemoveWebhook(otx *sql.Tx, id NodeWebhookID) error
+	ReturnAction(id NodeID) (DocActionID, error)
+	SLA(id NodeID) (time.Duration, error)
+	SetAutoAction(otx *sql.Tx, id NodeID, a *AutoAction) error
+	SetCapacity(otx *sql.Tx, id NodeID, l *CapacityLimit) error
+	SetCompensation(otx *sql.Tx, id NodeID, c *Compensation) error
+	SetExternalTask(otx *sql.Tx, id NodeID, p *ExternalTaskPolicy) error
+	SetReminder(otx *sql.Tx, id NodeID, p *ReminderPolicy) error
+	SetReturnAction(otx *sql.Tx, id NodeID, action DocActionID) error
+	SetSLA(otx *sql.Tx, id NodeID, d time.Duration) error
+	SetTemplate(otx *sql.Tx, id NodeID, name string) error
+	SetVotePolicy(otx *sql.Tx, id NodeID, p *VotePolicy) error
+	SetWebhookActive(otx *sql.Tx, id NodeWebhookID, active bool) error
+	VotePolicy(id NodeID) (*VotePolicy, error)
+	Webhooks(id NodeID) ([]*NodeWebhook, error)
+}
+
+// NotificationPrefsAPI is the interface of `NotificationPrefs`.
+type NotificationPrefsAPI interface {
+	Delete(otx *sql.Tx, uid UserID, dtype DocTypeID) error
+	Get(uid UserID, dtype DocTypeID) (*NotificationPref, error)
+	List(uid UserID) ([]*NotificationPref, error)
+	Set(otx *sql.Tx, pref *NotificationPref) error
+	SetDigestInterval(d time.Duration)
+}
+
+// OutboxAPI is the interface of `Outbox`.
+type OutboxAPI interface {
+	Dispatch(ctx context.Context) (int, error)
+	Enqueue(otx *sql.Tx, kind string, v interface{}) (OutboxEntryID, error)
+	List(input *OutboxListInput, offset, limit int64) ([]*OutboxEntry, error)
+	Notify()
+	Replay(otx *sql.Tx, id OutboxEntryID) error
+	SetConfig(c OutboxConfig)
+	SetHandler(kind string, h OutboxHandler)
+}
+
+// QuotasAPI is the interface of `Quotas`.
+type QuotasAPI interface {
+	Delete(otx *sql.Tx, id QuotaID) error
+	Get(id QuotaID) (*Quota, error)
+	List(dtype DocTypeID) ([]*Quota, error)
+	Set(otx *sql.Tx, q *Quota) (QuotaID, error)
+	Usage(id QuotaID) (*QuotaUsage, error)
+}
+
+// RedactionsAPI is the interface of `Redactions`.
+type RedactionsAPI interface {
+	Policy(dtype DocTypeID, rid RoleID) []string
+	SetPolicy(dtype DocTypeID, rid RoleID, fields ...string)
+	SetRedactor(dtype DocTypeID, r Redactor)
+}
+
+// ReportsAPI is the interface of `Reports`.
+type ReportsAPI interface {
+	AccessReview(acID AccessContextID) (*AccessReview, error)
+}
+
+// RetentionAPI is the interface of `Retention`.
+type RetentionAPI interface {
+	Delete(otx *sql.Tx, dtype DocTypeID) error
+	Get(dtype DocTypeID) (*RetentionPolicy, error)
+	List() ([]*RetentionPolicy, error)
+	OnProgress(fn func(*RetentionProgress))
+	Run(ctx context.Context) (int64, error)
+	Set(otx *sql.Tx, p *RetentionPolicy) error
+	SetBatchSize(n int) error
+}
+
+// RolesAPI is the interface of `Roles`.
+type RolesAPI interface {
+	AddPermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error
+	AllActionsDocTypes(rid RoleID) ([]DocTypeID, error)
+	Delete(otx *sql.Tx, id RoleID) error
+	Get(id RoleID) (*Role, error)
+	GetByName(name string) (*Role, error)
+	GrantAllActions(otx *sql.Tx, rid RoleID, dtype DocTypeID) error
+	HasPermission(rid RoleID, dtype DocTypeID, action DocActionID) (bool, error)
+	List(offset, limit int64) ([]*Role, error)
+	New(otx *sql.Tx, name string) (RoleID, error)
+	Permissions(rid RoleID) (map[string]struct {
+		DocTypeID DocTypeID
+		Actions   []*DocAction
+	}, error)
+	PreviewRemovePermissions(rid RoleID, dtype DocTypeID, actions []DocActionID) ([]*PermissionLoss, error)
+	RemovePermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error
+	Rename(otx *sql.Tx, id RoleID, name string) error
+	RevokeAllActions(otx *sql.Tx, rid RoleID, dtype DocTypeID) error
+	SetPermissions(otx *sql.Tx, rid RoleID, matrix map[DocTypeID][]DocActionID) error
+}
+
+// SettingsAPI is the interface of `Settings`.
+type SettingsAPI interface {
+	Bool(key string, scope SettingScope, def bool) (bool, error)
+	Delete(otx *sql.Tx, key string, scope SettingScope) error
+	Duration(key string, scope SettingScope, def time.Duration) (time.Duration, error)
+	Enabled(name string, scope SettingScope) (bool, error)
+	History(key string, offset, limit int64) ([]*SettingChange, error)
+	Int(key string, scope SettingScope, def int64) (int64, error)
+	List(prefix string) ([]*Setting, error)
+	Set(otx *sql.Tx, key string, scope SettingScope, value string) error
+	SetFeature(otx *sql.Tx, name string, scope SettingScope, on bool) error
+	String(key string, scope SettingScope, def string) (string, error)
+}
+
+// SubscriptionsAPI is the interface of `Subscriptions`.
+type SubscriptionsAPI interface {
+	Delete(otx *sql.Tx, id SubscriptionID) error
+	Get(id SubscriptionID) (*Subscription, error)
+	ListByGroup(gid GroupID) ([]*Subscription, error)
+	New(otx *sql.Tx, gid GroupID, name string, input *DocumentsListInput) (SubscriptionID, error)
+	SetActive(otx *sql.Tx, id SubscriptionID, active bool) error
+}
+
+// TemplatesAPI is the interface of `Templates`.
+type TemplatesAPI interface {
+	Delete(otx *sql.Tx, id MessageTemplateID) error
+	Get(name, locale string) (*MessageTemplate, error)
+	List(offset, limit int64, opts ...ReadOption) ([]*MessageTemplate, error)
+	New(otx *sql.Tx, name, locale, title, body string) (MessageTemplateID, error)
+	Render(name, locale string, data *TemplateData) (string, string, error)
+	Resolve(name, locale string) (*MessageTemplate, error)
+	SetDefaultLocale(locale string)
+	SetLinkFunc(fn LinkFunc)
+	Update(otx *sql.Tx, id MessageTemplateID, title, body string) error
+}
+
+// UsersAPI is the interface of `Users`.
+type UsersAPI interface {
+	Count(input *UsersListInput) (int64, error)
+	Get(uid UserID) (*User, error)
+	GetByEmail(email string) (*User, error)
+	GroupsOf(uid UserID) ([]*Group, error)
+	IsActive(uid UserID) (bool, error)
+	List(prefix string, offset, limit int64) ([]*User, error)
+	Search(input *UsersListInput, offset, limit int64) ([]*User, error)
+	SingletonGroupOf(uid UserID) (*Group, error)
+	SingletonMismatches() ([]*SingletonMismatch, error)
+	SyncEmail(otx *sql.Tx, uid UserID, email string) error
+}
+
+// WebhookDeliveriesAPI is the interface of `WebhookDeliveries`.
+type WebhookDeliveriesAPI interface {
+	List(input *WebhookDeliveriesListInput, offset, limit int64) ([]*WebhookDelivery, error)
+	Redeliver(otx *sql.Tx, id WebhookDeliveryID) error
+}
+
+// WebhooksAPI is the interface of `Webhooks`.
+type WebhooksAPI interface {
+	Delete(otx *sql.Tx, id WebhookID) error
+	Get(id WebhookID) (*Webhook, error)
+	List(offset, limit int64) ([]*Webhook, error)
+	New(otx *sql.Tx, endpoint, secret string, dtype DocTypeID, acid AccessContextID) (WebhookID, error)
+	SetActive(otx *sql.Tx, id WebhookID, active bool) error
+}
+
+// WorkflowsAPI is the interface of `Workflows`.
+type WorkflowsAPI interface {
+	Act(otx *sql.Tx, input *DocEventsNewInput, recipients []GroupID) (*ActResult, error)
+	AdHocSteps(dtype DocTypeID, did DocumentID) ([]*AdHocStep, error)
+	AddAdHocStep(otx *sql.Tx, dtype DocTypeID, did DocumentID, approver GroupID,
+		action DocActionID) (AdHocStepID, error)
+	AddNode(otx *sql.Tx, dtype DocTypeID, state DocStateID,
+		ac AccessContextID, wid WorkflowID, name string, ntype NodeType) (NodeID, error)
+	Capacity(id WorkflowID) (*CapacityLimit, error)
+	Compensate(otx *sql.Tx, dtype DocTypeID, did DocumentID, upto DocStateID) ([]DocEventID, error)
+	Count(input *WorkflowsListInput) (int64, error)
+	Coverage(wid WorkflowID, acID AccessContextID) ([]*CoverageGap, error)
+	DecideAdHocStep(otx *sql.Tx, id AdHocStepID, uid UserID, approve bool, text string) error
+	Delete(otx *sql.Tx, id WorkflowID) error
+	Diff(a, b *Bundle) *WorkflowDiff
+	DiffAgainstDB(b *Bundle) (*WorkflowDiff, error)
+	Get(id WorkflowID) (*Workflow, error)
+	GetByDocType(dtid DocTypeID) (*Workflow, error)
+	GetByName(name string) (*Workflow, error)
+	List(offset, limit int64) ([]*Workflow, error)
+	New(otx *sql.Tx, name string, dtype DocTypeID, state DocStateID) (WorkflowID, error)
+	OnTransition(name string, fn TransitionListener) error
+	RemoveNode(otx *sql.Tx, wid WorkflowID, nid NodeID) error
+	Rename(otx *sql.Tx, id WorkflowID, name string) error
+	Search(input *WorkflowsListInput, offset, limit int64) ([]*WorkflowSummary, error)
+	SetActive(otx *sql.Tx, id WorkflowID, active bool) error
+	SetCapacity(otx *sql.Tx, id WorkflowID, l *CapacityLimit) error
+	SetValidator(name string, fn TransitionValidator) error
+}
+
+// Compile-time checks that the accessors implement their interfaces.
+var (
+	_ AccessContextsAPI    = AccessContexts
+	_ AdminsAPI            = Admins
+	_ AnalyticsAPI         = Analytics
+	_ DirectorySyncAPI     = DirectorySync
+	_ DocActionsAPI        = DocActions
+	_ DocEventsAPI         = DocEvents
+	_ DocStatesAPI         = DocStates
+	_ DocTypesAPI          = DocTypes
+	_ DocumentsAPI         = Documents
+	_ EmailDeliveriesAPI   = EmailDeliveries
+	_ EventStreamAPI       = EventStream
+	_ ExternalTasksAPI     = ExternalTasks
+	_ GroupsAPI            = Groups
+	_ I18nAPI              = I18n
+	_ LocksAPI             = Locks
+	_ MailboxesAPI         = Mailboxes
+	_ MentionsAPI          = Mentions
+	_ NodesAPI             = Nodes
+	_ NotificationPrefsAPI = NotificationPrefs
+	_ OutboxAPI            = Outbox
+	_ QuotasAPI            = Quotas
+	_ RedactionsAPI        = Redactions
+	_ ReportsAPI           = Reports
+	_ RetentionAPI         = Retention
+	_ RolesAPI             = Roles
+	_ SettingsAPI          = Settings
+	_ SubscriptionsAPI     = Subscriptions
+	_ TemplatesAPI         = Templates
+	_ UsersAPI             = Users
+	_ WebhookDeliveriesAPI = WebhookDeliveries
+	_ WebhooksAPI          = Webhooks
+	_ WorkflowsAPI         = Workflows
+)
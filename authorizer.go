@@ -0,0 +1,110 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// Authorizer decides whether users may act on documents.
+//
+// All authorisation in `flow` goes through the registered authorizer:
+// `Workflow.ApplyEvent` consults it before applying an event, and so
+// do the REST and gRPC servers before serving a request.  The default
+// authorizer answers using the roles that users have in access
+// contexts, as recorded in `wf_ac_perms_v`.  Applications with
+// additional rules can register their own, possibly wrapping the
+// default.
+type Authorizer interface {
+	// Allowed answers `true` if the given user may perform the given
+	// action on documents of the given type in the given access
+	// context.  A zero action requires only membership of the access
+	// context.
+	Allowed(acid AccessContextID, uid UserID, dtype DocTypeID, action DocActionID) (bool, error)
+}
+
+// AccessContextAuthorizer is the default authorizer, which answers
+// using the permissions that users have in access contexts.
+type AccessContextAuthorizer struct{}
+
+// Allowed implements `Authorizer`.
+func (AccessContextAuthorizer) Allowed(acid AccessContextID, uid UserID, dtype DocTypeID, action DocActionID) (bool, error) {
+	if action == 0 {
+		return AccessContexts.IncludesUser(acid, uid)
+	}
+	return AccessContexts.UserHasPermission(acid, uid, dtype, action)
+}
+
+var (
+	authzMu    sync.RWMutex
+	authorizer Authorizer = AccessContextAuthorizer{}
+)
+
+// SetAuthorizer registers the authorizer consulted by `flow`.  A `nil`
+// authorizer restores the default.
+func SetAuthorizer(a Authorizer) {
+	if a == nil {
+		a = AccessContextAuthorizer{}
+	}
+
+	authzMu.Lock()
+	defer authzMu.Unlock()
+	authorizer = a
+}
+
+// Authorize answers `nil` if the registered authorizer allows the
+// given user to perform the given action on documents of the given
+// type in the given access context; `ErrPermissionDenied` otherwise.
+func Authorize(acid AccessContextID, uid UserID, dtype DocTypeID, action DocActionID) error {
+	authzMu.RLock()
+	a := authorizer
+	authzMu.RUnlock()
+
+	ok, err := a.Allowed(acid, uid, dtype, action)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// authorizeEvent answers `nil` if the user of the singleton group that
+// caused the given event may apply its action to the given document.
+func authorizeEvent(otx *sql.Tx, doc *Document, event *DocEvent) error {
+	q := `
+	SELECT gus.user_id
+	FROM wf_group_users gus
+	JOIN wf_groups_master gm ON gus.group_id = gm.id
+	WHERE gm.id = ?
+	AND gm.group_type = ` + GroupSingleton.sql() + `
+	LIMIT 1
+	`
+	row, err := stmts.queryRow(otx, q, event.Group)
+	if err != nil {
+		return err
+	}
+	var uid int64
+	if err = row.Scan(&uid); err != nil {
+		if err == sql.ErrNoRows {
+			return newError(CodeValidation, "group must be singleton")
+		}
+		return err
+	}
+
+	return Authorize(doc.AccCtx.ID, UserID(uid), event.DocType, event.Action)
+}
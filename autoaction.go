@@ -0,0 +1,275 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AutoAction specifies the action that the system applies to a
+// document waiting at a node, once `After` elapses without the
+// document moving on.  Typical uses include auto-approving requests of
+// small value, and expiring quotations.
+//
+// The action is applied by the timer pump, as an event raised on
+// behalf of `Actor`; it is audited as any other event.  `Action`
+// should be one of the actions defined on the node's state.
+type AutoAction struct {
+	After  time.Duration `json:"After"`  // Deadline, counted from the document's arrival; at least a minute
+	Action DocActionID   `json:"Action"` // Action applied upon the deadline
+	Actor  GroupID       `json:"Actor"`  // Singleton group on whose behalf the action is applied
+}
+
+func init() {
+	registerTimer("auto actions", applyAutoActions)
+}
+
+// SetAutoAction sets the automatic action of the given node.  A `nil`
+// action removes it, and cancels the pending automatic actions of the
+// node.  Documents already waiting at the node are not affected by a
+// new setting.
+func (_Nodes) SetAutoAction(otx *sql.Tx, id NodeID, a *AutoAction) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	if a != nil {
+		if a.After < time.Minute {
+			v.fail("After", "should be at least a minute")
+		}
+		v.positive("Action", int64(a.Action))
+		v.positive("Actor", int64(a.Actor))
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	if a != nil {
+		n, err := Nodes.Get(id)
+		if err != nil {
+			return err
+		}
+		ts, err := n.Transitions()
+		if err != nil {
+			return err
+		}
+		if _, ok := ts[a.Action]; !ok {
+			return errorf(CodeValidation, "action %d is not defined on the state of node %d", a.Action, id)
+		}
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if a == nil {
+			_, err := tx.Exec("DELETE FROM wf_node_auto_actions WHERE node_id = ?", id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("UPDATE wf_auto_actions SET status = 'C', mtime = NOW() WHERE node_id = ? AND status = 'P'", id)
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_node_auto_actions(node_id, after_secs, docaction_id, group_id)
+		VALUES(?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE after_secs = VALUES(after_secs), docaction_id = VALUES(docaction_id),
+			group_id = VALUES(group_id)
+		`
+		_, err := tx.Exec(q, id, int64(a.After/time.Second), a.Action, a.Actor)
+		return err
+	})
+}
+
+// AutoAction answers the automatic action of the given node, if any;
+// `nil` otherwise.
+func (_Nodes) AutoAction(id NodeID) (*AutoAction, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	q := `
+	SELECT after_secs, docaction_id, group_id
+	FROM wf_node_auto_actions
+	WHERE node_id = ?
+	`
+	var a AutoAction
+	var secs int64
+	err := readDB().QueryRow(q, id).Scan(&secs, &a.Action, &a.Actor)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	a.After = time.Duration(secs) * time.Second
+	return &a, nil
+}
+
+// scheduleAutoAction schedules the automatic action of the given node,
+// if any, for the given document, which has arrived at the node.
+func scheduleAutoAction(otx *sql.Tx, n *Node, did DocumentID) error {
+	q := `
+	INSERT INTO wf_auto_actions(node_id, doctype_id, doc_id, docstate_id, due_at, status, ctime, mtime)
+	SELECT node_id, ?, ?, ?, DATE_ADD(NOW(), INTERVAL after_secs SECOND), 'P', NOW(), NOW()
+	FROM wf_node_auto_actions
+	WHERE node_id = ?
+	`
+	_, err := stmts.exec(otx, q, n.DocType, did, n.State, n.ID)
+	return err
+}
+
+// cancelAutoActions cancels the pending automatic actions of the given
+// document, which is transitioning.
+func cancelAutoActions(otx *sql.Tx, dtype DocTypeID, did DocumentID) error {
+	q := `
+	UPDATE wf_auto_actions
+	SET status = 'C', mtime = NOW()
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND status = 'P'
+	`
+	_, err := stmts.exec(otx, q, dtype, did)
+	return err
+}
+
+// applyAutoActions is the timer task of automatic actions.  It applies
+// those that are due.
+//
+// An action that cannot be applied -- e.g. because its actor is no
+// longer permitted to perform it -- is marked failed and logged, so
+// that it does not hold up the others.
+func applyAutoActions(ctx context.Context) (int, error) {
+	q := `
+	SELECT id
+	FROM wf_auto_actions
+	WHERE status = 'P'
+	AND due_at <= NOW()
+	ORDER BY due_at
+	LIMIT 100
+	`
+	ids := []int64{}
+	err := scanEach(db.Query, q, nil, func(scan func(...interface{}) error) error {
+		var id int64
+		if err := scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		var ok bool
+		err = RetryTx(func(tx *sql.Tx) error {
+			var err error
+			ok, err = applyAutoAction(tx, id)
+			return err
+		})
+		switch CodeOf(err) {
+		case 0:
+			if ok {
+				n++
+			}
+
+		case CodeNotFound, CodeValidation, CodePermissionDenied:
+			writeLog(LogWarn, "automatic action failed", F("id", id), F("error", err))
+			_, err = db.Exec("UPDATE wf_auto_actions SET status = 'F', mtime = NOW() WHERE id = ? AND status = 'P'", id)
+			if err != nil {
+				return n, err
+			}
+
+		default:
+			return n, err
+		}
+	}
+	if n > 0 {
+		Outbox.Notify()
+	}
+
+	return n, nil
+}
+
+// applyAutoAction claims the given automatic action, and applies it to
+// its document, unless the document has moved on meanwhile.  It
+// answers `true` if the action was applied.
+func applyAutoAction(tx *sql.Tx, id int64) (bool, error) {
+	q := `
+	SELECT aas.node_id, aas.doctype_id, aas.doc_id, aas.docstate_id, naas.docaction_id, naas.group_id
+	FROM wf_auto_actions aas
+	JOIN wf_node_auto_actions naas ON naas.node_id = aas.node_id
+	WHERE aas.id = ?
+	AND aas.status = 'P'
+	AND aas.due_at <= NOW()
+	FOR UPDATE
+	`
+	var nid NodeID
+	var dtype DocTypeID
+	var did DocumentID
+	var state DocStateID
+	var action DocActionID
+	var gid GroupID
+	err := tx.QueryRow(q, id).Scan(&nid, &dtype, &did, &state, &action, &gid)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+
+	case err != nil:
+		return false, err
+	}
+
+	// Suppress the action should the document have transitioned
+	// without its cancellation, e.g. through a direct state change.
+	doc, err := Documents.Get(tx, dtype, did)
+	if err != nil && CodeOf(err) != CodeNotFound {
+		return false, err
+	}
+	if err != nil || doc.State.ID != state {
+		_, err = tx.Exec("UPDATE wf_auto_actions SET status = 'C', mtime = NOW() WHERE id = ?", id)
+		return false, err
+	}
+
+	w, err := Workflows.GetByDocType(dtype)
+	if err != nil {
+		return false, err
+	}
+	n, err := Nodes.Get(nid)
+	if err != nil {
+		return false, err
+	}
+	text := fmt.Sprintf("automatic action of %s : deadline elapsed", n.Name)
+	eid, err := w.applySystemEvent(tx, did, state, action, gid, text)
+	if err != nil {
+		return false, err
+	}
+
+	q = `
+	UPDATE wf_auto_actions
+	SET status = 'D', docevent_id = ?, mtime = NOW()
+	WHERE id = ?
+	`
+	_, err = tx.Exec(q, eid, id)
+	return err == nil, err
+}
@@ -0,0 +1,61 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// maxBatchRows bounds the number of rows written by a single
+// multi-row statement.  This keeps statements well within the limits
+// of MySQL on placeholders and packet size.
+const maxBatchRows = 500
+
+// insertRows executes the given `INSERT ... VALUES` statement prefix
+// with one copy of the given value group per row, e.g. `(?, ?, 1)`,
+// writing up to `maxBatchRows` rows per round trip.  Each row should
+// have as many values as the group has placeholders.
+func insertRows(tx *sql.Tx, prefix, group string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > maxBatchRows {
+			n = maxBatchRows
+		}
+
+		args := make([]interface{}, 0, n*len(rows[0]))
+		for _, r := range rows[:n] {
+			args = append(args, r...)
+		}
+		q := prefix + " " + group + strings.Repeat(", "+group, n-1)
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
+
+		rows = rows[n:]
+	}
+
+	return nil
+}
+
+// inPlaceholders answers a parenthesised list of `n` placeholders,
+// for use with `IN`.
+func inPlaceholders(n int) string {
+	return "(?" + strings.Repeat(", ?", n-1) + ")"
+}
@@ -0,0 +1,393 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// WorkflowBuilder assembles the definition of a document type, its
+// states, actions and transitions, and its workflow, by name.
+//
+//     wid, err := flow.NewWorkflowBuilder("PUR:RFQ").
+//         State("Draft").On("Submit").To("Pending").
+//         State("Pending").On("Approve").To("Approved").
+//                          On("Reject").To("Draft").
+//         Reconfirm("Approve").
+//         Build(nil)
+//
+// The first state declared is where the workflow begins, unless
+// `Begin` names another.  Each declared state gets a node, whose name
+// defaults to that of the state, and whose type is inferred from the
+// transitions, unless specified.  States that are only targets of
+// transitions get no node; documents cannot leave them.
+//
+// Errors in the chain of calls are remembered, and answered by
+// `Validate` and `Build`.
+type WorkflowBuilder struct {
+	dtype     string
+	name      string
+	begin     string
+	states    []*StateBuilder
+	byName    map[string]*StateBuilder
+	reconfirm map[string]bool
+	actions   []string
+	err       error
+}
+
+// StateBuilder assembles the transitions out of a single state, and
+// its node.
+type StateBuilder struct {
+	wb       *WorkflowBuilder
+	name     string
+	node     string
+	ntype    NodeType
+	acName   string
+	template string
+	trans    []ConfigTransition
+}
+
+// TransitionBuilder completes a transition begun by `StateBuilder.On`.
+type TransitionBuilder struct {
+	sb     *StateBuilder
+	action string
+}
+
+// NewWorkflowBuilder begins the definition of a workflow for the named
+// document type.  The workflow is named after the document type,
+// unless `Named` is used.
+func NewWorkflowBuilder(dtype string) *WorkflowBuilder {
+	wb := &WorkflowBuilder{
+		dtype:     strings.TrimSpace(dtype),
+		byName:    map[string]*StateBuilder{},
+		reconfirm: map[string]bool{},
+	}
+	wb.name = wb.dtype
+	if wb.dtype == "" {
+		wb.fail(newError(CodeValidation, "document type name should not be empty"))
+	}
+	return wb
+}
+
+func (wb *WorkflowBuilder) fail(err error) {
+	if wb.err == nil {
+		wb.err = err
+	}
+}
+
+// Named sets the name of the workflow.
+func (wb *WorkflowBuilder) Named(name string) *WorkflowBuilder {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		wb.fail(newError(CodeValidation, "workflow name should not be empty"))
+	}
+	wb.name = name
+	return wb
+}
+
+// Begin names the state in which the workflow begins.
+func (wb *WorkflowBuilder) Begin(state string) *WorkflowBuilder {
+	wb.begin = strings.TrimSpace(state)
+	return wb
+}
+
+// Reconfirm marks the named actions as requiring confirmation from
+// users.  Actions that exist already should agree.
+func (wb *WorkflowBuilder) Reconfirm(actions ...string) *WorkflowBuilder {
+	for _, a := range actions {
+		wb.reconfirm[strings.TrimSpace(a)] = true
+	}
+	return wb
+}
+
+// State declares the named state, or resumes its definition if it is
+// already declared.
+func (wb *WorkflowBuilder) State(name string) *StateBuilder {
+	name = strings.TrimSpace(name)
+	if sb, ok := wb.byName[name]; ok {
+		return sb
+	}
+	if name == "" {
+		wb.fail(newError(CodeValidation, "state name should not be empty"))
+	}
+
+	sb := &StateBuilder{wb: wb, name: name, node: name}
+	wb.states = append(wb.states, sb)
+	wb.byName[name] = sb
+	return sb
+}
+
+// State declares the named state in the containing workflow.
+func (sb *StateBuilder) State(name string) *StateBuilder {
+	return sb.wb.State(name)
+}
+
+// On begins a transition out of this state upon the named action.
+func (sb *StateBuilder) On(action string) *TransitionBuilder {
+	action = strings.TrimSpace(action)
+	if action == "" {
+		sb.wb.fail(errorf(CodeValidation, "state %s : action name should not be empty", sb.name))
+	}
+	return &TransitionBuilder{sb: sb, action: action}
+}
+
+// Node sets the name of the node of this state.
+func (sb *StateBuilder) Node(name string) *StateBuilder {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		sb.wb.fail(errorf(CodeValidation, "state %s : node name should not be empty", sb.name))
+	}
+	sb.node = name
+	return sb
+}
+
+// Type sets the type of the node of this state, overriding the
+// inferred one.
+func (sb *StateBuilder) Type(ntype NodeType) *StateBuilder {
+	if !IsValidNodeType(string(ntype)) {
+		sb.wb.fail(errorf(CodeValidation, "state %s : unknown node type : %s", sb.name, ntype))
+	}
+	sb.ntype = ntype
+	return sb
+}
+
+// AccessContext associates the named access context with the node of
+// this state.
+func (sb *StateBuilder) AccessContext(name string) *StateBuilder {
+	sb.acName = strings.TrimSpace(name)
+	return sb
+}
+
+// Template attaches the named message template to the node of this
+// state.
+func (sb *StateBuilder) Template(name string) *StateBuilder {
+	sb.template = strings.TrimSpace(name)
+	return sb
+}
+
+// Begin names the state in which the containing workflow begins.
+func (sb *StateBuilder) Begin(state string) *WorkflowBuilder {
+	return sb.wb.Begin(state)
+}
+
+// Named sets the name of the containing workflow.
+func (sb *StateBuilder) Named(name string) *WorkflowBuilder {
+	return sb.wb.Named(name)
+}
+
+// Reconfirm marks the named actions as requiring confirmation.
+func (sb *StateBuilder) Reconfirm(actions ...string) *WorkflowBuilder {
+	return sb.wb.Reconfirm(actions...)
+}
+
+// Validate checks the definition; see `WorkflowBuilder.Validate`.
+func (sb *StateBuilder) Validate() error {
+	return sb.wb.Validate()
+}
+
+// Build persists the definition; see `WorkflowBuilder.Build`.
+func (sb *StateBuilder) Build(otx *sql.Tx) (WorkflowID, error) {
+	return sb.wb.Build(otx)
+}
+
+// Config answers the configuration of the containing workflow; see
+// `WorkflowBuilder.Config`.
+func (sb *StateBuilder) Config() (*Config, error) {
+	return sb.wb.Config()
+}
+
+// To completes the transition, leading to the named state.  It
+// answers the state being transitioned out of, so that further
+// transitions can be chained.
+func (tb *TransitionBuilder) To(state string) *StateBuilder {
+	sb := tb.sb
+	state = strings.TrimSpace(state)
+	if state == "" {
+		sb.wb.fail(errorf(CodeValidation, "state %s : target state name should not be empty", sb.name))
+		return sb
+	}
+
+	for _, t := range sb.trans {
+		if t.Action == tb.action {
+			if t.To != state {
+				sb.wb.fail(errorf(CodeValidation, "state %s : action %s leads to both %s and %s", sb.name, tb.action, t.To, state))
+			}
+			return sb
+		}
+	}
+	sb.trans = append(sb.trans, ConfigTransition{From: sb.name, Action: tb.action, To: state})
+
+	known := false
+	for _, a := range sb.wb.actions {
+		if a == tb.action {
+			known = true
+			break
+		}
+	}
+	if !known {
+		sb.wb.actions = append(sb.wb.actions, tb.action)
+	}
+	return sb
+}
+
+// Validate checks the definition in memory: the begin state should
+// be declared, node names should be unique, and every declared state
+// should be reachable from the begin state.
+func (wb *WorkflowBuilder) Validate() error {
+	if wb.err != nil {
+		return wb.err
+	}
+	if len(wb.states) == 0 {
+		return newError(CodeValidation, "no states declared")
+	}
+
+	begin := wb.begin
+	if begin == "" {
+		begin = wb.states[0].name
+	}
+	if _, ok := wb.byName[begin]; !ok {
+		return errorf(CodeValidation, "begin state %s is not declared", begin)
+	}
+
+	nodes := map[string]string{}
+	for _, sb := range wb.states {
+		if other, ok := nodes[sb.node]; ok {
+			return errorf(CodeValidation, "states %s and %s have the same node name : %s", other, sb.name, sb.node)
+		}
+		nodes[sb.node] = sb.name
+	}
+
+	reached := map[string]bool{begin: true}
+	pending := []string{begin}
+	for len(pending) > 0 {
+		cur := pending[0]
+		pending = pending[1:]
+		sb, ok := wb.byName[cur]
+		if !ok {
+			continue
+		}
+		for _, t := range sb.trans {
+			if !reached[t.To] {
+				reached[t.To] = true
+				pending = append(pending, t.To)
+			}
+		}
+	}
+	for _, sb := range wb.states {
+		if !reached[sb.name] {
+			return errorf(CodeValidation, "state %s is not reachable from %s", sb.name, begin)
+		}
+	}
+
+	return nil
+}
+
+// config answers the configuration equivalent to this definition.
+func (wb *WorkflowBuilder) config() *Config {
+	begin := wb.begin
+	if begin == "" {
+		begin = wb.states[0].name
+	}
+
+	cfg := &Config{}
+	states := map[string]bool{}
+	addState := func(name string) {
+		if !states[name] {
+			states[name] = true
+			cfg.States = append(cfg.States, name)
+		}
+	}
+
+	cdt := ConfigDocType{Name: wb.dtype}
+	cw := ConfigWorkflow{Name: wb.name, DocType: wb.dtype, Begin: begin}
+	for _, sb := range wb.states {
+		addState(sb.name)
+		for _, t := range sb.trans {
+			addState(t.To)
+			cdt.Transitions = append(cdt.Transitions, t)
+		}
+
+		ntype := sb.ntype
+		if ntype == "" {
+			switch {
+			case sb.name == begin:
+				ntype = NodeTypeBegin
+			case len(sb.trans) == 0:
+				ntype = NodeTypeEnd
+			case len(sb.trans) == 1:
+				ntype = NodeTypeLinear
+			default:
+				ntype = NodeTypeBranch
+			}
+		}
+		cw.Nodes = append(cw.Nodes, ConfigNode{
+			Name:     sb.node,
+			State:    sb.name,
+			Type:     string(ntype),
+			AccCtx:   sb.acName,
+			Template: sb.template,
+		})
+	}
+	for _, a := range wb.actions {
+		cfg.Actions = append(cfg.Actions, ConfigDocAction{Name: a, Reconfirm: wb.reconfirm[a]})
+	}
+	cfg.DocTypes = []ConfigDocType{cdt}
+	cfg.Wflows = []ConfigWorkflow{cw}
+	return cfg
+}
+
+// Config validates the definition, and answers the equivalent
+// configuration, as read by `LoadConfig`.
+func (wb *WorkflowBuilder) Config() (*Config, error) {
+	if err := wb.Validate(); err != nil {
+		return nil, err
+	}
+	return wb.config(), nil
+}
+
+// Build validates the definition, and persists it in a single
+// transaction.  States, actions and the document type are created
+// unless they exist already; the transitions, the workflow and its
+// nodes are created unless identical ones exist already.  Should the
+// database define any of them differently, nothing is persisted, and
+// the differences are answered as an error.
+//
+// N.B. Creating the storage table of a new document type commits the
+// transaction implicitly in MySQL.
+func (wb *WorkflowBuilder) Build(otx *sql.Tx) (WorkflowID, error) {
+	if err := wb.Validate(); err != nil {
+		return 0, err
+	}
+
+	var wid WorkflowID
+	err := withTx(otx, func(tx *sql.Tx) error {
+		l := newConfigLoader(tx)
+		if err := l.load(wb.config()); err != nil {
+			return err
+		}
+		if len(l.report.Drift) > 0 {
+			return newError(CodeConflict, "definition conflicts with the database : "+strings.Join(l.report.Drift, "; "))
+		}
+
+		row := tx.QueryRow(`SELECT id FROM wf_workflows WHERE name = ?`, wb.name)
+		return row.Scan(&wid)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return wid, nil
+}
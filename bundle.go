@@ -0,0 +1,853 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// bundleVersion is the version of the format of configuration bundles
+// written by `ExportAll`.
+const bundleVersion = 1
+
+// Bundle is the complete configuration of `flow`, excluding documents
+// and their history, as written by `ExportAll`.
+//
+// All cross-references are by name.  The identifiers of the source
+// database are carried along, only so that `ImportAll` can report how
+// they map to those of the target database.
+type Bundle struct {
+	Version   int                   `json:"version"`
+	States    []BundleState         `json:"states"`
+	Actions   []BundleAction        `json:"actions"`
+	DocTypes  []BundleDocType       `json:"doctypes"`
+	Roles     []BundleRole          `json:"roles"`
+	Groups    []BundleGroup         `json:"groups"`
+	AccCtxs   []BundleAccessContext `json:"accessContexts"`
+	Workflows []BundleWorkflow      `json:"workflows"`
+}
+
+// BundleState describes a document state.
+type BundleState struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// BundleAction describes a document action.
+type BundleAction struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Reconfirm bool   `json:"reconfirm"`
+}
+
+// BundleDocType describes a document type, and its state transitions.
+type BundleDocType struct {
+	ID          int64              `json:"id"`
+	Name        string             `json:"name"`
+	Transitions []ConfigTransition `json:"transitions"`
+}
+
+// BundlePermission describes the permission to perform an action on
+// documents of a type.
+type BundlePermission struct {
+	DocType string `json:"doctype"`
+	Action  string `json:"action"`
+}
+
+// BundleRole describes a role, and its permissions.  `AllActions`
+// lists the document types on which the role has all the actions.
+type BundleRole struct {
+	ID          int64              `json:"id"`
+	Name        string             `json:"name"`
+	Permissions []BundlePermission `json:"permissions"`
+	AllActions  []string           `json:"all_actions,omitempty"`
+}
+
+// BundleGroup describes a group, and its members by e-mail address.
+type BundleGroup struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Members []string `json:"members"`
+}
+
+// BundleAcGroup describes the position of a group in the hierarchy of
+// an access context.
+type BundleAcGroup struct {
+	Group     string `json:"group"`
+	ReportsTo string `json:"reportsTo"` // Empty at the top of the hierarchy
+}
+
+// BundleAcGroupRole describes a role held by a group in an access
+// context.
+type BundleAcGroupRole struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// BundleAccessContext describes an access context, its groups and
+// their roles.
+type BundleAccessContext struct {
+	ID         int64               `json:"id"`
+	Name       string              `json:"name"`
+	Active     bool                `json:"active"`
+	Groups     []BundleAcGroup     `json:"groups"`
+	GroupRoles []BundleAcGroupRole `json:"groupRoles"`
+}
+
+// BundleNode describes a node of a workflow.
+type BundleNode struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Type     string `json:"type"`
+	AccCtx   string `json:"accessContext"`
+	Template string `json:"template"`
+}
+
+// BundleWorkflow describes a workflow, and its nodes.
+type BundleWorkflow struct {
+	ID      int64        `json:"id"`
+	Name    string       `json:"name"`
+	DocType string       `json:"doctype"`
+	Begin   string       `json:"begin"`
+	Active  bool         `json:"active"`
+	Nodes   []BundleNode `json:"nodes"`
+}
+
+// ImportReport summarises the reconciliation performed by
+// `ImportAll`.
+type ImportReport struct {
+	ConfigReport
+
+	// IDs maps the identifiers of the source database to those of the
+	// target database, by kind: "states", "actions", "doctypes",
+	// "roles", "groups", "accessContexts", "workflows" and "nodes".
+	IDs map[string]map[int64]int64
+}
+
+// ExportAll writes the complete configuration -- document types,
+// states, actions and transitions, workflows and nodes, roles and
+// their permissions, groups and their members, and access contexts
+// with their hierarchies and roles -- to the given writer, as a JSON
+// bundle.  Documents are not included.
+//
+// Definitions are ordered by name, so that bundles of equivalent
+// configurations compare equal, barring identifiers.
+func ExportAll(w io.Writer) error {
+	b, err := exportBundle()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// exportBundle reads the complete configuration.
+func exportBundle() (*Bundle, error) {
+	rdb := readDB()
+	b := &Bundle{
+		Version:   bundleVersion,
+		States:    []BundleState{},
+		Actions:   []BundleAction{},
+		DocTypes:  []BundleDocType{},
+		Roles:     []BundleRole{},
+		Groups:    []BundleGroup{},
+		AccCtxs:   []BundleAccessContext{},
+		Workflows: []BundleWorkflow{},
+	}
+
+	err := scanEach(rdb.Query, `SELECT id, name FROM wf_docstates_master ORDER BY name`, nil,
+		func(scan func(...interface{}) error) error {
+			var elem BundleState
+			if err := scan(&elem.ID, &elem.Name); err != nil {
+				return err
+			}
+			b.States = append(b.States, elem)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanEach(rdb.Query, `SELECT id, name, reconfirm FROM wf_docactions_master ORDER BY name`, nil,
+		func(scan func(...interface{}) error) error {
+			var elem BundleAction
+			if err := scan(&elem.ID, &elem.Name, &elem.Reconfirm); err != nil {
+				return err
+			}
+			b.Actions = append(b.Actions, elem)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	dtidx := map[int64]int{}
+	err = scanEach(rdb.Query, `SELECT id, name FROM wf_doctypes_master ORDER BY name`, nil,
+		func(scan func(...interface{}) error) error {
+			elem := BundleDocType{Transitions: []ConfigTransition{}}
+			if err := scan(&elem.ID, &elem.Name); err != nil {
+				return err
+			}
+			dtidx[elem.ID] = len(b.DocTypes)
+			b.DocTypes = append(b.DocTypes, elem)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	SELECT dst.doctype_id, dsm1.name, dam.name, dsm2.name
+	FROM wf_docstate_transitions dst
+	JOIN wf_docstates_master dsm1 ON dsm1.id = dst.from_state_id
+	JOIN wf_docactions_master dam ON dam.id = dst.docaction_id
+	JOIN wf_docstates_master dsm2 ON dsm2.id = dst.to_state_id
+	ORDER BY dsm1.name, dam.name, dsm2.name
+	`
+	err = scanEach(rdb.Query, q, nil, func(scan func(...interface{}) error) error {
+		var dtid int64
+		var t ConfigTransition
+		if err := scan(&dtid, &t.From, &t.Action, &t.To); err != nil {
+			return err
+		}
+		if i, ok := dtidx[dtid]; ok {
+			b.DocTypes[i].Transitions = append(b.DocTypes[i].Transitions, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ridx := map[int64]int{}
+	err = scanEach(rdb.Query, `SELECT id, name FROM wf_roles_master ORDER BY name`, nil,
+		func(scan func(...interface{}) error) error {
+			elem := BundleRole{Permissions: []BundlePermission{}}
+			if err := scan(&elem.ID, &elem.Name); err != nil {
+				return err
+			}
+			ridx[elem.ID] = len(b.Roles)
+			b.Roles = append(b.Roles, elem)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	q = `
+	SELECT rdas.role_id, dtm.name, dam.name
+	FROM wf_role_docactions rdas
+	JOIN wf_doctypes_master dtm ON dtm.id = rdas.doctype_id
+	JOIN wf_docactions_master dam ON dam.id = rdas.docaction_id
+	ORDER BY dtm.name, dam.name
+	`
+	err = scanEach(rdb.Query, q, nil, func(scan func(...interface{}) error) error {
+		var rid int64
+		var p BundlePermission
+		if err := scan(&rid, &p.DocType, &p.Action); err != nil {
+			return err
+		}
+		if i, ok := ridx[rid]; ok {
+			b.Roles[i].Permissions = append(b.Roles[i].Permissions, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	q = `
+	SELECT rads.role_id, dtm.name
+	FROM wf_role_all_docactions rads
+	JOIN wf_doctypes_master dtm ON dtm.id = rads.doctype_id
+	ORDER BY dtm.name
+	`
+	err = scanEach(rdb.Query, q, nil, func(scan func(...interface{}) error) error {
+		var rid int64
+		var dtname string
+		if err := scan(&rid, &dtname); err != nil {
+			return err
+		}
+		if i, ok := ridx[rid]; ok {
+			b.Roles[i].AllActions = append(b.Roles[i].AllActions, dtname)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gidx := map[int64]int{}
+	err = scanEach(rdb.Query, `SELECT id, name, COALESCE(group_type, `+GroupGeneral.sql()+`) FROM wf_groups_master ORDER BY name`, nil,
+		func(scan func(...interface{}) error) error {
+			elem := BundleGroup{Members: []string{}}
+			if err := scan(&elem.ID, &elem.Name, &elem.Type); err != nil {
+				return err
+			}
+			gidx[elem.ID] = len(b.Groups)
+			b.Groups = append(b.Groups, elem)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	q = `
+	SELECT gu.group_id, um.email
+	FROM wf_group_users gu
+	JOIN wf_users_master um ON um.id = gu.user_id
+	ORDER BY um.email
+	`
+	err = scanEach(rdb.Query, q, nil, func(scan func(...interface{}) error) error {
+		var gid int64
+		var email string
+		if err := scan(&gid, &email); err != nil {
+			return err
+		}
+		if i, ok := gidx[gid]; ok {
+			b.Groups[i].Members = append(b.Groups[i].Members, email)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	acidx := map[int64]int{}
+	err = scanEach(rdb.Query, `SELECT id, name, active FROM wf_access_contexts ORDER BY name`, nil,
+		func(scan func(...interface{}) error) error {
+			elem := BundleAccessContext{Groups: []BundleAcGroup{}, GroupRoles: []BundleAcGroupRole{}}
+			if err := scan(&elem.ID, &elem.Name, &elem.Active); err != nil {
+				return err
+			}
+			acidx[elem.ID] = len(b.AccCtxs)
+			b.AccCtxs = append(b.AccCtxs, elem)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	q = `
+	SELECT agh.ac_id, gm.name, COALESCE(rgm.name, '')
+	FROM wf_ac_group_hierarchy agh
+	JOIN wf_groups_master gm ON gm.id = agh.group_id
+	LEFT JOIN wf_groups_master rgm ON rgm.id = agh.reports_to
+	ORDER BY gm.name
+	`
+	err = scanEach(rdb.Query, q, nil, func(scan func(...interface{}) error) error {
+		var acid int64
+		var g BundleAcGroup
+		if err := scan(&acid, &g.Group, &g.ReportsTo); err != nil {
+			return err
+		}
+		if i, ok := acidx[acid]; ok {
+			b.AccCtxs[i].Groups = append(b.AccCtxs[i].Groups, g)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	q = `
+	SELECT DISTINCT agrs.ac_id, gm.name, rm.name
+	FROM wf_ac_group_roles agrs
+	JOIN wf_groups_master gm ON gm.id = agrs.group_id
+	JOIN wf_roles_master rm ON rm.id = agrs.role_id
+	ORDER BY gm.name, rm.name
+	`
+	err = scanEach(rdb.Query, q, nil, func(scan func(...interface{}) error) error {
+		var acid int64
+		var gr BundleAcGroupRole
+		if err := scan(&acid, &gr.Group, &gr.Role); err != nil {
+			return err
+		}
+		if i, ok := acidx[acid]; ok {
+			b.AccCtxs[i].GroupRoles = append(b.AccCtxs[i].GroupRoles, gr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	widx := map[int64]int{}
+	q = `
+	SELECT wfs.id, wfs.name, dtm.name, dsm.name, wfs.active
+	FROM wf_workflows wfs
+	JOIN wf_doctypes_master dtm ON dtm.id = wfs.doctype_id
+	JOIN wf_docstates_master dsm ON dsm.id = wfs.docstate_id
+	ORDER BY wfs.name
+	`
+	err = scanEach(rdb.Query, q, nil, func(scan func(...interface{}) error) error {
+		elem := BundleWorkflow{Nodes: []BundleNode{}}
+		if err := scan(&elem.ID, &elem.Name, &elem.DocType, &elem.Begin, &elem.Active); err != nil {
+			return err
+		}
+		widx[elem.ID] = len(b.Workflows)
+		b.Workflows = append(b.Workflows, elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	q = `
+	SELECT wns.id, wns.workflow_id, wns.name, dsm.name, wns.type, COALESCE(acs.name, ''),
+		COALESCE(wns.template_name, '')
+	FROM wf_workflow_nodes wns
+	JOIN wf_docstates_master dsm ON dsm.id = wns.docstate_id
+	LEFT JOIN wf_access_contexts acs ON acs.id = wns.ac_id
+	ORDER BY wns.name
+	`
+	err = scanEach(rdb.Query, q, nil, func(scan func(...interface{}) error) error {
+		var wid int64
+		var n BundleNode
+		if err := scan(&n.ID, &wid, &n.Name, &n.State, &n.Type, &n.AccCtx, &n.Template); err != nil {
+			return err
+		}
+		if i, ok := widx[wid]; ok {
+			b.Workflows[i].Nodes = append(b.Workflows[i].Nodes, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// ImportAll reads a bundle written by `ExportAll`, and reconciles the
+// database with it, in the manner of `LoadConfig`: definitions
+// missing in the database are created, while existing ones that
+// differ are reported as drift, and left unaltered.  Permissions,
+// memberships and access context entries present only in the
+// database are left alone.
+//
+// Users are not part of the bundle; members are matched by e-mail
+// address.  Unknown users are reported as drift, and skipped, as are
+// the singleton groups of such users.
+//
+// N.B. Creating the storage tables of new document types commits the
+// transaction implicitly in MySQL.  Should a later definition fail,
+// the earlier ones remain.
+func ImportAll(otx *sql.Tx, r io.Reader) (*ImportReport, error) {
+	b, err := ReadBundle(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rep *ImportReport
+	err = withTx(otx, func(tx *sql.Tx) error {
+		im := &bundleImporter{
+			l:      newConfigLoader(tx),
+			groups: map[string]GroupID{},
+			roles:  map[string]RoleID{},
+			ids:    map[string]map[int64]int64{},
+		}
+		if err := im.load(b); err != nil {
+			return err
+		}
+		rep = &ImportReport{ConfigReport: *im.l.report, IDs: im.ids}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rep, nil
+}
+
+// ReadBundle reads a bundle written by `ExportAll`, e.g. to compare it
+// with another; see `Workflows.Diff`.
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	var b Bundle
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		return nil, err
+	}
+	if b.Version != bundleVersion {
+		return nil, errorf(CodeValidation, "unsupported bundle version : %d", b.Version)
+	}
+	return &b, nil
+}
+
+// bundleImporter reconciles a bundle within a transaction, building on
+// the configuration loader.
+type bundleImporter struct {
+	l      *configLoader
+	groups map[string]GroupID
+	roles  map[string]RoleID
+	ids    map[string]map[int64]int64
+}
+
+// mapID records the given mapping of identifiers of the given kind.
+func (im *bundleImporter) mapID(kind string, from, to int64) {
+	if from <= 0 {
+		return
+	}
+	m, ok := im.ids[kind]
+	if !ok {
+		m = map[int64]int64{}
+		im.ids[kind] = m
+	}
+	m[from] = to
+}
+
+// lookup answers the identifier selected by the given query, or `0`
+// if there is none.
+func (im *bundleImporter) lookup(q string, args ...interface{}) (int64, error) {
+	var id int64
+	err := im.l.tx.QueryRow(q, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// load reconciles the given bundle, in dependency order.
+func (im *bundleImporter) load(b *Bundle) error {
+	l := im.l
+	cfg := &Config{}
+	for _, s := range b.States {
+		cfg.States = append(cfg.States, s.Name)
+	}
+	for _, a := range b.Actions {
+		cfg.Actions = append(cfg.Actions, ConfigDocAction{Name: a.Name, Reconfirm: a.Reconfirm})
+	}
+	for _, dt := range b.DocTypes {
+		cfg.DocTypes = append(cfg.DocTypes, ConfigDocType{Name: dt.Name, Transitions: dt.Transitions})
+	}
+	if err := l.load(cfg); err != nil {
+		return err
+	}
+	for _, s := range b.States {
+		id, err := l.state(s.Name, false)
+		if err != nil {
+			return err
+		}
+		im.mapID("states", s.ID, int64(id))
+	}
+	for _, a := range b.Actions {
+		id, err := l.action(a.Name)
+		if err != nil {
+			return err
+		}
+		im.mapID("actions", a.ID, int64(id))
+	}
+	for _, dt := range b.DocTypes {
+		id, err := l.docType(dt.Name)
+		if err != nil {
+			return err
+		}
+		im.mapID("doctypes", dt.ID, int64(id))
+	}
+
+	for i := range b.Roles {
+		if err := im.defineRole(&b.Roles[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Groups {
+		if err := im.defineGroup(&b.Groups[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.AccCtxs {
+		if err := im.defineAccessContext(&b.AccCtxs[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Workflows {
+		if err := im.defineWorkflow(&b.Workflows[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// defineRole creates the given role and its permissions, if they are
+// missing.
+func (im *bundleImporter) defineRole(br *BundleRole) error {
+	l := im.l
+	name := strings.TrimSpace(br.Name)
+	id, err := im.lookup(`SELECT id FROM wf_roles_master WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	rid := RoleID(id)
+	if rid == 0 {
+		if rid, err = Roles.New(l.tx, name); err != nil {
+			return err
+		}
+		l.created("role %s", name)
+	}
+	im.roles[name] = rid
+	im.mapID("roles", br.ID, int64(rid))
+
+	for _, p := range br.Permissions {
+		dtid, err := l.docType(p.DocType)
+		if err != nil {
+			return err
+		}
+		daid, err := l.action(p.Action)
+		if err != nil {
+			return err
+		}
+		q := `SELECT id FROM wf_role_docactions WHERE role_id = ? AND doctype_id = ? AND docaction_id = ?`
+		id, err := im.lookup(q, rid, dtid, daid)
+		if err != nil {
+			return err
+		}
+		if id > 0 {
+			continue
+		}
+		if err = Roles.AddPermissions(l.tx, rid, dtid, []DocActionID{daid}); err != nil {
+			return err
+		}
+		l.created("permission %s : %s on %s", name, p.Action, p.DocType)
+	}
+	for _, dtname := range br.AllActions {
+		dtid, err := l.docType(dtname)
+		if err != nil {
+			return err
+		}
+		q := `SELECT doctype_id FROM wf_role_all_docactions WHERE role_id = ? AND doctype_id = ?`
+		id, err := im.lookup(q, rid, dtid)
+		if err != nil {
+			return err
+		}
+		if id > 0 {
+			continue
+		}
+		if err = Roles.GrantAllActions(l.tx, rid, dtid); err != nil {
+			return err
+		}
+		l.created("permission %s : all actions on %s", name, dtname)
+	}
+
+	return nil
+}
+
+// defineGroup creates the given group and its memberships, if they
+// are missing.
+func (im *bundleImporter) defineGroup(bg *BundleGroup) error {
+	l := im.l
+	name := strings.TrimSpace(bg.Name)
+
+	uids := []UserID{}
+	for _, email := range bg.Members {
+		uid, err := im.lookup(`SELECT id FROM wf_users_master WHERE email = ?`, email)
+		if err != nil {
+			return err
+		}
+		if uid == 0 {
+			l.drift("group %s : user %s is unknown", name, email)
+			continue
+		}
+		uids = append(uids, UserID(uid))
+	}
+
+	var gid GroupID
+	var gtype GroupType
+	err := l.tx.QueryRow(`SELECT id, COALESCE(group_type, `+GroupGeneral.sql()+`) FROM wf_groups_master WHERE name = ?`, name).Scan(&gid, &gtype)
+	switch {
+	case err == nil:
+		if gtype != GroupType(bg.Type) {
+			l.drift("group %s : type is %s in the database", name, gtype)
+		}
+
+	case err != sql.ErrNoRows:
+		return err
+
+	case GroupType(bg.Type) == GroupDynamic:
+		l.drift("dynamic group %s : skipped; define it using Groups.NewDynamic", name)
+		return nil
+
+	case GroupType(bg.Type) == GroupSingleton:
+		if len(uids) != 1 {
+			l.drift("singleton group %s : skipped, for want of its user", name)
+			return nil
+		}
+		if gid, err = Groups.NewSingleton(l.tx, uids[0]); err != nil {
+			return err
+		}
+		l.created("group %s", name)
+
+	default:
+		if gid, err = Groups.New(l.tx, name, GroupType(bg.Type)); err != nil {
+			return err
+		}
+		l.created("group %s", name)
+	}
+	im.groups[name] = gid
+	im.mapID("groups", bg.ID, int64(gid))
+	if gtype == "" { // Created above.
+		gtype = GroupType(bg.Type)
+	}
+	if !gtype.explicitMembers() {
+		return nil
+	}
+
+	for _, uid := range uids {
+		id, err := im.lookup(`SELECT id FROM wf_group_users WHERE group_id = ? AND user_id = ?`, gid, uid)
+		if err != nil {
+			return err
+		}
+		if id > 0 {
+			continue
+		}
+		if err = Groups.AddUser(l.tx, gid, uid); err != nil {
+			return err
+		}
+		l.created("membership %s : user %d", name, uid)
+	}
+
+	return nil
+}
+
+// defineAccessContext creates the given access context, its hierarchy
+// and its group roles, if they are missing.
+func (im *bundleImporter) defineAccessContext(bac *BundleAccessContext) error {
+	l := im.l
+	name := strings.TrimSpace(bac.Name)
+
+	var acid AccessContextID
+	var active bool
+	err := l.tx.QueryRow(`SELECT id, active FROM wf_access_contexts WHERE name = ?`, name).Scan(&acid, &active)
+	switch {
+	case err == nil:
+		if active != bac.Active {
+			l.drift("access context %s : active is %v in the database", name, active)
+		}
+
+	case err != sql.ErrNoRows:
+		return err
+
+	default:
+		if acid, err = AccessContexts.New(l.tx, name); err != nil {
+			return err
+		}
+		if !bac.Active {
+			if err = AccessContexts.SetActive(l.tx, acid, false); err != nil {
+				return err
+			}
+		}
+		l.created("access context %s", name)
+	}
+	im.mapID("accessContexts", bac.ID, int64(acid))
+
+	for _, g := range bac.Groups {
+		gid, ok := im.groups[g.Group]
+		if !ok {
+			l.drift("access context %s : group %s is unknown", name, g.Group)
+			continue
+		}
+		var reportsTo GroupID
+		if g.ReportsTo != "" {
+			if reportsTo, ok = im.groups[g.ReportsTo]; !ok {
+				l.drift("access context %s : group %s is unknown", name, g.ReportsTo)
+				continue
+			}
+		}
+
+		var cur GroupID
+		q := `SELECT reports_to FROM wf_ac_group_hierarchy WHERE ac_id = ? AND group_id = ?`
+		err = l.tx.QueryRow(q, acid, gid).Scan(&cur)
+		switch {
+		case err == nil:
+			if cur != reportsTo {
+				l.drift("access context %s : group %s reports elsewhere in the database", name, g.Group)
+			}
+
+		case err != sql.ErrNoRows:
+			return err
+
+		default:
+			if err = AccessContexts.AddGroup(l.tx, acid, gid, reportsTo); err != nil {
+				return err
+			}
+			l.created("access context %s : group %s", name, g.Group)
+		}
+	}
+
+	for _, gr := range bac.GroupRoles {
+		gid, ok := im.groups[gr.Group]
+		if !ok {
+			l.drift("access context %s : group %s is unknown", name, gr.Group)
+			continue
+		}
+		rid, ok := im.roles[gr.Role]
+		if !ok {
+			l.drift("access context %s : role %s is unknown", name, gr.Role)
+			continue
+		}
+		q := `SELECT id FROM wf_ac_group_roles WHERE ac_id = ? AND group_id = ? AND role_id = ?`
+		id, err := im.lookup(q, acid, gid, rid)
+		if err != nil {
+			return err
+		}
+		if id > 0 {
+			continue
+		}
+		if err = AccessContexts.AddGroupRole(l.tx, acid, gid, rid); err != nil {
+			return err
+		}
+		l.created("access context %s : group %s as %s", name, gr.Group, gr.Role)
+	}
+
+	return nil
+}
+
+// defineWorkflow creates the given workflow and its nodes, if they are
+// missing.
+func (im *bundleImporter) defineWorkflow(bw *BundleWorkflow) error {
+	l := im.l
+	active := bw.Active
+	cw := &ConfigWorkflow{Name: bw.Name, DocType: bw.DocType, Begin: bw.Begin, Active: &active}
+	for _, n := range bw.Nodes {
+		cw.Nodes = append(cw.Nodes, ConfigNode{
+			Name:     n.Name,
+			State:    n.State,
+			Type:     n.Type,
+			AccCtx:   n.AccCtx,
+			Template: n.Template,
+		})
+	}
+	if err := l.defineWorkflow(cw); err != nil {
+		return err
+	}
+
+	name := strings.TrimSpace(bw.Name)
+	wid, err := im.lookup(`SELECT id FROM wf_workflows WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	im.mapID("workflows", bw.ID, wid)
+	for _, n := range bw.Nodes {
+		q := `SELECT id FROM wf_workflow_nodes WHERE workflow_id = ? AND name = ?`
+		nid, err := im.lookup(q, wid, strings.TrimSpace(n.Name))
+		if err != nil {
+			return err
+		}
+		im.mapID("nodes", n.ID, nid)
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DiffChange enumerates the kinds of differences between two
+// definitions.
+type DiffChange string
+
+// Kinds of differences.
+const (
+	DiffAdded   DiffChange = "added"
+	DiffRemoved DiffChange = "removed"
+	DiffChanged DiffChange = "changed"
+)
+
+// DefinitionDelta is a single difference between two sets of workflow
+// definitions.
+type DefinitionDelta struct {
+	Kind   string     `json:"kind"`             // `state`, `transition`, `workflow` or `node`
+	Change DiffChange `json:"change"`           // Kind of difference
+	Name   string     `json:"name"`             // Identification of the definition
+	Field  string     `json:"field,omitempty"`  // Field that changed, if any
+	Before string     `json:"before,omitempty"` // Value of the field before
+	After  string     `json:"after,omitempty"`  // Value of the field after
+}
+
+// String answers a human-readable description of this difference.
+func (d *DefinitionDelta) String() string {
+	switch d.Change {
+	case DiffAdded:
+		return fmt.Sprintf("+ %s %s", d.Kind, d.Name)
+
+	case DiffRemoved:
+		return fmt.Sprintf("- %s %s", d.Kind, d.Name)
+
+	default:
+		return fmt.Sprintf("~ %s %s : %s %q -> %q", d.Kind, d.Name, d.Field, d.Before, d.After)
+	}
+}
+
+// WorkflowDiff is the set of differences between two sets of workflow
+// definitions, ordered by kind and name.
+type WorkflowDiff struct {
+	Deltas []*DefinitionDelta `json:"deltas"`
+}
+
+// Empty answers `true` if the definitions compared are equivalent.
+func (d *WorkflowDiff) Empty() bool {
+	return len(d.Deltas) == 0
+}
+
+// String answers a human-readable description of the differences,
+// one per line.
+func (d *WorkflowDiff) String() string {
+	lines := make([]string, 0, len(d.Deltas))
+	for _, delta := range d.Deltas {
+		lines = append(lines, delta.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Diff compares the document states, transitions, workflows and nodes
+// of the given bundles (see `ExportAll`), and answers what changes
+// from `a` to `b`.  Definitions are matched by name, so that bundles
+// of different environments compare meaningfully; identifiers are
+// ignored.
+func (_Workflows) Diff(a, b *Bundle) *WorkflowDiff {
+	d := &WorkflowDiff{Deltas: []*DefinitionDelta{}}
+
+	// States.
+	d.diffSets("state", bundleStates(a), bundleStates(b))
+
+	// Transitions, being identified by all their parts.
+	d.diffSets("transition", bundleTransitions(a), bundleTransitions(b))
+
+	// Workflows.
+	wa, wb := bundleWorkflows(a), bundleWorkflows(b)
+	sa, sb := map[string]struct{}{}, map[string]struct{}{}
+	for name := range wa {
+		sa[name] = struct{}{}
+	}
+	for name := range wb {
+		sb[name] = struct{}{}
+	}
+	d.diffSets("workflow", sa, sb)
+	for _, name := range sortedSet(sa) {
+		w, x := wa[name], wb[name]
+		if x == nil {
+			continue
+		}
+		d.diffField("workflow", name, "doctype", w.DocType, x.DocType)
+		d.diffField("workflow", name, "begin", w.Begin, x.Begin)
+		d.diffField("workflow", name, "active", fmt.Sprint(w.Active), fmt.Sprint(x.Active))
+	}
+
+	// Nodes.
+	na, nb := bundleNodes(a), bundleNodes(b)
+	sa, sb = map[string]struct{}{}, map[string]struct{}{}
+	for name := range na {
+		sa[name] = struct{}{}
+	}
+	for name := range nb {
+		sb[name] = struct{}{}
+	}
+	d.diffSets("node", sa, sb)
+	for _, name := range sortedSet(sa) {
+		n, x := na[name], nb[name]
+		if x == nil {
+			continue
+		}
+		d.diffField("node", name, "state", n.State, x.State)
+		d.diffField("node", name, "type", n.Type, x.Type)
+		d.diffField("node", name, "accessContext", n.AccCtx, x.AccCtx)
+		d.diffField("node", name, "template", n.Template, x.Template)
+	}
+
+	return d
+}
+
+// DiffAgainstDB compares the definitions in the database with those in
+// the given bundle, and answers what changes from the former to the
+// latter.  Reviewing this before `ImportAll` shows what a promotion of
+// configuration would change.  Note that `ImportAll` creates the
+// definitions added, but neither removes nor alters existing ones; it
+// reports those as drift.
+func (_Workflows) DiffAgainstDB(b *Bundle) (*WorkflowDiff, error) {
+	cur, err := exportBundle()
+	if err != nil {
+		return nil, err
+	}
+	return Workflows.Diff(cur, b), nil
+}
+
+// diffSets records the names present in only one of the given sets.
+func (d *WorkflowDiff) diffSets(kind string, a, b map[string]struct{}) {
+	for _, name := range sortedSet(a) {
+		if _, ok := b[name]; !ok {
+			d.Deltas = append(d.Deltas, &DefinitionDelta{Kind: kind, Change: DiffRemoved, Name: name})
+		}
+	}
+	for _, name := range sortedSet(b) {
+		if _, ok := a[name]; !ok {
+			d.Deltas = append(d.Deltas, &DefinitionDelta{Kind: kind, Change: DiffAdded, Name: name})
+		}
+	}
+}
+
+// diffField records a change in the given field, if any.
+func (d *WorkflowDiff) diffField(kind, name, field, before, after string) {
+	if before == after {
+		return
+	}
+	d.Deltas = append(d.Deltas, &DefinitionDelta{Kind: kind, Change: DiffChanged, Name: name,
+		Field: field, Before: before, After: after})
+}
+
+// bundleStates answers the names of the states in the given bundle.
+func bundleStates(b *Bundle) map[string]struct{} {
+	m := map[string]struct{}{}
+	for _, s := range b.States {
+		m[s.Name] = struct{}{}
+	}
+	return m
+}
+
+// bundleTransitions answers the transitions in the given bundle, each
+// described as `doctype : from -action-> to`.
+func bundleTransitions(b *Bundle) map[string]struct{} {
+	m := map[string]struct{}{}
+	for _, dt := range b.DocTypes {
+		for _, t := range dt.Transitions {
+			m[fmt.Sprintf("%s : %s -%s-> %s", dt.Name, t.From, t.Action, t.To)] = struct{}{}
+		}
+	}
+	return m
+}
+
+// bundleWorkflows answers the workflows in the given bundle, by name.
+func bundleWorkflows(b *Bundle) map[string]*BundleWorkflow {
+	m := map[string]*BundleWorkflow{}
+	for i := range b.Workflows {
+		m[b.Workflows[i].Name] = &b.Workflows[i]
+	}
+	return m
+}
+
+// bundleNodes answers the nodes in the given bundle, each named as
+// `workflow / node`.
+func bundleNodes(b *Bundle) map[string]*BundleNode {
+	m := map[string]*BundleNode{}
+	for _, w := range b.Workflows {
+		for i := range w.Nodes {
+			m[w.Name+" / "+w.Nodes[i].Name] = &w.Nodes[i]
+		}
+	}
+	return m
+}
+
+// sortedSet answers the members of the given set, in order.
+func sortedSet(set map[string]struct{}) []string {
+	ary := make([]string, 0, len(set))
+	for k := range set {
+		ary = append(ary, k)
+	}
+	sort.Strings(ary)
+	return ary
+}
@@ -0,0 +1,462 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// CapacityMode enumerates what happens when a capacity limit is
+// reached.
+type CapacityMode uint8
+
+const (
+	// CapacityReject : the request fails with `ErrCapacityExceeded`
+	CapacityReject CapacityMode = iota + 1
+	// CapacityQueue : the event waits until capacity frees up; node limits only
+	CapacityQueue
+	// CapacityNotify : the request proceeds, and an alert is recorded in the outbox
+	CapacityNotify
+)
+
+// capacityModes maps the capacity modes to their representations in
+// the database.
+var capacityModes = map[CapacityMode]string{
+	CapacityReject: "R",
+	CapacityQueue:  "Q",
+	CapacityNotify: "N",
+}
+
+// CapacityLimit caps the number of documents, for when the work
+// downstream has finite capacity -- e.g. laboratory slots.
+type CapacityLimit struct {
+	Max  int          `json:"Max"`  // Maximum number of documents
+	Mode CapacityMode `json:"Mode"` // What happens upon reaching the maximum
+}
+
+// CapacityAlert is the payload of the outbox entries of kind
+// `OutboxKindCapacity`, recorded when a limit in mode `CapacityNotify`
+// is exceeded.  Applications register the handler of that kind to
+// notify administrators.
+type CapacityAlert struct {
+	Node    NodeID          `json:"Node,omitempty"`   // Node whose limit was exceeded, if a node limit
+	DocType DocTypeID       `json:"DocType"`          // Type of the document
+	DocID   DocumentID      `json:"DocID,omitempty"`  // The document moving into the node, if a node limit
+	AccCtx  AccessContextID `json:"AccCtx,omitempty"` // Access context, if a workflow limit
+	Event   DocEventID      `json:"Event,omitempty"`  // Event exceeding the limit, if a node limit
+	Max     int             `json:"Max"`              // Limit in force
+	Count   int             `json:"Count"`            // Number of documents before the request
+	Ctime   time.Time       `json:"Ctime"`            // Time of the request
+}
+
+func init() {
+	registerTimer("capacity queue", drainCapacityQueues)
+}
+
+// validate checks this limit.
+func (l *CapacityLimit) validate(v *validator, queue bool) {
+	v.positive("Max", int64(l.Max))
+	if _, ok := capacityModes[l.Mode]; !ok {
+		v.fail("Mode", "unknown mode")
+	}
+	if l.Mode == CapacityQueue && !queue {
+		v.fail("Mode", "queueing is available for node limits only")
+	}
+}
+
+// scanCapacityLimit reads one limit using the given scanner.
+func scanCapacityLimit(scan func(...interface{}) error) (*CapacityLimit, error) {
+	var l CapacityLimit
+	var mode string
+	if err := scan(&l.Max, &mode); err != nil {
+		return nil, err
+	}
+	for m, str := range capacityModes {
+		if str == mode {
+			l.Mode = m
+		}
+	}
+	if l.Mode == 0 {
+		return nil, errorf(CodeInternal, "unknown capacity mode : %s", mode)
+	}
+	return &l, nil
+}
+
+// SetCapacity limits the number of documents simultaneously in the
+// state of the given node.  A `nil` limit removes it; events queued at
+// the node are then applied by the timer pump.
+func (_Nodes) SetCapacity(otx *sql.Tx, id NodeID, l *CapacityLimit) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	if l != nil {
+		l.validate(&v, true)
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if l == nil {
+			_, err := tx.Exec("DELETE FROM wf_node_capacities WHERE node_id = ?", id)
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_node_capacities(node_id, max_docs, mode)
+		VALUES(?, ?, ?)
+		ON DUPLICATE KEY UPDATE max_docs = VALUES(max_docs), mode = VALUES(mode)
+		`
+		_, err := tx.Exec(q, id, l.Max, capacityModes[l.Mode])
+		return err
+	})
+}
+
+// Capacity answers the capacity limit of the given node, if any; `nil`
+// otherwise.
+func (_Nodes) Capacity(id NodeID) (*CapacityLimit, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	row := readDB().QueryRow("SELECT max_docs, mode FROM wf_node_capacities WHERE node_id = ?", id)
+	l, err := scanCapacityLimit(row.Scan)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetCapacity limits the number of documents of the given workflow's
+// type that can be in flight -- i.e. not in the state of an end node --
+// in each access context.  `Documents.New` enforces the limit; mode
+// `CapacityQueue` is not available.  A `nil` limit removes it.
+func (_Workflows) SetCapacity(otx *sql.Tx, id WorkflowID, l *CapacityLimit) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("workflow ID", int64(id))
+	if l != nil {
+		l.validate(&v, false)
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if l == nil {
+			_, err := tx.Exec("DELETE FROM wf_workflow_capacities WHERE workflow_id = ?", id)
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_workflow_capacities(workflow_id, max_docs, mode)
+		VALUES(?, ?, ?)
+		ON DUPLICATE KEY UPDATE max_docs = VALUES(max_docs), mode = VALUES(mode)
+		`
+		_, err := tx.Exec(q, id, l.Max, capacityModes[l.Mode])
+		return err
+	})
+}
+
+// Capacity answers the per-access context capacity limit of the given
+// workflow, if any; `nil` otherwise.
+func (_Workflows) Capacity(id WorkflowID) (*CapacityLimit, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "workflow ID must be a positive integer")
+	}
+
+	row := readDB().QueryRow("SELECT max_docs, mode FROM wf_workflow_capacities WHERE workflow_id = ?", id)
+	l, err := scanCapacityLimit(row.Scan)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	return l, nil
+}
+
+// checkContextCapacity enforces the per-access context limit of the
+// active workflow of the given type, if any, upon the creation of a
+// document in the given access context.
+func checkContextCapacity(tx *sql.Tx, dtype DocTypeID, acid AccessContextID) error {
+	// Locking the limit serialises the creation of documents that it
+	// governs.
+	q := `
+	SELECT wc.max_docs, wc.mode
+	FROM wf_workflow_capacities wc
+	JOIN wf_workflows wfs ON wfs.id = wc.workflow_id
+	WHERE wfs.doctype_id = ?
+	AND wfs.active = 1
+	FOR UPDATE
+	`
+	l, err := scanCapacityLimit(tx.QueryRow(q, dtype).Scan)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+
+	case err != nil:
+		return err
+	}
+
+	q = `
+	SELECT COUNT(*)
+	FROM ` + DocTypes.docStorName(dtype) + ` docs
+	WHERE docs.ac_id = ?
+	AND docs.path = ''
+	AND docs.docstate_id NOT IN (
+		SELECT docstate_id
+		FROM wf_workflow_nodes
+		WHERE doctype_id = ?
+		AND type = 'end'
+	)
+	`
+	var count int
+	if err = tx.QueryRow(q, acid, dtype).Scan(&count); err != nil {
+		return err
+	}
+	if count < l.Max {
+		return nil
+	}
+
+	if l.Mode != CapacityNotify {
+		return ErrCapacityExceeded
+	}
+	writeLog(LogWarn, "capacity exceeded", F("doctype", dtype), F("ac", acid), F("max", l.Max))
+	alert := &CapacityAlert{DocType: dtype, AccCtx: acid, Max: l.Max, Count: count, Ctime: time.Now()}
+	_, err = Outbox.Enqueue(tx, OutboxKindCapacity, alert)
+	return err
+}
+
+// checkNodeCapacity enforces the limit of the given node, if any, upon
+// the given event moving a document into it.  It answers `true` if the
+// event has been queued, in which case it should not be applied now.
+//
+// Events queued earlier at the node are served first: a later event is
+// queued behind them even if capacity is available.
+func checkNodeCapacity(otx *sql.Tx, n *Node, event *DocEvent, recipients []GroupID) (bool, error) {
+	// Locking the limit serialises the transitions that it governs.
+	row, err := stmts.queryRow(otx, "SELECT max_docs, mode FROM wf_node_capacities WHERE node_id = ? FOR UPDATE", n.ID)
+	if err != nil {
+		return false, err
+	}
+	l, err := scanCapacityLimit(row.Scan)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+
+	case err != nil:
+		return false, err
+	}
+
+	q := `
+	SELECT COUNT(*)
+	FROM wf_capacity_queue
+	WHERE node_id = ?
+	AND status = 'Q'
+	AND docevent_id < ?
+	`
+	if row, err = stmts.queryRow(otx, q, n.ID, event.ID); err != nil {
+		return false, err
+	}
+	var queued int
+	if err = row.Scan(&queued); err != nil {
+		return false, err
+	}
+
+	q = `SELECT COUNT(*) FROM ` + DocTypes.docStorName(n.DocType) + ` WHERE docstate_id = ? AND path = ''`
+	if row, err = stmts.queryRow(otx, q, n.State); err != nil {
+		return false, err
+	}
+	var count int
+	if err = row.Scan(&count); err != nil {
+		return false, err
+	}
+	if count < l.Max && (queued == 0 || l.Mode != CapacityQueue) {
+		return false, nil
+	}
+
+	switch l.Mode {
+	case CapacityReject:
+		return false, ErrCapacityExceeded
+
+	case CapacityNotify:
+		writeLog(LogWarn, "capacity exceeded", F("node", n.ID), F("event", event.ID), F("max", l.Max))
+		alert := &CapacityAlert{Node: n.ID, DocType: n.DocType, DocID: event.DocID, Event: event.ID, Max: l.Max,
+			Count: count, Ctime: time.Now()}
+		_, err = Outbox.Enqueue(otx, OutboxKindCapacity, alert)
+		return false, err
+	}
+
+	bs, err := json.Marshal(recipients)
+	if err != nil {
+		return false, err
+	}
+	q = `
+	INSERT INTO wf_capacity_queue(node_id, docevent_id, doctype_id, doc_id, recipients, status, ctime, mtime)
+	VALUES(?, ?, ?, ?, ?, 'Q', NOW(), NOW())
+	`
+	_, err = stmts.exec(otx, q, n.ID, event.ID, event.DocType, event.DocID, string(bs))
+	return err == nil, err
+}
+
+// drainCapacityQueues is the timer task of node capacity limits.  It
+// applies the events queued at nodes, in the order in which they were
+// queued, as capacity frees up.
+func drainCapacityQueues(ctx context.Context) (int, error) {
+	q := `
+	SELECT id
+	FROM wf_capacity_queue
+	WHERE status = 'Q'
+	ORDER BY docevent_id
+	LIMIT 100
+	`
+	ids := []int64{}
+	err := scanEach(db.Query, q, nil, func(scan func(...interface{}) error) error {
+		var id int64
+		if err := scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	full := map[NodeID]bool{}
+	for _, id := range ids {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		var nid NodeID
+		var ok bool
+		err = RetryTx(func(tx *sql.Tx) error {
+			var err error
+			nid, ok, err = dequeueEvent(tx, id, full)
+			return err
+		})
+		switch CodeOf(err) {
+		case 0:
+			if ok {
+				n++
+			}
+
+		case CodeNotFound, CodeValidation, CodePermissionDenied, CodeConflict:
+			// The event can no longer be applied, e.g. because the
+			// document has moved on meanwhile.
+			writeLog(LogWarn, "queued event dropped", F("id", id), F("node", nid), F("error", err))
+			_, err = db.Exec("UPDATE wf_capacity_queue SET status = 'X', mtime = NOW() WHERE id = ? AND status = 'Q'", id)
+			if err != nil {
+				return n, err
+			}
+
+		default:
+			return n, err
+		}
+	}
+	if n > 0 {
+		Outbox.Notify()
+	}
+
+	return n, nil
+}
+
+// dequeueEvent applies the given queued event, should its node have
+// capacity.  Nodes found full are noted in `full`, so that the events
+// queued behind are skipped in this round.  It answers `true` if the
+// event was applied.
+func dequeueEvent(tx *sql.Tx, id int64, full map[NodeID]bool) (NodeID, bool, error) {
+	q := `
+	SELECT node_id, docevent_id, recipients
+	FROM wf_capacity_queue
+	WHERE id = ?
+	AND status = 'Q'
+	FOR UPDATE
+	`
+	var nid NodeID
+	var eid DocEventID
+	var recv string
+	err := tx.QueryRow(q, id).Scan(&nid, &eid, &recv)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+
+	case err != nil:
+		return 0, false, err
+	}
+	if full[nid] {
+		return nid, false, nil
+	}
+
+	n, err := Nodes.Get(nid)
+	if err != nil {
+		return nid, false, err
+	}
+	l, err := scanCapacityLimit(tx.QueryRow("SELECT max_docs, mode FROM wf_node_capacities WHERE node_id = ? FOR UPDATE", nid).Scan)
+	if err != nil && err != sql.ErrNoRows {
+		return nid, false, err
+	}
+	if l != nil {
+		var count int
+		q = `SELECT COUNT(*) FROM ` + DocTypes.docStorName(n.DocType) + ` WHERE docstate_id = ? AND path = ''`
+		if err = tx.QueryRow(q, n.State).Scan(&count); err != nil {
+			return nid, false, err
+		}
+		if count >= l.Max {
+			full[nid] = true
+			return nid, false, nil
+		}
+	}
+
+	event, err := DocEvents.Get(eid)
+	if err != nil {
+		return nid, false, err
+	}
+	var recipients []GroupID
+	if err = json.Unmarshal([]byte(recv), &recipients); err != nil {
+		return nid, false, err
+	}
+	w, err := Workflows.GetByDocType(event.DocType)
+	if err != nil {
+		return nid, false, err
+	}
+
+	// The queue entry is marked done first, so that the capacity
+	// check of the application does not count it.  Being the oldest
+	// at its node, the event is not queued again.
+	if _, err = tx.Exec("UPDATE wf_capacity_queue SET status = 'D', mtime = NOW() WHERE id = ?", id); err != nil {
+		return nid, false, err
+	}
+	if _, err = w.ApplyEvent(tx, event, recipients); err != nil {
+		return nid, false, err
+	}
+	return nid, true, nil
+}
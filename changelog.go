@@ -0,0 +1,155 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// changeLogPageSize is the maximum number of changes answered by one
+// call to `ChangesSince`.
+const changeLogPageSize = 500
+
+// ChangeKind enumerates the kinds of mutations recorded in the change
+// log of documents.
+type ChangeKind uint8
+
+const (
+	// ChangeCreated : the document was created
+	ChangeCreated ChangeKind = iota + 1
+	// ChangeData : the data of the document was replaced
+	ChangeData
+	// ChangeTitle : the title of the document was replaced
+	ChangeTitle
+	// ChangeTags : tags were added to, or removed from, the document
+	ChangeTags
+	// ChangeBlobs : a blob was added to, or removed from, the document
+	ChangeBlobs
+	// ChangeState : the document moved into a new state
+	ChangeState
+)
+
+// DocumentChange is one entry in the change log of a document.
+//
+// `Delta` is a JSON object describing the mutation.  Its fields depend
+// on the kind of the change:
+//
+// - `ChangeCreated`: `title`, `data`, `state` and `ac`;
+// - `ChangeData`: `data`;
+// - `ChangeTitle`: `title`;
+// - `ChangeTags`: `added` or `removed`, each a list of tags;
+// - `ChangeBlobs`: `added` or `removed`, each a blob given by its
+// `name` and `sha1`; and
+// - `ChangeState`: `from`, `to` and `ac`.
+type DocumentChange struct {
+	Cursor  int64           `json:"Cursor"`  // Position of this change in the log
+	DocType DocTypeID       `json:"DocType"` // Type of the changed document
+	DocID   DocumentID      `json:"DocID"`   // The changed document
+	Kind    ChangeKind      `json:"Kind"`    // Kind of the change
+	Delta   json.RawMessage `json:"Delta"`   // Description of the change
+	Ctime   time.Time       `json:"Ctime"`   // Time of the change
+}
+
+var changeLogMu sync.RWMutex
+var changeLogTypes = map[DocTypeID]bool{}
+
+// SetChangeLog enables, or disables, the change log of documents of
+// the given type.  While enabled, every mutation to such a document --
+// its data, title, tags, blobs or state -- is appended to the log, in
+// the same transaction as the mutation itself.
+//
+// The change log is disabled by default.  Mutations made while it is
+// disabled are not recorded.
+func (_Documents) SetChangeLog(dtype DocTypeID, enabled bool) {
+	changeLogMu.Lock()
+	defer changeLogMu.Unlock()
+
+	if !enabled {
+		delete(changeLogTypes, dtype)
+		return
+	}
+	changeLogTypes[dtype] = true
+}
+
+// changeLogEnabled answers `true` if the change log of documents of
+// the given type is enabled.
+func changeLogEnabled(dtype DocTypeID) bool {
+	changeLogMu.RLock()
+	defer changeLogMu.RUnlock()
+
+	return changeLogTypes[dtype]
+}
+
+// recordChange appends the given change to the log, if the log is
+// enabled for the document type.
+func (_Documents) recordChange(otx *sql.Tx, dtype DocTypeID, id DocumentID, kind ChangeKind, delta interface{}) error {
+	if !changeLogEnabled(dtype) {
+		return nil
+	}
+
+	bs, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	q := `
+	INSERT INTO wf_document_changes(doctype_id, doc_id, kind, delta, ctime)
+	VALUES(?, ?, ?, ?, NOW())
+	`
+	_, err = stmts.exec(otx, q, dtype, id, kind, string(bs))
+	return err
+}
+
+// ChangesSince answers the changes to the given document -- or to all
+// the documents of the given type, if the document is `0` -- recorded
+// after the given cursor, in the order in which they were made.
+//
+// A cursor of `0` answers the log from its beginning.  At most
+// `changeLogPageSize` changes are answered per call; consumers should
+// call again with the cursor of the last change answered, until no
+// changes are answered.
+func (_Documents) ChangesSince(dtype DocTypeID, id DocumentID, cursor int64) ([]*DocumentChange, error) {
+	if dtype <= 0 || id < 0 || cursor < 0 {
+		return nil, newError(CodeValidation, "document type should be a positive integer, and document and cursor non-negative")
+	}
+
+	q := `
+	SELECT id, doctype_id, doc_id, kind, delta, ctime
+	FROM wf_document_changes
+	WHERE doctype_id = ?
+	AND (? = 0 OR doc_id = ?)
+	AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+	ary := make([]*DocumentChange, 0, 8)
+	err := scanEach(readDB().Query, q, []interface{}{dtype, id, id, cursor, changeLogPageSize}, func(scan func(...interface{}) error) error {
+		var elem DocumentChange
+		var delta string
+		if err := scan(&elem.Cursor, &elem.DocType, &elem.DocID, &elem.Kind, &delta, &elem.Ctime); err != nil {
+			return err
+		}
+		elem.Delta = json.RawMessage(delta)
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
@@ -0,0 +1,62 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/js-ojus/flow"
+)
+
+// runDefine reconciles the database with the given YAML (or JSON)
+// configuration, using `flow.LoadConfig`.  See `flow.Config` for the
+// structure of the file.
+func runDefine(db *sql.DB, args []string) error {
+	fs := newFlagSet("define", "")
+	file := fs.String("f", "", "YAML or JSON file of definitions (required)")
+	strict := fs.Bool("strict", false, "fail if the database has drifted from the file")
+	fs.Parse(args)
+	if *file == "" {
+		fs.Usage()
+		return errors.New("-f is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rep, err := flow.LoadConfig(nil, f)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range rep.Created {
+		fmt.Printf("created  %s\n", s)
+	}
+	for _, s := range rep.Drift {
+		fmt.Printf("drift    %s\n", s)
+	}
+	fmt.Printf("%d definition(s) created, %d drift(s) found\n", len(rep.Created), len(rep.Drift))
+
+	if *strict && len(rep.Drift) > 0 {
+		return errors.New("database has drifted from the configuration")
+	}
+	return nil
+}
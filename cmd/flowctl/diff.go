@@ -0,0 +1,87 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/js-ojus/flow"
+)
+
+// runDiff shows the differences in workflow definitions between the
+// database and a bundle, or between two bundles.
+func runDiff(db *sql.DB, args []string) error {
+	fs := newFlagSet("diff", "[from.json] to.json")
+	asJSON := fs.Bool("json", false, "write the differences as JSON")
+	fs.Parse(args)
+
+	var d *flow.WorkflowDiff
+	switch fs.NArg() {
+	case 1:
+		b, err := readBundle(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if d, err = flow.Workflows.DiffAgainstDB(b); err != nil {
+			return err
+		}
+
+	case 2:
+		a, err := readBundle(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		b, err := readBundle(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		d = flow.Workflows.Diff(a, b)
+
+	default:
+		fs.Usage()
+		return errors.New("one or two bundles are required")
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	if d.Empty() {
+		fmt.Println("no differences")
+		return nil
+	}
+	fmt.Println(d)
+	return nil
+}
+
+// readBundle reads the bundle in the given file.
+func readBundle(fn string) (*flow.Bundle, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := flow.ReadBundle(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %v", fn, err)
+	}
+	return b, nil
+}
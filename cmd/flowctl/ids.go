@@ -0,0 +1,41 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/js-ojus/flow"
+)
+
+// runIDs prepares the database for application-generated identifiers.
+// With `-widen`, it widens the identifiers of the existing document
+// types to `BIGINT`.
+func runIDs(db *sql.DB, args []string) error {
+	fs := newFlagSet("ids", "")
+	widen := fs.Bool("widen", false, "widen the identifiers of the existing document types")
+	fs.Parse(args)
+
+	if !*widen {
+		fs.Usage()
+		return nil
+	}
+	if err := flow.WidenDocumentIDs(); err != nil {
+		return err
+	}
+	fmt.Println("document identifiers widened")
+	return nil
+}
@@ -0,0 +1,160 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command flowctl performs administrative tasks on a `flow` database.
+//
+// Usage:
+//
+//     flowctl [-dsn DSN] [-tenant ID] <command> [arguments]
+//
+// The commands are:
+//
+//     define     reconcile the database with a YAML or JSON file of
+//                document types, states, actions, transitions,
+//                workflows and nodes
+//     workflows  list the workflows, or describe one of them
+//     diff       show the differences in workflow definitions between
+//                the database and a bundle, or between two bundles
+//     stuck      list documents that have not progressed in a while
+//     outbox     list outbox entries, or replay some of them
+//     migrate    apply pending schema migrations
+//     tenancy    show or enable multi-tenant mode
+//     ids        prepare the database for application-generated
+//                identifiers
+//
+// The data source name defaults to the value of the environment
+// variable `FLOW_DSN`.  It should be a DSN understood by
+// `github.com/go-sql-driver/mysql`; `parseTime=true` is added if it
+// is missing.  In multi-tenant mode, commands act on behalf of the
+// tenant given by `-tenant`, or of the default tenant otherwise.
+//
+// Run `flowctl <command> -h` for the arguments of a command.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/js-ojus/flow"
+)
+
+// command is a single sub-command of flowctl.
+type command struct {
+	name  string
+	short string
+	run   func(db *sql.DB, args []string) error
+}
+
+var commands = []*command{
+	{"define", "reconcile the database with a YAML or JSON file of definitions", runDefine},
+	{"workflows", "list the workflows, or describe one of them", runWorkflows},
+	{"diff", "show the differences in workflow definitions of bundles", runDiff},
+	{"stuck", "list documents that have not progressed in a while", runStuck},
+	{"outbox", "list outbox entries, or replay some of them", runOutbox},
+	{"migrate", "apply pending schema migrations", runMigrate},
+	{"tenancy", "show or enable multi-tenant mode", runTenancy},
+	{"ids", "prepare the database for application-generated identifiers", runIDs},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: flowctl [-dsn DSN] [-tenant ID] <command> [arguments]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "    %-10s %s\n", c.name, c.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	dsn := flag.String("dsn", os.Getenv("FLOW_DSN"), "MySQL data source name")
+	tenant := flag.Int64("tenant", 0, "tenant on whose behalf to act, in multi-tenant mode")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.name == flag.Arg(0) {
+			cmd = c
+			break
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "flowctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if *dsn == "" {
+		fatalf("a data source name is required; use -dsn or FLOW_DSN")
+	}
+	if *tenant != 0 {
+		*dsn = flow.TenantDSN(*dsn, flow.TenantID(*tenant))
+	}
+	db, err := openDB(*dsn)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer db.Close()
+
+	if err = cmd.run(db, flag.Args()[1:]); err != nil {
+		fatalf("%s: %v", cmd.name, err)
+	}
+}
+
+// openDB connects to the given database, and registers it with
+// `flow`.
+func openDB(dsn string) (*sql.DB, error) {
+	if !strings.Contains(dsn, "parseTime=") {
+		if strings.Contains(dsn, "?") {
+			dsn += "&parseTime=true"
+		} else {
+			dsn += "?parseTime=true"
+		}
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	flow.RegisterDB(db)
+	return db, nil
+}
+
+// newFlagSet answers a flag set for the named command, whose usage
+// line mentions the given positional arguments.
+func newFlagSet(name, positional string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: flowctl %s [flags] %s\n", name, positional)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "flowctl: "+format+"\n", args...)
+	os.Exit(1)
+}
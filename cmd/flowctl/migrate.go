@@ -0,0 +1,156 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runMigrate applies the migration files in a directory, in the order
+// of their names, skipping those already recorded in
+// `wf_schema_migrations`.
+//
+// Statements in a migration file are separated by semicolons at the
+// ends of lines.  MySQL commits data definition statements
+// implicitly; a migration that fails midway may, therefore, need to
+// be completed by hand before running `migrate` again.
+//
+// Databases created afresh using `setup_db.sh` already have the
+// current schema; record the migrations as applied, without running
+// them, using `-baseline`.
+func runMigrate(db *sql.DB, args []string) error {
+	fs := newFlagSet("migrate", "")
+	dir := fs.String("dir", "sql/migrations", "directory of migration files")
+	baseline := fs.Bool("baseline", false, "record all migrations as applied, without running them")
+	status := fs.Bool("status", false, "only list the migrations and whether they are applied")
+	fs.Parse(args)
+
+	q := `
+	CREATE TABLE IF NOT EXISTS wf_schema_migrations (
+		name VARCHAR(250) NOT NULL,
+		ctime TIMESTAMP NOT NULL,
+		PRIMARY KEY (name)
+	)
+	`
+	if _, err := db.Exec(q); err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(*dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	applied := map[string]bool{}
+	rows, err := db.Query(`SELECT name FROM wf_schema_migrations`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return err
+		}
+		applied[name] = true
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	n := 0
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".sql")
+		switch {
+		case *status:
+			mark := "pending"
+			if applied[name] {
+				mark = "applied"
+			}
+			fmt.Printf("%-8s %s\n", mark, name)
+			continue
+
+		case applied[name]:
+			continue
+		}
+
+		if !*baseline {
+			fmt.Printf("applying %s\n", name)
+			if err = applyMigration(db, f); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		_, err = db.Exec(`INSERT INTO wf_schema_migrations(name, ctime) VALUES(?, NOW())`, name)
+		if err != nil {
+			return err
+		}
+		n++
+	}
+
+	if !*status {
+		fmt.Printf("%d migration(s) recorded\n", n)
+	}
+	return nil
+}
+
+// applyMigration executes the statements in the given file, within a
+// transaction.
+func applyMigration(db *sql.DB, path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(string(buf)) {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// splitStatements answers the statements in the given SQL text, with
+// comment lines removed.
+func splitStatements(text string) []string {
+	stmts := []string{}
+	var cur []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur = append(cur, line)
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(strings.Join(cur, "\n")), ";"))
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		stmts = append(stmts, strings.Join(cur, "\n"))
+	}
+	return stmts
+}
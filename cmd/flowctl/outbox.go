@@ -0,0 +1,145 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/js-ojus/flow"
+)
+
+var outboxStatuses = map[string]flow.OutboxStatus{
+	"pending": flow.OutboxStatusPending,
+	"done":    flow.OutboxStatusDone,
+	"failed":  flow.OutboxStatusFailed,
+}
+
+func outboxStatusName(s flow.OutboxStatus) string {
+	for name, st := range outboxStatuses {
+		if st == s {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+func runOutbox(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: flowctl outbox list|replay [flags] [ID ...]")
+	}
+
+	switch args[0] {
+	case "list":
+		return listOutbox(args[1:])
+
+	case "replay":
+		return replayOutbox(db, args[1:])
+
+	default:
+		return fmt.Errorf("unknown outbox command %q", args[0])
+	}
+}
+
+// listOutbox prints the outbox entries matching the given filters.
+func listOutbox(args []string) error {
+	fs := newFlagSet("outbox list", "")
+	kind := fs.String("kind", "", "list only entries of this kind")
+	status := fs.String("status", "failed", "list only entries in this status: pending, done, failed or all")
+	offset := fs.Int64("offset", 0, "list entries from this ID onwards")
+	limit := fs.Int64("limit", 100, "maximum number of entries to list")
+	payload := fs.Bool("payload", false, "print the payloads of the entries")
+	fs.Parse(args)
+
+	input := &flow.OutboxListInput{Kind: *kind}
+	if *status != "all" {
+		st, ok := outboxStatuses[*status]
+		if !ok {
+			return fmt.Errorf("unknown status %q", *status)
+		}
+		input.Status = st
+	}
+
+	ary, err := flow.Outbox.List(input, *offset, *limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tSTATUS\tATTEMPTS\tCREATED\tLAST ERROR")
+	for _, e := range ary {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", e.ID, e.Kind, outboxStatusName(e.Status),
+			e.Attempts, e.Ctime.Format(time.RFC3339), e.LastError)
+		if *payload {
+			fmt.Fprintf(w, "\t%s\n", e.Payload)
+		}
+	}
+	return w.Flush()
+}
+
+// replayOutbox resets the given entries, or all the failed entries
+// (of a kind), for the relay to dispatch again.  All the entries are
+// reset in a single transaction.
+func replayOutbox(db *sql.DB, args []string) error {
+	fs := newFlagSet("outbox replay", "[ID ...]")
+	failed := fs.Bool("failed", false, "replay all failed entries")
+	kind := fs.String("kind", "", "with -failed, replay only entries of this kind")
+	fs.Parse(args)
+
+	ids := []flow.OutboxEntryID{}
+	for _, arg := range fs.Args() {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid entry ID %q", arg)
+		}
+		ids = append(ids, flow.OutboxEntryID(n))
+	}
+	if *failed {
+		ary, err := flow.Outbox.List(&flow.OutboxListInput{Kind: *kind, Status: flow.OutboxStatusFailed}, 0, 0)
+		if err != nil {
+			return err
+		}
+		for _, e := range ary {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 {
+		fmt.Println("nothing to replay")
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if err = flow.Outbox.Replay(tx, id); err != nil {
+			return fmt.Errorf("entry %d: %v", id, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d entry(ies) queued for dispatch\n", len(ids))
+	return nil
+}
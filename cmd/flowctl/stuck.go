@@ -0,0 +1,103 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/js-ojus/flow"
+)
+
+// runStuck lists the documents of a type that are in a state from
+// which they can transition, but on which no event has been applied
+// for at least the given duration.  Documents without applied events
+// are judged by their creation time.
+func runStuck(db *sql.DB, args []string) error {
+	fs := newFlagSet("stuck", "")
+	dtName := fs.String("doctype", "", "name of the document type (required)")
+	ac := fs.Int64("ac", 0, "restrict to this access context")
+	older := fs.Duration("older", 72*time.Hour, "minimum time since the last applied event")
+	limit := fs.Int64("limit", 100, "maximum number of documents to list")
+	fs.Parse(args)
+	if *dtName == "" {
+		fs.Usage()
+		return errors.New("-doctype is required")
+	}
+
+	dt, err := flow.DocTypes.GetByName(*dtName)
+	if err != nil {
+		if err == flow.ErrDocTypeNotFound {
+			return fmt.Errorf("unknown document type %q", *dtName)
+		}
+		return err
+	}
+
+	// N.B. This mirrors the naming of document storage tables in
+	// `flow`.
+	tbl := fmt.Sprintf("wf_documents_%03d", dt.ID)
+	q := `
+	SELECT docs.id, docs.ac_id, dsm.name, docs.title, COALESCE(MAX(des.ctime), docs.ctime) AS last
+	FROM ` + tbl + ` docs
+	JOIN wf_docstates_master dsm ON dsm.id = docs.docstate_id
+	LEFT JOIN wf_docevents des ON des.doctype_id = ? AND des.doc_id = docs.id AND des.status = 'A'
+	WHERE EXISTS (
+		SELECT 1
+		FROM wf_docstate_transitions dst
+		WHERE dst.doctype_id = ?
+		AND dst.from_state_id = docs.docstate_id
+	)
+	`
+	qargs := []interface{}{dt.ID, dt.ID}
+	if *ac > 0 {
+		q += `AND docs.ac_id = ?
+		`
+		qargs = append(qargs, *ac)
+	}
+	q += `
+	GROUP BY docs.id, docs.ac_id, dsm.name, docs.title, docs.ctime
+	HAVING last < ?
+	ORDER BY last
+	LIMIT ?
+	`
+	qargs = append(qargs, time.Now().Add(-*older), *limit)
+
+	rows, err := db.Query(q, qargs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tACCESS CONTEXT\tSTATE\tIDLE SINCE\tTITLE")
+	for rows.Next() {
+		var id, acid int64
+		var state string
+		var title sql.NullString
+		var last time.Time
+		if err = rows.Scan(&id, &acid, &state, &title, &last); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", id, acid, state, last.Format(time.RFC3339), title.String)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
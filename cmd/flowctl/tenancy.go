@@ -0,0 +1,48 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/js-ojus/flow"
+)
+
+// runTenancy reports whether multi-tenant mode is enabled, and the
+// tenant on whose behalf flowctl acts.  With `-enable`, it converts
+// the database to multi-tenant mode.
+func runTenancy(db *sql.DB, args []string) error {
+	fs := newFlagSet("tenancy", "")
+	enable := fs.Bool("enable", false, "convert the database to multi-tenant mode")
+	fs.Parse(args)
+
+	if *enable {
+		if err := flow.EnableTenancy(); err != nil {
+			return err
+		}
+	}
+
+	on, err := flow.TenancyEnabled()
+	if err != nil {
+		return err
+	}
+	tid, err := flow.CurrentTenant()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("multi-tenant mode: %v\ntenant: %d\n", on, tid)
+	return nil
+}
@@ -0,0 +1,142 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/js-ojus/flow"
+)
+
+func runWorkflows(db *sql.DB, args []string) error {
+	fs := newFlagSet("workflows", "[name]")
+	prefix := fs.String("prefix", "", "list only the workflows whose names begin with this")
+	active := fs.Bool("active", false, "list only the active workflows")
+	fs.Parse(args)
+
+	switch fs.NArg() {
+	case 0:
+		input := &flow.WorkflowsListInput{NamePrefix: *prefix}
+		if *active {
+			input.Active = active
+		}
+		return listWorkflows(input)
+
+	case 1:
+		return describeWorkflow(fs.Arg(0))
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+}
+
+// listWorkflows prints a summary of the workflows meeting the given
+// criteria.
+func listWorkflows(input *flow.WorkflowsListInput) error {
+	wfs, err := flow.Workflows.Search(input, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tDOCTYPE\tBEGIN STATE\tACTIVE\tNODES\tCOVERED")
+	for _, wf := range wfs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%v\t%d\t%v\n", wf.ID, wf.Name, wf.DocType.Name, wf.BeginState.Name, wf.Active,
+			wf.Nodes, wf.Covered)
+	}
+	return w.Flush()
+}
+
+// describeWorkflow prints the nodes of the named workflow, and the
+// transitions of its document type.
+func describeWorkflow(name string) error {
+	wf, err := flow.Workflows.GetByName(name)
+	if err != nil {
+		if err == flow.ErrWorkflowNotFound {
+			return fmt.Errorf("unknown workflow %q", name)
+		}
+		return err
+	}
+	nodes, err := flow.Nodes.List(wf.ID)
+	if err != nil {
+		return err
+	}
+	tms, err := flow.DocTypes.Transitions(wf.DocType.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Workflow    : %s (%d)\n", wf.Name, wf.ID)
+	fmt.Printf("Document    : %s (%d)\n", wf.DocType.Name, wf.DocType.ID)
+	fmt.Printf("Begins in   : %s\n", wf.BeginState.Name)
+	fmt.Printf("Active      : %v\n\n", wf.Active)
+
+	states := map[flow.DocStateID]string{}
+	for _, tm := range tms {
+		states[tm.From.ID] = tm.From.Name
+		for _, t := range tm.Transitions {
+			states[t.To.ID] = t.To.Name
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tTYPE\tSTATE\tACCESS CONTEXT\tTEMPLATE")
+	mapped := map[flow.DocStateID]bool{}
+	for _, n := range nodes {
+		mapped[n.State] = true
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", n.Name, n.NodeType, states[n.State], n.AccCtx, n.Template)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FROM\tACTION\tTO")
+	froms := make([]flow.DocStateID, 0, len(tms))
+	for id := range tms {
+		froms = append(froms, id)
+	}
+	sort.Slice(froms, func(i, j int) bool { return froms[i] < froms[j] })
+	for _, id := range froms {
+		tm := tms[id]
+		acts := make([]flow.DocActionID, 0, len(tm.Transitions))
+		for aid := range tm.Transitions {
+			acts = append(acts, aid)
+		}
+		sort.Slice(acts, func(i, j int) bool { return acts[i] < acts[j] })
+		for _, aid := range acts {
+			t := tm.Transitions[aid]
+			fmt.Fprintf(w, "%s\t%s\t%s\n", tm.From.Name, t.Upon.Name, t.To.Name)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	// Events on documents in states that have transitions, but no
+	// node, cannot be applied.
+	for _, id := range froms {
+		if !mapped[id] {
+			fmt.Printf("\nwarning: no node for state %q\n", states[id])
+		}
+	}
+	return nil
+}
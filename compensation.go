@@ -0,0 +1,222 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Compensation specifies the transition that undoes the work done at
+// a node, should a later step of the workflow fail.
+//
+// `Action` should be one of the actions defined on the node's state.
+// Its transition ordinarily leads back to the state that preceded the
+// node, so that compensating walks back through the nodes in the
+// reverse order of their execution.
+type Compensation struct {
+	Action DocActionID `json:"Action"` // Action that undoes the work of the node
+	Actor  GroupID     `json:"Actor"`  // Singleton group on whose behalf the action is applied
+}
+
+// SetCompensation sets the compensation of the given node.  A `nil`
+// compensation removes it.
+func (_Nodes) SetCompensation(otx *sql.Tx, id NodeID, c *Compensation) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	if c != nil {
+		v.positive("Action", int64(c.Action))
+		v.positive("Actor", int64(c.Actor))
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	if c != nil {
+		n, err := Nodes.Get(id)
+		if err != nil {
+			return err
+		}
+		ts, err := n.Transitions()
+		if err != nil {
+			return err
+		}
+		if _, ok := ts[c.Action]; !ok {
+			return errorf(CodeValidation, "action %d is not defined on the state of node %d", c.Action, id)
+		}
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if c == nil {
+			_, err := tx.Exec("DELETE FROM wf_node_compensations WHERE node_id = ?", id)
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_node_compensations(node_id, docaction_id, group_id)
+		VALUES(?, ?, ?)
+		ON DUPLICATE KEY UPDATE docaction_id = VALUES(docaction_id), group_id = VALUES(group_id)
+		`
+		_, err := tx.Exec(q, id, c.Action, c.Actor)
+		return err
+	})
+}
+
+// Compensation answers the compensation of the given node, if any;
+// `nil` otherwise.
+func (_Nodes) Compensation(id NodeID) (*Compensation, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	return nodeCompensation(nil, id)
+}
+
+// nodeCompensation answers the compensation of the given node, if
+// any; `nil` otherwise.
+func nodeCompensation(otx *sql.Tx, id NodeID) (*Compensation, error) {
+	q := `
+	SELECT docaction_id, group_id
+	FROM wf_node_compensations
+	WHERE node_id = ?
+	`
+	row, err := stmts.queryRow(otx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	var c Compensation
+	err = row.Scan(&c.Action, &c.Actor)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Compensate walks the given document back to the given state, which
+// it should have passed through earlier.  Starting at the document's
+// current node, the compensation of each node is applied in turn,
+// until the document reaches the given state.  It answers the events
+// so applied, in order.
+//
+// Pending external tasks of the document are cancelled first.  Should
+// a node on the way have no compensation, or should the walk not reach
+// the given state within as many steps as the document took from it,
+// nothing is compensated.
+func (_Workflows) Compensate(otx *sql.Tx, dtype DocTypeID, did DocumentID, upto DocStateID) ([]DocEventID, error) {
+	var v validator
+	v.positive("dtype", int64(dtype))
+	v.positive("docID", int64(did))
+	v.positive("uptoState", int64(upto))
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+
+	w, err := Workflows.GetByDocType(dtype)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []DocEventID
+	err = retryWithTx(otx, func(tx *sql.Tx) error {
+		ids = []DocEventID{}
+		steps, err := compensationSteps(tx, w, did, upto)
+		if err != nil {
+			return err
+		}
+		if err = ExternalTasks.cancel(tx, dtype, did); err != nil {
+			return err
+		}
+
+		for {
+			doc, err := Documents.Get(tx, dtype, did)
+			if err != nil {
+				return err
+			}
+			if doc.State.ID == upto {
+				return nil
+			}
+			if len(ids) >= steps {
+				return errorf(CodeConflict, "document did not return to state %d within %d compensations", upto, steps)
+			}
+
+			n, err := Nodes.GetByState(dtype, doc.State.ID)
+			if err != nil {
+				return err
+			}
+			c, err := nodeCompensation(tx, n.ID)
+			if err != nil {
+				return err
+			}
+			if c == nil {
+				return errorf(CodeValidation, "node %s has no compensation", n.Name)
+			}
+
+			text := fmt.Sprintf("compensation of %s", n.Name)
+			eid, err := w.applySystemEvent(tx, did, doc.State.ID, c.Action, c.Actor, text)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, eid)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if otx == nil {
+		Outbox.Notify()
+	}
+	return ids, nil
+}
+
+// compensationSteps answers the number of transitions that the given
+// document made since it last entered the given state.
+func compensationSteps(tx *sql.Tx, w *Workflow, did DocumentID, upto DocStateID) (int, error) {
+	q := `
+	SELECT COALESCE(MAX(id), 0)
+	FROM wf_docevent_application
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND to_state_id = ?
+	`
+	var after int64
+	if err := tx.QueryRow(q, w.DocType.ID, did, upto).Scan(&after); err != nil {
+		return 0, err
+	}
+	if after == 0 && upto != w.BeginState.ID {
+		return 0, errorf(CodeValidation, "document has not been in state %d", upto)
+	}
+
+	q = `
+	SELECT COUNT(*)
+	FROM wf_docevent_application
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND id > ?
+	`
+	var steps int
+	if err := tx.QueryRow(q, w.DocType.ID, did, after).Scan(&steps); err != nil {
+		return 0, err
+	}
+	return steps, nil
+}
@@ -0,0 +1,553 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the declarative description of workflow definitions read
+// by `LoadConfig`.  In YAML:
+//
+//     states: [Draft, Pending, Approved]
+//     actions:
+//       - name: Submit
+//       - name: Approve
+//         reconfirm: true
+//     doctypes:
+//       - name: "PUR:RFQ"
+//         transitions:
+//           - {from: Draft, action: Submit, to: Pending}
+//           - {from: Pending, action: Approve, to: Approved}
+//     workflows:
+//       - name: "RFQ Approval"
+//         doctype: "PUR:RFQ"
+//         begin: Draft
+//         nodes:
+//           - {name: Drafting, state: Draft, type: begin}
+//           - {name: Review, state: Pending, type: linear, template: rfq-review}
+//
+// The same structure can be given in JSON, using the same keys.
+//
+// All references are by name.  States, actions, document types and
+// access contexts referred to should either be defined in the
+// configuration, or be already present in the database.  Access
+// contexts are never created by `LoadConfig`.
+type Config struct {
+	States   []string          `yaml:"states"`
+	Actions  []ConfigDocAction `yaml:"actions"`
+	DocTypes []ConfigDocType   `yaml:"doctypes"`
+	Wflows   []ConfigWorkflow  `yaml:"workflows"`
+}
+
+// ConfigDocAction describes a document action.
+type ConfigDocAction struct {
+	Name      string `yaml:"name"`
+	Reconfirm bool   `yaml:"reconfirm"`
+}
+
+// ConfigDocType describes a document type, and its state transitions.
+type ConfigDocType struct {
+	Name        string             `yaml:"name"`
+	Transitions []ConfigTransition `yaml:"transitions"`
+}
+
+// ConfigTransition describes a single state transition.
+type ConfigTransition struct {
+	From   string `yaml:"from" json:"from"`
+	Action string `yaml:"action" json:"action"`
+	To     string `yaml:"to" json:"to"`
+}
+
+// ConfigWorkflow describes a workflow, and its nodes.
+type ConfigWorkflow struct {
+	Name    string       `yaml:"name"`
+	DocType string       `yaml:"doctype"`
+	Begin   string       `yaml:"begin"`
+	Active  *bool        `yaml:"active"` // Defaults to `true`
+	Nodes   []ConfigNode `yaml:"nodes"`
+}
+
+// ConfigNode describes a node of a workflow.
+type ConfigNode struct {
+	Name     string `yaml:"name"`
+	State    string `yaml:"state"`
+	Type     string `yaml:"type"`
+	AccCtx   string `yaml:"accessContext"` // Name of the access context, if any
+	Template string `yaml:"template"`
+}
+
+// ConfigReport summarises the reconciliation performed by
+// `LoadConfig`.
+type ConfigReport struct {
+	Created []string // Definitions that were created
+	Drift   []string // Differences between the configuration and the database, left as they are
+}
+
+// LoadConfig reads a YAML or JSON description of document types,
+// states, actions, transitions, workflows and nodes, and reconciles
+// the database with it.
+//
+// Definitions missing in the database are created.  Existing
+// definitions that differ from the configuration -- as also
+// transitions and nodes present only in the database -- are reported
+// as drift, but are left unaltered.  Reconciliation is, therefore,
+// safe to repeat.
+//
+// N.B. Creating the storage table of a new document type commits the
+// transaction implicitly in MySQL.  Should a later definition fail,
+// the earlier ones remain.
+func LoadConfig(otx *sql.Tx, r io.Reader) (*ConfigReport, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err = yaml.UnmarshalStrict(buf, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.States) == 0 && len(cfg.Actions) == 0 && len(cfg.DocTypes) == 0 && len(cfg.Wflows) == 0 {
+		return nil, newError(CodeValidation, "configuration defines nothing")
+	}
+
+	var l *configLoader
+	err = withTx(otx, func(tx *sql.Tx) error {
+		l = newConfigLoader(tx)
+		return l.load(&cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return l.report, nil
+}
+
+// configLoader reconciles a configuration within a transaction.
+// Lookups use the same transaction, so that definitions created
+// earlier in it are visible.
+type configLoader struct {
+	tx       *sql.Tx
+	report   *ConfigReport
+	states   map[string]DocStateID
+	actions  map[string]DocActionID
+	doctypes map[string]DocTypeID
+}
+
+func newConfigLoader(tx *sql.Tx) *configLoader {
+	return &configLoader{
+		tx:       tx,
+		report:   &ConfigReport{},
+		states:   map[string]DocStateID{},
+		actions:  map[string]DocActionID{},
+		doctypes: map[string]DocTypeID{},
+	}
+}
+
+func (l *configLoader) created(format string, args ...interface{}) {
+	l.report.Created = append(l.report.Created, fmt.Sprintf(format, args...))
+}
+
+func (l *configLoader) drift(format string, args ...interface{}) {
+	l.report.Drift = append(l.report.Drift, fmt.Sprintf(format, args...))
+}
+
+// load reconciles the given configuration, in dependency order.
+func (l *configLoader) load(cfg *Config) error {
+	for _, name := range cfg.States {
+		if _, err := l.state(name, true); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Actions {
+		if err := l.defineAction(&cfg.Actions[i]); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.DocTypes {
+		if err := l.defineDocType(&cfg.DocTypes[i]); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Wflows {
+		if err := l.defineWorkflow(&cfg.Wflows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// state answers the identifier of the named document state, creating
+// it if it is missing and `create` is `true`.
+func (l *configLoader) state(name string, create bool) (DocStateID, error) {
+	name = strings.TrimSpace(name)
+	if id, ok := l.states[name]; ok {
+		return id, nil
+	}
+
+	var id DocStateID
+	row := l.tx.QueryRow(`SELECT id FROM wf_docstates_master WHERE name = ?`, name)
+	err := row.Scan(&id)
+	switch {
+	case err == nil:
+		// Nothing to do
+
+	case err != sql.ErrNoRows:
+		return 0, err
+
+	case !create:
+		return 0, errorf(CodeValidation, "unknown document state : %s", name)
+
+	default:
+		id, err = DocStates.New(l.tx, name)
+		if err != nil {
+			return 0, err
+		}
+		l.created("document state %s", name)
+	}
+
+	l.states[name] = id
+	return id, nil
+}
+
+// action answers the identifier of the named document action.
+func (l *configLoader) action(name string) (DocActionID, error) {
+	name = strings.TrimSpace(name)
+	if id, ok := l.actions[name]; ok {
+		return id, nil
+	}
+
+	var id DocActionID
+	row := l.tx.QueryRow(`SELECT id FROM wf_docactions_master WHERE name = ?`, name)
+	err := row.Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errorf(CodeValidation, "unknown document action : %s", name)
+		}
+		return 0, err
+	}
+
+	l.actions[name] = id
+	return id, nil
+}
+
+// defineAction creates the given document action, if it is missing.
+func (l *configLoader) defineAction(ca *ConfigDocAction) error {
+	name := strings.TrimSpace(ca.Name)
+
+	var id DocActionID
+	var reconfirm bool
+	row := l.tx.QueryRow(`SELECT id, reconfirm FROM wf_docactions_master WHERE name = ?`, name)
+	err := row.Scan(&id, &reconfirm)
+	switch {
+	case err == nil:
+		if reconfirm != ca.Reconfirm {
+			l.drift("document action %s : reconfirm is %v in the database", name, reconfirm)
+		}
+
+	case err != sql.ErrNoRows:
+		return err
+
+	default:
+		id, err = DocActions.New(l.tx, name, ca.Reconfirm)
+		if err != nil {
+			return err
+		}
+		l.created("document action %s", name)
+	}
+
+	l.actions[name] = id
+	return nil
+}
+
+// docType answers the identifier of the named document type.
+func (l *configLoader) docType(name string) (DocTypeID, error) {
+	name = strings.TrimSpace(name)
+	if id, ok := l.doctypes[name]; ok {
+		return id, nil
+	}
+
+	var id DocTypeID
+	row := l.tx.QueryRow(`SELECT id FROM wf_doctypes_master WHERE name = ?`, name)
+	err := row.Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errorf(CodeValidation, "unknown document type : %s", name)
+		}
+		return 0, err
+	}
+
+	l.doctypes[name] = id
+	return id, nil
+}
+
+// defineDocType creates the given document type and its transitions,
+// if they are missing.
+func (l *configLoader) defineDocType(cdt *ConfigDocType) error {
+	name := strings.TrimSpace(cdt.Name)
+	var dtid DocTypeID
+	row := l.tx.QueryRow(`SELECT id FROM wf_doctypes_master WHERE name = ?`, name)
+	err := row.Scan(&dtid)
+	switch {
+	case err == nil:
+		// Nothing to do
+
+	case err != sql.ErrNoRows:
+		return err
+
+	default:
+		dtid, err = DocTypes.New(l.tx, name)
+		if err != nil {
+			return err
+		}
+		l.created("document type %s", name)
+	}
+	l.doctypes[name] = dtid
+
+	// Existing transitions, keyed by `from:action`.
+	type target struct {
+		id    DocStateID
+		name  string
+		label string
+	}
+	existing := map[string]*target{}
+	q := `
+	SELECT dsm1.name, dam.name, dst.to_state_id, dsm2.name
+	FROM wf_docstate_transitions dst
+	JOIN wf_docstates_master dsm1 ON dsm1.id = dst.from_state_id
+	JOIN wf_docstates_master dsm2 ON dsm2.id = dst.to_state_id
+	JOIN wf_docactions_master dam ON dam.id = dst.docaction_id
+	WHERE dst.doctype_id = ?
+	`
+	rows, err := l.tx.Query(q, dtid)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var from, action string
+		var t target
+		if err = rows.Scan(&from, &action, &t.id, &t.name); err != nil {
+			rows.Close()
+			return err
+		}
+		t.label = from + " --" + action + "--> " + t.name
+		existing[from+":"+action] = &t
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	seen := map[string]bool{}
+	for _, ct := range cdt.Transitions {
+		from, err := l.state(ct.From, false)
+		if err != nil {
+			return err
+		}
+		action, err := l.action(ct.Action)
+		if err != nil {
+			return err
+		}
+		to, err := l.state(ct.To, false)
+		if err != nil {
+			return err
+		}
+
+		key := strings.TrimSpace(ct.From) + ":" + strings.TrimSpace(ct.Action)
+		seen[key] = true
+		if t, ok := existing[key]; ok {
+			if t.id != to {
+				l.drift("document type %s : %s --%s--> leads to %s in the database, not %s",
+					name, ct.From, ct.Action, t.name, ct.To)
+			}
+			continue
+		}
+
+		err = DocTypes.AddTransition(l.tx, dtid, from, action, to)
+		if err != nil {
+			return err
+		}
+		l.created("transition %s : %s --%s--> %s", name, ct.From, ct.Action, ct.To)
+	}
+
+	extra := []string{}
+	for key, t := range existing {
+		if !seen[key] {
+			extra = append(extra, t.label)
+		}
+	}
+	sort.Strings(extra)
+	for _, label := range extra {
+		l.drift("document type %s : transition %s is not in the configuration", name, label)
+	}
+
+	return nil
+}
+
+// defineWorkflow creates the given workflow and its nodes, if they are
+// missing.
+func (l *configLoader) defineWorkflow(cw *ConfigWorkflow) error {
+	name := strings.TrimSpace(cw.Name)
+	dtid, err := l.docType(cw.DocType)
+	if err != nil {
+		return err
+	}
+	begin, err := l.state(cw.Begin, false)
+	if err != nil {
+		return err
+	}
+	active := cw.Active == nil || *cw.Active
+
+	var wid WorkflowID
+	var curDtype DocTypeID
+	var curBegin DocStateID
+	var curActive bool
+	q := `SELECT id, doctype_id, docstate_id, active FROM wf_workflows WHERE name = ?`
+	row := l.tx.QueryRow(q, name)
+	err = row.Scan(&wid, &curDtype, &curBegin, &curActive)
+	switch {
+	case err == nil:
+		if curDtype != dtid {
+			l.drift("workflow %s : manages a different document type in the database", name)
+			return nil
+		}
+		if curBegin != begin {
+			l.drift("workflow %s : begins in a different state in the database", name)
+		}
+		if curActive != active {
+			l.drift("workflow %s : active is %v in the database", name, curActive)
+		}
+
+	case err != sql.ErrNoRows:
+		return err
+
+	default:
+		wid, err = Workflows.New(l.tx, name, dtid, begin)
+		if err != nil {
+			return err
+		}
+		if !active {
+			err = Workflows.SetActive(l.tx, wid, false)
+			if err != nil {
+				return err
+			}
+		}
+		l.created("workflow %s", name)
+	}
+
+	// Existing nodes of this workflow, by name.
+	type node struct {
+		state    DocStateID
+		ntype    string
+		acid     sql.NullInt64
+		template sql.NullString
+	}
+	existing := map[string]*node{}
+	q = `
+	SELECT name, docstate_id, type, ac_id, template_name
+	FROM wf_workflow_nodes
+	WHERE workflow_id = ?
+	`
+	rows, err := l.tx.Query(q, wid)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var nname string
+		var n node
+		if err = rows.Scan(&nname, &n.state, &n.ntype, &n.acid, &n.template); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[nname] = &n
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	seen := map[string]bool{}
+	for _, cn := range cw.Nodes {
+		nname := strings.TrimSpace(cn.Name)
+		seen[nname] = true
+		if !IsValidNodeType(cn.Type) {
+			return errorf(CodeValidation, "workflow %s : node %s : unknown node type : %s", name, nname, cn.Type)
+		}
+		state, err := l.state(cn.State, false)
+		if err != nil {
+			return err
+		}
+		var acid AccessContextID
+		if ac := strings.TrimSpace(cn.AccCtx); ac != "" {
+			row := l.tx.QueryRow(`SELECT id FROM wf_access_contexts WHERE name = ?`, ac)
+			if err = row.Scan(&acid); err != nil {
+				if err == sql.ErrNoRows {
+					return errorf(CodeValidation, "unknown access context : %s", ac)
+				}
+				return err
+			}
+		}
+		tmpl := strings.TrimSpace(cn.Template)
+
+		if n, ok := existing[nname]; ok {
+			if n.state != state {
+				l.drift("workflow %s : node %s : maps a different state in the database", name, nname)
+			}
+			if n.ntype != cn.Type {
+				l.drift("workflow %s : node %s : type is %s in the database", name, nname, n.ntype)
+			}
+			if AccessContextID(n.acid.Int64) != acid {
+				l.drift("workflow %s : node %s : has a different access context in the database", name, nname)
+			}
+			if n.template.String != tmpl {
+				l.drift("workflow %s : node %s : template is %q in the database", name, nname, n.template.String)
+			}
+			continue
+		}
+
+		nid, err := Workflows.AddNode(l.tx, dtid, state, acid, wid, nname, NodeType(cn.Type))
+		if err != nil {
+			return err
+		}
+		if tmpl != "" {
+			err = Nodes.SetTemplate(l.tx, nid, tmpl)
+			if err != nil {
+				return err
+			}
+		}
+		l.created("node %s : %s", name, nname)
+	}
+
+	extra := []string{}
+	for nname := range existing {
+		if !seen[nname] {
+			extra = append(extra, nname)
+		}
+	}
+	sort.Strings(extra)
+	for _, nname := range extra {
+		l.drift("workflow %s : node %s is not in the configuration", name, nname)
+	}
+
+	return nil
+}
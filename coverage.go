@@ -0,0 +1,93 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+// CoverageGap is a transition of a workflow that no user can perform,
+// since no group in the applicable access context holds its action.
+type CoverageGap struct {
+	Node      NodeID          `json:"Node"`      // Node of the state transitioned from
+	FromState DocStateID      `json:"FromState"` // State transitioned from
+	Action    DocActionID     `json:"Action"`    // Action that nobody holds
+	ToState   DocStateID      `json:"ToState"`   // State transitioned to
+	AccCtx    AccessContextID `json:"AccCtx"`    // Access context checked
+	Stranded  bool            `json:"Stranded"`  // Can no transition out of the state be performed?
+}
+
+// Coverage answers the transitions of the given workflow that no user
+// can perform in the given access context: those whose action is
+// granted -- explicitly or through an all-actions grant -- to no role
+// of any group having members in it.  Nodes having an access context
+// of their own are checked against that instead.  End nodes are
+// skipped.
+//
+// A gap whose `Stranded` is `true` marks a state that documents can
+// enter, but that no user can ever move them out of.  Such states are
+// usually the result of roles or group assignments lagging behind the
+// workflow.  Note that documents may still leave them through
+// automatic actions, timers and returns, which are not considered
+// here.
+func (_Workflows) Coverage(wid WorkflowID, acID AccessContextID) ([]*CoverageGap, error) {
+	var v validator
+	v.positive("workflow ID", int64(wid))
+	v.positive("access context ID", int64(acID))
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+	if _, err := Workflows.Get(wid); err != nil {
+		return nil, err
+	}
+	if _, err := AccessContexts.Get(acID); err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT wn.id, dst.from_state_id, dst.docaction_id, dst.to_state_id, COALESCE(wn.ac_id, ?) AS eff_ac_id,
+		NOT EXISTS (
+			SELECT 1
+			FROM wf_docstate_transitions dst2
+			JOIN wf_ac_perms_v acpv2 ON acpv2.doctype_id = dst2.doctype_id AND acpv2.docaction_id = dst2.docaction_id
+			WHERE dst2.doctype_id = dst.doctype_id
+			AND dst2.from_state_id = dst.from_state_id
+			AND acpv2.ac_id = COALESCE(wn.ac_id, ?)
+		) AS stranded
+	FROM wf_workflow_nodes wn
+	JOIN wf_docstate_transitions dst ON dst.doctype_id = wn.doctype_id AND dst.from_state_id = wn.docstate_id
+	WHERE wn.workflow_id = ?
+	AND wn.type <> 'end'
+	AND NOT EXISTS (
+		SELECT 1
+		FROM wf_ac_perms_v acpv
+		WHERE acpv.ac_id = COALESCE(wn.ac_id, ?)
+		AND acpv.doctype_id = dst.doctype_id
+		AND acpv.docaction_id = dst.docaction_id
+	)
+	ORDER BY wn.id, dst.docaction_id, dst.to_state_id
+	`
+	ary := []*CoverageGap{}
+	err := scanEach(readDB().Query, q, []interface{}{acID, acID, wid, acID}, func(scan func(...interface{}) error) error {
+		var elem CoverageGap
+		err := scan(&elem.Node, &elem.FromState, &elem.Action, &elem.ToState, &elem.AccCtx, &elem.Stranded)
+		if err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
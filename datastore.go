@@ -0,0 +1,525 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// DataStorage enumerates the ways in which the data of the documents
+// of a type can be stored.
+type DataStorage string
+
+// Kinds of storage of document data.
+const (
+	// DataStorageText : in the document table, up to 64 KiB; the default
+	DataStorageText DataStorage = "text"
+	// DataStorageMedium : in the document table, up to 16 MiB
+	DataStorageMedium DataStorage = "mediumtext"
+	// DataStorageLong : in the document table, up to 4 GiB
+	DataStorageLong DataStorage = "longtext"
+	// DataStorageExternal : in files in the blobs directory, referred to by the document table
+	DataStorageExternal DataStorage = "external"
+	// DataStorageJSON : in a native JSON column of the document table, up to 4 GiB
+	DataStorageJSON DataStorage = "json"
+)
+
+// capacity answers the number of bytes that the column of this kind
+// of storage can hold; `0` if it is not limited.
+func (s DataStorage) capacity() int64 {
+	switch s {
+	case DataStorageText:
+		return 1<<16 - 1
+
+	case DataStorageMedium:
+		return 1<<24 - 1
+
+	case DataStorageLong, DataStorageJSON:
+		return 1<<32 - 1
+
+	default:
+		return 0
+	}
+}
+
+// column answers the type of the data column for this kind of
+// storage.  External data needs only room for references.
+func (s DataStorage) column() string {
+	if s == DataStorageExternal {
+		return "TEXT"
+	}
+	return strings.ToUpper(string(s))
+}
+
+// valid answers `true` if this is a known kind of storage.
+func (s DataStorage) valid() bool {
+	switch s {
+	case DataStorageText, DataStorageMedium, DataStorageLong, DataStorageExternal, DataStorageJSON:
+		return true
+	}
+	return false
+}
+
+// DataConfig specifies how the data of the documents of a type is
+// stored, and how large it can be.
+type DataConfig struct {
+	Storage DataStorage `json:"Storage"`          // Where the data is stored
+	MaxSize int64       `json:"MaxSize"`          // Maximum size of the data, in bytes; `0` for the capacity of the storage
+	Schema  string      `json:"Schema,omitempty"` // JSON Schema that the data should satisfy, if any; only for JSON storage
+
+	schema *jsonSchema
+}
+
+// limit answers the maximum size of data, in bytes, as per this
+// configuration; `0` if it is not limited.
+func (c *DataConfig) limit() int64 {
+	if c.MaxSize > 0 {
+		return c.MaxSize
+	}
+	return c.Storage.capacity()
+}
+
+// check answers a `DataTooLargeError` if data of the given size is
+// not allowed for the given document type.
+func (c *DataConfig) check(dtype DocTypeID, size int64) error {
+	if lim := c.limit(); lim > 0 && size > lim {
+		return &DataTooLargeError{DocType: dtype, Size: size, Limit: lim}
+	}
+	return nil
+}
+
+// DataTooLargeError is answered when the data given for a document
+// exceeds the size allowed for its type.  It matches
+// `ErrDocDataTooLarge`, and has the code `CodeValidation`.
+type DataTooLargeError struct {
+	DocType DocTypeID // Type of the document
+	Size    int64     // Size of the data given, in bytes; at least `Limit + 1`, if streamed
+	Limit   int64     // Maximum size allowed, in bytes
+}
+
+// Error implements the `error` interface.
+func (e *DataTooLargeError) Error() string {
+	return fmt.Sprintf("%s : document type %d allows %d bytes, given %d", ErrDocDataTooLarge, e.DocType, e.Limit, e.Size)
+}
+
+// Is answers `true` if the target is `ErrDocDataTooLarge`, or its
+// code.
+func (e *DataTooLargeError) Is(target error) bool {
+	return target == ErrDocDataTooLarge || target == CodeValidation
+}
+
+// masterDataConfigs is the kind of the cached data configurations of
+// document types.
+const masterDataConfigs = "dataconfig"
+
+// DataConfig answers how the data of the documents of the given type
+// is stored.  Types not configured using `SetDataConfig` store it in
+// the document table, up to 64 KiB.
+func (_DocTypes) DataConfig(dtype DocTypeID) (*DataConfig, error) {
+	if dtype <= 0 {
+		return nil, newError(CodeValidation, "document type ID should be a positive integer")
+	}
+
+	return dataConfig(nil, dtype)
+}
+
+// dataConfig answers the data configuration of the given document
+// type, from the cache if possible.
+func dataConfig(otx *sql.Tx, dtype DocTypeID) (*DataConfig, error) {
+	if v, ok := masters.get(masterDataConfigs, dtype); ok {
+		elem := v.(DataConfig)
+		return &elem, nil
+	}
+
+	queryRow := readDB().QueryRow
+	if otx != nil {
+		queryRow = otx.QueryRow
+	}
+	elem := DataConfig{Storage: DataStorageText}
+	var schema sql.NullString
+	q := `SELECT storage, max_size, json_schema FROM wf_doctype_data WHERE doctype_id = ?`
+	err := queryRow(q, dtype).Scan(&elem.Storage, &elem.MaxSize, &schema)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if schema.String != "" {
+		elem.Schema = schema.String
+		if elem.schema, err = compileJSONSchema(elem.Schema); err != nil {
+			return nil, err
+		}
+	}
+
+	if otx == nil {
+		masters.put(masterDataConfigs, elem, dtype)
+	}
+	return &elem, nil
+}
+
+// SetDataConfig changes how the data of the documents of the given
+// type is stored, and how large it can be.  The column of the document
+// table is altered as needed; this is a schema change, which MySQL
+// commits implicitly.  It answers `ErrDocDataTooLarge` if existing
+// data would not fit.
+//
+// Data is moved neither into nor out of external storage: switching to
+// or from `DataStorageExternal` answers `ErrDocTypeInUse` once
+// documents of the type exist.  Switching to `DataStorageJSON` requires
+// that the existing data be valid JSON; it is not checked against the
+// schema given, though.  MySQL normalises JSON data, so that it is read
+// back with its keys ordered, and without insignificant white space.  External data is written to files
+// under the directory `data` inside the blobs directory (see
+// `SetBlobsDir`), named by their SHA1 sums.  Those files are not
+// removed when the data of documents is replaced, or purged.
+func (_DocTypes) SetDataConfig(otx *sql.Tx, dtype DocTypeID, cfg *DataConfig) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("document type ID", int64(dtype))
+	if cfg == nil {
+		v.fail("config", "should be given")
+	} else {
+		if !cfg.Storage.valid() {
+			v.fail("Storage", fmt.Sprintf("unknown storage %q", cfg.Storage))
+		}
+		if cfg.MaxSize < 0 {
+			v.fail("MaxSize", "should be non-negative")
+		}
+		if c := cfg.Storage.capacity(); c > 0 && cfg.MaxSize > c {
+			v.fail("MaxSize", fmt.Sprintf("should not exceed %d bytes for %s storage", c, cfg.Storage))
+		}
+		switch {
+		case cfg.Schema == "":
+			cfg.schema = nil
+
+		case cfg.Storage != DataStorageJSON:
+			v.fail("Schema", "requires JSON storage")
+
+		default:
+			var err error
+			if cfg.schema, err = compileJSONSchema(cfg.Schema); err != nil {
+				v.fail("Schema", err.Error())
+			}
+		}
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	defer masters.changed(otx, masterDataConfigs)
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if _, err := DocTypes.name(tx, dtype); err != nil {
+			return err
+		}
+		cur, err := dataConfig(tx, dtype)
+		if err != nil {
+			return err
+		}
+		tbl := DocTypes.docStorName(dtype)
+
+		if (cur.Storage == DataStorageExternal) != (cfg.Storage == DataStorageExternal) {
+			var n int64
+			if err = tx.QueryRow(`SELECT COUNT(*) FROM ` + tbl).Scan(&n); err != nil {
+				return err
+			}
+			if n > 0 {
+				return ErrDocTypeInUse
+			}
+		}
+		if cfg.Storage != DataStorageExternal {
+			var size int64
+			if err = tx.QueryRow(`SELECT COALESCE(MAX(LENGTH(data)), 0) FROM ` + tbl).Scan(&size); err != nil {
+				return err
+			}
+			if err = cfg.check(dtype, size); err != nil {
+				return err
+			}
+		}
+		if cfg.Storage == DataStorageJSON && cur.Storage != DataStorageJSON {
+			var n int64
+			if err = tx.QueryRow(`SELECT COUNT(*) FROM ` + tbl + ` WHERE NOT JSON_VALID(data)`).Scan(&n); err != nil {
+				return err
+			}
+			if n > 0 {
+				return errorf(CodeValidation, "data of %d documents is not valid JSON", n)
+			}
+		}
+
+		if col := cfg.Storage.column(); col != cur.Storage.column() {
+			if err = alterDocStor(tx, dtype, `MODIFY data `+col+` NOT NULL`); err != nil {
+				return err
+			}
+		}
+
+		q := `
+		INSERT INTO wf_doctype_data(doctype_id, storage, max_size, json_schema)
+		VALUES(?, ?, ?, NULLIF(?, ''))
+		ON DUPLICATE KEY UPDATE storage = VALUES(storage), max_size = VALUES(max_size), json_schema = VALUES(json_schema)
+		`
+		_, err = tx.Exec(q, dtype, cfg.Storage, cfg.MaxSize, cfg.Schema)
+		return err
+	})
+}
+
+// storeData checks the size of the given data for documents of the
+// given type -- and its validity, if it is JSON -- and answers the
+// value to be held in the document table: the data itself, or a
+// reference to its file.
+func storeData(otx *sql.Tx, dtype DocTypeID, data string) (string, error) {
+	cfg, err := dataConfig(otx, dtype)
+	if err != nil {
+		return "", err
+	}
+	if err = cfg.check(dtype, int64(len(data))); err != nil {
+		return "", err
+	}
+	switch cfg.Storage {
+	case DataStorageJSON:
+		if cfg.schema != nil {
+			if err = cfg.schema.validate(data); err != nil {
+				return "", err
+			}
+			return data, nil
+		}
+		if !json.Valid([]byte(data)) {
+			return "", newError(CodeValidation, "document data is not valid JSON")
+		}
+		return data, nil
+
+	case DataStorageExternal:
+
+	default:
+		return data, nil
+	}
+
+	return writeDataFile(dtype, strings.NewReader(data), 0)
+}
+
+// dataDir answers the directory in which external document data is
+// stored.
+func dataDir() (string, error) {
+	if blobsDir == "" {
+		return "", newError(CodeInternal, "blobs directory is not set; see `SetBlobsDir`")
+	}
+	return path.Join(blobsDir, "data"), nil
+}
+
+// writeDataFile writes the data read from the given reader, of at most
+// the given size (unlimited, if `0`), into a file in the data
+// directory.  It answers the SHA1 sum of the data, which names the
+// file.
+func writeDataFile(dtype DocTypeID, r io.Reader, limit int64) (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, "data-")
+	if err != nil {
+		return "", err
+	}
+	success := false
+	defer func() {
+		if !success {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	h := sha1.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", newError(CodeValidation, "document data should not be empty")
+	}
+	if limit > 0 && n > limit {
+		return "", &DataTooLargeError{DocType: dtype, Size: n, Limit: limit}
+	}
+
+	csum := fmt.Sprintf("%x", h.Sum(nil))
+	if err = os.MkdirAll(path.Join(dir, csum[0:2]), 0750); err != nil {
+		return "", err
+	}
+	if err = os.Rename(f.Name(), path.Join(dir, csum[0:2], csum)); err != nil {
+		return "", err
+	}
+
+	success = true
+	return csum, nil
+}
+
+// openDataFile opens the file of external data having the given SHA1
+// sum.
+func openDataFile(csum string) (*os.File, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return nil, err
+	}
+	if len(csum) < 2 || strings.ContainsAny(csum, "/.") {
+		return nil, errorf(CodeInternal, "malformed data reference : %q", csum)
+	}
+	return os.Open(path.Join(dir, csum[0:2], csum))
+}
+
+// loadData replaces the given value held in the document table with
+// the data it refers to, should the data of the given document type be
+// stored externally.
+func loadData(otx *sql.Tx, dtype DocTypeID, val string) (string, error) {
+	cfg, err := dataConfig(otx, dtype)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Storage != DataStorageExternal {
+		return val, nil
+	}
+
+	f, err := openDataFile(val)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// OpenData answers a reader of the data of the given document.  Data
+// stored externally is streamed from its file; other data is read
+// fully from the database.  The data is not redacted.  The caller
+// should close the reader.
+func (_Documents) OpenData(dtype DocTypeID, id DocumentID) (io.ReadCloser, error) {
+	var v validator
+	v.positive("document type ID", int64(dtype))
+	v.positive("document ID", int64(id))
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+
+	var val string
+	q := `SELECT data FROM ` + DocTypes.docStorName(dtype) + ` WHERE id = ?`
+	if err := readDB().QueryRow(q, id).Scan(&val); err != nil {
+		return nil, notFound(err, ErrDocumentNotFound)
+	}
+	cfg, err := dataConfig(nil, dtype)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Storage != DataStorageExternal {
+		return ioutil.NopCloser(strings.NewReader(val)), nil
+	}
+
+	return openDataFile(val)
+}
+
+// SetDataFrom sets the data of the document to that read from the
+// given reader.  Data stored externally is streamed into its file;
+// other data is read fully, up to the size allowed.  Data larger than
+// the size allowed for the type answers a `DataTooLargeError`.
+func (_Documents) SetDataFrom(otx *sql.Tx, dtype DocTypeID, id DocumentID, r io.Reader) error {
+	if r == nil {
+		return newError(CodeValidation, "reader should be non-nil")
+	}
+
+	cfg, err := dataConfig(otx, dtype)
+	if err != nil {
+		return err
+	}
+	lim := cfg.limit()
+	if cfg.Storage != DataStorageExternal {
+		buf, err := ioutil.ReadAll(io.LimitReader(r, lim+1))
+		if err != nil {
+			return err
+		}
+		return Documents.SetData(otx, dtype, id, string(buf))
+	}
+
+	csum, err := writeDataFile(dtype, r, lim)
+	if err != nil {
+		return err
+	}
+	return withTx(otx, func(tx *sql.Tx) error {
+		return Documents.setData(tx, dtype, id, csum)
+	})
+}
+
+// DataOp enumerates the comparisons of `DataPredicate`.
+type DataOp string
+
+// Comparisons of JSON document data.
+const (
+	DataEq       DataOp = "="        // Value at the path equals the given value
+	DataNe       DataOp = "!="       // Value at the path differs from the given value
+	DataLt       DataOp = "<"        // Value at the path is less than the given value
+	DataLe       DataOp = "<="       // Value at the path is at most the given value
+	DataGt       DataOp = ">"        // Value at the path is greater than the given value
+	DataGe       DataOp = ">="       // Value at the path is at least the given value
+	DataExists   DataOp = "exists"   // The path exists
+	DataContains DataOp = "contains" // Value at the path contains the given value, as per `JSON_CONTAINS`
+)
+
+// DataPredicate is a condition on the JSON data of documents, which is
+// evaluated by the database.  Comparisons are between JSON values;
+// documents lacking the path do not satisfy them, including `DataNe`.
+type DataPredicate struct {
+	Path  string      // JSON path into the data, e.g. `$.customer.id`
+	Op    DataOp      // Comparison
+	Value interface{} // Value compared with, marshalled as JSON; unused by `DataExists`
+}
+
+// sql answers the SQL condition of this predicate, and its arguments.
+func (p *DataPredicate) sql() (string, []interface{}, error) {
+	if !strings.HasPrefix(p.Path, "$") {
+		return "", nil, errorf(CodeValidation, "JSON path %q should begin with `$`", p.Path)
+	}
+	if p.Op == DataExists {
+		return `JSON_CONTAINS_PATH(docs.data, 'one', ?)`, []interface{}{p.Path}, nil
+	}
+
+	val, err := json.Marshal(p.Value)
+	if err != nil {
+		return "", nil, errorf(CodeValidation, "value for JSON path %q : %v", p.Path, err)
+	}
+	switch p.Op {
+	case DataEq, DataNe, DataLt, DataLe, DataGt, DataGe:
+		return `JSON_EXTRACT(docs.data, ?) ` + string(p.Op) + ` CAST(? AS JSON)`, []interface{}{p.Path, string(val)}, nil
+
+	case DataContains:
+		return `JSON_CONTAINS(docs.data, CAST(? AS JSON), ?)`, []interface{}{string(val), p.Path}, nil
+
+	default:
+		return "", nil, errorf(CodeValidation, "unknown comparison %q for JSON path %q", p.Op, p.Path)
+	}
+}
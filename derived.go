@@ -0,0 +1,528 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DerivedKind enumerates the types of the values of derived fields.
+type DerivedKind string
+
+// Types of derived fields.
+const (
+	// DerivedNumber : a floating-point number
+	DerivedNumber DerivedKind = "number"
+	// DerivedText : text of up to 250 characters
+	DerivedText DerivedKind = "text"
+	// DerivedTime : a time stamp
+	DerivedTime DerivedKind = "time"
+)
+
+// column answers the type of the column holding values of this kind.
+func (k DerivedKind) column() string {
+	switch k {
+	case DerivedNumber:
+		return "DOUBLE NULL"
+
+	case DerivedText:
+		return "VARCHAR(250) NULL"
+
+	default:
+		return "DATETIME NULL"
+	}
+}
+
+// DerivedField is a value computed from the data of each document of a
+// type, whenever the data is written.  It is stored in an indexed
+// column of the document table, so that documents can be filtered and
+// sorted by it when listed.
+//
+// A derived field is computed either by a JSON path expression into
+// the data, such as `$.order.total`, or by a Go function registered
+// using `DocTypes.SetDeriveFunc`.
+type DerivedField struct {
+	DocType DocTypeID   `json:"DocType"`        // Type of the documents
+	Name    string      `json:"Name"`           // Unique within the type; lower case letters, digits and underscores
+	Kind    DerivedKind `json:"Kind"`           // Type of the values
+	Expr    string      `json:"Expr,omitempty"` // JSON path into the data; empty, if computed by a function
+}
+
+// DeriveFunc computes the value of a derived field from the data of a
+// document.  It should answer a number, a string or a `time.Time`, as
+// per the kind of the field; `nil` stores no value.
+type DeriveFunc func(dtype DocTypeID, data string) (interface{}, error)
+
+// masterDerivedFields is the kind of the cached derived fields of
+// document types.
+const masterDerivedFields = "derivedfield"
+
+// derivePageSize is the number of documents recomputed per
+// transaction.
+const derivePageSize = 500
+
+// derivedNameRE matches the valid names of derived fields.
+var derivedNameRE = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+var deriveMu sync.RWMutex
+var deriveFuncs = map[string]DeriveFunc{}
+
+// deriveKey answers the registry key of the given derived field.
+func deriveKey(dtype DocTypeID, name string) string {
+	return fmt.Sprintf("%d:%s", dtype, name)
+}
+
+// derivedColumn answers the column of the document table holding the
+// given derived field.
+func derivedColumn(name string) string {
+	return "dv_" + name
+}
+
+// SetDeriveFunc registers the function computing the given derived
+// field of the given document type.  A `nil` function unregisters it.
+//
+// The function should be registered in every process writing documents
+// of the type; writing their data otherwise answers an error.
+func (_DocTypes) SetDeriveFunc(dtype DocTypeID, name string, fn DeriveFunc) error {
+	var v validator
+	v.positive("document type ID", int64(dtype))
+	v.required("name", name)
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	deriveMu.Lock()
+	defer deriveMu.Unlock()
+
+	if fn == nil {
+		delete(deriveFuncs, deriveKey(dtype, name))
+		return nil
+	}
+	deriveFuncs[deriveKey(dtype, name)] = fn
+	return nil
+}
+
+// AddDerivedField defines the given derived field, adding an indexed
+// column for it to the document table; this is a schema change, which
+// MySQL commits implicitly.
+//
+// Existing documents have no value for the field until their data is
+// written again, or `RecomputeDerivedFields` is run.
+func (_DocTypes) AddDerivedField(otx *sql.Tx, f *DerivedField) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
+	var v validator
+	if f == nil {
+		v.fail("field", "should be given")
+	} else {
+		v.positive("DocType", int64(f.DocType))
+		if !derivedNameRE.MatchString(f.Name) {
+			v.fail("Name", "should be lower case letters, digits and underscores, beginning with a letter")
+		}
+		switch f.Kind {
+		case DerivedNumber, DerivedText, DerivedTime:
+
+		default:
+			v.fail("Kind", fmt.Sprintf("unknown kind %q", f.Kind))
+		}
+		if f.Expr != "" {
+			if _, err := parseJSONPath(f.Expr); err != nil {
+				v.fail("Expr", err.Error())
+			}
+		}
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	defer masters.changed(otx, masterDerivedFields)
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if _, err := DocTypes.name(tx, f.DocType); err != nil {
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_derived_fields(doctype_id, name, kind, expr)
+		VALUES(?, ?, ?, NULLIF(?, ''))
+		`
+		if _, err := tx.Exec(q, f.DocType, f.Name, f.Kind, f.Expr); err != nil {
+			return err
+		}
+
+		col := derivedColumn(f.Name)
+		return alterDocStor(tx, f.DocType, `ADD COLUMN `+col+` `+f.Kind.column()+`, ADD INDEX (`+col+`)`)
+	})
+}
+
+// RemoveDerivedField removes the given derived field, and its column.
+func (_DocTypes) RemoveDerivedField(otx *sql.Tx, dtype DocTypeID, name string) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
+	defer masters.changed(otx, masterDerivedFields)
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM wf_derived_fields WHERE doctype_id = ? AND name = ?`, dtype, name)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		return alterDocStor(tx, dtype, `DROP COLUMN `+derivedColumn(name))
+	})
+}
+
+// DerivedFields answers the derived fields of the given document type,
+// ordered by name.
+func (_DocTypes) DerivedFields(dtype DocTypeID) ([]*DerivedField, error) {
+	if dtype <= 0 {
+		return nil, newError(CodeValidation, "document type ID should be a positive integer")
+	}
+
+	fs, err := derivedFields(nil, dtype)
+	if err != nil {
+		return nil, err
+	}
+	ary := make([]*DerivedField, 0, len(fs))
+	for _, f := range fs {
+		elem := f
+		ary = append(ary, &elem)
+	}
+	return ary, nil
+}
+
+// derivedFields answers the derived fields of the given document type,
+// from the cache if possible.
+func derivedFields(otx *sql.Tx, dtype DocTypeID) ([]DerivedField, error) {
+	if v, ok := masters.get(masterDerivedFields, dtype); ok {
+		return v.([]DerivedField), nil
+	}
+
+	query := readDB().Query
+	if otx != nil {
+		query = otx.Query
+	}
+	q := `
+	SELECT doctype_id, name, kind, COALESCE(expr, '')
+	FROM wf_derived_fields
+	WHERE doctype_id = ?
+	ORDER BY name
+	`
+	ary := []DerivedField{}
+	err := scanEach(query, q, []interface{}{dtype}, func(scan func(...interface{}) error) error {
+		var elem DerivedField
+		if err := scan(&elem.DocType, &elem.Name, &elem.Kind, &elem.Expr); err != nil {
+			return err
+		}
+		ary = append(ary, elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if otx == nil {
+		masters.put(masterDerivedFields, ary, dtype)
+	}
+	return ary, nil
+}
+
+// derivedField answers the derived field of the given document type
+// having the given name.
+func derivedField(dtype DocTypeID, name string) (*DerivedField, error) {
+	fs, err := derivedFields(nil, dtype)
+	if err != nil {
+		return nil, err
+	}
+	for i := range fs {
+		if fs[i].Name == name {
+			return &fs[i], nil
+		}
+	}
+	return nil, errorf(CodeValidation, "unknown derived field : %q", name)
+}
+
+// derive computes the derived fields of the given document from the
+// given value held in the document table, and stores them.
+func derive(tx *sql.Tx, dtype DocTypeID, id DocumentID, val string) error {
+	fs, err := derivedFields(tx, dtype)
+	if err != nil || len(fs) == 0 {
+		return err
+	}
+	data, err := loadData(tx, dtype, val)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	parsed := false
+	sets := make([]string, 0, len(fs))
+	args := make([]interface{}, 0, len(fs)+1)
+	for _, f := range fs {
+		var x interface{}
+		if f.Expr != "" {
+			if !parsed {
+				parsed = true
+				if json.Unmarshal([]byte(data), &doc) != nil {
+					doc = nil
+				}
+			}
+			x = evalJSONPath(doc, f.Expr)
+		} else {
+			deriveMu.RLock()
+			fn, ok := deriveFuncs[deriveKey(dtype, f.Name)]
+			deriveMu.RUnlock()
+			if !ok {
+				return errorf(CodeInternal, "no function is registered for derived field %q of document type %d", f.Name, dtype)
+			}
+			if x, err = fn(dtype, data); err != nil {
+				return err
+			}
+		}
+
+		if x, err = f.convert(x); err != nil {
+			return err
+		}
+		sets = append(sets, derivedColumn(f.Name)+` = ?`)
+		args = append(args, x)
+	}
+
+	args = append(args, id)
+	_, err = tx.Exec(`UPDATE `+DocTypes.docStorName(dtype)+` SET `+strings.Join(sets, ", ")+` WHERE id = ?`, args...)
+	return err
+}
+
+// convert answers the given value as one of the kind of this field;
+// `nil` if it is `nil`.
+func (f *DerivedField) convert(x interface{}) (interface{}, error) {
+	if x == nil {
+		return nil, nil
+	}
+
+	fail := func() (interface{}, error) {
+		return nil, errorf(CodeValidation, "derived field %q : cannot use %v as %s", f.Name, x, f.Kind)
+	}
+	switch f.Kind {
+	case DerivedNumber:
+		switch n := x.(type) {
+		case float64:
+			return n, nil
+		case float32:
+			return float64(n), nil
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case json.Number:
+			return n.Float64()
+		case string:
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return fail()
+			}
+			return v, nil
+		}
+
+	case DerivedText:
+		var s string
+		switch t := x.(type) {
+		case string:
+			s = t
+		case float64, bool, int, int64:
+			s = fmt.Sprint(t)
+		default:
+			return fail()
+		}
+		if utf8.RuneCountInString(s) > maxTitleLen {
+			return nil, errorf(CodeValidation, "derived field %q : should not exceed %d characters", f.Name, maxTitleLen)
+		}
+		return s, nil
+
+	case DerivedTime:
+		switch t := x.(type) {
+		case time.Time:
+			return t.UTC(), nil
+		case string:
+			v, err := time.Parse(time.RFC3339, t)
+			if err != nil {
+				return fail()
+			}
+			return v.UTC(), nil
+		}
+	}
+	return fail()
+}
+
+// RecomputeDerivedFields computes the derived fields of all the
+// documents of the given type afresh, a page at a time, and answers the
+// number of documents updated.  Run this after adding a derived field,
+// or changing its function.
+//
+// It checks the given context between pages, and answers its error
+// upon cancellation.
+func (_DocTypes) RecomputeDerivedFields(ctx context.Context, dtype DocTypeID) (int64, error) {
+	if dtype <= 0 {
+		return 0, newError(CodeValidation, "document type ID should be a positive integer")
+	}
+
+	q := `SELECT id, data FROM ` + DocTypes.docStorName(dtype) + ` WHERE id > ? ORDER BY id LIMIT ?`
+	var total int64
+	var last DocumentID
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		n := 0
+		err := withTx(nil, func(tx *sql.Tx) error {
+			type row struct {
+				id  DocumentID
+				val string
+			}
+			rows := []row{}
+			err := scanEach(tx.Query, q, []interface{}{last, derivePageSize}, func(scan func(...interface{}) error) error {
+				var r row
+				if err := scan(&r.id, &r.val); err != nil {
+					return err
+				}
+				rows = append(rows, r)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, r := range rows {
+				if err = derive(tx, dtype, r.id, r.val); err != nil {
+					return err
+				}
+				last = r.id
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int64(n)
+		if n < derivePageSize {
+			return total, nil
+		}
+	}
+}
+
+// FieldPredicate is a condition on a derived field of documents.
+type FieldPredicate struct {
+	Name  string      // Name of the derived field
+	Op    DataOp      // Comparison; `DataExists` selects documents having a value, and `DataContains` is not supported
+	Value interface{} // Value compared with; unused by `DataExists`
+}
+
+// sql answers the SQL condition of this predicate on documents of the
+// given type, and its arguments.
+func (p *FieldPredicate) sql(dtype DocTypeID) (string, []interface{}, error) {
+	f, err := derivedField(dtype, p.Name)
+	if err != nil {
+		return "", nil, err
+	}
+	col := `docs.` + derivedColumn(f.Name)
+
+	switch p.Op {
+	case DataExists:
+		return col + ` IS NOT NULL`, nil, nil
+
+	case DataEq, DataNe, DataLt, DataLe, DataGt, DataGe:
+		x, err := f.convert(p.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		if x == nil {
+			return "", nil, errorf(CodeValidation, "derived field %q : value should be given", f.Name)
+		}
+		return col + ` ` + string(p.Op) + ` ?`, []interface{}{x}, nil
+
+	default:
+		return "", nil, errorf(CodeValidation, "unsupported comparison %q for derived field %q", p.Op, f.Name)
+	}
+}
+
+// jsonPathRE matches the JSON path expressions supported: member
+// names and array indices following `$`.
+var jsonPathRE = regexp.MustCompile(`^\$((\.[A-Za-z_][A-Za-z0-9_]*)|(\[[0-9]+\]))*$`)
+
+// jsonPathStepRE matches the individual steps of a JSON path.
+var jsonPathStepRE = regexp.MustCompile(`\.([A-Za-z_][A-Za-z0-9_]*)|\[([0-9]+)\]`)
+
+// parseJSONPath answers the steps of the given JSON path: member names,
+// and array indices.
+func parseJSONPath(p string) ([]interface{}, error) {
+	if !jsonPathRE.MatchString(p) {
+		return nil, errorf(CodeValidation, "unsupported JSON path %q; use member names and array indices, e.g. `$.items[0].price`", p)
+	}
+	steps := []interface{}{}
+	for _, m := range jsonPathStepRE.FindAllStringSubmatch(p, -1) {
+		if m[1] != "" {
+			steps = append(steps, m[1])
+			continue
+		}
+		i, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, i)
+	}
+	return steps, nil
+}
+
+// evalJSONPath answers the value at the given path in the given
+// decoded JSON; `nil` if there is none.
+func evalJSONPath(doc interface{}, p string) interface{} {
+	steps, err := parseJSONPath(p)
+	if err != nil {
+		return nil
+	}
+	for _, s := range steps {
+		switch k := s.(type) {
+		case string:
+			m, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			doc = m[k]
+
+		case int:
+			a, ok := doc.([]interface{})
+			if !ok || k >= len(a) {
+				return nil
+			}
+			doc = a[k]
+		}
+	}
+	return doc
+}
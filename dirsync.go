@@ -0,0 +1,361 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirectoryUser is a user record as reported by an external directory.
+//
+// The e-mail address is the linking identifier between the directory
+// and `flow`, exactly as it is for singleton groups.
+type DirectoryUser struct {
+	FirstName string `json:"FirstName"` // For display purposes only
+	LastName  string `json:"LastName"`  // For display purposes only
+	Email     string `json:"Email"`     // E-mail address of this user; must be unique
+	Active    bool   `json:"Active"`    // Is this user account active?
+}
+
+// DirectoryGroup is a general group as reported by an external
+// directory, together with the e-mail addresses of its members.
+type DirectoryGroup struct {
+	Name    string   `json:"Name"`    // Globally-unique name
+	Members []string `json:"Members"` // E-mail addresses of the members
+}
+
+// DirectoryProvider is the interface that external identity
+// directories implement, in order to be reconciled into `flow`.
+//
+// `Fetch` should answer the complete current set of users and general
+// groups.  Users that are absent from the answer are deactivated by
+// the synchronisation.
+type DirectoryProvider interface {
+	Fetch(ctx context.Context) ([]*DirectoryUser, []*DirectoryGroup, error)
+}
+
+// DirectorySyncDiff lists the changes needed to bring `flow`'s users,
+// groups and memberships in line with an external directory.
+//
+// A diff can be inspected as a dry run, before being applied.
+type DirectorySyncDiff struct {
+	UsersAdded       []*DirectoryUser    `json:"UsersAdded"`       // Users present only in the directory
+	UsersUpdated     []*DirectoryUser    `json:"UsersUpdated"`     // Users whose names or status differ
+	UsersDeactivated []*User             `json:"UsersDeactivated"` // Active users absent from the directory
+	GroupsAdded      []string            `json:"GroupsAdded"`      // General groups present only in the directory
+	MembersAdded     map[string][]string `json:"MembersAdded"`     // Group name --> e-mail addresses to add
+	MembersRemoved   map[string][]string `json:"MembersRemoved"`   // Group name --> e-mail addresses to remove
+}
+
+// IsEmpty answers `true` if this diff requires no changes.
+func (d *DirectorySyncDiff) IsEmpty() bool {
+	return len(d.UsersAdded) == 0 && len(d.UsersUpdated) == 0 && len(d.UsersDeactivated) == 0 &&
+		len(d.GroupsAdded) == 0 && len(d.MembersAdded) == 0 && len(d.MembersRemoved) == 0
+}
+
+// Unexported type, only for convenience methods.
+type _DirectorySync struct{}
+
+// DirectorySync reconciles users, general groups and their
+// memberships from an external directory into `flow`.
+var DirectorySync _DirectorySync
+
+// Plan fetches the current state of the given directory, compares it
+// with that of `flow`, and answers the differences.  Nothing is
+// written to the database.
+func (_DirectorySync) Plan(ctx context.Context, p DirectoryProvider) (*DirectorySyncDiff, error) {
+	if p == nil {
+		return nil, newError(CodeValidation, "directory provider should be non-nil")
+	}
+
+	dusers, dgroups, err := p.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lusers, err := Users.List("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	lgroups, err := DirectorySync.generalGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	return diffDirectory(dusers, dgroups, lusers, lgroups), nil
+}
+
+// generalGroups answers all general groups, together with the e-mail
+// addresses of their members.
+func (_DirectorySync) generalGroups() (map[string][]string, error) {
+	q := `
+	SELECT gm.name, um.email
+	FROM wf_groups_master gm
+	LEFT JOIN wf_group_users gu ON gu.group_id = gm.id
+	LEFT JOIN wf_users_master um ON um.id = gu.user_id
+	WHERE gm.group_type = ` + GroupGeneral.sql() + `
+	ORDER BY gm.id
+	`
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[string][]string{}
+	for rows.Next() {
+		var name string
+		var email sql.NullString
+		err = rows.Scan(&name, &email)
+		if err != nil {
+			return nil, err
+		}
+		ary := res[name]
+		if email.Valid {
+			ary = append(ary, email.String)
+		}
+		res[name] = ary
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// diffDirectory compares the directory's view with the local one.
+// E-mail addresses are compared case-insensitively.
+func diffDirectory(dusers []*DirectoryUser, dgroups []*DirectoryGroup,
+	lusers []*User, lgroups map[string][]string) *DirectorySyncDiff {
+	diff := &DirectorySyncDiff{
+		UsersAdded:       []*DirectoryUser{},
+		UsersUpdated:     []*DirectoryUser{},
+		UsersDeactivated: []*User{},
+		GroupsAdded:      []string{},
+		MembersAdded:     map[string][]string{},
+		MembersRemoved:   map[string][]string{},
+	}
+
+	// Users.
+
+	lu := make(map[string]*User, len(lusers))
+	for _, u := range lusers {
+		lu[strings.ToLower(u.Email)] = u
+	}
+	seen := make(map[string]struct{}, len(dusers))
+	for _, du := range dusers {
+		key := strings.ToLower(strings.TrimSpace(du.Email))
+		if key == "" {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		u, ok := lu[key]
+		switch {
+		case !ok:
+			diff.UsersAdded = append(diff.UsersAdded, du)
+
+		case u.FirstName != du.FirstName || u.LastName != du.LastName || u.Active != du.Active:
+			diff.UsersUpdated = append(diff.UsersUpdated, du)
+		}
+	}
+	for key, u := range lu {
+		if _, ok := seen[key]; !ok && u.Active {
+			diff.UsersDeactivated = append(diff.UsersDeactivated, u)
+		}
+	}
+	sort.Slice(diff.UsersDeactivated, func(i, j int) bool {
+		return diff.UsersDeactivated[i].ID < diff.UsersDeactivated[j].ID
+	})
+
+	// Groups and memberships.  Groups absent from the directory are
+	// left alone; they may be local to `flow`.
+
+	for _, dg := range dgroups {
+		name := strings.TrimSpace(dg.Name)
+		if name == "" {
+			continue
+		}
+		members, ok := lgroups[name]
+		if !ok {
+			diff.GroupsAdded = append(diff.GroupsAdded, name)
+		}
+
+		have := make(map[string]struct{}, len(members))
+		for _, m := range members {
+			have[strings.ToLower(m)] = struct{}{}
+		}
+		want := make(map[string]struct{}, len(dg.Members))
+		for _, m := range dg.Members {
+			key := strings.ToLower(strings.TrimSpace(m))
+			if key == "" {
+				continue
+			}
+			want[key] = struct{}{}
+			if _, ok := have[key]; !ok {
+				diff.MembersAdded[name] = append(diff.MembersAdded[name], key)
+			}
+		}
+		for _, m := range members {
+			if _, ok := want[strings.ToLower(m)]; !ok {
+				diff.MembersRemoved[name] = append(diff.MembersRemoved[name], m)
+			}
+		}
+	}
+
+	return diff
+}
+
+// Apply writes the given diff to the database.  New users get their
+// singleton groups created as well.
+func (_DirectorySync) Apply(otx *sql.Tx, diff *DirectorySyncDiff) error {
+	if diff == nil {
+		return newError(CodeValidation, "diff should be non-nil")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		var err error
+		q := `
+		INSERT INTO wf_users_master(first_name, last_name, email, active)
+		VALUES(?, ?, ?, ?)
+		`
+		for _, du := range diff.UsersAdded {
+			res, err := tx.Exec(q, du.FirstName, du.LastName, strings.TrimSpace(du.Email), du.Active)
+			if err != nil {
+				return err
+			}
+			uid, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			_, err = Groups.NewSingleton(tx, UserID(uid))
+			if err != nil {
+				return err
+			}
+		}
+
+		q = `
+		UPDATE wf_users_master SET first_name = ?, last_name = ?, active = ?
+		WHERE email = ?
+		`
+		for _, du := range diff.UsersUpdated {
+			_, err = tx.Exec(q, du.FirstName, du.LastName, du.Active, strings.TrimSpace(du.Email))
+			if err != nil {
+				return err
+			}
+		}
+
+		q = `UPDATE wf_users_master SET active = 0 WHERE id = ?`
+		for _, u := range diff.UsersDeactivated {
+			_, err = tx.Exec(q, u.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, name := range diff.GroupsAdded {
+			_, err = Groups.New(tx, name, GroupGeneral)
+			if err != nil {
+				return err
+			}
+		}
+
+		q = `
+		INSERT INTO wf_group_users(group_id, user_id)
+		SELECT gm.id, um.id
+		FROM wf_groups_master gm, wf_users_master um
+		WHERE gm.name = ?
+		AND gm.group_type = ` + GroupGeneral.sql() + `
+		AND um.email = ?
+		`
+		for name, emails := range diff.MembersAdded {
+			for _, email := range emails {
+				_, err = tx.Exec(q, name, email)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		q = `
+		DELETE gu
+		FROM wf_group_users gu
+		JOIN wf_groups_master gm ON gm.id = gu.group_id
+		JOIN wf_users_master um ON um.id = gu.user_id
+		WHERE gm.name = ?
+		AND gm.group_type = ` + GroupGeneral.sql() + `
+		AND um.email = ?
+		`
+		for name, emails := range diff.MembersRemoved {
+			for _, email := range emails {
+				_, err = tx.Exec(q, name, email)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DirectorySyncReviewer is consulted with the result of each planned
+// synchronisation.  It should answer `true` if the diff should be
+// applied.  When planning fails, the diff is `nil` and `err` holds the
+// reason; the answer is then ignored.
+type DirectorySyncReviewer func(diff *DirectorySyncDiff, err error) bool
+
+// Run synchronises the given directory into `flow` every `interval`,
+// until the context is cancelled.  Each planned diff is first handed
+// to `review` as a dry run; it is applied only if approved.  A `nil`
+// reviewer approves every non-empty diff.
+func (_DirectorySync) Run(ctx context.Context, p DirectoryProvider, interval time.Duration, review DirectorySyncReviewer) error {
+	if interval <= 0 {
+		return newError(CodeValidation, "interval should be a positive duration")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		diff, err := DirectorySync.Plan(ctx, p)
+		apply := err == nil && !diff.IsEmpty()
+		if review != nil {
+			apply = review(diff, err) && apply
+		}
+		if apply {
+			err = DirectorySync.Apply(nil, diff)
+			if err != nil && review != nil {
+				review(nil, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"strings"
+)
+
+// LDAPEntry is a single entry answered by an LDAP search.
+type LDAPEntry struct {
+	DN         string              // Distinguished name of this entry
+	Attributes map[string][]string // Attribute name --> values
+}
+
+// Attr answers the first value of the given attribute, if any.
+func (e *LDAPEntry) Attr(name string) string {
+	vals := e.Attributes[name]
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// LDAPSearcher performs subtree searches against an LDAP server.
+//
+// `flow` does not bundle an LDAP client.  Applications wrap the
+// client of their choice in this interface, handling connection and
+// binding themselves.
+type LDAPSearcher interface {
+	Search(ctx context.Context, baseDN, filter string, attrs []string) ([]*LDAPEntry, error)
+}
+
+// LDAPProvider is a `DirectoryProvider` that reads users and groups
+// from an LDAP directory.
+//
+// Group membership attributes are expected to hold the DNs of the
+// member users, as is usual for `groupOfNames`.
+type LDAPProvider struct {
+	Conn LDAPSearcher // Required
+
+	UserBaseDN  string // Required
+	UserFilter  string // Defaults to `(objectClass=inetOrgPerson)`
+	GroupBaseDN string // Required
+	GroupFilter string // Defaults to `(objectClass=groupOfNames)`
+
+	AttrFirstName string // Defaults to `givenName`
+	AttrLastName  string // Defaults to `sn`
+	AttrEmail     string // Defaults to `mail`
+	AttrGroupName string // Defaults to `cn`
+	AttrMember    string // Defaults to `member`
+
+	// ExtraAttrs are further attributes of user entries to fetch, so
+	// that `IsActive` can examine them, e.g. `nsAccountLock`,
+	// `shadowExpire` or `userAccountControl`.
+	ExtraAttrs []string
+
+	// IsActive determines the account status of the given user entry,
+	// from its attributes named above.  If `nil`, all users found are
+	// treated as active.
+	IsActive func(*LDAPEntry) bool
+}
+
+// withDefaults answers a copy of this provider with empty settings
+// replaced by their defaults.
+func (p *LDAPProvider) withDefaults() LDAPProvider {
+	c := *p
+	def := func(s *string, v string) {
+		if *s == "" {
+			*s = v
+		}
+	}
+	def(&c.UserFilter, "(objectClass=inetOrgPerson)")
+	def(&c.GroupFilter, "(objectClass=groupOfNames)")
+	def(&c.AttrFirstName, "givenName")
+	def(&c.AttrLastName, "sn")
+	def(&c.AttrEmail, "mail")
+	def(&c.AttrGroupName, "cn")
+	def(&c.AttrMember, "member")
+	return c
+}
+
+// Fetch implements `DirectoryProvider`.
+func (p *LDAPProvider) Fetch(ctx context.Context) ([]*DirectoryUser, []*DirectoryGroup, error) {
+	if p.Conn == nil {
+		return nil, nil, newError(CodeValidation, "LDAP connection should be non-nil")
+	}
+	if p.UserBaseDN == "" || p.GroupBaseDN == "" {
+		return nil, nil, newError(CodeValidation, "user and group base DNs should be non-empty")
+	}
+	c := p.withDefaults()
+
+	attrs := append([]string{c.AttrFirstName, c.AttrLastName, c.AttrEmail}, c.ExtraAttrs...)
+	ues, err := c.Conn.Search(ctx, c.UserBaseDN, c.UserFilter, attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	users := make([]*DirectoryUser, 0, len(ues))
+	emails := make(map[string]string, len(ues)) // DN --> e-mail
+	for _, e := range ues {
+		email := e.Attr(c.AttrEmail)
+		if email == "" {
+			continue
+		}
+		active := true
+		if c.IsActive != nil {
+			active = c.IsActive(e)
+		}
+		users = append(users, &DirectoryUser{
+			FirstName: e.Attr(c.AttrFirstName),
+			LastName:  e.Attr(c.AttrLastName),
+			Email:     email,
+			Active:    active,
+		})
+		emails[strings.ToLower(e.DN)] = email
+	}
+
+	ges, err := c.Conn.Search(ctx, c.GroupBaseDN, c.GroupFilter, []string{c.AttrGroupName, c.AttrMember})
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := make([]*DirectoryGroup, 0, len(ges))
+	for _, e := range ges {
+		name := e.Attr(c.AttrGroupName)
+		if name == "" {
+			continue
+		}
+		g := &DirectoryGroup{Name: name, Members: []string{}}
+		for _, dn := range e.Attributes[c.AttrMember] {
+			if email, ok := emails[strings.ToLower(dn)]; ok {
+				g.Members = append(g.Members, email)
+			}
+		}
+		groups = append(groups, g)
+	}
+
+	return users, groups, nil
+}
@@ -0,0 +1,183 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SCIMProvider is a `DirectoryProvider` that reads users and groups
+// from a SCIM 2.0 service provider (RFC 7644).
+type SCIMProvider struct {
+	BaseURL  string       // E.g. `https://idp.example.com/scim/v2`; required
+	Token    string       // Bearer token, if any
+	Client   *http.Client // Defaults to `http.DefaultClient`
+	PageSize int          // Defaults to 100
+}
+
+// scimUser is the subset of the SCIM user resource that `flow` uses.
+type scimUser struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	Name     struct {
+		GivenName  string `json:"givenName"`
+		FamilyName string `json:"familyName"`
+	} `json:"name"`
+	Emails []struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary"`
+	} `json:"emails"`
+	Active *bool `json:"active"`
+}
+
+// email answers the primary e-mail address of this user, falling
+// back to the first listed one, and then to the user name.
+func (u *scimUser) email() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return u.UserName
+}
+
+// scimGroup is the subset of the SCIM group resource that `flow`
+// uses.
+type scimGroup struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Members     []struct {
+		Value string `json:"value"`
+	} `json:"members"`
+}
+
+// list pages through the given SCIM resource endpoint, invoking `fn`
+// with each page's raw resources.
+func (p *SCIMProvider) list(ctx context.Context, resource string, fn func(json.RawMessage) error) error {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	count := p.PageSize
+	if count <= 0 {
+		count = 100
+	}
+
+	start := 1
+	for {
+		u := fmt.Sprintf("%s/%s?startIndex=%d&count=%d", strings.TrimRight(p.BaseURL, "/"), resource, start, count)
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Accept", "application/scim+json")
+		if p.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+p.Token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			TotalResults int               `json:"totalResults"`
+			Resources    []json.RawMessage `json:"Resources"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return errorf(CodeInternal, "SCIM %s : unexpected HTTP status : %s", resource, resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, r := range page.Resources {
+			if err = fn(r); err != nil {
+				return err
+			}
+		}
+
+		start += len(page.Resources)
+		if len(page.Resources) == 0 || start > page.TotalResults {
+			return nil
+		}
+	}
+}
+
+// Fetch implements `DirectoryProvider`.
+func (p *SCIMProvider) Fetch(ctx context.Context) ([]*DirectoryUser, []*DirectoryGroup, error) {
+	if _, err := url.Parse(p.BaseURL); err != nil || p.BaseURL == "" {
+		return nil, nil, newError(CodeValidation, "SCIM base URL should be a valid URL")
+	}
+
+	users := []*DirectoryUser{}
+	emails := map[string]string{} // SCIM ID --> e-mail
+	err := p.list(ctx, "Users", func(raw json.RawMessage) error {
+		var su scimUser
+		if err := json.Unmarshal(raw, &su); err != nil {
+			return err
+		}
+		email := su.email()
+		if email == "" {
+			return nil
+		}
+		users = append(users, &DirectoryUser{
+			FirstName: su.Name.GivenName,
+			LastName:  su.Name.FamilyName,
+			Email:     email,
+			Active:    su.Active == nil || *su.Active,
+		})
+		emails[su.ID] = email
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := []*DirectoryGroup{}
+	err = p.list(ctx, "Groups", func(raw json.RawMessage) error {
+		var sg scimGroup
+		if err := json.Unmarshal(raw, &sg); err != nil {
+			return err
+		}
+		if sg.DisplayName == "" {
+			return nil
+		}
+		g := &DirectoryGroup{Name: sg.DisplayName, Members: []string{}}
+		for _, m := range sg.Members {
+			if email, ok := emails[m.Value]; ok {
+				g.Members = append(g.Members, email)
+			}
+		}
+		groups = append(groups, g)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return users, groups, nil
+}
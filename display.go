@@ -0,0 +1,112 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// Display is the presentation metadata of a document state or action,
+// so that the several user interfaces of an application render them
+// alike -- e.g. `Approved` as a green check mark.  `flow` does not
+// interpret it.
+type Display struct {
+	Color       string `json:"Color,omitempty"`       // `#rgb`, `#rrggbb` or a CSS colour name
+	Icon        string `json:"Icon,omitempty"`        // Name of an icon in the application's icon set, e.g. `check`
+	Description string `json:"Description,omitempty"` // Longer explanation, e.g. for tooltips
+}
+
+// Limits on the lengths of the presentation metadata.
+const (
+	maxIconLen        = 50
+	maxDescriptionLen = 500
+)
+
+var reDisplayColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[a-zA-Z]{1,30})$`)
+
+// validate trims and checks this metadata.
+func (d *Display) validate(v *validator) {
+	d.Color = strings.TrimSpace(d.Color)
+	d.Icon = strings.TrimSpace(d.Icon)
+	d.Description = strings.TrimSpace(d.Description)
+
+	if d.Color != "" && !reDisplayColor.MatchString(d.Color) {
+		v.fail("Color", "should be #rgb, #rrggbb or a colour name")
+	}
+	if len(d.Icon) > maxIconLen {
+		v.fail("Icon", "too long")
+	}
+	if len(d.Description) > maxDescriptionLen {
+		v.fail("Description", "too long")
+	}
+}
+
+// setDisplay stores the given presentation metadata in the given
+// master table.
+func setDisplay(otx *sql.Tx, tbl string, id int64, d *Display) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
+	var dd Display
+	if d != nil {
+		dd = *d
+	}
+	d = &dd
+	var v validator
+	v.positive("id", id)
+	d.validate(&v)
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	q := `UPDATE ` + tbl + ` SET color = ?, icon = ?, description = ? WHERE id = ?`
+	res, err := stmts.exec(otx, q, d.Color, d.Icon, d.Description, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// The row may exist, having the given metadata already.
+		row, err := stmts.queryRow(otx, `SELECT COUNT(*) FROM `+tbl+` WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		if err = row.Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
+
+// SetDisplay sets the presentation metadata of the given document
+// state, replacing the existing one.  A `nil` value clears it.
+func (_DocStates) SetDisplay(otx *sql.Tx, id DocStateID, d *Display) error {
+	defer masters.changed(otx, masterDocStates)
+
+	return notFound(setDisplay(otx, "wf_docstates_master", int64(id), d), ErrDocStateNotFound)
+}
+
+// SetDisplay sets the presentation metadata of the given document
+// action, replacing the existing one.  A `nil` value clears it.
+func (_DocActions) SetDisplay(otx *sql.Tx, id DocActionID, d *Display) error {
+	defer masters.changed(otx, masterDocActions)
+
+	return notFound(setDisplay(otx, "wf_docactions_master", int64(id), d), ErrDocActionNotFound)
+}
@@ -17,12 +17,15 @@ package flow
 
 import (
 	"database/sql"
-	"log"
 )
 
 const (
 	// DefACRoleCount is the default number of roles a group can have
 	// in an access context.
+	//
+	// Deprecated: it was never enforced.  Limit the number of roles
+	// using `AccessContexts.SetRoleLimit`, or the setting
+	// `SettingACRoleLimit`.
 	DefACRoleCount = 1
 )
 
@@ -31,19 +34,20 @@ var blobsDir string
 
 //
 
-func init() {
-	f := log.Flags()
-	log.SetFlags(f | log.Lmicroseconds | log.Lshortfile)
-}
-
 // RegisterDB provides an already initialised database handle to `flow`.
 //
+// The handle should be opened with `parseTime=true` in its DSN, so
+// that time stamps (event times, notification posting times, etc.)
+// can be read back as `time.Time` values.
+//
 // N.B. This method **MUST** be called before anything else in `flow`.
 func RegisterDB(sdb *sql.DB) error {
 	if sdb == nil {
-		log.Fatal("given database handle is `nil`")
+		writeLog(LogError, "given database handle is `nil`")
+		return newError(CodeValidation, "given database handle is `nil`")
 	}
 	db = sdb
+	stmts.reset(sdb)
 
 	return nil
 }
@@ -60,7 +64,8 @@ func RegisterDB(sdb *sql.DB) error {
 // corresponding documents get corrupted.
 func SetBlobsDir(base string) error {
 	if base == "" {
-		log.Fatal("given base directory path is empty")
+		writeLog(LogError, "given base directory path is empty")
+		return newError(CodeValidation, "given base directory path is empty")
 	}
 	blobsDir = base
 
@@ -16,7 +16,6 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
 	"math"
 	"strings"
 )
@@ -42,9 +41,14 @@ type DocActionID int64
 //
 // N.B. All document actions must be defined as constant strings.
 type DocAction struct {
-	ID        DocActionID `json:"ID"`        // Unique identifier of this action
-	Name      string      `json:"Name"`      // Globally-unique name of this action
-	Reconfirm bool        `json:"Reconfirm"` // Should the user be prompted for a reconfirmation of this action?
+	ID          DocActionID `json:"ID"`                    // Unique identifier of this action
+	Name        string      `json:"Name"`                  // Globally-unique name of this action
+	Reconfirm   bool        `json:"Reconfirm"`             // Should the user be prompted for a reconfirmation of this action?
+	Active      bool        `json:"Active"`                // Can this action be performed?
+	Label       string      `json:"Label,omitempty"`       // Localised display label, when requested
+	Color       string      `json:"Color,omitempty"`       // Display colour; see `Display`
+	Icon        string      `json:"Icon,omitempty"`        // Display icon; see `Display`
+	Description string      `json:"Description,omitempty"` // Description for display; see `Display`
 }
 
 // Unexported type, only for convenience methods.
@@ -54,47 +58,44 @@ type _DocActions struct{}
 // in the system.
 var DocActions _DocActions
 
-// New creates and registers a new document action in the system.
+// New creates and registers a new document action in the system.  New
+// actions are active.
 func (_DocActions) New(otx *sql.Tx, name string, reconfirm bool) (DocActionID, error) {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return 0, err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, errors.New("document action cannot be empty")
+	var v validator
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_docactions_master", name, 0)
+	if err := v.result(); err != nil {
+		return 0, err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	var aid int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		var err error
+		var res sql.Result
+		if reconfirm {
+			res, err = tx.Exec("INSERT INTO wf_docactions_master(name, reconfirm, active) VALUES(?, ?, 1)", name, 1)
+		} else {
+			res, err = tx.Exec("INSERT INTO wf_docactions_master(name, reconfirm, active) VALUES(?, ?, 1)", name, 0)
+		}
 		if err != nil {
-			return 0, err
+			return err
+		}
+		aid, err = res.LastInsertId()
+		if err != nil {
+			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	var res sql.Result
-	if reconfirm {
-		res, err = tx.Exec("INSERT INTO wf_docactions_master(name, reconfirm) VALUES(?, ?)", name, 1)
-	} else {
-		res, err = tx.Exec("INSERT INTO wf_docactions_master(name, reconfirm) VALUES(?, ?)", name, 0)
-	}
-	if err != nil {
-		return 0, err
-	}
-	var aid int64
-	aid, err = res.LastInsertId()
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
-		}
-	}
-
 	return DocActionID(aid), nil
 }
 
@@ -104,21 +105,23 @@ func (_DocActions) New(otx *sql.Tx, name string, reconfirm bool) (DocActionID, e
 // Result set begins with ID >= `offset`, and has not more than
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
-func (_DocActions) List(offset, limit int64) ([]*DocAction, error) {
+//
+// `WithLocale` fills in the localised labels of the actions.
+func (_DocActions) List(offset, limit int64, opts ...ReadOption) ([]*DocAction, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
 	}
 
 	q := `
-	SELECT id, name, reconfirm
+	SELECT id, name, reconfirm, active, color, icon, description
 	FROM wf_docactions_master
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := readDB().Query(q, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +130,7 @@ func (_DocActions) List(offset, limit int64) ([]*DocAction, error) {
 	ary := make([]*DocAction, 0, 10)
 	for rows.Next() {
 		var elem DocAction
-		err = rows.Scan(&elem.ID, &elem.Name, &elem.Reconfirm)
+		err = rows.Scan(&elem.ID, &elem.Name, &elem.Reconfirm, &elem.Active, &elem.Color, &elem.Icon, &elem.Description)
 		if err != nil {
 			return nil, err
 		}
@@ -137,73 +140,176 @@ func (_DocActions) List(offset, limit int64) ([]*DocAction, error) {
 		return nil, err
 	}
 
+	if err = localiseActions(applyReadOptions(opts), ary...); err != nil {
+		return nil, err
+	}
 	return ary, nil
 }
 
-// Get retrieves the document action for the given ID.
-func (_DocActions) Get(id DocActionID) (*DocAction, error) {
+// Get retrieves the document action for the given ID.  `WithLocale`
+// fills in its localised label.
+func (_DocActions) Get(id DocActionID, opts ...ReadOption) (*DocAction, error) {
 	if id <= 0 {
-		return nil, errors.New("ID should be a positive integer")
+		return nil, newError(CodeValidation, "ID should be a positive integer")
 	}
 
 	var elem DocAction
-	row := db.QueryRow("SELECT id, name, reconfirm FROM wf_docactions_master WHERE id = ?", id)
-	err := row.Scan(&elem.ID, &elem.Name, &elem.Reconfirm)
-	if err != nil {
-		return nil, err
+	if v, ok := masters.get(masterDocActions, id); ok {
+		elem = v.(DocAction)
+	} else {
+		q := `
+		SELECT id, name, reconfirm, active, color, icon, description
+		FROM wf_docactions_master
+		WHERE id = ?
+		`
+		row := readDB().QueryRow(q, id)
+		if err := row.Scan(&elem.ID, &elem.Name, &elem.Reconfirm, &elem.Active, &elem.Color, &elem.Icon,
+			&elem.Description); err != nil {
+			return nil, notFound(err, ErrDocActionNotFound)
+		}
+		masters.put(masterDocActions, elem, elem.ID, elem.Name)
 	}
 
+	if err := localiseActions(applyReadOptions(opts), &elem); err != nil {
+		return nil, err
+	}
 	return &elem, nil
 }
 
 // GetByName answers the document action, if one such with the given
-// name is registered; `nil` and the error, otherwise.
-func (_DocActions) GetByName(name string) (*DocAction, error) {
+// name is registered; `nil` and the error, otherwise.  `WithLocale`
+// fills in its localised label.
+func (_DocActions) GetByName(name string, opts ...ReadOption) (*DocAction, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
-		return nil, errors.New("document action cannot be empty")
+		return nil, newError(CodeValidation, "document action cannot be empty")
 	}
 
 	var elem DocAction
-	row := db.QueryRow("SELECT id, name, reconfirm FROM wf_docactions_master WHERE name = ?", name)
-	err := row.Scan(&elem.ID, &elem.Name, &elem.Reconfirm)
-	if err != nil {
-		return nil, err
+	if v, ok := masters.get(masterDocActions, name); ok {
+		elem = v.(DocAction)
+	} else {
+		q := `
+		SELECT id, name, reconfirm, active, color, icon, description
+		FROM wf_docactions_master
+		WHERE name = ?
+		`
+		row := readDB().QueryRow(q, name)
+		if err := row.Scan(&elem.ID, &elem.Name, &elem.Reconfirm, &elem.Active, &elem.Color, &elem.Icon,
+			&elem.Description); err != nil {
+			return nil, notFound(err, ErrDocActionNotFound)
+		}
+		masters.put(masterDocActions, elem, elem.ID, elem.Name)
 	}
 
+	if err := localiseActions(applyReadOptions(opts), &elem); err != nil {
+		return nil, err
+	}
 	return &elem, nil
 }
 
 // Rename renames the given document action.
 func (_DocActions) Rename(otx *sql.Tx, id DocActionID, name string) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return errors.New("name cannot be empty")
+	var v validator
+	v.positive("id", int64(id))
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_docactions_master", name, int64(id))
+	if err := v.result(); err != nil {
+		return err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	defer masters.changed(otx, masterDocActions)
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE wf_docactions_master SET name = ? WHERE id = ?", name, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	_, err = tx.Exec("UPDATE wf_docactions_master SET name = ? WHERE id = ?", name, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
+	return nil
+}
+
+// SetActive activates or deactivates the given document action.
+// Events performing inactive actions are rejected by `ApplyEvent`, and
+// inactive actions are omitted from the listings of permissions.  The
+// transitions and permissions involving them are retained, and come
+// into force again upon reactivation.
+func (_DocActions) SetActive(otx *sql.Tx, id DocActionID, active bool) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+	if id <= 0 {
+		return newError(CodeValidation, "ID should be a positive integer")
+	}
+
+	defer masters.changed(otx, masterDocActions)
+
+	res, err := stmts.exec(otx, "UPDATE wf_docactions_master SET active = ? WHERE id = ?", active, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// The action may exist, having the requested status already.
+		if _, err = DocActions.Get(id); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
+
+// Delete deletes the given document action from the system, if no
+// workflow transition or role uses it.  Actions performed by events
+// cannot be deleted; they should be deactivated instead.
+func (_DocActions) Delete(otx *sql.Tx, id DocActionID) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+	if id <= 0 {
+		return newError(CodeValidation, "ID should be a positive integer")
+	}
+
+	defer masters.changed(otx, masterDocActions)
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		var n int64
+		if err := tx.QueryRow("SELECT COUNT(*) FROM wf_docstate_transitions WHERE docaction_id = ?", id).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return newError(CodeConflict, "action is being used in at least one workflow transition; cannot delete")
+		}
+		if err := tx.QueryRow("SELECT COUNT(*) FROM wf_role_docactions WHERE docaction_id = ?", id).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return newError(CodeConflict, "action is being used in at least one role; cannot delete")
+		}
+
+		_, err := tx.Exec("DELETE FROM wf_i18n WHERE entity = ? AND entity_id = ?", string(I18nDocAction), id)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM wf_action_policies WHERE docaction_id = ?", id); err != nil {
+			return err
+		}
+		res, err := tx.Exec("DELETE FROM wf_docactions_master WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, _ = res.RowsAffected(); n == 0 {
+			return ErrDocActionNotFound
+		}
+		return nil
+	})
+}
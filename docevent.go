@@ -16,8 +16,6 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
-	"fmt"
 	"math"
 	"strings"
 	"time"
@@ -47,15 +45,16 @@ type DocEventID int64
 // from one state to another, usually in response to user actions.  It
 // is possible for system events to cause state transitions, as well.
 type DocEvent struct {
-	ID      DocEventID  `json:"ID"`        // Unique ID of this event
-	DocType DocTypeID   `json:"DocType"`   // Document type of the document to which this event is to be applied
-	DocID   DocumentID  `json:"DocID"`     // Document to which this event is to be applied
-	State   DocStateID  `json:"DocState"`  // Current state of the document must equal this
-	Action  DocActionID `json:"DocAction"` // Action performed by the user
-	Group   GroupID     `json:"Group"`     // Group (singleton) who caused this action
-	Text    string      `json:"Text"`      // Comment or other content
-	Ctime   time.Time   `json:"Ctime"`     // Time at which the event occurred
-	Status  EventStatus `json:"Status"`    // Status of this event
+	ID      DocEventID  `json:"ID"`               // Unique ID of this event
+	DocType DocTypeID   `json:"DocType"`          // Document type of the document to which this event is to be applied
+	DocID   DocumentID  `json:"DocID"`            // Document to which this event is to be applied
+	State   DocStateID  `json:"DocState"`         // Current state of the document must equal this
+	Action  DocActionID `json:"DocAction"`        // Action performed by the user
+	Group   GroupID     `json:"Group"`            // Group (singleton) who caused this action
+	Text    string      `json:"Text"`             // Comment or other content
+	Ctime   time.Time   `json:"Ctime"`            // Time at which the event occurred
+	Status  EventStatus `json:"Status"`           // Status of this event
+	Target  DocStateID  `json:"Target,omitempty"` // State to which a return action sends the document, if any
 }
 
 // StatusInDB answers the status of this event.
@@ -74,7 +73,7 @@ func (e *DocEvent) StatusInDB() (EventStatus, error) {
 		e.Status = EventStatusPending
 
 	default:
-		return 0, fmt.Errorf("unknown event status : %s", dstatus)
+		return 0, errorf(CodeInternal, "unknown event status : %s", dstatus)
 	}
 
 	return e.Status, nil
@@ -89,72 +88,208 @@ var DocEvents _DocEvents
 // DocEventsNewInput holds information needed to create a new document
 // event in the system.
 type DocEventsNewInput struct {
-	DocTypeID          // Type of the document; required
-	DocumentID         // Unique identifier of the document; required
-	DocStateID         // Document must be in this state for this event to be applied; required
-	DocActionID        // Action performed by `Group`; required
-	GroupID            // Group (user) who performed the action that raised this event; required
-	Text        string // Any comments or notes; required
+	DocTypeID              // Type of the document; required
+	DocumentID             // Unique identifier of the document; required
+	DocStateID             // Document must be in this state for this event to be applied; required
+	DocActionID            // Action performed by `Group`; required
+	GroupID                // Group (user) who performed the action that raised this event; required
+	Text        string     // Any comments or notes; required
+	Signature   []byte     // Detached signature over `Canonical()`, if any; see `DocEvents.VerifySignature`
+	Target      DocStateID // State to which a return action sends the document; see `Nodes.SetReturnAction`
+}
+
+// Validate checks this input, and answers all the problems found with
+// it as `ValidationErrors`; `nil` if there are none.
+func (input *DocEventsNewInput) Validate() error {
+	var v validator
+	input.check(&v)
+	return v.result()
+}
+
+// check records the problems found with this input.
+func (input *DocEventsNewInput) check(v *validator) {
+	v.positive("DocTypeID", int64(input.DocTypeID))
+	v.positive("DocumentID", int64(input.DocumentID))
+	v.positive("DocStateID", int64(input.DocStateID))
+	v.positive("DocActionID", int64(input.DocActionID))
+	v.positive("GroupID", int64(input.GroupID))
+	if input.Text == "" {
+		v.fail("Text", "please add comments or notes")
+	}
+	if len(input.Signature) > maxSignatureLen {
+		v.fail("Signature", "too long")
+	}
+	if input.Target < 0 {
+		v.fail("Target", "should be non-negative")
+	}
 }
 
 // New creates and initialises an event that transforms the document
-// that it refers to.
+// that it refers to.  It answers `ErrRateLimited` if the group has
+// raised too many events recently; see `SetRateLimit`.  Should the
+// policy of the action in force require a reason, the event's text
+// should provide one; see `ActionPolicy`.
 func (_DocEvents) New(otx *sql.Tx, input *DocEventsNewInput) (DocEventID, error) {
-	if input.DocTypeID <= 0 || input.DocumentID <= 0 || input.DocStateID <= 0 || input.DocActionID <= 0 || input.GroupID <= 0 {
-		return 0, errors.New("all identifiers should be positive integers")
-	}
-	if input.Text == "" {
-		return 0, errors.New("please add comments or notes")
+	if err := input.Validate(); err != nil {
+		return 0, err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		// Workflow is tracked at the level of root documents.
+
+		doc, err := Documents.Get(tx, input.DocTypeID, input.DocumentID)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		if err = limiter.allow(rateOpNew, input.GroupID, doc.AccCtx.ID); err != nil {
+			return err
+		}
+		if err = checkReason(tx, input, doc.AccCtx.ID); err != nil {
+			return err
+		}
+		rdtid, rdid, err := doc.Path.Root()
+		if err != nil {
+			return err
+		}
+		dtid, did := input.DocTypeID, input.DocumentID
+		if rdid > 0 { // A different document is the root.
+			dtid, did = rdtid, rdid
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	// Workflow is tracked at the level of root documents.
+		// Register the event using the root document.
 
-	doc, err := Documents.Get(tx, input.DocTypeID, input.DocumentID)
-	if err != nil {
-		return 0, err
-	}
-	rdtid, rdid, err := doc.Path.Root()
+		eid, err := nextID(IDKindEvent)
+		if err != nil {
+			return err
+		}
+		q := `
+		INSERT INTO wf_docevents(id, doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status)
+		VALUES(NULLIF(?, 0), ?, ?, ?, ?, ?, ?, NOW(), 'P')
+		`
+		res, err := tx.Exec(q, eid, dtid, did, input.DocStateID, input.DocActionID, input.GroupID, input.Text)
+		if err != nil {
+			return err
+		}
+		id, err = insertedID(eid, res)
+		if err != nil {
+			return err
+		}
+
+		// The signature is made over the input as given.
+		if len(input.Signature) > 0 {
+			if err = recordSignature(tx, DocEventID(id), input); err != nil {
+				return err
+			}
+		}
+		if input.Target > 0 {
+			return recordReturnTarget(tx, DocEventID(id), input.Target)
+		}
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
-	if rdid > 0 { // A different document is the root.
-		input.DocTypeID = rdtid
-		input.DocumentID = rdid
-	}
 
-	// Register the event using the root document.
+	return DocEventID(id), nil
+}
 
-	q := `
-	INSERT INTO wf_docevents(doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status)
-	VALUES(?, ?, ?, ?, ?, ?, NOW(), 'P')
-	`
-	res, err := tx.Exec(q, input.DocTypeID, input.DocumentID, input.DocStateID, input.DocActionID, input.GroupID, input.Text)
-	if err != nil {
-		return 0, err
+// DocEventsImportInput specifies a historical event, imported from
+// another system, together with its outcome.
+type DocEventsImportInput struct {
+	DocEventsNewInput
+	ToState DocStateID // State into which the event moved the document; required
+	Ctime   time.Time  // Time at which the event occurred; required
+}
+
+// Validate checks this input, and answers all the problems found with
+// it as `ValidationErrors`; `nil` if there are none.
+func (input *DocEventsImportInput) Validate() error {
+	var v validator
+	input.check(&v)
+	v.positive("ToState", int64(input.ToState))
+	switch {
+	case input.Ctime.IsZero():
+		v.fail("Ctime", "is required")
+
+	case input.Ctime.After(time.Now()):
+		v.fail("Ctime", "should not be in the future")
 	}
-	var id int64
-	id, err = res.LastInsertId()
-	if err != nil {
+	return v.result()
+}
+
+// Import records a historical event, as already applied, at the time
+// given.  The document moves into the given target state.  No messages
+// are posted, and no notifications are sent.  Imported events are
+// answered by `List` and the audit trail like any other.
+//
+// Import is meant for migrating documents from a legacy system: create
+// each document (see `DocumentsNewInput.Ctime`), import its events in
+// chronological order, and then let `flow` take over.  The document
+// should be in the event's state; else, `ErrDocEventStateMismatch` is
+// answered.  Since legacy histories need not follow the current
+// workflow, the transition itself is not checked.
+func (_DocEvents) Import(otx *sql.Tx, input *DocEventsImportInput) (DocEventID, error) {
+	if err := input.Validate(); err != nil {
 		return 0, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		doc, err := Documents.Get(tx, input.DocTypeID, input.DocumentID)
+		if err != nil {
+			return err
+		}
+		rdtid, rdid, err := doc.Path.Root()
 		if err != nil {
-			return 0, err
+			return err
 		}
+		dtid, did := input.DocTypeID, input.DocumentID
+		if rdid > 0 { // A different document is the root.
+			dtid, did = rdtid, rdid
+			if doc, err = Documents.Get(tx, dtid, did); err != nil {
+				return err
+			}
+		}
+		if doc.State.ID != input.DocStateID {
+			return ErrDocEventStateMismatch
+		}
+
+		eid, err := nextID(IDKindEvent)
+		if err != nil {
+			return err
+		}
+		q := `
+		INSERT INTO wf_docevents(id, doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status)
+		VALUES(NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?, 'A')
+		`
+		res, err := tx.Exec(q, eid, dtid, did, input.DocStateID, input.DocActionID, input.GroupID, input.Text, input.Ctime)
+		if err != nil {
+			return err
+		}
+		id, err = insertedID(eid, res)
+		if err != nil {
+			return err
+		}
+
+		q = `
+		INSERT INTO wf_docevent_application(doctype_id, doc_id, from_state_id, docevent_id, to_state_id, ctime)
+		VALUES(?, ?, ?, ?, ?, ?)
+		`
+		if _, err = tx.Exec(q, dtid, did, input.DocStateID, id, input.ToState, input.Ctime); err != nil {
+			return err
+		}
+		if err = Documents.setState(tx, dtid, did, input.ToState, 0); err != nil {
+			return err
+		}
+
+		if len(input.Signature) > 0 {
+			return recordSignature(tx, DocEventID(id), &input.DocEventsNewInput)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return DocEventID(id), nil
@@ -182,7 +317,7 @@ type DocEventsListInput struct {
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEvent, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -191,8 +326,10 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 	// Base query.
 
 	q := `
-	SELECT de.id, de.doctype_id, de.doc_id, de.docstate_id, de.docaction_id, de.group_id, de.data, de.ctime, de.status
+	SELECT de.id, de.doctype_id, de.doc_id, de.docstate_id, de.docaction_id, de.group_id, de.data, de.ctime, de.status,
+		COALESCE(det.to_state_id, 0)
 	FROM wf_docevents de
+	LEFT JOIN wf_docevent_targets det ON det.docevent_id = de.id
 	`
 
 	// Process input specification.
@@ -224,7 +361,7 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 		where = append(where, `status = 'P'`)
 
 	default:
-		return nil, fmt.Errorf("unknown event status specified in filter : %d", input.Status)
+		return nil, errorf(CodeValidation, "unknown event status specified in filter : %d", input.Status)
 	}
 
 	if input.GroupID > 0 {
@@ -256,7 +393,7 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 	LIMIT ? OFFSET ?
 	`
 	args = append(args, limit, offset)
-	rows, err := db.Query(q, args...)
+	rows, err := readDB().Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -267,7 +404,8 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 	ary := make([]*DocEvent, 0, 10)
 	for rows.Next() {
 		var elem DocEvent
-		err = rows.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Group, &text, &elem.Ctime, &dstatus)
+		err = rows.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Group, &text, &elem.Ctime, &dstatus,
+			&elem.Target)
 		if err != nil {
 			return nil, err
 		}
@@ -282,7 +420,7 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 			elem.Status = EventStatusPending
 
 		default:
-			return nil, fmt.Errorf("unknown event status : %s", dstatus)
+			return nil, errorf(CodeInternal, "unknown event status : %s", dstatus)
 		}
 		ary = append(ary, &elem)
 	}
@@ -297,21 +435,30 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 // event ID.
 func (_DocEvents) Get(eid DocEventID) (*DocEvent, error) {
 	if eid <= 0 {
-		return nil, errors.New("event ID should be a positive integer")
+		return nil, newError(CodeValidation, "event ID should be a positive integer")
 	}
 
+	return getDocEvent(readDB().QueryRow, eid)
+}
+
+// getDocEvent loads the given document event using the given query
+// function.
+func getDocEvent(queryRow func(string, ...interface{}) *sql.Row, eid DocEventID) (*DocEvent, error) {
 	var text sql.NullString
 	var dstatus string
 	var elem DocEvent
 	q := `
-	SELECT id, doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status
-	FROM wf_docevents
-	WHERE id = ?
+	SELECT de.id, de.doctype_id, de.doc_id, de.docstate_id, de.docaction_id, de.group_id, de.data, de.ctime, de.status,
+		COALESCE(det.to_state_id, 0)
+	FROM wf_docevents de
+	LEFT JOIN wf_docevent_targets det ON det.docevent_id = de.id
+	WHERE de.id = ?
 	`
-	row := db.QueryRow(q, eid)
-	err := row.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Group, &text, &elem.Ctime, &dstatus)
+	row := queryRow(q, eid)
+	err := row.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Group, &text, &elem.Ctime, &dstatus,
+		&elem.Target)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrDocEventNotFound)
 	}
 	if text.Valid {
 		elem.Text = text.String
@@ -324,8 +471,87 @@ func (_DocEvents) Get(eid DocEventID) (*DocEvent, error) {
 		elem.Status = EventStatusPending
 
 	default:
-		return nil, fmt.Errorf("unknown event status : %s", dstatus)
+		return nil, errorf(CodeInternal, "unknown event status : %s", dstatus)
 	}
 
 	return &elem, nil
 }
+
+// DocEventApplication records the application of an event to a
+// document: the transition it caused.
+type DocEventApplication struct {
+	ID        int64      `json:"ID"`        // Sequence number of this application
+	DocType   DocTypeID  `json:"DocType"`   // Type of the document
+	DocID     DocumentID `json:"DocID"`     // Document that transitioned
+	FromState DocStateID `json:"FromState"` // State before the transition
+	Event     DocEventID `json:"Event"`     // Event applied
+	ToState   DocStateID `json:"ToState"`   // State after the transition
+	Workflow  WorkflowID `json:"Workflow"`  // Workflow that applied the event; `0` if not recorded
+	Node      NodeID     `json:"Node"`      // Node that applied the event; `0` if not recorded
+	Ctime     time.Time  `json:"Ctime"`     // Time of the event
+	Applied   time.Time  `json:"Applied"`   // Time of the transition; that of the event, if not recorded
+}
+
+// Applications answers the transitions of the given document, in the
+// order in which they were applied.  Transitions recorded before their
+// times of application were (see `Applied`) answer those of their
+// events instead.
+func (_DocEvents) Applications(dtype DocTypeID, did DocumentID) ([]*DocEventApplication, error) {
+	var v validator
+	v.positive("document type ID", int64(dtype))
+	v.positive("document ID", int64(did))
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT dea.id, dea.doctype_id, dea.doc_id, dea.from_state_id, dea.docevent_id, dea.to_state_id,
+		COALESCE(dea.workflow_id, 0), COALESCE(dea.node_id, 0), de.ctime, COALESCE(dea.ctime, de.ctime)
+	FROM wf_docevent_application dea
+	JOIN wf_docevents de ON de.id = dea.docevent_id
+	WHERE dea.doctype_id = ?
+	AND dea.doc_id = ?
+	ORDER BY dea.id
+	`
+	return docEventApplications(q, dtype, did)
+}
+
+// ApplicationsByEvent answers the transitions caused by the given
+// event.  An event that has not been applied, or that did not
+// transition its document, has none.
+func (_DocEvents) ApplicationsByEvent(eid DocEventID) ([]*DocEventApplication, error) {
+	if eid <= 0 {
+		return nil, newError(CodeValidation, "event ID should be a positive integer")
+	}
+
+	q := `
+	SELECT dea.id, dea.doctype_id, dea.doc_id, dea.from_state_id, dea.docevent_id, dea.to_state_id,
+		COALESCE(dea.workflow_id, 0), COALESCE(dea.node_id, 0), de.ctime, COALESCE(dea.ctime, de.ctime)
+	FROM wf_docevent_application dea
+	JOIN wf_docevents de ON de.id = dea.docevent_id
+	WHERE dea.docevent_id = ?
+	ORDER BY dea.id
+	`
+	return docEventApplications(q, eid)
+}
+
+// docEventApplications answers the applications selected by the given
+// query.
+func docEventApplications(q string, args ...interface{}) ([]*DocEventApplication, error) {
+	ary := []*DocEventApplication{}
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
+		var elem DocEventApplication
+		err := scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.FromState, &elem.Event, &elem.ToState,
+			&elem.Workflow, &elem.Node, &elem.Ctime, &elem.Applied)
+		if err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
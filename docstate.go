@@ -16,7 +16,6 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
 	"math"
 	"strings"
 )
@@ -37,8 +36,12 @@ type DocStateID int64
 // altering the corresponding workflow definition to use the new one
 // instead.
 type DocState struct {
-	ID   DocStateID `json:"ID"`             // Unique identifier of this document state
-	Name string     `json:"Name,omitempty"` // Unique identifier of this state in its workflow
+	ID          DocStateID `json:"ID"`                    // Unique identifier of this document state
+	Name        string     `json:"Name,omitempty"`        // Unique identifier of this state in its workflow
+	Label       string     `json:"Label,omitempty"`       // Localised display label, when requested
+	Color       string     `json:"Color,omitempty"`       // Display colour; see `Display`
+	Icon        string     `json:"Icon,omitempty"`        // Display icon; see `Display`
+	Description string     `json:"Description,omitempty"` // Description for display; see `Display`
 }
 
 // Unexported type, only for convenience methods.
@@ -51,40 +54,35 @@ var DocStates _DocStates
 // New creates an enumerated state as defined by the consuming
 // application.
 func (_DocStates) New(otx *sql.Tx, name string) (DocStateID, error) {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return 0, err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, errors.New("name cannot be empty")
+	var v validator
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_docstates_master", name, 0)
+	if err := v.result(); err != nil {
+		return 0, err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT INTO wf_docstates_master(name) VALUES(?)", name)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	res, err := tx.Exec("INSERT INTO wf_docstates_master(name) VALUES(?)", name)
-	if err != nil {
-		return 0, err
-	}
-	var id int64
-	id, err = res.LastInsertId()
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
-		}
-	}
-
 	return DocStateID(id), nil
 }
 
@@ -94,21 +92,23 @@ func (_DocStates) New(otx *sql.Tx, name string) (DocStateID, error) {
 // Result set begins with ID >= `offset`, and has not more than
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
-func (_DocStates) List(offset, limit int64) ([]*DocState, error) {
+//
+// `WithLocale` fills in the localised labels of the states.
+func (_DocStates) List(offset, limit int64, opts ...ReadOption) ([]*DocState, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
 	}
 
 	q := `
-	SELECT id, name
+	SELECT id, name, color, icon, description
 	FROM wf_docstates_master
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := readDB().Query(q, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +117,7 @@ func (_DocStates) List(offset, limit int64) ([]*DocState, error) {
 	ary := make([]*DocState, 0, 10)
 	for rows.Next() {
 		var elem DocState
-		err = rows.Scan(&elem.ID, &elem.Name)
+		err = rows.Scan(&elem.ID, &elem.Name, &elem.Color, &elem.Icon, &elem.Description)
 		if err != nil {
 			return nil, err
 		}
@@ -127,79 +127,101 @@ func (_DocStates) List(offset, limit int64) ([]*DocState, error) {
 		return nil, err
 	}
 
+	if err = localiseStates(applyReadOptions(opts), ary...); err != nil {
+		return nil, err
+	}
 	return ary, nil
 }
 
-// Get retrieves the document state for the given ID.
-func (_DocStates) Get(id DocStateID) (*DocState, error) {
+// Get retrieves the document state for the given ID.  `WithLocale`
+// fills in its localised label.
+func (_DocStates) Get(id DocStateID, opts ...ReadOption) (*DocState, error) {
 	if id <= 0 {
-		return nil, errors.New("ID should be a positive integer")
+		return nil, newError(CodeValidation, "ID should be a positive integer")
 	}
 
 	var elem DocState
-	q := `
-	SELECT name
-	FROM wf_docstates_master
-	WHERE id = ?
-	`
-	row := db.QueryRow(q, id)
-	err := row.Scan(&elem.Name)
-	if err != nil {
-		return nil, err
+	if v, ok := masters.get(masterDocStates, id); ok {
+		elem = v.(DocState)
+	} else {
+		q := `
+		SELECT name, color, icon, description
+		FROM wf_docstates_master
+		WHERE id = ?
+		`
+		row := readDB().QueryRow(q, id)
+		if err := row.Scan(&elem.Name, &elem.Color, &elem.Icon, &elem.Description); err != nil {
+			return nil, notFound(err, ErrDocStateNotFound)
+		}
+		elem.ID = id
+		masters.put(masterDocStates, elem, elem.ID, elem.Name)
 	}
 
-	elem.ID = id
+	if err := localiseStates(applyReadOptions(opts), &elem); err != nil {
+		return nil, err
+	}
 	return &elem, nil
 }
 
 // GetByName answers the document state, if one with the given name is
-// registered; `nil` and the error, otherwise.
-func (_DocStates) GetByName(name string) (*DocState, error) {
+// registered; `nil` and the error, otherwise.  `WithLocale` fills in
+// its localised label.
+func (_DocStates) GetByName(name string, opts ...ReadOption) (*DocState, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
-		return nil, errors.New("document state name should be non-empty")
+		return nil, newError(CodeValidation, "document state name should be non-empty")
 	}
 
 	var elem DocState
-	row := db.QueryRow("SELECT id, name FROM wf_docstates_master WHERE name = ?", name)
-	err := row.Scan(&elem.ID, &elem.Name)
-	if err != nil {
-		return nil, err
+	if v, ok := masters.get(masterDocStates, name); ok {
+		elem = v.(DocState)
+	} else {
+		q := `
+		SELECT id, name, color, icon, description
+		FROM wf_docstates_master
+		WHERE name = ?
+		`
+		row := readDB().QueryRow(q, name)
+		if err := row.Scan(&elem.ID, &elem.Name, &elem.Color, &elem.Icon, &elem.Description); err != nil {
+			return nil, notFound(err, ErrDocStateNotFound)
+		}
+		masters.put(masterDocStates, elem, elem.ID, elem.Name)
 	}
 
+	if err := localiseStates(applyReadOptions(opts), &elem); err != nil {
+		return nil, err
+	}
 	return &elem, nil
 }
 
 // Rename renames the given document state.
 func (_DocStates) Rename(otx *sql.Tx, id DocStateID, name string) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return errors.New("name cannot be empty")
+	var v validator
+	v.positive("id", int64(id))
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_docstates_master", name, int64(id))
+	if err := v.result(); err != nil {
+		return err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	defer masters.changed(otx, masterDocStates)
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE wf_docstates_master SET name = ? WHERE id = ?", name, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	_, err = tx.Exec("UPDATE wf_docstates_master SET name = ? WHERE id = ?", name, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
@@ -16,7 +16,6 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
 	"math"
 	"strings"
@@ -65,67 +64,105 @@ func (_DocTypes) docStorName(dtid DocTypeID) string {
 
 // New creates and registers a new document type in the system.
 func (_DocTypes) New(otx *sql.Tx, name string) (DocTypeID, error) {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return 0, err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, errors.New("name cannot be empty")
+	var v validator
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_doctypes_master", name, 0)
+	if err := v.result(); err != nil {
+		return 0, err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT INTO wf_doctypes_master(name) VALUES(?)", name)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	res, err := tx.Exec("INSERT INTO wf_doctypes_master(name) VALUES(?)", name)
-	if err != nil {
-		return 0, err
-	}
-	var id int64
-	id, err = res.LastInsertId()
+		// In multi-tenant mode, documents are stored in a table having a
+		// tenant column, and are accessed through a tenant-scoped view.
+		tenancy, err := tenancyEnabled(tx)
+		if err != nil {
+			return err
+		}
+		tbl := DocTypes.docStorName(DocTypeID(id))
+		stor, tcol, tidx, sfx := tbl, "", "", ""
+		if tenancy {
+			stor, tcol, tidx, sfx = tbl+"_all", "tenant_id INT NOT NULL DEFAULT 0,", "INDEX (tenant_id),", "_all"
+			res, err = tx.Exec(`DROP VIEW IF EXISTS ` + tbl)
+			if err != nil {
+				return err
+			}
+		}
+		q := `DROP TABLE IF EXISTS ` + stor
+		res, err = tx.Exec(q)
+		if err != nil {
+			return err
+		}
+		q = `
+		CREATE TABLE ` + stor + ` (
+			id BIGINT NOT NULL AUTO_INCREMENT,
+			` + tcol + `
+			path VARCHAR(1000) NOT NULL,
+			ac_id INT NOT NULL,
+			docstate_id INT NOT NULL,
+			group_id INT NOT NULL,
+			ctime TIMESTAMP NOT NULL,
+			title VARCHAR(250) NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (id),
+			` + tidx + `
+			FOREIGN KEY (ac_id) REFERENCES wf_access_contexts` + sfx + `(id),
+			FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master` + sfx + `(id),
+			FOREIGN KEY (group_id) REFERENCES wf_groups_master` + sfx + `(id)
+		)
+		`
+		res, err = tx.Exec(q)
+		if err != nil {
+			return err
+		}
+		if tenancy {
+			if err = tenantScope(tx, tbl); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
+	return DocTypeID(id), nil
+}
 
-	tbl := DocTypes.docStorName(DocTypeID(id))
-	q := `DROP TABLE IF EXISTS ` + tbl
-	res, err = tx.Exec(q)
+// alterDocStor alters the table of the documents of the given type as
+// specified.  In multi-tenant mode, the table is the `_all`
+// counterpart, whose tenant-scoped view is then recreated.
+func alterDocStor(tx *sql.Tx, dtype DocTypeID, spec string) error {
+	tenancy, err := tenancyEnabled(tx)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	q = `
-	CREATE TABLE ` + tbl + ` (
-		id INT NOT NULL AUTO_INCREMENT,
-		path VARCHAR(1000) NOT NULL,
-		ac_id INT NOT NULL,
-		docstate_id INT NOT NULL,
-		group_id INT NOT NULL,
-		ctime TIMESTAMP NOT NULL,
-		title VARCHAR(250) NULL,
-		data TEXT NOT NULL,
-		PRIMARY KEY (id),
-		FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id),
-		FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
-		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
-	)
-	`
-	res, err = tx.Exec(q)
-	if err != nil {
-		return 0, err
+	tbl := DocTypes.docStorName(dtype)
+	stor := tbl
+	if tenancy {
+		stor = tbl + "_all"
 	}
-
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
-		}
+	if _, err = tx.Exec(`ALTER TABLE ` + stor + ` ` + spec); err != nil {
+		return err
 	}
-	return DocTypeID(id), nil
+	if tenancy {
+		return tenantScope(tx, tbl)
+	}
+	return nil
 }
 
 // List answers a subset of the document types, based on the input
@@ -136,7 +173,7 @@ func (_DocTypes) New(otx *sql.Tx, name string) (DocTypeID, error) {
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_DocTypes) List(offset, limit int64) ([]*DocType, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -148,7 +185,7 @@ func (_DocTypes) List(offset, limit int64) ([]*DocType, error) {
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := readDB().Query(q, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -173,16 +210,22 @@ func (_DocTypes) List(offset, limit int64) ([]*DocType, error) {
 // Get retrieves the document type for the given ID.
 func (_DocTypes) Get(id DocTypeID) (*DocType, error) {
 	if id <= 0 {
-		return nil, errors.New("ID should be a positive integer")
+		return nil, newError(CodeValidation, "ID should be a positive integer")
+	}
+
+	if v, ok := masters.get(masterDocTypes, id); ok {
+		elem := v.(DocType)
+		return &elem, nil
 	}
 
 	var elem DocType
-	row := db.QueryRow("SELECT id, name FROM wf_doctypes_master WHERE id = ?", id)
+	row := readDB().QueryRow("SELECT id, name FROM wf_doctypes_master WHERE id = ?", id)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrDocTypeNotFound)
 	}
 
+	masters.put(masterDocTypes, elem, elem.ID, elem.Name)
 	return &elem, nil
 }
 
@@ -191,48 +234,75 @@ func (_DocTypes) Get(id DocTypeID) (*DocType, error) {
 func (_DocTypes) GetByName(name string) (*DocType, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
-		return nil, errors.New("document type cannot be empty")
+		return nil, newError(CodeValidation, "document type cannot be empty")
+	}
+
+	if v, ok := masters.get(masterDocTypes, name); ok {
+		elem := v.(DocType)
+		return &elem, nil
 	}
 
 	var elem DocType
-	row := db.QueryRow("SELECT id, name FROM wf_doctypes_master WHERE name = ?", name)
+	row := readDB().QueryRow("SELECT id, name FROM wf_doctypes_master WHERE name = ?", name)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrDocTypeNotFound)
 	}
 
+	masters.put(masterDocTypes, elem, elem.ID, elem.Name)
 	return &elem, nil
 }
 
-// Rename renames the given document type.
-func (_DocTypes) Rename(otx *sql.Tx, id DocTypeID, name string) error {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return errors.New("name cannot be empty")
+// name answers the name of the given document type, from the cache if
+// possible.  A document type created in the given transaction is
+// looked up in it.
+func (_DocTypes) name(otx *sql.Tx, id DocTypeID) (string, error) {
+	if v, ok := masters.get(masterDocTypes, id); ok {
+		return v.(DocType).Name, nil
 	}
-
-	var tx *sql.Tx
-	var err error
 	if otx == nil {
-		tx, err = db.Begin()
+		elem, err := DocTypes.Get(id)
 		if err != nil {
-			return err
+			return "", err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
+		return elem.Name, nil
 	}
 
-	_, err = tx.Exec("UPDATE wf_doctypes_master SET name = ? WHERE id = ?", name, id)
-	if err != nil {
+	var name string
+	row := otx.QueryRow("SELECT name FROM wf_doctypes_master WHERE id = ?", id)
+	if err := row.Scan(&name); err != nil {
+		return "", notFound(err, ErrDocTypeNotFound)
+	}
+	return name, nil
+}
+
+// Rename renames the given document type.
+func (_DocTypes) Rename(otx *sql.Tx, id DocTypeID, name string) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	name = strings.TrimSpace(name)
+	var v validator
+	v.positive("id", int64(id))
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_doctypes_master", name, int64(id))
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	defer masters.changed(otx, masterDocTypes)
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE wf_doctypes_master SET name = ? WHERE id = ?", name, id)
 		if err != nil {
 			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -269,9 +339,9 @@ func (_DocTypes) Transitions(dtype DocTypeID, from DocStateID) (map[DocStateID]*
 	if from > 0 {
 		q += `AND dst.from_state_id = ?
 		`
-		rows, err = db.Query(q, dtype, from)
+		rows, err = readDB().Query(q, dtype, from)
 	} else {
-		rows, err = db.Query(q, dtype)
+		rows, err = readDB().Query(q, dtype)
 	}
 
 	if err != nil {
@@ -316,7 +386,7 @@ func (_DocTypes) _Transitions(dtype DocTypeID, state DocStateID) (map[DocActionI
 	WHERE doctype_id = ?
 	AND from_state_id = ?
 	`
-	rows, err := db.Query(q, dtype, state)
+	rows, err := stmts.query(nil, q, dtype, state)
 	if err != nil {
 		return nil, err
 	}
@@ -343,32 +413,24 @@ func (_DocTypes) _Transitions(dtype DocTypeID, state DocStateID) (map[DocActionI
 // action performed on documents in the given current state.
 func (_DocTypes) AddTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID,
 	action DocActionID, toState DocStateID) error {
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return err
-		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	q := `
-	INSERT INTO wf_docstate_transitions(doctype_id, from_state_id, docaction_id, to_state_id)
-	VALUES(?, ?, ?, ?)
-	`
-	_, err = tx.Exec(q, dtype, state, action, toState)
-	if err != nil {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_docstate_transitions(doctype_id, from_state_id, docaction_id, to_state_id)
+		VALUES(?, ?, ?, ?)
+		`
+		_, err := tx.Exec(q, dtype, state, action, toState)
 		if err != nil {
 			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -377,34 +439,26 @@ func (_DocTypes) AddTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID,
 // RemoveTransition disassociates a target document state with a
 // document action performed on documents in the given current state.
 func (_DocTypes) RemoveTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID, action DocActionID) error {
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return err
-		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	q := `
-	DELETE FROM wf_docstate_transitions
-	WHERE doctype_id = ?
-	AND from_state_id =?
-	AND docaction_id = ?
-	`
-	_, err = tx.Exec(q, dtype, state, action)
-	if err != nil {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		DELETE FROM wf_docstate_transitions
+		WHERE doctype_id = ?
+		AND from_state_id =?
+		AND docaction_id = ?
+		`
+		_, err := tx.Exec(q, dtype, state, action)
 		if err != nil {
 			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
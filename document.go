@@ -17,7 +17,6 @@ package flow
 import (
 	"crypto/sha1"
 	"database/sql"
-	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -33,8 +32,16 @@ var (
 	// reDocPath defines the regular expression for each component of
 	// a document's path.
 	reDocPath = regexp.MustCompile("[0-9]+?:[0-9]+?/")
+	// reDocPathFull defines the regular expression for a well-formed
+	// path as a whole.
+	reDocPathFull = regexp.MustCompile("^([1-9][0-9]*:[1-9][0-9]*/)*$")
 )
 
+// DefDocMaxDepth is the maximum nesting depth of documents, when
+// `SettingDocMaxDepth` is not set.  Root documents have a depth of
+// `0`.
+const DefDocMaxDepth = 32
+
 // DocPath helps in managing document hierarchies.  It provides a set
 // of utility methods that ease path management.
 type DocPath string
@@ -94,16 +101,106 @@ func (p *DocPath) Components() ([]struct {
 }
 
 // Append adds the given document type-document ID pair to this path,
-// updating it as a result.
+// updating it as a result.  The path should be well-formed, and should
+// not contain the given document already.
 func (p *DocPath) Append(dtid DocTypeID, did DocumentID) error {
 	if dtid <= 0 || did <= 0 {
-		return errors.New("document type ID and document ID should be positive integers")
+		return newError(CodeValidation, "document type ID and document ID should be positive integers")
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if p.Contains(dtid, did) {
+		return ErrDocPathInvalid
 	}
 
 	*p = *p + DocPath(fmt.Sprintf("%d:%d/", dtid, did))
 	return nil
 }
 
+// Validate checks that this path is well-formed: a sequence of
+// `<document type ID>:<document ID>/` components having positive
+// identifiers, in which no document repeats.  An empty path is valid.
+func (p *DocPath) Validate() error {
+	if !reDocPathFull.MatchString(string(*p)) {
+		return ErrDocPathInvalid
+	}
+
+	seen := map[string]struct{}{}
+	for _, comp := range reDocPath.FindAllString(string(*p), -1) {
+		if _, ok := seen[comp]; ok {
+			return ErrDocPathInvalid
+		}
+		seen[comp] = struct{}{}
+	}
+	return nil
+}
+
+// Contains answers `true` if the given document is a component of this
+// path, i.e. an ancestor of the documents having this path.
+func (p *DocPath) Contains(dtid DocTypeID, did DocumentID) bool {
+	return strings.HasPrefix(string(*p), fmt.Sprintf("%d:%d/", dtid, did)) ||
+		strings.Contains(string(*p), fmt.Sprintf("/%d:%d/", dtid, did))
+}
+
+// Depth answers the number of components of this path, i.e. the
+// nesting depth of the documents having this path.
+func (p *DocPath) Depth() int {
+	return len(reDocPath.FindAllString(string(*p), -1))
+}
+
+// Parent answers the last component of this path, i.e. the immediate
+// parent of the documents having this path.  It answers zeroes for an
+// empty path.
+func (p *DocPath) Parent() (DocTypeID, DocumentID, error) {
+	comps, err := p.Components()
+	if err != nil || len(comps) == 0 {
+		return 0, 0, err
+	}
+
+	last := comps[len(comps)-1]
+	return last.DocTypeID, last.DocumentID, nil
+}
+
+// checkDocPath checks the given path of a new child document of the
+// given type: that it is within the maximum nesting depth, and that
+// the parent, if a child itself, is registered as a child of its own
+// parent.
+func checkDocPath(tx *sql.Tx, dtype DocTypeID, ptype DocTypeID, pid DocumentID, path DocPath) error {
+	max, err := settingInt(tx.QueryRow, SettingDocMaxDepth, SettingScope{DocType: dtype}, DefDocMaxDepth)
+	if err != nil {
+		return err
+	}
+	if max > 0 && int64(path.Depth()) > max {
+		return ErrDocPathTooDeep
+	}
+
+	comps, err := path.Components()
+	if err != nil {
+		return err
+	}
+	if len(comps) < 2 {
+		return nil
+	}
+	gp := comps[len(comps)-2]
+	q := `
+	SELECT COUNT(*)
+	FROM wf_document_children
+	WHERE parent_doctype_id = ?
+	AND parent_id = ?
+	AND child_doctype_id = ?
+	AND child_id = ?
+	`
+	var n int64
+	if err = tx.QueryRow(q, gp.DocTypeID, gp.DocumentID, ptype, pid).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDocPathInconsistent
+	}
+	return nil
+}
+
 // Blob is a simple data holder for information concerning the
 // user-supplied name of the binary object, the path of the stored
 // binary object, and its SHA1 checksum.
@@ -146,6 +243,10 @@ type Document struct {
 
 	Title string `json:"Title"`          // Human-readable title; applicable only for root documents
 	Data  string `json:"Data,omitempty"` // Primary content of the document
+
+	Tags     []string      `json:"Tags,omitempty"`     // Tags of this document; answered upon request
+	Blobs    []*Blob       `json:"Blobs,omitempty"`    // Blobs of this document; answered upon request
+	Children []DocumentRef `json:"Children,omitempty"` // Children of this document; answered upon request
 }
 
 // Unexported type, only for convenience methods.
@@ -165,6 +266,28 @@ type DocumentsNewInput struct {
 	ParentID        DocumentID // Unique identifier of the parent document, if any
 	Title           string     // Title of the new document; applicable to only root (top-level) documents
 	Data            string     // Body of the new document; required
+	Ctime           time.Time  // Time of creation, when importing from another system; defaults to now
+
+	imported bool // Is the document being imported by an `Importer`?
+}
+
+// Validate checks this input, and answers all the problems found with
+// it as `ValidationErrors`; `nil` if there are none.
+func (input *DocumentsNewInput) Validate() error {
+	var v validator
+	v.positive("DocTypeID", int64(input.DocTypeID))
+	v.positive("AccessContextID", int64(input.AccessContextID))
+	v.positive("GroupID", int64(input.GroupID))
+	if input.ParentType != 0 || input.ParentID != 0 {
+		v.positive("ParentType", int64(input.ParentType))
+		v.positive("ParentID", int64(input.ParentID))
+	}
+	v.maxLen("Title", input.Title, maxTitleLen)
+	v.required("Data", input.Data)
+	if input.Ctime.After(time.Now()) {
+		v.fail("Ctime", "should not be in the future")
+	}
+	return v.result()
 }
 
 // New creates and initialises a document.
@@ -175,14 +298,22 @@ type DocumentsNewInput struct {
 // determined in the scope of the access context applicable to the
 // current state of the document.
 //
+// Should a duplicate policy be registered for the document type, and
+// an equivalent document exist, a `DuplicateDocumentError` identifying
+// it is answered.
+//
+// Should the creator have reached a quota on documents of the type, a
+// `QuotaExceededError` is answered, irrespective of `Ctime`.  Only the
+// documents imported by an `Importer` are exempt from quotas.
+//
+// Child documents can be nested up to the depth given by the setting
+// `SettingDocMaxDepth`; `ErrDocPathTooDeep` is answered beyond it.
+//
 // N.B. Blobs, tags and children documents have to be associated with
 // this document, if needed, through appropriate separate calls.
 func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error) {
-	if input.DocTypeID <= 0 || input.AccessContextID <= 0 || input.GroupID <= 0 {
-		return 0, errors.New("all identifiers should be positive integers")
-	}
-	if len(input.Data) == 0 {
-		return 0, errors.New("document's body should be non-empty")
+	if err := input.Validate(); err != nil {
+		return 0, err
 	}
 
 	var dsid int64
@@ -194,7 +325,9 @@ func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error)
 			return 0, err
 		}
 		path = pdoc.Path
-		path.Append(input.ParentType, input.ParentID)
+		if err = path.Append(input.ParentType, input.ParentID); err != nil {
+			return 0, err
+		}
 
 		// Child document does not have its own state.
 		dsid = 1 // `__RESERVED_CHILD_STATE__`
@@ -210,7 +343,7 @@ func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error)
 		if err != nil {
 			switch {
 			case err == sql.ErrNoRows:
-				return 0, errors.New("no active workflow is defined for the given document type")
+				return 0, newError(CodeNotFound, "no active workflow is defined for the given document type")
 
 			default:
 				return 0, err
@@ -218,62 +351,117 @@ func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error)
 		}
 	}
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return 0, err
-		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	tbl := DocTypes.docStorName(input.DocTypeID)
-	q2 := `INSERT INTO ` + tbl + `(path, ac_id, docstate_id, group_id, ctime, title, data)
-	VALUES (?, ?, ?, ?, NOW(), ?, ?)
-	`
-	res, err := tx.Exec(q2, string(path), input.AccessContextID, dsid, input.GroupID, input.Title, input.Data)
-	if err != nil {
-		return 0, err
-	}
-	id, err := res.LastInsertId()
+	dkey, err := Documents.duplicateKey(input)
 	if err != nil {
 		return 0, err
 	}
 
-	if input.ParentID > 0 {
-		q2 = `
-		INSERT INTO wf_document_children(parent_doctype_id, parent_id, child_doctype_id, child_id)
-		VALUES (?, ?, ?, ?)
+	var id int64
+	err = withTx(otx, func(tx *sql.Tx) error {
+		if dkey != "" {
+			if err := Documents.checkDuplicate(tx, input.DocTypeID, dkey, false); err != nil {
+				return err
+			}
+		}
+		if input.ParentID > 0 {
+			if err := checkDocPath(tx, input.DocTypeID, input.ParentType, input.ParentID, path); err != nil {
+				return err
+			}
+		}
+		if input.ParentID == 0 {
+			if err := checkContextCapacity(tx, input.DocTypeID, input.AccessContextID); err != nil {
+				return err
+			}
+			if !input.imported {
+				if err := checkQuotas(tx, input.DocTypeID, input.GroupID); err != nil {
+					return err
+				}
+			}
+		}
+
+		data, err := storeData(tx, input.DocTypeID, input.Data)
+		if err != nil {
+			return err
+		}
+		gid, err := nextID(IDKindDocument)
+		if err != nil {
+			return err
+		}
+		tbl := DocTypes.docStorName(input.DocTypeID)
+		q2 := `INSERT INTO ` + tbl + `(id, path, ac_id, docstate_id, group_id, ctime, title, data)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?, COALESCE(?, NOW()), ?, ?)
 		`
-		res, err = tx.Exec(q2, input.ParentType, input.ParentID, input.DocTypeID, id)
+		ctime := sql.NullTime{Time: input.Ctime, Valid: !input.Ctime.IsZero()}
+		res, err := tx.Exec(q2, gid, string(path), input.AccessContextID, dsid, input.GroupID, ctime, input.Title, data)
 		if err != nil {
-			return 0, err
+			return err
 		}
-	}
-
-	if otx == nil {
-		err = tx.Commit()
+		id, err = insertedID(gid, res)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		if err = derive(tx, input.DocTypeID, DocumentID(id), data); err != nil {
+			return err
+		}
+
+		if input.ParentID > 0 {
+			q2 = `
+			INSERT INTO wf_document_children(parent_doctype_id, parent_id, child_doctype_id, child_id)
+			VALUES (?, ?, ?, ?)
+			`
+			res, err = tx.Exec(q2, input.ParentType, input.ParentID, input.DocTypeID, id)
+			if err != nil {
+				return err
+			}
 		}
+
+		if dkey != "" {
+			if err = Documents.recordDuplicateKey(tx, input.DocTypeID, DocumentID(id), dkey); err != nil {
+				return err
+			}
+		}
+
+		delta := map[string]interface{}{"title": input.Title, "data": data, "state": dsid, "ac": input.AccessContextID}
+		return Documents.recordChange(tx, input.DocTypeID, DocumentID(id), ChangeCreated, delta)
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return DocumentID(id), nil
 }
 
+// Visibility enumerates the sets of documents visible in listings,
+// relative to the user on whose behalf they are listed.
+type Visibility uint8
+
+const (
+	// VisibilityAll does not filter documents.
+	VisibilityAll Visibility = iota
+	// VisibilityOwn selects only those documents created by the user.
+	VisibilityOwn
+	// VisibilityTeam selects only those documents created by the user,
+	// or by the members of groups in the reporting subtree of the
+	// user's groups, within the access context.
+	VisibilityTeam
+)
+
 // DocumentsListInput specifies a set of filter conditions to narrow
 // down document listings.
 type DocumentsListInput struct {
-	DocTypeID                 // Documents of this type are listed; required
-	AccessContextID           // Access context from within which to list; required
-	GroupID                   // List documents created by this (singleton) group
-	DocStateID                // List documents currently in this state
-	CtimeStarting   time.Time // List documents created after this time
-	CtimeBefore     time.Time // List documents created before this time
-	TitleContains   string    // List documents whose title contains the given text; expensive operation
-	RootOnly        bool      // List only root (top-level) documents
+	DocTypeID                        // Documents of this type are listed; required
+	AccessContextID                  // Access context from within which to list; required
+	GroupID                          // List documents created by this (singleton) group
+	DocStateID                       // List documents currently in this state
+	CtimeStarting   time.Time        // List documents created after this time
+	CtimeBefore     time.Time        // List documents created before this time
+	TitleContains   string           // List documents whose title contains the given text; expensive operation
+	RootOnly        bool             // List only root (top-level) documents
+	Visibility      Visibility       // List documents visible to the user given by the option `AsUser`; requires that option
+	DataWhere       []DataPredicate  // List documents whose data satisfies all these; requires JSON storage
+	FieldWhere      []FieldPredicate // List documents whose derived fields satisfy all these
+	SortBy          string           // Derived field by which to order the documents, if any; they are ordered by ID otherwise
+	SortDesc        bool             // Order the documents by `SortBy` descending?
 }
 
 // List answers a subset of the documents based on the input
@@ -282,13 +470,23 @@ type DocumentsListInput struct {
 // Result set begins with ID >= `offset`, and has not more than
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
-func (_Documents) List(input *DocumentsListInput, offset, limit int64) ([]*Document, error) {
+//
+// With the option `AsUser`, the documents are redacted as per the
+// `Redactions` policies applicable to that user, and can be filtered
+// by their visibility to that user.  The options `WithBlobs`,
+// `WithTags` and `WithChildren` answer the respective collections of
+// all the listed documents, using one query each.
+func (_Documents) List(input *DocumentsListInput, offset, limit int64, opts ...ReadOption) ([]*Document, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
 	}
+	o := applyReadOptions(opts)
+	if input.Visibility != VisibilityAll && o.user <= 0 {
+		return nil, newError(CodeValidation, "visibility filter requires the acting user")
+	}
 
 	// Base query.
 
@@ -336,19 +534,94 @@ func (_Documents) List(input *DocumentsListInput, offset, limit int64) ([]*Docum
 		where = append(where, `docs.path = ''`)
 	}
 
+	if len(input.DataWhere) > 0 {
+		cfg, err := dataConfig(nil, input.DocTypeID)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Storage != DataStorageJSON {
+			return nil, newError(CodeValidation, "data predicates require JSON storage of the document type")
+		}
+		for i := range input.DataWhere {
+			cond, cargs, err := input.DataWhere[i].sql()
+			if err != nil {
+				return nil, err
+			}
+			where = append(where, cond)
+			args = append(args, cargs...)
+		}
+	}
+
+	switch input.Visibility {
+	case VisibilityOwn:
+		where = append(where, `docs.group_id IN (
+			SELECT gm.id
+			FROM wf_groups_master gm
+			JOIN wf_group_users gu ON gu.group_id = gm.id
+			WHERE gm.group_type = ` + GroupSingleton.sql() + `
+			AND gu.user_id = ?
+		)`)
+		args = append(args, o.user)
+
+	case VisibilityTeam:
+		gids, err := AccessContexts.userSubtree(input.AccessContextID, o.user)
+		if err != nil {
+			return nil, err
+		}
+		cond := `docs.group_id IN (
+			SELECT sgm.id
+			FROM wf_groups_master sgm
+			JOIN wf_group_users sgu ON sgu.group_id = sgm.id
+			WHERE sgm.group_type = ` + GroupSingleton.sql() + `
+			AND (sgu.user_id = ?`
+		args = append(args, o.user)
+		if len(gids) > 0 {
+			cond += ` OR sgu.user_id IN (
+				SELECT tgu.user_id
+				FROM wf_group_users tgu
+				WHERE tgu.group_id IN ` + inPlaceholders(len(gids)) + `
+			)`
+			for _, gid := range gids {
+				args = append(args, gid)
+			}
+		}
+		where = append(where, cond+`))`)
+	}
+
+	for i := range input.FieldWhere {
+		cond, cargs, err := input.FieldWhere[i].sql(input.DocTypeID)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, cond)
+		args = append(args, cargs...)
+	}
+
 	if len(where) > 0 {
 		q += ` AND ` + strings.Join(where, ` AND `)
 	}
 
+	order := `docs.id`
+	if input.SortBy != "" {
+		f, err := derivedField(input.DocTypeID, input.SortBy)
+		if err != nil {
+			return nil, err
+		}
+		dir := ``
+		if input.SortDesc {
+			dir = ` DESC`
+		}
+		order = `docs.` + derivedColumn(f.Name) + dir + `, docs.id`
+	}
 	q += `
-	ORDER BY docs.id
+	ORDER BY ` + order + `
 	LIMIT ? OFFSET ?
 	`
 	args = append(args, limit, offset)
 
 	// Fetch document data.
 
-	rows, err := db.Query(q, args...)
+	rows, err := readDB().Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -365,7 +638,7 @@ func (_Documents) List(input *DocumentsListInput, offset, limit int64) ([]*Docum
 
 		elem.DocType.ID = input.DocTypeID
 		q2 := `SELECT name FROM wf_doctypes_master WHERE id = ?`
-		row2 := db.QueryRow(q2, input.DocTypeID)
+		row2 := readDB().QueryRow(q2, input.DocTypeID)
 		err = row2.Scan(&elem.DocType.Name)
 		if err != nil {
 			return nil, err
@@ -380,47 +653,326 @@ func (_Documents) List(input *DocumentsListInput, offset, limit int64) ([]*Docum
 		return nil, err
 	}
 
+	if err = Documents.loadCollections(nil, input.DocTypeID, o, ary...); err != nil {
+		return nil, err
+	}
+	if err = Redactions.apply(nil, input.DocTypeID, o.user, ary...); err != nil {
+		return nil, err
+	}
+
 	return ary, nil
 }
 
 // Get initialises a document by reading from the database.
 //
 // N.B. This retrieves the primary data of the document.  Other
-// information viz. blobs, tags and children documents have to be
-// fetched separately.
-func (_Documents) Get(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Document, error) {
+// information viz. blobs, tags and children documents are fetched
+// only upon request, using the options `WithBlobs`, `WithTags` and
+// `WithChildren`.  The option `WithoutData` skips the data.
+//
+// With the option `AsUser`, the document is redacted as per the
+// `Redactions` policies applicable to that user.
+func (_Documents) Get(otx *sql.Tx, dtype DocTypeID, id DocumentID, opts ...ReadOption) (*Document, error) {
+	o := applyReadOptions(opts)
+	dcol := `docs.data`
+	if o.noData {
+		dcol = `''`
+	}
+
 	tbl := DocTypes.docStorName(dtype)
 	var elem Document
 	q := `
-	SELECT docs.path, docs.ac_id, docs.group_id, gm.name, docs.ctime, docs.title, docs.data, docs.docstate_id, dsm.name
+	SELECT docs.path, docs.ac_id, docs.group_id, gm.name, docs.ctime, docs.title, ` + dcol + `, docs.docstate_id, dsm.name
 	FROM ` + tbl + ` AS docs
 	JOIN wf_groups_master gm ON gm.id = docs.group_id
 	JOIN wf_docstates_master dsm ON docs.docstate_id = dsm.id
 	WHERE docs.id = ?
 	`
 
-	var row *sql.Row
+	c := stmts
 	if otx == nil {
-		row = db.QueryRow(q, id)
-	} else {
-		row = otx.QueryRow(q, id)
+		c = readStmts()
 	}
-	err := row.Scan(&elem.Path, &elem.AccCtx.ID, &elem.Group.ID, &elem.Group.Name, &elem.Ctime, &elem.Title, &elem.Data, &elem.State.ID, &elem.State.Name)
+	row, err := c.queryRow(otx, q, id)
 	if err != nil {
 		return nil, err
 	}
-	q = `SELECT name FROM wf_doctypes_master WHERE id = ?`
-	row = db.QueryRow(q, dtype)
-	err = row.Scan(&elem.DocType.Name)
+	err = row.Scan(&elem.Path, &elem.AccCtx.ID, &elem.Group.ID, &elem.Group.Name, &elem.Ctime, &elem.Title, &elem.Data, &elem.State.ID, &elem.State.Name)
 	if err != nil {
+		return nil, notFound(err, ErrDocumentNotFound)
+	}
+	if elem.DocType.Name, err = DocTypes.name(otx, dtype); err != nil {
 		return nil, err
 	}
+	if !o.noData {
+		if elem.Data, err = loadData(otx, dtype, elem.Data); err != nil {
+			return nil, err
+		}
+	}
 
 	elem.ID = id
 	elem.DocType.ID = dtype
+
+	if err = Documents.loadCollections(otx, dtype, o, &elem); err != nil {
+		return nil, err
+	}
+	if err = Redactions.apply(otx, dtype, o.user, &elem); err != nil {
+		return nil, err
+	}
+
 	return &elem, nil
 }
 
+// DocumentRef identifies a document of any type.
+type DocumentRef struct {
+	DocType DocTypeID  `json:"DocType"` // Type of the document
+	ID      DocumentID `json:"ID"`      // Unique identifier of the document within its type
+}
+
+// GetMany answers the metadata of the given documents, of the given
+// type, in the order of the given IDs.  As with `List`, the data of
+// the documents is not fetched.  IDs of documents that do not exist
+// are skipped.  The options `WithBlobs`, `WithTags` and `WithChildren`
+// are honoured, as in `List`.
+//
+// Documents are read in batches of up to 500 per query.
+func (_Documents) GetMany(otx *sql.Tx, dtype DocTypeID, ids []DocumentID, opts ...ReadOption) ([]*Document, error) {
+	if dtype <= 0 {
+		return nil, newError(CodeValidation, "document type should be a positive integer")
+	}
+	if len(ids) == 0 {
+		return []*Document{}, nil
+	}
+
+	dtname, err := DocTypes.name(otx, dtype)
+	if err != nil {
+		return nil, err
+	}
+
+	tbl := DocTypes.docStorName(dtype)
+	byID := make(map[DocumentID]*Document, len(ids))
+	for rest := ids; len(rest) > 0; {
+		n := len(rest)
+		if n > maxBatchRows {
+			n = maxBatchRows
+		}
+		q := `
+		SELECT docs.id, docs.path, docs.ac_id, docs.group_id, gm.name, docs.docstate_id, dsm.name, docs.ctime, docs.title
+		FROM ` + tbl + ` docs
+		JOIN wf_groups_master gm ON gm.id = docs.group_id
+		JOIN wf_docstates_master dsm ON dsm.id = docs.docstate_id
+		WHERE docs.id IN ` + inPlaceholders(n)
+		args := make([]interface{}, 0, n)
+		for _, id := range rest[:n] {
+			args = append(args, id)
+		}
+
+		var rows *sql.Rows
+		if otx == nil {
+			rows, err = readDB().Query(q, args...)
+		} else {
+			rows, err = otx.Query(q, args...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var elem Document
+			var title sql.NullString
+			err = rows.Scan(&elem.ID, &elem.Path, &elem.AccCtx.ID, &elem.Group.ID, &elem.Group.Name, &elem.State.ID, &elem.State.Name, &elem.Ctime, &title)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			elem.DocType.ID = dtype
+			elem.DocType.Name = dtname
+			elem.Title = title.String
+			byID[elem.ID] = &elem
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		rest = rest[n:]
+	}
+
+	ary := make([]*Document, 0, len(byID))
+	for _, id := range ids {
+		if elem, ok := byID[id]; ok {
+			ary = append(ary, elem)
+		}
+	}
+
+	o := applyReadOptions(opts)
+	if err = Documents.loadCollections(otx, dtype, o, ary...); err != nil {
+		return nil, err
+	}
+	if err = Redactions.apply(otx, dtype, o.user, ary...); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// GetManyRefs answers the metadata of the given documents, possibly of
+// several types, in the order of the given references.  Documents of
+// each type are read using `GetMany`.  References to documents that
+// do not exist are skipped.
+func (_Documents) GetManyRefs(otx *sql.Tx, refs []DocumentRef, opts ...ReadOption) ([]*Document, error) {
+	ids := map[DocTypeID][]DocumentID{}
+	dtypes := []DocTypeID{}
+	for _, ref := range refs {
+		if _, ok := ids[ref.DocType]; !ok {
+			dtypes = append(dtypes, ref.DocType)
+		}
+		ids[ref.DocType] = append(ids[ref.DocType], ref.ID)
+	}
+
+	byRef := make(map[DocumentRef]*Document, len(refs))
+	for _, dtype := range dtypes {
+		docs, err := Documents.GetMany(otx, dtype, ids[dtype], opts...)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			byRef[DocumentRef{DocType: dtype, ID: doc.ID}] = doc
+		}
+	}
+
+	ary := make([]*Document, 0, len(byRef))
+	for _, ref := range refs {
+		if doc, ok := byRef[ref]; ok {
+			ary = append(ary, doc)
+		}
+	}
+	return ary, nil
+}
+
+// loadCollections reads the collections requested in the given
+// options for the given documents, all of the given type, using one
+// query per collection and batch of documents.
+func (_Documents) loadCollections(otx *sql.Tx, dtype DocTypeID, o *readOptions, docs ...*Document) error {
+	if len(docs) == 0 || !(o.tags || o.blobs || o.children) {
+		return nil
+	}
+
+	query := readDB().Query
+	if otx != nil {
+		query = otx.Query
+	}
+
+	byID := make(map[DocumentID]*Document, len(docs))
+	for _, doc := range docs {
+		byID[doc.ID] = doc
+	}
+
+	for rest := docs; len(rest) > 0; {
+		n := len(rest)
+		if n > maxBatchRows {
+			n = maxBatchRows
+		}
+		args := make([]interface{}, 0, n+1)
+		args = append(args, dtype)
+		for _, doc := range rest[:n] {
+			args = append(args, doc.ID)
+		}
+		in := inPlaceholders(n)
+
+		if o.tags {
+			q := `
+			SELECT doc_id, tag
+			FROM wf_document_tags
+			WHERE doctype_id = ?
+			AND doc_id IN ` + in + `
+			ORDER BY id
+			`
+			err := scanEach(query, q, args, func(scan func(...interface{}) error) error {
+				var did int64
+				var tag string
+				if err := scan(&did, &tag); err != nil {
+					return err
+				}
+				doc := byID[DocumentID(did)]
+				doc.Tags = append(doc.Tags, tag)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if o.blobs {
+			q := `
+			SELECT doc_id, name, sha1sum
+			FROM wf_document_blobs
+			WHERE doctype_id = ?
+			AND doc_id IN ` + in + `
+			ORDER BY id
+			`
+			err := scanEach(query, q, args, func(scan func(...interface{}) error) error {
+				var did int64
+				var b Blob
+				if err := scan(&did, &b.Name, &b.SHA1Sum); err != nil {
+					return err
+				}
+				doc := byID[DocumentID(did)]
+				doc.Blobs = append(doc.Blobs, &b)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if o.children {
+			q := `
+			SELECT parent_id, child_doctype_id, child_id
+			FROM wf_document_children
+			WHERE parent_doctype_id = ?
+			AND parent_id IN ` + in + `
+			ORDER BY id
+			`
+			err := scanEach(query, q, args, func(scan func(...interface{}) error) error {
+				var did int64
+				var ref DocumentRef
+				if err := scan(&did, &ref.DocType, &ref.ID); err != nil {
+					return err
+				}
+				doc := byID[DocumentID(did)]
+				doc.Children = append(doc.Children, ref)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		rest = rest[n:]
+	}
+
+	return nil
+}
+
+// scanEach runs the given query, and calls the given function for
+// each row of its result.
+func scanEach(query func(string, ...interface{}) (*sql.Rows, error), q string, args []interface{},
+	fn func(scan func(...interface{}) error) error) error {
+	rows, err := query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 // GetParent answers the parent document of the specified document.
 func (_Documents) GetParent(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Document, error) {
 	q := `
@@ -457,21 +1009,40 @@ func (_Documents) setState(otx *sql.Tx, dtype DocTypeID, id DocumentID, state Do
 
 	var q string
 	var err error
+	var from DocStateID
+	if changeLogEnabled(dtype) {
+		q = `SELECT docstate_id FROM ` + tbl + ` WHERE id = ?`
+		row, err := stmts.queryRow(otx, q, id)
+		if err != nil {
+			return err
+		}
+		if err = row.Scan(&from); err != nil {
+			return err
+		}
+	}
+
 	if ac > 0 {
 		q = `UPDATE ` + tbl + ` SET docstate_id = ?, ac_id = ? WHERE id = ?`
-		_, err = otx.Exec(q, state, ac, id)
+		_, err = stmts.exec(otx, q, state, ac, id)
 	} else {
 		q = `UPDATE ` + tbl + ` SET docstate_id = ? WHERE id = ?`
-		_, err = otx.Exec(q, state, id)
+		_, err = stmts.exec(otx, q, state, id)
+	}
+	if err != nil {
+		return err
 	}
-	return err
+
+	delta := map[string]interface{}{"from": from, "to": state, "ac": ac}
+	return Documents.recordChange(otx, dtype, id, ChangeState, delta)
 }
 
 // SetTitle sets the title of the document.
 func (_Documents) SetTitle(otx *sql.Tx, dtype DocTypeID, id DocumentID, title string) error {
 	title = strings.TrimSpace(title)
-	if title == "" {
-		return errors.New("document title should not be empty")
+	var v validator
+	v.name("title", title, maxTitleLen)
+	if err := v.result(); err != nil {
+		return err
 	}
 
 	// A child document does not have its own title.
@@ -485,68 +1056,60 @@ func (_Documents) SetTitle(otx *sql.Tx, dtype DocTypeID, id DocumentID, title st
 		return err
 	}
 	if path != "" {
-		return errors.New("a child document cannot have its own title")
+		return newError(CodeValidation, "a child document cannot have its own title")
 	}
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err = db.Begin()
+	err = withTx(otx, func(tx *sql.Tx) error {
+		q = `UPDATE ` + tbl + ` SET title = ?, ctime = NOW() WHERE id = ?`
+		_, err := tx.Exec(q, title, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q = `UPDATE ` + tbl + ` SET title = ?, ctime = NOW() WHERE id = ?`
-	_, err = tx.Exec(q, title, id)
+		return Documents.recordChange(tx, dtype, id, ChangeTitle, map[string]string{"title": title})
+	})
 	if err != nil {
 		return err
 	}
-
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
 	return nil
 }
 
-// SetData sets the data of the document.
+// SetData sets the data of the document.  Data larger than the size
+// allowed for the type answers a `DataTooLargeError`.  Types storing
+// JSON data require it to be valid, and to satisfy their schema, if
+// any.  See `DocTypes.SetDataConfig`.
 func (_Documents) SetData(otx *sql.Tx, dtype DocTypeID, id DocumentID, data string) error {
 	if data == "" {
-		return errors.New("document data should not be empty")
+		return newError(CodeValidation, "document data should not be empty")
 	}
 
-	tbl := DocTypes.docStorName(dtype)
-
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		val, err := storeData(tx, dtype, data)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `UPDATE ` + tbl + ` SET data = ?, ctime = NOW() WHERE id = ?`
-	_, err = tx.Exec(q, data, id)
+		return Documents.setData(tx, dtype, id, val)
+	})
 	if err != nil {
 		return err
 	}
+	return nil
+}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
+// setData writes the given value -- the data, or a reference to its
+// file -- into the document table, and records the change.
+func (_Documents) setData(tx *sql.Tx, dtype DocTypeID, id DocumentID, val string) error {
+	q := `UPDATE ` + DocTypes.docStorName(dtype) + ` SET data = ?, ctime = NOW() WHERE id = ?`
+	_, err := tx.Exec(q, val, id)
+	if err != nil {
+		return err
 	}
-	return nil
+	if err = derive(tx, dtype, id, val); err != nil {
+		return err
+	}
+
+	return Documents.recordChange(tx, dtype, id, ChangeData, map[string]string{"data": val})
 }
 
 // Blobs answers a list of this document's enclosures (as names, not
@@ -559,7 +1122,7 @@ func (_Documents) Blobs(dtype DocTypeID, id DocumentID) ([]*Blob, error) {
 	WHERE doctype_id = ?
 	AND doc_id = ?
 	`
-	rows, err := db.Query(q, dtype, id)
+	rows, err := readDB().Query(q, dtype, id)
 	if err != nil {
 		return nil, err
 	}
@@ -586,7 +1149,7 @@ func (_Documents) Blobs(dtype DocTypeID, id DocumentID) ([]*Blob, error) {
 // The retrieved blob is copied into the specified path.
 func (_Documents) GetBlob(dtype DocTypeID, id DocumentID, blob *Blob) error {
 	if blob == nil {
-		return errors.New("blob should be non-nil")
+		return newError(CodeValidation, "blob should be non-nil")
 	}
 
 	q := `
@@ -596,11 +1159,11 @@ func (_Documents) GetBlob(dtype DocTypeID, id DocumentID, blob *Blob) error {
 	AND doc_id = ?
 	AND sha1sum = ?
 	`
-	row := db.QueryRow(q, dtype, id, blob.SHA1Sum)
+	row := readDB().QueryRow(q, dtype, id, blob.SHA1Sum)
 	var b Blob
 	err := row.Scan(&b.Name, &b.Path)
 	if err != nil {
-		return err
+		return notFound(err, ErrBlobNotFound)
 	}
 	b.SHA1Sum = blob.SHA1Sum
 
@@ -627,7 +1190,7 @@ func (_Documents) GetBlob(dtype DocTypeID, id DocumentID, blob *Blob) error {
 // AddBlob adds the path to an enclosure to this document.
 func (_Documents) AddBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, blob *Blob) error {
 	if blob == nil {
-		return errors.New("blob should be non-nil")
+		return newError(CodeValidation, "blob should be non-nil")
 	}
 
 	// Verify the given checksum.
@@ -643,7 +1206,7 @@ func (_Documents) AddBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, blob *Blo
 	}
 	csum := fmt.Sprintf("%x", h.Sum(nil))
 	if blob.SHA1Sum != csum {
-		return fmt.Errorf("checksum mismatch -- given SHA1 sum : %s, computed SHA1 sum : %s", blob.SHA1Sum, csum)
+		return errorf(CodeValidation, "checksum mismatch -- given SHA1 sum : %s, computed SHA1 sum : %s", blob.SHA1Sum, csum)
 	}
 
 	// Store the blob in the appropriate path.
@@ -666,35 +1229,25 @@ func (_Documents) AddBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, blob *Blo
 		}
 	}()
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err = db.Begin()
+	err = withTx(otx, func(tx *sql.Tx) error {
+		// Now write the database entry.
+
+		q := `
+		INSERT INTO wf_document_blobs(doctype_id, doc_id, name, path, sha1sum)
+		VALUES(?, ?, ?, ?, ?)
+		`
+		_, err := tx.Exec(q, dtype, id, blob.Name, bpath, csum)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	// Now write the database entry.
-
-	q := `
-	INSERT INTO wf_document_blobs(doctype_id, doc_id, name, path, sha1sum)
-	VALUES(?, ?, ?, ?, ?)
-	`
-	_, err = tx.Exec(q, dtype, id, blob.Name, bpath, csum)
+		delta := map[string]interface{}{"added": map[string]string{"name": blob.Name, "sha1": csum}}
+		return Documents.recordChange(tx, dtype, id, ChangeBlobs, delta)
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	success = true
 	return nil
 }
@@ -702,71 +1255,60 @@ func (_Documents) AddBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, blob *Blo
 // DeleteBlob deletes the given blob from the specified document.
 func (_Documents) DeleteBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, sha1 string) error {
 	if sha1 == "" {
-		return errors.New("SHA1 sum should be non-empty")
+		return newError(CodeValidation, "SHA1 sum should be non-empty")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		SELECT COUNT(*)
+		FROM wf_document_blobs
+		WHERE sha1sum = ?
+		`
+		var count int64
+		row := tx.QueryRow(q, sha1)
+		err := row.Scan(&count)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
+		if count == 1 {
+			q = `
+			SELECT path
+			FROM wf_document_blobs
+			WHERE doctype_id = ?
+			AND doc_id = ?
+			AND sha1sum = ?
+			`
+			var path string
+			row = tx.QueryRow(q, dtype, id, sha1)
+			err = row.Scan(&path)
+			if err != nil {
+				return err
+			}
+
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+		}
 
-	q := `
-	SELECT COUNT(*)
-	FROM wf_document_blobs
-	WHERE sha1sum = ?
-	`
-	var count int64
-	row := tx.QueryRow(q, sha1)
-	err = row.Scan(&count)
-	if err != nil {
-		return err
-	}
-	if count == 1 {
 		q = `
-		SELECT path
-		FROM wf_document_blobs
+		DELETE FROM wf_document_blobs
 		WHERE doctype_id = ?
 		AND doc_id = ?
 		AND sha1sum = ?
 		`
-		var path string
-		row = tx.QueryRow(q, dtype, id, sha1)
-		err = row.Scan(&path)
-		if err != nil {
-			return err
-		}
-
-		err = os.Remove(path)
+		_, err = tx.Exec(q, dtype, id, sha1)
 		if err != nil {
 			return err
 		}
-	}
 
-	q = `
-	DELETE FROM wf_document_blobs
-	WHERE doctype_id = ?
-	AND doc_id = ?
-	AND sha1sum = ?
-	`
-	_, err = tx.Exec(q, dtype, id, sha1)
+		delta := map[string]interface{}{"removed": map[string]string{"sha1": sha1}}
+		return Documents.recordChange(tx, dtype, id, ChangeBlobs, delta)
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
@@ -779,7 +1321,7 @@ func (_Documents) Tags(dtype DocTypeID, id DocumentID) ([]string, error) {
 	WHERE doctype_id = ?
 	AND doc_id = ?
 	`
-	rows, err := db.Query(q, dtype, id)
+	rows, err := readDB().Query(q, dtype, id)
 	if err != nil {
 		return nil, err
 	}
@@ -807,6 +1349,15 @@ func (_Documents) Tags(dtype DocTypeID, id DocumentID) ([]string, error) {
 // before getting associated with documents.  Also, embedded spaces,
 // if any, are retained.
 func (_Documents) AddTags(otx *sql.Tx, dtype DocTypeID, id DocumentID, tags ...string) error {
+	var v validator
+	for i, tag := range tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		v.name(field, strings.TrimSpace(tag), maxTagLen)
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
 	// A child document does not have its own tags.
 	q := `
 	SELECT parent_id
@@ -826,37 +1377,27 @@ func (_Documents) AddTags(otx *sql.Tx, dtype DocTypeID, id DocumentID, tags ...s
 		return err
 	}
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return err
-		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	// Now write the database entry.
+	err = withTx(otx, func(tx *sql.Tx) error {
+		// Now write the database entry.
 
-	q = `
-	INSERT INTO wf_document_tags(doctype_id, doc_id, tag)
-	VALUES(?, ?, ?)
-	`
-	for _, tag := range tags {
-		tag = strings.TrimSpace(tag)
-		tag = strings.ToLower(tag)
-		_, err = tx.Exec(q, dtype, id, tag)
-		if err != nil {
-			return err
+		rows := make([][]interface{}, 0, len(tags))
+		added := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			tag = strings.TrimSpace(tag)
+			tag = strings.ToLower(tag)
+			rows = append(rows, []interface{}{dtype, id, tag})
+			added = append(added, tag)
 		}
-	}
-
-	if otx == nil {
-		err = tx.Commit()
+		q = `INSERT INTO wf_document_tags(doctype_id, doc_id, tag) VALUES`
+		err := insertRows(tx, q, `(?, ?, ?)`, rows)
 		if err != nil {
 			return err
 		}
+
+		return Documents.recordChange(tx, dtype, id, ChangeTags, map[string][]string{"added": added})
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -866,42 +1407,104 @@ func (_Documents) AddTags(otx *sql.Tx, dtype DocTypeID, id DocumentID, tags ...s
 func (_Documents) RemoveTag(otx *sql.Tx, dtype DocTypeID, id DocumentID, tag string) error {
 	tag = strings.TrimSpace(tag)
 	if tag == "" {
-		return errors.New("tag should not be empty")
+		return newError(CodeValidation, "tag should not be empty")
 	}
 	tag = strings.ToLower(tag)
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		// Now write the database entry.
+		q := `
+		DELETE FROM wf_document_tags
+		WHERE doctype_id = ?
+		AND doc_id = ?
+		AND tag = ?
+		`
+		_, err := tx.Exec(q, dtype, id, tag)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
+
+		return Documents.recordChange(tx, dtype, id, ChangeTags, map[string][]string{"removed": {tag}})
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Children answers the metadata of the children of the given
+// document -- creator, creation time, title, etc. -- ordered by their
+// types and IDs.  As with `GetMany`, their data is not fetched, and
+// the children of each type are read together.
+//
+// Result set skips the first `offset` children, and has not more than
+// `limit` elements.  A value of `0` for `limit` fetches until the end.
+// Use `ChildCounts` for the totals.
+func (_Documents) Children(dtype DocTypeID, id DocumentID, offset, limit int64, opts ...ReadOption) ([]*Document, error) {
+	if dtype <= 0 || id <= 0 {
+		return nil, newError(CodeValidation, "document type and document ID should be positive integers")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
 	}
 
-	// Now write the database entry.
 	q := `
-	DELETE FROM wf_document_tags
-	WHERE doctype_id = ?
-	AND doc_id = ?
-	AND tag = ?
+	SELECT child_doctype_id, child_id
+	FROM wf_document_children
+	WHERE parent_doctype_id = ?
+	AND parent_id = ?
+	ORDER BY child_doctype_id, child_id
+	LIMIT ? OFFSET ?
 	`
-	_, err = tx.Exec(q, dtype, id, tag)
+	refs := []DocumentRef{}
+	err := scanEach(readDB().Query, q, []interface{}{dtype, id, limit, offset}, func(scan func(...interface{}) error) error {
+		var ref DocumentRef
+		if err := scan(&ref.DocType, &ref.ID); err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
+	return Documents.GetManyRefs(nil, refs, opts...)
+}
+
+// ChildCounts answers the number of children of the given document,
+// per document type.
+func (_Documents) ChildCounts(dtype DocTypeID, id DocumentID) (map[DocTypeID]int64, error) {
+	if dtype <= 0 || id <= 0 {
+		return nil, newError(CodeValidation, "document type and document ID should be positive integers")
+	}
+
+	q := `
+	SELECT child_doctype_id, COUNT(*)
+	FROM wf_document_children
+	WHERE parent_doctype_id = ?
+	AND parent_id = ?
+	GROUP BY child_doctype_id
+	`
+	counts := map[DocTypeID]int64{}
+	err := scanEach(readDB().Query, q, []interface{}{dtype, id}, func(scan func(...interface{}) error) error {
+		var ctype DocTypeID
+		var n int64
+		if err := scan(&ctype, &n); err != nil {
 			return err
 		}
+		counts[ctype] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return counts, nil
 }
 
 // ChildrenIDs answers a list of this document's children IDs.
@@ -920,7 +1523,7 @@ func (_Documents) ChildrenIDs(dtype DocTypeID, id DocumentID) ([]struct {
 	WHERE parent_doctype_id = ?
 	AND parent_id = ?
 	`
-	rows, err := db.Query(q, dtype, id)
+	rows, err := readDB().Query(q, dtype, id)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,171 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DuplicateKeyFunc answers the key that identifies the content of a
+// new document, for the purpose of detecting duplicates.  Documents of
+// the same type having equal keys are duplicates.  An empty key
+// exempts the document from the check.
+type DuplicateKeyFunc func(input *DocumentsNewInput) (string, error)
+
+// DuplicateByTitle is a duplicate policy under which documents having
+// the same title, ignoring case and surrounding space, are duplicates.
+func DuplicateByTitle(input *DocumentsNewInput) (string, error) {
+	return strings.ToLower(strings.TrimSpace(input.Title)), nil
+}
+
+// DuplicateByDataFields answers a duplicate policy under which
+// documents having equal values of all the given fields of their data
+// are duplicates.  The data should be a JSON object; nested fields are
+// named by their dotted paths, e.g. `claim.amount`.
+func DuplicateByDataFields(fields ...string) DuplicateKeyFunc {
+	fs := append([]string{}, fields...)
+	return func(input *DocumentsNewInput) (string, error) {
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(input.Data), &obj); err != nil {
+			return "", errorf(CodeValidation, "document data is not a JSON object : %v", err)
+		}
+
+		vals := make([]interface{}, 0, len(fs))
+		for _, f := range fs {
+			var val interface{} = obj
+			for _, p := range strings.Split(f, ".") {
+				m, ok := val.(map[string]interface{})
+				if !ok {
+					val = nil
+					break
+				}
+				val = m[p]
+			}
+			vals = append(vals, val)
+		}
+		bs, err := json.Marshal(vals)
+		if err != nil {
+			return "", err
+		}
+		return string(bs), nil
+	}
+}
+
+// DuplicateDocumentError is answered by `Documents.New` when the new
+// document is a duplicate of an existing one.  It matches
+// `ErrDuplicateDocument`, and has the code `CodeConflict`.
+type DuplicateDocumentError struct {
+	DocType DocTypeID  // Type of the documents
+	ID      DocumentID // Existing document of which the new one is a duplicate
+}
+
+// Error implements the `error` interface.
+func (e *DuplicateDocumentError) Error() string {
+	return fmt.Sprintf("%s : existing document %d", ErrDuplicateDocument, e.ID)
+}
+
+// Is answers `true` if the target is `ErrDuplicateDocument`, or its
+// code.
+func (e *DuplicateDocumentError) Is(target error) bool {
+	return target == ErrDuplicateDocument || target == CodeConflict
+}
+
+var dupMu sync.RWMutex
+var dupPolicies = map[DocTypeID]DuplicateKeyFunc{}
+
+// SetDuplicatePolicy registers the duplicate policy of documents of the
+// given type.  `Documents.New` then refuses to create a document whose
+// key equals that of an existing document of the type.  A `nil`
+// policy removes the check.
+//
+// Keys are recorded for documents created while a policy is in force.
+// Documents created earlier are not considered.
+func (_Documents) SetDuplicatePolicy(dtype DocTypeID, fn DuplicateKeyFunc) {
+	dupMu.Lock()
+	defer dupMu.Unlock()
+
+	if fn == nil {
+		delete(dupPolicies, dtype)
+		return
+	}
+	dupPolicies[dtype] = fn
+}
+
+// duplicateKey answers the hashed duplicate key of the given input, if
+// a policy applies to its document type; an empty string otherwise.
+func (_Documents) duplicateKey(input *DocumentsNewInput) (string, error) {
+	dupMu.RLock()
+	fn := dupPolicies[input.DocTypeID]
+	dupMu.RUnlock()
+	if fn == nil {
+		return "", nil
+	}
+
+	key, err := fn(input)
+	if err != nil || key == "" {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkDuplicate answers a `DuplicateDocumentError` if a document of
+// the given type having the given key exists.  A locking read sees the
+// rows committed by concurrent transactions.
+func (_Documents) checkDuplicate(tx *sql.Tx, dtype DocTypeID, key string, locking bool) error {
+	q := `
+	SELECT doc_id
+	FROM wf_document_keys
+	WHERE doctype_id = ?
+	AND dkey = ?
+	`
+	if locking {
+		q += `LOCK IN SHARE MODE`
+	}
+	var did int64
+	err := tx.QueryRow(q, dtype, key).Scan(&did)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+
+	case err != nil:
+		return err
+
+	default:
+		return &DuplicateDocumentError{DocType: dtype, ID: DocumentID(did)}
+	}
+}
+
+// recordDuplicateKey records the key of the given new document.  Should
+// a concurrent transaction have recorded the same key meanwhile, the
+// corresponding `DuplicateDocumentError` is answered.
+func (_Documents) recordDuplicateKey(tx *sql.Tx, dtype DocTypeID, id DocumentID, key string) error {
+	q := `INSERT INTO wf_document_keys(doctype_id, doc_id, dkey) VALUES (?, ?, ?)`
+	_, err := tx.Exec(q, dtype, id, key)
+	if me, ok := err.(*mysql.MySQLError); ok && me.Number == mysqlErrDupEntry {
+		if derr := Documents.checkDuplicate(tx, dtype, key, true); derr != nil {
+			return derr
+		}
+	}
+	return err
+}
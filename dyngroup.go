@@ -0,0 +1,139 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// NewDynamic creates a group whose members are the users holding the
+// given role in the given access context, or in any access context if
+// it is `0`.  Its membership is kept up to date as the roles of groups
+// and the members of groups change.  Dynamic groups cannot be sources
+// of other dynamic groups.
+func (_Groups) NewDynamic(otx *sql.Tx, name string, rid RoleID, acID AccessContextID) (GroupID, error) {
+	if err := checkAdmin(otx, AdminGroups); err != nil {
+		return 0, err
+	}
+
+	name = strings.TrimSpace(name)
+	var v validator
+	v.name("name", name, maxNameLen)
+	v.positive("role", int64(rid))
+	if acID < 0 {
+		v.fail("access context", "should be a non-negative integer")
+	}
+	v.unique(otx, "name", "wf_groups_master", name, 0)
+	if err := v.result(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `INSERT INTO wf_groups_master(name, group_type) VALUES(?, ` + GroupDynamic.sql() + `)`
+		res, err := tx.Exec(q, name)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		q = `INSERT INTO wf_dynamic_groups(group_id, role_id, ac_id) VALUES(?, ?, ?)`
+		_, err = tx.Exec(q, id, rid, acID)
+		if err != nil {
+			return err
+		}
+
+		return refreshDynamicGroup(tx, GroupID(id))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return GroupID(id), nil
+}
+
+// DynamicRole answers the role and the access context, if any, whose
+// holders are the members of the given dynamic group.
+func (_Groups) DynamicRole(gid GroupID) (RoleID, AccessContextID, error) {
+	if gid <= 0 {
+		return 0, 0, newError(CodeValidation, "group ID must be a positive integer")
+	}
+
+	var rid RoleID
+	var acID AccessContextID
+	q := `SELECT role_id, ac_id FROM wf_dynamic_groups WHERE group_id = ?`
+	err := readDB().QueryRow(q, gid).Scan(&rid, &acID)
+	if err != nil {
+		return 0, 0, notFound(err, ErrGroupNotFound)
+	}
+	return rid, acID, nil
+}
+
+// refreshDynamicGroups recomputes the members of the dynamic groups of
+// the given role, or of all of them if it is `0`.
+func refreshDynamicGroups(tx *sql.Tx, rid RoleID) error {
+	q := `
+	SELECT group_id
+	FROM wf_dynamic_groups
+	WHERE ? = 0 OR role_id = ?
+	ORDER BY group_id
+	`
+	gids := []GroupID{}
+	err := scanEach(tx.Query, q, []interface{}{rid, rid}, func(scan func(...interface{}) error) error {
+		var gid GroupID
+		if err := scan(&gid); err != nil {
+			return err
+		}
+		gids = append(gids, gid)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, gid := range gids {
+		if err = refreshDynamicGroup(tx, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshDynamicGroup recomputes the members of the given dynamic
+// group.
+func refreshDynamicGroup(tx *sql.Tx, gid GroupID) error {
+	_, err := tx.Exec("DELETE FROM wf_group_users WHERE group_id = ?", gid)
+	if err != nil {
+		return err
+	}
+
+	q := `
+	INSERT INTO wf_group_users(group_id, user_id)
+	SELECT DISTINCT dg.group_id, gu.user_id
+	FROM wf_dynamic_groups dg
+	JOIN wf_ac_group_roles agr ON agr.role_id = dg.role_id
+	JOIN wf_groups_master gm ON gm.id = agr.group_id
+	JOIN wf_group_users gu ON gu.group_id = agr.group_id
+	WHERE dg.group_id = ?
+	AND (dg.ac_id = 0 OR agr.ac_id = dg.ac_id)
+	AND gm.group_type <> ` + GroupDynamic.sql() + `
+	`
+	_, err = tx.Exec(q, gid)
+	return err
+}
@@ -0,0 +1,527 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"time"
+)
+
+// Mailer sends a fully-formed e-mail message (RFC 5322) to the given
+// recipients.
+type Mailer interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// SMTPMailer is a `Mailer` that relays e-mail through an SMTP server.
+type SMTPMailer struct {
+	Addr string    // `host:port` of the SMTP server
+	Auth smtp.Auth // Authentication mechanism, if any
+}
+
+// Send implements `Mailer`.
+func (m *SMTPMailer) Send(from string, to []string, msg []byte) error {
+	return smtp.SendMail(m.Addr, m.Auth, from, to, msg)
+}
+
+// EmailStatus enumerates the states of an e-mail delivery.
+type EmailStatus uint8
+
+const (
+	// EmailStatusPending : not yet sent; may be awaiting a retry
+	EmailStatusPending EmailStatus = iota + 1
+	// EmailStatusSent : accepted by the mail server
+	EmailStatusSent
+	// EmailStatusFailed : abandoned after exhausting all attempts
+	EmailStatusFailed
+)
+
+// EmailDeliveryID is the type of unique identifiers of e-mail
+// deliveries.
+type EmailDeliveryID int64
+
+// EmailDelivery tracks the e-mailing of a notification to one member
+// of the recipient group.
+type EmailDelivery struct {
+	ID          EmailDeliveryID `json:"ID"`          // Unique identifier of this delivery
+	Message     MessageID       `json:"Message"`     // Message being e-mailed
+	User        UserID          `json:"User"`        // Recipient user
+	Email       string          `json:"Email"`       // Address to which the message is sent
+	Status      EmailStatus     `json:"Status"`      // Current status of this delivery
+	Digest      bool            `json:"Digest"`      // Is this delivery combined with others to the same address?
+	Attempts    int             `json:"Attempts"`    // Number of attempts made so far
+	NextAttempt time.Time       `json:"NextAttempt"` // Earliest time of the next attempt, if pending
+	LastError   string          `json:"LastError"`   // Error of the most recent failed attempt
+	Ctime       time.Time       `json:"Ctime"`       // Time when this delivery was enqueued
+	Mtime       time.Time       `json:"Mtime"`       // Time of the most recent status change
+}
+
+// EmailDispatcher bridges mailboxes to e-mail.  It e-mails posted
+// notifications to the active users of the recipient groups.
+//
+// Notifications are queued for e-mailing through the outbox (see
+// `RunOutbox`), once e-mailing is enabled using
+// `EmailDeliveries.SetEnabled`.
+//
+// Failed attempts are retried with exponential backoff, until
+// `MaxAttempts` is reached.  The status of each delivery is recorded,
+// and can be inspected using `EmailDeliveries`.
+type EmailDispatcher struct {
+	Mailer      Mailer        // Required
+	From        string        // Sender address; required
+	Template    string        // Name of the message template to render e-mails with, if any
+	Locale      string        // Locale of the template variant to use
+	MaxAttempts int           // Defaults to 5
+	Backoff     time.Duration // Delay before the first retry; doubles thereafter.  Defaults to 1 minute
+	BatchSize   int           // Deliveries attempted per round; defaults to 100
+}
+
+// emailEnabled determines if posted messages are queued for e-mailing.
+var emailEnabled bool
+
+func init() {
+	Outbox.SetHandler(OutboxKindEmail, fanOutEmail)
+}
+
+// emailEntry is the outbox payload of a message to be e-mailed.
+type emailEntry struct {
+	Message MessageID `json:"Message"`
+}
+
+// fanOutEmail is the outbox handler of posted messages.  It records a
+// pending delivery to each active user of each recipient group, who
+// chose e-mail and is not muted (see `NotificationPrefs`).  Deliveries
+// to users preferring digests are held until the end of the current
+// digest period.
+func fanOutEmail(ctx context.Context, tx *sql.Tx, e *OutboxEntry) error {
+	var ee emailEntry
+	err := json.Unmarshal(e.Payload, &ee)
+	if err != nil {
+		return err
+	}
+
+	q := `
+	INSERT IGNORE INTO wf_email_deliveries(mailbox_id, message_id, user_id, email, status, digest, attempts, next_attempt, ctime, mtime)
+	SELECT mbs.id, mbs.message_id, um.id, um.email, 'P', ` + prefDigest + `, 0,
+		CASE WHEN ` + prefDigest + ` = 1 THEN ? ELSE NOW() END, NOW(), NOW()
+	FROM wf_mailboxes mbs
+	JOIN wf_messages msgs ON msgs.id = mbs.message_id
+	JOIN wf_group_users gus ON gus.group_id = mbs.group_id
+	JOIN wf_users_master um ON um.id = gus.user_id
+	` + prefJoins("msgs.doctype_id") + `
+	WHERE mbs.message_id = ?
+	AND um.active = 1
+	AND (` + prefChannels + ` & ?) <> 0
+	AND ` + prefMuted + ` = 0
+	`
+	_, err = tx.Exec(q, NotificationPrefs.nextDigest(), ee.Message, ChannelEmail)
+	return err
+}
+
+// defaults answers the effective retry settings of this dispatcher.
+func (d *EmailDispatcher) defaults() (int, time.Duration, int) {
+	maxAttempts, backoff, batch := d.MaxAttempts, d.Backoff, d.BatchSize
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	if backoff <= 0 {
+		backoff = time.Minute
+	}
+	if batch <= 0 {
+		batch = 100
+	}
+	return maxAttempts, backoff, batch
+}
+
+// Dispatch attempts those pending deliveries that are due.  It
+// answers the number of e-mails successfully sent.
+//
+// Each delivery is claimed before it is attempted, so that multiple
+// dispatchers can run concurrently without sending duplicates.
+//
+// Due digest deliveries to the same address are combined into a single
+// e-mail.
+func (d *EmailDispatcher) Dispatch(ctx context.Context) (int, error) {
+	if d.Mailer == nil || d.From == "" {
+		return 0, newError(CodeValidation, "mailer and sender address are required")
+	}
+	maxAttempts, backoff, batch := d.defaults()
+
+	q := `
+	SELECT id, message_id, email, digest, attempts
+	FROM wf_email_deliveries
+	WHERE status = 'P'
+	AND next_attempt <= NOW()
+	ORDER BY next_attempt
+	LIMIT ?
+	`
+	rows, err := db.Query(q, batch)
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		id       EmailDeliveryID
+		msg      MessageID
+		email    string
+		digest   bool
+		attempts int
+	}
+	groups := make([][]due, 0, batch)
+	digests := map[string]int{}
+	for rows.Next() {
+		var elem due
+		err = rows.Scan(&elem.id, &elem.msg, &elem.email, &elem.digest, &elem.attempts)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if elem.digest {
+			if i, ok := digests[elem.email]; ok {
+				groups[i] = append(groups[i], elem)
+				continue
+			}
+			digests[elem.email] = len(groups)
+		}
+		groups = append(groups, []due{elem})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	bodies := map[MessageID][]byte{}
+	for _, grp := range groups {
+		if err = ctx.Err(); err != nil {
+			return sent, err
+		}
+
+		// Claim these deliveries for the duration of one backoff.
+		q = `
+		UPDATE wf_email_deliveries
+		SET next_attempt = ?
+		WHERE id = ?
+		AND status = 'P'
+		AND attempts = ?
+		AND next_attempt <= NOW()
+		`
+		claimed := make([]due, 0, len(grp))
+		for _, elem := range grp {
+			res, err := db.Exec(q, time.Now().Add(backoff), elem.id, elem.attempts)
+			if err != nil {
+				return sent, err
+			}
+			if n, _ := res.RowsAffected(); n == 1 {
+				claimed = append(claimed, elem)
+			}
+		}
+		if len(claimed) == 0 {
+			continue
+		}
+
+		var body []byte
+		if len(claimed) == 1 {
+			var ok bool
+			body, ok = bodies[claimed[0].msg]
+			if !ok {
+				body, err = d.compose(claimed[0].msg)
+				if err != nil {
+					return sent, err
+				}
+				bodies[claimed[0].msg] = body
+			}
+		} else {
+			mids := make([]MessageID, 0, len(claimed))
+			for _, elem := range claimed {
+				mids = append(mids, elem.msg)
+			}
+			body, err = d.composeDigest(mids)
+			if err != nil {
+				return sent, err
+			}
+		}
+		email := claimed[0].email
+		msg := append([]byte(fmt.Sprintf("From: %s\r\nTo: %s\r\n", d.From, email)), body...)
+
+		serr := d.Mailer.Send(d.From, []string{email}, msg)
+		if serr == nil {
+			for _, elem := range claimed {
+				_, err = db.Exec("UPDATE wf_email_deliveries SET status = 'S', attempts = attempts + 1, last_error = NULL, mtime = NOW() WHERE id = ?", elem.id)
+				if err != nil {
+					return sent, err
+				}
+			}
+			sent++
+			continue
+		}
+
+		errText := serr.Error()
+		if len(errText) > 500 {
+			errText = errText[:500]
+		}
+		q = `
+		UPDATE wf_email_deliveries
+		SET status = ?, attempts = attempts + 1, next_attempt = ?, last_error = ?, mtime = NOW()
+		WHERE id = ?
+		`
+		for _, elem := range claimed {
+			status := "P"
+			level := LogWarn
+			if elem.attempts+1 >= maxAttempts {
+				status = "F"
+				level = LogError
+			}
+			writeLog(level, "e-mail delivery failed", F("delivery", elem.id), F("attempt", elem.attempts+1), F("error", serr))
+			_, err = db.Exec(q, status, time.Now().Add(backoff<<uint(elem.attempts)), errText, elem.id)
+			if err != nil {
+				return sent, err
+			}
+		}
+	}
+
+	return sent, nil
+}
+
+// render answers the subject and body of the e-mail for the given
+// message.
+func (d *EmailDispatcher) render(mid MessageID) (string, string, error) {
+	var dtype DocTypeID
+	var did DocumentID
+	var eid DocEventID
+	var title, body string
+	q := `
+	SELECT doctype_id, doc_id, docevent_id, title, data
+	FROM wf_messages
+	WHERE id = ?
+	`
+	row := db.QueryRow(q, mid)
+	err := row.Scan(&dtype, &did, &eid, &title, &body)
+	if err != nil {
+		return "", "", err
+	}
+
+	if d.Template != "" {
+		data, err := emailTemplateData(dtype, did, eid)
+		if err != nil {
+			return "", "", err
+		}
+		title, body, err = Templates.Render(d.Template, d.Locale, data)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return title, body, nil
+}
+
+// compose renders the headers (other than the addresses) and body of
+// the e-mail for the given message.
+func (d *EmailDispatcher) compose(mid MessageID) ([]byte, error) {
+	title, body, err := d.render(mid)
+	if err != nil {
+		return nil, err
+	}
+	return emailBytes(title, body), nil
+}
+
+// composeDigest renders the headers (other than the addresses) and body
+// of a single e-mail combining the given messages.
+func (d *EmailDispatcher) composeDigest(mids []MessageID) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, mid := range mids {
+		title, body, err := d.render(mid)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("\r\n\r\n----\r\n\r\n")
+		}
+		buf.WriteString(title)
+		buf.WriteString("\r\n\r\n")
+		buf.WriteString(body)
+	}
+	return emailBytes(fmt.Sprintf("%d notifications", len(mids)), buf.String()), nil
+}
+
+// emailBytes answers the headers (other than the addresses) and body of
+// an e-mail having the given subject and text.
+func emailBytes(title, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(body)
+	return buf.Bytes()
+}
+
+// emailTemplateData assembles the template data of the given
+// message's event, after the event has been applied.
+func emailTemplateData(dtype DocTypeID, did DocumentID, eid DocEventID) (*TemplateData, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	doc, err := Documents.Get(tx, dtype, did)
+	if err != nil {
+		return nil, err
+	}
+	event, err := DocEvents.Get(eid)
+	if err != nil {
+		return nil, err
+	}
+	data, err := templateData(tx, doc, event, doc.State.ID)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := DocStates.Get(event.State)
+	if err != nil {
+		return nil, err
+	}
+	data.PrevState = prev.Name
+
+	return data, nil
+}
+
+// Run dispatches e-mails every `interval`, until the given context is
+// cancelled.  Errors are reported to `onErr`, if given, or logged
+// otherwise.  They do not stop the dispatcher.
+func (d *EmailDispatcher) Run(ctx context.Context, interval time.Duration, onErr func(error)) error {
+	if interval <= 0 {
+		return newError(CodeValidation, "interval should be a positive duration")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := d.Dispatch(ctx)
+		if err != nil {
+			if onErr != nil {
+				onErr(err)
+			} else {
+				writeLog(LogError, "e-mail dispatch failed", F("error", err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// Unexported type, only for convenience methods.
+type _EmailDeliveries struct{}
+
+// EmailDeliveries provides a resource-like interface to the e-mail
+// deliveries of notifications.
+var EmailDeliveries _EmailDeliveries
+
+// SetEnabled turns the queueing of posted messages for e-mailing on or
+// off.  Messages posted while it is off are not e-mailed.
+func (_EmailDeliveries) SetEnabled(on bool) {
+	emailEnabled = on
+}
+
+// List answers the e-mail deliveries of the given message.
+func (_EmailDeliveries) List(mid MessageID) ([]*EmailDelivery, error) {
+	q := `
+	SELECT id, message_id, user_id, email, status, digest, attempts, next_attempt, last_error, ctime, mtime
+	FROM wf_email_deliveries
+	WHERE message_id = ?
+	ORDER BY id
+	`
+	rows, err := readDB().Query(q, mid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*EmailDelivery, 0, 4)
+	for rows.Next() {
+		var elem EmailDelivery
+		var status string
+		var lerr sql.NullString
+		err = rows.Scan(&elem.ID, &elem.Message, &elem.User, &elem.Email, &status, &elem.Digest, &elem.Attempts,
+			&elem.NextAttempt, &lerr, &elem.Ctime, &elem.Mtime)
+		if err != nil {
+			return nil, err
+		}
+		switch status {
+		case "P":
+			elem.Status = EmailStatusPending
+
+		case "S":
+			elem.Status = EmailStatusSent
+
+		case "F":
+			elem.Status = EmailStatusFailed
+
+		default:
+			return nil, errorf(CodeInternal, "unknown e-mail delivery status : %s", status)
+		}
+		if lerr.Valid {
+			elem.LastError = lerr.String
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Retry resets the given failed delivery, so that it is attempted
+// afresh by the next dispatch.
+func (_EmailDeliveries) Retry(otx *sql.Tx, id EmailDeliveryID) error {
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_email_deliveries
+		SET status = 'P', attempts = 0, next_attempt = NOW(), mtime = NOW()
+		WHERE id = ?
+		AND status = 'F'
+		`
+		res, err := tx.Exec(q, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return newError(CodeNotFound, "no failed delivery with the given ID")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
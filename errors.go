@@ -14,6 +14,171 @@
 
 package flow
 
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrorCode classifies the errors answered by `flow`, so that callers
+// -- HTTP handlers, for instance -- can react to them without
+// inspecting their messages.
+//
+// Codes can be used as targets of `errors.Is`:
+//
+//     if errors.Is(err, flow.CodeNotFound) {
+//         ...
+//     }
+type ErrorCode uint8
+
+// The following are the defined error codes.
+const (
+	// CodeInternal : failure within `flow` or the database
+	CodeInternal ErrorCode = iota + 1
+	// CodeNotFound : the requested entity does not exist
+	CodeNotFound
+	// CodeConflict : the request conflicts with the current state of the data
+	CodeConflict
+	// CodeValidation : the request has invalid or missing inputs
+	CodeValidation
+	// CodePermissionDenied : the user may not perform the request
+	CodePermissionDenied
+	// CodeRateLimited : the user has made too many requests recently
+	CodeRateLimited
+)
+
+// String answers the name of this code.
+func (c ErrorCode) String() string {
+	switch c {
+	case CodeInternal:
+		return "Internal"
+
+	case CodeNotFound:
+		return "NotFound"
+
+	case CodeConflict:
+		return "Conflict"
+
+	case CodeValidation:
+		return "Validation"
+
+	case CodePermissionDenied:
+		return "PermissionDenied"
+
+	case CodeRateLimited:
+		return "RateLimited"
+
+	default:
+		return fmt.Sprintf("ErrorCode(%d)", c)
+	}
+}
+
+// Error implements the `error` interface, so that codes can be used
+// as targets of `errors.Is`.
+func (c ErrorCode) Error() string {
+	return c.String()
+}
+
+// CodedError is an error having a code, optionally wrapping an
+// underlying cause.
+type CodedError struct {
+	Code ErrorCode // Classification of this error
+	Msg  string    // Description of this error
+	Err  error     // Underlying cause, if any
+}
+
+// Error implements the `error` interface.
+func (e *CodedError) Error() string {
+	if e.Err == nil {
+		return e.Msg
+	}
+	return e.Msg + " : " + e.Err.Error()
+}
+
+// Unwrap answers the underlying cause.
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// Is answers `true` if the target is the code of this error.
+func (e *CodedError) Is(target error) bool {
+	c, ok := target.(ErrorCode)
+	return ok && c == e.Code
+}
+
+// newError answers a new error with the given code and message.
+func newError(code ErrorCode, msg string) error {
+	return &CodedError{Code: code, Msg: msg}
+}
+
+// errorf answers a new error with the given code, and a formatted
+// message.
+func errorf(code ErrorCode, format string, args ...interface{}) error {
+	return &CodedError{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// MySQL error numbers of constraint violations.
+const (
+	mysqlErrDupEntry      = 1062
+	mysqlErrDataTooLong   = 1406
+	mysqlErrRowReferenced = 1451
+	mysqlErrNoParentRow   = 1452
+)
+
+// CodeOf answers the code of the given error; `0` for `nil`.
+//
+// Errors answered by the database are classified too: missing rows as
+// `CodeNotFound`, duplicate keys and references that prevent deletion
+// as `CodeConflict`, and references to missing entities and values
+// that are too long as `CodeValidation`.  All other errors are
+// `CodeInternal`.
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return 0
+	}
+
+	var ce *CodedError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	var fe Error
+	if errors.As(err, &fe) {
+		return fe.Code()
+	}
+	var ve ValidationErrors
+	if errors.As(err, &ve) {
+		return CodeValidation
+	}
+	var de *DuplicateDocumentError
+	if errors.As(err, &de) {
+		return CodeConflict
+	}
+	var qe *QuotaExceededError
+	if errors.As(err, &qe) {
+		return CodeRateLimited
+	}
+	var rle *RoleLimitError
+	if errors.As(err, &rle) {
+		return CodeConflict
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return CodeNotFound
+	}
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		switch me.Number {
+		case mysqlErrDupEntry, mysqlErrRowReferenced, mysqlErrDeadlock, mysqlErrLockWaitTimeout:
+			return CodeConflict
+
+		case mysqlErrNoParentRow, mysqlErrDataTooLong:
+			return CodeValidation
+		}
+	}
+	return CodeInternal
+}
+
 // Error defines `flow`-specific errors, and satisfies the `error`
 // interface.
 type Error string
@@ -23,12 +188,117 @@ func (e Error) Error() string {
 	return string(e)
 }
 
+// Code answers the code of this error.
+func (e Error) Code() ErrorCode {
+	switch e {
+	case ErrDocEventRedundant, ErrDocEventStateMismatch, ErrDocEventAlreadyApplied, ErrWorkflowInactive,
+		ErrDuplicateDocument, ErrExternalTaskNotPending, ErrAdHocStepPending, ErrCapacityExceeded,
+		ErrRoleLimitReached, ErrWorkflowInUse, ErrLockHeld, ErrLockLost, ErrDocTypeInUse:
+		return CodeConflict
+
+	case ErrDocEventDocTypeMismatch, ErrDocEventBadSignature, ErrDocumentIsChild, ErrWorkflowInvalidAction,
+		ErrMessageNoRecipients, ErrDocActionInactive, ErrDocPathInvalid, ErrDocPathInconsistent, ErrDocPathTooDeep, ErrGroupNoPermissions,
+		ErrDocDataTooLarge:
+		return CodeValidation
+
+	case ErrDocumentNoParent, ErrNotFound, ErrAccessContextNotFound, ErrAdHocStepNotFound, ErrBlobNotFound,
+		ErrDocActionNotFound, ErrDocEventNotFound, ErrDocEventUnsigned, ErrDocStateNotFound,
+		ErrDocTypeNotFound, ErrDocumentNotFound, ErrExternalTaskNotFound, ErrGroupNotFound,
+		ErrMessageNotFound, ErrNodeNotFound, ErrNodeWebhookNotFound, ErrQuotaNotFound, ErrRetentionPolicyNotFound, ErrRoleNotFound,
+		ErrShareTokenNotFound,
+		ErrSubscriptionNotFound, ErrTemplateNotFound, ErrUserNotFound, ErrWebhookNotFound, ErrWorkflowNotFound:
+		return CodeNotFound
+
+	case ErrPermissionDenied, ErrShareTokenInvalid:
+		return CodePermissionDenied
+
+	case ErrRateLimited, ErrQuotaExceeded:
+		return CodeRateLimited
+
+	default:
+		return CodeInternal
+	}
+}
+
+// Is answers `true` if the target is the code of this error.  Errors
+// about specific entities not existing also match `ErrNotFound`.
+func (e Error) Is(target error) bool {
+	if target == ErrNotFound {
+		return e.Code() == CodeNotFound && e != ErrDocumentNoParent
+	}
+	c, ok := target.(ErrorCode)
+	return ok && c == e.Code()
+}
+
+// notFound answers the given entity-specific error in place of
+// `sql.ErrNoRows`; other errors are answered as they are.
+func notFound(err error, nf Error) error {
+	if err == sql.ErrNoRows {
+		return nf
+	}
+	return err
+}
+
 //
 
 const (
 	// ErrUnknown : unknown internal error
 	ErrUnknown = Error("ErrUnknown : unknown internal error")
 
+	// ErrNotFound : requested entity does not exist
+	ErrNotFound = Error("ErrNotFound : requested entity does not exist")
+	// ErrAccessContextNotFound : requested access context does not exist
+	ErrAccessContextNotFound = Error("ErrAccessContextNotFound : requested access context does not exist")
+	// ErrAdHocStepNotFound : requested ad-hoc step does not exist
+	ErrAdHocStepNotFound = Error("ErrAdHocStepNotFound : requested ad-hoc step does not exist")
+	// ErrBlobNotFound : requested blob does not exist
+	ErrBlobNotFound = Error("ErrBlobNotFound : requested blob does not exist")
+	// ErrDocActionNotFound : requested document action does not exist
+	ErrDocActionNotFound = Error("ErrDocActionNotFound : requested document action does not exist")
+	// ErrDocEventNotFound : requested document event does not exist
+	ErrDocEventNotFound = Error("ErrDocEventNotFound : requested document event does not exist")
+	// ErrDocStateNotFound : requested document state does not exist
+	ErrDocStateNotFound = Error("ErrDocStateNotFound : requested document state does not exist")
+	// ErrDocTypeNotFound : requested document type does not exist
+	ErrDocTypeNotFound = Error("ErrDocTypeNotFound : requested document type does not exist")
+	// ErrDocumentNotFound : requested document does not exist
+	ErrDocumentNotFound = Error("ErrDocumentNotFound : requested document does not exist")
+	// ErrExternalTaskNotFound : requested external task does not exist
+	ErrExternalTaskNotFound = Error("ErrExternalTaskNotFound : requested external task does not exist")
+	// ErrGroupNotFound : requested group does not exist
+	ErrGroupNotFound = Error("ErrGroupNotFound : requested group does not exist")
+	// ErrMessageNotFound : requested message does not exist
+	ErrMessageNotFound = Error("ErrMessageNotFound : requested message does not exist")
+	// ErrNodeNotFound : requested workflow node does not exist
+	ErrNodeNotFound = Error("ErrNodeNotFound : requested workflow node does not exist")
+	// ErrNodeWebhookNotFound : requested node webhook does not exist
+	ErrNodeWebhookNotFound = Error("ErrNodeWebhookNotFound : requested node webhook does not exist")
+	// ErrRoleNotFound : requested role does not exist
+	ErrRoleNotFound = Error("ErrRoleNotFound : requested role does not exist")
+	// ErrQuotaNotFound : requested quota does not exist
+	ErrQuotaNotFound = Error("ErrQuotaNotFound : requested quota does not exist")
+	// ErrRetentionPolicyNotFound : requested retention policy does not exist
+	ErrRetentionPolicyNotFound = Error("ErrRetentionPolicyNotFound : requested retention policy does not exist")
+	// ErrShareTokenNotFound : requested share token does not exist
+	ErrShareTokenNotFound = Error("ErrShareTokenNotFound : requested share token does not exist")
+	// ErrSubscriptionNotFound : requested subscription does not exist
+	ErrSubscriptionNotFound = Error("ErrSubscriptionNotFound : requested subscription does not exist")
+	// ErrTemplateNotFound : requested message template does not exist
+	ErrTemplateNotFound = Error("ErrTemplateNotFound : requested message template does not exist")
+	// ErrUserNotFound : requested user does not exist
+	ErrUserNotFound = Error("ErrUserNotFound : requested user does not exist")
+	// ErrWebhookNotFound : requested webhook does not exist
+	ErrWebhookNotFound = Error("ErrWebhookNotFound : requested webhook does not exist")
+	// ErrWorkflowNotFound : requested workflow does not exist
+	ErrWorkflowNotFound = Error("ErrWorkflowNotFound : requested workflow does not exist")
+
+	// ErrPermissionDenied : user may not perform the requested action
+	ErrPermissionDenied = Error("ErrPermissionDenied : user may not perform the requested action")
+	// ErrShareTokenInvalid : share token is malformed, forged, expired or revoked
+	ErrShareTokenInvalid = Error("ErrShareTokenInvalid : share token is malformed, forged, expired or revoked")
+	// ErrRateLimited : too many events raised recently; retry later
+	ErrRateLimited = Error("ErrRateLimited : too many events raised recently; retry later")
+
 	// ErrDocEventRedundant : another equivalent event has already effected this action
 	ErrDocEventRedundant = Error("ErrDocEventRedundant : another equivalent event has already applied this action")
 	// ErrDocEventDocTypeMismatch : document's type does not match event's type
@@ -37,17 +307,64 @@ const (
 	ErrDocEventStateMismatch = Error("ErrDocEventStateMismatch : document's state does not match event's state")
 	// ErrDocEventAlreadyApplied : event already applied; nothing to do
 	ErrDocEventAlreadyApplied = Error("ErrDocEventAlreadyApplied : event already applied; nothing to do")
+	// ErrDocEventUnsigned : event does not carry a signature
+	ErrDocEventUnsigned = Error("ErrDocEventUnsigned : event does not carry a signature")
+	// ErrDocEventBadSignature : event's signature does not verify with the given key
+	ErrDocEventBadSignature = Error("ErrDocEventBadSignature : event's signature does not verify with the given key")
 
 	// ErrDocumentNoParent : document is a root document
 	ErrDocumentNoParent = Error("ErrDocumentNoParent : document is a root document")
 	// ErrDocumentIsChild : cannot have its own state, title or tags
 	ErrDocumentIsChild = Error("ErrDocumentIsChild : cannot have its own state, title or tags")
+	// ErrDocPathInvalid : malformed document path
+	ErrDocPathInvalid = Error("ErrDocPathInvalid : malformed document path")
+	// ErrDocPathTooDeep : document would be nested too deep
+	ErrDocPathTooDeep = Error("ErrDocPathTooDeep : document would be nested too deep")
+	// ErrDocPathInconsistent : document's path does not match its registered parent
+	ErrDocPathInconsistent = Error("ErrDocPathInconsistent : document's path does not match its registered parent")
+	// ErrDuplicateDocument : an equivalent document exists already
+	ErrDuplicateDocument = Error("ErrDuplicateDocument : an equivalent document exists already")
+	// ErrDocDataTooLarge : document data exceeds the size allowed for its type
+	ErrDocDataTooLarge = Error("ErrDocDataTooLarge : document data exceeds the size allowed for its type")
+	// ErrDocTypeInUse : documents of this type exist
+	ErrDocTypeInUse = Error("ErrDocTypeInUse : documents of this type exist")
+
+	// ErrAdHocStepPending : action awaits an ad-hoc approval
+	ErrAdHocStepPending = Error("ErrAdHocStepPending : action awaits an ad-hoc approval")
+
+	// ErrCapacityExceeded : capacity limit reached; no more documents can be admitted
+	ErrCapacityExceeded = Error("ErrCapacityExceeded : capacity limit reached; no more documents can be admitted")
+	// ErrRoleLimitReached : group has the maximum number of roles in the access context
+	ErrRoleLimitReached = Error("ErrRoleLimitReached : group has the maximum number of roles in the access context")
+	// ErrQuotaExceeded : creator has reached a quota on documents of this type
+	ErrQuotaExceeded = Error("ErrQuotaExceeded : creator has reached a quota on documents of this type")
+
+	// ErrLockHeld : the lock is held by another owner
+	ErrLockHeld = Error("ErrLockHeld : the lock is held by another owner")
+	// ErrLockLost : the lock expired, and may have been acquired by another owner
+	ErrLockLost = Error("ErrLockLost : the lock expired, and may have been acquired by another owner")
+
+	// ErrGroupNoPermissions : distribution groups cannot hold roles
+	ErrGroupNoPermissions = Error("ErrGroupNoPermissions : distribution groups cannot hold roles")
+
+	// ErrDocActionInactive : this action is currently inactive
+	ErrDocActionInactive = Error("ErrDocActionInactive : this action is currently inactive")
 
 	// ErrWorkflowInactive : this workflow is currently inactive
 	ErrWorkflowInactive = Error("ErrWorkflowInactive : this workflow is currently inactive")
+	// ErrWorkflowInUse : documents have been processed under this workflow
+	ErrWorkflowInUse = Error("ErrWorkflowInUse : documents have been processed under this workflow")
 	// ErrWorkflowInvalidAction : given action cannot be performed on this document's current state
 	ErrWorkflowInvalidAction = Error("ErrWorkflowInvalidAction : given action cannot be performed on this document's current state")
+	// ErrWorkflowUnknownNodeType : target node is of a type that the engine does not know
+	ErrWorkflowUnknownNodeType = Error("ErrWorkflowUnknownNodeType : target node is of a type that the engine does not know")
 
 	// ErrMessageNoRecipients : list of recipients is empty
 	ErrMessageNoRecipients = Error("ErrMessageNoRecipients : list of recipients is empty")
+
+	// ErrExternalTaskNotPending : external task has already completed, failed or been cancelled
+	ErrExternalTaskNotPending = Error("ErrExternalTaskNotPending : external task has already completed, failed or been cancelled")
+
+	// ErrOutboxDeferred : handler not ready; retry later without counting an attempt
+	ErrOutboxDeferred = Error("ErrOutboxDeferred : handler not ready; retry later without counting an attempt")
 )
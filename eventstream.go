@@ -0,0 +1,154 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StreamEvent is the record published to the event stream, when an
+// event is successfully applied to a document.
+type StreamEvent struct {
+	Event     DocEventID  `json:"Event"`     // The applied event
+	DocType   DocTypeID   `json:"DocType"`   // Type of the affected document
+	DocID     DocumentID  `json:"DocID"`     // The affected document
+	Action    DocActionID `json:"Action"`    // Action performed
+	Actor     GroupID     `json:"Actor"`     // Singleton group of the performing user
+	FromState DocStateID  `json:"FromState"` // State before the event
+	ToState   DocStateID  `json:"ToState"`   // State after the event
+	Ctime     time.Time   `json:"Ctime"`     // Time of the event
+
+	AccCtx     AccessContextID `json:"AccCtx"`               // Access context of the document after the event
+	Message    MessageID       `json:"Message,omitempty"`    // Notification posted about the event, if any
+	Title      string          `json:"Title,omitempty"`      // Subject of the notification
+	Recipients []GroupID       `json:"Recipients,omitempty"` // Groups to whose mailboxes the notification was posted
+}
+
+// EventPublisher publishes applied events to an external stream, such
+// as a Kafka topic or a NATS subject.
+//
+// Publication is at-least-once: the same event may be published more
+// than once, in case of failures.  Consumers should de-duplicate using
+// `StreamEvent.Event`.  Events are published in the order in which
+// they were applied, except when a failed publication is retried.
+type EventPublisher interface {
+	Publish(ctx context.Context, ev *StreamEvent) error
+}
+
+var evPublisher EventPublisher
+var evPublisherMu sync.RWMutex
+
+// MultiPublisher is an `EventPublisher` that publishes each event
+// using all of its elements, in order.  It stops at the first failure;
+// the event is then published again, using all of them.
+type MultiPublisher []EventPublisher
+
+// Publish implements `EventPublisher`.
+func (ps MultiPublisher) Publish(ctx context.Context, ev *StreamEvent) error {
+	for _, p := range ps {
+		if err := p.Publish(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unexported type, only for convenience methods.
+type _EventStream struct{}
+
+// EventStream provides an interface to the publication of applied
+// events.
+//
+// While a publisher is registered, every applied event is recorded in
+// the outbox, in the same transaction that applies it.  Recorded
+// events are published by the outbox relay (see `RunOutbox`) after
+// that transaction commits.  Events that could not be published --
+// e.g. because the broker was down -- are retried by the relay.
+var EventStream _EventStream
+
+func init() {
+	Outbox.SetHandler(OutboxKindStream, publishStreamEvent)
+}
+
+// SetPublisher registers the publisher of applied events.  A `nil`
+// publisher stops publication: events applied thereafter are not
+// recorded, while those recorded already wait in the outbox until a
+// publisher is registered again.
+func (_EventStream) SetPublisher(p EventPublisher) {
+	evPublisherMu.Lock()
+	evPublisher = p
+	evPublisherMu.Unlock()
+}
+
+// publisher answers the currently-registered publisher, if any.
+func (_EventStream) publisher() EventPublisher {
+	evPublisherMu.RLock()
+	defer evPublisherMu.RUnlock()
+	return evPublisher
+}
+
+// record writes the given applied event to the outbox, together with
+// the notification posted about it, if any.  Nothing is recorded
+// unless a publisher is registered, so that installations not using
+// the event stream do not accumulate entries that never drain.
+func (_EventStream) record(otx *sql.Tx, event *DocEvent, tstate DocStateID, acid AccessContextID,
+	msg *Message, recv map[GroupID]struct{}) error {
+	if EventStream.publisher() == nil {
+		return nil
+	}
+
+	ev := &StreamEvent{
+		Event:     event.ID,
+		DocType:   event.DocType,
+		DocID:     event.DocID,
+		Action:    event.Action,
+		Actor:     event.Group,
+		FromState: event.State,
+		ToState:   tstate,
+		Ctime:     event.Ctime,
+		AccCtx:    acid,
+	}
+	if msg != nil && msg.ID > 0 {
+		ev.Message = msg.ID
+		ev.Title = msg.Title
+		for gid := range recv {
+			ev.Recipients = append(ev.Recipients, gid)
+		}
+		sort.Slice(ev.Recipients, func(i, j int) bool { return ev.Recipients[i] < ev.Recipients[j] })
+	}
+
+	_, err := Outbox.Enqueue(otx, OutboxKindStream, ev)
+	return err
+}
+
+// publishStreamEvent is the outbox handler of applied events.
+func publishStreamEvent(ctx context.Context, tx *sql.Tx, e *OutboxEntry) error {
+	p := EventStream.publisher()
+	if p == nil {
+		return ErrOutboxDeferred
+	}
+
+	var ev StreamEvent
+	err := json.Unmarshal(e.Payload, &ev)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, &ev)
+}
@@ -0,0 +1,59 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// KafkaWriter writes a single keyed message to a Kafka topic,
+// returning after the broker has acknowledged it.
+//
+// `flow` does not bundle a Kafka client.  Applications wrap the
+// producer of their choice in this interface, handling connection and
+// configuration themselves.
+type KafkaWriter interface {
+	WriteMessage(ctx context.Context, topic string, key, value []byte) error
+}
+
+// KafkaPublisher is an `EventPublisher` that writes applied events as
+// JSON to a Kafka topic.
+//
+// Messages are keyed by document, so that all events of a document
+// land in the same partition, preserving their order.
+type KafkaPublisher struct {
+	Writer KafkaWriter // Required
+	Topic  string      // Defaults to `flow.events`
+}
+
+// Publish implements `EventPublisher`.
+func (p *KafkaPublisher) Publish(ctx context.Context, ev *StreamEvent) error {
+	if p.Writer == nil {
+		return newError(CodeValidation, "Kafka writer is required")
+	}
+	topic := p.Topic
+	if topic == "" {
+		topic = "flow.events"
+	}
+
+	value, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%d:%d", ev.DocType, ev.DocID)
+	return p.Writer.WriteMessage(ctx, topic, []byte(key), value)
+}
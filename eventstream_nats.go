@@ -0,0 +1,68 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// NATSConn publishes a message on a NATS subject.  The connection type
+// of the official NATS client satisfies this interface as is.
+type NATSConn interface {
+	Publish(subject string, data []byte) error
+}
+
+// NATSFlusher is optionally implemented by a `NATSConn` that buffers
+// messages.  When available, it is used to await the server's
+// acknowledgement of each publication.
+type NATSFlusher interface {
+	Flush() error
+}
+
+// NATSPublisher is an `EventPublisher` that publishes applied events
+// as JSON on NATS subjects of the form `<prefix>.<document type ID>`.
+type NATSPublisher struct {
+	Conn          NATSConn // Required
+	SubjectPrefix string   // Defaults to `flow.events`
+}
+
+// Publish implements `EventPublisher`.
+func (p *NATSPublisher) Publish(ctx context.Context, ev *StreamEvent) error {
+	if p.Conn == nil {
+		return newError(CodeValidation, "NATS connection is required")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	prefix := p.SubjectPrefix
+	if prefix == "" {
+		prefix = "flow.events"
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	err = p.Conn.Publish(fmt.Sprintf("%s.%d", prefix, ev.DocType), data)
+	if err != nil {
+		return err
+	}
+	if f, ok := p.Conn.(NATSFlusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
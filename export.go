@@ -0,0 +1,295 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// exportPageSize is the number of rows fetched per query when
+// exporting.
+const exportPageSize = 500
+
+// ExportFormat enumerates the formats of exports.
+type ExportFormat uint8
+
+const (
+	// ExportCSV : comma-separated values, with a header row
+	ExportCSV ExportFormat = iota + 1
+	// ExportJSON : a JSON array of objects
+	ExportJSON
+)
+
+// exportWriter writes the rows of an export incrementally.
+type exportWriter struct {
+	format ExportFormat
+	w      io.Writer
+	csv    *csv.Writer
+	n      int
+}
+
+// newExportWriter begins an export in the given format, having the
+// given columns when in CSV.
+func newExportWriter(w io.Writer, format ExportFormat, cols []string) (*exportWriter, error) {
+	e := &exportWriter{format: format, w: w}
+	switch format {
+	case ExportCSV:
+		e.csv = csv.NewWriter(w)
+		return e, e.csv.Write(cols)
+
+	case ExportJSON:
+		_, err := io.WriteString(w, "[")
+		return e, err
+
+	default:
+		return nil, errorf(CodeValidation, "unknown export format : %d", format)
+	}
+}
+
+// write writes one row: the given record when in CSV, and the JSON
+// encoding of the given value otherwise.
+func (e *exportWriter) write(v interface{}, rec []string) error {
+	e.n++
+	if e.format == ExportCSV {
+		return e.csv.Write(rec)
+	}
+
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if e.n == 1 {
+		sep = "\n"
+	}
+	if _, err = io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(bs)
+	return err
+}
+
+// flush writes out the rows buffered so far.
+func (e *exportWriter) flush() error {
+	if e.format == ExportCSV {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
+
+// close ends the export.
+func (e *exportWriter) close() error {
+	if e.format == ExportCSV {
+		return e.flush()
+	}
+	_, err := io.WriteString(e.w, "\n]\n")
+	return err
+}
+
+// exportTime formats the given time for CSV exports.
+func exportTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// exportID formats the given identifier for CSV exports.
+func exportID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// Export writes the documents matching the given specification to the
+// given writer, in the given format.  Documents are fetched a page at
+// a time, and each page is written before the next is fetched, so that
+// exports of any size can be streamed.  The options are those of
+// `List`.
+func (_Documents) Export(w io.Writer, format ExportFormat, input *DocumentsListInput, opts ...ReadOption) error {
+	cols := []string{"ID", "Path", "AccessContext", "Group", "GroupName", "DocState", "DocStateName", "Ctime", "Title"}
+	e, err := newExportWriter(w, format, cols)
+	if err != nil {
+		return err
+	}
+
+	for offset := int64(0); ; offset += exportPageSize {
+		ary, err := Documents.List(input, offset, exportPageSize, opts...)
+		if err != nil {
+			return err
+		}
+		for _, d := range ary {
+			rec := []string{exportID(int64(d.ID)), string(d.Path), exportID(int64(d.AccCtx.ID)),
+				exportID(int64(d.Group.ID)), d.Group.Name, exportID(int64(d.State.ID)), d.State.Name,
+				exportTime(d.Ctime), d.Title}
+			if err = e.write(d, rec); err != nil {
+				return err
+			}
+		}
+		if err = e.flush(); err != nil {
+			return err
+		}
+		if len(ary) < exportPageSize {
+			break
+		}
+	}
+
+	return e.close()
+}
+
+// ExportCSV writes the documents matching the given specification to
+// the given writer, as CSV.  See `Export`.
+func (_Documents) ExportCSV(w io.Writer, input *DocumentsListInput, opts ...ReadOption) error {
+	return Documents.Export(w, ExportCSV, input, opts...)
+}
+
+// Export writes the events matching the given specification to the
+// given writer, in the given format, a page at a time.
+func (_DocEvents) Export(w io.Writer, format ExportFormat, input *DocEventsListInput) error {
+	cols := []string{"ID", "DocType", "DocID", "DocState", "DocAction", "Group", "Ctime", "Status", "Text"}
+	e, err := newExportWriter(w, format, cols)
+	if err != nil {
+		return err
+	}
+
+	for offset := int64(0); ; offset += exportPageSize {
+		ary, err := DocEvents.List(input, offset, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, ev := range ary {
+			status := "P"
+			if ev.Status == EventStatusApplied {
+				status = "A"
+			}
+			rec := []string{exportID(int64(ev.ID)), exportID(int64(ev.DocType)), exportID(int64(ev.DocID)),
+				exportID(int64(ev.State)), exportID(int64(ev.Action)), exportID(int64(ev.Group)),
+				exportTime(ev.Ctime), status, ev.Text}
+			if err = e.write(ev, rec); err != nil {
+				return err
+			}
+		}
+		if err = e.flush(); err != nil {
+			return err
+		}
+		if len(ary) < exportPageSize {
+			break
+		}
+	}
+
+	return e.close()
+}
+
+// ExportCSV writes the events matching the given specification to the
+// given writer, as CSV.  See `Export`.
+func (_DocEvents) ExportCSV(w io.Writer, input *DocEventsListInput) error {
+	return DocEvents.Export(w, ExportCSV, input)
+}
+
+// AuditEntry records one state transition of a document: the event
+// applied, who raised it and when, and the states before and after.
+type AuditEntry struct {
+	ID        int64       `json:"ID"`        // Sequence number of this transition
+	DocType   DocTypeID   `json:"DocType"`   // Type of the document
+	DocID     DocumentID  `json:"DocID"`     // Document that transitioned
+	FromState DocStateID  `json:"FromState"` // State before the transition
+	Event     DocEventID  `json:"Event"`     // Event applied
+	Action    DocActionID `json:"DocAction"` // Action of the event
+	Group     GroupID     `json:"Group"`     // (Singleton) group that raised the event
+	ToState   DocStateID  `json:"ToState"`   // State after the transition
+	Ctime     time.Time   `json:"Ctime"`     // Time of the event
+}
+
+// ExportAudit writes the audit trail of the given document -- or of
+// all the documents of the given type, if the document is `0` -- to
+// the given writer, in the given format, a page at a time.
+func (_DocEvents) ExportAudit(w io.Writer, format ExportFormat, dtype DocTypeID, did DocumentID) error {
+	if dtype <= 0 || did < 0 {
+		return newError(CodeValidation, "document type should be a positive integer, and document non-negative")
+	}
+
+	cols := []string{"ID", "DocType", "DocID", "FromState", "Event", "DocAction", "Group", "ToState", "Ctime"}
+	e, err := newExportWriter(w, format, cols)
+	if err != nil {
+		return err
+	}
+
+	q := `
+	SELECT dea.id, dea.doctype_id, dea.doc_id, dea.from_state_id, dea.docevent_id, de.docaction_id, de.group_id, dea.to_state_id, de.ctime
+	FROM wf_docevent_application dea
+	JOIN wf_docevents de ON de.id = dea.docevent_id
+	WHERE dea.doctype_id = ?
+	AND (? = 0 OR dea.doc_id = ?)
+	AND dea.id > ?
+	ORDER BY dea.id
+	LIMIT ?
+	`
+	var after int64
+	for {
+		ary, err := auditPage(q, dtype, did, after)
+		if err != nil {
+			return err
+		}
+		for _, ae := range ary {
+			rec := []string{exportID(ae.ID), exportID(int64(ae.DocType)), exportID(int64(ae.DocID)),
+				exportID(int64(ae.FromState)), exportID(int64(ae.Event)), exportID(int64(ae.Action)),
+				exportID(int64(ae.Group)), exportID(int64(ae.ToState)), exportTime(ae.Ctime)}
+			if err = e.write(ae, rec); err != nil {
+				return err
+			}
+			after = ae.ID
+		}
+		if err = e.flush(); err != nil {
+			return err
+		}
+		if len(ary) < exportPageSize {
+			break
+		}
+	}
+
+	return e.close()
+}
+
+// ExportAuditCSV writes the audit trail of the given document, or
+// documents, as CSV.  See `ExportAudit`.
+func (_DocEvents) ExportAuditCSV(w io.Writer, dtype DocTypeID, did DocumentID) error {
+	return DocEvents.ExportAudit(w, ExportCSV, dtype, did)
+}
+
+// auditPage fetches the page of the audit trail following the given
+// entry.
+func auditPage(q string, dtype DocTypeID, did DocumentID, after int64) ([]*AuditEntry, error) {
+	rows, err := readDB().Query(q, dtype, did, did, after, exportPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*AuditEntry, 0, exportPageSize)
+	for rows.Next() {
+		var elem AuditEntry
+		err = rows.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.FromState, &elem.Event, &elem.Action,
+			&elem.Group, &elem.ToState, &elem.Ctime)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
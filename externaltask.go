@@ -0,0 +1,580 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ExternalTaskPolicy specifies the task emitted when a document
+// arrives at a node of type `NodeTypeExternal`.
+//
+// The task is recorded in the outbox under `Kind`; the application
+// registers the outbox handler of that kind, which hands the task
+// over to the external system.  Once the external system is done, the
+// application calls `ExternalTasks.Complete` with the action resulting
+// from it.
+//
+// Should the task not complete within `Timeout`, it is emitted again,
+// up to `MaxAttempts` times in all.  Thereafter, `TimeoutAction` is
+// applied, if given; else, the task fails, and the document stays at
+// the node until it is moved manually.
+type ExternalTaskPolicy struct {
+	Kind          string        `json:"Kind"`                    // Outbox kind of the task; should not begin with `flow.`
+	Actor         GroupID       `json:"Actor"`                   // Singleton group on whose behalf results are applied
+	Timeout       time.Duration `json:"Timeout"`                 // Time allowed for each attempt; at least a second
+	MaxAttempts   int           `json:"MaxAttempts"`             // Maximum number of attempts
+	TimeoutAction DocActionID   `json:"TimeoutAction,omitempty"` // Action applied after the final attempt times out, if any
+}
+
+// SetExternalTask sets the external task policy of the given node,
+// which should be of type `NodeTypeExternal`.  A `nil` policy removes
+// it; tasks pending at the node then do not time out.
+func (_Nodes) SetExternalTask(otx *sql.Tx, id NodeID, p *ExternalTaskPolicy) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	if p != nil {
+		p.Kind = strings.TrimSpace(p.Kind)
+		v.name("Kind", p.Kind, maxNameLen)
+		if strings.HasPrefix(p.Kind, "flow.") {
+			v.fail("Kind", "should not begin with `flow.`")
+		}
+		v.positive("Actor", int64(p.Actor))
+		if p.Timeout < time.Second {
+			v.fail("Timeout", "should be at least a second")
+		}
+		v.positive("MaxAttempts", int64(p.MaxAttempts))
+		if p.TimeoutAction < 0 {
+			v.fail("TimeoutAction", "should be non-negative")
+		}
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	n, err := Nodes.Get(id)
+	if err != nil {
+		return err
+	}
+	if n.NodeType != NodeTypeExternal {
+		return errorf(CodeValidation, "node %d is not of type %s", id, NodeTypeExternal)
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if p == nil {
+			_, err := tx.Exec("DELETE FROM wf_node_external_tasks WHERE node_id = ?", id)
+			return err
+		}
+
+		var action sql.NullInt64
+		if p.TimeoutAction > 0 {
+			action = sql.NullInt64{Int64: int64(p.TimeoutAction), Valid: true}
+		}
+		q := `
+		INSERT INTO wf_node_external_tasks(node_id, kind, group_id, timeout_secs, max_attempts, timeout_action_id)
+		VALUES(?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE kind = VALUES(kind), group_id = VALUES(group_id),
+			timeout_secs = VALUES(timeout_secs), max_attempts = VALUES(max_attempts),
+			timeout_action_id = VALUES(timeout_action_id)
+		`
+		_, err := tx.Exec(q, id, p.Kind, p.Actor, int64(p.Timeout/time.Second), p.MaxAttempts, action)
+		return err
+	})
+}
+
+// ExternalTask answers the external task policy of the given node, if
+// any; `nil` otherwise.
+func (_Nodes) ExternalTask(id NodeID) (*ExternalTaskPolicy, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	return externalTaskPolicy(nil, id)
+}
+
+// externalTaskPolicy answers the external task policy of the given
+// node, if any; `nil` otherwise.
+func externalTaskPolicy(otx *sql.Tx, id NodeID) (*ExternalTaskPolicy, error) {
+	q := `
+	SELECT kind, group_id, timeout_secs, max_attempts, timeout_action_id
+	FROM wf_node_external_tasks
+	WHERE node_id = ?
+	`
+	row, err := stmts.queryRow(otx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	var p ExternalTaskPolicy
+	var secs int64
+	var action sql.NullInt64
+	err = row.Scan(&p.Kind, &p.Actor, &secs, &p.MaxAttempts, &action)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	p.Timeout = time.Duration(secs) * time.Second
+	if action.Valid {
+		p.TimeoutAction = DocActionID(action.Int64)
+	}
+	return &p, nil
+}
+
+// ExternalTaskID is the type of unique identifiers of external tasks.
+type ExternalTaskID int64
+
+// ExternalTaskStatus enumerates the states of an external task.
+type ExternalTaskStatus uint8
+
+const (
+	// ExternalTaskPending : awaiting completion by the external system
+	ExternalTaskPending ExternalTaskStatus = iota + 1
+	// ExternalTaskDone : completed; its result has been applied
+	ExternalTaskDone
+	// ExternalTaskFailed : abandoned after its final attempt timed out
+	ExternalTaskFailed
+	// ExternalTaskCancelled : the document left the node by other means
+	ExternalTaskCancelled
+)
+
+// externalTaskStatuses maps the statuses of external tasks to their
+// representations in the database.
+var externalTaskStatuses = map[ExternalTaskStatus]string{
+	ExternalTaskPending:   "P",
+	ExternalTaskDone:      "D",
+	ExternalTaskFailed:    "F",
+	ExternalTaskCancelled: "C",
+}
+
+// ExternalTask is a unit of work handed over to an external system,
+// such as a payment gateway, for a document waiting at a node of type
+// `NodeTypeExternal`.
+//
+// It is also the payload of the outbox entries that emit the task.
+// Receivers should de-duplicate using `ID` and `Attempt`.
+type ExternalTask struct {
+	ID       ExternalTaskID     `json:"ID"`               // Unique identifier of this task
+	Kind     string             `json:"Kind"`             // Outbox kind of this task
+	Node     NodeID             `json:"Node"`             // Node at which the document waits
+	DocType  DocTypeID          `json:"DocType"`          // Type of the waiting document
+	DocID    DocumentID         `json:"DocID"`            // The waiting document
+	State    DocStateID         `json:"DocState"`         // State of the node
+	Status   ExternalTaskStatus `json:"Status"`           // Current status of this task
+	Attempt  int                `json:"Attempt"`          // Number of the current attempt
+	Deadline time.Time          `json:"Deadline"`         // Time when the current attempt times out
+	Result   DocActionID        `json:"Result,omitempty"` // Action applied upon completion, if done
+	Ctime    time.Time          `json:"Ctime"`            // Time when this task was created
+	Mtime    time.Time          `json:"Mtime"`            // Time of the most recent status change
+}
+
+// Unexported type, only for convenience methods.
+type _ExternalTasks struct{}
+
+// ExternalTasks provides a resource-like interface to the tasks
+// handed over to external systems by nodes of type
+// `NodeTypeExternal`.
+//
+// A document arriving at such a node is held there while its task is
+// pending: only the actor of the node's policy can move it further,
+// which `Complete` does on behalf of the external system.
+var ExternalTasks _ExternalTasks
+
+func init() {
+	registerTimer("external tasks", timeoutExternalTasks)
+}
+
+// start creates the task of the given node for the given document,
+// and records it in the outbox.
+func (_ExternalTasks) start(otx *sql.Tx, n *Node, did DocumentID) error {
+	p, err := externalTaskPolicy(otx, n.ID)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return errorf(CodeValidation, "node %d : no external task policy is set", n.ID)
+	}
+
+	t := &ExternalTask{
+		Kind:     p.Kind,
+		Node:     n.ID,
+		DocType:  n.DocType,
+		DocID:    did,
+		State:    n.State,
+		Status:   ExternalTaskPending,
+		Attempt:  1,
+		Deadline: time.Now().Add(p.Timeout).Truncate(time.Second),
+	}
+	q := `
+	INSERT INTO wf_external_tasks(kind, node_id, doctype_id, doc_id, docstate_id, status, attempts, deadline, ctime, mtime)
+	VALUES(?, ?, ?, ?, ?, 'P', 1, ?, NOW(), NOW())
+	`
+	res, err := stmts.exec(otx, q, t.Kind, t.Node, t.DocType, t.DocID, t.State, t.Deadline)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.ID = ExternalTaskID(id)
+	t.Ctime = time.Now()
+	t.Mtime = t.Ctime
+
+	_, err = Outbox.Enqueue(otx, t.Kind, t)
+	return err
+}
+
+// cancel cancels the pending tasks of the given document, which is
+// transitioning.
+func (_ExternalTasks) cancel(otx *sql.Tx, dtype DocTypeID, did DocumentID) error {
+	q := `
+	UPDATE wf_external_tasks
+	SET status = 'C', mtime = NOW()
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND status = 'P'
+	`
+	_, err := stmts.exec(otx, q, dtype, did)
+	return err
+}
+
+// checkActor answers `ErrPermissionDenied` unless the given event,
+// on a document waiting at the given external node, is raised by the
+// actor of the node's policy.  Documents whose tasks are no longer
+// pending -- e.g. because they failed -- can be moved by anyone
+// permitted to.
+func (_ExternalTasks) checkActor(otx *sql.Tx, n *Node, event *DocEvent) error {
+	q := `
+	SELECT COUNT(*)
+	FROM wf_external_tasks
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND node_id = ?
+	AND status = 'P'
+	`
+	row, err := stmts.queryRow(otx, q, event.DocType, event.DocID, n.ID)
+	if err != nil {
+		return err
+	}
+	var count int64
+	if err = row.Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	p, err := externalTaskPolicy(otx, n.ID)
+	if err != nil {
+		return err
+	}
+	if p == nil || p.Actor != event.Group {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// Complete applies the given action, resulting from the given task,
+// to the task's document, on behalf of the actor of the task's node.
+// Completing a task that is no longer pending answers
+// `ErrExternalTaskNotPending`.
+func (_ExternalTasks) Complete(otx *sql.Tx, id ExternalTaskID, action DocActionID) error {
+	if id <= 0 || action <= 0 {
+		return newError(CodeValidation, "task ID and action must be positive integers")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		t, err := ExternalTasks.get(tx, id, true)
+		if err != nil {
+			return err
+		}
+		if t.Status != ExternalTaskPending {
+			return ErrExternalTaskNotPending
+		}
+		return ExternalTasks.complete(tx, t, action, fmt.Sprintf("external task %d completed", id))
+	})
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		Outbox.Notify()
+	}
+	return nil
+}
+
+// complete applies the given action to the document of the given
+// task, and marks the task done.
+func (_ExternalTasks) complete(tx *sql.Tx, t *ExternalTask, action DocActionID, text string) error {
+	p, err := externalTaskPolicy(tx, t.Node)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return errorf(CodeValidation, "node %d : no external task policy is set", t.Node)
+	}
+	w, err := Workflows.GetByDocType(t.DocType)
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.applySystemEvent(tx, t.DocID, t.State, action, p.Actor, text); err != nil {
+		return err
+	}
+
+	q := `
+	UPDATE wf_external_tasks
+	SET status = 'D', result_action_id = ?, mtime = NOW()
+	WHERE id = ?
+	`
+	_, err = tx.Exec(q, action, t.ID)
+	return err
+}
+
+// get answers the given task, locking it if so requested.
+func (_ExternalTasks) get(otx *sql.Tx, id ExternalTaskID, lock bool) (*ExternalTask, error) {
+	q := `
+	SELECT id, kind, node_id, doctype_id, doc_id, docstate_id, status, attempts, deadline, result_action_id, ctime, mtime
+	FROM wf_external_tasks
+	WHERE id = ?
+	`
+	if lock {
+		q += `FOR UPDATE`
+	}
+	row, err := stmts.queryRow(otx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	t, err := scanExternalTask(row.Scan)
+	if err != nil {
+		return nil, notFound(err, ErrExternalTaskNotFound)
+	}
+	return t, nil
+}
+
+// scanExternalTask reads one task using the given scanner.
+func scanExternalTask(scan func(...interface{}) error) (*ExternalTask, error) {
+	var elem ExternalTask
+	var status string
+	var action sql.NullInt64
+	err := scan(&elem.ID, &elem.Kind, &elem.Node, &elem.DocType, &elem.DocID, &elem.State, &status,
+		&elem.Attempt, &elem.Deadline, &action, &elem.Ctime, &elem.Mtime)
+	if err != nil {
+		return nil, err
+	}
+	for s, str := range externalTaskStatuses {
+		if str == status {
+			elem.Status = s
+		}
+	}
+	if elem.Status == 0 {
+		return nil, errorf(CodeInternal, "unknown external task status : %s", status)
+	}
+	if action.Valid {
+		elem.Result = DocActionID(action.Int64)
+	}
+	return &elem, nil
+}
+
+// Get answers the given task.
+func (_ExternalTasks) Get(id ExternalTaskID) (*ExternalTask, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "task ID must be a positive integer")
+	}
+
+	return ExternalTasks.get(nil, id, false)
+}
+
+// ExternalTasksListInput specifies a set of filtering criteria on
+// external tasks.
+type ExternalTasksListInput struct {
+	DocTypeID                     // Tasks of documents of this type are listed, if non-zero
+	DocumentID                    // Tasks of this document are listed, if non-zero; requires the type
+	Status     ExternalTaskStatus // Tasks in this status are listed, if non-zero
+}
+
+// List answers a subset of the external tasks, based on the input
+// specification.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_ExternalTasks) List(input *ExternalTasksListInput, offset, limit int64) ([]*ExternalTask, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if input != nil {
+		if input.DocumentID > 0 && input.DocTypeID <= 0 {
+			return nil, newError(CodeValidation, "document type is required when listing by document")
+		}
+		if input.DocTypeID > 0 {
+			where = append(where, `doctype_id = ?`)
+			args = append(args, input.DocTypeID)
+		}
+		if input.DocumentID > 0 {
+			where = append(where, `doc_id = ?`)
+			args = append(args, input.DocumentID)
+		}
+		if input.Status > 0 {
+			str, ok := externalTaskStatuses[input.Status]
+			if !ok {
+				return nil, errorf(CodeValidation, "unknown external task status : %d", input.Status)
+			}
+			where = append(where, `status = ?`)
+			args = append(args, str)
+		}
+	}
+
+	q := `
+	SELECT id, kind, node_id, doctype_id, doc_id, docstate_id, status, attempts, deadline, result_action_id, ctime, mtime
+	FROM wf_external_tasks
+	`
+	if len(where) > 0 {
+		q += `WHERE ` + strings.Join(where, ` AND `)
+	}
+	q += `
+	ORDER BY id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+
+	ary := make([]*ExternalTask, 0, 10)
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
+		elem, err := scanExternalTask(scan)
+		if err != nil {
+			return err
+		}
+		ary = append(ary, elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// timeoutExternalTasks is the timer task of external tasks.  It emits
+// again those tasks whose current attempts have timed out, and gives
+// up on those having no attempts left.
+func timeoutExternalTasks(ctx context.Context) (int, error) {
+	q := `
+	SELECT ets.id
+	FROM wf_external_tasks ets
+	JOIN wf_node_external_tasks nets ON nets.node_id = ets.node_id
+	WHERE ets.status = 'P'
+	AND ets.deadline <= NOW()
+	ORDER BY ets.deadline
+	LIMIT 100
+	`
+	ids := []ExternalTaskID{}
+	err := scanEach(db.Query, q, nil, func(scan func(...interface{}) error) error {
+		var id int64
+		if err := scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, ExternalTaskID(id))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		var ok bool
+		err = RetryTx(func(tx *sql.Tx) error {
+			var err error
+			ok, err = ExternalTasks.timeout(tx, id)
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	if n > 0 {
+		Outbox.Notify()
+	}
+
+	return n, nil
+}
+
+// timeout claims the given task, and retries it, applies its node's
+// timeout action, or fails it, as per its node's policy.  It answers
+// `true` if the task had timed out.
+func (_ExternalTasks) timeout(tx *sql.Tx, id ExternalTaskID) (bool, error) {
+	t, err := ExternalTasks.get(tx, id, true)
+	if err != nil {
+		return false, err
+	}
+	if t.Status != ExternalTaskPending || t.Deadline.After(time.Now()) {
+		return false, nil
+	}
+	p, err := externalTaskPolicy(tx, t.Node)
+	if err != nil || p == nil {
+		return false, err
+	}
+
+	switch {
+	case t.Attempt < p.MaxAttempts:
+		t.Attempt++
+		t.Deadline = time.Now().Add(p.Timeout).Truncate(time.Second)
+		q := `
+		UPDATE wf_external_tasks
+		SET attempts = ?, deadline = ?
+		WHERE id = ?
+		`
+		if _, err = tx.Exec(q, t.Attempt, t.Deadline, t.ID); err != nil {
+			return false, err
+		}
+		_, err = Outbox.Enqueue(tx, t.Kind, t)
+
+	case p.TimeoutAction > 0:
+		writeLog(LogWarn, "external task timed out; applying timeout action", F("task", t.ID), F("action", p.TimeoutAction))
+		err = ExternalTasks.complete(tx, t, p.TimeoutAction, fmt.Sprintf("external task %d timed out", t.ID))
+
+	default:
+		writeLog(LogWarn, "external task timed out", F("task", t.ID), F("attempts", t.Attempt))
+		_, err = tx.Exec("UPDATE wf_external_tasks SET status = 'F', mtime = NOW() WHERE id = ?", t.ID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -15,11 +15,23 @@
 package flow
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 // error0 expects only an error value as its argument.
@@ -79,7 +91,7 @@ func TestFlowInit(t *testing.T) {
 	gt = t
 
 	// Connect to the database.
-	driver, connStr := "mysql", "travis@/flow"
+	driver, connStr := "mysql", "travis@/flow?parseTime=true"
 	tdb := fatal1(sql.Open(driver, connStr)).(*sql.DB)
 	RegisterDB(tdb)
 }
@@ -608,6 +620,581 @@ func TestFlowDelete(t *testing.T) {
 	})
 }
 
+// Transaction rollback.
+func TestFlowRollback(t *testing.T) {
+	gt = t
+
+	t.Run("CallerTx", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		fatal1(DocStates.New(tx, "ROLLED_BACK_1"))
+		fatal0(tx.Rollback())
+
+		_, err := DocStates.GetByName("ROLLED_BACK_1")
+		assertEqual(ErrDocStateNotFound, err, "a rolled back document state should not exist")
+	})
+
+	t.Run("FailedFn", func(t *testing.T) {
+		errAbort := newError(CodeInternal, "abort")
+		err := withTx(nil, func(tx *sql.Tx) error {
+			if _, err := DocStates.New(tx, "ROLLED_BACK_2"); err != nil {
+				return err
+			}
+			if _, err := Roles.New(tx, "ROLLED_BACK_2"); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		assertEqual(errAbort, err)
+
+		_, err = DocStates.GetByName("ROLLED_BACK_2")
+		assertEqual(ErrDocStateNotFound, err, "a document state written before the failure should not exist")
+		_, err = Roles.GetByName("ROLLED_BACK_2")
+		assertEqual(ErrRoleNotFound, err, "a role written before the failure should not exist")
+	})
+}
+
+// Fixture of the tests of node policies: a document type whose
+// workflow takes documents from "Draft", through a vote at "Pending
+// Approval", to "Approved" or "Rejected", in an access context of its
+// own.
+var dtID3 DocTypeID
+var daID10 DocActionID
+var wfID3 WorkflowID
+var acID1 AccessContextID
+var roleID3 RoleID
+var nID1, nID2, nID3, nID4 NodeID
+var capDoc1, capDoc2 DocumentID
+
+// Set up of the fixture of node policies.
+func TestFlowNodeSetup(t *testing.T) {
+	gt = t
+
+	t.Run("DocType", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		dtID3 = fatal1(DocTypes.New(tx, "Purchase Request")).(DocTypeID)
+		daID10 = fatal1(DocActions.New(tx, "Submit", false)).(DocActionID)
+		fatal0(DocTypes.AddTransition(tx, dtID3, dsID1, daID10, dsID2))
+		fatal0(DocTypes.AddTransition(tx, dtID3, dsID2, daID6, dsID3))
+		fatal0(DocTypes.AddTransition(tx, dtID3, dsID2, daID7, dsID4))
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("Workflow", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		wfID3 = fatal1(Workflows.New(tx, "Purchase Management", dtID3, dsID1)).(WorkflowID)
+		nID1 = fatal1(Workflows.AddNode(tx, dtID3, dsID1, 0, wfID3, "Draft", NodeTypeBegin)).(NodeID)
+		nID2 = fatal1(Workflows.AddNode(tx, dtID3, dsID2, 0, wfID3, "Approval", NodeTypeVote)).(NodeID)
+		nID3 = fatal1(Workflows.AddNode(tx, dtID3, dsID3, 0, wfID3, "Approved", NodeTypeEnd)).(NodeID)
+		nID4 = fatal1(Workflows.AddNode(tx, dtID3, dsID4, 0, wfID3, "Rejected", NodeTypeEnd)).(NodeID)
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("AccessContext", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		acID1 = fatal1(AccessContexts.New(tx, "Purchasing")).(AccessContextID)
+		roleID3 = fatal1(Roles.New(tx, "Purchaser")).(RoleID)
+		fatal0(Roles.AddPermissions(tx, roleID3, dtID3, []DocActionID{daID6, daID7, daID10}))
+		for _, gid := range []GroupID{gID1, gID2, gID3, gID4} {
+			fatal0(AccessContexts.AddGroupRole(tx, acID1, gid, roleID3))
+		}
+
+		fatal0(tx.Commit())
+	})
+}
+
+// newPurchase creates a document of the node policies' fixture on
+// behalf of the given group, and submits it if so requested.
+func newPurchase(gid GroupID, title string, submit bool) DocumentID {
+	did := fatal1(Documents.New(nil, &DocumentsNewInput{
+		DocTypeID:       dtID3,
+		AccessContextID: acID1,
+		GroupID:         gid,
+		Title:           title,
+		Data:            title,
+	})).(DocumentID)
+	if !submit {
+		return did
+	}
+
+	input := &DocEventsNewInput{
+		DocTypeID:   dtID3,
+		DocumentID:  did,
+		DocStateID:  dsID1,
+		DocActionID: daID10,
+		GroupID:     gid,
+		Text:        "submitted",
+	}
+	res := fatal1(Workflows.Act(nil, input, nil)).(*ActResult)
+	assertEqual(dsID2, res.State)
+	return did
+}
+
+// Voting nodes.
+func TestFlowVotes(t *testing.T) {
+	gt = t
+
+	t.Run("SetVotePolicy", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		bad := &VotePolicy{Rule: VoteWeighted, Threshold: 1.5, Voters: []Voter{{Group: gID2, Weight: 1}},
+			ApproveAction: daID6, RejectAction: daID7, Actor: gID1}
+		assertEqual(CodeValidation, CodeOf(Nodes.SetVotePolicy(tx, nID2, bad)), "threshold should be in (0, 1]")
+
+		p := &VotePolicy{
+			Rule: VoteMajority,
+			Voters: []Voter{
+				{Group: gID2, Weight: 1},
+				{Group: gID3, Weight: 1},
+				{Group: gID4, Weight: 1, Veto: true},
+			},
+			ApproveAction: daID6,
+			RejectAction:  daID7,
+			Actor:         gID1,
+		}
+		fatal0(Nodes.SetVotePolicy(tx, nID2, p))
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("Majority", func(t *testing.T) {
+		did := newPurchase(gID1, "Laptops", true)
+
+		vote := &DocumentsVoteInput{DocTypeID: dtID3, DocumentID: did, GroupID: gID2, UserID: uID2, Approve: true}
+		tally := fatal1(Documents.Vote(nil, vote)).(*VoteTally)
+		assertEqual(VotePending, tally.Outcome)
+		assertEqual(1, tally.Approvals)
+
+		_, err := Documents.Vote(nil, &DocumentsVoteInput{DocTypeID: dtID3, DocumentID: did, GroupID: gID3, UserID: uID2})
+		assertEqual(ErrPermissionDenied, err, "only members of a group should vote on its behalf")
+		_, err = Documents.Vote(nil, &DocumentsVoteInput{DocTypeID: dtID3, DocumentID: did, GroupID: gID1, UserID: uID1})
+		assertEqual(ErrPermissionDenied, err, "only the voters of the policy should vote")
+
+		vote = &DocumentsVoteInput{DocTypeID: dtID3, DocumentID: did, GroupID: gID3, UserID: uID3, Approve: true}
+		tally = fatal1(Documents.Vote(nil, vote)).(*VoteTally)
+		assertEqual(VoteApproved, tally.Outcome)
+
+		doc := fatal1(Documents.Get(nil, dtID3, did)).(*Document)
+		assertEqual(dsID3, doc.State.ID, "an approved document should move on")
+
+		_, err = Documents.Vote(nil, vote)
+		assertEqual(CodeConflict, CodeOf(err), "a decided document should not be voted on")
+	})
+
+	t.Run("Veto", func(t *testing.T) {
+		did := newPurchase(gID1, "Servers", true)
+
+		vote := &DocumentsVoteInput{DocTypeID: dtID3, DocumentID: did, GroupID: gID2, UserID: uID2, Approve: true}
+		fatal1(Documents.Vote(nil, vote))
+		vote = &DocumentsVoteInput{DocTypeID: dtID3, DocumentID: did, GroupID: gID4, UserID: uID4, Text: "over budget"}
+		tally := fatal1(Documents.Vote(nil, vote)).(*VoteTally)
+		assertEqual(true, tally.Vetoed)
+		assertEqual(VoteRejected, tally.Outcome)
+
+		doc := fatal1(Documents.Get(nil, dtID3, did)).(*Document)
+		assertEqual(dsID4, doc.State.ID, "a vetoed document should be rejected")
+	})
+
+	t.Run("RemoveVotePolicy", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		fatal0(Nodes.SetVotePolicy(tx, nID2, nil))
+
+		fatal0(tx.Commit())
+
+		p := fatal1(Nodes.VotePolicy(nID2)).(*VotePolicy)
+		assertEqual(true, p == nil)
+	})
+}
+
+// Capacity limits of workflows and nodes.
+func TestFlowCapacity(t *testing.T) {
+	gt = t
+
+	t.Run("Workflow", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		err := Workflows.SetCapacity(tx, wfID3, &CapacityLimit{Max: 1, Mode: CapacityQueue})
+		assertEqual(CodeValidation, CodeOf(err), "workflow limits should not queue")
+		fatal0(Workflows.SetCapacity(tx, wfID3, &CapacityLimit{Max: 1, Mode: CapacityReject}))
+
+		fatal0(tx.Commit())
+
+		l := fatal1(Workflows.Capacity(wfID3)).(*CapacityLimit)
+		assertEqual(1, l.Max)
+		assertEqual(CapacityReject, l.Mode)
+
+		capDoc1 = newPurchase(gID1, "Printers", false)
+		_, err = Documents.New(nil, &DocumentsNewInput{DocTypeID: dtID3, AccessContextID: acID1, GroupID: gID1,
+			Title: "Scanners", Data: "Scanners"})
+		assertEqual(true, errors.Is(err, ErrCapacityExceeded), "a second document in flight should be rejected")
+
+		tx = fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		fatal0(Workflows.SetCapacity(tx, wfID3, nil))
+
+		fatal0(tx.Commit())
+
+		capDoc2 = newPurchase(gID1, "Scanners", false)
+	})
+
+	t.Run("Node", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		fatal0(Nodes.SetCapacity(tx, nID2, &CapacityLimit{Max: 1, Mode: CapacityReject}))
+
+		fatal0(tx.Commit())
+
+		submit := func(did DocumentID) (*ActResult, error) {
+			return Workflows.Act(nil, &DocEventsNewInput{DocTypeID: dtID3, DocumentID: did, DocStateID: dsID1,
+				DocActionID: daID10, GroupID: gID1, Text: "submitted"}, nil)
+		}
+		res := fatal1(submit(capDoc1)).(*ActResult)
+		assertEqual(dsID2, res.State)
+		_, err := submit(capDoc2)
+		assertEqual(true, errors.Is(err, ErrCapacityExceeded), "a full node should reject")
+
+		tx = fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		fatal0(Nodes.SetCapacity(tx, nID2, &CapacityLimit{Max: 1, Mode: CapacityQueue}))
+
+		fatal0(tx.Commit())
+
+		res = fatal1(submit(capDoc2)).(*ActResult)
+		assertEqual(dsID1, res.State, "a full node should queue")
+		assertEqual(0, fatal1(drainCapacityQueues(context.Background())).(int), "a full node should hold the queue")
+
+		approve := func(did DocumentID) {
+			fatal1(Workflows.Act(nil, &DocEventsNewInput{DocTypeID: dtID3, DocumentID: did, DocStateID: dsID2,
+				DocActionID: daID6, GroupID: gID1, Text: "approved"}, nil))
+		}
+		approve(capDoc1)
+		assertEqual(1, fatal1(drainCapacityQueues(context.Background())).(int), "a freed node should serve the queue")
+		doc := fatal1(Documents.Get(nil, dtID3, capDoc2)).(*Document)
+		assertEqual(dsID2, doc.State.ID, "a queued event should be applied")
+		approve(capDoc2)
+
+		tx = fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		fatal0(Nodes.SetCapacity(tx, nID2, nil))
+
+		fatal0(tx.Commit())
+	})
+}
+
+// Quotas on the creation of documents.
+func TestFlowQuotas(t *testing.T) {
+	gt = t
+	var qID1, qID2 QuotaID
+
+	t.Run("Set", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		_, err := Quotas.Set(tx, &Quota{DocType: dtID3, Group: gID2, Max: 1})
+		assertEqual(CodeValidation, CodeOf(err), "a quota should have a period")
+		qID1 = fatal1(Quotas.Set(tx, &Quota{DocType: dtID3, Group: gID2, Max: 1, Period: QuotaDay})).(QuotaID)
+		qID2 = fatal1(Quotas.Set(tx, &Quota{DocType: dtID3, Group: gID5, Max: 1, Period: QuotaMonth})).(QuotaID)
+
+		fatal0(tx.Commit())
+
+		qs := fatal1(Quotas.List(dtID3)).([]*Quota)
+		assertEqual(2, len(qs))
+	})
+
+	t.Run("Enforce", func(t *testing.T) {
+		u := fatal1(Quotas.Usage(qID1)).(*QuotaUsage)
+		assertEqual(0, u.Used)
+
+		newPurchase(gID2, "Monitors", false)
+		u = fatal1(Quotas.Usage(qID1)).(*QuotaUsage)
+		assertEqual(1, u.Used)
+
+		input := &DocumentsNewInput{DocTypeID: dtID3, AccessContextID: acID1, GroupID: gID2,
+			Title: "Keyboards", Data: "Keyboards"}
+		_, err := Documents.New(nil, input)
+		assertEqual(true, errors.Is(err, ErrQuotaExceeded), "a singleton group's quota should be enforced")
+		var qe *QuotaExceededError
+		if errors.As(err, &qe) {
+			assertEqual(qID1, qe.Quota)
+			assertEqual(1, qe.Used)
+		}
+
+		// The members of a general group share its quota.
+		input.GroupID = gID1
+		_, err = Documents.New(nil, input)
+		assertEqual(true, errors.Is(err, ErrQuotaExceeded), "a general group's quota should be enforced")
+
+		// A creation time alone does not exempt a document.
+		input.Ctime = time.Now().Add(-time.Minute)
+		_, err = Documents.New(nil, input)
+		assertEqual(true, errors.Is(err, ErrQuotaExceeded), "a document with a creation time should not evade the quota")
+
+		// Imported documents are exempt.
+		input.imported = true
+		fatal1(Documents.New(nil, input))
+
+		// Others are not limited.
+		newPurchase(gID3, "Keyboards", false)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		fatal0(Quotas.Delete(tx, qID1))
+		fatal0(Quotas.Delete(tx, qID2))
+		assertEqual(ErrQuotaNotFound, Quotas.Delete(tx, qID1))
+
+		fatal0(tx.Commit())
+	})
+}
+
+// Distributed locks.
+func TestFlowLocks(t *testing.T) {
+	gt = t
+
+	l1 := fatal1(Locks.Acquire("flow test", 2*time.Second)).(*Lock)
+	_, err := Locks.Acquire("flow test", time.Minute)
+	assertEqual(ErrLockHeld, err, "a held lock should not be acquired")
+	fatal0(l1.Refresh(time.Second))
+
+	// An expired lease is taken over.
+	time.Sleep(2 * time.Second)
+	l2 := fatal1(Locks.Acquire("flow test", time.Minute)).(*Lock)
+	assertEqual(ErrLockLost, l1.Refresh(time.Minute), "an expired lease should not be refreshed")
+	fatal0(l1.Release())
+	_, err = Locks.Acquire("flow test", time.Minute)
+	assertEqual(ErrLockHeld, err, "a stale holder should not release the lock")
+
+	fatal0(l2.Release())
+	l3 := fatal1(Locks.Acquire("flow test", time.Minute)).(*Lock)
+	fatal0(l3.Release())
+}
+
+// Snoozing messages.
+func TestFlowSnooze(t *testing.T) {
+	gt = t
+
+	did := newPurchase(gID1, "Monitors", true)
+	var msgID MessageID
+	for _, n := range fatal1(Mailboxes.ListByGroup(gID2, &MailboxesListInput{}, 0, 100)).([]*Notification) {
+		if n.DocID == did {
+			msgID = n.ID
+		}
+	}
+	assertNotEqual(MessageID(0), msgID, "the submission should be posted to the approvers")
+
+	t.Run("Singleton", func(t *testing.T) {
+		total := fatal1(Mailboxes.CountByUser(uID2, false)).(int64)
+		unread := fatal1(Mailboxes.CountByUser(uID2, true)).(int64)
+		unseen := fatal1(Mailboxes.CountUnreadByGroupUser(gID2, uID2)).(int64)
+		fatal0(Mailboxes.Snooze(nil, gID2, msgID, time.Now().Add(time.Hour)))
+		assertEqual(total-1, fatal1(Mailboxes.CountByUser(uID2, false)), "a snoozed message should not be counted")
+		assertEqual(unread-1, fatal1(Mailboxes.CountByUser(uID2, true)), "a snoozed message should not be counted as unread")
+		assertEqual(unseen-1, fatal1(Mailboxes.CountUnreadByGroupUser(gID2, uID2)), "a snoozed message should not be counted as unseen")
+		for _, n := range fatal1(Mailboxes.ListByGroup(gID2, &MailboxesListInput{}, 0, 100)).([]*Notification) {
+			assertNotEqual(msgID, n.ID, "a snoozed message should not be listed by default")
+		}
+		ns := fatal1(Mailboxes.ListByGroup(gID2, &MailboxesListInput{Snoozed: true}, 0, 100)).([]*Notification)
+		assertEqual(1, len(ns), "the snoozed message should be listed as such")
+
+		fatal0(Mailboxes.Snooze(nil, gID2, msgID, time.Time{}))
+		ns = fatal1(Mailboxes.ListByGroup(gID2, &MailboxesListInput{Unread: true}, 0, 100)).([]*Notification)
+		found := false
+		for _, n := range ns {
+			found = found || n.ID == msgID
+		}
+		assertEqual(true, found, "an unsnoozed message should be unread again")
+	})
+
+	t.Run("Group", func(t *testing.T) {
+		err := Mailboxes.Snooze(nil, gID5, msgID, time.Now().Add(time.Hour))
+		assertEqual(CodeValidation, CodeOf(err), "a shared mailbox should not be snoozed")
+	})
+}
+
+// webhookReceiver answers a test server that verifies the signatures
+// of the deliveries made to it, and fails the first `fail` of them.
+func webhookReceiver(t *testing.T, secret string, fail int32) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("could not read delivery : %v", err)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get(WebhookSignatureHeader) != want {
+			t.Errorf("signature : expected : %s, observed : %s", want, r.Header.Get(WebhookSignatureHeader))
+		}
+		if r.Header.Get("X-Flow-Delivery") == "" {
+			t.Errorf("delivery ID header is missing")
+		}
+		if atomic.AddInt32(&calls, 1) <= fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	return srv, &calls
+}
+
+// Webhook deliveries, with a retry.
+func TestFlowWebhooks(t *testing.T) {
+	gt = t
+
+	srv, calls := webhookReceiver(t, "hush", 1)
+	defer srv.Close()
+	whID := fatal1(Webhooks.New(nil, srv.URL, "hush", dtID3, acID1)).(WebhookID)
+	defer Webhooks.Delete(nil, whID)
+
+	newPurchase(gID1, "Projectors", true)
+	input := &WebhookDeliveriesListInput{WebhookID: whID}
+	ds := fatal1(WebhookDeliveries.List(input, 0, 0)).([]*WebhookDelivery)
+	assertEqual(1, len(ds), "the submission should be enqueued for delivery")
+
+	d := &WebhookDispatcher{Backoff: time.Minute}
+	assertEqual(0, fatal1(d.Dispatch(context.Background())), "a failed attempt should not count as sent")
+	ds = fatal1(WebhookDeliveries.List(input, 0, 0)).([]*WebhookDelivery)
+	assertEqual(WebhookStatusPending, ds[0].Status)
+	assertEqual(1, ds[0].Attempts)
+	assertEqual(true, ds[0].NextAttempt.After(time.Now().Add(30*time.Second)), "a retry should back off")
+
+	// Not due yet.
+	assertEqual(0, fatal1(d.Dispatch(context.Background())))
+	assertEqual(int32(1), atomic.LoadInt32(calls))
+
+	fatal1(db.Exec(`UPDATE wf_webhook_deliveries SET next_attempt = NOW() WHERE id = ?`, ds[0].ID))
+	assertEqual(1, fatal1(d.Dispatch(context.Background())), "a due retry should be delivered")
+	ds = fatal1(WebhookDeliveries.List(input, 0, 0)).([]*WebhookDelivery)
+	assertEqual(WebhookStatusDelivered, ds[0].Status)
+	assertEqual(2, ds[0].Attempts)
+	assertEqual(int32(2), atomic.LoadInt32(calls))
+}
+
+// Share tokens.
+func TestFlowShareTokens(t *testing.T) {
+	gt = t
+
+	fatal0(SetShareKey([]byte("0123456789abcdef0123456789abcdef")))
+	did1 := newPurchase(gID1, "Chairs", false)
+	did2 := newPurchase(gID1, "Tables", false)
+
+	// Blobs are recorded directly, sparing the blob store.
+	dir := fatal1(ioutil.TempDir("", "flow-share-")).(string)
+	defer os.RemoveAll(dir)
+	src := dir + "/quote.txt"
+	fatal0(ioutil.WriteFile(src, []byte("quote"), 0600))
+	q := `INSERT INTO wf_document_blobs(doctype_id, doc_id, name, path, sha1sum) VALUES(?, ?, ?, ?, ?)`
+	fatal1(db.Exec(q, dtID3, did1, "quote.txt", src, "sha1-of-chairs"))
+	fatal1(db.Exec(q, dtID3, did2, "quote.txt", src, "sha1-of-tables"))
+
+	token := fatal1(Documents.CreateShareToken(nil, dtID3, did1, time.Hour, ShareWithBlobs)).(string)
+	var id ShareTokenID
+	fmt.Sscanf(token, "%d.", &id)
+
+	t.Run("Valid", func(t *testing.T) {
+		doc := fatal1(Documents.GetShared(token, "tester")).(*Document)
+		assertEqual(did1, doc.ID)
+		dst := &Blob{SHA1Sum: "sha1-of-chairs", Path: dir + "/copy.txt"}
+		fatal0(Documents.GetSharedBlob(token, "tester", dst))
+		assertEqual("quote", string(fatal1(ioutil.ReadFile(dst.Path)).([]byte)))
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		forged := token[:len(token)-1] + "0"
+		if strings.HasSuffix(token, "0") {
+			forged = token[:len(token)-1] + "1"
+		}
+		_, err := Documents.GetShared(forged, "tester")
+		assertEqual(ErrShareTokenInvalid, err, "a tampered signature should be rejected")
+
+		// Nor does a signature of the token for another document.
+		other := fmt.Sprintf("%d.%s", id, signShareToken(id, dtID3, did2, "B"))
+		_, err = Documents.GetShared(other, "tester")
+		assertEqual(ErrShareTokenInvalid, err, "a signature for another document should be rejected")
+	})
+
+	t.Run("OtherDocumentBlob", func(t *testing.T) {
+		dst := &Blob{SHA1Sum: "sha1-of-tables", Path: dir + "/other.txt"}
+		err := Documents.GetSharedBlob(token, "tester", dst)
+		assertEqual(ErrBlobNotFound, err, "a blob of another document should not be answered")
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := fatal1(Documents.CreateShareToken(nil, dtID3, did1, time.Hour, ShareDocument)).(string)
+		var eid ShareTokenID
+		fmt.Sscanf(expired, "%d.", &eid)
+		fatal1(db.Exec(`UPDATE wf_share_tokens SET expires_at = NOW() - INTERVAL 1 SECOND WHERE id = ?`, eid))
+		_, err := Documents.GetShared(expired, "tester")
+		assertEqual(ErrShareTokenInvalid, err, "an expired token should be rejected")
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		fatal0(Documents.RevokeShareToken(nil, id))
+		_, err := Documents.GetShared(token, "tester")
+		assertEqual(ErrShareTokenInvalid, err, "a revoked token should be rejected")
+		err = Documents.GetSharedBlob(token, "tester", &Blob{SHA1Sum: "sha1-of-chairs", Path: dir + "/late.txt"})
+		assertEqual(ErrShareTokenInvalid, err, "a revoked token should not answer blobs")
+	})
+}
+
+// Purging of documents, with their votes.
+func TestFlowPurge(t *testing.T) {
+	gt = t
+
+	// The documents decided by votes rest in end states.
+	tbl := DocTypes.docStorName(dtID3)
+	q := `SELECT id FROM ` + tbl + ` WHERE docstate_id IN (?, ?) ORDER BY id`
+	dids := []interface{}{}
+	rows := fatal1(db.Query(q, dsID3, dsID4)).(*sql.Rows)
+	for rows.Next() {
+		var did int64
+		fatal0(rows.Scan(&did))
+		dids = append(dids, did)
+	}
+	fatal0(rows.Err())
+	rows.Close()
+	assertNotEqual(0, len(dids), "some documents should have ended")
+
+	in := inPlaceholders(len(dids))
+	args := append([]interface{}{dtID3}, dids...)
+	var n int64
+	fatal0(db.QueryRow(`SELECT COUNT(*) FROM wf_votes WHERE doctype_id = ? AND doc_id IN `+in, args...).Scan(&n))
+	assertNotEqual(int64(0), n, "the ended documents should have votes")
+
+	_, err := Retention.Get(dtID3)
+	assertEqual(ErrRetentionPolicyNotFound, err, "a document type without a policy should have none")
+
+	tx := fatal1(db.Begin()).(*sql.Tx)
+	defer tx.Rollback()
+	purged, _, err := purgeDocuments(tx, dtID3, time.Now().Add(time.Minute), 100)
+	fatal0(err)
+	fatal0(tx.Commit())
+	assertEqual(len(dids), purged)
+
+	for _, dt := range docTables {
+		q := `SELECT COUNT(*) FROM ` + dt.name + ` WHERE ` + dt.typeCol + ` = ? AND ` + dt.idCol + ` IN ` + in
+		fatal0(db.QueryRow(q, args...).Scan(&n))
+		assertEqual(int64(0), n, "purged documents should leave no rows in "+dt.name)
+	}
+	fatal0(db.QueryRow(`SELECT COUNT(*) FROM wf_docevents WHERE doctype_id = ? AND doc_id IN `+in, args...).Scan(&n))
+	assertEqual(int64(0), n, "purged documents should leave no events")
+}
+
 // Tear down.
 func TestFlowTearDown(t *testing.T) {
 	gt = t
@@ -615,6 +1202,28 @@ func TestFlowTearDown(t *testing.T) {
 	tx := fatal1(db.Begin()).(*sql.Tx)
 	defer tx.Rollback()
 
+	error1(tx.Exec(`DELETE FROM wf_locks`))
+	error1(tx.Exec(`DELETE FROM wf_votes`))
+	error1(tx.Exec(`DELETE FROM wf_node_voters`))
+	error1(tx.Exec(`DELETE FROM wf_node_vote_policies`))
+	error1(tx.Exec(`DELETE FROM wf_capacity_queue`))
+	error1(tx.Exec(`DELETE FROM wf_node_capacities`))
+	error1(tx.Exec(`DELETE FROM wf_workflow_capacities`))
+	error1(tx.Exec(`DELETE FROM wf_quotas`))
+	error1(tx.Exec(`DELETE FROM wf_outbox`))
+	error1(tx.Exec(`DELETE FROM wf_mailbox_reads`))
+	error1(tx.Exec(`DELETE FROM wf_mailboxes`))
+	error1(tx.Exec(`DELETE FROM wf_messages`))
+	error1(tx.Exec(`DELETE FROM wf_webhook_deliveries`))
+	error1(tx.Exec(`DELETE FROM wf_webhooks`))
+	error1(tx.Exec(`DELETE FROM wf_docevent_application`))
+	error1(tx.Exec(`DELETE FROM wf_docevents`))
+	error1(tx.Exec(`DELETE FROM wf_share_accesses`))
+	error1(tx.Exec(`DELETE FROM wf_share_tokens`))
+	error1(tx.Exec(`DELETE FROM wf_document_blobs`))
+	error1(tx.Exec(`DELETE FROM ` + DocTypes.docStorName(dtID3)))
+	error1(tx.Exec(`DELETE FROM wf_docstate_transitions`))
+
 	error1(tx.Exec(`DELETE FROM wf_ac_group_roles`))
 	error1(tx.Exec(`DELETE FROM wf_ac_group_hierarchy`))
 	error1(tx.Exec(`DELETE FROM wf_access_contexts`))
@@ -632,4 +1241,500 @@ func TestFlowTearDown(t *testing.T) {
 	error1(tx.Exec(`DELETE FROM wf_doctypes_master`))
 
 	fatal0(tx.Commit())
+	InvalidateMasterCache()
+}
+
+// Isolation of tenants.  Enabling multi-tenant mode alters the schema,
+// so this needs a database of its own, set up like the test database,
+// whose DSN is given in `FLOW_TENANCY_DSN`.
+func TestFlowTenancy(t *testing.T) {
+	gt = t
+
+	dsn := os.Getenv("FLOW_TENANCY_DSN")
+	if dsn == "" {
+		t.Skip("FLOW_TENANCY_DSN is not set")
+	}
+	saved := db
+	defer func() {
+		RegisterDB(saved)
+		InvalidateMasterCache()
+	}()
+
+	dbA := fatal1(sql.Open("mysql", TenantDSN(dsn, 1))).(*sql.DB)
+	defer dbA.Close()
+	dbB := fatal1(sql.Open("mysql", TenantDSN(dsn, 2))).(*sql.DB)
+	defer dbB.Close()
+
+	// Names are unique per tenant; the suffix allows re-runs.
+	sfx := fmt.Sprintf(" %d", time.Now().UnixNano())
+	var dtype DocTypeID
+	var did DocumentID
+	var gid GroupID
+	var msgID MessageID
+
+	t.Run("TenantA", func(t *testing.T) {
+		RegisterDB(dbA)
+		InvalidateMasterCache()
+		fatal0(EnableTenancy())
+
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		dtype = fatal1(DocTypes.New(tx, "Leave Request"+sfx)).(DocTypeID)
+		fatal0(tx.Commit())
+
+		tx = fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		draft := fatal1(DocStates.New(tx, "Draft"+sfx)).(DocStateID)
+		pending := fatal1(DocStates.New(tx, "Pending"+sfx)).(DocStateID)
+		submit := fatal1(DocActions.New(tx, "Submit"+sfx, false)).(DocActionID)
+		fatal0(DocTypes.AddTransition(tx, dtype, draft, submit, pending))
+		wid := fatal1(Workflows.New(tx, "Leave Management"+sfx, dtype, draft)).(WorkflowID)
+		fatal1(Workflows.AddNode(tx, dtype, draft, 0, wid, "Draft"+sfx, NodeTypeBegin))
+		fatal1(Workflows.AddNode(tx, dtype, pending, 0, wid, "Pending"+sfx, NodeTypeLinear))
+
+		res := fatal1(tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
+			VALUES('FN A', 'LN A', ?, 1)`, "tenant"+strings.TrimSpace(sfx)+"@example.com")).(sql.Result)
+		uid := fatal1(res.LastInsertId()).(int64)
+		gid = fatal1(Groups.NewSingleton(tx, UserID(uid))).(GroupID)
+		acID := fatal1(AccessContexts.New(tx, "Leaves"+sfx)).(AccessContextID)
+		rid := fatal1(Roles.New(tx, "Employee"+sfx)).(RoleID)
+		fatal0(Roles.AddPermissions(tx, rid, dtype, []DocActionID{submit}))
+		fatal0(AccessContexts.AddGroupRole(tx, acID, gid, rid))
+		fatal0(tx.Commit())
+
+		did = fatal1(Documents.New(nil, &DocumentsNewInput{
+			DocTypeID:       dtype,
+			AccessContextID: acID,
+			GroupID:         gid,
+			Title:           "Vacation",
+			Data:            "Vacation",
+		})).(DocumentID)
+		input := &DocEventsNewInput{
+			DocTypeID:   dtype,
+			DocumentID:  did,
+			DocStateID:  draft,
+			DocActionID: submit,
+			GroupID:     gid,
+			Text:        "submitted",
+		}
+		fatal1(Workflows.Act(nil, input, nil))
+
+		row := db.QueryRow(`
+			SELECT mbs.id
+			FROM wf_mailboxes mbs
+			JOIN wf_messages msgs ON msgs.id = mbs.message_id
+			WHERE msgs.doctype_id = ? AND msgs.doc_id = ?
+			LIMIT 1`, dtype, did)
+		fatal0(row.Scan(&msgID))
+
+		fatal1(Documents.Get(nil, dtype, did))
+		fatal1(Mailboxes.GetMessage(msgID))
+	})
+	if t.Failed() {
+		return
+	}
+
+	t.Run("TenantB", func(t *testing.T) {
+		RegisterDB(dbB)
+		InvalidateMasterCache()
+
+		_, err := DocTypes.Get(dtype)
+		assertEqual(CodeNotFound, CodeOf(err), "document types of another tenant should not be visible")
+		_, err = Documents.Get(nil, dtype, did)
+		assertEqual(CodeNotFound, CodeOf(err), "documents of another tenant should not be visible")
+		_, err = Mailboxes.GetMessage(msgID)
+		assertEqual(ErrMessageNotFound, err, "messages of another tenant should not be visible")
+
+		ns := fatal1(Mailboxes.ListByGroup(gid, &MailboxesListInput{}, 0, 100)).([]*Notification)
+		assertEqual(0, len(ns), "mailboxes of another tenant should be empty")
+		n := fatal1(Mailboxes.CountByGroup(gid, false)).(int64)
+		assertEqual(int64(0), n)
+
+		var cnt int64
+		fatal0(db.QueryRow(`SELECT COUNT(*) FROM wf_messages WHERE doctype_id = ?`, dtype).Scan(&cnt))
+		assertEqual(int64(0), cnt, "tables should answer only the rows of the current tenant")
+	})
+}
+
+// Benchmarks of frequently-run queries, with and without the prepared
+// statement cache.  They use the same test database as the tests
+// above, and need no data in it.
+func BenchmarkStatementCache(b *testing.B) {
+	if db == nil {
+		tdb, err := sql.Open("mysql", "travis@/flow?parseTime=true")
+		if err != nil {
+			b.Fatal(err)
+		}
+		RegisterDB(tdb)
+	}
+	defer SetStatementCacheSize(256)
+
+	queries := []struct {
+		name string
+		fn   func() error
+	}{
+		{"UserHasPermission", func() error {
+			_, err := AccessContexts.UserHasPermission(1, 1, 1, 1)
+			return err
+		}},
+		{"IncludesUser", func() error {
+			_, err := AccessContexts.IncludesUser(1, 1)
+			return err
+		}},
+		{"Transitions", func() error {
+			_, err := DocTypes._Transitions(1, 1)
+			return err
+		}},
+	}
+
+	for _, q := range queries {
+		for _, size := range []int{0, 256} {
+			name := q.name + "/uncached"
+			if size > 0 {
+				name = q.name + "/cached"
+			}
+			fn := q.fn
+			b.Run(name, func(b *testing.B) {
+				SetStatementCacheSize(size)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if err := fn(); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// Classification of transient errors, needing no database.
+func TestFlowTransientErrors(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found"}
+	timeout := &mysql.MySQLError{Number: mysqlErrLockWaitTimeout, Message: "Lock wait timeout exceeded"}
+
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"deadlock", deadlock, true},
+		{"lock wait time-out", timeout, true},
+		{"wrapped deadlock", fmt.Errorf("applying event : %w", deadlock), true},
+		{"doubly-wrapped time-out", fmt.Errorf("outer : %w", fmt.Errorf("inner : %w", timeout)), true},
+		{"duplicate entry", &mysql.MySQLError{Number: mysqlErrDupEntry, Message: "Duplicate entry"}, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"flow error", ErrDocEventRedundant, false},
+	}
+	for _, c := range cases {
+		if got := IsTransientError(c.err); got != c.want {
+			t.Errorf("%s : expected : %v, observed : %v", c.name, c.want, got)
+		}
+	}
+}
+
+// Outcomes of votes under the rules, needing no database.
+func TestFlowVoteDecide(t *testing.T) {
+	majority := &VotePolicy{Rule: VoteMajority}
+	weighted := &VotePolicy{Rule: VoteWeighted, Threshold: 0.6}
+	unanimous := &VotePolicy{Rule: VoteWeighted, Threshold: 1}
+
+	cases := []struct {
+		name  string
+		p     *VotePolicy
+		tally VoteTally
+		want  VoteOutcome
+	}{
+		{"majority : none", majority, VoteTally{Voters: 3}, VotePending},
+		{"majority : one of three", majority, VoteTally{Voters: 3, Approvals: 1}, VotePending},
+		{"majority : two of three", majority, VoteTally{Voters: 3, Approvals: 2}, VoteApproved},
+		{"majority : two against", majority, VoteTally{Voters: 3, Rejections: 2}, VoteRejected},
+		{"majority : half in favour", majority, VoteTally{Voters: 4, Approvals: 2}, VotePending},
+		{"majority : tie", majority, VoteTally{Voters: 4, Approvals: 2, Rejections: 2}, VoteRejected},
+		{"majority : veto", majority, VoteTally{Voters: 3, Approvals: 2, Rejections: 1, Vetoed: true}, VoteRejected},
+		{"weighted : below threshold", weighted, VoteTally{TotalWeight: 10, ApprovedWeight: 5}, VotePending},
+		{"weighted : at threshold", weighted, VoteTally{TotalWeight: 10, ApprovedWeight: 6}, VoteApproved},
+		{"weighted : still reachable", weighted, VoteTally{TotalWeight: 10, RejectedWeight: 4}, VotePending},
+		{"weighted : unreachable", weighted, VoteTally{TotalWeight: 10, RejectedWeight: 5}, VoteRejected},
+		{"weighted : veto", weighted, VoteTally{TotalWeight: 10, ApprovedWeight: 9, Vetoed: true}, VoteRejected},
+		{"unanimous : all", unanimous, VoteTally{TotalWeight: 10, ApprovedWeight: 10}, VoteApproved},
+		{"unanimous : one against", unanimous, VoteTally{TotalWeight: 10, RejectedWeight: 1}, VoteRejected},
+	}
+	for _, c := range cases {
+		c.tally.decide(c.p)
+		if c.tally.Outcome != c.want {
+			t.Errorf("%s : expected : %v, observed : %v", c.name, c.want, c.tally.Outcome)
+		}
+	}
+}
+
+// Validation and reading of capacity limits, needing no database.
+func TestFlowCapacityLimits(t *testing.T) {
+	cases := []struct {
+		name  string
+		l     CapacityLimit
+		queue bool
+		valid bool
+	}{
+		{"reject", CapacityLimit{Max: 5, Mode: CapacityReject}, false, true},
+		{"notify", CapacityLimit{Max: 5, Mode: CapacityNotify}, false, true},
+		{"queue at node", CapacityLimit{Max: 5, Mode: CapacityQueue}, true, true},
+		{"queue at workflow", CapacityLimit{Max: 5, Mode: CapacityQueue}, false, false},
+		{"zero maximum", CapacityLimit{Max: 0, Mode: CapacityReject}, true, false},
+		{"unknown mode", CapacityLimit{Max: 5}, true, false},
+	}
+	for _, c := range cases {
+		var v validator
+		c.l.validate(&v, c.queue)
+		if err := v.result(); (err == nil) != c.valid {
+			t.Errorf("%s : expected valid : %v, observed : %v", c.name, c.valid, err)
+		}
+	}
+
+	for m, str := range capacityModes {
+		l, err := scanCapacityLimit(func(dest ...interface{}) error {
+			*dest[0].(*int) = 3
+			*dest[1].(*string) = str
+			return nil
+		})
+		if err != nil || l.Max != 3 || l.Mode != m {
+			t.Errorf("mode %q : unexpected limit : %v, %v", str, l, err)
+		}
+	}
+	_, err := scanCapacityLimit(func(dest ...interface{}) error {
+		*dest[1].(*string) = "?"
+		return nil
+	})
+	if CodeOf(err) != CodeInternal {
+		t.Errorf("expected an unknown mode to be an internal error; observed : %v", err)
+	}
+}
+
+// Quota errors, and the reading of quotas, needing no database.
+func TestFlowQuotaErrors(t *testing.T) {
+	qe := &QuotaExceededError{Quota: 7, Group: 3, Max: 2, Used: 2}
+	cases := []struct {
+		name   string
+		target error
+		want   bool
+	}{
+		{"sentinel", ErrQuotaExceeded, true},
+		{"code", CodeRateLimited, true},
+		{"other sentinel", ErrRateLimited, false},
+		{"other code", CodeValidation, false},
+	}
+	for _, c := range cases {
+		if got := errors.Is(fmt.Errorf("creating : %w", qe), c.target); got != c.want {
+			t.Errorf("%s : expected : %v, observed : %v", c.name, c.want, got)
+		}
+	}
+
+	for p, str := range quotaPeriods {
+		q, err := scanQuota(func(dest ...interface{}) error {
+			*dest[4].(*string) = str
+			return nil
+		})
+		if err != nil || q.Period != p {
+			t.Errorf("period %q : unexpected quota : %v, %v", str, q, err)
+		}
+	}
+	_, err := scanQuota(func(dest ...interface{}) error {
+		*dest[4].(*string) = "Y"
+		return nil
+	})
+	if CodeOf(err) != CodeInternal {
+		t.Errorf("expected an unknown period to be an internal error; observed : %v", err)
+	}
+}
+
+// Token buckets of the rate limiter, needing no database.
+func TestFlowRateLimits(t *testing.T) {
+	l := &rateLimiter{
+		def:     2,
+		perAC:   map[AccessContextID]int{2: 1},
+		buckets: map[rateKey]*rateBucket{},
+	}
+
+	cases := []struct {
+		name string
+		op   string
+		gid  GroupID
+		acid AccessContextID
+		want error
+	}{
+		{"default : first", rateOpNew, 1, 1, nil},
+		{"default : second", rateOpNew, 1, 1, nil},
+		{"default : exhausted", rateOpNew, 1, 1, ErrRateLimited},
+		{"default : other operation", rateOpApply, 1, 1, nil},
+		{"default : other group", rateOpNew, 2, 1, nil},
+		{"override : first", rateOpNew, 1, 2, nil},
+		{"override : exhausted", rateOpNew, 1, 2, ErrRateLimited},
+	}
+	for _, c := range cases {
+		if err := l.allow(c.op, c.gid, c.acid); err != c.want {
+			t.Errorf("%s : expected : %v, observed : %v", c.name, c.want, err)
+		}
+	}
+
+	// Half a minute replenishes half the allowance.
+	l.buckets[rateKey{rateOpNew, 1, 1}].last = time.Now().Add(-30 * time.Second)
+	if err := l.allow(rateOpNew, 1, 1); err != nil {
+		t.Errorf("replenished : expected : <nil>, observed : %v", err)
+	}
+	if err := l.allow(rateOpNew, 1, 1); err != ErrRateLimited {
+		t.Errorf("replenished : expected : %v, observed : %v", ErrRateLimited, err)
+	}
+
+	// Without a default, only the overrides limit.
+	l.def = 0
+	for i := 0; i < 3; i++ {
+		if err := l.allow(rateOpNew, 1, 1); err != nil {
+			t.Errorf("unlimited : expected : <nil>, observed : %v", err)
+		}
+	}
+	if err := l.allow(rateOpNew, 3, 2); err != nil {
+		t.Errorf("override : expected : <nil>, observed : %v", err)
+	}
+	if err := l.allow(rateOpNew, 3, 2); err != ErrRateLimited {
+		t.Errorf("override : expected : %v, observed : %v", ErrRateLimited, err)
+	}
+}
+
+// Validation of lock leases, needing no database.
+func TestFlowLockLeases(t *testing.T) {
+	cases := []struct {
+		ttl  time.Duration
+		want int64
+	}{
+		{time.Second, 1},
+		{time.Second + time.Millisecond, 2},
+		{1500 * time.Millisecond, 2},
+		{time.Minute, 60},
+	}
+	for _, c := range cases {
+		if got := lockSecs(c.ttl); got != c.want {
+			t.Errorf("%v : expected : %d, observed : %d", c.ttl, c.want, got)
+		}
+	}
+
+	if _, err := Locks.Acquire(" ", time.Minute); CodeOf(err) != CodeValidation {
+		t.Errorf("expected a blank name to be invalid; observed : %v", err)
+	}
+	if _, err := Locks.Acquire("runner", 999*time.Millisecond); CodeOf(err) != CodeValidation {
+		t.Errorf("expected a sub-second lease to be invalid; observed : %v", err)
+	}
+	l := &Lock{Name: "runner", Owner: "x"}
+	if err := l.Refresh(time.Millisecond); CodeOf(err) != CodeValidation {
+		t.Errorf("expected a sub-second refresh to be invalid; observed : %v", err)
+	}
+}
+
+// Delays of the outbox relay, needing no database.
+func TestFlowOutboxDelays(t *testing.T) {
+	c := OutboxConfig{PollInterval: time.Second, Backoff: 10 * time.Second, MaxBackoff: time.Hour}
+
+	retries := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+		{1 << 20, time.Hour},
+	}
+	for _, r := range retries {
+		if got := c.retryDelay(r.failures); got != r.want {
+			t.Errorf("retry after %d failures : expected : %v, observed : %v", r.failures, r.want, got)
+		}
+	}
+
+	deferrals := []struct {
+		age  time.Duration
+		want time.Duration
+	}{
+		{0, time.Second},
+		{500 * time.Millisecond, time.Second},
+		{time.Minute, time.Minute},
+		{48 * time.Hour, time.Hour},
+	}
+	for _, d := range deferrals {
+		if got := c.deferDelay(d.age); got != d.want {
+			t.Errorf("deferral at age %v : expected : %v, observed : %v", d.age, d.want, got)
+		}
+	}
+}
+
+// Invalidation of the master cache, needing no database.
+func TestFlowMasterCacheChanges(t *testing.T) {
+	c := &masterCache{ttl: time.Minute, entries: map[string]masterEntry{}, settling: map[string]time.Time{}}
+
+	c.put(masterDocTypes, "old", DocTypeID(1))
+	c.changed(nil, masterDocTypes)
+	if _, ok := c.get(masterDocTypes, DocTypeID(1)); ok {
+		t.Errorf("a changed kind should be invalidated")
+	}
+	c.put(masterDocTypes, "new", DocTypeID(1))
+	if _, ok := c.get(masterDocTypes, DocTypeID(1)); !ok {
+		t.Errorf("a kind changed in a committed transaction should be filled again")
+	}
+
+	// A transaction of the caller may not have been committed yet.
+	c.changed(&sql.Tx{}, masterDocTypes)
+	c.put(masterDocTypes, "old", DocTypeID(1))
+	if _, ok := c.get(masterDocTypes, DocTypeID(1)); ok {
+		t.Errorf("a kind changed in an open transaction should not be filled")
+	}
+	c.put(masterRoles, "role", RoleID(1))
+	if _, ok := c.get(masterRoles, RoleID(1)); !ok {
+		t.Errorf("other kinds should be filled")
+	}
+
+	c.settling[masterDocTypes] = time.Now().Add(-time.Second)
+	c.put(masterDocTypes, "new", DocTypeID(1))
+	if _, ok := c.get(masterDocTypes, DocTypeID(1)); !ok {
+		t.Errorf("a kind should be filled again once it has settled")
+	}
+}
+
+// Signing and backoff of webhook deliveries, needing no database.
+func TestFlowWebhookDelivery(t *testing.T) {
+	srv, calls := webhookReceiver(t, "hush", 1)
+	defer srv.Close()
+
+	d := (&WebhookDispatcher{Backoff: 30 * time.Second, MaxBackoff: time.Hour}).config()
+	payload := []byte(`{"Event":1}`)
+	err := d.post(context.Background(), d.Client, srv.URL, "hush", 7, payload)
+	if CodeOf(err) != CodeInternal {
+		t.Errorf("a failed delivery : expected : %v, observed : %v", CodeInternal, err)
+	}
+	if err = d.post(context.Background(), d.Client, srv.URL, "hush", 7, payload); err != nil {
+		t.Errorf("a successful delivery : unexpected error : %v", err)
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("attempts : expected : 2, observed : %d", n)
+	}
+
+	retries := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{1 << 20, time.Hour},
+	}
+	for _, r := range retries {
+		if got := d.retryDelay(r.failures); got != r.want {
+			t.Errorf("retry after %d failures : expected : %v, observed : %v", r.failures, r.want, got)
+		}
+	}
 }
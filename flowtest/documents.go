@@ -0,0 +1,635 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowtest
+
+import (
+	"crypto"
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/js-ojus/flow"
+)
+
+// bounds answers the indices of the page of a result set of the given
+// size, as per the usual `offset` and `limit` of listings.
+func bounds(n int, offset, limit int64) (int, int, error) {
+	if offset < 0 || limit < 0 {
+		return 0, 0, &flow.CodedError{Code: flow.CodeValidation, Msg: "offset and limit must be non-negative integers"}
+	}
+	i := int64(n)
+	if offset < i {
+		i = offset
+	}
+	j := int64(n)
+	if limit > 0 && i+limit < j {
+		j = i + limit
+	}
+	return int(i), int(j), nil
+}
+
+// Documents is an in-memory fake of `flow.Documents`.  It covers root
+// documents, their titles, data and tags.  Read options are accepted,
+// but ignored; tags are always answered.
+type Documents struct {
+	e *Engine
+}
+
+var _ flow.DocumentsAPI = (*Documents)(nil)
+
+// Documents answers the fake documents of this engine.
+func (e *Engine) Documents() *Documents {
+	return &Documents{e: e}
+}
+
+// doc answers the given document.  The caller should hold the lock.
+func (e *Engine) doc(dtype flow.DocTypeID, id flow.DocumentID) (*flow.Document, error) {
+	dt := e.doctypeByID(dtype)
+	if dt == nil {
+		return nil, flow.ErrDocTypeNotFound
+	}
+	d, ok := dt.docs[id]
+	if !ok {
+		return nil, flow.ErrDocumentNotFound
+	}
+	return d, nil
+}
+
+// copyDoc answers a copy of the given document.
+func copyDoc(d *flow.Document) *flow.Document {
+	cp := *d
+	cp.Tags = append([]string{}, d.Tags...)
+	return &cp
+}
+
+// AddBlob implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) AddBlob(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, blob *flow.Blob) error {
+	return ErrUnsupported
+}
+
+// AddTags implements `flow.DocumentsAPI`.
+func (ds *Documents) AddTags(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, tags ...string) error {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	d, err := ds.e.doc(dtype, id)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		found := false
+		for _, t := range d.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.Tags = append(d.Tags, tag)
+		}
+	}
+	sort.Strings(d.Tags)
+	return nil
+}
+
+// Blobs implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) Blobs(dtype flow.DocTypeID, id flow.DocumentID) ([]*flow.Blob, error) {
+	return nil, ErrUnsupported
+}
+
+// Branches implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) Branches(dtype flow.DocTypeID, did flow.DocumentID) ([]*flow.Branch, error) {
+	return nil, ErrUnsupported
+}
+
+// ChangesSince implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) ChangesSince(dtype flow.DocTypeID, id flow.DocumentID, cursor int64) ([]*flow.DocumentChange, error) {
+	return nil, ErrUnsupported
+}
+
+// ChildCounts implements `flow.DocumentsAPI`.  Documents of the fake
+// have no children.
+func (ds *Documents) ChildCounts(dtype flow.DocTypeID, id flow.DocumentID) (map[flow.DocTypeID]int64, error) {
+	return map[flow.DocTypeID]int64{}, nil
+}
+
+// Children implements `flow.DocumentsAPI`.  Documents of the fake have
+// no children.
+func (ds *Documents) Children(dtype flow.DocTypeID, id flow.DocumentID, offset, limit int64, opts ...flow.ReadOption) ([]*flow.Document, error) {
+	return []*flow.Document{}, nil
+}
+
+// ChildrenIDs implements `flow.DocumentsAPI`.  Documents of the fake
+// have no children.
+func (ds *Documents) ChildrenIDs(dtype flow.DocTypeID, id flow.DocumentID) ([]struct {
+	flow.DocTypeID
+	flow.DocumentID
+}, error) {
+	return []struct {
+		flow.DocTypeID
+		flow.DocumentID
+	}{}, nil
+}
+
+// CreateShareToken implements `flow.DocumentsAPI`; it is not
+// supported.
+func (*Documents) CreateShareToken(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, ttl time.Duration,
+	scope flow.ShareScope) (string, error) {
+	return "", ErrUnsupported
+}
+
+// DeleteBlob implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) DeleteBlob(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, sha1 string) error {
+	return ErrUnsupported
+}
+
+// Export implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) Export(w io.Writer, format flow.ExportFormat, input *flow.DocumentsListInput, opts ...flow.ReadOption) error {
+	return ErrUnsupported
+}
+
+// ExportCSV implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) ExportCSV(w io.Writer, input *flow.DocumentsListInput, opts ...flow.ReadOption) error {
+	return ErrUnsupported
+}
+
+// Get implements `flow.DocumentsAPI`.
+func (ds *Documents) Get(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, opts ...flow.ReadOption) (*flow.Document, error) {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	d, err := ds.e.doc(dtype, id)
+	if err != nil {
+		return nil, err
+	}
+	return copyDoc(d), nil
+}
+
+// GetBlob implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) GetBlob(dtype flow.DocTypeID, id flow.DocumentID, blob *flow.Blob) error {
+	return ErrUnsupported
+}
+
+// GetMany implements `flow.DocumentsAPI`.
+func (ds *Documents) GetMany(otx *sql.Tx, dtype flow.DocTypeID, ids []flow.DocumentID, opts ...flow.ReadOption) ([]*flow.Document, error) {
+	refs := make([]flow.DocumentRef, 0, len(ids))
+	for _, id := range ids {
+		refs = append(refs, flow.DocumentRef{DocType: dtype, ID: id})
+	}
+	return ds.GetManyRefs(otx, refs, opts...)
+}
+
+// GetManyRefs implements `flow.DocumentsAPI`.  Documents that do not
+// exist are omitted.
+func (ds *Documents) GetManyRefs(otx *sql.Tx, refs []flow.DocumentRef, opts ...flow.ReadOption) ([]*flow.Document, error) {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	ary := make([]*flow.Document, 0, len(refs))
+	for _, ref := range refs {
+		if d, err := ds.e.doc(ref.DocType, ref.ID); err == nil {
+			ary = append(ary, copyDoc(d))
+		}
+	}
+	return ary, nil
+}
+
+// GetParent implements `flow.DocumentsAPI`.  Documents of the fake are
+// all root documents.
+func (ds *Documents) GetParent(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID) (*flow.Document, error) {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	if _, err := ds.e.doc(dtype, id); err != nil {
+		return nil, err
+	}
+	return nil, flow.ErrDocumentNoParent
+}
+
+// GetShared implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) GetShared(token, from string) (*flow.Document, error) {
+	return nil, ErrUnsupported
+}
+
+// GetSharedBlob implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) GetSharedBlob(token, from string, blob *flow.Blob) error {
+	return ErrUnsupported
+}
+
+// List implements `flow.DocumentsAPI`.  Filtering by visibility, data
+// and derived fields is not supported.
+func (ds *Documents) List(input *flow.DocumentsListInput, offset, limit int64, opts ...flow.ReadOption) ([]*flow.Document, error) {
+	if input.Visibility != flow.VisibilityAll || len(input.DataWhere) > 0 || len(input.FieldWhere) > 0 || input.SortBy != "" {
+		return nil, ErrUnsupported
+	}
+
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	dt := ds.e.doctypeByID(input.DocTypeID)
+	if dt == nil {
+		return nil, flow.ErrDocTypeNotFound
+	}
+	ary := []*flow.Document{}
+	for _, d := range dt.docs {
+		switch {
+		case input.AccessContextID > 0 && d.AccCtx.ID != input.AccessContextID,
+			input.GroupID > 0 && d.Group.ID != input.GroupID,
+			input.DocStateID > 0 && d.State.ID != input.DocStateID,
+			!input.CtimeStarting.IsZero() && d.Ctime.Before(input.CtimeStarting),
+			!input.CtimeBefore.IsZero() && !d.Ctime.Before(input.CtimeBefore),
+			input.TitleContains != "" && !strings.Contains(d.Title, input.TitleContains):
+			continue
+		}
+		ary = append(ary, copyDoc(d))
+	}
+	sort.Slice(ary, func(i, j int) bool {
+		return ary[i].ID < ary[j].ID
+	})
+
+	i, j, err := bounds(len(ary), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ary[i:j], nil
+}
+
+// New implements `flow.DocumentsAPI`.  Child documents are not
+// supported.
+func (ds *Documents) New(otx *sql.Tx, input *flow.DocumentsNewInput) (flow.DocumentID, error) {
+	if err := input.Validate(); err != nil {
+		return 0, err
+	}
+	if input.ParentID > 0 {
+		return 0, ErrUnsupported
+	}
+
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	dt := ds.e.doctypeByID(input.DocTypeID)
+	if dt == nil {
+		return 0, flow.ErrDocTypeNotFound
+	}
+	if dt.wflow == nil {
+		return 0, flow.ErrWorkflowNotFound
+	}
+	ac := ds.e.accessContext(input.AccessContextID)
+	if ac == nil {
+		return 0, flow.ErrAccessContextNotFound
+	}
+	g := ds.e.groupByID(input.GroupID)
+	if g == nil || g.GroupType != flow.GroupSingleton {
+		return 0, flow.ErrGroupNotFound
+	}
+
+	ctime := input.Ctime
+	if ctime.IsZero() {
+		ctime = time.Now()
+	}
+	d := &flow.Document{
+		ID:      flow.DocumentID(ds.e.next()),
+		DocType: dt.DocType,
+		Path:    "/",
+		AccCtx:  ac.AccessContext,
+		State:   dt.wflow.BeginState,
+		Group:   *g,
+		Ctime:   ctime,
+		Title:   strings.TrimSpace(input.Title),
+		Data:    input.Data,
+	}
+	dt.docs[d.ID] = d
+	return d.ID, nil
+}
+
+// OnTerminal implements `flow.DocumentsAPI`.  Hooks are run
+// synchronously, once the application of an event leaving the document
+// in the state of an end node has been recorded; their errors are
+// ignored.
+func (ds *Documents) OnTerminal(name string, fn flow.TerminalHook) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return &flow.CodedError{Code: flow.CodeValidation, Msg: "hook name should be non-empty"}
+	}
+
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	if fn == nil {
+		delete(ds.e.terminals, name)
+	} else {
+		ds.e.terminals[name] = fn
+	}
+	return nil
+}
+
+// OpenData implements `flow.DocumentsAPI`.
+func (ds *Documents) OpenData(dtype flow.DocTypeID, id flow.DocumentID) (io.ReadCloser, error) {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	d, err := ds.e.doc(dtype, id)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(d.Data)), nil
+}
+
+// RegisterRenderer implements `flow.DocumentsAPI`; renderers are
+// ignored.
+func (*Documents) RegisterRenderer(dtype flow.DocTypeID, fn flow.Renderer) {}
+
+// RemoveTag implements `flow.DocumentsAPI`.
+func (ds *Documents) RemoveTag(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, tag string) error {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	d, err := ds.e.doc(dtype, id)
+	if err != nil {
+		return err
+	}
+	tag = strings.TrimSpace(tag)
+	for i, t := range d.Tags {
+		if t == tag {
+			d.Tags = append(d.Tags[:i], d.Tags[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Render implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) Render(dtype flow.DocTypeID, id flow.DocumentID, format flow.RenderFormat) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// RenderBlob implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) RenderBlob(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, format flow.RenderFormat,
+	name string) (*flow.Blob, error) {
+	return nil, ErrUnsupported
+}
+
+// ReturnTargets implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) ReturnTargets(dtype flow.DocTypeID, did flow.DocumentID) ([]*flow.DocState, error) {
+	return nil, ErrUnsupported
+}
+
+// RevokeShareToken implements `flow.DocumentsAPI`; it is not
+// supported.
+func (*Documents) RevokeShareToken(otx *sql.Tx, id flow.ShareTokenID) error {
+	return ErrUnsupported
+}
+
+// SetChangeLog implements `flow.DocumentsAPI`; changes are not logged.
+func (*Documents) SetChangeLog(dtype flow.DocTypeID, enabled bool) {}
+
+// SetData implements `flow.DocumentsAPI`.
+func (ds *Documents) SetData(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, data string) error {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	d, err := ds.e.doc(dtype, id)
+	if err != nil {
+		return err
+	}
+	d.Data = data
+	return nil
+}
+
+// SetDataFrom implements `flow.DocumentsAPI`.
+func (ds *Documents) SetDataFrom(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, r io.Reader) error {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return ds.SetData(otx, dtype, id, string(buf))
+}
+
+// SetDuplicatePolicy implements `flow.DocumentsAPI`; duplicates are not
+// detected.
+func (*Documents) SetDuplicatePolicy(dtype flow.DocTypeID, fn flow.DuplicateKeyFunc) {}
+
+// SetTitle implements `flow.DocumentsAPI`.
+func (ds *Documents) SetTitle(otx *sql.Tx, dtype flow.DocTypeID, id flow.DocumentID, title string) error {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	d, err := ds.e.doc(dtype, id)
+	if err != nil {
+		return err
+	}
+	d.Title = strings.TrimSpace(title)
+	return nil
+}
+
+// ShareAccesses implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) ShareAccesses(dtype flow.DocTypeID, id flow.DocumentID, offset, limit int64) ([]*flow.ShareAccess, error) {
+	return nil, ErrUnsupported
+}
+
+// ShareTokens implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) ShareTokens(dtype flow.DocTypeID, id flow.DocumentID) ([]*flow.ShareToken, error) {
+	return nil, ErrUnsupported
+}
+
+// Tags implements `flow.DocumentsAPI`.
+func (ds *Documents) Tags(dtype flow.DocTypeID, id flow.DocumentID) ([]string, error) {
+	ds.e.mu.Lock()
+	defer ds.e.mu.Unlock()
+
+	d, err := ds.e.doc(dtype, id)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{}, d.Tags...), nil
+}
+
+// Vote implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) Vote(otx *sql.Tx, input *flow.DocumentsVoteInput) (*flow.VoteTally, error) {
+	return nil, ErrUnsupported
+}
+
+// Votes implements `flow.DocumentsAPI`; it is not supported.
+func (*Documents) Votes(dtype flow.DocTypeID, did flow.DocumentID) (*flow.VoteTally, error) {
+	return nil, ErrUnsupported
+}
+
+// DocEvents is an in-memory fake of `flow.DocEvents`.  Events raised
+// using `New` remain pending until they are applied by
+// `Workflows.Act`, or by `Engine.Apply`.
+type DocEvents struct {
+	e *Engine
+}
+
+var _ flow.DocEventsAPI = (*DocEvents)(nil)
+
+// DocEvents answers the fake document events of this engine.
+func (e *Engine) DocEvents() *DocEvents {
+	return &DocEvents{e: e}
+}
+
+// Applications implements `flow.DocEventsAPI`; it is not supported.
+func (*DocEvents) Applications(dtype flow.DocTypeID, did flow.DocumentID) ([]*flow.DocEventApplication, error) {
+	return nil, ErrUnsupported
+}
+
+// ApplicationsByEvent implements `flow.DocEventsAPI`; it is not
+// supported.
+func (*DocEvents) ApplicationsByEvent(eid flow.DocEventID) ([]*flow.DocEventApplication, error) {
+	return nil, ErrUnsupported
+}
+
+// Export implements `flow.DocEventsAPI`; it is not supported.
+func (*DocEvents) Export(w io.Writer, format flow.ExportFormat, input *flow.DocEventsListInput) error {
+	return ErrUnsupported
+}
+
+// ExportAudit implements `flow.DocEventsAPI`; it is not supported.
+func (*DocEvents) ExportAudit(w io.Writer, format flow.ExportFormat, dtype flow.DocTypeID, did flow.DocumentID) error {
+	return ErrUnsupported
+}
+
+// ExportAuditCSV implements `flow.DocEventsAPI`; it is not supported.
+func (*DocEvents) ExportAuditCSV(w io.Writer, dtype flow.DocTypeID, did flow.DocumentID) error {
+	return ErrUnsupported
+}
+
+// ExportCSV implements `flow.DocEventsAPI`; it is not supported.
+func (*DocEvents) ExportCSV(w io.Writer, input *flow.DocEventsListInput) error {
+	return ErrUnsupported
+}
+
+// Get implements `flow.DocEventsAPI`.
+func (des *DocEvents) Get(eid flow.DocEventID) (*flow.DocEvent, error) {
+	des.e.mu.Lock()
+	defer des.e.mu.Unlock()
+
+	ev := des.e.event(eid)
+	if ev == nil {
+		return nil, flow.ErrDocEventNotFound
+	}
+	cp := *ev
+	return &cp, nil
+}
+
+// event answers the given event, or `nil`.  The caller should hold the
+// lock.
+func (e *Engine) event(eid flow.DocEventID) *flow.DocEvent {
+	for _, ev := range e.events {
+		if ev.ID == eid {
+			return ev
+		}
+	}
+	return nil
+}
+
+// Import implements `flow.DocEventsAPI`; it is not supported.
+func (*DocEvents) Import(otx *sql.Tx, input *flow.DocEventsImportInput) (flow.DocEventID, error) {
+	return 0, ErrUnsupported
+}
+
+// List implements `flow.DocEventsAPI`.
+func (des *DocEvents) List(input *flow.DocEventsListInput, offset, limit int64) ([]*flow.DocEvent, error) {
+	des.e.mu.Lock()
+	defer des.e.mu.Unlock()
+
+	ary := []*flow.DocEvent{}
+	for _, ev := range des.e.events {
+		if input.AccessContextID > 0 {
+			d, err := des.e.doc(ev.DocType, ev.DocID)
+			if err != nil || d.AccCtx.ID != input.AccessContextID {
+				continue
+			}
+		}
+		switch {
+		case input.DocTypeID > 0 && ev.DocType != input.DocTypeID,
+			input.GroupID > 0 && ev.Group != input.GroupID,
+			input.DocStateID > 0 && ev.State != input.DocStateID,
+			!input.CtimeStarting.IsZero() && ev.Ctime.Before(input.CtimeStarting),
+			!input.CtimeBefore.IsZero() && !ev.Ctime.Before(input.CtimeBefore),
+			input.Status != flow.EventStatusAll && ev.Status != input.Status:
+			continue
+		}
+		cp := *ev
+		ary = append(ary, &cp)
+	}
+
+	i, j, err := bounds(len(ary), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ary[i:j], nil
+}
+
+// New implements `flow.DocEventsAPI`.  Signatures are not verified.
+func (des *DocEvents) New(otx *sql.Tx, input *flow.DocEventsNewInput) (flow.DocEventID, error) {
+	if err := input.Validate(); err != nil {
+		return 0, err
+	}
+
+	des.e.mu.Lock()
+	defer des.e.mu.Unlock()
+
+	ev, err := des.e.newEvent(input)
+	if err != nil {
+		return 0, err
+	}
+	return ev.ID, nil
+}
+
+// newEvent records a pending event as per the given input.  The caller
+// should hold the lock.
+func (e *Engine) newEvent(input *flow.DocEventsNewInput) (*flow.DocEvent, error) {
+	d, err := e.doc(input.DocTypeID, input.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+	if d.State.ID != input.DocStateID {
+		return nil, flow.ErrDocEventStateMismatch
+	}
+	if e.actionByID(input.DocActionID) == nil {
+		return nil, flow.ErrDocActionNotFound
+	}
+	if e.groupByID(input.GroupID) == nil {
+		return nil, flow.ErrGroupNotFound
+	}
+
+	ev := &flow.DocEvent{
+		ID:      flow.DocEventID(e.next()),
+		DocType: input.DocTypeID,
+		DocID:   input.DocumentID,
+		State:   input.DocStateID,
+		Action:  input.DocActionID,
+		Group:   input.GroupID,
+		Text:    input.Text,
+		Ctime:   time.Now(),
+		Status:  flow.EventStatusPending,
+		Target:  input.Target,
+	}
+	e.events = append(e.events, ev)
+	return ev, nil
+}
+
+// VerifySignature implements `flow.DocEventsAPI`; it is not supported.
+func (*DocEvents) VerifySignature(eid flow.DocEventID, pubkey crypto.PublicKey) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,760 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package flowtest provides an in-memory fake of the workflow engine
+// of `flow`, so that applications can unit-test the logic that drives
+// their workflows without a MySQL instance.
+//
+// The fake covers document types, states, actions and transitions;
+// workflows and their nodes; users, groups, roles and access contexts;
+// documents, together with the application of actions to them,
+// authorised as in `flow`; and the messages posted to the mailboxes of
+// the recipients of events.  Blobs, votes, sharing, exports and the
+// other facilities backed by the database are not covered: those
+// remain the subject of integration tests.
+//
+// An engine is set up using its own methods, as below.  Code under test
+// that is written against the interfaces of `flow` -- `DocumentsAPI`,
+// `DocEventsAPI`, `WorkflowsAPI` and `MailboxesAPI` -- is then given the
+// fakes answered by `Documents`, `DocEvents`, `Workflows` and
+// `Mailboxes`, in place of the accessors of `flow`.  Their methods that
+// the fake does not cover answer `ErrUnsupported`.
+//
+//     e := flowtest.New()
+//     e.Define(flow.NewWorkflowBuilder("PUR:RFQ").
+//         State("Draft").On("Submit").To("Pending").
+//         State("Pending").On("Approve").To("Approved"))
+//     ac := e.AccessContext("Purchase")
+//     alice := e.User("alice@example.com")
+//     e.Grant(ac, e.SingletonGroup(alice), e.Role("Buyer",
+//         flowtest.Permission{DocType: "PUR:RFQ", Action: "Submit"}))
+//
+//     did, _ := e.NewDocument(alice, ac, "PUR:RFQ", "Laptops")
+//     state, err := e.Apply(alice, "PUR:RFQ", did, "Submit", "")
+//
+//     app := &Approvals{Docs: e.Documents(), Flows: e.Workflows()}
+//
+// Identifiers are assigned in order of creation, from a single
+// sequence.  All methods are safe for concurrent use.
+package flowtest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/js-ojus/flow"
+)
+
+// ErrUnsupported is answered by the methods of the fakes that the
+// in-memory engine does not cover.
+var ErrUnsupported = errors.New("flowtest : operation not supported by the in-memory fake")
+
+// Permission is the permission to perform the named action on the
+// documents of the named type.
+type Permission struct {
+	DocType string
+	Action  string
+}
+
+// transition is keyed by the state transitioned out of, and the
+// action.
+type transition struct {
+	from   flow.DocStateID
+	action flow.DocActionID
+}
+
+// doctype holds a document type, its transitions and its documents.
+type doctype struct {
+	flow.DocType
+	trans map[transition]flow.DocStateID
+	wflow *flow.Workflow
+	nodes map[flow.DocStateID]*flow.Node
+	docs  map[flow.DocumentID]*flow.Document
+}
+
+// accessContext holds an access context, and its group roles.
+type accessContext struct {
+	flow.AccessContext
+	roles map[flow.GroupID]map[flow.RoleID]bool
+}
+
+// Engine is an in-memory workflow engine.  Its zero value is not
+// usable; use `New`.
+type Engine struct {
+	mu sync.Mutex
+	id int64
+
+	states   map[string]*flow.DocState
+	actions  map[string]*flow.DocAction
+	doctypes map[string]*doctype
+	users    map[flow.UserID]*flow.User
+	groups   map[string]*flow.Group
+	members  map[flow.GroupID]map[flow.UserID]bool
+	roles    map[string]*flow.Role
+	perms    map[flow.RoleID]map[Permission]bool
+	accCtxs  map[string]*accessContext
+	events   []*flow.DocEvent
+	notes    []*note
+
+	validators map[string]flow.TransitionValidator
+	listeners  map[string]flow.TransitionListener
+	terminals  map[string]flow.TerminalHook
+}
+
+// New answers an empty engine.
+func New() *Engine {
+	return &Engine{
+		states:   map[string]*flow.DocState{},
+		actions:  map[string]*flow.DocAction{},
+		doctypes: map[string]*doctype{},
+		users:    map[flow.UserID]*flow.User{},
+		groups:   map[string]*flow.Group{},
+		members:  map[flow.GroupID]map[flow.UserID]bool{},
+		roles:    map[string]*flow.Role{},
+		perms:    map[flow.RoleID]map[Permission]bool{},
+		accCtxs:  map[string]*accessContext{},
+
+		validators: map[string]flow.TransitionValidator{},
+		listeners:  map[string]flow.TransitionListener{},
+		terminals:  map[string]flow.TerminalHook{},
+	}
+}
+
+// next answers the next identifier.
+func (e *Engine) next() int64 {
+	e.id++
+	return e.id
+}
+
+// Define validates the given workflow definition, and loads it.  It
+// answers the identifier of the workflow.
+func (e *Engine) Define(wb interface {
+	Config() (*flow.Config, error)
+}) (flow.WorkflowID, error) {
+	cfg, err := wb.Config()
+	if err != nil {
+		return 0, err
+	}
+	if len(cfg.Wflows) == 0 {
+		return 0, &flow.CodedError{Code: flow.CodeValidation, Msg: "definition should have a workflow"}
+	}
+	if err = e.Load(cfg); err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.doctypes[strings.TrimSpace(cfg.Wflows[0].DocType)].wflow.ID, nil
+}
+
+// Load loads the given configuration, as `flow.LoadConfig` does.
+// Unlike the latter, definitions that exist already are redefined.
+// Access contexts named by nodes should have been created already.
+func (e *Engine) Load(cfg *flow.Config) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, name := range cfg.States {
+		e.state(name)
+	}
+	for _, ca := range cfg.Actions {
+		e.action(ca.Name).Reconfirm = ca.Reconfirm
+	}
+	for _, cdt := range cfg.DocTypes {
+		name := strings.TrimSpace(cdt.Name)
+		dt, ok := e.doctypes[name]
+		if !ok {
+			dt = &doctype{
+				DocType: flow.DocType{ID: flow.DocTypeID(e.next()), Name: name},
+				docs:    map[flow.DocumentID]*flow.Document{},
+			}
+			e.doctypes[name] = dt
+		}
+		dt.trans = map[transition]flow.DocStateID{}
+		for _, ct := range cdt.Transitions {
+			from := e.state(ct.From).ID
+			action := e.action(ct.Action).ID
+			dt.trans[transition{from, action}] = e.state(ct.To).ID
+		}
+	}
+
+	for _, cw := range cfg.Wflows {
+		dt, ok := e.doctypes[strings.TrimSpace(cw.DocType)]
+		if !ok {
+			return flow.ErrDocTypeNotFound
+		}
+		w := &flow.Workflow{
+			ID:         flow.WorkflowID(e.next()),
+			Name:       strings.TrimSpace(cw.Name),
+			DocType:    dt.DocType,
+			BeginState: *e.state(cw.Begin),
+			Active:     cw.Active == nil || *cw.Active,
+		}
+		nodes := map[flow.DocStateID]*flow.Node{}
+		for _, cn := range cw.Nodes {
+			if !flow.IsValidNodeType(cn.Type) {
+				return flow.ErrWorkflowUnknownNodeType
+			}
+			n := &flow.Node{
+				ID:       flow.NodeID(e.next()),
+				DocType:  dt.ID,
+				State:    e.state(cn.State).ID,
+				Wflow:    w.ID,
+				Name:     strings.TrimSpace(cn.Name),
+				NodeType: flow.NodeType(cn.Type),
+				Template: strings.TrimSpace(cn.Template),
+			}
+			if name := strings.TrimSpace(cn.AccCtx); name != "" {
+				ac, ok := e.accCtxs[name]
+				if !ok {
+					return flow.ErrAccessContextNotFound
+				}
+				n.AccCtx = ac.ID
+			}
+			nodes[n.State] = n
+		}
+		dt.wflow = w
+		dt.nodes = nodes
+	}
+
+	return nil
+}
+
+// state answers the named state, creating it if necessary.
+func (e *Engine) state(name string) *flow.DocState {
+	name = strings.TrimSpace(name)
+	s, ok := e.states[name]
+	if !ok {
+		s = &flow.DocState{ID: flow.DocStateID(e.next()), Name: name}
+		e.states[name] = s
+	}
+	return s
+}
+
+// action answers the named action, creating it if necessary.
+func (e *Engine) action(name string) *flow.DocAction {
+	name = strings.TrimSpace(name)
+	a, ok := e.actions[name]
+	if !ok {
+		a = &flow.DocAction{ID: flow.DocActionID(e.next()), Name: name, Active: true}
+		e.actions[name] = a
+	}
+	return a
+}
+
+// DocType answers the identifier of the named document type.
+func (e *Engine) DocType(name string) (flow.DocTypeID, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dt, ok := e.doctypes[strings.TrimSpace(name)]
+	if !ok {
+		return 0, flow.ErrDocTypeNotFound
+	}
+	return dt.ID, nil
+}
+
+// Action answers the identifier of the named action.
+func (e *Engine) Action(name string) (flow.DocActionID, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	a, ok := e.actions[strings.TrimSpace(name)]
+	if !ok {
+		return 0, flow.ErrDocActionNotFound
+	}
+	return a.ID, nil
+}
+
+// Workflow answers the workflow of the named document type.
+func (e *Engine) Workflow(dtype string) (*flow.Workflow, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dt, ok := e.doctypes[strings.TrimSpace(dtype)]
+	if !ok || dt.wflow == nil {
+		return nil, flow.ErrWorkflowNotFound
+	}
+	w := *dt.wflow
+	return &w, nil
+}
+
+// SetActive enables or disables the workflow of the named document
+// type.
+func (e *Engine) SetActive(dtype string, active bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dt, ok := e.doctypes[strings.TrimSpace(dtype)]
+	if !ok || dt.wflow == nil {
+		return flow.ErrWorkflowNotFound
+	}
+	dt.wflow.Active = active
+	return nil
+}
+
+// User creates a user having the given e-mail address, together with
+// the user's singleton group, unless the user exists already.  It
+// answers the identifier of the user.
+func (e *Engine) User(email string) flow.UserID {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	email = strings.TrimSpace(email)
+	if g, ok := e.groups[email]; ok && g.GroupType == flow.GroupSingleton {
+		for uid := range e.members[g.ID] {
+			return uid
+		}
+	}
+
+	u := &flow.User{ID: flow.UserID(e.next()), Email: email, Active: true}
+	e.users[u.ID] = u
+	g := &flow.Group{ID: flow.GroupID(e.next()), Name: email, GroupType: flow.GroupSingleton}
+	e.groups[email] = g
+	e.members[g.ID] = map[flow.UserID]bool{u.ID: true}
+	return u.ID
+}
+
+// SingletonGroup answers the singleton group of the given user, or `0`
+// if the user is unknown.
+func (e *Engine) SingletonGroup(uid flow.UserID) flow.GroupID {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.singleton(uid)
+}
+
+func (e *Engine) singleton(uid flow.UserID) flow.GroupID {
+	u, ok := e.users[uid]
+	if !ok {
+		return 0
+	}
+	return e.groups[u.Email].ID
+}
+
+// Group creates the named general group, unless it exists already,
+// and adds the given users to it.  It answers the identifier of the
+// group.
+func (e *Engine) Group(name string, users ...flow.UserID) flow.GroupID {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	name = strings.TrimSpace(name)
+	g, ok := e.groups[name]
+	if !ok {
+		g = &flow.Group{ID: flow.GroupID(e.next()), Name: name, GroupType: flow.GroupGeneral}
+		e.groups[name] = g
+		e.members[g.ID] = map[flow.UserID]bool{}
+	}
+	for _, uid := range users {
+		e.members[g.ID][uid] = true
+	}
+	return g.ID
+}
+
+// Role creates the named role, unless it exists already, and adds the
+// given permissions to it.  It answers the identifier of the role.
+func (e *Engine) Role(name string, perms ...Permission) flow.RoleID {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	name = strings.TrimSpace(name)
+	r, ok := e.roles[name]
+	if !ok {
+		r = &flow.Role{ID: flow.RoleID(e.next()), Name: name}
+		e.roles[name] = r
+		e.perms[r.ID] = map[Permission]bool{}
+	}
+	for _, p := range perms {
+		p.DocType = strings.TrimSpace(p.DocType)
+		p.Action = strings.TrimSpace(p.Action)
+		e.perms[r.ID][p] = true
+	}
+	return r.ID
+}
+
+// AccessContext creates the named, active access context, unless it
+// exists already.  It answers the identifier of the access context.
+func (e *Engine) AccessContext(name string) flow.AccessContextID {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	name = strings.TrimSpace(name)
+	ac, ok := e.accCtxs[name]
+	if !ok {
+		ac = &accessContext{
+			AccessContext: flow.AccessContext{ID: flow.AccessContextID(e.next()), Name: name, Active: true},
+			roles:         map[flow.GroupID]map[flow.RoleID]bool{},
+		}
+		e.accCtxs[name] = ac
+	}
+	return ac.ID
+}
+
+// accessContext answers the given access context, or `nil`.
+func (e *Engine) accessContext(id flow.AccessContextID) *accessContext {
+	for _, ac := range e.accCtxs {
+		if ac.ID == id {
+			return ac
+		}
+	}
+	return nil
+}
+
+// doctypeByID answers the given document type, or `nil`.
+func (e *Engine) doctypeByID(id flow.DocTypeID) *doctype {
+	for _, dt := range e.doctypes {
+		if dt.ID == id {
+			return dt
+		}
+	}
+	return nil
+}
+
+// stateByID answers the given state, or `nil`.
+func (e *Engine) stateByID(id flow.DocStateID) *flow.DocState {
+	for _, s := range e.states {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// actionByID answers the given action, or `nil`.
+func (e *Engine) actionByID(id flow.DocActionID) *flow.DocAction {
+	for _, a := range e.actions {
+		if a.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// groupByID answers the given group, or `nil`.
+func (e *Engine) groupByID(id flow.GroupID) *flow.Group {
+	for _, g := range e.groups {
+		if g.ID == id {
+			return g
+		}
+	}
+	return nil
+}
+
+// singletonUser answers the user of the given singleton group, or `0`.
+func (e *Engine) singletonUser(gid flow.GroupID) flow.UserID {
+	g := e.groupByID(gid)
+	if g == nil || g.GroupType != flow.GroupSingleton {
+		return 0
+	}
+	for uid := range e.members[gid] {
+		return uid
+	}
+	return 0
+}
+
+// Grant assigns the given role to the given group, in the given access
+// context.
+func (e *Engine) Grant(acID flow.AccessContextID, gid flow.GroupID, rid flow.RoleID) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ac := e.accessContext(acID)
+	if ac == nil {
+		return flow.ErrAccessContextNotFound
+	}
+	if _, ok := e.members[gid]; !ok {
+		return flow.ErrGroupNotFound
+	}
+	if _, ok := e.perms[rid]; !ok {
+		return flow.ErrRoleNotFound
+	}
+	if ac.roles[gid] == nil {
+		ac.roles[gid] = map[flow.RoleID]bool{}
+	}
+	ac.roles[gid][rid] = true
+	return nil
+}
+
+// allowed answers if the given user may perform the given action on
+// documents of the given type, in the given access context.
+func (e *Engine) allowed(uid flow.UserID, ac *accessContext, p Permission) bool {
+	for gid, rids := range ac.roles {
+		if !e.members[gid][uid] {
+			continue
+		}
+		for rid := range rids {
+			if e.perms[rid][p] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewDocument creates a document of the named type, on behalf of the
+// given user, in the given access context.  The document begins in
+// the begin state of the workflow of its type.
+func (e *Engine) NewDocument(uid flow.UserID, acID flow.AccessContextID, dtype, title string) (flow.DocumentID, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dt, ok := e.doctypes[strings.TrimSpace(dtype)]
+	if !ok {
+		return 0, flow.ErrDocTypeNotFound
+	}
+	if dt.wflow == nil {
+		return 0, flow.ErrWorkflowNotFound
+	}
+	ac := e.accessContext(acID)
+	if ac == nil {
+		return 0, flow.ErrAccessContextNotFound
+	}
+	gid := e.singleton(uid)
+	if gid == 0 {
+		return 0, flow.ErrUserNotFound
+	}
+	var g flow.Group
+	for _, elem := range e.groups {
+		if elem.ID == gid {
+			g = *elem
+		}
+	}
+
+	d := &flow.Document{
+		ID:      flow.DocumentID(e.next()),
+		DocType: dt.DocType,
+		Path:    "/",
+		AccCtx:  ac.AccessContext,
+		State:   dt.wflow.BeginState,
+		Group:   g,
+		Ctime:   time.Now(),
+		Title:   strings.TrimSpace(title),
+	}
+	dt.docs[d.ID] = d
+	return d.ID, nil
+}
+
+// Document answers a copy of the given document.
+func (e *Engine) Document(dtype string, did flow.DocumentID) (*flow.Document, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	d, err := e.document(dtype, did)
+	if err != nil {
+		return nil, err
+	}
+	cp := *d
+	return &cp, nil
+}
+
+func (e *Engine) document(dtype string, did flow.DocumentID) (*flow.Document, error) {
+	dt, ok := e.doctypes[strings.TrimSpace(dtype)]
+	if !ok {
+		return nil, flow.ErrDocTypeNotFound
+	}
+	d, ok := dt.docs[did]
+	if !ok {
+		return nil, flow.ErrDocumentNotFound
+	}
+	return d, nil
+}
+
+// Apply performs the named action on the given document, on behalf of
+// the given user, and answers the name of the resulting state.
+//
+// As in `flow`, the workflow should be active, the document's current
+// state should have a node, the user should hold the permission for
+// the action in the document's access context, and the action should
+// lead out of the current state.  Otherwise, respectively,
+// `flow.ErrWorkflowInactive`, `flow.ErrNodeNotFound`,
+// `flow.ErrPermissionDenied` and `flow.ErrWorkflowInvalidAction` are
+// answered.
+func (e *Engine) Apply(uid flow.UserID, dtype string, did flow.DocumentID, action, text string) (string, error) {
+	e.mu.Lock()
+	d, err := e.document(dtype, did)
+	if err != nil {
+		e.mu.Unlock()
+		return "", err
+	}
+	a, ok := e.actions[strings.TrimSpace(action)]
+	if !ok {
+		e.mu.Unlock()
+		return "", flow.ErrDocActionNotFound
+	}
+	ev := &flow.DocEvent{
+		DocType: d.DocType.ID,
+		DocID:   d.ID,
+		State:   d.State.ID,
+		Action:  a.ID,
+		Group:   e.singleton(uid),
+		Text:    text,
+		Ctime:   time.Now(),
+		Status:  flow.EventStatusPending,
+	}
+	done, err := e.apply(ev, nil)
+	state := d.State.Name
+	e.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	done()
+	return state, nil
+}
+
+// apply applies the given event, recording it if it is new, and posts
+// a message about it to the mailboxes of the given groups.  The
+// validators registered are run.  The function answered runs the
+// listeners and hooks of the transition; it should be called after
+// unlocking the engine.
+//
+// As in `flow`, the workflow should be active, the document's current
+// state should have a node, the user of the event's group should hold
+// the permission for the action in the document's access context, and
+// the action should lead out of the current state.
+func (e *Engine) apply(ev *flow.DocEvent, recipients []flow.GroupID) (func(), error) {
+	dt := e.doctypeByID(ev.DocType)
+	if dt == nil {
+		return nil, flow.ErrDocTypeNotFound
+	}
+	d, ok := dt.docs[ev.DocID]
+	if !ok {
+		return nil, flow.ErrDocumentNotFound
+	}
+	if dt.wflow == nil {
+		return nil, flow.ErrWorkflowNotFound
+	}
+	if !dt.wflow.Active {
+		return nil, flow.ErrWorkflowInactive
+	}
+	if d.State.ID != ev.State {
+		return nil, flow.ErrDocEventStateMismatch
+	}
+	if _, ok = dt.nodes[d.State.ID]; !ok {
+		return nil, flow.ErrNodeNotFound
+	}
+	a := e.actionByID(ev.Action)
+	if a == nil {
+		return nil, flow.ErrDocActionNotFound
+	}
+	uid := e.singletonUser(ev.Group)
+	ac := e.accessContext(d.AccCtx.ID)
+	if uid == 0 || !e.allowed(uid, ac, Permission{DocType: dt.Name, Action: a.Name}) {
+		return nil, flow.ErrPermissionDenied
+	}
+	to, ok := dt.trans[transition{d.State.ID, a.ID}]
+	if !ok {
+		return nil, flow.ErrWorkflowInvalidAction
+	}
+	names := make([]string, 0, len(e.validators))
+	for name := range e.validators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cp := *d
+		if err := e.validators[name](nil, &cp, ev, to); err != nil {
+			return nil, err
+		}
+	}
+
+	if ev.ID == 0 {
+		ev.ID = flow.DocEventID(e.next())
+		e.events = append(e.events, ev)
+	}
+	ev.Status = flow.EventStatusApplied
+	d.State = *e.stateByID(to)
+	e.post(d, ev, recipients)
+
+	// Listeners and hooks receive copies, and may call the fakes.
+	evc := *ev
+	var doc *flow.Document
+	if n, ok := dt.nodes[to]; ok && n.NodeType == flow.NodeTypeEnd {
+		cp := *d
+		doc = &cp
+	}
+	listeners := make([]flow.TransitionListener, 0, len(e.listeners))
+	for _, fn := range e.listeners {
+		listeners = append(listeners, fn)
+	}
+	hooks := make([]flow.TerminalHook, 0, len(e.terminals))
+	for _, fn := range e.terminals {
+		hooks = append(hooks, fn)
+	}
+	return func() {
+		ctx := context.Background()
+		for _, fn := range listeners {
+			fn(ctx, &evc, to)
+		}
+		if doc == nil {
+			return
+		}
+		for _, fn := range hooks {
+			fn(ctx, doc, evc.ID)
+		}
+	}, nil
+}
+
+// Actions answers the names of the actions that the given user may
+// perform on the given document in its current state, in
+// alphabetical order.
+func (e *Engine) Actions(uid flow.UserID, dtype string, did flow.DocumentID) ([]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	d, err := e.document(dtype, did)
+	if err != nil {
+		return nil, err
+	}
+	dt := e.doctypes[strings.TrimSpace(dtype)]
+	ac := e.accessContext(d.AccCtx.ID)
+
+	names := []string{}
+	for _, a := range e.actions {
+		if _, ok := dt.trans[transition{d.State.ID, a.ID}]; !ok {
+			continue
+		}
+		if e.allowed(uid, ac, Permission{DocType: dt.Name, Action: a.Name}) {
+			names = append(names, a.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Events answers the events applied to the given document, in the
+// order of their application.
+func (e *Engine) Events(dtype string, did flow.DocumentID) ([]*flow.DocEvent, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	d, err := e.document(dtype, did)
+	if err != nil {
+		return nil, err
+	}
+
+	ary := []*flow.DocEvent{}
+	for _, ev := range e.events {
+		if ev.DocType == d.DocType.ID && ev.DocID == d.ID && ev.Status == flow.EventStatusApplied {
+			cp := *ev
+			ary = append(ary, &cp)
+		}
+	}
+	return ary, nil
+}
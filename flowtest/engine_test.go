@@ -0,0 +1,176 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowtest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/js-ojus/flow"
+)
+
+func TestEngine(t *testing.T) {
+	e := New()
+	_, err := e.Define(flow.NewWorkflowBuilder("PUR:RFQ").
+		State("Draft").On("Submit").To("Pending").
+		State("Pending").On("Approve").To("Approved").
+		On("Reject").To("Draft"))
+	if err != nil {
+		t.Fatalf("could not define workflow : %v", err)
+	}
+
+	ac := e.AccessContext("Purchase")
+	alice := e.User("alice@example.com")
+	bob := e.User("bob@example.com")
+	buyer := e.Role("Buyer", Permission{DocType: "PUR:RFQ", Action: "Submit"})
+	approver := e.Role("Approver",
+		Permission{DocType: "PUR:RFQ", Action: "Approve"},
+		Permission{DocType: "PUR:RFQ", Action: "Reject"})
+	if err = e.Grant(ac, e.SingletonGroup(alice), buyer); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Grant(ac, e.Group("Managers", bob), approver); err != nil {
+		t.Fatal(err)
+	}
+
+	did, err := e.NewDocument(alice, ac, "PUR:RFQ", "Laptops")
+	if err != nil {
+		t.Fatalf("could not create document : %v", err)
+	}
+	if _, err = e.Apply(bob, "PUR:RFQ", did, "Submit", ""); err != flow.ErrPermissionDenied {
+		t.Fatalf("expected permission to be denied; got : %v", err)
+	}
+	if _, err = e.Apply(alice, "PUR:RFQ", did, "Approve", ""); err != flow.ErrPermissionDenied {
+		t.Fatalf("expected permission to be denied; got : %v", err)
+	}
+	state, err := e.Apply(alice, "PUR:RFQ", did, "Submit", "please")
+	if err != nil || state != "Pending" {
+		t.Fatalf("expected Pending; got : %s, %v", state, err)
+	}
+
+	acts, err := e.Actions(bob, "PUR:RFQ", did)
+	if err != nil || !reflect.DeepEqual(acts, []string{"Approve", "Reject"}) {
+		t.Fatalf("unexpected actions : %v, %v", acts, err)
+	}
+	if state, err = e.Apply(bob, "PUR:RFQ", did, "Approve", ""); err != nil || state != "Approved" {
+		t.Fatalf("expected Approved; got : %s, %v", state, err)
+	}
+	if _, err = e.Apply(bob, "PUR:RFQ", did, "Approve", ""); err != flow.ErrNodeNotFound {
+		t.Fatalf("expected no node for Approved; got : %v", err)
+	}
+
+	evs, err := e.Events("PUR:RFQ", did)
+	if err != nil || len(evs) != 2 || evs[0].Text != "please" {
+		t.Fatalf("unexpected events : %v, %v", evs, err)
+	}
+}
+
+func TestEngineFakes(t *testing.T) {
+	e := New()
+	if _, err := e.Define(flow.NewWorkflowBuilder("PUR:RFQ")); err == nil {
+		t.Fatalf("expected an empty definition to be rejected")
+	}
+	wid, err := e.Define(flow.NewWorkflowBuilder("PUR:RFQ").
+		State("Draft").On("Submit").To("Pending").
+		State("Pending").On("Approve").To("Approved"))
+	if err != nil {
+		t.Fatalf("could not define workflow : %v", err)
+	}
+
+	ac := e.AccessContext("Purchase")
+	alice := e.User("alice@example.com")
+	bob := e.User("bob@example.com")
+	managers := e.Group("Managers", bob)
+	buyer := e.Role("Buyer", Permission{DocType: "PUR:RFQ", Action: "Submit"})
+	if err = e.Grant(ac, e.SingletonGroup(alice), buyer); err != nil {
+		t.Fatal(err)
+	}
+	dtype, _ := e.DocType("PUR:RFQ")
+	submit, _ := e.Action("Submit")
+
+	var docs flow.DocumentsAPI = e.Documents()
+	var flows flow.WorkflowsAPI = e.Workflows()
+	var mbs flow.MailboxesAPI = e.Mailboxes()
+
+	ws, err := flows.Search(&flow.WorkflowsListInput{}, 0, 0)
+	if err != nil || len(ws) != 1 || ws[0].ID != wid || ws[0].Covered {
+		t.Fatalf("unexpected workflows : %v, %v", ws, err)
+	}
+
+	did, err := docs.New(nil, &flow.DocumentsNewInput{
+		DocTypeID:       dtype,
+		AccessContextID: ac,
+		GroupID:         e.SingletonGroup(alice),
+		Title:           "Laptops",
+		Data:            "10 units",
+	})
+	if err != nil {
+		t.Fatalf("could not create document : %v", err)
+	}
+	if err = docs.AddTags(nil, dtype, did, "urgent", "it"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := docs.Get(nil, dtype, did)
+	if err != nil || d.Title != "Laptops" || !reflect.DeepEqual(d.Tags, []string{"it", "urgent"}) {
+		t.Fatalf("unexpected document : %v, %v", d, err)
+	}
+	if _, err = docs.Blobs(dtype, did); err != ErrUnsupported {
+		t.Fatalf("expected blobs to be unsupported; got : %v", err)
+	}
+
+	var heard []flow.DocStateID
+	flows.OnTransition("audit", func(ctx context.Context, ev *flow.DocEvent, to flow.DocStateID) error {
+		heard = append(heard, to)
+		return nil
+	})
+	res, err := flows.Act(nil, &flow.DocEventsNewInput{
+		DocTypeID:   dtype,
+		DocumentID:  did,
+		DocStateID:  d.State.ID,
+		DocActionID: submit,
+		GroupID:     e.SingletonGroup(alice),
+		Text:        "please",
+	}, []flow.GroupID{managers})
+	if err != nil || len(res.Messages) != 1 || len(heard) != 1 || heard[0] != res.State {
+		t.Fatalf("unexpected outcome : %v, %v", res, err)
+	}
+	if _, err = flows.Act(nil, &flow.DocEventsNewInput{
+		DocTypeID:   dtype,
+		DocumentID:  did,
+		DocStateID:  d.State.ID,
+		DocActionID: submit,
+		GroupID:     e.SingletonGroup(alice),
+		Text:        "again",
+	}, nil); err != flow.ErrDocEventStateMismatch {
+		t.Fatalf("expected state mismatch; got : %v", err)
+	}
+
+	n, err := mbs.CountForUserAllGroups(bob, true)
+	if err != nil || n != 1 {
+		t.Fatalf("expected one unread message; got : %d, %v", n, err)
+	}
+	if err = mbs.SetReadByUser(nil, managers, bob, res.Messages[0], true); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = mbs.CountForUserAllGroups(bob, true); err != nil || n != 0 {
+		t.Fatalf("expected no unread messages; got : %d, %v", n, err)
+	}
+
+	evs, err := e.DocEvents().List(&flow.DocEventsListInput{DocTypeID: dtype, Status: flow.EventStatusApplied}, 0, 0)
+	if err != nil || len(evs) != 1 || evs[0].ID != res.Event {
+		t.Fatalf("unexpected events : %v, %v", evs, err)
+	}
+}
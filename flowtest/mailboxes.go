@@ -0,0 +1,468 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowtest
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/js-ojus/flow"
+)
+
+// note is a notification in a group's mailbox, together with the
+// members of the group who have read it.
+type note struct {
+	flow.Notification
+	snoozed time.Time
+	reads   map[flow.UserID]time.Time
+}
+
+// post delivers a message about the given event on the given document
+// to the mailboxes of the given groups.  The caller should hold the
+// lock.
+func (e *Engine) post(d *flow.Document, ev *flow.DocEvent, recipients []flow.GroupID) {
+	if len(recipients) == 0 {
+		return
+	}
+
+	msg := flow.Message{
+		ID:      flow.MessageID(e.next()),
+		DocType: d.DocType,
+		DocID:   d.ID,
+		Event:   ev.ID,
+		Title:   d.Title,
+		Data:    ev.Text,
+	}
+	msg.Thread = msg.ID
+	for _, n := range e.notes {
+		if n.Message.DocType.ID == d.DocType.ID && n.Message.DocID == d.ID {
+			msg.Thread = n.Message.Thread
+			break
+		}
+	}
+	for _, gid := range recipients {
+		e.notes = append(e.notes, &note{
+			Notification: flow.Notification{GroupID: gid, Message: msg, Unread: true, Ctime: ev.Ctime},
+			reads:        map[flow.UserID]time.Time{},
+		})
+	}
+}
+
+// Mailboxes is an in-memory fake of `flow.Mailboxes`.  Messages are
+// posted to the mailboxes of the recipients given to
+// `Workflows.Act`.  As in `flow`, members of a group read its messages
+// independently of one another (see `SetReadByUser`).
+type Mailboxes struct {
+	e *Engine
+}
+
+var _ flow.MailboxesAPI = (*Mailboxes)(nil)
+
+// Mailboxes answers the fake mailboxes of this engine.
+func (e *Engine) Mailboxes() *Mailboxes {
+	return &Mailboxes{e: e}
+}
+
+// unread answers if the given notification is unread by the given
+// user; by the group, if no user is given.
+func (m *Mailboxes) unread(n *note, uid flow.UserID) bool {
+	if uid == 0 || m.e.singletonUser(n.GroupID) == uid {
+		return n.Unread
+	}
+	_, ok := n.reads[uid]
+	return !ok
+}
+
+// resurface lists again the messages whose snooze has ended, marking
+// them unread, as the timer pump of `flow` does.
+func (m *Mailboxes) resurface() {
+	now := time.Now()
+	for _, n := range m.e.notes {
+		if !n.snoozed.IsZero() && !n.snoozed.After(now) {
+			n.snoozed = time.Time{}
+			n.Unread = true
+		}
+	}
+}
+
+// list answers copies of the notifications in the given mailboxes that
+// meet the given specification, as seen by the given user, if any.
+func (m *Mailboxes) list(gids map[flow.GroupID]bool, uid flow.UserID, input *flow.MailboxesListInput) []*flow.Notification {
+	if input == nil {
+		input = &flow.MailboxesListInput{}
+	}
+
+	m.resurface()
+	ary := []*flow.Notification{}
+	for _, n := range m.e.notes {
+		if !gids[n.GroupID] {
+			continue
+		}
+		if input.Snoozed == n.snoozed.IsZero() {
+			continue
+		}
+		if !input.CtimeStarting.IsZero() && n.Ctime.Before(input.CtimeStarting) {
+			continue
+		}
+		if !input.CtimeBefore.IsZero() && !n.Ctime.Before(input.CtimeBefore) {
+			continue
+		}
+		unread := m.unread(n, uid)
+		if input.Unread && !unread {
+			continue
+		}
+
+		cp := n.Notification
+		cp.Unread = unread
+		if uid != 0 {
+			cp.GroupName = m.e.groupByID(n.GroupID).Name
+		}
+		ary = append(ary, &cp)
+	}
+	sort.SliceStable(ary, func(i, j int) bool {
+		return ary[i].Message.ID < ary[j].Message.ID
+	})
+	return ary
+}
+
+// groupsOf answers the groups of which the given user is a member.
+func (e *Engine) groupsOf(uid flow.UserID) map[flow.GroupID]bool {
+	gids := map[flow.GroupID]bool{}
+	for gid, uids := range e.members {
+		if uids[uid] {
+			gids[gid] = true
+		}
+	}
+	return gids
+}
+
+// find answers the notification of the given message in the given
+// group's mailbox, or `nil`.
+func (m *Mailboxes) find(gid flow.GroupID, msgID flow.MessageID) *note {
+	for _, n := range m.e.notes {
+		if n.GroupID == gid && n.Message.ID == msgID {
+			return n
+		}
+	}
+	return nil
+}
+
+// CountByGroup implements `flow.MailboxesAPI`.
+func (m *Mailboxes) CountByGroup(gid flow.GroupID, unread bool) (int64, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	m.resurface()
+	var c int64
+	for _, n := range m.e.notes {
+		if n.GroupID == gid && n.snoozed.IsZero() && (!unread || n.Unread) {
+			c++
+		}
+	}
+	return c, nil
+}
+
+// CountByUser implements `flow.MailboxesAPI`.
+func (m *Mailboxes) CountByUser(uid flow.UserID, unread bool) (int64, error) {
+	m.e.mu.Lock()
+	gid := m.e.singleton(uid)
+	m.e.mu.Unlock()
+	if gid == 0 {
+		return 0, flow.ErrUserNotFound
+	}
+	return m.CountByGroup(gid, unread)
+}
+
+// CountForUserAllGroups implements `flow.MailboxesAPI`.
+func (m *Mailboxes) CountForUserAllGroups(uid flow.UserID, unread bool) (int64, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	input := &flow.MailboxesListInput{Unread: unread}
+	return int64(len(m.list(m.e.groupsOf(uid), uid, input))), nil
+}
+
+// CountUnreadByGroupUser implements `flow.MailboxesAPI`.
+func (m *Mailboxes) CountUnreadByGroupUser(gid flow.GroupID, uid flow.UserID) (int64, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	m.resurface()
+	var c int64
+	for _, n := range m.e.notes {
+		if _, ok := n.reads[uid]; n.GroupID == gid && n.snoozed.IsZero() && !ok {
+			c++
+		}
+	}
+	return c, nil
+}
+
+// CountsByUser implements `flow.MailboxesAPI`.
+func (m *Mailboxes) CountsByUser(uid flow.UserID, perDoc bool) ([]*flow.UnreadCount, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	gid := m.e.singleton(uid)
+	if gid == 0 {
+		return nil, flow.ErrUserNotFound
+	}
+	type key struct {
+		dtype flow.DocTypeID
+		did   flow.DocumentID
+	}
+	m.resurface()
+	counts := map[key]*flow.UnreadCount{}
+	ary := []*flow.UnreadCount{}
+	for _, n := range m.e.notes {
+		if n.GroupID != gid || !n.Unread {
+			continue
+		}
+		k := key{dtype: n.Message.DocType.ID}
+		if perDoc {
+			k.did = n.Message.DocID
+		}
+		uc, ok := counts[k]
+		if !ok {
+			uc = &flow.UnreadCount{DocType: n.Message.DocType, DocID: k.did}
+			counts[k] = uc
+			ary = append(ary, uc)
+		}
+		uc.Unread++
+	}
+	sort.Slice(ary, func(i, j int) bool {
+		if ary[i].DocType.ID != ary[j].DocType.ID {
+			return ary[i].DocType.ID < ary[j].DocType.ID
+		}
+		return ary[i].DocID < ary[j].DocID
+	})
+	return ary, nil
+}
+
+// GetMessage implements `flow.MailboxesAPI`.
+func (m *Mailboxes) GetMessage(msgID flow.MessageID) (*flow.Notification, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	for _, n := range m.e.notes {
+		if n.Message.ID == msgID {
+			cp := n.Notification
+			return &cp, nil
+		}
+	}
+	return nil, flow.ErrMessageNotFound
+}
+
+// ListByDocument implements `flow.MailboxesAPI`.
+func (m *Mailboxes) ListByDocument(gid flow.GroupID, dtype flow.DocTypeID, docID flow.DocumentID) (*flow.MessageThread, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	dt := m.e.doctypeByID(dtype)
+	if dt == nil {
+		return nil, flow.ErrDocTypeNotFound
+	}
+	d, ok := dt.docs[docID]
+	if !ok {
+		return nil, flow.ErrDocumentNotFound
+	}
+
+	th := &flow.MessageThread{
+		DocType:       d.DocType,
+		DocID:         d.ID,
+		Title:         d.Title,
+		State:         d.State,
+		Notifications: []*flow.Notification{},
+	}
+	for _, n := range m.e.notes {
+		if n.GroupID == gid && n.Message.DocType.ID == dtype && n.Message.DocID == docID {
+			cp := n.Notification
+			th.Thread = cp.Message.Thread
+			th.Notifications = append(th.Notifications, &cp)
+		}
+	}
+	return th, nil
+}
+
+// ListByGroup implements `flow.MailboxesAPI`.
+func (m *Mailboxes) ListByGroup(gid flow.GroupID, input *flow.MailboxesListInput, offset, limit int64) ([]*flow.Notification, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	ary := m.list(map[flow.GroupID]bool{gid: true}, 0, input)
+	i, j, err := bounds(len(ary), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ary[i:j], nil
+}
+
+// ListByUser implements `flow.MailboxesAPI`.
+func (m *Mailboxes) ListByUser(uid flow.UserID, input *flow.MailboxesListInput, offset, limit int64) ([]*flow.Notification, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	gid := m.e.singleton(uid)
+	if gid == 0 {
+		return nil, flow.ErrUserNotFound
+	}
+	ary := m.list(map[flow.GroupID]bool{gid: true}, 0, input)
+	i, j, err := bounds(len(ary), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ary[i:j], nil
+}
+
+// ListForUserAllGroups implements `flow.MailboxesAPI`.
+func (m *Mailboxes) ListForUserAllGroups(uid flow.UserID, input *flow.MailboxesListInput, offset, limit int64) ([]*flow.Notification, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	ary := m.list(m.e.groupsOf(uid), uid, input)
+	i, j, err := bounds(len(ary), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ary[i:j], nil
+}
+
+// MarkAllRead implements `flow.MailboxesAPI`.
+func (m *Mailboxes) MarkAllRead(otx *sql.Tx, gid flow.GroupID, before time.Time) error {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	for _, n := range m.e.notes {
+		if n.GroupID == gid && (before.IsZero() || n.Ctime.Before(before)) {
+			n.Unread = false
+		}
+	}
+	return nil
+}
+
+// ReadBy implements `flow.MailboxesAPI`.
+func (m *Mailboxes) ReadBy(gid flow.GroupID, msgID flow.MessageID) ([]*flow.MessageRead, error) {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	ary := []*flow.MessageRead{}
+	n := m.find(gid, msgID)
+	if n == nil {
+		return ary, nil
+	}
+	for uid, at := range n.reads {
+		ary = append(ary, &flow.MessageRead{GroupID: gid, User: *m.e.users[uid], Ctime: at})
+	}
+	sort.Slice(ary, func(i, j int) bool {
+		if !ary[i].Ctime.Equal(ary[j].Ctime) {
+			return ary[i].Ctime.Before(ary[j].Ctime)
+		}
+		return ary[i].User.ID < ary[j].User.ID
+	})
+	return ary, nil
+}
+
+// ReassignMessage implements `flow.MailboxesAPI`.
+func (m *Mailboxes) ReassignMessage(otx *sql.Tx, fgid, tgid flow.GroupID, msgID flow.MessageID) error {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	if n := m.find(fgid, msgID); n != nil && fgid != tgid {
+		n.GroupID = tgid
+		n.Unread = true
+		n.reads = map[flow.UserID]time.Time{}
+	}
+	return nil
+}
+
+// SetReadByUser implements `flow.MailboxesAPI`.
+func (m *Mailboxes) SetReadByUser(otx *sql.Tx, gid flow.GroupID, uid flow.UserID, msgID flow.MessageID, read bool) error {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	n := m.find(gid, msgID)
+	if n == nil || !m.e.members[gid][uid] {
+		return nil
+	}
+	if !read {
+		delete(n.reads, uid)
+	} else if _, ok := n.reads[uid]; !ok {
+		n.reads[uid] = time.Now()
+	}
+	return nil
+}
+
+// SetStatusBulk implements `flow.MailboxesAPI`.
+func (m *Mailboxes) SetStatusBulk(otx *sql.Tx, gid flow.GroupID, msgIDs []flow.MessageID, status bool) error {
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	for _, msgID := range msgIDs {
+		if n := m.find(gid, msgID); n != nil {
+			n.Unread = status
+		}
+	}
+	return nil
+}
+
+// SetStatusByGroup implements `flow.MailboxesAPI`.
+func (m *Mailboxes) SetStatusByGroup(otx *sql.Tx, gid flow.GroupID, msgID flow.MessageID, status bool) error {
+	return m.SetStatusBulk(otx, gid, []flow.MessageID{msgID}, status)
+}
+
+// SetStatusByUser implements `flow.MailboxesAPI`.
+func (m *Mailboxes) SetStatusByUser(otx *sql.Tx, uid flow.UserID, msgID flow.MessageID, status bool) error {
+	m.e.mu.Lock()
+	gid := m.e.singleton(uid)
+	m.e.mu.Unlock()
+	if gid == 0 {
+		return flow.ErrUserNotFound
+	}
+	return m.SetStatusBulk(otx, gid, []flow.MessageID{msgID}, status)
+}
+
+// Snooze implements `flow.MailboxesAPI`.  Snoozed messages resurface
+// when they are next listed after the given time.  As in `flow`, only
+// singleton mailboxes can be snoozed.
+func (m *Mailboxes) Snooze(otx *sql.Tx, gid flow.GroupID, msgID flow.MessageID, until time.Time) error {
+	if !until.IsZero() && !until.After(time.Now()) {
+		return &flow.CodedError{Code: flow.CodeValidation, Msg: "snooze time should be in the future"}
+	}
+
+	m.e.mu.Lock()
+	defer m.e.mu.Unlock()
+
+	g := m.e.groupByID(gid)
+	if g == nil {
+		return flow.ErrGroupNotFound
+	}
+	if g.GroupType != flow.GroupSingleton {
+		return &flow.CodedError{Code: flow.CodeValidation, Msg: "only messages in a user's own mailbox can be snoozed"}
+	}
+	n := m.find(gid, msgID)
+	if n == nil {
+		return flow.ErrMessageNotFound
+	}
+	if until.IsZero() {
+		if !n.snoozed.IsZero() {
+			n.snoozed = time.Time{}
+			n.Unread = true
+		}
+		return nil
+	}
+	n.snoozed = until
+	n.Unread = false
+	return nil
+}
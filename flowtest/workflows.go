@@ -0,0 +1,376 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowtest
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/js-ojus/flow"
+)
+
+// Workflows is an in-memory fake of `flow.Workflows`.  Workflows are
+// defined using `Engine.Define` or `Engine.Load`; their definitions
+// cannot be altered through the fake.
+type Workflows struct {
+	e *Engine
+}
+
+var _ flow.WorkflowsAPI = (*Workflows)(nil)
+
+// Workflows answers the fake workflows of this engine.
+func (e *Engine) Workflows() *Workflows {
+	return &Workflows{e: e}
+}
+
+// workflows answers the document types having workflows, in the order
+// of the identifiers of their workflows.  The caller should hold the
+// lock.
+func (e *Engine) workflows() []*doctype {
+	ary := []*doctype{}
+	for _, dt := range e.doctypes {
+		if dt.wflow != nil {
+			ary = append(ary, dt)
+		}
+	}
+	sort.Slice(ary, func(i, j int) bool {
+		return ary[i].wflow.ID < ary[j].wflow.ID
+	})
+	return ary
+}
+
+// workflow answers the document type having the given workflow, or
+// `nil`.  The caller should hold the lock.
+func (e *Engine) workflow(id flow.WorkflowID) *doctype {
+	for _, dt := range e.doctypes {
+		if dt.wflow != nil && dt.wflow.ID == id {
+			return dt
+		}
+	}
+	return nil
+}
+
+// Act implements `flow.WorkflowsAPI`.  The event is raised and applied
+// as by `Engine.Apply`; a message about it is posted to the mailboxes
+// of the given groups.
+func (ws *Workflows) Act(otx *sql.Tx, input *flow.DocEventsNewInput, recipients []flow.GroupID) (*flow.ActResult, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	ws.e.mu.Lock()
+	dt := ws.e.doctypeByID(input.DocTypeID)
+	if dt == nil || dt.wflow == nil {
+		ws.e.mu.Unlock()
+		return nil, flow.ErrWorkflowNotFound
+	}
+	ev, err := ws.e.newEvent(input)
+	if err != nil {
+		ws.e.mu.Unlock()
+		return nil, err
+	}
+	done, err := ws.e.apply(ev, recipients)
+	if err != nil {
+		ws.e.mu.Unlock()
+		return nil, err
+	}
+	res := &flow.ActResult{
+		Event: ev.ID,
+		EventOutcome: flow.EventOutcome{
+			Node:       dt.nodes[input.DocStateID].ID,
+			State:      dt.docs[input.DocumentID].State.ID,
+			Messages:   []flow.MessageID{},
+			Recipients: []flow.GroupID{},
+		},
+	}
+	if len(recipients) > 0 {
+		res.Messages = append(res.Messages, ws.e.notes[len(ws.e.notes)-1].Message.ID)
+		res.Recipients = append(res.Recipients, recipients...)
+	}
+	ws.e.mu.Unlock()
+
+	done()
+	return res, nil
+}
+
+// AdHocSteps implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) AdHocSteps(dtype flow.DocTypeID, did flow.DocumentID) ([]*flow.AdHocStep, error) {
+	return nil, ErrUnsupported
+}
+
+// AddAdHocStep implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) AddAdHocStep(otx *sql.Tx, dtype flow.DocTypeID, did flow.DocumentID, approver flow.GroupID,
+	action flow.DocActionID) (flow.AdHocStepID, error) {
+	return 0, ErrUnsupported
+}
+
+// AddNode implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) AddNode(otx *sql.Tx, dtype flow.DocTypeID, state flow.DocStateID,
+	ac flow.AccessContextID, wid flow.WorkflowID, name string, ntype flow.NodeType) (flow.NodeID, error) {
+	return 0, ErrUnsupported
+}
+
+// Capacity implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) Capacity(id flow.WorkflowID) (*flow.CapacityLimit, error) {
+	return nil, ErrUnsupported
+}
+
+// Compensate implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) Compensate(otx *sql.Tx, dtype flow.DocTypeID, did flow.DocumentID, upto flow.DocStateID) ([]flow.DocEventID, error) {
+	return nil, ErrUnsupported
+}
+
+// Count implements `flow.WorkflowsAPI`.
+func (ws *Workflows) Count(input *flow.WorkflowsListInput) (int64, error) {
+	ary, err := ws.Search(input, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ary)), nil
+}
+
+// Coverage implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) Coverage(wid flow.WorkflowID, acID flow.AccessContextID) ([]*flow.CoverageGap, error) {
+	return nil, ErrUnsupported
+}
+
+// DecideAdHocStep implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) DecideAdHocStep(otx *sql.Tx, id flow.AdHocStepID, uid flow.UserID, approve bool, text string) error {
+	return ErrUnsupported
+}
+
+// Delete implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) Delete(otx *sql.Tx, id flow.WorkflowID) error {
+	return ErrUnsupported
+}
+
+// Diff implements `flow.WorkflowsAPI`, as `flow.Workflows.Diff` does.
+func (*Workflows) Diff(a, b *flow.Bundle) *flow.WorkflowDiff {
+	return flow.Workflows.Diff(a, b)
+}
+
+// DiffAgainstDB implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) DiffAgainstDB(b *flow.Bundle) (*flow.WorkflowDiff, error) {
+	return nil, ErrUnsupported
+}
+
+// Get implements `flow.WorkflowsAPI`.
+func (ws *Workflows) Get(id flow.WorkflowID) (*flow.Workflow, error) {
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	dt := ws.e.workflow(id)
+	if dt == nil {
+		return nil, flow.ErrWorkflowNotFound
+	}
+	w := *dt.wflow
+	return &w, nil
+}
+
+// GetByDocType implements `flow.WorkflowsAPI`.
+func (ws *Workflows) GetByDocType(dtid flow.DocTypeID) (*flow.Workflow, error) {
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	dt := ws.e.doctypeByID(dtid)
+	if dt == nil || dt.wflow == nil {
+		return nil, flow.ErrWorkflowNotFound
+	}
+	w := *dt.wflow
+	return &w, nil
+}
+
+// GetByName implements `flow.WorkflowsAPI`.
+func (ws *Workflows) GetByName(name string) (*flow.Workflow, error) {
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	for _, dt := range ws.e.workflows() {
+		if dt.wflow.Name == name {
+			w := *dt.wflow
+			return &w, nil
+		}
+	}
+	return nil, flow.ErrWorkflowNotFound
+}
+
+// List implements `flow.WorkflowsAPI`.
+func (ws *Workflows) List(offset, limit int64) ([]*flow.Workflow, error) {
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	dts := ws.e.workflows()
+	i, j, err := bounds(len(dts), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	ary := make([]*flow.Workflow, 0, j-i)
+	for _, dt := range dts[i:j] {
+		w := *dt.wflow
+		ary = append(ary, &w)
+	}
+	return ary, nil
+}
+
+// New implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) New(otx *sql.Tx, name string, dtype flow.DocTypeID, state flow.DocStateID) (flow.WorkflowID, error) {
+	return 0, ErrUnsupported
+}
+
+// OnTransition implements `flow.WorkflowsAPI`.  Listeners are invoked
+// synchronously, once the application of the event has been recorded;
+// their errors are ignored.
+func (ws *Workflows) OnTransition(name string, fn flow.TransitionListener) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return &flow.CodedError{Code: flow.CodeValidation, Msg: "listener name should be non-empty"}
+	}
+
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	if fn == nil {
+		delete(ws.e.listeners, name)
+	} else {
+		ws.e.listeners[name] = fn
+	}
+	return nil
+}
+
+// RemoveNode implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) RemoveNode(otx *sql.Tx, wid flow.WorkflowID, nid flow.NodeID) error {
+	return ErrUnsupported
+}
+
+// Rename implements `flow.WorkflowsAPI`.
+func (ws *Workflows) Rename(otx *sql.Tx, id flow.WorkflowID, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return &flow.CodedError{Code: flow.CodeValidation, Msg: "name should not be empty"}
+	}
+
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	dt := ws.e.workflow(id)
+	if dt == nil {
+		return flow.ErrWorkflowNotFound
+	}
+	dt.wflow.Name = name
+	return nil
+}
+
+// Search implements `flow.WorkflowsAPI`.
+func (ws *Workflows) Search(input *flow.WorkflowsListInput, offset, limit int64) ([]*flow.WorkflowSummary, error) {
+	if input == nil {
+		return nil, &flow.CodedError{Code: flow.CodeValidation, Msg: "input should be non-nil"}
+	}
+
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	prefix := strings.TrimSpace(input.NamePrefix)
+	ary := []*flow.WorkflowSummary{}
+	for _, dt := range ws.e.workflows() {
+		switch {
+		case input.DocTypeID > 0 && dt.ID != input.DocTypeID,
+			input.Active != nil && dt.wflow.Active != *input.Active,
+			!strings.HasPrefix(dt.wflow.Name, prefix):
+			continue
+		}
+		ary = append(ary, &flow.WorkflowSummary{
+			Workflow: *dt.wflow,
+			Nodes:    int64(len(dt.nodes)),
+			Covered:  ws.e.covered(dt),
+		})
+	}
+
+	i, j, err := bounds(len(ary), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ary[i:j], nil
+}
+
+// covered answers if every transition out of the nodes of the given
+// document type's workflow, other than its end nodes, can be performed
+// by some user: in the access context of the node, if it has one; in
+// any access context, otherwise.  The caller should hold the lock.
+func (e *Engine) covered(dt *doctype) bool {
+	for t := range dt.trans {
+		n, ok := dt.nodes[t.from]
+		if !ok || n.NodeType == flow.NodeTypeEnd {
+			continue
+		}
+		p := Permission{DocType: dt.Name, Action: e.actionByID(t.action).Name}
+		found := false
+		for _, ac := range e.accCtxs {
+			if n.AccCtx > 0 && ac.ID != n.AccCtx {
+				continue
+			}
+			for uid := range e.users {
+				if e.allowed(uid, ac, p) {
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SetActive implements `flow.WorkflowsAPI`.
+func (ws *Workflows) SetActive(otx *sql.Tx, id flow.WorkflowID, active bool) error {
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	dt := ws.e.workflow(id)
+	if dt == nil {
+		return flow.ErrWorkflowNotFound
+	}
+	dt.wflow.Active = active
+	return nil
+}
+
+// SetCapacity implements `flow.WorkflowsAPI`; it is not supported.
+func (*Workflows) SetCapacity(otx *sql.Tx, id flow.WorkflowID, l *flow.CapacityLimit) error {
+	return ErrUnsupported
+}
+
+// SetValidator implements `flow.WorkflowsAPI`.  Validators are given a
+// `nil` transaction, and a copy of the document.
+func (ws *Workflows) SetValidator(name string, fn flow.TransitionValidator) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return &flow.CodedError{Code: flow.CodeValidation, Msg: "validator name should be non-empty"}
+	}
+
+	ws.e.mu.Lock()
+	defer ws.e.mu.Unlock()
+
+	if fn == nil {
+		delete(ws.e.validators, name)
+	} else {
+		ws.e.validators[name] = fn
+	}
+	return nil
+}
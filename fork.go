@@ -0,0 +1,178 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Branch is one of the parallel paths along which a document, waiting
+// at a node of type `NodeTypeFork`, progresses.
+//
+// Upon arrival at a fork, a branch is spawned in the target state of
+// each of the fork's transitions.  The document itself stays in the
+// state of the fork; events raised in the state of a branch move that
+// branch instead.  A branch arriving at a node of type
+// `NodeTypeJoinAll` waits there for the others; once all have arrived,
+// the document moves into the state of the join.
+type Branch struct {
+	ID      int64      `json:"ID"`       // Unique identifier of this branch
+	DocType DocTypeID  `json:"DocType"`  // Type of the document
+	DocID   DocumentID `json:"DocID"`    // The forked document
+	Fork    NodeID     `json:"Fork"`     // Node that spawned this branch
+	State   DocStateID `json:"DocState"` // Current state of this branch
+	Joined  bool       `json:"Joined"`   // Has this branch arrived at its join?
+	Ctime   time.Time  `json:"Ctime"`    // Time when this branch was spawned
+	Mtime   time.Time  `json:"Mtime"`    // Time when this branch last moved
+}
+
+// spawnBranches spawns the branches of the given fork node, at which
+// the given document has arrived.
+func spawnBranches(otx *sql.Tx, did DocumentID, fork *Node) error {
+	ts, err := fork.Transitions()
+	if err != nil {
+		return err
+	}
+	if len(ts) == 0 {
+		return errorf(CodeValidation, "fork node %s has no transitions", fork.Name)
+	}
+
+	seen := map[DocStateID]bool{}
+	rows := make([][]interface{}, 0, len(ts))
+	for _, state := range ts {
+		if seen[state] {
+			continue
+		}
+		seen[state] = true
+		rows = append(rows, []interface{}{fork.DocType, did, fork.ID, state})
+	}
+	q := `INSERT INTO wf_doc_branches(doctype_id, doc_id, fork_node_id, docstate_id, status, ctime, mtime) VALUES`
+	return insertRows(otx, q, `(?, ?, ?, ?, 'A', NOW(), NOW())`, rows)
+}
+
+// activeBranch answers the active branch of the given document that
+// is in the given state, if any; `nil` otherwise.
+func activeBranch(otx *sql.Tx, dtype DocTypeID, did DocumentID, state DocStateID) (*Branch, error) {
+	q := `
+	SELECT id, doctype_id, doc_id, fork_node_id, docstate_id, ctime, mtime
+	FROM wf_doc_branches
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND docstate_id = ?
+	AND status = 'A'
+	LIMIT 1
+	`
+	row, err := stmts.queryRow(otx, q, dtype, did, state)
+	if err != nil {
+		return nil, err
+	}
+	var elem Branch
+	err = row.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.Fork, &elem.State, &elem.Ctime, &elem.Mtime)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	return &elem, nil
+}
+
+// advance moves this branch to the given node.  It answers `true` if
+// the branch thereby completed the join of all the branches of its
+// fork, so that the document itself should move to the node.
+func (b *Branch) advance(otx *sql.Tx, tnode *Node) (bool, error) {
+	status := "A"
+	if tnode.NodeType == NodeTypeJoinAll {
+		status = "J"
+	}
+	q := `
+	UPDATE wf_doc_branches
+	SET docstate_id = ?, status = ?, mtime = NOW()
+	WHERE id = ?
+	`
+	if _, err := stmts.exec(otx, q, tnode.State, status, b.ID); err != nil {
+		return false, err
+	}
+	if status == "A" {
+		return false, nil
+	}
+
+	q = `
+	SELECT COUNT(*)
+	FROM wf_doc_branches
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND fork_node_id = ?
+	AND status = 'A'
+	`
+	row, err := stmts.queryRow(otx, q, b.DocType, b.DocID, b.Fork)
+	if err != nil {
+		return false, err
+	}
+	var count int64
+	if err = row.Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	q = `
+	UPDATE wf_doc_branches
+	SET status = 'C', mtime = NOW()
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND fork_node_id = ?
+	AND status = 'J'
+	`
+	_, err = stmts.exec(otx, q, b.DocType, b.DocID, b.Fork)
+	return err == nil, err
+}
+
+// Branches answers the branches of the given document that are yet to
+// complete their join, should the document be waiting at a fork.
+func (_Documents) Branches(dtype DocTypeID, did DocumentID) ([]*Branch, error) {
+	if dtype <= 0 || did <= 0 {
+		return nil, newError(CodeValidation, "document type and document ID must be positive integers")
+	}
+
+	q := `
+	SELECT id, doctype_id, doc_id, fork_node_id, docstate_id, status, ctime, mtime
+	FROM wf_doc_branches
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND status IN ('A', 'J')
+	ORDER BY id
+	`
+	ary := make([]*Branch, 0, 2)
+	err := scanEach(readDB().Query, q, []interface{}{dtype, did}, func(scan func(...interface{}) error) error {
+		var elem Branch
+		var status string
+		err := scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.Fork, &elem.State, &status, &elem.Ctime, &elem.Mtime)
+		if err != nil {
+			return err
+		}
+		elem.Joined = status == "J"
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
@@ -16,8 +16,6 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
-	"fmt"
 	"math"
 	"strings"
 )
@@ -25,12 +23,70 @@ import (
 // GroupID is the type of unique group identifiers.
 type GroupID int64
 
+// GroupType enumerates the kinds of groups.
+type GroupType string
+
+const (
+	// GroupSingleton : the group of exactly one user, named by the
+	// user's e-mail address; see `Groups.NewSingleton`
+	GroupSingleton GroupType = "S"
+	// GroupGeneral : a group whose members are managed explicitly
+	GroupGeneral GroupType = "G"
+	// GroupDynamic : a group whose members are those holding a role;
+	// see `Groups.NewDynamic`
+	GroupDynamic GroupType = "R"
+	// GroupDistribution : a group whose members are managed
+	// explicitly, used only to address messages and notifications; it
+	// cannot hold roles, nor be part of a reporting hierarchy
+	GroupDistribution GroupType = "D"
+)
+
+// sql answers this group type as an SQL literal.
+func (t GroupType) sql() string {
+	return "'" + string(t) + "'"
+}
+
+// explicitMembers answers `true` if the members of groups of this type
+// are added and removed explicitly.
+func (t GroupType) explicitMembers() bool {
+	return t == GroupGeneral || t == GroupDistribution
+}
+
+// holdsPermissions answers `true` if groups of this type can hold
+// roles, and be part of reporting hierarchies.
+func (t GroupType) holdsPermissions() bool {
+	return t != GroupDistribution
+}
+
 // Group represents a specified collection of users.  A user belongs
 // to zero or more groups.
 type Group struct {
-	ID        GroupID `json:"ID"`        // Globally-unique ID
-	Name      string  `json:"Name"`      // Globally-unique name
-	GroupType string  `json:"GroupType"` // Is this a user-specific group? Etc.
+	ID        GroupID   `json:"ID"`        // Globally-unique ID
+	Name      string    `json:"Name"`      // Globally-unique name
+	GroupType GroupType `json:"GroupType"` // Is this a user-specific group? Etc.
+}
+
+// groupType answers the type of the given group.
+func groupType(queryRow func(string, ...interface{}) *sql.Row, gid GroupID) (GroupType, error) {
+	var gtype GroupType
+	err := queryRow("SELECT group_type FROM wf_groups_master WHERE id = ?", gid).Scan(&gtype)
+	if err != nil {
+		return "", notFound(err, ErrGroupNotFound)
+	}
+	return gtype, nil
+}
+
+// checkHoldsPermissions answers an error if the given group cannot
+// hold roles.
+func checkHoldsPermissions(tx *sql.Tx, gid GroupID) error {
+	gtype, err := groupType(tx.QueryRow, gid)
+	if err != nil {
+		return err
+	}
+	if !gtype.holdsPermissions() {
+		return ErrGroupNoPermissions
+	}
+	return nil
 }
 
 // Unexported type, only for convenience methods.
@@ -43,95 +99,89 @@ var Groups _Groups
 // user.  The e-mail address of the user is used as the name of the
 // group.  This serves as the linking identifier.
 func (_Groups) NewSingleton(otx *sql.Tx, uid UserID) (GroupID, error) {
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return 0, err
-		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	q := `
-	INSERT INTO wf_groups_master(name, group_type)
-	SELECT u.email, 'S'
-	FROM wf_users_master u
-	WHERE u.id = ?
-	`
-	res, err := tx.Exec(q, uid)
-	if err != nil {
-		return 0, err
-	}
-	var gid int64
-	gid, err = res.LastInsertId()
-	if err != nil {
+	if err := checkAdmin(otx, AdminGroups); err != nil {
 		return 0, err
 	}
 
-	res, err = tx.Exec("INSERT INTO wf_group_users(group_id, user_id) VALUES(?, ?)", gid, uid)
-	if err != nil {
-		return 0, err
-	}
-	_, err = res.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
+	var gid int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_groups_master(name, group_type)
+		SELECT u.email, ` + GroupSingleton.sql() + `
+		FROM wf_users_master u
+		WHERE u.id = ?
+		`
+		res, err := tx.Exec(q, uid)
+		if err != nil {
+			return err
+		}
+		gid, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
 
-	if otx == nil {
-		err = tx.Commit()
+		res, err = tx.Exec("INSERT INTO wf_group_users(group_id, user_id) VALUES(?, ?)", gid, uid)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		_, err = res.LastInsertId()
+		if err != nil {
+			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return GroupID(gid), nil
 }
 
 // New creates a new group that can be populated with users later.
-func (_Groups) New(otx *sql.Tx, name string, gtype string) (GroupID, error) {
-	name = strings.TrimSpace(name)
-	gtype = strings.TrimSpace(gtype)
-	if name == "" || gtype == "" {
-		return 0, errors.New("group name and type must not be empty")
+// Its type should be `GroupGeneral` or `GroupDistribution`; the other
+// types of groups have their own constructors.
+func (_Groups) New(otx *sql.Tx, name string, gtype GroupType) (GroupID, error) {
+	if err := checkAdmin(otx, AdminGroups); err != nil {
+		return 0, err
 	}
+
+	name = strings.TrimSpace(name)
+	var v validator
+	v.name("name", name, maxNameLen)
 	switch gtype {
-	case "G": // General
+	case GroupGeneral, GroupDistribution:
 	// Nothing to do
 
-	default:
-		return 0, errors.New("unknown group type")
-	}
+	case "":
+		v.required("gtype", string(gtype))
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return 0, err
-		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
+	case GroupSingleton, GroupDynamic:
+		v.fail("gtype", "use the constructor specific to the group type")
 
-	res, err := tx.Exec("INSERT INTO wf_groups_master(name, group_type) VALUES(?, ?)", name, gtype)
-	if err != nil {
-		return 0, err
+	default:
+		v.fail("gtype", "unknown group type")
 	}
-	var id int64
-	id, err = res.LastInsertId()
-	if err != nil {
+	v.unique(otx, "name", "wf_groups_master", name, 0)
+	if err := v.result(); err != nil {
 		return 0, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT INTO wf_groups_master(name, group_type) VALUES(?, ?)", name, gtype)
 		if err != nil {
-			return 0, err
+			return err
 		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return GroupID(id), nil
@@ -145,7 +195,7 @@ func (_Groups) New(otx *sql.Tx, name string, gtype string) (GroupID, error) {
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_Groups) List(offset, limit int64) ([]*Group, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -157,7 +207,7 @@ func (_Groups) List(offset, limit int64) ([]*Group, error) {
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := readDB().Query(q, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -182,14 +232,14 @@ func (_Groups) List(offset, limit int64) ([]*Group, error) {
 // Get initialises the group by reading from database.
 func (_Groups) Get(id GroupID) (*Group, error) {
 	if id <= 0 {
-		return nil, errors.New("group ID should be a positive integer")
+		return nil, newError(CodeValidation, "group ID should be a positive integer")
 	}
 
 	var elem Group
-	row := db.QueryRow("SELECT id, name, group_type FROM wf_groups_master WHERE id = ?", id)
+	row := readDB().QueryRow("SELECT id, name, group_type FROM wf_groups_master WHERE id = ?", id)
 	err := row.Scan(&elem.ID, &elem.Name, &elem.GroupType)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrGroupNotFound)
 	}
 
 	return &elem, nil
@@ -197,9 +247,17 @@ func (_Groups) Get(id GroupID) (*Group, error) {
 
 // Rename renames the given group.
 func (_Groups) Rename(otx *sql.Tx, id GroupID, name string) error {
+	if err := checkAdmin(otx, AdminGroups); err != nil {
+		return err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return errors.New("name cannot be empty")
+	var v validator
+	v.positive("id", int64(id))
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_groups_master", name, int64(id))
+	if err := v.result(); err != nil {
+		return err
 	}
 
 	var elem Group
@@ -208,89 +266,77 @@ func (_Groups) Rename(otx *sql.Tx, id GroupID, name string) error {
 	if err != nil {
 		return err
 	}
-	if elem.GroupType == "S" {
-		return errors.New("cannot rename a singleton group")
+	if elem.GroupType == GroupSingleton {
+		return newError(CodeValidation, "cannot rename a singleton group; use Users.SyncEmail")
 	}
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err = db.Begin()
+	err = withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE wf_groups_master SET name = ? WHERE id = ?", name, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	_, err = tx.Exec("UPDATE wf_groups_master SET name = ? WHERE id = ?", name, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
 // Delete deletes the given group from the system, if no access
 // context is actively using it.
 func (_Groups) Delete(otx *sql.Tx, id GroupID) error {
+	if err := checkAdmin(otx, AdminGroups); err != nil {
+		return err
+	}
+
 	if id <= 0 {
-		return errors.New("group ID must be a positive integer")
+		return newError(CodeValidation, "group ID must be a positive integer")
 	}
 
-	row := db.QueryRow("SELECT group_type FROM wf_groups_master WHERE id = ?", id)
-	var gtype string
-	err := row.Scan(&gtype)
+	gtype, err := groupType(db.QueryRow, id)
 	if err != nil {
 		return err
 	}
-	if gtype == "S" {
-		return errors.New("singleton groups cannot be deleted")
+	if gtype == GroupSingleton {
+		return newError(CodeConflict, "singleton groups cannot be deleted")
 	}
 
-	row = db.QueryRow("SELECT COUNT(*) FROM wf_ac_group_roles WHERE group_id = ?", id)
+	row := db.QueryRow("SELECT COUNT(*) FROM wf_ac_group_roles WHERE group_id = ?", id)
 	var n int64
 	err = row.Scan(&n)
 	if n > 0 {
-		return errors.New("group is being used in at least one access context; cannot delete")
+		return newError(CodeConflict, "group is being used in at least one access context; cannot delete")
 	}
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err = db.Begin()
+	err = withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_group_users WHERE group_id = ?", id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	_, err = tx.Exec("DELETE FROM wf_group_users WHERE group_id = ?", id)
-	if err != nil {
-		return err
-	}
-	res, err := tx.Exec("DELETE FROM wf_groups_master WHERE id = ?", id)
-	if err != nil {
-		return err
-	}
-	n, err = res.RowsAffected()
-	if n != 1 {
-		return fmt.Errorf("expected number of affected rows : 1; actual affected : %d", n)
-	}
-
-	if otx == nil {
-		err = tx.Commit()
+		_, err = tx.Exec("DELETE FROM wf_admin_group_roles WHERE group_id = ?", id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM wf_dynamic_groups WHERE group_id = ?", id)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("DELETE FROM wf_groups_master WHERE id = ?", id)
 		if err != nil {
 			return err
 		}
+		n, err = res.RowsAffected()
+		if n != 1 {
+			return errorf(CodeNotFound, "expected number of affected rows : 1; actual affected : %d", n)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -304,7 +350,7 @@ func (_Groups) Users(gid GroupID) ([]*User, error) {
 	JOIN wf_group_users gu ON gu.user_id = um.id
 	WHERE gu.group_id = ?
 	`
-	rows, err := db.Query(q, gid)
+	rows, err := readDB().Query(q, gid)
 	if err != nil {
 		return nil, err
 	}
@@ -337,11 +383,11 @@ func (_Groups) HasUser(gid GroupID, uid UserID) (bool, error) {
 	LIMIT 1
 	`
 	var id int64
-	row := db.QueryRow(q, gid, uid)
+	row := readDB().QueryRow(q, gid, uid)
 	err := row.Scan(&id)
 	switch {
 	case err == sql.ErrNoRows:
-		return false, errors.New("given user is not part of the specified group")
+		return false, newError(CodeNotFound, "given user is not part of the specified group")
 
 	case err != nil:
 		return false, err
@@ -360,13 +406,13 @@ func (_Groups) SingletonUser(gid GroupID) (*User, error) {
 	JOIN wf_group_users gus ON gus.user_id = um.id
 	JOIN wf_groups_master gm ON gus.group_id = gm.id
 	WHERE gm.id = ?
-	AND gm.group_type = 'S'
+	AND gm.group_type = ` + GroupSingleton.sql() + `
 	ORDER BY um.id
 	LIMIT 1
 	`
 
 	var elem User
-	row := db.QueryRow(q, gid)
+	row := readDB().QueryRow(q, gid)
 	err := row.Scan(&elem.ID, &elem.FirstName, &elem.LastName, &elem.Email, &elem.Active)
 	switch {
 	case err != nil:
@@ -379,41 +425,32 @@ func (_Groups) SingletonUser(gid GroupID) (*User, error) {
 
 // AddUser adds the given user as a member of this group.
 func (_Groups) AddUser(otx *sql.Tx, gid GroupID, uid UserID) error {
+	if err := checkAdmin(otx, AdminGroups); err != nil {
+		return err
+	}
+
 	if gid <= 0 || uid <= 0 {
-		return errors.New("group ID and user ID must be positive integers")
+		return newError(CodeValidation, "group ID and user ID must be positive integers")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		gtype, err := groupType(tx.QueryRow, gid)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	var gtype string
-	row := tx.QueryRow("SELECT group_type FROM wf_groups_master WHERE id = ?", gid)
-	err = row.Scan(&gtype)
-	if err != nil {
-		return err
-	}
-	if gtype == "S" {
-		return errors.New("cannot add users to singleton groups")
-	}
+		if !gtype.explicitMembers() {
+			return newError(CodeValidation, "cannot add users to singleton or dynamic groups")
+		}
 
-	_, err = tx.Exec("INSERT INTO wf_group_users(group_id, user_id) VALUES(?, ?)", gid, uid)
-	if err != nil {
-		return err
-	}
-	if otx == nil {
-		err = tx.Commit()
+		_, err = tx.Exec("INSERT INTO wf_group_users(group_id, user_id) VALUES(?, ?)", gid, uid)
 		if err != nil {
 			return err
 		}
+
+		return refreshDynamicGroups(tx, 0)
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -422,49 +459,39 @@ func (_Groups) AddUser(otx *sql.Tx, gid GroupID, uid UserID) error {
 // RemoveUser removes the given user from this group, if the user is a
 // member of the group.  This operation is idempotent.
 func (_Groups) RemoveUser(otx *sql.Tx, gid GroupID, uid UserID) error {
+	if err := checkAdmin(otx, AdminGroups); err != nil {
+		return err
+	}
+
 	if gid <= 0 || uid <= 0 {
-		return errors.New("group ID and user ID must be positive integers")
+		return newError(CodeValidation, "group ID and user ID must be positive integers")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		gtype, err := groupType(tx.QueryRow, gid)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	var gtype string
-	row := tx.QueryRow("SELECT group_type FROM wf_groups_master WHERE id = ?", gid)
-	err = row.Scan(&gtype)
-	if err != nil {
-		return err
-	}
-	if gtype == "S" {
-		return errors.New("cannot remove users from singleton groups")
-	}
-
-	res, err := tx.Exec("DELETE FROM wf_group_users WHERE group_id = ? AND user_id = ?", gid, uid)
-	if err != nil {
-		return err
-	}
-	n, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if n != 1 {
-		return fmt.Errorf("expected number of affected rows : 1; actual affected : %d", n)
-	}
+		if !gtype.explicitMembers() {
+			return newError(CodeValidation, "cannot remove users from singleton or dynamic groups")
+		}
 
-	if otx == nil {
-		err = tx.Commit()
+		res, err := tx.Exec("DELETE FROM wf_group_users WHERE group_id = ? AND user_id = ?", gid, uid)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
 		if err != nil {
 			return err
 		}
+		if n != 1 {
+			return errorf(CodeNotFound, "expected number of affected rows : 1; actual affected : %d", n)
+		}
+
+		return refreshDynamicGroups(tx, 0)
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
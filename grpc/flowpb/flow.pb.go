@@ -0,0 +1,1365 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: grpc/flowpb/flow.proto
+
+package flowpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Document struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	DoctypeId       int64                  `protobuf:"varint,2,opt,name=doctype_id,json=doctypeId,proto3" json:"doctype_id,omitempty"`
+	DoctypeName     string                 `protobuf:"bytes,3,opt,name=doctype_name,json=doctypeName,proto3" json:"doctype_name,omitempty"`
+	Path            string                 `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	AccessContextId int64                  `protobuf:"varint,5,opt,name=access_context_id,json=accessContextId,proto3" json:"access_context_id,omitempty"`
+	DocstateId      int64                  `protobuf:"varint,6,opt,name=docstate_id,json=docstateId,proto3" json:"docstate_id,omitempty"`
+	DocstateName    string                 `protobuf:"bytes,7,opt,name=docstate_name,json=docstateName,proto3" json:"docstate_name,omitempty"`
+	GroupId         int64                  `protobuf:"varint,8,opt,name=group_id,json=groupId,proto3" json:"group_id,omitempty"`
+	GroupName       string                 `protobuf:"bytes,9,opt,name=group_name,json=groupName,proto3" json:"group_name,omitempty"`
+	Ctime           *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=ctime,proto3" json:"ctime,omitempty"`
+	Title           string                 `protobuf:"bytes,11,opt,name=title,proto3" json:"title,omitempty"`
+	Data            string                 `protobuf:"bytes,12,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Document) Reset() {
+	*x = Document{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Document) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Document) ProtoMessage() {}
+
+func (x *Document) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Document.ProtoReflect.Descriptor instead.
+func (*Document) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Document) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Document) GetDoctypeId() int64 {
+	if x != nil {
+		return x.DoctypeId
+	}
+	return 0
+}
+
+func (x *Document) GetDoctypeName() string {
+	if x != nil {
+		return x.DoctypeName
+	}
+	return ""
+}
+
+func (x *Document) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Document) GetAccessContextId() int64 {
+	if x != nil {
+		return x.AccessContextId
+	}
+	return 0
+}
+
+func (x *Document) GetDocstateId() int64 {
+	if x != nil {
+		return x.DocstateId
+	}
+	return 0
+}
+
+func (x *Document) GetDocstateName() string {
+	if x != nil {
+		return x.DocstateName
+	}
+	return ""
+}
+
+func (x *Document) GetGroupId() int64 {
+	if x != nil {
+		return x.GroupId
+	}
+	return 0
+}
+
+func (x *Document) GetGroupName() string {
+	if x != nil {
+		return x.GroupName
+	}
+	return ""
+}
+
+func (x *Document) GetCtime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Ctime
+	}
+	return nil
+}
+
+func (x *Document) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Document) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+type CreateDocumentRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	DoctypeId       int64                  `protobuf:"varint,1,opt,name=doctype_id,json=doctypeId,proto3" json:"doctype_id,omitempty"`
+	AccessContextId int64                  `protobuf:"varint,2,opt,name=access_context_id,json=accessContextId,proto3" json:"access_context_id,omitempty"`
+	ParentType      int64                  `protobuf:"varint,3,opt,name=parent_type,json=parentType,proto3" json:"parent_type,omitempty"`
+	ParentId        int64                  `protobuf:"varint,4,opt,name=parent_id,json=parentId,proto3" json:"parent_id,omitempty"`
+	Title           string                 `protobuf:"bytes,5,opt,name=title,proto3" json:"title,omitempty"`
+	Data            string                 `protobuf:"bytes,6,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *CreateDocumentRequest) Reset() {
+	*x = CreateDocumentRequest{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateDocumentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateDocumentRequest) ProtoMessage() {}
+
+func (x *CreateDocumentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateDocumentRequest.ProtoReflect.Descriptor instead.
+func (*CreateDocumentRequest) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CreateDocumentRequest) GetDoctypeId() int64 {
+	if x != nil {
+		return x.DoctypeId
+	}
+	return 0
+}
+
+func (x *CreateDocumentRequest) GetAccessContextId() int64 {
+	if x != nil {
+		return x.AccessContextId
+	}
+	return 0
+}
+
+func (x *CreateDocumentRequest) GetParentType() int64 {
+	if x != nil {
+		return x.ParentType
+	}
+	return 0
+}
+
+func (x *CreateDocumentRequest) GetParentId() int64 {
+	if x != nil {
+		return x.ParentId
+	}
+	return 0
+}
+
+func (x *CreateDocumentRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *CreateDocumentRequest) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+type GetDocumentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DoctypeId     int64                  `protobuf:"varint,1,opt,name=doctype_id,json=doctypeId,proto3" json:"doctype_id,omitempty"`
+	DocumentId    int64                  `protobuf:"varint,2,opt,name=document_id,json=documentId,proto3" json:"document_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDocumentRequest) Reset() {
+	*x = GetDocumentRequest{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDocumentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDocumentRequest) ProtoMessage() {}
+
+func (x *GetDocumentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDocumentRequest.ProtoReflect.Descriptor instead.
+func (*GetDocumentRequest) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetDocumentRequest) GetDoctypeId() int64 {
+	if x != nil {
+		return x.DoctypeId
+	}
+	return 0
+}
+
+func (x *GetDocumentRequest) GetDocumentId() int64 {
+	if x != nil {
+		return x.DocumentId
+	}
+	return 0
+}
+
+type ListDocumentsRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	DoctypeId       int64                  `protobuf:"varint,1,opt,name=doctype_id,json=doctypeId,proto3" json:"doctype_id,omitempty"`
+	AccessContextId int64                  `protobuf:"varint,2,opt,name=access_context_id,json=accessContextId,proto3" json:"access_context_id,omitempty"`
+	DocstateId      int64                  `protobuf:"varint,3,opt,name=docstate_id,json=docstateId,proto3" json:"docstate_id,omitempty"`
+	RootOnly        bool                   `protobuf:"varint,4,opt,name=root_only,json=rootOnly,proto3" json:"root_only,omitempty"`
+	Offset          int64                  `protobuf:"varint,5,opt,name=offset,proto3" json:"offset,omitempty"`
+	Limit           int64                  `protobuf:"varint,6,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ListDocumentsRequest) Reset() {
+	*x = ListDocumentsRequest{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDocumentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDocumentsRequest) ProtoMessage() {}
+
+func (x *ListDocumentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDocumentsRequest.ProtoReflect.Descriptor instead.
+func (*ListDocumentsRequest) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListDocumentsRequest) GetDoctypeId() int64 {
+	if x != nil {
+		return x.DoctypeId
+	}
+	return 0
+}
+
+func (x *ListDocumentsRequest) GetAccessContextId() int64 {
+	if x != nil {
+		return x.AccessContextId
+	}
+	return 0
+}
+
+func (x *ListDocumentsRequest) GetDocstateId() int64 {
+	if x != nil {
+		return x.DocstateId
+	}
+	return 0
+}
+
+func (x *ListDocumentsRequest) GetRootOnly() bool {
+	if x != nil {
+		return x.RootOnly
+	}
+	return false
+}
+
+func (x *ListDocumentsRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ListDocumentsRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListDocumentsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Documents     []*Document            `protobuf:"bytes,1,rep,name=documents,proto3" json:"documents,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDocumentsResponse) Reset() {
+	*x = ListDocumentsResponse{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDocumentsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDocumentsResponse) ProtoMessage() {}
+
+func (x *ListDocumentsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDocumentsResponse.ProtoReflect.Descriptor instead.
+func (*ListDocumentsResponse) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListDocumentsResponse) GetDocuments() []*Document {
+	if x != nil {
+		return x.Documents
+	}
+	return nil
+}
+
+type ApplyEventRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DoctypeId     int64                  `protobuf:"varint,1,opt,name=doctype_id,json=doctypeId,proto3" json:"doctype_id,omitempty"`
+	DocumentId    int64                  `protobuf:"varint,2,opt,name=document_id,json=documentId,proto3" json:"document_id,omitempty"`
+	DocstateId    int64                  `protobuf:"varint,3,opt,name=docstate_id,json=docstateId,proto3" json:"docstate_id,omitempty"`
+	DocactionId   int64                  `protobuf:"varint,4,opt,name=docaction_id,json=docactionId,proto3" json:"docaction_id,omitempty"`
+	Text          string                 `protobuf:"bytes,5,opt,name=text,proto3" json:"text,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ApplyEventRequest) Reset() {
+	*x = ApplyEventRequest{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApplyEventRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApplyEventRequest) ProtoMessage() {}
+
+func (x *ApplyEventRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApplyEventRequest.ProtoReflect.Descriptor instead.
+func (*ApplyEventRequest) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ApplyEventRequest) GetDoctypeId() int64 {
+	if x != nil {
+		return x.DoctypeId
+	}
+	return 0
+}
+
+func (x *ApplyEventRequest) GetDocumentId() int64 {
+	if x != nil {
+		return x.DocumentId
+	}
+	return 0
+}
+
+func (x *ApplyEventRequest) GetDocstateId() int64 {
+	if x != nil {
+		return x.DocstateId
+	}
+	return 0
+}
+
+func (x *ApplyEventRequest) GetDocactionId() int64 {
+	if x != nil {
+		return x.DocactionId
+	}
+	return 0
+}
+
+func (x *ApplyEventRequest) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+type ApplyEventResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EventId       int64                  `protobuf:"varint,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	DocstateId    int64                  `protobuf:"varint,2,opt,name=docstate_id,json=docstateId,proto3" json:"docstate_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ApplyEventResponse) Reset() {
+	*x = ApplyEventResponse{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApplyEventResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApplyEventResponse) ProtoMessage() {}
+
+func (x *ApplyEventResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApplyEventResponse.ProtoReflect.Descriptor instead.
+func (*ApplyEventResponse) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ApplyEventResponse) GetEventId() int64 {
+	if x != nil {
+		return x.EventId
+	}
+	return 0
+}
+
+func (x *ApplyEventResponse) GetDocstateId() int64 {
+	if x != nil {
+		return x.DocstateId
+	}
+	return 0
+}
+
+type Workflow struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name           string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	DoctypeId      int64                  `protobuf:"varint,3,opt,name=doctype_id,json=doctypeId,proto3" json:"doctype_id,omitempty"`
+	DoctypeName    string                 `protobuf:"bytes,4,opt,name=doctype_name,json=doctypeName,proto3" json:"doctype_name,omitempty"`
+	BeginStateId   int64                  `protobuf:"varint,5,opt,name=begin_state_id,json=beginStateId,proto3" json:"begin_state_id,omitempty"`
+	BeginStateName string                 `protobuf:"bytes,6,opt,name=begin_state_name,json=beginStateName,proto3" json:"begin_state_name,omitempty"`
+	Active         bool                   `protobuf:"varint,7,opt,name=active,proto3" json:"active,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Workflow) Reset() {
+	*x = Workflow{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Workflow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Workflow) ProtoMessage() {}
+
+func (x *Workflow) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Workflow.ProtoReflect.Descriptor instead.
+func (*Workflow) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Workflow) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Workflow) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Workflow) GetDoctypeId() int64 {
+	if x != nil {
+		return x.DoctypeId
+	}
+	return 0
+}
+
+func (x *Workflow) GetDoctypeName() string {
+	if x != nil {
+		return x.DoctypeName
+	}
+	return ""
+}
+
+func (x *Workflow) GetBeginStateId() int64 {
+	if x != nil {
+		return x.BeginStateId
+	}
+	return 0
+}
+
+func (x *Workflow) GetBeginStateName() string {
+	if x != nil {
+		return x.BeginStateName
+	}
+	return ""
+}
+
+func (x *Workflow) GetActive() bool {
+	if x != nil {
+		return x.Active
+	}
+	return false
+}
+
+type ListWorkflowsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        int64                  `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	Limit         int64                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWorkflowsRequest) Reset() {
+	*x = ListWorkflowsRequest{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWorkflowsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWorkflowsRequest) ProtoMessage() {}
+
+func (x *ListWorkflowsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWorkflowsRequest.ProtoReflect.Descriptor instead.
+func (*ListWorkflowsRequest) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListWorkflowsRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ListWorkflowsRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListWorkflowsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Workflows     []*Workflow            `protobuf:"bytes,1,rep,name=workflows,proto3" json:"workflows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWorkflowsResponse) Reset() {
+	*x = ListWorkflowsResponse{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWorkflowsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWorkflowsResponse) ProtoMessage() {}
+
+func (x *ListWorkflowsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWorkflowsResponse.ProtoReflect.Descriptor instead.
+func (*ListWorkflowsResponse) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListWorkflowsResponse) GetWorkflows() []*Workflow {
+	if x != nil {
+		return x.Workflows
+	}
+	return nil
+}
+
+type Notification struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	GroupId       int64                  `protobuf:"varint,1,opt,name=group_id,json=groupId,proto3" json:"group_id,omitempty"`
+	GroupName     string                 `protobuf:"bytes,2,opt,name=group_name,json=groupName,proto3" json:"group_name,omitempty"`
+	MessageId     int64                  `protobuf:"varint,3,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	DoctypeId     int64                  `protobuf:"varint,4,opt,name=doctype_id,json=doctypeId,proto3" json:"doctype_id,omitempty"`
+	DocId         int64                  `protobuf:"varint,5,opt,name=doc_id,json=docId,proto3" json:"doc_id,omitempty"`
+	EventId       int64                  `protobuf:"varint,6,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	ThreadId      int64                  `protobuf:"varint,7,opt,name=thread_id,json=threadId,proto3" json:"thread_id,omitempty"`
+	Title         string                 `protobuf:"bytes,8,opt,name=title,proto3" json:"title,omitempty"`
+	Data          string                 `protobuf:"bytes,9,opt,name=data,proto3" json:"data,omitempty"`
+	Unread        bool                   `protobuf:"varint,10,opt,name=unread,proto3" json:"unread,omitempty"`
+	Ctime         *timestamppb.Timestamp `protobuf:"bytes,11,opt,name=ctime,proto3" json:"ctime,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Notification) Reset() {
+	*x = Notification{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Notification) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Notification) ProtoMessage() {}
+
+func (x *Notification) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Notification.ProtoReflect.Descriptor instead.
+func (*Notification) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Notification) GetGroupId() int64 {
+	if x != nil {
+		return x.GroupId
+	}
+	return 0
+}
+
+func (x *Notification) GetGroupName() string {
+	if x != nil {
+		return x.GroupName
+	}
+	return ""
+}
+
+func (x *Notification) GetMessageId() int64 {
+	if x != nil {
+		return x.MessageId
+	}
+	return 0
+}
+
+func (x *Notification) GetDoctypeId() int64 {
+	if x != nil {
+		return x.DoctypeId
+	}
+	return 0
+}
+
+func (x *Notification) GetDocId() int64 {
+	if x != nil {
+		return x.DocId
+	}
+	return 0
+}
+
+func (x *Notification) GetEventId() int64 {
+	if x != nil {
+		return x.EventId
+	}
+	return 0
+}
+
+func (x *Notification) GetThreadId() int64 {
+	if x != nil {
+		return x.ThreadId
+	}
+	return 0
+}
+
+func (x *Notification) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Notification) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+func (x *Notification) GetUnread() bool {
+	if x != nil {
+		return x.Unread
+	}
+	return false
+}
+
+func (x *Notification) GetCtime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Ctime
+	}
+	return nil
+}
+
+type ListMailboxRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Unread        bool                   `protobuf:"varint,1,opt,name=unread,proto3" json:"unread,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Limit         int64                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMailboxRequest) Reset() {
+	*x = ListMailboxRequest{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMailboxRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMailboxRequest) ProtoMessage() {}
+
+func (x *ListMailboxRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMailboxRequest.ProtoReflect.Descriptor instead.
+func (*ListMailboxRequest) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListMailboxRequest) GetUnread() bool {
+	if x != nil {
+		return x.Unread
+	}
+	return false
+}
+
+func (x *ListMailboxRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ListMailboxRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListMailboxResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Notifications []*Notification        `protobuf:"bytes,1,rep,name=notifications,proto3" json:"notifications,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMailboxResponse) Reset() {
+	*x = ListMailboxResponse{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMailboxResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMailboxResponse) ProtoMessage() {}
+
+func (x *ListMailboxResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMailboxResponse.ProtoReflect.Descriptor instead.
+func (*ListMailboxResponse) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ListMailboxResponse) GetNotifications() []*Notification {
+	if x != nil {
+		return x.Notifications
+	}
+	return nil
+}
+
+type CountMailboxRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Unread        bool                   `protobuf:"varint,1,opt,name=unread,proto3" json:"unread,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CountMailboxRequest) Reset() {
+	*x = CountMailboxRequest{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CountMailboxRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CountMailboxRequest) ProtoMessage() {}
+
+func (x *CountMailboxRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CountMailboxRequest.ProtoReflect.Descriptor instead.
+func (*CountMailboxRequest) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *CountMailboxRequest) GetUnread() bool {
+	if x != nil {
+		return x.Unread
+	}
+	return false
+}
+
+type CountMailboxResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Count         int64                  `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CountMailboxResponse) Reset() {
+	*x = CountMailboxResponse{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CountMailboxResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CountMailboxResponse) ProtoMessage() {}
+
+func (x *CountMailboxResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CountMailboxResponse.ProtoReflect.Descriptor instead.
+func (*CountMailboxResponse) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *CountMailboxResponse) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type SetMessageStatusRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	MessageId int64                  `protobuf:"varint,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	// Shared group mailbox holding the message; 0 for the user's own.
+	GroupId       int64 `protobuf:"varint,2,opt,name=group_id,json=groupId,proto3" json:"group_id,omitempty"`
+	Unread        bool  `protobuf:"varint,3,opt,name=unread,proto3" json:"unread,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetMessageStatusRequest) Reset() {
+	*x = SetMessageStatusRequest{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetMessageStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetMessageStatusRequest) ProtoMessage() {}
+
+func (x *SetMessageStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetMessageStatusRequest.ProtoReflect.Descriptor instead.
+func (*SetMessageStatusRequest) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *SetMessageStatusRequest) GetMessageId() int64 {
+	if x != nil {
+		return x.MessageId
+	}
+	return 0
+}
+
+func (x *SetMessageStatusRequest) GetGroupId() int64 {
+	if x != nil {
+		return x.GroupId
+	}
+	return 0
+}
+
+func (x *SetMessageStatusRequest) GetUnread() bool {
+	if x != nil {
+		return x.Unread
+	}
+	return false
+}
+
+type SetMessageStatusResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetMessageStatusResponse) Reset() {
+	*x = SetMessageStatusResponse{}
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetMessageStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetMessageStatusResponse) ProtoMessage() {}
+
+func (x *SetMessageStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_flowpb_flow_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetMessageStatusResponse.ProtoReflect.Descriptor instead.
+func (*SetMessageStatusResponse) Descriptor() ([]byte, []int) {
+	return file_grpc_flowpb_flow_proto_rawDescGZIP(), []int{16}
+}
+
+var File_grpc_flowpb_flow_proto protoreflect.FileDescriptor
+
+const file_grpc_flowpb_flow_proto_rawDesc = "" +
+	"\n" +
+	"\x16grpc/flowpb/flow.proto\x12\aflow.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf8\x02\n" +
+	"\bDocument\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1d\n" +
+	"\n" +
+	"doctype_id\x18\x02 \x01(\x03R\tdoctypeId\x12!\n" +
+	"\fdoctype_name\x18\x03 \x01(\tR\vdoctypeName\x12\x12\n" +
+	"\x04path\x18\x04 \x01(\tR\x04path\x12*\n" +
+	"\x11access_context_id\x18\x05 \x01(\x03R\x0faccessContextId\x12\x1f\n" +
+	"\vdocstate_id\x18\x06 \x01(\x03R\n" +
+	"docstateId\x12#\n" +
+	"\rdocstate_name\x18\a \x01(\tR\fdocstateName\x12\x19\n" +
+	"\bgroup_id\x18\b \x01(\x03R\agroupId\x12\x1d\n" +
+	"\n" +
+	"group_name\x18\t \x01(\tR\tgroupName\x120\n" +
+	"\x05ctime\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\x05ctime\x12\x14\n" +
+	"\x05title\x18\v \x01(\tR\x05title\x12\x12\n" +
+	"\x04data\x18\f \x01(\tR\x04data\"\xca\x01\n" +
+	"\x15CreateDocumentRequest\x12\x1d\n" +
+	"\n" +
+	"doctype_id\x18\x01 \x01(\x03R\tdoctypeId\x12*\n" +
+	"\x11access_context_id\x18\x02 \x01(\x03R\x0faccessContextId\x12\x1f\n" +
+	"\vparent_type\x18\x03 \x01(\x03R\n" +
+	"parentType\x12\x1b\n" +
+	"\tparent_id\x18\x04 \x01(\x03R\bparentId\x12\x14\n" +
+	"\x05title\x18\x05 \x01(\tR\x05title\x12\x12\n" +
+	"\x04data\x18\x06 \x01(\tR\x04data\"T\n" +
+	"\x12GetDocumentRequest\x12\x1d\n" +
+	"\n" +
+	"doctype_id\x18\x01 \x01(\x03R\tdoctypeId\x12\x1f\n" +
+	"\vdocument_id\x18\x02 \x01(\x03R\n" +
+	"documentId\"\xcd\x01\n" +
+	"\x14ListDocumentsRequest\x12\x1d\n" +
+	"\n" +
+	"doctype_id\x18\x01 \x01(\x03R\tdoctypeId\x12*\n" +
+	"\x11access_context_id\x18\x02 \x01(\x03R\x0faccessContextId\x12\x1f\n" +
+	"\vdocstate_id\x18\x03 \x01(\x03R\n" +
+	"docstateId\x12\x1b\n" +
+	"\troot_only\x18\x04 \x01(\bR\brootOnly\x12\x16\n" +
+	"\x06offset\x18\x05 \x01(\x03R\x06offset\x12\x14\n" +
+	"\x05limit\x18\x06 \x01(\x03R\x05limit\"H\n" +
+	"\x15ListDocumentsResponse\x12/\n" +
+	"\tdocuments\x18\x01 \x03(\v2\x11.flow.v1.DocumentR\tdocuments\"\xab\x01\n" +
+	"\x11ApplyEventRequest\x12\x1d\n" +
+	"\n" +
+	"doctype_id\x18\x01 \x01(\x03R\tdoctypeId\x12\x1f\n" +
+	"\vdocument_id\x18\x02 \x01(\x03R\n" +
+	"documentId\x12\x1f\n" +
+	"\vdocstate_id\x18\x03 \x01(\x03R\n" +
+	"docstateId\x12!\n" +
+	"\fdocaction_id\x18\x04 \x01(\x03R\vdocactionId\x12\x12\n" +
+	"\x04text\x18\x05 \x01(\tR\x04text\"P\n" +
+	"\x12ApplyEventResponse\x12\x19\n" +
+	"\bevent_id\x18\x01 \x01(\x03R\aeventId\x12\x1f\n" +
+	"\vdocstate_id\x18\x02 \x01(\x03R\n" +
+	"docstateId\"\xd8\x01\n" +
+	"\bWorkflow\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1d\n" +
+	"\n" +
+	"doctype_id\x18\x03 \x01(\x03R\tdoctypeId\x12!\n" +
+	"\fdoctype_name\x18\x04 \x01(\tR\vdoctypeName\x12$\n" +
+	"\x0ebegin_state_id\x18\x05 \x01(\x03R\fbeginStateId\x12(\n" +
+	"\x10begin_state_name\x18\x06 \x01(\tR\x0ebeginStateName\x12\x16\n" +
+	"\x06active\x18\a \x01(\bR\x06active\"D\n" +
+	"\x14ListWorkflowsRequest\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x03R\x06offset\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x03R\x05limit\"H\n" +
+	"\x15ListWorkflowsResponse\x12/\n" +
+	"\tworkflows\x18\x01 \x03(\v2\x11.flow.v1.WorkflowR\tworkflows\"\xc9\x02\n" +
+	"\fNotification\x12\x19\n" +
+	"\bgroup_id\x18\x01 \x01(\x03R\agroupId\x12\x1d\n" +
+	"\n" +
+	"group_name\x18\x02 \x01(\tR\tgroupName\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x03 \x01(\x03R\tmessageId\x12\x1d\n" +
+	"\n" +
+	"doctype_id\x18\x04 \x01(\x03R\tdoctypeId\x12\x15\n" +
+	"\x06doc_id\x18\x05 \x01(\x03R\x05docId\x12\x19\n" +
+	"\bevent_id\x18\x06 \x01(\x03R\aeventId\x12\x1b\n" +
+	"\tthread_id\x18\a \x01(\x03R\bthreadId\x12\x14\n" +
+	"\x05title\x18\b \x01(\tR\x05title\x12\x12\n" +
+	"\x04data\x18\t \x01(\tR\x04data\x12\x16\n" +
+	"\x06unread\x18\n" +
+	" \x01(\bR\x06unread\x120\n" +
+	"\x05ctime\x18\v \x01(\v2\x1a.google.protobuf.TimestampR\x05ctime\"Z\n" +
+	"\x12ListMailboxRequest\x12\x16\n" +
+	"\x06unread\x18\x01 \x01(\bR\x06unread\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x03R\x05limit\"R\n" +
+	"\x13ListMailboxResponse\x12;\n" +
+	"\rnotifications\x18\x01 \x03(\v2\x15.flow.v1.NotificationR\rnotifications\"-\n" +
+	"\x13CountMailboxRequest\x12\x16\n" +
+	"\x06unread\x18\x01 \x01(\bR\x06unread\",\n" +
+	"\x14CountMailboxResponse\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x03R\x05count\"k\n" +
+	"\x17SetMessageStatusRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\x03R\tmessageId\x12\x19\n" +
+	"\bgroup_id\x18\x02 \x01(\x03R\agroupId\x12\x16\n" +
+	"\x06unread\x18\x03 \x01(\bR\x06unread\"\x1a\n" +
+	"\x18SetMessageStatusResponse2\xe1\x04\n" +
+	"\x04Flow\x12C\n" +
+	"\x0eCreateDocument\x12\x1e.flow.v1.CreateDocumentRequest\x1a\x11.flow.v1.Document\x12=\n" +
+	"\vGetDocument\x12\x1b.flow.v1.GetDocumentRequest\x1a\x11.flow.v1.Document\x12N\n" +
+	"\rListDocuments\x12\x1d.flow.v1.ListDocumentsRequest\x1a\x1e.flow.v1.ListDocumentsResponse\x12E\n" +
+	"\n" +
+	"ApplyEvent\x12\x1a.flow.v1.ApplyEventRequest\x1a\x1b.flow.v1.ApplyEventResponse\x12N\n" +
+	"\rListWorkflows\x12\x1d.flow.v1.ListWorkflowsRequest\x1a\x1e.flow.v1.ListWorkflowsResponse\x12H\n" +
+	"\vListMailbox\x12\x1b.flow.v1.ListMailboxRequest\x1a\x1c.flow.v1.ListMailboxResponse\x12K\n" +
+	"\fCountMailbox\x12\x1c.flow.v1.CountMailboxRequest\x1a\x1d.flow.v1.CountMailboxResponse\x12W\n" +
+	"\x10SetMessageStatus\x12 .flow.v1.SetMessageStatusRequest\x1a!.flow.v1.SetMessageStatusResponseB,Z*github.com/js-ojus/flow/grpc/flowpb;flowpbb\x06proto3"
+
+var (
+	file_grpc_flowpb_flow_proto_rawDescOnce sync.Once
+	file_grpc_flowpb_flow_proto_rawDescData []byte
+)
+
+func file_grpc_flowpb_flow_proto_rawDescGZIP() []byte {
+	file_grpc_flowpb_flow_proto_rawDescOnce.Do(func() {
+		file_grpc_flowpb_flow_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_grpc_flowpb_flow_proto_rawDesc), len(file_grpc_flowpb_flow_proto_rawDesc)))
+	})
+	return file_grpc_flowpb_flow_proto_rawDescData
+}
+
+var file_grpc_flowpb_flow_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_grpc_flowpb_flow_proto_goTypes = []any{
+	(*Document)(nil),                 // 0: flow.v1.Document
+	(*CreateDocumentRequest)(nil),    // 1: flow.v1.CreateDocumentRequest
+	(*GetDocumentRequest)(nil),       // 2: flow.v1.GetDocumentRequest
+	(*ListDocumentsRequest)(nil),     // 3: flow.v1.ListDocumentsRequest
+	(*ListDocumentsResponse)(nil),    // 4: flow.v1.ListDocumentsResponse
+	(*ApplyEventRequest)(nil),        // 5: flow.v1.ApplyEventRequest
+	(*ApplyEventResponse)(nil),       // 6: flow.v1.ApplyEventResponse
+	(*Workflow)(nil),                 // 7: flow.v1.Workflow
+	(*ListWorkflowsRequest)(nil),     // 8: flow.v1.ListWorkflowsRequest
+	(*ListWorkflowsResponse)(nil),    // 9: flow.v1.ListWorkflowsResponse
+	(*Notification)(nil),             // 10: flow.v1.Notification
+	(*ListMailboxRequest)(nil),       // 11: flow.v1.ListMailboxRequest
+	(*ListMailboxResponse)(nil),      // 12: flow.v1.ListMailboxResponse
+	(*CountMailboxRequest)(nil),      // 13: flow.v1.CountMailboxRequest
+	(*CountMailboxResponse)(nil),     // 14: flow.v1.CountMailboxResponse
+	(*SetMessageStatusRequest)(nil),  // 15: flow.v1.SetMessageStatusRequest
+	(*SetMessageStatusResponse)(nil), // 16: flow.v1.SetMessageStatusResponse
+	(*timestamppb.Timestamp)(nil),    // 17: google.protobuf.Timestamp
+}
+var file_grpc_flowpb_flow_proto_depIdxs = []int32{
+	17, // 0: flow.v1.Document.ctime:type_name -> google.protobuf.Timestamp
+	0,  // 1: flow.v1.ListDocumentsResponse.documents:type_name -> flow.v1.Document
+	7,  // 2: flow.v1.ListWorkflowsResponse.workflows:type_name -> flow.v1.Workflow
+	17, // 3: flow.v1.Notification.ctime:type_name -> google.protobuf.Timestamp
+	10, // 4: flow.v1.ListMailboxResponse.notifications:type_name -> flow.v1.Notification
+	1,  // 5: flow.v1.Flow.CreateDocument:input_type -> flow.v1.CreateDocumentRequest
+	2,  // 6: flow.v1.Flow.GetDocument:input_type -> flow.v1.GetDocumentRequest
+	3,  // 7: flow.v1.Flow.ListDocuments:input_type -> flow.v1.ListDocumentsRequest
+	5,  // 8: flow.v1.Flow.ApplyEvent:input_type -> flow.v1.ApplyEventRequest
+	8,  // 9: flow.v1.Flow.ListWorkflows:input_type -> flow.v1.ListWorkflowsRequest
+	11, // 10: flow.v1.Flow.ListMailbox:input_type -> flow.v1.ListMailboxRequest
+	13, // 11: flow.v1.Flow.CountMailbox:input_type -> flow.v1.CountMailboxRequest
+	15, // 12: flow.v1.Flow.SetMessageStatus:input_type -> flow.v1.SetMessageStatusRequest
+	0,  // 13: flow.v1.Flow.CreateDocument:output_type -> flow.v1.Document
+	0,  // 14: flow.v1.Flow.GetDocument:output_type -> flow.v1.Document
+	4,  // 15: flow.v1.Flow.ListDocuments:output_type -> flow.v1.ListDocumentsResponse
+	6,  // 16: flow.v1.Flow.ApplyEvent:output_type -> flow.v1.ApplyEventResponse
+	9,  // 17: flow.v1.Flow.ListWorkflows:output_type -> flow.v1.ListWorkflowsResponse
+	12, // 18: flow.v1.Flow.ListMailbox:output_type -> flow.v1.ListMailboxResponse
+	14, // 19: flow.v1.Flow.CountMailbox:output_type -> flow.v1.CountMailboxResponse
+	16, // 20: flow.v1.Flow.SetMessageStatus:output_type -> flow.v1.SetMessageStatusResponse
+	13, // [13:21] is the sub-list for method output_type
+	5,  // [5:13] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_grpc_flowpb_flow_proto_init() }
+func file_grpc_flowpb_flow_proto_init() {
+	if File_grpc_flowpb_flow_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_grpc_flowpb_flow_proto_rawDesc), len(file_grpc_flowpb_flow_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   17,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_grpc_flowpb_flow_proto_goTypes,
+		DependencyIndexes: file_grpc_flowpb_flow_proto_depIdxs,
+		MessageInfos:      file_grpc_flowpb_flow_proto_msgTypes,
+	}.Build()
+	File_grpc_flowpb_flow_proto = out.File
+	file_grpc_flowpb_flow_proto_goTypes = nil
+	file_grpc_flowpb_flow_proto_depIdxs = nil
+}
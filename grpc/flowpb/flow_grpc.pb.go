@@ -0,0 +1,427 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: grpc/flowpb/flow.proto
+
+package flowpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Flow_CreateDocument_FullMethodName   = "/flow.v1.Flow/CreateDocument"
+	Flow_GetDocument_FullMethodName      = "/flow.v1.Flow/GetDocument"
+	Flow_ListDocuments_FullMethodName    = "/flow.v1.Flow/ListDocuments"
+	Flow_ApplyEvent_FullMethodName       = "/flow.v1.Flow/ApplyEvent"
+	Flow_ListWorkflows_FullMethodName    = "/flow.v1.Flow/ListWorkflows"
+	Flow_ListMailbox_FullMethodName      = "/flow.v1.Flow/ListMailbox"
+	Flow_CountMailbox_FullMethodName     = "/flow.v1.Flow/CountMailbox"
+	Flow_SetMessageStatus_FullMethodName = "/flow.v1.Flow/SetMessageStatus"
+)
+
+// FlowClient is the client API for Flow service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Flow drives document workflows.  All calls act on behalf of the
+// authenticated user of the call.
+type FlowClient interface {
+	// CreateDocument creates a root or child document.
+	CreateDocument(ctx context.Context, in *CreateDocumentRequest, opts ...grpc.CallOption) (*Document, error)
+	// GetDocument answers the primary data of a document.
+	GetDocument(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*Document, error)
+	// ListDocuments lists documents of a type in an access context.
+	ListDocuments(ctx context.Context, in *ListDocumentsRequest, opts ...grpc.CallOption) (*ListDocumentsResponse, error)
+	// ApplyEvent records an action on a document, and applies it using
+	// the workflow of the document's type.
+	ApplyEvent(ctx context.Context, in *ApplyEventRequest, opts ...grpc.CallOption) (*ApplyEventResponse, error)
+	// ListWorkflows lists the defined workflows.
+	ListWorkflows(ctx context.Context, in *ListWorkflowsRequest, opts ...grpc.CallOption) (*ListWorkflowsResponse, error)
+	// ListMailbox lists the notifications in all the mailboxes of the
+	// user.
+	ListMailbox(ctx context.Context, in *ListMailboxRequest, opts ...grpc.CallOption) (*ListMailboxResponse, error)
+	// CountMailbox counts the notifications in the user's own mailbox.
+	CountMailbox(ctx context.Context, in *CountMailboxRequest, opts ...grpc.CallOption) (*CountMailboxResponse, error)
+	// SetMessageStatus marks a message as read or unread for the user.
+	SetMessageStatus(ctx context.Context, in *SetMessageStatusRequest, opts ...grpc.CallOption) (*SetMessageStatusResponse, error)
+}
+
+type flowClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFlowClient(cc grpc.ClientConnInterface) FlowClient {
+	return &flowClient{cc}
+}
+
+func (c *flowClient) CreateDocument(ctx context.Context, in *CreateDocumentRequest, opts ...grpc.CallOption) (*Document, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Document)
+	err := c.cc.Invoke(ctx, Flow_CreateDocument_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowClient) GetDocument(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*Document, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Document)
+	err := c.cc.Invoke(ctx, Flow_GetDocument_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowClient) ListDocuments(ctx context.Context, in *ListDocumentsRequest, opts ...grpc.CallOption) (*ListDocumentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDocumentsResponse)
+	err := c.cc.Invoke(ctx, Flow_ListDocuments_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowClient) ApplyEvent(ctx context.Context, in *ApplyEventRequest, opts ...grpc.CallOption) (*ApplyEventResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ApplyEventResponse)
+	err := c.cc.Invoke(ctx, Flow_ApplyEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowClient) ListWorkflows(ctx context.Context, in *ListWorkflowsRequest, opts ...grpc.CallOption) (*ListWorkflowsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListWorkflowsResponse)
+	err := c.cc.Invoke(ctx, Flow_ListWorkflows_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowClient) ListMailbox(ctx context.Context, in *ListMailboxRequest, opts ...grpc.CallOption) (*ListMailboxResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMailboxResponse)
+	err := c.cc.Invoke(ctx, Flow_ListMailbox_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowClient) CountMailbox(ctx context.Context, in *CountMailboxRequest, opts ...grpc.CallOption) (*CountMailboxResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CountMailboxResponse)
+	err := c.cc.Invoke(ctx, Flow_CountMailbox_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowClient) SetMessageStatus(ctx context.Context, in *SetMessageStatusRequest, opts ...grpc.CallOption) (*SetMessageStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetMessageStatusResponse)
+	err := c.cc.Invoke(ctx, Flow_SetMessageStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FlowServer is the server API for Flow service.
+// All implementations must embed UnimplementedFlowServer
+// for forward compatibility.
+//
+// Flow drives document workflows.  All calls act on behalf of the
+// authenticated user of the call.
+type FlowServer interface {
+	// CreateDocument creates a root or child document.
+	CreateDocument(context.Context, *CreateDocumentRequest) (*Document, error)
+	// GetDocument answers the primary data of a document.
+	GetDocument(context.Context, *GetDocumentRequest) (*Document, error)
+	// ListDocuments lists documents of a type in an access context.
+	ListDocuments(context.Context, *ListDocumentsRequest) (*ListDocumentsResponse, error)
+	// ApplyEvent records an action on a document, and applies it using
+	// the workflow of the document's type.
+	ApplyEvent(context.Context, *ApplyEventRequest) (*ApplyEventResponse, error)
+	// ListWorkflows lists the defined workflows.
+	ListWorkflows(context.Context, *ListWorkflowsRequest) (*ListWorkflowsResponse, error)
+	// ListMailbox lists the notifications in all the mailboxes of the
+	// user.
+	ListMailbox(context.Context, *ListMailboxRequest) (*ListMailboxResponse, error)
+	// CountMailbox counts the notifications in the user's own mailbox.
+	CountMailbox(context.Context, *CountMailboxRequest) (*CountMailboxResponse, error)
+	// SetMessageStatus marks a message as read or unread for the user.
+	SetMessageStatus(context.Context, *SetMessageStatusRequest) (*SetMessageStatusResponse, error)
+	mustEmbedUnimplementedFlowServer()
+}
+
+// UnimplementedFlowServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFlowServer struct{}
+
+func (UnimplementedFlowServer) CreateDocument(context.Context, *CreateDocumentRequest) (*Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateDocument not implemented")
+}
+func (UnimplementedFlowServer) GetDocument(context.Context, *GetDocumentRequest) (*Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDocument not implemented")
+}
+func (UnimplementedFlowServer) ListDocuments(context.Context, *ListDocumentsRequest) (*ListDocumentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDocuments not implemented")
+}
+func (UnimplementedFlowServer) ApplyEvent(context.Context, *ApplyEventRequest) (*ApplyEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApplyEvent not implemented")
+}
+func (UnimplementedFlowServer) ListWorkflows(context.Context, *ListWorkflowsRequest) (*ListWorkflowsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWorkflows not implemented")
+}
+func (UnimplementedFlowServer) ListMailbox(context.Context, *ListMailboxRequest) (*ListMailboxResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMailbox not implemented")
+}
+func (UnimplementedFlowServer) CountMailbox(context.Context, *CountMailboxRequest) (*CountMailboxResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountMailbox not implemented")
+}
+func (UnimplementedFlowServer) SetMessageStatus(context.Context, *SetMessageStatusRequest) (*SetMessageStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMessageStatus not implemented")
+}
+func (UnimplementedFlowServer) mustEmbedUnimplementedFlowServer() {}
+func (UnimplementedFlowServer) testEmbeddedByValue()              {}
+
+// UnsafeFlowServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FlowServer will
+// result in compilation errors.
+type UnsafeFlowServer interface {
+	mustEmbedUnimplementedFlowServer()
+}
+
+func RegisterFlowServer(s grpc.ServiceRegistrar, srv FlowServer) {
+	// If the following call pancis, it indicates UnimplementedFlowServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Flow_ServiceDesc, srv)
+}
+
+func _Flow_CreateDocument_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowServer).CreateDocument(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flow_CreateDocument_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowServer).CreateDocument(ctx, req.(*CreateDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flow_GetDocument_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowServer).GetDocument(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flow_GetDocument_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowServer).GetDocument(ctx, req.(*GetDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flow_ListDocuments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDocumentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowServer).ListDocuments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flow_ListDocuments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowServer).ListDocuments(ctx, req.(*ListDocumentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flow_ApplyEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApplyEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowServer).ApplyEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flow_ApplyEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowServer).ApplyEvent(ctx, req.(*ApplyEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flow_ListWorkflows_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWorkflowsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowServer).ListWorkflows(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flow_ListWorkflows_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowServer).ListWorkflows(ctx, req.(*ListWorkflowsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flow_ListMailbox_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMailboxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowServer).ListMailbox(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flow_ListMailbox_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowServer).ListMailbox(ctx, req.(*ListMailboxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flow_CountMailbox_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountMailboxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowServer).CountMailbox(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flow_CountMailbox_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowServer).CountMailbox(ctx, req.(*CountMailboxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flow_SetMessageStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetMessageStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowServer).SetMessageStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flow_SetMessageStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowServer).SetMessageStatus(ctx, req.(*SetMessageStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Flow_ServiceDesc is the grpc.ServiceDesc for Flow service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Flow_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "flow.v1.Flow",
+	HandlerType: (*FlowServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateDocument",
+			Handler:    _Flow_CreateDocument_Handler,
+		},
+		{
+			MethodName: "GetDocument",
+			Handler:    _Flow_GetDocument_Handler,
+		},
+		{
+			MethodName: "ListDocuments",
+			Handler:    _Flow_ListDocuments_Handler,
+		},
+		{
+			MethodName: "ApplyEvent",
+			Handler:    _Flow_ApplyEvent_Handler,
+		},
+		{
+			MethodName: "ListWorkflows",
+			Handler:    _Flow_ListWorkflows_Handler,
+		},
+		{
+			MethodName: "ListMailbox",
+			Handler:    _Flow_ListMailbox_Handler,
+		},
+		{
+			MethodName: "CountMailbox",
+			Handler:    _Flow_CountMailbox_Handler,
+		},
+		{
+			MethodName: "SetMessageStatus",
+			Handler:    _Flow_SetMessageStatus_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "grpc/flowpb/flow.proto",
+}
@@ -0,0 +1,392 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grpc implements the `flow.v1.Flow` gRPC service, defined in
+// `flowpb/flow.proto`, on top of `flow`.
+//
+// As with `httpapi`, the server does not authenticate users itself.
+// Applications supply an `Authenticator` that maps each call to a
+// `flow` user, usually using the metadata of the call.
+//
+// Register the service with a gRPC server as follows.
+//
+//     srv, err := grpc.NewServer(&grpc.Config{Auth: auth})
+//     ...
+//     flowpb.RegisterFlowServer(gs, srv)
+package grpc
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative grpc/flowpb/flow.proto
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/js-ojus/flow"
+	"github.com/js-ojus/flow/grpc/flowpb"
+)
+
+// Authenticator identifies the user making the call with the given
+// context.  It should answer an error if the call is not
+// authenticated.
+type Authenticator func(ctx context.Context) (flow.UserID, error)
+
+// Config holds the settings of a server.
+type Config struct {
+	// Auth identifies the user of each call; required.
+	Auth Authenticator
+
+	// ViewAction, if non-zero, is the document action that a user
+	// should be permitted in order to read documents of a type in an
+	// access context.  Otherwise, membership of the access context
+	// suffices.
+	ViewAction flow.DocActionID
+
+	// CreateAction, if non-zero, is the document action that a user
+	// should be permitted in order to create documents of a type in
+	// an access context.  Otherwise, membership of the access context
+	// suffices.
+	CreateAction flow.DocActionID
+}
+
+// Server implements `flowpb.FlowServer`.
+type Server struct {
+	flowpb.UnimplementedFlowServer
+	cfg Config
+}
+
+// NewServer creates a server with the given configuration.
+func NewServer(cfg *Config) (*Server, error) {
+	if cfg == nil || cfg.Auth == nil {
+		return nil, errors.New("an authenticator is required")
+	}
+	return &Server{cfg: *cfg}, nil
+}
+
+// user answers the authenticated user of the given call.
+func (s *Server) user(ctx context.Context) (flow.UserID, error) {
+	uid, err := s.cfg.Auth(ctx)
+	if err != nil || uid <= 0 {
+		return 0, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	return uid, nil
+}
+
+// allowed answers `nil` if the registered authorizer permits the
+// given user to perform the given action on documents of the given
+// type in the given access context.  A zero action requires only
+// membership of the access context.
+func allowed(uid flow.UserID, acid flow.AccessContextID, dtype flow.DocTypeID, action flow.DocActionID) error {
+	if err := flow.Authorize(acid, uid, dtype, action); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// toStatus maps the given error answered by `flow` to a gRPC status.
+func toStatus(err error) error {
+	switch flow.CodeOf(err) {
+	case flow.CodeNotFound:
+		return status.Error(codes.NotFound, "not found")
+
+	case flow.CodeConflict:
+		return status.Error(codes.FailedPrecondition, err.Error())
+
+	case flow.CodeValidation:
+		return status.Error(codes.InvalidArgument, err.Error())
+
+	case flow.CodePermissionDenied:
+		return status.Error(codes.PermissionDenied, err.Error())
+
+	case flow.CodeRateLimited:
+		return status.Error(codes.ResourceExhausted, err.Error())
+
+	default:
+		// Errors of the database and its driver are not for the
+		// clients' eyes.
+		flow.Log(flow.LogError, "flow/grpc: internal error", flow.F("error", err))
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// toDocument converts the given document to its wire form.
+func toDocument(d *flow.Document) *flowpb.Document {
+	return &flowpb.Document{
+		Id:              int64(d.ID),
+		DoctypeId:       int64(d.DocType.ID),
+		DoctypeName:     d.DocType.Name,
+		Path:            string(d.Path),
+		AccessContextId: int64(d.AccCtx.ID),
+		DocstateId:      int64(d.State.ID),
+		DocstateName:    d.State.Name,
+		GroupId:         int64(d.Group.ID),
+		GroupName:       d.Group.Name,
+		Ctime:           timestamppb.New(d.Ctime),
+		Title:           d.Title,
+		Data:            d.Data,
+	}
+}
+
+// CreateDocument implements `flowpb.FlowServer`.
+func (s *Server) CreateDocument(ctx context.Context, req *flowpb.CreateDocumentRequest) (*flowpb.Document, error) {
+	uid, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtype := flow.DocTypeID(req.DoctypeId)
+	acid := flow.AccessContextID(req.AccessContextId)
+	if dtype <= 0 || acid <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "document type and access context are required")
+	}
+	if err = allowed(uid, acid, dtype, s.cfg.CreateAction); err != nil {
+		return nil, err
+	}
+
+	g, err := flow.Users.SingletonGroupOf(uid)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	input := &flow.DocumentsNewInput{
+		DocTypeID:       dtype,
+		AccessContextID: acid,
+		GroupID:         g.ID,
+		ParentType:      flow.DocTypeID(req.ParentType),
+		ParentID:        flow.DocumentID(req.ParentId),
+		Title:           req.Title,
+		Data:            req.Data,
+	}
+	did, err := flow.Documents.New(nil, input)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	doc, err := flow.Documents.Get(nil, dtype, did, flow.AsUser(uid))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toDocument(doc), nil
+}
+
+// GetDocument implements `flowpb.FlowServer`.
+func (s *Server) GetDocument(ctx context.Context, req *flowpb.GetDocumentRequest) (*flowpb.Document, error) {
+	uid, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtype := flow.DocTypeID(req.DoctypeId)
+
+	doc, err := flow.Documents.Get(nil, dtype, flow.DocumentID(req.DocumentId), flow.AsUser(uid))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err = allowed(uid, doc.AccCtx.ID, dtype, s.cfg.ViewAction); err != nil {
+		return nil, err
+	}
+	return toDocument(doc), nil
+}
+
+// ListDocuments implements `flowpb.FlowServer`.
+func (s *Server) ListDocuments(ctx context.Context, req *flowpb.ListDocumentsRequest) (*flowpb.ListDocumentsResponse, error) {
+	uid, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtype := flow.DocTypeID(req.DoctypeId)
+	acid := flow.AccessContextID(req.AccessContextId)
+	if dtype <= 0 || acid <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "document type and access context are required")
+	}
+	if err = allowed(uid, acid, dtype, s.cfg.ViewAction); err != nil {
+		return nil, err
+	}
+
+	input := &flow.DocumentsListInput{
+		DocTypeID:       dtype,
+		AccessContextID: acid,
+		DocStateID:      flow.DocStateID(req.DocstateId),
+		RootOnly:        req.RootOnly,
+	}
+	ary, err := flow.Documents.List(input, req.Offset, req.Limit, flow.AsUser(uid))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	res := &flowpb.ListDocumentsResponse{Documents: make([]*flowpb.Document, 0, len(ary))}
+	for _, doc := range ary {
+		res.Documents = append(res.Documents, toDocument(doc))
+	}
+	return res, nil
+}
+
+// ApplyEvent implements `flowpb.FlowServer`.  The user should be
+// permitted the event's action in the document's access context.
+func (s *Server) ApplyEvent(ctx context.Context, req *flowpb.ApplyEventRequest) (*flowpb.ApplyEventResponse, error) {
+	uid, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dtype := flow.DocTypeID(req.DoctypeId)
+	did := flow.DocumentID(req.DocumentId)
+	action := flow.DocActionID(req.DocactionId)
+	if req.DocstateId <= 0 || action <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "document state and action are required")
+	}
+
+	doc, err := flow.Documents.Get(nil, dtype, did)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err = allowed(uid, doc.AccCtx.ID, dtype, action); err != nil {
+		return nil, err
+	}
+
+	wf, err := flow.Workflows.GetByDocType(dtype)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	g, err := flow.Users.SingletonGroupOf(uid)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	input := &flow.DocEventsNewInput{
+		DocTypeID:   dtype,
+		DocumentID:  did,
+		DocStateID:  flow.DocStateID(req.DocstateId),
+		DocActionID: action,
+		GroupID:     g.ID,
+		Text:        req.Text,
+	}
+	eid, err := flow.DocEvents.New(nil, input)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	event, err := flow.DocEvents.Get(eid)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	state, err := wf.ApplyEvent(nil, event, nil)
+	if err != nil && err != flow.ErrDocEventRedundant {
+		return nil, toStatus(err)
+	}
+	return &flowpb.ApplyEventResponse{EventId: int64(eid), DocstateId: int64(state)}, nil
+}
+
+// ListWorkflows implements `flowpb.FlowServer`.  Workflow definitions
+// are readable by all authenticated users.
+func (s *Server) ListWorkflows(ctx context.Context, req *flowpb.ListWorkflowsRequest) (*flowpb.ListWorkflowsResponse, error) {
+	if _, err := s.user(ctx); err != nil {
+		return nil, err
+	}
+
+	ary, err := flow.Workflows.List(req.Offset, req.Limit)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	res := &flowpb.ListWorkflowsResponse{Workflows: make([]*flowpb.Workflow, 0, len(ary))}
+	for _, wf := range ary {
+		res.Workflows = append(res.Workflows, &flowpb.Workflow{
+			Id:             int64(wf.ID),
+			Name:           wf.Name,
+			DoctypeId:      int64(wf.DocType.ID),
+			DoctypeName:    wf.DocType.Name,
+			BeginStateId:   int64(wf.BeginState.ID),
+			BeginStateName: wf.BeginState.Name,
+			Active:         wf.Active,
+		})
+	}
+	return res, nil
+}
+
+// ListMailbox implements `flowpb.FlowServer`.
+func (s *Server) ListMailbox(ctx context.Context, req *flowpb.ListMailboxRequest) (*flowpb.ListMailboxResponse, error) {
+	uid, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &flow.MailboxesListInput{Unread: req.Unread}
+	ary, err := flow.Mailboxes.ListForUserAllGroups(uid, input, req.Offset, req.Limit)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	res := &flowpb.ListMailboxResponse{Notifications: make([]*flowpb.Notification, 0, len(ary))}
+	for _, n := range ary {
+		res.Notifications = append(res.Notifications, &flowpb.Notification{
+			GroupId:   int64(n.GroupID),
+			GroupName: n.GroupName,
+			MessageId: int64(n.Message.ID),
+			DoctypeId: int64(n.Message.DocType.ID),
+			DocId:     int64(n.Message.DocID),
+			EventId:   int64(n.Message.Event),
+			ThreadId:  int64(n.Message.Thread),
+			Title:     n.Message.Title,
+			Data:      n.Message.Data,
+			Unread:    n.Unread,
+			Ctime:     timestamppb.New(n.Ctime),
+		})
+	}
+	return res, nil
+}
+
+// CountMailbox implements `flowpb.FlowServer`.
+func (s *Server) CountMailbox(ctx context.Context, req *flowpb.CountMailboxRequest) (*flowpb.CountMailboxResponse, error) {
+	uid, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := flow.Mailboxes.CountForUserAllGroups(uid, req.Unread)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &flowpb.CountMailboxResponse{Count: n}, nil
+}
+
+// SetMessageStatus implements `flowpb.FlowServer`.
+func (s *Server) SetMessageStatus(ctx context.Context, req *flowpb.SetMessageStatusRequest) (*flowpb.SetMessageStatusResponse, error) {
+	uid, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgID := flow.MessageID(req.MessageId)
+
+	if req.GroupId == 0 {
+		err = flow.Mailboxes.SetStatusByUser(nil, uid, msgID, req.Unread)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return &flowpb.SetMessageStatusResponse{}, nil
+	}
+
+	gid := flow.GroupID(req.GroupId)
+	ok, err := flow.Groups.HasUser(gid, uid)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	err = flow.Mailboxes.SetReadByUser(nil, gid, uid, msgID, !req.Unread)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &flowpb.SetMessageStatusResponse{}, nil
+}
@@ -0,0 +1,84 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/js-ojus/flow"
+)
+
+// serveAccessContexts handles `/accesscontexts/...`.  Users can see
+// only those access contexts that they are members of.
+func (s *Server) serveAccessContexts(w http.ResponseWriter, r *request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	if len(r.parts) == 1 {
+		offset, limit, err := r.page()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		ary, err := flow.AccessContexts.ListByUser(r.user, offset, limit)
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ary)
+		return
+	}
+
+	if len(r.parts) > 3 || (len(r.parts) == 3 && r.parts[2] != "permissions") {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	n, err := r.id(1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	acid := flow.AccessContextID(n)
+
+	ok, err := s.allowed(r, acid, 0, 0)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	if !ok {
+		forbidden(w)
+		return
+	}
+
+	if len(r.parts) == 2 {
+		ac, err := flow.AccessContexts.Get(acid)
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ac)
+		return
+	}
+
+	perms, err := flow.AccessContexts.UserPermissions(acid, r.user)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, perms)
+}
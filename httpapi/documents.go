@@ -0,0 +1,328 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/js-ojus/flow"
+)
+
+// newDocumentBody is the JSON body of a document creation request.
+type newDocumentBody struct {
+	AccessContext flow.AccessContextID `json:"AccessContext"` // Required
+	ParentType    flow.DocTypeID       `json:"ParentType"`    // Of the parent document, if any
+	ParentID      flow.DocumentID      `json:"ParentID"`      // Of the parent document, if any
+	Title         string               `json:"Title"`         // Of a root document
+	Data          string               `json:"Data"`          // Required
+}
+
+// newEventBody is the JSON body of an event application request.
+type newEventBody struct {
+	State     flow.DocStateID  `json:"DocState"`            // Current state of the document; required
+	Action    flow.DocActionID `json:"DocAction"`           // Action to apply; required
+	Text      string           `json:"Text"`                // Comment
+	Signature []byte           `json:"Signature,omitempty"` // Detached signature of the event, in base64
+}
+
+// eventResult is the JSON response to an event application request.
+type eventResult struct {
+	Event     flow.DocEventID `json:"Event"`               // The recorded event
+	State     flow.DocStateID `json:"DocState"`            // State of the document after application
+	Redundant bool            `json:"Redundant,omitempty"` // Did the event leave the document as it was?
+}
+
+// serveDocuments handles `/doctypes/{dtype}/documents/...`.
+func (s *Server) serveDocuments(w http.ResponseWriter, r *request) {
+	if len(r.parts) < 3 || r.parts[2] != "documents" || len(r.parts) > 5 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	n, err := r.id(1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	dtype := flow.DocTypeID(n)
+
+	switch len(r.parts) {
+	case 3:
+		switch r.Method {
+		case http.MethodGet:
+			s.listDocuments(w, r, dtype)
+
+		case http.MethodPost:
+			s.createDocument(w, r, dtype)
+
+		default:
+			methodNotAllowed(w)
+		}
+		return
+	}
+
+	n, err = r.id(3)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	did := flow.DocumentID(n)
+
+	if len(r.parts) == 4 {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		s.getDocument(w, r, dtype, did)
+		return
+	}
+
+	if r.parts[4] != "events" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	s.applyEvent(w, r, dtype, did)
+}
+
+// listDocuments lists the documents of the given type in the access
+// context given by the `ac` query parameter.  The `visibility`
+// parameter restricts them to those created by the user (`own`), or
+// by the user's reporting subtree (`team`).
+func (s *Server) listDocuments(w http.ResponseWriter, r *request, dtype flow.DocTypeID) {
+	ac, err := r.queryInt("ac")
+	if err == nil && ac == 0 {
+		err = errors.New("ac is required")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	state, err := r.queryInt("state")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, limit, err := r.page()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var vis flow.Visibility
+	switch r.URL.Query().Get("visibility") {
+	case "", "all":
+		vis = flow.VisibilityAll
+	case "own":
+		vis = flow.VisibilityOwn
+	case "team":
+		vis = flow.VisibilityTeam
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("visibility should be one of all, own or team"))
+		return
+	}
+
+	acid := flow.AccessContextID(ac)
+	ok, err := s.allowed(r, acid, dtype, s.cfg.ViewAction)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	if !ok {
+		forbidden(w)
+		return
+	}
+
+	input := &flow.DocumentsListInput{
+		DocTypeID:       dtype,
+		AccessContextID: acid,
+		DocStateID:      flow.DocStateID(state),
+		RootOnly:        r.URL.Query().Get("root") == "true",
+		Visibility:      vis,
+	}
+	ary, err := flow.Documents.List(input, offset, limit, flow.AsUser(r.user))
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ary)
+}
+
+// createDocument creates a document of the given type, on behalf of
+// the requesting user.
+func (s *Server) createDocument(w http.ResponseWriter, r *request, dtype flow.DocTypeID) {
+	var body newDocumentBody
+	if err := r.decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.AccessContext <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("AccessContext is required"))
+		return
+	}
+
+	ok, err := s.allowed(r, body.AccessContext, dtype, s.cfg.CreateAction)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	if !ok {
+		forbidden(w)
+		return
+	}
+
+	g, err := flow.Users.SingletonGroupOf(r.user)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	input := &flow.DocumentsNewInput{
+		DocTypeID:       dtype,
+		AccessContextID: body.AccessContext,
+		GroupID:         g.ID,
+		ParentType:      body.ParentType,
+		ParentID:        body.ParentID,
+		Title:           body.Title,
+		Data:            body.Data,
+	}
+	did, err := flow.Documents.New(nil, input)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+
+	doc, err := flow.Documents.Get(nil, dtype, did, flow.AsUser(r.user))
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, doc)
+}
+
+// getDocument answers the given document, if the requesting user can
+// view it.
+func (s *Server) getDocument(w http.ResponseWriter, r *request, dtype flow.DocTypeID, did flow.DocumentID) {
+	doc, err := flow.Documents.Get(nil, dtype, did, flow.AsUser(r.user))
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+
+	ok, err := s.allowed(r, doc.AccCtx.ID, dtype, s.cfg.ViewAction)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	if !ok {
+		forbidden(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// applyEvent records an event performed by the requesting user on the
+// given document, and applies it using the workflow of the document
+// type.  The user should be permitted the event's action in the
+// document's access context.
+func (s *Server) applyEvent(w http.ResponseWriter, r *request, dtype flow.DocTypeID, did flow.DocumentID) {
+	var body newEventBody
+	if err := r.decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.State <= 0 || body.Action <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("DocState and DocAction are required"))
+		return
+	}
+
+	doc, err := flow.Documents.Get(nil, dtype, did)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	ok, err := s.allowed(r, doc.AccCtx.ID, dtype, body.Action)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	if !ok {
+		forbidden(w)
+		return
+	}
+
+	g, err := flow.Users.SingletonGroupOf(r.user)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+
+	input := &flow.DocEventsNewInput{
+		DocTypeID:   dtype,
+		DocumentID:  did,
+		DocStateID:  body.State,
+		DocActionID: body.Action,
+		GroupID:     g.ID,
+		Text:        body.Text,
+		Signature:   body.Signature,
+	}
+	res, err := flow.Workflows.Act(nil, input, nil)
+	if err != nil && err != flow.ErrDocEventRedundant {
+		writeFlowError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, &eventResult{Event: res.Event, State: res.State, Redundant: err != nil})
+}
+
+// serveEvents handles `/events/{id}`.
+func (s *Server) serveEvents(w http.ResponseWriter, r *request) {
+	if len(r.parts) != 2 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	n, err := r.id(1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := flow.DocEvents.Get(flow.DocEventID(n))
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	doc, err := flow.Documents.Get(nil, event.DocType, event.DocID)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	ok, err := s.allowed(r, doc.AccCtx.ID, event.DocType, s.cfg.ViewAction)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	if !ok {
+		forbidden(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, event)
+}
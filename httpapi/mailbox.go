@@ -0,0 +1,210 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/js-ojus/flow"
+)
+
+// messageStatusBody is the JSON body of a request to alter the status
+// of a message.
+type messageStatusBody struct {
+	Group  flow.GroupID `json:"Group"`  // Shared group mailbox holding the message, if any
+	Unread bool         `json:"Unread"` // Desired status
+}
+
+// snoozeBody is the JSON body of a request to snooze a message.
+type snoozeBody struct {
+	Group flow.GroupID `json:"Group"` // Shared group mailbox holding the message, if any
+	Until time.Time    `json:"Until"` // End of the snooze; zero to resurface right away
+}
+
+// countResult is the JSON response to a count request.
+type countResult struct {
+	Count int64 `json:"Count"`
+}
+
+// serveMailbox handles `/mailbox/...`.  Users can access only their
+// own mailboxes, and those of the groups they are members of.
+func (s *Server) serveMailbox(w http.ResponseWriter, r *request) {
+	switch {
+	case len(r.parts) == 1:
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		offset, limit, err := r.page()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		input := &flow.MailboxesListInput{
+			Unread:  r.URL.Query().Get("unread") == "true",
+			Snoozed: r.URL.Query().Get("snoozed") == "true",
+		}
+		ary, err := flow.Mailboxes.ListForUserAllGroups(r.user, input, offset, limit)
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ary)
+
+	case len(r.parts) == 2 && r.parts[1] == "count":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		n, err := flow.Mailboxes.CountForUserAllGroups(r.user, r.URL.Query().Get("unread") == "true")
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &countResult{Count: n})
+
+	case len(r.parts) == 2 && r.parts[1] == "counts":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		ary, err := flow.Mailboxes.CountsByUser(r.user, r.URL.Query().Get("perdoc") == "true")
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ary)
+
+	case len(r.parts) == 2 && r.parts[1] == "mentions":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		offset, limit, err := r.page()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		ary, err := flow.Mentions.ListByUser(r.user, offset, limit)
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ary)
+
+	case len(r.parts) == 3 && r.parts[1] == "messages":
+		if r.Method != http.MethodPut {
+			methodNotAllowed(w)
+			return
+		}
+		s.setMessageStatus(w, r)
+
+	case len(r.parts) == 4 && r.parts[1] == "messages" && r.parts[3] == "snooze":
+		if r.Method != http.MethodPut {
+			methodNotAllowed(w)
+			return
+		}
+		s.snoozeMessage(w, r)
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// setMessageStatus marks a message as read or unread, for the
+// requesting user.
+func (s *Server) setMessageStatus(w http.ResponseWriter, r *request) {
+	n, err := r.id(2)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var body messageStatusBody
+	if err = r.decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	msgID := flow.MessageID(n)
+	if body.Group == 0 {
+		err = flow.Mailboxes.SetStatusByUser(nil, r.user, msgID, body.Unread)
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ok, err := flow.Groups.HasUser(body.Group, r.user)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	if !ok {
+		forbidden(w)
+		return
+	}
+	err = flow.Mailboxes.SetReadByUser(nil, body.Group, r.user, msgID, !body.Unread)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// snoozeMessage snoozes a message in the requesting user's mailbox, or
+// in that of a group the user is a member of.
+func (s *Server) snoozeMessage(w http.ResponseWriter, r *request) {
+	n, err := r.id(2)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var body snoozeBody
+	if err = r.decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	gid := body.Group
+	if gid == 0 {
+		g, err := flow.Users.SingletonGroupOf(r.user)
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		gid = g.ID
+	} else {
+		ok, err := flow.Groups.HasUser(gid, r.user)
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		if !ok {
+			forbidden(w)
+			return
+		}
+	}
+
+	err = flow.Mailboxes.Snooze(nil, gid, flow.MessageID(n), body.Until)
+	if err != nil {
+		writeFlowError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
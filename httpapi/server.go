@@ -0,0 +1,261 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpapi exposes the resources of `flow` as JSON REST
+// endpoints.
+//
+// The server does not authenticate users itself.  Applications supply
+// an `Authenticator` that maps each request to a `flow` user, usually
+// by validating a session cookie or a bearer token.  Operations on
+// documents are then authorised by the registered `flow.Authorizer`,
+// which, by default, uses the permissions that the user has in the
+// applicable access context.
+//
+// Endpoints (relative to the mount point of the server):
+//
+//     GET  /doctypes/{dtype}/documents?ac=&state=&visibility=&offset=&limit=
+//     POST /doctypes/{dtype}/documents
+//     GET  /doctypes/{dtype}/documents/{id}
+//     POST /doctypes/{dtype}/documents/{id}/events
+//     GET  /events/{id}
+//     GET  /workflows
+//     GET  /workflows/{id}
+//     GET  /mailbox?unread=&snoozed=&offset=&limit=
+//     GET  /mailbox/count?unread=
+//     GET  /mailbox/counts?perdoc=
+//     GET  /mailbox/mentions?offset=&limit=
+//     PUT  /mailbox/messages/{id}
+//     PUT  /mailbox/messages/{id}/snooze
+//     GET  /accesscontexts
+//     GET  /accesscontexts/{id}
+//     GET  /accesscontexts/{id}/permissions
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/js-ojus/flow"
+)
+
+// Authenticator identifies the user making the given request.  It
+// should answer an error if the request is not authenticated.
+type Authenticator func(r *http.Request) (flow.UserID, error)
+
+// Config holds the settings of a server.
+type Config struct {
+	// Auth identifies the user of each request; required.
+	Auth Authenticator
+
+	// ViewAction, if non-zero, is the document action that a user
+	// should be permitted in order to read documents of a type in an
+	// access context.  Otherwise, membership of the access context
+	// suffices.
+	ViewAction flow.DocActionID
+
+	// CreateAction, if non-zero, is the document action that a user
+	// should be permitted in order to create documents of a type in
+	// an access context.  Otherwise, membership of the access context
+	// suffices.
+	CreateAction flow.DocActionID
+}
+
+// Server is an `http.Handler` serving the REST API.
+type Server struct {
+	cfg Config
+}
+
+// NewServer creates a server with the given configuration.
+func NewServer(cfg *Config) (*Server, error) {
+	if cfg == nil || cfg.Auth == nil {
+		return nil, errors.New("an authenticator is required")
+	}
+	return &Server{cfg: *cfg}, nil
+}
+
+// request bundles the details of an authenticated request.
+type request struct {
+	*http.Request
+	user  flow.UserID
+	parts []string
+}
+
+// ServeHTTP implements `http.Handler`.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	uid, err := s.cfg.Auth(r)
+	if err != nil || uid <= 0 {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+
+	req := &request{Request: r, user: uid, parts: splitPath(r.URL.Path)}
+	if len(req.parts) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch req.parts[0] {
+	case "doctypes":
+		s.serveDocuments(w, req)
+
+	case "events":
+		s.serveEvents(w, req)
+
+	case "workflows":
+		s.serveWorkflows(w, req)
+
+	case "mailbox":
+		s.serveMailbox(w, req)
+
+	case "accesscontexts":
+		s.serveAccessContexts(w, req)
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// splitPath answers the non-empty segments of the given path.
+func splitPath(path string) []string {
+	parts := []string{}
+	for _, p := range strings.Split(path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// id parses the path segment at the given index as a positive
+// integer.
+func (r *request) id(i int) (int64, error) {
+	if i >= len(r.parts) {
+		return 0, errors.New("missing identifier")
+	}
+	n, err := strconv.ParseInt(r.parts[i], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("identifiers should be positive integers")
+	}
+	return n, nil
+}
+
+// queryInt parses the given query parameter as a non-negative integer,
+// defaulting to `0`.
+func (r *request) queryInt(name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New(name + " should be a non-negative integer")
+	}
+	return n, nil
+}
+
+// page answers the `offset` and `limit` query parameters.
+func (r *request) page() (int64, int64, error) {
+	offset, err := r.queryInt("offset")
+	if err != nil {
+		return 0, 0, err
+	}
+	limit, err := r.queryInt("limit")
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+// decode reads the JSON body of the request into `v`.
+func (r *request) decode(v interface{}) error {
+	defer r.Body.Close()
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	return dec.Decode(v)
+}
+
+// errorBody is the JSON body of error responses.
+type errorBody struct {
+	Error string `json:"Error"`
+}
+
+// writeJSON writes the given value as the JSON body of a response
+// with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the given error as the JSON body of a response
+// with the given status.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorBody{Error: err.Error()})
+}
+
+// writeFlowError maps the given error answered by `flow` to an
+// appropriate HTTP status, and writes it.
+func writeFlowError(w http.ResponseWriter, err error) {
+	switch flow.CodeOf(err) {
+	case flow.CodeNotFound:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+
+	case flow.CodeConflict:
+		writeError(w, http.StatusConflict, err)
+
+	case flow.CodeValidation:
+		writeError(w, http.StatusBadRequest, err)
+
+	case flow.CodePermissionDenied:
+		writeError(w, http.StatusForbidden, err)
+
+	case flow.CodeRateLimited:
+		writeError(w, http.StatusTooManyRequests, err)
+
+	default:
+		// The details of internal errors are not for clients.
+		flow.Log(flow.LogError, "flow/httpapi: internal error", flow.F("error", err))
+		writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+	}
+}
+
+// methodNotAllowed writes the corresponding error.
+func methodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+// forbidden writes the corresponding error.
+func forbidden(w http.ResponseWriter) {
+	writeError(w, http.StatusForbidden, errors.New("permission denied"))
+}
+
+// allowed answers `true` if the registered authorizer permits the user
+// of the request to perform the given action on documents of the
+// given type in the given access context.  A zero action requires
+// only membership of the access context.
+func (s *Server) allowed(r *request, acid flow.AccessContextID, dtype flow.DocTypeID, action flow.DocActionID) (bool, error) {
+	err := flow.Authorize(acid, r.user, dtype, action)
+	switch {
+	case errors.Is(err, flow.ErrPermissionDenied):
+		return false, nil
+
+	case err != nil:
+		return false, err
+
+	default:
+		return true, nil
+	}
+}
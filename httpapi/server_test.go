@@ -0,0 +1,111 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/js-ojus/flow"
+)
+
+// stubAuthorizer answers the given outcome for all requests.
+type stubAuthorizer struct {
+	ok  bool
+	err error
+}
+
+// Allowed implements `flow.Authorizer`.
+func (a stubAuthorizer) Allowed(flow.AccessContextID, flow.UserID, flow.DocTypeID, flow.DocActionID) (bool, error) {
+	return a.ok, a.err
+}
+
+// serve answers the response of a server, authenticating all requests
+// as the given user, to the given request.
+func serve(t *testing.T, uid flow.UserID, method, target string) *httptest.ResponseRecorder {
+	s, err := NewServer(&Config{Auth: func(r *http.Request) (flow.UserID, error) {
+		if uid == 0 {
+			return 0, errors.New("no session")
+		}
+		return uid, nil
+	}})
+	if err != nil {
+		t.Fatalf("could not create server : %v", err)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestServerAuthentication(t *testing.T) {
+	if _, err := NewServer(&Config{}); err == nil {
+		t.Errorf("a server without an authenticator should not be created")
+	}
+
+	rec := serve(t, 0, http.MethodGet, "/workflows")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request : expected : %d, observed : %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	defer flow.SetAuthorizer(nil)
+
+	cases := []struct {
+		name  string
+		authz stubAuthorizer
+		want  int
+	}{
+		{"denied", stubAuthorizer{ok: false}, http.StatusForbidden},
+		{"permission error", stubAuthorizer{err: fmt.Errorf("checking roles : %w", flow.ErrPermissionDenied)}, http.StatusForbidden},
+		{"internal error", stubAuthorizer{err: errors.New("dial tcp 10.0.0.7:3306 : connection refused")}, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		flow.SetAuthorizer(c.authz)
+		rec := serve(t, 1, http.MethodGet, "/doctypes/1/documents?ac=1")
+		if rec.Code != c.want {
+			t.Errorf("%s : expected : %d, observed : %d", c.name, c.want, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "10.0.0.7") {
+			t.Errorf("%s : internal error leaked : %s", c.name, rec.Body.String())
+		}
+	}
+}
+
+func TestServerFlowErrors(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{flow.ErrPermissionDenied, http.StatusForbidden},
+		{flow.ErrDocumentNotFound, http.StatusNotFound},
+		{flow.ErrRateLimited, http.StatusTooManyRequests},
+		{errors.New("secret internal detail"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		writeFlowError(rec, c.err)
+		if rec.Code != c.want {
+			t.Errorf("%v : expected : %d, observed : %d", c.err, c.want, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("%v : internal error leaked : %s", c.err, rec.Body.String())
+		}
+	}
+}
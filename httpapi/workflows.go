@@ -0,0 +1,62 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/js-ojus/flow"
+)
+
+// serveWorkflows handles `/workflows/...`.  Workflow definitions are
+// readable by all authenticated users.
+func (s *Server) serveWorkflows(w http.ResponseWriter, r *request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	switch len(r.parts) {
+	case 1:
+		offset, limit, err := r.page()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		ary, err := flow.Workflows.List(offset, limit)
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ary)
+
+	case 2:
+		n, err := r.id(1)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		wf, err := flow.Workflows.Get(flow.WorkflowID(n))
+		if err != nil {
+			writeFlowError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, wf)
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
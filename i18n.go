@@ -0,0 +1,237 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// I18nEntity enumerates the kinds of master data that can have
+// localised display labels.
+type I18nEntity string
+
+// The following constants are represented **identically** as part of
+// an enumeration in the database.  DO NOT ALTER THESE WITHOUT ALSO
+// ALTERING THE DATABASE; ELSE DATA COULD GET CORRUPTED!
+//
+// Message templates are localised through their own per-locale
+// variants; see `MessageTemplate`.
+const (
+	// I18nDocState : labels of document states
+	I18nDocState I18nEntity = "docstate"
+	// I18nDocAction : labels of document actions
+	I18nDocAction = "docaction"
+)
+
+// I18nLabel is the display label of a master data item in a locale.
+type I18nLabel struct {
+	Entity   I18nEntity `json:"Entity"`   // Kind of the labelled item
+	EntityID int64      `json:"EntityID"` // Unique identifier of the labelled item
+	Locale   string     `json:"Locale"`   // E.g. `en`, `hi-IN`
+	Label    string     `json:"Label"`    // Localised display label
+}
+
+// Unexported type, only for convenience methods.
+type _I18n struct{}
+
+// I18n provides a resource-like interface to localised display labels
+// of master data.
+var I18n _I18n
+
+// Set assigns the display label of the given item in the given
+// locale, replacing the existing one, if any.
+func (_I18n) Set(otx *sql.Tx, entity I18nEntity, id int64, locale, label string) error {
+	locale = strings.TrimSpace(locale)
+	label = strings.TrimSpace(label)
+	if id <= 0 {
+		return newError(CodeValidation, "ID should be a positive integer")
+	}
+	if locale == "" || label == "" {
+		return newError(CodeValidation, "locale and label should be non-empty")
+	}
+	switch entity {
+	case I18nDocState, I18nDocAction:
+		// Nothing to do
+
+	default:
+		return newError(CodeValidation, "unknown localisable entity")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_i18n(entity, entity_id, locale, label)
+		VALUES(?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE label = VALUES(label)
+		`
+		_, err := tx.Exec(q, string(entity), id, locale, label)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the display label of the given item in the given
+// locale.
+func (_I18n) Delete(otx *sql.Tx, entity I18nEntity, id int64, locale string) error {
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		DELETE FROM wf_i18n
+		WHERE entity = ?
+		AND entity_id = ?
+		AND locale = ?
+		`
+		_, err := tx.Exec(q, string(entity), id, strings.TrimSpace(locale))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// List answers all the localised labels of the given item.
+func (_I18n) List(entity I18nEntity, id int64) ([]*I18nLabel, error) {
+	q := `
+	SELECT entity, entity_id, locale, label
+	FROM wf_i18n
+	WHERE entity = ?
+	AND entity_id = ?
+	ORDER BY locale
+	`
+	rows, err := readDB().Query(q, string(entity), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*I18nLabel, 0, 4)
+	for rows.Next() {
+		var elem I18nLabel
+		err = rows.Scan(&elem.Entity, &elem.EntityID, &elem.Locale, &elem.Label)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// labels answers the best-matching label of every item of the given
+// kind that has one, for the given locale.
+func (_I18n) labels(entity I18nEntity, locale string) (map[int64]string, error) {
+	cands := localeCandidates(locale)
+	cands = cands[:len(cands)-1] // Labels have no default locale.
+	res := map[int64]string{}
+	if len(cands) == 0 {
+		return res, nil
+	}
+
+	args := []interface{}{string(entity)}
+	for _, c := range cands {
+		args = append(args, c)
+	}
+	q := `
+	SELECT entity_id, locale, label
+	FROM wf_i18n
+	WHERE entity = ?
+	AND locale IN (?` + strings.Repeat(",?", len(cands)-1) + `)
+	`
+	rows, err := readDB().Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rank := map[int64]int{}
+	for rows.Next() {
+		var id int64
+		var l, label string
+		err = rows.Scan(&id, &l, &label)
+		if err != nil {
+			return nil, err
+		}
+		for r, c := range cands {
+			if c != l {
+				continue
+			}
+			if cur, ok := rank[id]; !ok || r < cur {
+				rank[id] = r
+				res[id] = label
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// localiseStates fills in the labels of the given document states.
+// Items without a localised label get their names as labels.
+func localiseStates(opts *readOptions, ary ...*DocState) error {
+	if opts.locale == "" {
+		return nil
+	}
+	ls, err := I18n.labels(I18nDocState, opts.locale)
+	if err != nil {
+		return err
+	}
+	for _, ds := range ary {
+		if l, ok := ls[int64(ds.ID)]; ok {
+			ds.Label = l
+		} else {
+			ds.Label = ds.Name
+		}
+	}
+	return nil
+}
+
+// localiseActions fills in the labels of the given document actions.
+// Items without a localised label get their names as labels.
+func localiseActions(opts *readOptions, ary ...*DocAction) error {
+	if opts.locale == "" {
+		return nil
+	}
+	ls, err := I18n.labels(I18nDocAction, opts.locale)
+	if err != nil {
+		return err
+	}
+	for _, da := range ary {
+		if l, ok := ls[int64(da.ID)]; ok {
+			da.Label = l
+		} else {
+			da.Label = da.Name
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// IDKind enumerates the entities whose identifiers can be generated by
+// the application.
+type IDKind string
+
+// Entities whose identifiers can be generated by the application.
+const (
+	IDKindDocument IDKind = "document"
+	IDKindEvent    IDKind = "event"
+	IDKindMessage  IDKind = "message"
+)
+
+// IDGenerator generates the identifiers of new documents, events and
+// messages, in place of the auto-increment columns of the database.
+// This eases replication across regions, and the merging of data from
+// several databases.
+//
+// Generated identifiers should be positive, and unique per kind across
+// all the databases whose data may be merged.  Identifiers remain
+// 64-bit integers throughout `flow`; 128-bit schemes such as UUIDv7 do
+// not fit them.  `Snowflake` offers the same time-ordered,
+// coordination-free generation in 64 bits.
+type IDGenerator interface {
+	NextID(kind IDKind) (int64, error)
+}
+
+var idGenMu sync.RWMutex
+var idGen IDGenerator
+
+// SetIDGenerator makes `flow` use the given generator for the
+// identifiers of new documents, events and messages.  A `nil`
+// generator restores the default: the database's auto-increment
+// columns.  Both modes can be mixed in a database, as long as the
+// generated identifiers do not collide with those already assigned.
+//
+// Generated identifiers -- snowflakes, in particular -- exceed the
+// range of the original `INT` columns.  Apply the migrations using
+// `flowctl migrate`, and widen the identifiers of the existing
+// document types using `flowctl ids -widen`, before enabling this.
+func SetIDGenerator(g IDGenerator) {
+	idGenMu.Lock()
+	defer idGenMu.Unlock()
+
+	idGen = g
+}
+
+// nextID answers a generated identifier of the given kind, or `0` if
+// the database should assign it.
+func nextID(kind IDKind) (int64, error) {
+	idGenMu.RLock()
+	g := idGen
+	idGenMu.RUnlock()
+
+	if g == nil {
+		return 0, nil
+	}
+	id, err := g.NextID(kind)
+	if err != nil {
+		return 0, err
+	}
+	if id <= 0 {
+		return 0, errorf(CodeInternal, "generated %s ID should be a positive integer; got %d", kind, id)
+	}
+	return id, nil
+}
+
+// insertedID answers the given generated identifier, if any, or the
+// one assigned by the database to the row inserted otherwise.
+func insertedID(id int64, res sql.Result) (int64, error) {
+	if id > 0 {
+		return id, nil
+	}
+	return res.LastInsertId()
+}
+
+// Layout of snowflake identifiers, from the most significant bit: a
+// zero sign bit, 41 bits of milliseconds since `SnowflakeEpoch`, 10
+// bits of node number and 12 bits of sequence.
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+// SnowflakeEpoch is the origin of the timestamps in snowflake
+// identifiers.  They last for about 69 years thereafter.
+var SnowflakeEpoch = time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake generates time-ordered 64-bit identifiers, without
+// coordination between the instances of an application, as long as
+// each uses a distinct node number.  It generates up to 4096
+// identifiers per millisecond per node.
+//
+// The same sequence serves all kinds of identifiers.
+type Snowflake struct {
+	mu   sync.Mutex
+	node int64
+	last int64 // Milliseconds since the epoch, of the last identifier
+	seq  int64
+}
+
+// NewSnowflake answers a generator for the given node number, which
+// should be in the range 0..1023.
+func NewSnowflake(node int64) (*Snowflake, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, errorf(CodeValidation, "node number should be in the range 0..%d", snowflakeMaxNode)
+	}
+	return &Snowflake{node: node}, nil
+}
+
+// NextID implements `IDGenerator`.
+//
+// Should the clock go back, identifiers continue from the last
+// timestamp used, so that they never repeat.
+func (s *Snowflake) NextID(kind IDKind) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := int64(time.Since(SnowflakeEpoch) / time.Millisecond)
+	if now < s.last {
+		now = s.last
+	}
+	if now == s.last {
+		s.seq = (s.seq + 1) & snowflakeMaxSeq
+		if s.seq == 0 {
+			// Sequence exhausted for this millisecond.
+			for now <= s.last {
+				time.Sleep(100 * time.Microsecond)
+				now = int64(time.Since(SnowflakeEpoch) / time.Millisecond)
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	if now>>(63-snowflakeNodeBits-snowflakeSeqBits) != 0 {
+		return 0, newError(CodeInternal, "snowflake timestamp out of range")
+	}
+	s.last = now
+
+	return now<<(snowflakeNodeBits+snowflakeSeqBits) | s.node<<snowflakeSeqBits | s.seq, nil
+}
+
+// WidenDocumentIDs converts the identifier columns of the storage
+// tables of the existing document types to `BIGINT`, skipping those
+// converted already.  Document types defined thereafter have such
+// columns from the outset.
+//
+// N.B. MySQL commits data definition statements implicitly.  Stop all
+// instances of the application before running this.
+func WidenDocumentIDs() error {
+	rows, err := db.Query(`
+	SELECT table_name
+	FROM information_schema.columns
+	WHERE table_schema = DATABASE()
+	AND table_name LIKE 'wf\_documents\_%'
+	AND column_name = 'id'
+	AND data_type = 'int'
+	AND table_name IN (
+		SELECT table_name
+		FROM information_schema.tables
+		WHERE table_schema = DATABASE()
+		AND table_type = 'BASE TABLE'
+	)
+	`)
+	if err != nil {
+		return err
+	}
+	tbls := []string{}
+	for rows.Next() {
+		var tbl string
+		if err = rows.Scan(&tbl); err != nil {
+			rows.Close()
+			return err
+		}
+		tbls = append(tbls, tbl)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, tbl := range tbls {
+		if _, err = db.Exec(`ALTER TABLE ` + tbl + ` MODIFY id BIGINT NOT NULL AUTO_INCREMENT`); err != nil {
+			return &CodedError{Code: CodeOf(err), Msg: tbl, Err: err}
+		}
+	}
+	return nil
+}
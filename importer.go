@@ -0,0 +1,495 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ImportRecord is a document of a legacy system, together with its
+// history and attachments.
+type ImportRecord struct {
+	Key         string             `json:"key"`         // Unique identifier in the legacy system; required
+	Title       string             `json:"title"`       // Title of the document
+	Data        string             `json:"data"`        // Body of the document; defaults to the title
+	Creator     string             `json:"creator"`     // Legacy user who created the document; required
+	Ctime       time.Time          `json:"ctime"`       // Time of creation; required
+	Status      string             `json:"status"`      // Legacy status at creation; defaults to the begin state of the workflow
+	Events      []ImportEvent      `json:"events"`      // History, in chronological order
+	Attachments []ImportAttachment `json:"attachments"` // Files attached to the document
+}
+
+// ImportEvent is an action performed on a legacy document.
+type ImportEvent struct {
+	Action string    `json:"action"` // Legacy action; required
+	To     string    `json:"to"`     // Legacy status resulting from the action; required
+	User   string    `json:"user"`   // Legacy user who performed the action; required
+	Ctime  time.Time `json:"ctime"`  // Time of the action; required
+	Text   string    `json:"text"`   // Comments, if any
+}
+
+// ImportAttachment is a file attached to a legacy document.  The file
+// is copied into the blobs directory; the original is left as it is.
+type ImportAttachment struct {
+	Name string `json:"name"` // Name of the attachment; defaults to the base name of the path
+	Path string `json:"path"` // Path to the file; required
+}
+
+// ImportSource supplies the records of a legacy system, one at a time.
+// `Next` answers `io.EOF` when the records are exhausted.
+type ImportSource interface {
+	Next() (*ImportRecord, error)
+}
+
+// ImportMapping maps the statuses, actions and users of a legacy
+// system to those of `flow`.
+type ImportMapping struct {
+	DocType DocTypeID              // Type of the documents created; required
+	AccCtx  AccessContextID        // Access context of the documents created; required
+	States  map[string]DocStateID  // Legacy status to document state
+	Actions map[string]DocActionID // Legacy action to document action
+	Users   map[string]GroupID     // Legacy user to (singleton) group; others are looked up as e-mail addresses
+}
+
+// ImportProgress reports the progress of an import, after each record.
+type ImportProgress struct {
+	Key      string // Legacy key of the record just processed
+	Imported int    // Records imported so far, in this run
+	Skipped  int    // Records skipped so far, having been imported in an earlier run
+}
+
+// Importer bulk-creates documents, their historical events and their
+// attachments from the records of a legacy system.
+//
+// Each record is imported in its own transaction, together with a
+// checkpoint under the name of the importer.  Should a run fail or be
+// cancelled, a later run under the same name skips the records
+// imported already, and resumes with the rest.
+//
+// Imported documents are exempt from quotas (see `Quotas`).
+type Importer struct {
+	name    string
+	m       *ImportMapping
+	groups  map[string]GroupID
+	onProgr func(*ImportProgress)
+}
+
+// NewImporter answers an importer of the given name, which identifies
+// its checkpoints, using the given mapping.
+func NewImporter(name string, m *ImportMapping) (*Importer, error) {
+	name = strings.TrimSpace(name)
+	var v validator
+	v.name("name", name, maxNameLen)
+	if m == nil {
+		v.fail("mapping", "should be given")
+	} else {
+		v.positive("DocType", int64(m.DocType))
+		v.positive("AccCtx", int64(m.AccCtx))
+	}
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+
+	groups := map[string]GroupID{}
+	for k, gid := range m.Users {
+		groups[k] = gid
+	}
+	return &Importer{name: name, m: m, groups: groups}, nil
+}
+
+// OnProgress registers the given function to be called after each
+// record processed.
+func (im *Importer) OnProgress(fn func(*ImportProgress)) {
+	im.onProgr = fn
+}
+
+// Run imports all the records of the given source.  It stops at the
+// first record that cannot be imported, answering the error together
+// with the legacy key of the record.  It answers the progress made.
+func (im *Importer) Run(ctx context.Context, src ImportSource) (*ImportProgress, error) {
+	p := &ImportProgress{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return p, err
+		}
+
+		rec, err := src.Next()
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return p, err
+		}
+		p.Key = rec.Key
+
+		done, err := im.imported(rec.Key)
+		if err != nil {
+			return p, err
+		}
+		if done {
+			p.Skipped++
+		} else {
+			err = withTx(nil, func(tx *sql.Tx) error {
+				return im.importRecord(tx, rec)
+			})
+			if err != nil {
+				return p, &CodedError{Code: CodeOf(err), Msg: "legacy record " + rec.Key, Err: err}
+			}
+			p.Imported++
+		}
+
+		if im.onProgr != nil {
+			im.onProgr(p)
+		}
+	}
+}
+
+// imported answers if the record of the given key has been imported
+// already.
+func (im *Importer) imported(key string) (bool, error) {
+	q := `SELECT COUNT(*) FROM wf_import_checkpoints WHERE name = ? AND record_key = ?`
+	var n int64
+	if err := db.QueryRow(q, im.name, key).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// group answers the group of the given legacy user.
+func (im *Importer) group(user string) (GroupID, error) {
+	user = strings.TrimSpace(user)
+	if gid, ok := im.groups[user]; ok {
+		return gid, nil
+	}
+
+	u, err := Users.GetByEmail(user)
+	if err != nil {
+		return 0, errorf(CodeValidation, "legacy user %q is neither mapped nor known", user)
+	}
+	g, err := Users.SingletonGroupOf(u.ID)
+	if err != nil {
+		return 0, err
+	}
+	im.groups[user] = g.ID
+	return g.ID, nil
+}
+
+// state answers the document state of the given legacy status.
+func (im *Importer) state(status string) (DocStateID, error) {
+	id, ok := im.m.States[strings.TrimSpace(status)]
+	if !ok {
+		return 0, errorf(CodeValidation, "legacy status %q is not mapped", status)
+	}
+	return id, nil
+}
+
+// importRecord imports the given record, in the given transaction.
+func (im *Importer) importRecord(tx *sql.Tx, rec *ImportRecord) error {
+	var v validator
+	v.required("key", rec.Key)
+	v.required("creator", rec.Creator)
+	if rec.Ctime.IsZero() {
+		v.fail("ctime", "is required")
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	gid, err := im.group(rec.Creator)
+	if err != nil {
+		return err
+	}
+	data := rec.Data
+	if data == "" {
+		data = rec.Title
+	}
+	did, err := Documents.New(tx, &DocumentsNewInput{
+		DocTypeID:       im.m.DocType,
+		AccessContextID: im.m.AccCtx,
+		GroupID:         gid,
+		Title:           rec.Title,
+		Data:            data,
+		Ctime:           rec.Ctime,
+		imported:        true,
+	})
+	if err != nil {
+		return err
+	}
+
+	doc, err := Documents.Get(tx, im.m.DocType, did)
+	if err != nil {
+		return err
+	}
+	cur := doc.State.ID
+	if rec.Status != "" {
+		state, err := im.state(rec.Status)
+		if err != nil {
+			return err
+		}
+		if state != cur {
+			if err = Documents.setState(tx, im.m.DocType, did, state, 0); err != nil {
+				return err
+			}
+			cur = state
+		}
+	}
+
+	for i, ev := range rec.Events {
+		action, ok := im.m.Actions[strings.TrimSpace(ev.Action)]
+		if !ok {
+			return errorf(CodeValidation, "event %d : legacy action %q is not mapped", i+1, ev.Action)
+		}
+		to, err := im.state(ev.To)
+		if err != nil {
+			return err
+		}
+		egid, err := im.group(ev.User)
+		if err != nil {
+			return err
+		}
+		text := ev.Text
+		if text == "" {
+			text = ev.Action
+		}
+		_, err = DocEvents.Import(tx, &DocEventsImportInput{
+			DocEventsNewInput: DocEventsNewInput{
+				DocTypeID:   im.m.DocType,
+				DocumentID:  did,
+				DocStateID:  cur,
+				DocActionID: action,
+				GroupID:     egid,
+				Text:        text,
+			},
+			ToState: to,
+			Ctime:   ev.Ctime,
+		})
+		if err != nil {
+			return &CodedError{Code: CodeOf(err), Msg: fmt.Sprintf("event %d", i+1), Err: err}
+		}
+		cur = to
+	}
+
+	for _, a := range rec.Attachments {
+		if err = im.attach(tx, did, &a); err != nil {
+			return err
+		}
+	}
+
+	q := `
+	INSERT INTO wf_import_checkpoints(name, record_key, doctype_id, doc_id, ctime)
+	VALUES(?, ?, ?, ?, NOW())
+	`
+	_, err = tx.Exec(q, im.name, rec.Key, im.m.DocType, did)
+	return err
+}
+
+// attach copies the given attachment, and adds the copy to the given
+// document as a blob.
+func (im *Importer) attach(tx *sql.Tx, did DocumentID, a *ImportAttachment) error {
+	src, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// The copy is made in the blobs directory, so that `AddBlob` can
+	// move it into place.
+	dst, err := ioutil.TempFile(blobsDir, "import-")
+	if err != nil {
+		return err
+	}
+	h := sha1.New()
+	_, err = io.Copy(io.MultiWriter(dst, h), src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+
+	name := a.Name
+	if name == "" {
+		name = filepath.Base(a.Path)
+	}
+	blob := &Blob{Name: name, Path: dst.Name(), SHA1Sum: fmt.Sprintf("%x", h.Sum(nil))}
+	if err = Documents.AddBlob(tx, im.m.DocType, did, blob); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return nil
+}
+
+// jsonImportSource reads records as a stream of JSON objects.
+type jsonImportSource struct {
+	dec *json.Decoder
+}
+
+// NewJSONImportSource answers a source that reads records from the
+// given reader, as a stream of JSON objects -- one per line, usually
+// -- each having the structure of `ImportRecord`.  Times are in RFC
+// 3339 format.
+func NewJSONImportSource(r io.Reader) ImportSource {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	return &jsonImportSource{dec: dec}
+}
+
+// Next answers the next record.
+func (s *jsonImportSource) Next() (*ImportRecord, error) {
+	var rec ImportRecord
+	if err := s.dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// csvImportSource reads records from CSV, one event per row.
+type csvImportSource struct {
+	r       *csv.Reader
+	cols    map[string]int
+	pending []string
+	line    int
+}
+
+// NewCSVImportSource answers a source that reads records from the
+// given reader, as CSV having a header row.  The columns, in any
+// order, are:
+//
+//     key          legacy key of the document; required
+//     title        title of the document
+//     data         body of the document
+//     creator      legacy user who created the document
+//     ctime        time of creation
+//     status       legacy status at creation
+//     attachments  paths to attached files, separated by `;`
+//     action       legacy action of an event
+//     to           legacy status resulting from the event
+//     user         legacy user who performed the event
+//     time         time of the event
+//     text         comments on the event
+//
+// Consecutive rows having the same key make up one record: the
+// columns of the document are read from the first of them, while
+// each row having an action adds an event.  Times are in RFC 3339
+// format.
+func NewCSVImportSource(r io.Reader) (ImportSource, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["key"]; !ok {
+		return nil, newError(CodeValidation, "CSV should have a `key` column")
+	}
+	return &csvImportSource{r: cr, cols: cols, line: 1}, nil
+}
+
+// col answers the value of the named column in the given row.
+func (s *csvImportSource) col(row []string, name string) string {
+	i, ok := s.cols[name]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// time answers the value of the named column in the given row, as a
+// time.
+func (s *csvImportSource) time(row []string, name string) (time.Time, error) {
+	v := s.col(row, name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errorf(CodeValidation, "CSV line %d : %s : %v", s.line, name, err)
+	}
+	return t, nil
+}
+
+// Next answers the next record.
+func (s *csvImportSource) Next() (*ImportRecord, error) {
+	var rec *ImportRecord
+	for {
+		row := s.pending
+		s.pending = nil
+		if row == nil {
+			var err error
+			row, err = s.r.Read()
+			if err == io.EOF && rec != nil {
+				return rec, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			s.line++
+		}
+
+		key := s.col(row, "key")
+		if rec != nil && key != rec.Key {
+			s.pending = row
+			return rec, nil
+		}
+		if rec == nil {
+			ctime, err := s.time(row, "ctime")
+			if err != nil {
+				return nil, err
+			}
+			rec = &ImportRecord{
+				Key:     key,
+				Title:   s.col(row, "title"),
+				Data:    s.col(row, "data"),
+				Creator: s.col(row, "creator"),
+				Ctime:   ctime,
+				Status:  s.col(row, "status"),
+			}
+			for _, p := range strings.Split(s.col(row, "attachments"), ";") {
+				if p = strings.TrimSpace(p); p != "" {
+					rec.Attachments = append(rec.Attachments, ImportAttachment{Path: p})
+				}
+			}
+		}
+
+		if action := s.col(row, "action"); action != "" {
+			t, err := s.time(row, "time")
+			if err != nil {
+				return nil, err
+			}
+			rec.Events = append(rec.Events, ImportEvent{
+				Action: action,
+				To:     s.col(row, "to"),
+				User:   s.col(row, "user"),
+				Ctime:  t,
+				Text:   s.col(row, "text"),
+			})
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// IntegrityClass enumerates the classes of inconsistencies found by
+// `Admins.Integrity`.
+type IntegrityClass string
+
+// Classes of inconsistencies.
+const (
+	// IntegrityMailboxOrphan : a mailbox entry whose message is missing; fixable
+	IntegrityMailboxOrphan IntegrityClass = "mailbox_orphan"
+	// IntegrityChildOrphan : a parent-child link whose parent or child is missing; fixable
+	IntegrityChildOrphan IntegrityClass = "child_orphan"
+	// IntegrityBlobMissing : a blob whose file is missing from the blobs directory
+	IntegrityBlobMissing IntegrityClass = "blob_missing"
+	// IntegrityEventState : an event referring to a missing document state
+	IntegrityEventState IntegrityClass = "event_state"
+)
+
+// fixable answers `true` if inconsistencies of this class can be
+// fixed without losing information: the rows concerned are
+// unreachable anyway.
+func (c IntegrityClass) fixable() bool {
+	return c == IntegrityMailboxOrphan || c == IntegrityChildOrphan
+}
+
+// IntegrityIssue is an inconsistency found by `Admins.Integrity`.
+type IntegrityIssue struct {
+	Class  IntegrityClass `json:"Class"`  // Class of this issue
+	Table  string         `json:"Table"`  // Table having the inconsistent row
+	ID     int64          `json:"ID"`     // Identifier of the row
+	Detail string         `json:"Detail"` // Description of the inconsistency
+	Fixed  bool           `json:"Fixed"`  // Was the row removed?
+}
+
+// IntegrityReport is the outcome of `Admins.Integrity`.
+type IntegrityReport struct {
+	Issues []*IntegrityIssue        `json:"Issues"` // All the issues found
+	Counts map[IntegrityClass]int64 `json:"Counts"` // Number of issues by class
+	Fixed  int64                    `json:"Fixed"`  // Number of issues fixed
+}
+
+// integrityPageSize is the number of blobs checked per query.
+const integrityPageSize = 1000
+
+// Integrity scans the database for orphaned and dangling rows, which
+// the foreign keys of the schema do not prevent -- or did not, e.g.
+// while migrations ran with foreign key checks disabled.  It checks
+// for mailbox entries without messages, parent-child links to missing
+// documents, blobs whose files are gone and events referring to
+// missing states.
+//
+// Should `fix` be `true`, the mailbox entries and the links found are
+// removed, since nothing can reach them anyway.  Other issues need
+// manual intervention, and are only reported.
+//
+// The scan checks the given context between its steps, and answers
+// its error upon cancellation.
+func (_Admins) Integrity(ctx context.Context, fix bool) (*IntegrityReport, error) {
+	rep := &IntegrityReport{Issues: []*IntegrityIssue{}, Counts: map[IntegrityClass]int64{}}
+	steps := []func(*IntegrityReport) error{
+		integrityMailboxes,
+		integrityChildren,
+		integrityBlobs,
+		integrityEvents,
+	}
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := step(rep); err != nil {
+			return nil, err
+		}
+	}
+	if !fix {
+		return rep, nil
+	}
+
+	byTable := map[string][]interface{}{}
+	for _, is := range rep.Issues {
+		if is.Class.fixable() {
+			byTable[is.Table] = append(byTable[is.Table], is.ID)
+		}
+	}
+	for tbl, ids := range byTable {
+		for len(ids) > 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			n := len(ids)
+			if n > maxBatchRows {
+				n = maxBatchRows
+			}
+			err := withTx(nil, func(tx *sql.Tx) error {
+				return purgeExec(tx, ids[:n], "DELETE FROM "+tbl+" WHERE id IN ")
+			})
+			if err != nil {
+				return nil, err
+			}
+			ids = ids[n:]
+		}
+	}
+	for _, is := range rep.Issues {
+		if is.Class.fixable() {
+			is.Fixed = true
+			rep.Fixed++
+		}
+	}
+	writeLog(LogInfo, "integrity issues fixed", F("count", rep.Fixed))
+
+	return rep, nil
+}
+
+// add records the given issue in this report.
+func (r *IntegrityReport) add(class IntegrityClass, tbl string, id int64, detail string) {
+	r.Issues = append(r.Issues, &IntegrityIssue{Class: class, Table: tbl, ID: id, Detail: detail})
+	r.Counts[class]++
+}
+
+// integrityMailboxes finds the mailbox entries whose messages are
+// missing.
+func integrityMailboxes(rep *IntegrityReport) error {
+	q := `
+	SELECT mb.id, mb.message_id
+	FROM wf_mailboxes mb
+	LEFT JOIN wf_messages msgs ON msgs.id = mb.message_id
+	WHERE msgs.id IS NULL
+	ORDER BY mb.id
+	`
+	return scanEach(readDB().Query, q, nil, func(scan func(...interface{}) error) error {
+		var id, mid int64
+		if err := scan(&id, &mid); err != nil {
+			return err
+		}
+		rep.add(IntegrityMailboxOrphan, "wf_mailboxes", id, fmt.Sprintf("message %d is missing", mid))
+		return nil
+	})
+}
+
+// integrityChildren finds the parent-child links whose parent or
+// child is missing.
+func integrityChildren(rep *IntegrityReport) error {
+	dtids := []DocTypeID{}
+	err := scanEach(readDB().Query, `SELECT id FROM wf_doctypes_master ORDER BY id`, nil,
+		func(scan func(...interface{}) error) error {
+			var dtid DocTypeID
+			if err := scan(&dtid); err != nil {
+				return err
+			}
+			dtids = append(dtids, dtid)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, dtid := range dtids {
+		for _, side := range []string{"parent", "child"} {
+			q := `
+			SELECT dc.id, dc.` + side + `_id
+			FROM wf_document_children dc
+			LEFT JOIN ` + DocTypes.docStorName(dtid) + ` docs ON docs.id = dc.` + side + `_id
+			WHERE dc.` + side + `_doctype_id = ?
+			AND docs.id IS NULL
+			ORDER BY dc.id
+			`
+			err = scanEach(readDB().Query, q, []interface{}{dtid}, func(scan func(...interface{}) error) error {
+				var id, did int64
+				if err := scan(&id, &did); err != nil {
+					return err
+				}
+				rep.add(IntegrityChildOrphan, "wf_document_children", id,
+					fmt.Sprintf("%s document %d:%d is missing", side, dtid, did))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// integrityBlobs finds the blobs whose files are missing.
+func integrityBlobs(rep *IntegrityReport) error {
+	q := `
+	SELECT id, path
+	FROM wf_document_blobs
+	WHERE id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+	var last int64
+	for {
+		n := 0
+		err := scanEach(readDB().Query, q, []interface{}{last, integrityPageSize}, func(scan func(...interface{}) error) error {
+			var bpath string
+			if err := scan(&last, &bpath); err != nil {
+				return err
+			}
+			n++
+			if _, err := os.Stat(bpath); os.IsNotExist(err) {
+				rep.add(IntegrityBlobMissing, "wf_document_blobs", last, fmt.Sprintf("file %s is missing", bpath))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if n < integrityPageSize {
+			return nil
+		}
+	}
+}
+
+// integrityEvents finds the events referring to missing states.
+func integrityEvents(rep *IntegrityReport) error {
+	q := `
+	SELECT de.id, de.docstate_id
+	FROM wf_docevents de
+	LEFT JOIN wf_docstates_master dsm ON dsm.id = de.docstate_id
+	WHERE dsm.id IS NULL
+	ORDER BY de.id
+	`
+	return scanEach(readDB().Query, q, nil, func(scan func(...interface{}) error) error {
+		var id, dsid int64
+		if err := scan(&id, &dsid); err != nil {
+			return err
+		}
+		rep.add(IntegrityEventState, "wf_docevents", id, fmt.Sprintf("state %d is missing", dsid))
+		return nil
+	})
+}
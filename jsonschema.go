@@ -0,0 +1,263 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// jsonSchema is a compiled JSON Schema.  The keywords supported are
+// `type`, `enum`, `properties`, `required`, `additionalProperties`,
+// `items`, `minItems`, `maxItems`, `minLength`, `maxLength`, `pattern`,
+// `minimum` and `maximum`.  Others are ignored.
+type jsonSchema struct {
+	Type       interface{}            `json:"type"` // A name, or a list of names
+	Enum       []interface{}          `json:"enum"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Required   []string               `json:"required"`
+	AddlProps  json.RawMessage        `json:"additionalProperties"` // A boolean, or a schema
+	Items      *jsonSchema            `json:"items"`
+	MinItems   *int                   `json:"minItems"`
+	MaxItems   *int                   `json:"maxItems"`
+	MinLength  *int                   `json:"minLength"`
+	MaxLength  *int                   `json:"maxLength"`
+	Pattern    string                 `json:"pattern"`
+	Minimum    *float64               `json:"minimum"`
+	Maximum    *float64               `json:"maximum"`
+
+	types     []string
+	noAddl    bool
+	addl      *jsonSchema
+	patternRE *regexp.Regexp
+}
+
+// jsonTypes are the names of the types of JSON Schema.
+var jsonTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+}
+
+// compileJSONSchema parses the given JSON Schema.
+func compileJSONSchema(s string) (*jsonSchema, error) {
+	var js jsonSchema
+	if err := json.Unmarshal([]byte(s), &js); err != nil {
+		return nil, errorf(CodeValidation, "malformed JSON schema : %v", err)
+	}
+	if err := js.compile("$"); err != nil {
+		return nil, err
+	}
+	return &js, nil
+}
+
+// compile checks this schema, found at the given location, and
+// prepares it for validation.
+func (js *jsonSchema) compile(at string) error {
+	switch t := js.Type.(type) {
+	case nil:
+
+	case string:
+		js.types = []string{t}
+
+	case []interface{}:
+		for _, e := range t {
+			s, ok := e.(string)
+			if !ok {
+				return errorf(CodeValidation, "JSON schema at %s : type names should be strings", at)
+			}
+			js.types = append(js.types, s)
+		}
+
+	default:
+		return errorf(CodeValidation, "JSON schema at %s : type should be a name, or a list of names", at)
+	}
+	for _, t := range js.types {
+		if !jsonTypes[t] {
+			return errorf(CodeValidation, "JSON schema at %s : unknown type %q", at, t)
+		}
+	}
+
+	if len(js.AddlProps) > 0 {
+		var b bool
+		if err := json.Unmarshal(js.AddlProps, &b); err == nil {
+			js.noAddl = !b
+		} else {
+			js.addl = &jsonSchema{}
+			if err = json.Unmarshal(js.AddlProps, js.addl); err != nil {
+				return errorf(CodeValidation, "JSON schema at %s : additionalProperties should be a boolean, or a schema", at)
+			}
+			if err = js.addl.compile(at + ".*"); err != nil {
+				return err
+			}
+		}
+	}
+	if js.Pattern != "" {
+		re, err := regexp.Compile(js.Pattern)
+		if err != nil {
+			return errorf(CodeValidation, "JSON schema at %s : malformed pattern : %v", at, err)
+		}
+		js.patternRE = re
+	}
+
+	for name, p := range js.Properties {
+		if p == nil {
+			return errorf(CodeValidation, "JSON schema at %s.%s : should be an object", at, name)
+		}
+		if err := p.compile(at + "." + name); err != nil {
+			return err
+		}
+	}
+	if js.Items != nil {
+		if err := js.Items.compile(at + "[]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the given JSON text against this schema, and
+// answers all the problems found as `ValidationErrors`.
+func (js *jsonSchema) validate(data string) error {
+	var val interface{}
+	if err := json.Unmarshal([]byte(data), &val); err != nil {
+		return newError(CodeValidation, "document data is not valid JSON")
+	}
+	var v validator
+	js.check(&v, "$", val)
+	return v.result()
+}
+
+// check records the problems of the given value, found at the given
+// location.
+func (js *jsonSchema) check(v *validator, at string, val interface{}) {
+	if len(js.types) > 0 && !js.hasType(val) {
+		v.fail(at, fmt.Sprintf("should be of type %v", js.types))
+		return
+	}
+	if len(js.Enum) > 0 {
+		found := false
+		for _, e := range js.Enum {
+			if reflect.DeepEqual(e, val) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(at, "is not one of the allowed values")
+		}
+	}
+
+	switch x := val.(type) {
+	case map[string]interface{}:
+		for _, name := range js.Required {
+			if _, ok := x[name]; !ok {
+				v.fail(at+"."+name, "is required")
+			}
+		}
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch p, ok := js.Properties[name]; {
+			case ok:
+				p.check(v, at+"."+name, x[name])
+
+			case js.noAddl:
+				v.fail(at+"."+name, "is not allowed")
+
+			case js.addl != nil:
+				js.addl.check(v, at+"."+name, x[name])
+			}
+		}
+
+	case []interface{}:
+		if js.MinItems != nil && len(x) < *js.MinItems {
+			v.fail(at, fmt.Sprintf("should have at least %d items", *js.MinItems))
+		}
+		if js.MaxItems != nil && len(x) > *js.MaxItems {
+			v.fail(at, fmt.Sprintf("should have at most %d items", *js.MaxItems))
+		}
+		if js.Items != nil {
+			for i, e := range x {
+				js.Items.check(v, fmt.Sprintf("%s[%d]", at, i), e)
+			}
+		}
+
+	case string:
+		n := utf8.RuneCountInString(x)
+		if js.MinLength != nil && n < *js.MinLength {
+			v.fail(at, fmt.Sprintf("should have at least %d characters", *js.MinLength))
+		}
+		if js.MaxLength != nil && n > *js.MaxLength {
+			v.fail(at, fmt.Sprintf("should have at most %d characters", *js.MaxLength))
+		}
+		if js.patternRE != nil && !js.patternRE.MatchString(x) {
+			v.fail(at, fmt.Sprintf("should match %q", js.Pattern))
+		}
+
+	case float64:
+		if js.Minimum != nil && x < *js.Minimum {
+			v.fail(at, fmt.Sprintf("should be at least %v", *js.Minimum))
+		}
+		if js.Maximum != nil && x > *js.Maximum {
+			v.fail(at, fmt.Sprintf("should be at most %v", *js.Maximum))
+		}
+	}
+}
+
+// hasType answers `true` if the given value is of one of the types of
+// this schema.
+func (js *jsonSchema) hasType(val interface{}) bool {
+	for _, t := range js.types {
+		switch x := val.(type) {
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+
+		case string:
+			if t == "string" {
+				return true
+			}
+
+		case float64:
+			if t == "number" || (t == "integer" && x == math.Trunc(x)) {
+				return true
+			}
+
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+
+		case nil:
+			if t == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
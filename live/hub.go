@@ -0,0 +1,223 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package live pushes mailbox notifications and document state changes
+// to connected clients, using server-sent events or WebSockets.
+//
+// A `Hub` is an `flow.EventPublisher`.  In a single-process
+// deployment, register it (possibly within a `flow.MultiPublisher`)
+// using `flow.EventStream.SetPublisher`; events then reach the hub
+// after the applying transactions commit, through the outbox relay.
+// In a deployment with several processes, feed each process' hub from
+// a consumer of the Kafka topic or the NATS subjects, instead.
+package live
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/js-ojus/flow"
+)
+
+// Kinds of updates.
+const (
+	// KindState : a document transitioned to a new state
+	KindState = "state"
+	// KindNotification : a message was posted to a mailbox of the user
+	KindNotification = "notification"
+)
+
+// Update is a single message pushed to a client.
+type Update struct {
+	Kind      string          `json:"Kind"`              // One of the kinds above
+	Event     flow.DocEventID `json:"Event"`             // The applied event
+	DocType   flow.DocTypeID  `json:"DocType"`           // Type of the affected document
+	DocID     flow.DocumentID `json:"DocID"`             // The affected document
+	FromState flow.DocStateID `json:"FromState"`         // State before the event
+	ToState   flow.DocStateID `json:"ToState"`           // State after the event
+	Group     flow.GroupID    `json:"Group,omitempty"`   // Mailbox to which the notification was posted
+	Message   flow.MessageID  `json:"Message,omitempty"` // The posted notification
+	Title     string          `json:"Title,omitempty"`   // Subject of the notification
+	Unread    int64           `json:"Unread"`            // Unread count of the user's own mailbox, for notifications
+	Ctime     time.Time       `json:"Ctime"`             // Time of the event
+}
+
+// Authenticator identifies the user making the given request.  It
+// should answer an error if the request is not authenticated.
+type Authenticator func(r *http.Request) (flow.UserID, error)
+
+// Subscription receives the updates relevant to one user.
+type Subscription struct {
+	C <-chan *Update // Updates, in order; closed when the subscription ends
+
+	hub    *Hub
+	user   flow.UserID
+	groups map[flow.GroupID]struct{}
+	ch     chan *Update
+	closed bool // Guarded by the hub's lock
+}
+
+// Close ends this subscription.
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+// Hub fans updates out to the subscriptions of the users concerned.
+//
+// Slow subscribers do not hold up others: updates that do not fit in
+// a subscriber's buffer are dropped, and the subscription is closed.
+// Clients should then reconnect, and refresh their state.
+type Hub struct {
+	mu      sync.RWMutex
+	byGroup map[flow.GroupID]map[*Subscription]struct{}
+	buffer  int
+}
+
+// NewHub creates a hub whose subscriptions buffer up to `buffer`
+// updates.  A non-positive value selects a default of 64.
+func NewHub(buffer int) *Hub {
+	if buffer <= 0 {
+		buffer = 64
+	}
+	return &Hub{
+		byGroup: map[flow.GroupID]map[*Subscription]struct{}{},
+		buffer:  buffer,
+	}
+}
+
+// Subscribe starts a subscription for the given user.  It receives
+// updates concerning all the groups that the user is a member of, at
+// the time of subscribing.
+func (h *Hub) Subscribe(uid flow.UserID) (*Subscription, error) {
+	gs, err := flow.Users.GroupsOf(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *Update, h.buffer)
+	s := &Subscription{C: ch, hub: h, user: uid, groups: map[flow.GroupID]struct{}{}, ch: ch}
+	for _, g := range gs {
+		s.groups[g.ID] = struct{}{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for gid := range s.groups {
+		subs, ok := h.byGroup[gid]
+		if !ok {
+			subs = map[*Subscription]struct{}{}
+			h.byGroup[gid] = subs
+		}
+		subs[s] = struct{}{}
+	}
+	return s, nil
+}
+
+// remove unregisters the given subscription, and closes its channel.
+func (h *Hub) remove(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.ch)
+	for gid := range s.groups {
+		delete(h.byGroup[gid], s)
+		if len(h.byGroup[gid]) == 0 {
+			delete(h.byGroup, gid)
+		}
+	}
+}
+
+// Publish implements `flow.EventPublisher`.
+//
+// The actor and the members of the recipient groups receive a state
+// update.  Members of the recipient groups also receive a notification
+// update.  Each subscription receives at most one update of each
+// kind per event.
+func (h *Hub) Publish(ctx context.Context, ev *flow.StreamEvent) error {
+	base := Update{
+		Event:     ev.Event,
+		DocType:   ev.DocType,
+		DocID:     ev.DocID,
+		FromState: ev.FromState,
+		ToState:   ev.ToState,
+		Ctime:     ev.Ctime,
+	}
+
+	h.mu.RLock()
+	state := map[*Subscription]struct{}{}
+	notify := map[*Subscription]flow.GroupID{}
+	for s := range h.byGroup[ev.Actor] {
+		state[s] = struct{}{}
+	}
+	for _, gid := range ev.Recipients {
+		for s := range h.byGroup[gid] {
+			state[s] = struct{}{}
+			if _, ok := notify[s]; !ok {
+				notify[s] = gid
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	unread := map[flow.UserID]int64{}
+	if ev.Message > 0 {
+		for s := range notify {
+			if _, ok := unread[s.user]; !ok {
+				// Best effort; clients can always fetch the count afresh.
+				unread[s.user], _ = flow.Mailboxes.CountByUser(s.user, true)
+			}
+		}
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range state {
+		u := base
+		u.Kind = KindState
+		h.send(s, &u)
+	}
+	if ev.Message == 0 {
+		return nil
+	}
+	for s, gid := range notify {
+		u := base
+		u.Kind = KindNotification
+		u.Group = gid
+		u.Message = ev.Message
+		u.Title = ev.Title
+		u.Unread = unread[s.user]
+		h.send(s, &u)
+	}
+	return nil
+}
+
+// send delivers the given update to the given subscription, closing
+// the subscription if it is not keeping up.  The caller should hold
+// the read lock of the hub.
+func (h *Hub) send(s *Subscription, u *Update) {
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- u:
+	default:
+		go s.Close()
+	}
+}
@@ -0,0 +1,86 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// heartbeat is the interval at which idle connections are kept alive.
+const heartbeat = 30 * time.Second
+
+// SSEHandler answers an `http.Handler` that streams the updates of the
+// authenticated user as server-sent events.  The event name of each
+// update is its kind.
+func (h *Hub) SSEHandler(auth Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := auth(r)
+		if err != nil || uid <= 0 {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		sub, err := h.Subscribe(uid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-ticker.C:
+				if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+
+			case u, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(u)
+				if err != nil {
+					return
+				}
+				_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", u.Event, u.Kind, data)
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
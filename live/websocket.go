@@ -0,0 +1,101 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package live
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeWait is the time allowed to write a message to a WebSocket.
+const writeWait = 10 * time.Second
+
+// WebSocketHandler answers an `http.Handler` that upgrades requests to
+// WebSockets, and sends the updates of the authenticated user as JSON
+// text messages.  Messages from clients are ignored.
+//
+// `checkOrigin` decides if cross-origin requests are acceptable; if
+// `nil`, only same-origin requests are accepted.
+func (h *Hub) WebSocketHandler(auth Authenticator, checkOrigin func(*http.Request) bool) http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: checkOrigin}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := auth(r)
+		if err != nil || uid <= 0 {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		sub, err := h.Subscribe(uid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer sub.Close()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already replied.
+			return
+		}
+		defer conn.Close()
+
+		// Read (and discard) incoming messages, so that control
+		// frames are processed and closure is detected.
+		done := make(chan struct{})
+		conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		})
+		go func() {
+			defer close(done)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+
+			case <-ticker.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+				if err != nil {
+					return
+				}
+
+			case u, ok := <-sub.C:
+				if !ok {
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscription ended"),
+						time.Now().Add(writeWait))
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err = conn.WriteJSON(u); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
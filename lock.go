@@ -0,0 +1,139 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Lock is a named lock, held by one owner across all the instances of
+// an application that share a database.  It is a lease: unless
+// refreshed, it expires after its time-to-live, so that the locks of
+// crashed instances are taken over eventually.
+type Lock struct {
+	Name    string    `json:"Name"`    // Name of this lock
+	Owner   string    `json:"Owner"`   // Random token identifying this holder
+	Expires time.Time `json:"Expires"` // Expiry time of the lease, as per the database
+}
+
+// Unexported type, only for convenience methods.
+type _Locks struct{}
+
+// Locks provides a resource-like interface to distributed locks.
+var Locks _Locks
+
+// lockSecs answers the given time-to-live in whole seconds, rounded
+// up.
+func lockSecs(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// Acquire acquires the lock having the given name, for the given
+// time-to-live of at least a second.  It answers `ErrLockHeld` if
+// another owner holds an unexpired lease on it.  Expiry is judged by
+// the clock of the database, so that the clocks of the instances need
+// not agree.
+//
+// Holders doing work that can outlast the time-to-live should refresh
+// the lock periodically, and stop upon `ErrLockLost`.
+func (_Locks) Acquire(name string, ttl time.Duration) (*Lock, error) {
+	var v validator
+	name = strings.TrimSpace(name)
+	v.required("name", name)
+	if ttl < time.Second {
+		v.fail("ttl", "should be at least a second")
+	}
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	l := &Lock{Name: name, Owner: hex.EncodeToString(buf)}
+
+	err := withTx(nil, func(tx *sql.Tx) error {
+		// N.B. MySQL applies the assignments in order; `expires` is
+		// tested before it is updated.
+		q := `
+		INSERT INTO wf_locks(name, owner, expires, ctime)
+		VALUES(?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND), NOW())
+		ON DUPLICATE KEY UPDATE
+			owner = IF(expires <= NOW(), VALUES(owner), owner),
+			expires = IF(owner = VALUES(owner), VALUES(expires), expires),
+			ctime = IF(owner = VALUES(owner), VALUES(ctime), ctime)
+		`
+		if _, err := tx.Exec(q, name, l.Owner, lockSecs(ttl)); err != nil {
+			return err
+		}
+
+		var owner string
+		err := tx.QueryRow(`SELECT owner, expires FROM wf_locks WHERE name = ?`, name).Scan(&owner, &l.Expires)
+		if err != nil {
+			return err
+		}
+		if owner != l.Owner {
+			return ErrLockHeld
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Refresh extends the lease on this lock to the given time-to-live
+// from now.  It answers `ErrLockLost` if the lease expired already;
+// the lock may have been acquired by another owner since.
+func (l *Lock) Refresh(ttl time.Duration) error {
+	if ttl < time.Second {
+		return newError(CodeValidation, "ttl should be at least a second")
+	}
+
+	return withTx(nil, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_locks
+		SET expires = DATE_ADD(NOW(), INTERVAL ? SECOND)
+		WHERE name = ?
+		AND owner = ?
+		AND expires > NOW()
+		`
+		if _, err := tx.Exec(q, lockSecs(ttl), l.Name, l.Owner); err != nil {
+			return err
+		}
+
+		// An unchanged row is not counted as affected; hence, this
+		// check.
+		q = `SELECT expires FROM wf_locks WHERE name = ? AND owner = ? AND expires > NOW()`
+		err := tx.QueryRow(q, l.Name, l.Owner).Scan(&l.Expires)
+		if err == sql.ErrNoRows {
+			return ErrLockLost
+		}
+		return err
+	})
+}
+
+// Release releases this lock, if it is still held by this owner.
+func (l *Lock) Release() error {
+	_, err := db.Exec(`DELETE FROM wf_locks WHERE name = ? AND owner = ?`, l.Name, l.Owner)
+	return err
+}
@@ -0,0 +1,145 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// LogLevel enumerates the severities of log entries.
+type LogLevel uint8
+
+// The following are the defined log levels, in increasing order of
+// severity.
+const (
+	// LogDebug : detailed information for troubleshooting
+	LogDebug LogLevel = iota + 1
+	// LogInfo : noteworthy, but normal, occurrences
+	LogInfo
+	// LogWarn : unexpected conditions that `flow` recovers from
+	LogWarn
+	// LogError : failures that need attention
+	LogError
+)
+
+// String answers the name of this level.
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+
+	case LogInfo:
+		return "INFO"
+
+	case LogWarn:
+		return "WARN"
+
+	case LogError:
+		return "ERROR"
+
+	default:
+		return fmt.Sprintf("LEVEL(%d)", l)
+	}
+}
+
+// LogField is a single key-value pair of structured context attached
+// to a log entry.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// F is a shorthand for constructing a `LogField`.
+func F(key string, value interface{}) LogField {
+	return LogField{Key: key, Value: value}
+}
+
+// Logger receives the log entries of `flow`.  Applications can adapt
+// their logging library of choice to this interface, and register it
+// using `SetLogger`.
+//
+// Implementations should be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+// StdLogger is a `Logger` that writes to a standard library logger,
+// in a `key=value` format.  Entries below `MinLevel` are discarded.
+type StdLogger struct {
+	Out      *log.Logger
+	MinLevel LogLevel
+}
+
+// Log implements `Logger`.
+func (l *StdLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	if level < l.MinLevel {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&buf, " %s=%v", f.Key, f.Value)
+	}
+	l.Out.Output(2, buf.String())
+}
+
+// NopLogger discards all log entries.
+type NopLogger struct{}
+
+// Log implements `Logger`.
+func (NopLogger) Log(level LogLevel, msg string, fields ...LogField) {}
+
+var logMu sync.RWMutex
+var logger Logger = &StdLogger{
+	Out:      log.New(os.Stderr, "flow: ", log.LstdFlags|log.Lmicroseconds),
+	MinLevel: LogInfo,
+}
+
+// SetLogger registers the logger to which `flow` sends its log
+// entries.  A `nil` logger discards all entries.
+//
+// By default, entries at `LogInfo` and above are written to the
+// standard error.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NopLogger{}
+	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	logger = l
+}
+
+// Log sends an entry to the logger registered using `SetLogger`.  The
+// packages serving `flow` over the network use this, so that their
+// entries reach the same logger.
+func Log(level LogLevel, msg string, fields ...LogField) {
+	writeLog(level, msg, fields...)
+}
+
+// writeLog sends an entry to the registered logger.
+func writeLog(level LogLevel, msg string, fields ...LogField) {
+	logMu.RLock()
+	l := logger
+	logMu.RUnlock()
+	l.Log(level, msg, fields...)
+}
@@ -15,9 +15,11 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"math"
+	"strings"
+	"time"
 )
 
 // Mailbox is the message delivery destination for both action and
@@ -40,30 +42,35 @@ type _Mailboxes struct {
 // Mailboxes is the singleton instance of `_Mailboxes`.
 var Mailboxes _Mailboxes
 
+func init() {
+	registerTimer("snoozes", resurfaceSnoozed)
+}
+
 // CountByUser answers the number of messages in the given user's
 // virtual mailbox. Specifying `true` for `unread` fetches a count of
-// unread messages.
+// unread messages.  Snoozed messages are not counted.
 func (_Mailboxes) CountByUser(uid UserID, unread bool) (int64, error) {
 	if uid <= 0 {
-		return 0, errors.New("user ID should be a positive integer")
+		return 0, newError(CodeValidation, "user ID should be a positive integer")
 	}
 
 	q := `
-	SELECT COUNT(id)
-	FROM wf_mailboxes
-	WHERE group_id = (
+	SELECT COUNT(mbs.id)
+	FROM wf_mailboxes mbs
+	WHERE mbs.group_id = (
 		SELECT gm.id
 		FROM wf_groups_master gm
 		JOIN wf_group_users gu ON gu.group_id = gm.id
 		WHERE gu.user_id = ?
-		AND gm.group_type = 'S'
+		AND gm.group_type = ` + GroupSingleton.sql() + `
 	)
+	AND ` + notSnoozed + `
 	`
 	if unread {
-		q += `AND unread = 1`
+		q += `AND mbs.unread = 1`
 	}
 
-	row := db.QueryRow(q, uid)
+	row := readDB().QueryRow(q, uid)
 	var n int64
 	err := row.Scan(&n)
 	if err != nil {
@@ -75,22 +82,23 @@ func (_Mailboxes) CountByUser(uid UserID, unread bool) (int64, error) {
 
 // CountByGroup answers the number of messages in the given group's
 // virtual mailbox. Specifying `true` for `unread` fetches a count of
-// unread messages.
+// unread messages.  Snoozed messages are not counted.
 func (_Mailboxes) CountByGroup(gid GroupID, unread bool) (int64, error) {
 	if gid <= 0 {
-		return 0, errors.New("group ID should be a positive integer")
+		return 0, newError(CodeValidation, "group ID should be a positive integer")
 	}
 
 	q := `
-	SELECT COUNT(id)
-	FROM wf_mailboxes
-	WHERE group_id = ?
+	SELECT COUNT(mbs.id)
+	FROM wf_mailboxes mbs
+	WHERE mbs.group_id = ?
+	AND ` + notSnoozed + `
 	`
 	if unread {
-		q += `AND unread = 1`
+		q += `AND mbs.unread = 1`
 	}
 
-	row := db.QueryRow(q, gid)
+	row := readDB().QueryRow(q, gid)
 	var n int64
 	err := row.Scan(&n)
 	if err != nil {
@@ -100,25 +108,223 @@ func (_Mailboxes) CountByGroup(gid GroupID, unread bool) (int64, error) {
 	return n, nil
 }
 
+// CountForUserAllGroups answers the number of messages in the
+// mailboxes of all the groups that the given user is a member of,
+// including the user's singleton group, as listed by
+// `ListForUserAllGroups`.  Specifying `true` for `unread` fetches a
+// count of messages unread by the user.
+func (_Mailboxes) CountForUserAllGroups(uid UserID, unread bool) (int64, error) {
+	if uid <= 0 {
+		return 0, newError(CodeValidation, "user ID should be a positive integer")
+	}
+
+	q := `
+	SELECT COUNT(mbs.id)
+	FROM wf_mailboxes mbs
+	WHERE mbs.group_id IN (
+		SELECT gu.group_id
+		FROM wf_group_users gu
+		WHERE gu.user_id = ?
+	)
+	AND ` + notSnoozed + `
+	`
+	args := []interface{}{uid}
+	if unread {
+		q += `AND ` + unreadByUser() + ` = 1`
+		args = append(args, uid)
+	}
+
+	row := readDB().QueryRow(q, args...)
+	var n int64
+	err := row.Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// UnreadCount is the number of unread messages in a mailbox, about
+// documents of a type, or about a single document.
+type UnreadCount struct {
+	DocType DocType    `json:"DocType"`         // Document type of the messages
+	DocID   DocumentID `json:"DocID,omitempty"` // Document of the messages, when counted per document
+	Unread  int64      `json:"Unread"`          // Number of unread messages
+}
+
+// CountsByUser answers the numbers of unread messages in the given
+// user's virtual mailbox, per document type.  Specifying `true` for
+// `perDoc` breaks them down further per document.  Document types and
+// documents having no unread messages are not answered, and snoozed
+// messages are not counted.
+func (_Mailboxes) CountsByUser(uid UserID, perDoc bool) ([]*UnreadCount, error) {
+	if uid <= 0 {
+		return nil, newError(CodeValidation, "user ID should be a positive integer")
+	}
+
+	// Grouping by a literal would be taken as a column position.
+	dcol, group := `0`, `msgs.doctype_id, dtm.name`
+	if perDoc {
+		dcol = `msgs.doc_id`
+		group += `, msgs.doc_id`
+	}
+	q := `
+	SELECT msgs.doctype_id, dtm.name, ` + dcol + `, COUNT(mbs.id)
+	FROM wf_mailboxes mbs
+	JOIN wf_messages msgs ON msgs.id = mbs.message_id
+	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	WHERE mbs.group_id = (
+		SELECT gm.id
+		FROM wf_groups_master gm
+		JOIN wf_group_users gu ON gu.group_id = gm.id
+		WHERE gu.user_id = ?
+		AND gm.group_type = ` + GroupSingleton.sql() + `
+	)
+	AND mbs.unread = 1
+	AND ` + notSnoozed + `
+	GROUP BY ` + group + `
+	ORDER BY ` + group + `
+	`
+	rows, err := readDB().Query(q, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*UnreadCount, 0, 4)
+	for rows.Next() {
+		var elem UnreadCount
+		err = rows.Scan(&elem.DocType.ID, &elem.DocType.Name, &elem.DocID, &elem.Unread)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// MailboxesListInput specifies a set of filter conditions to narrow
+// down mailbox listings.  Time filters apply to the posting time of
+// the notifications, enabling incremental synchronisation.
+//
+// Snoozed messages are not listed, unless asked for explicitly.  Those
+// whose snooze has ended are listed even before the timer pump
+// resurfaces them.
+type MailboxesListInput struct {
+	Unread        bool      // List only unread messages
+	Snoozed       bool      // List only snoozed messages
+	CtimeStarting time.Time // List messages posted at or after this time
+	CtimeBefore   time.Time // List messages posted before this time
+
+	user UserID // Member as whom to reckon the unread status, if any
+}
+
+// forUser answers a copy of this specification, reckoning the unread
+// status of messages as seen by the given member of their groups.
+func (input *MailboxesListInput) forUser(uid UserID) *MailboxesListInput {
+	cp := MailboxesListInput{}
+	if input != nil {
+		cp = *input
+	}
+	cp.user = uid
+	return &cp
+}
+
+// unread answers the SQL expression of the unread status of the
+// mailbox entry `mbs` under this specification, together with its
+// arguments.
+func (input *MailboxesListInput) unread() (string, []interface{}) {
+	if input == nil || input.user <= 0 {
+		return `mbs.unread`, nil
+	}
+	return unreadByUser(), []interface{}{input.user}
+}
+
+// notSnoozed is the SQL condition of the mailbox entry `mbs` not being
+// snoozed now.  Listings and counts share it, so that they agree.
+const notSnoozed = `(mbs.snoozed_until IS NULL OR mbs.snoozed_until <= NOW())`
+
+// unreadByUser answers the SQL condition of the mailbox entry `mbs`
+// being unread by the user given as its argument.  Messages in a
+// singleton mailbox follow the `unread` flag of the mailbox.  Messages
+// in a group's mailbox are unread by each member until that member
+// reads them (see `SetReadByUser`), irrespective of the others.
+func unreadByUser() string {
+	return `(CASE
+		WHEN (SELECT ugm.group_type FROM wf_groups_master ugm WHERE ugm.id = mbs.group_id) = ` + GroupSingleton.sql() + `
+		THEN mbs.unread = 1
+		ELSE NOT EXISTS (
+			SELECT 1
+			FROM wf_mailbox_reads mrs
+			WHERE mrs.group_id = mbs.group_id
+			AND mrs.message_id = mbs.message_id
+			AND mrs.user_id = ?
+		)
+	END)`
+}
+
+// where answers the additional filter conditions of this
+// specification, together with their arguments.
+func (input *MailboxesListInput) where() (string, []interface{}) {
+	if input == nil {
+		input = &MailboxesListInput{}
+	}
+
+	where := []string{}
+	args := []interface{}{}
+
+	if input.Unread {
+		cond, cargs := input.unread()
+		where = append(where, cond+` = 1`)
+		args = append(args, cargs...)
+	}
+
+	if input.Snoozed {
+		where = append(where, `mbs.snoozed_until > NOW()`)
+	} else {
+		where = append(where, notSnoozed)
+	}
+
+	if !input.CtimeStarting.IsZero() {
+		where = append(where, `mbs.ctime >= ?`)
+		args = append(args, input.CtimeStarting)
+	}
+
+	if !input.CtimeBefore.IsZero() {
+		where = append(where, `mbs.ctime < ?`)
+		args = append(args, input.CtimeBefore)
+	}
+
+	return `AND ` + strings.Join(where, ` AND `) + `
+	`, args
+}
+
 // ListByUser answers a list of the messages in the given user's
-// virtual mailbox, as per the given specification.
+// virtual mailbox, as per the given specification.  A `nil` input
+// lists all messages.
 //
 // Result set begins with ID >= `offset`, and has not more than
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
-func (_Mailboxes) ListByUser(uid UserID, offset, limit int64, unread bool) ([]*Notification, error) {
+func (_Mailboxes) ListByUser(uid UserID, input *MailboxesListInput, offset, limit int64) ([]*Notification, error) {
 	if uid <= 0 {
-		return nil, errors.New("user ID should be a positive integer")
+		return nil, newError(CodeValidation, "user ID should be a positive integer")
 	}
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
 	}
 
+	input = input.forUser(uid)
+	ucol, args := input.unread()
 	q := `
-	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
+	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.thread_id, msgs.title, msgs.data, ` + ucol + `, mbs.ctime
 	FROM wf_messages msgs
 	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
 	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
@@ -127,18 +333,19 @@ func (_Mailboxes) ListByUser(uid UserID, offset, limit int64, unread bool) ([]*N
 		FROM wf_groups_master gm
 		JOIN wf_group_users gu ON gu.group_id = gm.id
 		WHERE gu.user_id = ?
-		AND gm.group_type = 'S'
+		AND gm.group_type = ` + GroupSingleton.sql() + `
 	)
 	`
-	if unread {
-		q += `AND mbs.unread = 1`
-	}
-	q += `
+	where, wargs := input.where()
+	q += where + `
 	ORDER BY msgs.id
 	LIMIT ? OFFSET ?
 	`
+	args = append(args, uid)
+	args = append(args, wargs...)
+	args = append(args, limit, offset)
 
-	rows, err := db.Query(q, uid, limit, offset)
+	rows, err := readDB().Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -148,7 +355,7 @@ func (_Mailboxes) ListByUser(uid UserID, offset, limit int64, unread bool) ([]*N
 	for rows.Next() {
 		var elem Notification
 		err = rows.Scan(&elem.GroupID, &elem.Message.ID, &elem.Message.DocType.ID,
-			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event,
+			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event, &elem.Message.Thread,
 			&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
 		if err != nil {
 			return nil, err
@@ -163,38 +370,39 @@ func (_Mailboxes) ListByUser(uid UserID, offset, limit int64, unread bool) ([]*N
 }
 
 // ListByGroup answers a list of the messages in the given group's
-// virtual mailbox, as per the given specification.
+// virtual mailbox, as per the given specification.  A `nil` input
+// lists all messages.
 //
 // Result set begins with ID >= `offset`, and has not more than
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
-func (_Mailboxes) ListByGroup(gid GroupID, offset, limit int64, unread bool) ([]*Notification, error) {
+func (_Mailboxes) ListByGroup(gid GroupID, input *MailboxesListInput, offset, limit int64) ([]*Notification, error) {
 	if gid <= 0 {
-		return nil, errors.New("group ID should be a positive integer")
+		return nil, newError(CodeValidation, "group ID should be a positive integer")
 	}
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
 	}
 
 	q := `
-	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
+	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.thread_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
 	FROM wf_messages msgs
 	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
 	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
 	WHERE mbs.group_id = ?
 	`
-	if unread {
-		q += `AND mbs.unread = 1`
-	}
-	q += `
+	where, wargs := input.where()
+	q += where + `
 	ORDER BY msgs.id
 	LIMIT ? OFFSET ?
 	`
+	args := append([]interface{}{gid}, wargs...)
+	args = append(args, limit, offset)
 
-	rows, err := db.Query(q, gid, limit, offset)
+	rows, err := readDB().Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -204,7 +412,78 @@ func (_Mailboxes) ListByGroup(gid GroupID, offset, limit int64, unread bool) ([]
 	for rows.Next() {
 		var elem Notification
 		err = rows.Scan(&elem.GroupID, &elem.Message.ID, &elem.Message.DocType.ID,
-			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event,
+			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event, &elem.Message.Thread,
+			&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// ListForUserAllGroups answers a list of the messages in the
+// mailboxes of all the groups that the given user is a member of,
+// including the user's singleton group, as per the given
+// specification.  Each notification is annotated with its owning
+// group.  A `nil` input lists all messages.
+//
+// A message delivered to more than one of these groups appears once
+// per group.  Messages in the mailboxes of groups are listed as unread
+// until the user reads them (see `SetReadByUser`).
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_Mailboxes) ListForUserAllGroups(uid UserID, input *MailboxesListInput, offset, limit int64) ([]*Notification, error) {
+	if uid <= 0 {
+		return nil, newError(CodeValidation, "user ID should be a positive integer")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	input = input.forUser(uid)
+	ucol, args := input.unread()
+	q := `
+	SELECT mbs.group_id, gm.name, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.thread_id, msgs.title, msgs.data, ` + ucol + `, mbs.ctime
+	FROM wf_messages msgs
+	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
+	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	JOIN wf_groups_master gm ON gm.id = mbs.group_id
+	WHERE mbs.group_id IN (
+		SELECT gu.group_id
+		FROM wf_group_users gu
+		WHERE gu.user_id = ?
+	)
+	`
+	where, wargs := input.where()
+	q += where + `
+	ORDER BY msgs.id, mbs.group_id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, uid)
+	args = append(args, wargs...)
+	args = append(args, limit, offset)
+
+	rows, err := readDB().Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*Notification, 0, 10)
+	for rows.Next() {
+		var elem Notification
+		err = rows.Scan(&elem.GroupID, &elem.GroupName, &elem.Message.ID, &elem.Message.DocType.ID,
+			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event, &elem.Message.Thread,
 			&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
 		if err != nil {
 			return nil, err
@@ -218,27 +497,81 @@ func (_Mailboxes) ListByGroup(gid GroupID, offset, limit int64, unread bool) ([]
 	return ary, nil
 }
 
+// ListByDocument answers the conversation formed by all the messages
+// about the given document, in the given group's mailbox.  The thread
+// carries the current title and state of the document.
+func (_Mailboxes) ListByDocument(gid GroupID, dtype DocTypeID, docID DocumentID) (*MessageThread, error) {
+	if gid <= 0 || dtype <= 0 || docID <= 0 {
+		return nil, newError(CodeValidation, "all identifiers should be positive integers")
+	}
+
+	doc, err := Documents.Get(nil, dtype, docID)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.thread_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
+	FROM wf_messages msgs
+	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
+	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	WHERE mbs.group_id = ?
+	AND msgs.doctype_id = ?
+	AND msgs.doc_id = ?
+	ORDER BY msgs.id
+	`
+	rows, err := readDB().Query(q, gid, dtype, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	th := &MessageThread{
+		DocType:       doc.DocType,
+		DocID:         doc.ID,
+		Title:         doc.Title,
+		State:         doc.State,
+		Notifications: make([]*Notification, 0, 10),
+	}
+	for rows.Next() {
+		var elem Notification
+		err = rows.Scan(&elem.GroupID, &elem.Message.ID, &elem.Message.DocType.ID,
+			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event, &elem.Message.Thread,
+			&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
+		if err != nil {
+			return nil, err
+		}
+		th.Thread = elem.Message.Thread
+		th.Notifications = append(th.Notifications, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return th, nil
+}
+
 // GetMessage answers the requested message from the given user's
 // virtual mailbox.
 func (_Mailboxes) GetMessage(msgID MessageID) (*Notification, error) {
 	if msgID <= 0 {
-		return nil, errors.New("message ID should be positive integers")
+		return nil, newError(CodeValidation, "message ID should be positive integers")
 	}
 
 	q := `
-	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
+	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.thread_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
 	FROM wf_messages msgs
 	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
 	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
 	WHERE mbs.id = ?
 	`
-	row := db.QueryRow(q, msgID)
+	row := readDB().QueryRow(q, msgID)
 	var elem Notification
 	err := row.Scan(&elem.GroupID, &elem.Message.ID, &elem.Message.DocType.ID,
-		&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event,
+		&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event, &elem.Message.Thread,
 		&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrMessageNotFound)
 	}
 
 	return &elem, nil
@@ -249,124 +582,346 @@ func (_Mailboxes) GetMessage(msgID MessageID) (*Notification, error) {
 // mailbox.
 func (_Mailboxes) ReassignMessage(otx *sql.Tx, fgid, tgid GroupID, msgID MessageID) error {
 	if fgid <= 0 || tgid <= 0 || msgID <= 0 {
-		return errors.New("all identifiers should be positive integers")
+		return newError(CodeValidation, "all identifiers should be positive integers")
 	}
 	if fgid == tgid {
 		return nil
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_mailboxes SET group_id = ?, unread = 1
+		WHERE group_id = ?
+		AND message_id = ?
+		`
+		_, err := tx.Exec(q, tgid, fgid, msgID)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `
-	UPDATE wf_mailboxes SET group_id = ?, unread = 1
-	WHERE group_id = ?
-	AND message_id = ?
-	`
-	_, err = tx.Exec(q, tgid, fgid, msgID)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	return nil
+}
+
+// SetStatusByUser sets the `unread` status of the given message as
+// per input specification.
+func (_Mailboxes) SetStatusByUser(otx *sql.Tx, uid UserID, msgID MessageID, status bool) error {
+	if uid <= 0 || msgID <= 0 {
+		return newError(CodeValidation, "all identifiers should be positive integers")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_mailboxes SET unread = ?
+		WHERE group_id = (
+			SELECT gm.id
+			FROM wf_groups_master gm
+			JOIN wf_group_users gu ON gu.group_id = gm.id
+			WHERE gu.user_id = ?
+			AND gm.group_type = ` + GroupSingleton.sql() + `
+		)
+		AND message_id = ?
+		`
+		_, err := tx.Exec(q, status, uid, msgID)
 		if err != nil {
 			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// SetStatusByUser sets the `unread` status of the given message as
-// per input specification.
-func (_Mailboxes) SetStatusByUser(otx *sql.Tx, uid UserID, msgID MessageID, status bool) error {
-	if uid <= 0 || msgID <= 0 {
-		return errors.New("all identifiers should be positive integers")
+// SetStatusByGroup sets the `unread` status of the given message as
+// per input specification.  This status is shared by all the members
+// of the group; listings for a member reckon that member's own reads
+// instead (see `SetReadByUser`).
+func (_Mailboxes) SetStatusByGroup(otx *sql.Tx, gid GroupID, msgID MessageID, status bool) error {
+	if gid <= 0 || msgID <= 0 {
+		return newError(CodeValidation, "all identifiers should be positive integers")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_mailboxes SET unread = ?
+		WHERE group_id = ?
+		AND message_id = ?
+		`
+		_, err := tx.Exec(q, status, gid, msgID)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	q := `
-	UPDATE wf_mailboxes SET unread = ?
-	WHERE group_id = (
-		SELECT gm.id
-		FROM wf_groups_master gm
-		JOIN wf_group_users gu ON gu.group_id = gm.id
-		WHERE gu.user_id = ?
-		AND gm.group_type = 'S'
-	)
-	AND message_id = ?
-	`
-	_, err = tx.Exec(q, status, uid, msgID)
+	return nil
+}
+
+// SetStatusBulk sets the `unread` status of all the given messages in
+// the given group's mailbox, using a single statement.
+func (_Mailboxes) SetStatusBulk(otx *sql.Tx, gid GroupID, msgIDs []MessageID, status bool) error {
+	if gid <= 0 {
+		return newError(CodeValidation, "group ID should be a positive integer")
+	}
+	if len(msgIDs) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(msgIDs)+2)
+	args = append(args, status, gid)
+	for _, msgID := range msgIDs {
+		if msgID <= 0 {
+			return newError(CodeValidation, "all identifiers should be positive integers")
+		}
+		args = append(args, msgID)
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_mailboxes SET unread = ?
+		WHERE group_id = ?
+		AND message_id IN (?` + strings.Repeat(",?", len(msgIDs)-1) + `)
+		`
+		_, err := tx.Exec(q, args...)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	return nil
+}
+
+// MarkAllRead marks all the unread messages in the given group's
+// mailbox as read, using a single statement.  If `before` is
+// non-zero, only messages posted before that time are marked.
+func (_Mailboxes) MarkAllRead(otx *sql.Tx, gid GroupID, before time.Time) error {
+	if gid <= 0 {
+		return newError(CodeValidation, "group ID should be a positive integer")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		var err error
+		q := `
+		UPDATE wf_mailboxes SET unread = 0
+		WHERE group_id = ?
+		AND unread = 1
+		`
+		if before.IsZero() {
+			_, err = tx.Exec(q, gid)
+		} else {
+			q += `AND ctime < ?`
+			_, err = tx.Exec(q, gid, before)
+		}
 		if err != nil {
 			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// SetStatusByGroup sets the `unread` status of the given message as
-// per input specification.
-func (_Mailboxes) SetStatusByGroup(otx *sql.Tx, gid GroupID, msgID MessageID, status bool) error {
+// Snooze hides the given message in the given group's mailbox from
+// default listings, and marks it read, until the given time.  The
+// timer pump (see `RunTimers`) then resurfaces it as unread.  A zero
+// time resurfaces a snoozed message right away.
+//
+// Only messages in a user's singleton mailbox can be snoozed.  Members
+// of other groups track reading independently (see `SetReadByUser`),
+// so a snooze of a shared mailbox would hide the message, and mark it
+// read, for all of them.
+func (_Mailboxes) Snooze(otx *sql.Tx, gid GroupID, msgID MessageID, until time.Time) error {
 	if gid <= 0 || msgID <= 0 {
-		return errors.New("all identifiers should be positive integers")
+		return newError(CodeValidation, "all identifiers should be positive integers")
+	}
+	if !until.IsZero() && !until.After(time.Now()) {
+		return newError(CodeValidation, "snooze time should be in the future")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	return withTx(otx, func(tx *sql.Tx) error {
+		gtype, err := groupType(tx.QueryRow, gid)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
+		if gtype != GroupSingleton {
+			return newError(CodeValidation, "only messages in a user's own mailbox can be snoozed")
+		}
+
+		if until.IsZero() {
+			q := `
+			UPDATE wf_mailboxes SET unread = 1, snoozed_until = NULL
+			WHERE group_id = ?
+			AND message_id = ?
+			AND snoozed_until IS NOT NULL
+			`
+			_, err := tx.Exec(q, gid, msgID)
+			return err
+		}
 
+		q := `
+		UPDATE wf_mailboxes SET unread = 0, snoozed_until = ?
+		WHERE group_id = ?
+		AND message_id = ?
+		`
+		res, err := tx.Exec(q, until, gid, msgID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return nil
+		}
+		// Nothing changed, if it was snoozed until the same time.
+		var one int
+		q = `SELECT 1 FROM wf_mailboxes WHERE group_id = ? AND message_id = ?`
+		return notFound(tx.QueryRow(q, gid, msgID).Scan(&one), ErrMessageNotFound)
+	})
+}
+
+// resurfaceSnoozed is the timer task of snoozed messages.  It marks
+// those whose snooze has ended unread, and lists them again.
+func resurfaceSnoozed(ctx context.Context) (int, error) {
 	q := `
-	UPDATE wf_mailboxes SET unread = ?
-	WHERE group_id = ?
-	AND message_id = ?
+	UPDATE wf_mailboxes SET unread = 1, snoozed_until = NULL
+	WHERE snoozed_until <= NOW()
 	`
-	_, err = tx.Exec(q, status, gid, msgID)
+	res, err := db.ExecContext(ctx, q)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
 
-	if otx == nil {
-		err = tx.Commit()
+// SetReadByUser records whether the given member of a group has seen
+// the given message in that group's mailbox.
+//
+// Unlike `SetStatusByGroup`, this does not alter the shared `unread`
+// flag of the mailbox; each member tracks reading independently, and
+// `ListForUserAllGroups` and `CountForUserAllGroups` reflect it.
+func (_Mailboxes) SetReadByUser(otx *sql.Tx, gid GroupID, uid UserID, msgID MessageID, read bool) error {
+	if gid <= 0 || uid <= 0 || msgID <= 0 {
+		return newError(CodeValidation, "all identifiers should be positive integers")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		var q string
+		if read {
+			q = `
+			INSERT IGNORE INTO wf_mailbox_reads(group_id, message_id, user_id, ctime)
+			SELECT mbs.group_id, mbs.message_id, gu.user_id, NOW()
+			FROM wf_mailboxes mbs
+			JOIN wf_group_users gu ON gu.group_id = mbs.group_id
+			WHERE mbs.group_id = ?
+			AND gu.user_id = ?
+			AND mbs.message_id = ?
+			`
+		} else {
+			q = `
+			DELETE FROM wf_mailbox_reads
+			WHERE group_id = ?
+			AND user_id = ?
+			AND message_id = ?
+			`
+		}
+		_, err := tx.Exec(q, gid, uid, msgID)
 		if err != nil {
 			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
+
+// CountUnreadByGroupUser answers the number of messages in the given
+// group's mailbox that the given member of the group has not yet
+// seen.  Snoozed messages are not counted.
+func (_Mailboxes) CountUnreadByGroupUser(gid GroupID, uid UserID) (int64, error) {
+	if gid <= 0 || uid <= 0 {
+		return 0, newError(CodeValidation, "group ID and user ID should be positive integers")
+	}
+
+	q := `
+	SELECT COUNT(mbs.id)
+	FROM wf_mailboxes mbs
+	LEFT JOIN wf_mailbox_reads mrs ON mrs.group_id = mbs.group_id
+		AND mrs.message_id = mbs.message_id
+		AND mrs.user_id = ?
+	WHERE mbs.group_id = ?
+	AND mrs.id IS NULL
+	AND ` + notSnoozed + `
+	`
+	row := readDB().QueryRow(q, uid, gid)
+	var n int64
+	err := row.Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// ReadBy answers the members of the given group who have seen the
+// given message in that group's mailbox, in the order in which they
+// saw it.
+func (_Mailboxes) ReadBy(gid GroupID, msgID MessageID) ([]*MessageRead, error) {
+	if gid <= 0 || msgID <= 0 {
+		return nil, newError(CodeValidation, "group ID and message ID should be positive integers")
+	}
+
+	q := `
+	SELECT mrs.group_id, um.id, um.first_name, um.last_name, um.email, um.active, mrs.ctime
+	FROM wf_mailbox_reads mrs
+	JOIN wf_users_master um ON um.id = mrs.user_id
+	WHERE mrs.group_id = ?
+	AND mrs.message_id = ?
+	ORDER BY mrs.ctime, mrs.id
+	`
+	rows, err := readDB().Query(q, gid, msgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*MessageRead, 0, 4)
+	for rows.Next() {
+		var elem MessageRead
+		err = rows.Scan(&elem.GroupID, &elem.User.ID, &elem.User.FirstName, &elem.User.LastName,
+			&elem.User.Email, &elem.User.Active, &elem.Ctime)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
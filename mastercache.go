@@ -0,0 +1,155 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of master data held in the cache.
+const (
+	masterDocTypes   = "doctype"
+	masterDocStates  = "docstate"
+	masterDocActions = "docaction"
+	masterRoles      = "role"
+)
+
+// masterEntry is a single cached value, with its expiry time.
+type masterEntry struct {
+	val     interface{}
+	expires time.Time
+}
+
+// masterCache holds document types, states, actions and roles, which
+// change rarely, but are looked up constantly.  Entries are keyed by
+// kind, and by ID or name.  Values should be stored and answered by
+// value, so that callers cannot alter cached entries.
+//
+// Kinds altered in a transaction of the caller are not filled again
+// for the duration of an entry, since the cache cannot know when such
+// a transaction is committed.
+type masterCache struct {
+	mu       sync.RWMutex
+	ttl      time.Duration
+	entries  map[string]masterEntry
+	settling map[string]time.Time // Kinds not to be filled until the given times
+}
+
+var masters = &masterCache{ttl: 5 * time.Minute, entries: map[string]masterEntry{}, settling: map[string]time.Time{}}
+
+// SetMasterCacheTTL sets the duration for which document types,
+// states, actions and roles read from the database are retained in
+// memory.  A duration of `0` disables the cache.  The default is five
+// minutes.
+//
+// The cache is invalidated by the methods of `flow` that alter these,
+// in this process, once the change is committed.  Changes made in a
+// transaction of the caller are not cached again for this duration.  Changes made by other processes, or directly in
+// the database, are seen after at most this duration, or upon calling
+// `InvalidateMasterCache`.
+func SetMasterCacheTTL(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	masters.mu.Lock()
+	defer masters.mu.Unlock()
+	masters.ttl = d
+	if d == 0 {
+		masters.entries = map[string]masterEntry{}
+	}
+}
+
+// InvalidateMasterCache discards all cached document types, states,
+// actions and roles.
+func InvalidateMasterCache() {
+	masters.mu.Lock()
+	defer masters.mu.Unlock()
+	masters.entries = map[string]masterEntry{}
+}
+
+// masterKey answers the cache key of the given kind and ID or name.
+func masterKey(kind string, key interface{}) string {
+	return fmt.Sprintf("%s:%T:%v", kind, key, key)
+}
+
+// get answers the cached value for the given key, if it has not
+// expired.
+func (c *masterCache) get(kind string, key interface{}) (interface{}, bool) {
+	k := masterKey(kind, key)
+	c.mu.RLock()
+	e, ok := c.entries[k]
+	c.mu.RUnlock()
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.val, true
+}
+
+// put retains the given value under each of the given keys.
+func (c *masterCache) put(kind string, val interface{}, keys ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl == 0 {
+		return
+	}
+	now := time.Now()
+	if until, ok := c.settling[kind]; ok {
+		if now.Before(until) {
+			return
+		}
+		delete(c.settling, kind)
+	}
+	exp := now.Add(c.ttl)
+	for _, key := range keys {
+		c.entries[masterKey(kind, key)] = masterEntry{val: val, expires: exp}
+	}
+}
+
+// invalidate discards all cached values of the given kind.  Since a
+// rename changes the name key of an entry, while its old name may be
+// reused, entries are invalidated by kind, rather than individually.
+func (c *masterCache) invalidate(kind string) {
+	prefix := kind + ":"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// changed discards all cached values of the given kind, after a change
+// to them made in the given transaction.  It should be deferred by the
+// method making the change, so that it runs after a transaction begun
+// by that method has been committed.  A transaction of the caller is
+// committed later still; until then, concurrent readers see the values
+// before the change, which should not be cached.  Hence, such a kind is
+// not filled again for the duration of an entry.
+func (c *masterCache) changed(otx *sql.Tx, kind string) {
+	c.invalidate(kind)
+	if otx == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settling[kind] = time.Now().Add(c.ttl)
+}
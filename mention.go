@@ -0,0 +1,194 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// reMention matches mentions of the form `@user@example.com`, not
+	// preceded by a word character, so that plain e-mail addresses do
+	// not match.
+	reMention = regexp.MustCompile(`(?:^|[^\w.@])@([\w.%+\-]+@[\w\-]+(?:\.[\w\-]+)+)`)
+)
+
+// Mention records that a user was mentioned in the text of an event.
+//
+// The message posted upon the application of that event is delivered
+// to the mailbox of every user mentioned in it, in addition to its
+// usual recipients.
+type Mention struct {
+	User    UserID    `json:"User"`    // The user who was mentioned
+	Author  GroupID   `json:"Author"`  // Singleton group of the user who mentioned
+	Message Message   `json:"Message"` // Message posted upon the application of the event
+	Unread  bool      `json:"Unread"`  // Is the message still not read by the mentioned user?
+	Ctime   time.Time `json:"Ctime"`   // Time of the mention
+}
+
+// ParseMentions answers the distinct e-mail addresses mentioned in the
+// given text, in the order of their first occurrence.  Addresses are
+// answered in lower case.
+func ParseMentions(text string) []string {
+	seen := map[string]bool{}
+	ary := []string{}
+	for _, m := range reMention.FindAllStringSubmatch(text, -1) {
+		email := strings.ToLower(strings.TrimRight(m[1], "."))
+		if !seen[email] {
+			seen[email] = true
+			ary = append(ary, email)
+		}
+	}
+	return ary
+}
+
+// Unexported type, only for convenience methods.
+type _Mentions struct{}
+
+// Mentions provides a resource-like interface to the mentions of users
+// in the text of document events.
+var Mentions _Mentions
+
+// ListByUser answers the mentions of the given user, latest first.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_Mentions) ListByUser(uid UserID, offset, limit int64) ([]*Mention, error) {
+	if uid <= 0 {
+		return nil, newError(CodeValidation, "user ID should be a positive integer")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT mns.user_id, mns.author_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.thread_id, msgs.title, msgs.data, mbs.unread, mns.ctime
+	FROM wf_mentions mns
+	JOIN wf_messages msgs ON msgs.id = mns.message_id
+	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	JOIN wf_mailboxes mbs ON mbs.message_id = mns.message_id AND mbs.group_id = mns.group_id
+	WHERE mns.user_id = ?
+	ORDER BY mns.id DESC
+	LIMIT ? OFFSET ?
+	`
+	rows, err := readDB().Query(q, uid, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*Mention, 0, 10)
+	for rows.Next() {
+		var elem Mention
+		err = rows.Scan(&elem.User, &elem.Author, &elem.Message.ID, &elem.Message.DocType.ID,
+			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event, &elem.Message.Thread,
+			&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// CountByUser answers the number of mentions of the given user.  If
+// `unread` is `true`, only those not read yet are counted.
+func (_Mentions) CountByUser(uid UserID, unread bool) (int64, error) {
+	if uid <= 0 {
+		return 0, newError(CodeValidation, "user ID should be a positive integer")
+	}
+
+	q := `
+	SELECT COUNT(*)
+	FROM wf_mentions mns
+	JOIN wf_mailboxes mbs ON mbs.message_id = mns.message_id AND mbs.group_id = mns.group_id
+	WHERE mns.user_id = ?
+	`
+	if unread {
+		q += `AND mbs.unread = 1`
+	}
+	var n int64
+	if err := readDB().QueryRow(q, uid).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// resolve answers the singleton groups of the active users mentioned
+// in the text of the given event, other than its author, mapped to
+// those users.
+func (_Mentions) resolve(otx *sql.Tx, event *DocEvent) (map[GroupID]UserID, error) {
+	res := map[GroupID]UserID{}
+	emails := ParseMentions(event.Text)
+	if len(emails) == 0 {
+		return res, nil
+	}
+
+	q := `
+	SELECT gm.id, um.id
+	FROM wf_users_master um
+	JOIN wf_group_users gu ON gu.user_id = um.id
+	JOIN wf_groups_master gm ON gm.id = gu.group_id
+	WHERE gm.group_type = ` + GroupSingleton.sql() + `
+	AND um.active = 1
+	AND um.email IN ` + inPlaceholders(len(emails))
+	args := make([]interface{}, 0, len(emails))
+	for _, email := range emails {
+		args = append(args, email)
+	}
+	rows, err := otx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gid, uid int64
+		if err = rows.Scan(&gid, &uid); err != nil {
+			return nil, err
+		}
+		if GroupID(gid) != event.Group {
+			res[GroupID(gid)] = UserID(uid)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// record notes the mentions of the given users in the given event,
+// whose message has been posted to their mailboxes.
+func (_Mentions) record(otx *sql.Tx, msg *Message, event *DocEvent, mentioned map[GroupID]UserID) error {
+	rows := make([][]interface{}, 0, len(mentioned))
+	for gid, uid := range mentioned {
+		rows = append(rows, []interface{}{msg.ID, gid, uid, event.Group})
+	}
+	q := `INSERT INTO wf_mentions(message_id, group_id, user_id, author_id, ctime) VALUES`
+	return insertRows(otx, q, `(?, ?, ?, ?, NOW())`, rows)
+}
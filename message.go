@@ -32,18 +32,39 @@ type Message struct {
 	DocType `json:"DocType"` // Document type of the associated document
 	DocID   DocumentID       `json:"DocID"`    // Document in the workflow
 	Event   DocEventID       `json:"DocEvent"` // Event that triggered this message
+	Thread  MessageID        `json:"Thread"`   // First message of the conversation on this document
 	Title   string           `json:"Title"`    // Subject of this message
 	Data    string           `json:"Data"`     // Body of this message
 }
 
+// MessageThread is the conversation formed by all the messages about
+// a single document, in a given mailbox.
+type MessageThread struct {
+	Thread        MessageID       `json:"Thread"`        // First message of this conversation
+	DocType       DocType         `json:"DocType"`       // Document type of the associated document
+	DocID         DocumentID      `json:"DocID"`         // Document being discussed
+	Title         string          `json:"Title"`         // Current title of the document
+	State         DocState        `json:"DocState"`      // Current state of the document
+	Notifications []*Notification `json:"Notifications"` // Messages in this conversation, oldest first
+}
+
 // Notification tracks the 'unread' status of a message in a mailbox.
 //
 // Since a single message can be delivered to multiple mailboxes, the
 // 'unread' status cannot be associated with a message.  Instead,
 // `Notification` is the entity that tracks it per mailbox.
 type Notification struct {
-	GroupID `json:"Group"`   // The group whose mailbox this notification is in
-	Message `json:"Message"` // The underlying message
-	Unread  bool             `json:"Unread"` // Status flag reflecting if the message is still not read
-	Ctime   time.Time        `json:"Ctime"`  // Time when this notification was posted
+	GroupID   `json:"Group"`   // The group whose mailbox this notification is in
+	Message   `json:"Message"` // The underlying message
+	GroupName string           `json:"GroupName,omitempty"` // Name of the owning group, when listing across groups
+	Unread    bool             `json:"Unread"`              // Status flag reflecting if the message is still not read
+	Ctime     time.Time        `json:"Ctime"`               // Time when this notification was posted
+}
+
+// MessageRead records that a particular member of a group has seen a
+// message delivered to that group's mailbox.
+type MessageRead struct {
+	GroupID `json:"Group"` // The group whose mailbox holds the message
+	User    User           `json:"User"`  // Member who has seen the message
+	Ctime   time.Time      `json:"Ctime"` // Time when the message was first seen
 }
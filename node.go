@@ -16,8 +16,8 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
-	"log"
+	"sort"
+	"strings"
 )
 
 // NodeID is the type of unique identifiers of nodes.
@@ -65,6 +65,7 @@ type Node struct {
 	Wflow    WorkflowID      `json:"Workflow"`                // Containing flow of this node
 	Name     string          `json:"Name"`                    // Unique within its workflow
 	NodeType NodeType        `json:"NodeType"`                // Topology type of this node
+	Template string          `json:"Template,omitempty"`      // Message template used for notifications, if any
 	nfunc    NodeFunc        // Processing function of this node
 }
 
@@ -97,15 +98,26 @@ func (n *Node) Func() NodeFunc {
 
 // applyEvent checks to see if the given event can be applied
 // successfully.  Accordingly, it prepares a message by utilising the
-// registered node function, and posts it to applicable mailboxes.
-func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (DocStateID, error) {
+// registered node function, and posts it to applicable mailboxes.  The
+// message posted, and its recipients, are noted in `out`.
+func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID, out *EventOutcome) (DocStateID, error) {
+	out.Node = n.ID
+
 	ts, err := n.Transitions()
 	if err != nil {
 		return 0, err
 	}
 	tstate, ok := ts[event.Action]
+	if ok && event.Target > 0 {
+		return 0, newError(CodeValidation, "only return actions can have targets")
+	}
 	if !ok {
-		return 0, ErrWorkflowInvalidAction
+		// A return action sends the document to the chosen earlier
+		// state.
+		tstate, err = n.returnTarget(otx, event)
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	// Check document's current state.
@@ -113,8 +125,30 @@ func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (D
 	if err != nil {
 		return 0, err
 	}
+	// A document waiting at a fork progresses along its branches.
+	var br *Branch
 	if doc.State.ID != event.State {
-		return 0, ErrDocEventStateMismatch
+		br, err = activeBranch(otx, event.DocType, event.DocID, event.State)
+		if err != nil {
+			return 0, err
+		}
+		if br == nil {
+			return 0, ErrDocEventStateMismatch
+		}
+	}
+	if err = authorizeEvent(otx, doc, event); err != nil {
+		return 0, err
+	}
+	// A document waiting at an external node moves only on behalf of
+	// the external system.
+	if n.NodeType == NodeTypeExternal {
+		if err = ExternalTasks.checkActor(otx, n, event); err != nil {
+			return 0, err
+		}
+	}
+	// Actions awaiting ad-hoc approvals are blocked.
+	if err = checkAdHocSteps(otx, event); err != nil {
+		return 0, err
 	}
 
 	// Document has already transitioned.  So, we note that the event
@@ -123,7 +157,7 @@ func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (D
 	// N.B. This has implications for `NodeTypeJoinAny` below.  Should
 	// you alter this logic or its position, verify that the
 	// corresponding logic in the switch below is in coherence.
-	if doc.State.ID == tstate {
+	if br == nil && doc.State.ID == tstate {
 		err = n.recordEvent(otx, event, tstate, true)
 		if err != nil {
 			return 0, err
@@ -138,7 +172,32 @@ func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (D
 		return 0, err
 	}
 
+	// Hold the event back, should the target node be at capacity.  It
+	// remains pending, and is applied once capacity frees up.
+	if br == nil {
+		queued, err := checkNodeCapacity(otx, tnode, event, recipients)
+		if err != nil {
+			return 0, err
+		}
+		if queued {
+			return event.State, nil
+		}
+	}
+
+	// Registered validators can veto the transition.
+	if err = validateTransition(otx, doc, event, tstate); err != nil {
+		return 0, err
+	}
+
 	switch tnode.NodeType {
+	case NodeTypeJoinAll:
+		// Multiple 'in's, and all are required.
+
+		// Each branch of the fork waits here for the others; see
+		// `Branch.advance` below.  A document that did not fork
+		// passes through, as it does through `NodeTypeJoinAny`.
+		fallthrough
+
 	case NodeTypeJoinAny:
 		// Multiple 'in's, but any one suffices.
 
@@ -147,17 +206,72 @@ func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (D
 		// far, the event can be applied.
 		fallthrough
 
-	case NodeTypeBegin, NodeTypeEnd, NodeTypeLinear, NodeTypeBranch:
+	case NodeTypeBegin, NodeTypeEnd, NodeTypeLinear, NodeTypeBranch, NodeTypeExternal, NodeTypeVote,
+		NodeTypeFork:
 		// Any node type having a single 'in'.
 
+		// Move the branch, should the event be on one.  The document
+		// itself moves only when the branches have all joined.
+		moved := true
+		if br != nil {
+			moved, err = br.advance(otx, tnode)
+			if err != nil {
+				return 0, err
+			}
+		}
+
 		// Update the document to transition the state.
 		tacid := tnode.AccCtx
-		if tacid == 0 {
+		if tacid == 0 || !moved {
 			tacid = doc.AccCtx.ID
 		}
-		err = Documents.setState(otx, event.DocType, event.DocID, tstate, tacid)
-		if err != nil {
-			return 0, err
+		if moved {
+			err = Documents.setState(otx, event.DocType, event.DocID, tstate, tacid)
+			if err != nil {
+				return 0, err
+			}
+			err = cancelReminders(otx, event.DocType, event.DocID)
+			if err != nil {
+				return 0, err
+			}
+			err = ExternalTasks.cancel(otx, event.DocType, event.DocID)
+			if err != nil {
+				return 0, err
+			}
+			err = closeVotes(otx, event.DocType, event.DocID)
+			if err != nil {
+				return 0, err
+			}
+			err = lapseAdHocSteps(otx, event.DocType, event.DocID)
+			if err != nil {
+				return 0, err
+			}
+			err = cancelAutoActions(otx, event.DocType, event.DocID)
+			if err != nil {
+				return 0, err
+			}
+			err = scheduleAutoAction(otx, tnode, event.DocID)
+			if err != nil {
+				return 0, err
+			}
+			err = stopSLA(otx, event.DocType, event.DocID)
+			if err != nil {
+				return 0, err
+			}
+			err = scheduleSLA(otx, tnode, event.DocID, event.ID)
+			if err != nil {
+				return 0, err
+			}
+			err = enqueueNodeWebhooks(otx, n, tnode, doc, event, tstate, tacid)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if moved && tnode.NodeType == NodeTypeFork {
+			err = spawnBranches(otx, event.DocID, tnode)
+			if err != nil {
+				return 0, err
+			}
 		}
 
 		// Record event application.
@@ -165,6 +279,16 @@ func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (D
 		if err != nil {
 			return 0, err
 		}
+		err = enqueueTransitionListeners(otx, event, tstate)
+		if err != nil {
+			return 0, err
+		}
+
+		// Enqueue webhook deliveries.
+		err = Webhooks.enqueue(otx, doc, event, tstate, tacid)
+		if err != nil {
+			return 0, err
+		}
 
 		// Post messages.
 		recv := make(map[GroupID]struct{})
@@ -172,46 +296,128 @@ func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (D
 			recv[gid] = struct{}{}
 		}
 		msg := n.nfunc(doc, event)
+		if n.Template != "" {
+			data, err := templateData(otx, doc, event, tstate)
+			if err != nil {
+				return 0, err
+			}
+			msg.Title, msg.Data, err = Templates.Render(n.Template, tmplDefLocale, data)
+			if err != nil {
+				return 0, err
+			}
+		}
 		recv, err = tnode.determineRecipients(otx, recv, doc, event, tacid)
 		if err != nil {
 			return 0, err
 		}
+		// Users mentioned in the event's text receive the message, too.
+		mentioned, err := Mentions.resolve(otx, event)
+		if err != nil {
+			return 0, err
+		}
+		for gid := range mentioned {
+			recv[gid] = struct{}{}
+		}
+		// Groups watching searches that the document newly matches
+		// are notified, too.
+		subs, err := Subscriptions.matches(otx, doc, event.State, doc.AccCtx.ID, tstate, tacid)
+		if err != nil {
+			return 0, err
+		}
+		for _, gid := range subs {
+			recv[gid] = struct{}{}
+		}
+		// Users who muted notifications about this type of documents
+		// are omitted.
+		muted, err := NotificationPrefs.muted(otx, event.DocType, recv)
+		if err != nil {
+			return 0, err
+		}
+		for gid := range muted {
+			delete(recv, gid)
+			delete(mentioned, gid)
+		}
 		// It is legal to not have any recipients, too.
 		if len(recv) > 0 {
 			err = n.postMessage(otx, msg, recv)
 			if err != nil {
 				return 0, err
 			}
+			out.Messages = append(out.Messages, msg.ID)
+			for gid := range recv {
+				out.Recipients = append(out.Recipients, gid)
+			}
+			sort.Slice(out.Recipients, func(i, j int) bool { return out.Recipients[i] < out.Recipients[j] })
+			if len(mentioned) > 0 {
+				err = Mentions.record(otx, msg, event, mentioned)
+				if err != nil {
+					return 0, err
+				}
+			}
+			if emailEnabled {
+				_, err = Outbox.Enqueue(otx, OutboxKindEmail, &emailEntry{Message: msg.ID})
+				if err != nil {
+					return 0, err
+				}
+			}
+			err = NotificationPrefs.enqueueWebhooks(otx, msg.ID, nil, 0)
+			if err != nil {
+				return 0, err
+			}
+			err = scheduleReminders(otx, tnode, msg)
+			if err != nil {
+				return 0, err
+			}
 		}
 
-	case NodeTypeJoinAll:
-		// Multiple 'in's, and all are required.
+		// Record the event for the event stream.
+		err = EventStream.record(otx, event, tstate, tacid, msg, recv)
+		if err != nil {
+			return 0, err
+		}
 
-		// TODO(js)
+		// Hand the task of an external node over to its system.
+		if tnode.NodeType == NodeTypeExternal {
+			err = ExternalTasks.start(otx, tnode, event.DocID)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		// Have the terminal-state hooks invoked, should the document
+		// have completed its workflow.
+		if tnode.NodeType == NodeTypeEnd {
+			err = enqueueTerminalHooks(otx, event)
+			if err != nil {
+				return 0, err
+			}
+		}
 
 	default:
-		log.Panicf("unknown node type encountered : %s\n", tnode.NodeType)
+		writeLog(LogError, "unknown node type encountered", F("node", tnode.ID), F("type", tnode.NodeType))
+		return 0, ErrWorkflowUnknownNodeType
 	}
 
 	return tstate, nil
 }
 
 // recordEvent writes a record stating that the given event has
-// successfully been applied to effect a document state transition.
+// successfully been applied by this node to effect a document state
+// transition, and when.
 func (n *Node) recordEvent(otx *sql.Tx, event *DocEvent, tstate DocStateID, statusOnly bool) error {
 	if !statusOnly {
 		q := `
-		INSERT INTO wf_docevent_application(doctype_id, doc_id, from_state_id, docevent_id, to_state_id)
-		VALUES(?, ?, ?, ?, ?)
+		INSERT INTO wf_docevent_application(doctype_id, doc_id, from_state_id, docevent_id, to_state_id, workflow_id, node_id, ctime)
+		VALUES(?, ?, ?, ?, ?, ?, ?, NOW())
 		`
-		_, err := otx.Exec(q, event.DocType, event.DocID, event.State, event.ID, tstate)
+		_, err := stmts.exec(otx, q, event.DocType, event.DocID, event.State, event.ID, tstate, n.Wflow, n.ID)
 		if err != nil {
 			return err
 		}
 	}
 
 	q := `UPDATE wf_docevents SET status = 'A' WHERE id = ?`
-	_, err := otx.Exec(q, event.ID)
+	_, err := stmts.exec(otx, q, event.ID)
 	if err != nil {
 		return err
 	}
@@ -233,7 +439,7 @@ func (n *Node) determineRecipients(otx *sql.Tx, recv map[GroupID]struct{}, doc *
 	ORDER BY group_id
 	LIMIT 1
 	`
-	rows, err := otx.Query(q, acid, event.Group)
+	rows, err := stmts.query(otx, q, acid, event.Group)
 	if err != nil {
 		return nil, err
 	}
@@ -258,7 +464,7 @@ func (n *Node) determineRecipients(otx *sql.Tx, recv map[GroupID]struct{}, doc *
 	WHERE doctype_id = ?
 	AND doc_id = ?
 	`
-	rows2, err := otx.Query(q2, doc.DocType.ID, doc.ID)
+	rows2, err := stmts.query(otx, q2, doc.DocType.ID, doc.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -282,35 +488,62 @@ func (n *Node) determineRecipients(otx *sql.Tx, recv map[GroupID]struct{}, doc *
 // postMessage posts the given message into the mailboxes of the
 // specified recipients.
 func (n *Node) postMessage(otx *sql.Tx, msg *Message, recv map[GroupID]struct{}) error {
-	// Record the message.
+	// Messages about the same document form a thread, identified by
+	// the first message posted about it.
 
 	q := `
-	INSERT INTO wf_messages(doctype_id, doc_id, docevent_id, title, data)
-	VALUES(?, ?, ?, ?, ?)
+	SELECT thread_id
+	FROM wf_messages
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	ORDER BY id
+	LIMIT 1
 	`
-	res, err := otx.Exec(q, msg.DocType.ID, msg.DocID, msg.Event, msg.Title, msg.Data)
+	var thread int64
+	row, err := stmts.queryRow(otx, q, msg.DocType.ID, msg.DocID)
 	if err != nil {
 		return err
 	}
-	var msgid int64
-	if msgid, err = res.LastInsertId(); err != nil {
+	err = row.Scan(&thread)
+	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
 
-	// Post it into applicable mailboxes.
+	// Record the message.
 
+	msgid, err := nextID(IDKindMessage)
+	if err != nil {
+		return err
+	}
 	q = `
-	INSERT INTO wf_mailboxes(group_id, message_id, unread, ctime)
-	VALUES(?, ?, 1, NOW())
+	INSERT INTO wf_messages(id, doctype_id, doc_id, docevent_id, thread_id, title, data)
+	VALUES(NULLIF(?, 0), ?, ?, ?, ?, ?, ?)
 	`
-	for gid := range recv {
-		res, err = otx.Exec(q, gid, msgid)
+	res, err := stmts.exec(otx, q, msgid, msg.DocType.ID, msg.DocID, msg.Event, thread, msg.Title, msg.Data)
+	if err != nil {
+		return err
+	}
+	if msgid, err = insertedID(msgid, res); err != nil {
+		return err
+	}
+	if thread == 0 {
+		thread = msgid
+		_, err = stmts.exec(otx, `UPDATE wf_messages SET thread_id = ? WHERE id = ?`, thread, msgid)
 		if err != nil {
 			return err
 		}
 	}
+	msg.ID = MessageID(msgid)
+	msg.Thread = MessageID(thread)
 
-	return nil
+	// Post it into applicable mailboxes.
+
+	rows := make([][]interface{}, 0, len(recv))
+	for gid := range recv {
+		rows = append(rows, []interface{}{gid, msgid})
+	}
+	q = `INSERT INTO wf_mailboxes(group_id, message_id, unread, ctime) VALUES`
+	return insertRows(otx, q, `(?, ?, 1, NOW())`, rows)
 }
 
 // Unexported type, only for convenience methods.
@@ -323,11 +556,11 @@ var Nodes _Nodes
 // List answers a list of the nodes comprising the given workflow.
 func (_Nodes) List(id WorkflowID) ([]*Node, error) {
 	q := `
-	SELECT id, doctype_id, docstate_id, workflow_id, name, type
+	SELECT id, doctype_id, docstate_id, ac_id, workflow_id, name, type, template_name
 	FROM wf_workflow_nodes
 	WHERE workflow_id = ?
 	`
-	rows, err := db.Query(q, id)
+	rows, err := readDB().Query(q, id)
 	if err != nil {
 		return nil, err
 	}
@@ -336,10 +569,18 @@ func (_Nodes) List(id WorkflowID) ([]*Node, error) {
 	ary := make([]*Node, 0, 5)
 	for rows.Next() {
 		var elem Node
-		err = rows.Scan(&elem.ID, &elem.DocType, &elem.State, &elem.Wflow, &elem.Name, &elem.NodeType)
+		var acID sql.NullInt64
+		var tmpl sql.NullString
+		err = rows.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType, &tmpl)
 		if err != nil {
 			return nil, err
 		}
+		if acID.Valid {
+			elem.AccCtx = AccessContextID(acID.Int64)
+		}
+		if tmpl.Valid {
+			elem.Template = tmpl.String
+		}
 		elem.nfunc = defNodeFunc
 		ary = append(ary, &elem)
 	}
@@ -353,24 +594,28 @@ func (_Nodes) List(id WorkflowID) ([]*Node, error) {
 // Get retrieves the requested node from the database.
 func (_Nodes) Get(id NodeID) (*Node, error) {
 	if id <= 0 {
-		return nil, errors.New("node ID must be a positive integer")
+		return nil, newError(CodeValidation, "node ID must be a positive integer")
 	}
 
 	var elem Node
 	var acID sql.NullInt64
+	var tmpl sql.NullString
 	q := `
-	SELECT id, doctype_id, docstate_id, ac_id, workflow_id, name, type
+	SELECT id, doctype_id, docstate_id, ac_id, workflow_id, name, type, template_name
 	FROM wf_workflow_nodes
 	WHERE id = ?
 	`
-	row := db.QueryRow(q, id)
-	err := row.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType)
+	row := readDB().QueryRow(q, id)
+	err := row.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType, &tmpl)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrNodeNotFound)
 	}
 	if acID.Valid {
 		elem.AccCtx = AccessContextID(acID.Int64)
 	}
+	if tmpl.Valid {
+		elem.Template = tmpl.String
+	}
 
 	elem.nfunc = defNodeFunc
 	return &elem, nil
@@ -381,21 +626,60 @@ func (_Nodes) Get(id NodeID) (*Node, error) {
 func (_Nodes) GetByState(dtype DocTypeID, state DocStateID) (*Node, error) {
 	var elem Node
 	var acID sql.NullInt64
+	var tmpl sql.NullString
 	q := `
-	SELECT id, doctype_id, docstate_id, ac_id, workflow_id, name, type
+	SELECT id, doctype_id, docstate_id, ac_id, workflow_id, name, type, template_name
 	FROM wf_workflow_nodes
 	WHERE doctype_id = ?
 	AND docstate_id = ?
 	`
-	row := db.QueryRow(q, dtype, state)
-	err := row.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType)
+	row, err := stmts.queryRow(nil, q, dtype, state)
 	if err != nil {
 		return nil, err
 	}
+	err = row.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType, &tmpl)
+	if err != nil {
+		return nil, notFound(err, ErrNodeNotFound)
+	}
 	if acID.Valid {
 		elem.AccCtx = AccessContextID(acID.Int64)
 	}
+	if tmpl.Valid {
+		elem.Template = tmpl.String
+	}
 
 	elem.nfunc = defNodeFunc
 	return &elem, nil
 }
+
+// SetTemplate attaches the named message template to the given node.
+// Notifications posted by this node are then rendered from that
+// template.  An empty name detaches the current template, if any.
+func (_Nodes) SetTemplate(otx *sql.Tx, id NodeID, name string) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	if id <= 0 {
+		return newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		var tmpl sql.NullString
+		name = strings.TrimSpace(name)
+		if name != "" {
+			tmpl = sql.NullString{String: name, Valid: true}
+		}
+		_, err := tx.Exec("UPDATE wf_workflow_nodes SET template_name = ? WHERE id = ?", tmpl, id)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
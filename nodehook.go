@@ -0,0 +1,293 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NodeWebhookID is the type of unique identifiers of node webhooks.
+type NodeWebhookID int64
+
+// NodeWebhook is an HTTP endpoint attached to a workflow node, to
+// which documents entering or leaving the node are delivered -- e.g.
+// so that a document entering `Dispatch` is handed over to the
+// logistics system directly.
+//
+// Node webhooks are independent of those registered using `Webhooks`.
+// Their deliveries are made by the outbox relay (see `RunOutbox`), only
+// after the transaction that moved the document commits, and are
+// retried as per the outbox configuration.  Deliveries are signed as
+// those of `Webhooks` are; see `WebhookSignatureHeader`.
+type NodeWebhook struct {
+	ID      NodeWebhookID `json:"ID"`      // Unique identifier of this webhook
+	Node    NodeID        `json:"Node"`    // Node to which this webhook is attached
+	URL     string        `json:"URL"`     // Endpoint to which transitions are POSTed
+	Secret  string        `json:"-"`       // Key used to sign payloads
+	OnEntry bool          `json:"OnEntry"` // Deliver documents entering the node?
+	OnExit  bool          `json:"OnExit"`  // Deliver documents leaving the node?
+	Active  bool          `json:"Active"`  // Is this webhook enabled?
+	Ctime   time.Time     `json:"Ctime"`   // Time of registration
+}
+
+// Points of a node at which its webhooks are invoked.
+const (
+	NodeWebhookEntry = "entry"
+	NodeWebhookExit  = "exit"
+)
+
+// NodeWebhookPayload is the JSON body POSTed to node webhooks.
+type NodeWebhookPayload struct {
+	Hook  NodeWebhookID `json:"Hook"`  // The invoked webhook
+	Node  NodeID        `json:"Node"`  // Node entered or left
+	Point string        `json:"Point"` // `entry` or `exit`
+	WebhookPayload
+}
+
+// nodeWebhookClient makes the deliveries of node webhooks.
+var nodeWebhookClient = &http.Client{Timeout: 30 * time.Second}
+
+func init() {
+	Outbox.SetHandler(OutboxKindNodeWebhook, deliverNodeWebhook)
+}
+
+// AddWebhook attaches a webhook with the given endpoint and signing
+// secret to the given node, and answers its ID.  At least one of
+// `onEntry` and `onExit` should be `true`.
+func (_Nodes) AddWebhook(otx *sql.Tx, id NodeID, endpoint, secret string, onEntry, onExit bool) (NodeWebhookID, error) {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return 0, err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	endpoint = strings.TrimSpace(endpoint)
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail("endpoint", "should be an absolute HTTP(S) URL")
+	}
+	if secret == "" {
+		v.fail("secret", "should be non-empty")
+	}
+	if !onEntry && !onExit {
+		v.fail("webhook", "should be invoked on entry, on exit, or on both")
+	}
+	if err = v.result(); err != nil {
+		return 0, err
+	}
+
+	var hid int64
+	err = withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_node_webhooks(node_id, url, secret, on_entry, on_exit, active, ctime)
+		VALUES(?, ?, ?, ?, ?, 1, NOW())
+		`
+		res, err := tx.Exec(q, id, endpoint, secret, onEntry, onExit)
+		if err != nil {
+			return err
+		}
+		hid, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return NodeWebhookID(hid), nil
+}
+
+// Webhooks answers the webhooks attached to the given node.
+func (_Nodes) Webhooks(id NodeID) ([]*NodeWebhook, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	q := `
+	SELECT id, node_id, url, secret, on_entry, on_exit, active, ctime
+	FROM wf_node_webhooks
+	WHERE node_id = ?
+	ORDER BY id
+	`
+	ary := []*NodeWebhook{}
+	err := scanEach(readDB().Query, q, []interface{}{id}, func(scan func(...interface{}) error) error {
+		var elem NodeWebhook
+		err := scan(&elem.ID, &elem.Node, &elem.URL, &elem.Secret, &elem.OnEntry, &elem.OnExit, &elem.Active, &elem.Ctime)
+		if err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// SetWebhookActive enables or disables the given node webhook.
+// Deliveries are not enqueued for disabled webhooks; those already
+// enqueued are dropped.
+func (_Nodes) SetWebhookActive(otx *sql.Tx, id NodeWebhookID, active bool) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+	if id <= 0 {
+		return newError(CodeValidation, "node webhook ID must be a positive integer")
+	}
+
+	res, err := stmts.exec(otx, "UPDATE wf_node_webhooks SET active = ? WHERE id = ?", active, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		row, err := stmts.queryRow(otx, "SELECT COUNT(*) FROM wf_node_webhooks WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if err = row.Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNodeWebhookNotFound
+		}
+	}
+	return nil
+}
+
+// RemoveWebhook detaches the given webhook from its node.  Deliveries
+// already enqueued for it are dropped.
+func (_Nodes) RemoveWebhook(otx *sql.Tx, id NodeWebhookID) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+	if id <= 0 {
+		return newError(CodeValidation, "node webhook ID must be a positive integer")
+	}
+
+	res, err := stmts.exec(otx, "DELETE FROM wf_node_webhooks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNodeWebhookNotFound
+	}
+	return nil
+}
+
+// enqueueNodeWebhooks records the deliveries to the exit webhooks of
+// the node `from`, and to the entry webhooks of the node `to`, for the
+// given event having moved its document between them.
+func enqueueNodeWebhooks(otx *sql.Tx, from, to *Node, doc *Document, event *DocEvent,
+	tstate DocStateID, acid AccessContextID) error {
+	q := `
+	SELECT id, node_id, IF(node_id = ? AND on_exit = 1, 'exit', 'entry')
+	FROM wf_node_webhooks
+	WHERE active = 1
+	AND ((node_id = ? AND on_exit = 1) OR (node_id = ? AND on_entry = 1))
+	ORDER BY id
+	`
+	type hook struct {
+		id    NodeWebhookID
+		node  NodeID
+		point string
+	}
+	ary := []hook{}
+	err := scanEach(otx.Query, q, []interface{}{from.ID, from.ID, to.ID}, func(scan func(...interface{}) error) error {
+		var elem hook
+		if err := scan(&elem.id, &elem.node, &elem.point); err != nil {
+			return err
+		}
+		ary = append(ary, elem)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, h := range ary {
+		p := &NodeWebhookPayload{
+			Hook:  h.id,
+			Node:  h.node,
+			Point: h.point,
+			WebhookPayload: WebhookPayload{
+				Event:     event.ID,
+				DocType:   event.DocType,
+				DocID:     event.DocID,
+				Title:     doc.Title,
+				Action:    event.Action,
+				Actor:     event.Group,
+				FromState: event.State,
+				ToState:   tstate,
+				AccCtx:    acid,
+				Comment:   event.Text,
+				Ctime:     event.Ctime,
+			},
+		}
+		if _, err = Outbox.Enqueue(otx, OutboxKindNodeWebhook, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverNodeWebhook is the outbox handler of node webhooks.  It POSTs
+// the entry's payload to the webhook's current endpoint, signed using
+// its current secret.
+func deliverNodeWebhook(ctx context.Context, tx *sql.Tx, e *OutboxEntry) error {
+	var p NodeWebhookPayload
+	if err := json.Unmarshal(e.Payload, &p); err != nil {
+		return err
+	}
+
+	var endpoint, secret string
+	var active bool
+	q := `SELECT url, secret, active FROM wf_node_webhooks WHERE id = ?`
+	err := tx.QueryRow(q, p.Hook).Scan(&endpoint, &secret, &active)
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		// Removed or disabled since.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(e.Payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Flow-Delivery", fmt.Sprintf("%d", e.ID))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(secret, e.Payload))
+
+	resp, err := nodeWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errorf(CodeInternal, "unexpected HTTP status : %s", resp.Status)
+	}
+	return nil
+}
@@ -33,6 +33,12 @@ const (
 	NodeTypeJoinAny = "joinany"
 	// NodeTypeJoinAll : two or more incoming, one outgoing
 	NodeTypeJoinAll = "joinall"
+	// NodeTypeExternal : one incoming, one or more outgoing; awaits an external task
+	NodeTypeExternal = "external"
+	// NodeTypeVote : one incoming, two or more outgoing; decided by votes
+	NodeTypeVote = "vote"
+	// NodeTypeFork : one incoming, two or more outgoing, all taken in parallel
+	NodeTypeFork = "fork"
 )
 
 // IsValidNodeType answers `true` if the given node type is a
@@ -40,7 +46,7 @@ const (
 func IsValidNodeType(ntype string) bool {
 	nt := NodeType(ntype)
 	switch nt {
-	case NodeTypeBegin, NodeTypeEnd, NodeTypeLinear, NodeTypeBranch, NodeTypeJoinAny, NodeTypeJoinAll:
+	case NodeTypeBegin, NodeTypeEnd, NodeTypeLinear, NodeTypeBranch, NodeTypeJoinAny, NodeTypeJoinAll, NodeTypeExternal, NodeTypeVote, NodeTypeFork:
 		return true
 
 	default:
@@ -0,0 +1,334 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// NotifyChannel is a set of channels through which a user is notified
+// of posted messages.
+type NotifyChannel uint8
+
+// Channels of notification.  They can be combined.
+const (
+	// ChannelMailbox : the user's mailbox; always included
+	ChannelMailbox NotifyChannel = 1 << iota
+	// ChannelEmail : e-mail, when e-mailing is enabled
+	ChannelEmail
+	// ChannelWebhook : an outbox entry of kind `OutboxKindNotify`
+	ChannelWebhook
+
+	channelsAll     = ChannelMailbox | ChannelEmail | ChannelWebhook
+	channelsDefault = ChannelMailbox | ChannelEmail
+)
+
+// OutboxKindNotify : notification of a posted message to one user
+// through the webhook channel.  The payload is a `NotifyEntry`.  No
+// handler is registered for it by `flow`; applications deliver these
+// entries to their endpoints using `Outbox.SetHandler`.
+const OutboxKindNotify = "flow.notify"
+
+// NotifyEntry is the outbox payload of a notification to a user through
+// the webhook channel.
+type NotifyEntry struct {
+	User     UserID    `json:"User"`               // Recipient user
+	Message  MessageID `json:"Message"`            // Message posted
+	Digest   bool      `json:"Digest"`             // Does the user prefer a digest?
+	Reminder int       `json:"Reminder,omitempty"` // Ordinal of the reminder, if this is one
+}
+
+// NotificationPref is the choice of a user of how to be notified of
+// messages about documents of a type.  A preference for the document
+// type `0` is the user's default, applicable to the types having no
+// preference of their own.
+//
+// In the absence of any preference, users are notified through their
+// mailboxes and e-mail, immediately.
+type NotificationPref struct {
+	User       UserID        `json:"User"`                 // User whose preference this is
+	DocType    DocTypeID     `json:"DocType"`              // Type of the documents; `0` for the default
+	Channels   NotifyChannel `json:"Channels"`             // Channels through which to notify
+	Digest     bool          `json:"Digest"`               // Combine e-mails periodically, rather than sending each immediately?
+	Muted      bool          `json:"Muted"`                // Suppress all notifications?
+	MutedUntil time.Time     `json:"MutedUntil,omitempty"` // End of the muting, if temporary
+}
+
+var digestMu sync.RWMutex
+var digestInterval = time.Hour
+
+// Unexported type, only for convenience methods.
+type _NotificationPrefs struct{}
+
+// NotificationPrefs provides a resource-like interface to the
+// notification preferences of users.
+//
+// Messages are posted to the mailboxes of recipient groups as usual.
+// Muted users are omitted, however, when a recipient is their singleton
+// group.  E-mails and webhook notifications are sent only to those
+// users of the recipient groups who chose the respective channels, and
+// are not muted.
+var NotificationPrefs _NotificationPrefs
+
+// SetDigestInterval sets the period over which e-mails to users
+// preferring digests are combined.  Such e-mails are held until the
+// end of the current period, counted from the Unix epoch in UTC.  The
+// default is one hour.
+func (_NotificationPrefs) SetDigestInterval(d time.Duration) {
+	if d <= 0 {
+		d = time.Hour
+	}
+	digestMu.Lock()
+	digestInterval = d
+	digestMu.Unlock()
+}
+
+// nextDigest answers the time at which e-mails being queued now for
+// digests are due.
+func (_NotificationPrefs) nextDigest() time.Time {
+	digestMu.RLock()
+	d := digestInterval
+	digestMu.RUnlock()
+	return time.Now().UTC().Truncate(d).Add(d)
+}
+
+// Set records the given preference, replacing any earlier one of the
+// same user for the same document type.
+func (_NotificationPrefs) Set(otx *sql.Tx, pref *NotificationPref) error {
+	var v validator
+	v.positive("User", int64(pref.User))
+	if pref.DocType < 0 {
+		v.fail("DocType", "should be a non-negative integer")
+	}
+	if pref.Channels&^channelsAll != 0 {
+		v.fail("Channels", "unknown channel")
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_notification_prefs(user_id, doctype_id, channels, digest, muted, muted_until, mtime)
+		VALUES(?, ?, ?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE channels = VALUES(channels), digest = VALUES(digest), muted = VALUES(muted),
+			muted_until = VALUES(muted_until), mtime = NOW()
+		`
+		_, err := tx.Exec(q, pref.User, pref.DocType, pref.Channels|ChannelMailbox, pref.Digest, pref.Muted,
+			sql.NullTime{Time: pref.MutedUntil, Valid: !pref.MutedUntil.IsZero()})
+		return err
+	})
+}
+
+// scanNotificationPref reads one preference from the given row.
+func scanNotificationPref(scan func(...interface{}) error) (*NotificationPref, error) {
+	var elem NotificationPref
+	var until sql.NullTime
+	err := scan(&elem.User, &elem.DocType, &elem.Channels, &elem.Digest, &elem.Muted, &until)
+	if err != nil {
+		return nil, err
+	}
+	if until.Valid {
+		elem.MutedUntil = until.Time
+	}
+	return &elem, nil
+}
+
+// Get answers the preference of the given user in effect for documents
+// of the given type: that of the type, if recorded; else, the user's
+// default, if recorded; else, the built-in default.
+func (_NotificationPrefs) Get(uid UserID, dtype DocTypeID) (*NotificationPref, error) {
+	if uid <= 0 {
+		return nil, newError(CodeValidation, "user ID should be a positive integer")
+	}
+
+	q := `
+	SELECT user_id, doctype_id, channels, digest, muted, muted_until
+	FROM wf_notification_prefs
+	WHERE user_id = ?
+	AND doctype_id IN (?, 0)
+	ORDER BY doctype_id DESC
+	LIMIT 1
+	`
+	elem, err := scanNotificationPref(readDB().QueryRow(q, uid, dtype).Scan)
+	switch {
+	case err == sql.ErrNoRows:
+		return &NotificationPref{User: uid, Channels: channelsDefault}, nil
+
+	case err != nil:
+		return nil, err
+	}
+	return elem, nil
+}
+
+// List answers the preferences recorded by the given user, the default
+// first.
+func (_NotificationPrefs) List(uid UserID) ([]*NotificationPref, error) {
+	if uid <= 0 {
+		return nil, newError(CodeValidation, "user ID should be a positive integer")
+	}
+
+	q := `
+	SELECT user_id, doctype_id, channels, digest, muted, muted_until
+	FROM wf_notification_prefs
+	WHERE user_id = ?
+	ORDER BY doctype_id
+	`
+	rows, err := readDB().Query(q, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*NotificationPref, 0, 4)
+	for rows.Next() {
+		elem, err := scanNotificationPref(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Delete removes the preference of the given user for the given
+// document type, so that the user's default applies to it again.
+func (_NotificationPrefs) Delete(otx *sql.Tx, uid UserID, dtype DocTypeID) error {
+	return withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_notification_prefs WHERE user_id = ? AND doctype_id = ?", uid, dtype)
+		return err
+	})
+}
+
+// prefJoins answers the joins of the preferences of the users `um` for
+// documents of the given type: `np` of the type itself, and `dp` the
+// default.  Either may be absent.
+func prefJoins(dtypeExpr string) string {
+	return `
+	LEFT JOIN wf_notification_prefs np ON np.user_id = um.id AND np.doctype_id = ` + dtypeExpr + `
+	LEFT JOIN wf_notification_prefs dp ON dp.user_id = um.id AND dp.doctype_id = 0
+	`
+}
+
+// Expressions over `prefJoins` evaluating the effective preference.
+// The columns of an absent preference are `NULL`, falling through to
+// the next.
+const (
+	prefChannels = `COALESCE(np.channels, dp.channels, 3)`
+	prefDigest   = `COALESCE(np.digest, dp.digest, 0)`
+	prefMuted    = `COALESCE(
+		np.muted = 1 AND (np.muted_until IS NULL OR np.muted_until > NOW()),
+		dp.muted = 1 AND (dp.muted_until IS NULL OR dp.muted_until > NOW()),
+		0)`
+)
+
+// muted answers those of the given groups that are singleton groups of
+// users who muted notifications about documents of the given type.
+func (_NotificationPrefs) muted(otx *sql.Tx, dtype DocTypeID, recv map[GroupID]struct{}) (map[GroupID]struct{}, error) {
+	res := map[GroupID]struct{}{}
+	if len(recv) == 0 {
+		return res, nil
+	}
+
+	q := `
+	SELECT gm.id
+	FROM wf_groups_master gm
+	JOIN wf_group_users gu ON gu.group_id = gm.id
+	JOIN wf_users_master um ON um.id = gu.user_id
+	` + prefJoins("?") + `
+	WHERE gm.group_type = ` + GroupSingleton.sql() + `
+	AND gm.id IN ` + inPlaceholders(len(recv)) + `
+	AND ` + prefMuted + ` = 1
+	`
+	args := make([]interface{}, 0, len(recv)+1)
+	args = append(args, dtype)
+	for gid := range recv {
+		args = append(args, gid)
+	}
+	rows, err := otx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gid int64
+		if err = rows.Scan(&gid); err != nil {
+			return nil, err
+		}
+		res[GroupID(gid)] = struct{}{}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// enqueueWebhooks records an outbox entry for each active user of the
+// recipient groups of the given message, who chose the webhook channel
+// and is not muted.  If groups are given, only their users are
+// considered.  A positive `reminder` marks the entries as reminders.
+func (_NotificationPrefs) enqueueWebhooks(otx *sql.Tx, mid MessageID, gids []GroupID, reminder int) error {
+	q := `
+	SELECT DISTINCT um.id, ` + prefDigest + `
+	FROM wf_mailboxes mbs
+	JOIN wf_messages msgs ON msgs.id = mbs.message_id
+	JOIN wf_group_users gus ON gus.group_id = mbs.group_id
+	JOIN wf_users_master um ON um.id = gus.user_id
+	` + prefJoins("msgs.doctype_id") + `
+	WHERE mbs.message_id = ?
+	AND um.active = 1
+	AND (` + prefChannels + ` & ?) <> 0
+	AND ` + prefMuted + ` = 0
+	`
+	args := []interface{}{mid, ChannelWebhook}
+	if len(gids) > 0 {
+		q += `AND mbs.group_id IN ` + inPlaceholders(len(gids))
+		for _, gid := range gids {
+			args = append(args, gid)
+		}
+	}
+	rows, err := otx.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	ary := []*NotifyEntry{}
+	for rows.Next() {
+		elem := &NotifyEntry{Message: mid, Reminder: reminder}
+		if err = rows.Scan(&elem.User, &elem.Digest); err != nil {
+			rows.Close()
+			return err
+		}
+		ary = append(ary, elem)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, elem := range ary {
+		if _, err = Outbox.Enqueue(otx, OutboxKindNotify, elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
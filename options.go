@@ -0,0 +1,107 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"strings"
+)
+
+// readOptions holds the optional settings of read APIs.
+type readOptions struct {
+	locale   string
+	user     UserID
+	tags     bool
+	blobs    bool
+	children bool
+	noData   bool
+}
+
+// ReadOption alters the behaviour of a read API.  Options not
+// applicable to a given API are ignored by it.
+type ReadOption func(*readOptions)
+
+// WithLocale requests that localised display labels be answered, in
+// the given locale, wherever they are available.
+func WithLocale(locale string) ReadOption {
+	return func(o *readOptions) {
+		o.locale = strings.TrimSpace(locale)
+	}
+}
+
+// AsUser requests that documents be answered as visible to the given
+// user, i.e. with the fields hidden from that user by `Redactions`
+// policies removed.
+func AsUser(uid UserID) ReadOption {
+	return func(o *readOptions) {
+		o.user = uid
+	}
+}
+
+// WithTags requests that the tags of documents be answered, too.
+func WithTags() ReadOption {
+	return func(o *readOptions) {
+		o.tags = true
+	}
+}
+
+// WithBlobs requests that the blobs of documents be answered, too.
+// Their names and checksums are answered; their contents are not.
+func WithBlobs() ReadOption {
+	return func(o *readOptions) {
+		o.blobs = true
+	}
+}
+
+// WithChildren requests that references to the children of documents
+// be answered, too.
+func WithChildren() ReadOption {
+	return func(o *readOptions) {
+		o.children = true
+	}
+}
+
+// WithoutData requests that the data of documents not be read, when
+// only their metadata are needed.
+func WithoutData() ReadOption {
+	return func(o *readOptions) {
+		o.noData = true
+	}
+}
+
+// applyReadOptions folds the given options into a settings value.
+func applyReadOptions(opts []ReadOption) *readOptions {
+	o := &readOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// localeCandidates answers the locales to try, in order, when looking
+// up a localised value: the full locale (`hi-IN`), its language (`hi`)
+// and the default (empty) locale.
+func localeCandidates(locale string) []string {
+	locale = strings.TrimSpace(locale)
+	cands := []string{}
+	if locale != "" {
+		cands = append(cands, locale)
+		if i := strings.IndexAny(locale, "-_"); i > 0 {
+			cands = append(cands, locale[:i])
+		}
+	}
+	return append(cands, "")
+}
@@ -0,0 +1,559 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of outbox entries written by `flow` itself.  Applications can
+// define their own kinds; these should not begin with `flow.`.
+const (
+	// OutboxKindStream : publication of an applied event to the event stream
+	OutboxKindStream = "flow.stream"
+	// OutboxKindEmail : e-mailing of a posted message
+	OutboxKindEmail = "flow.email"
+	// OutboxKindTerminal : invocation of a terminal-state hook
+	OutboxKindTerminal = "flow.terminal"
+	// OutboxKindCapacity : alert of a capacity limit exceeded; see `CapacityAlert`
+	OutboxKindCapacity = "flow.capacity"
+	// OutboxKindNodeWebhook : delivery of a document's entry into, or exit from, a node to a node webhook
+	OutboxKindNodeWebhook = "flow.node_webhook"
+	// OutboxKindTransition : invocation of a transition listener; see `Workflows.OnTransition`
+	OutboxKindTransition = "flow.transition"
+)
+
+// OutboxEntryID is the type of unique identifiers of outbox entries.
+type OutboxEntryID int64
+
+// OutboxStatus enumerates the states of an outbox entry.
+type OutboxStatus uint8
+
+const (
+	// OutboxStatusPending : not yet dispatched; may be awaiting a retry
+	OutboxStatusPending OutboxStatus = iota + 1
+	// OutboxStatusDone : successfully dispatched
+	OutboxStatusDone
+	// OutboxStatusFailed : abandoned after exhausting all attempts
+	OutboxStatusFailed
+)
+
+// OutboxEntry is a side effect recorded in the transaction that
+// caused it, to be dispatched after that transaction commits.
+type OutboxEntry struct {
+	ID          OutboxEntryID   `json:"ID"`          // Unique identifier; can be used by receivers to de-duplicate
+	Kind        string          `json:"Kind"`        // Determines the handler that dispatches this entry
+	Payload     json.RawMessage `json:"Payload"`     // Kind-specific data
+	Status      OutboxStatus    `json:"Status"`      // Current status of this entry
+	Attempts    int             `json:"Attempts"`    // Number of attempts made so far
+	NextAttempt time.Time       `json:"NextAttempt"` // Earliest time of the next attempt, if pending
+	LastError   string          `json:"LastError"`   // Error of the most recent failed attempt
+	Ctime       time.Time       `json:"Ctime"`       // Time when this entry was recorded
+	Mtime       time.Time       `json:"Mtime"`       // Time of the most recent status change
+}
+
+// OutboxHandler dispatches one outbox entry.
+//
+// The given transaction is committed together with the marking of the
+// entry as done.  Side effects within the database should, therefore,
+// use it; they then happen exactly once.  External side effects happen
+// at least once, since a crash can intervene between their effecting
+// and the commit.
+//
+// A handler that is not ready to dispatch the entry can answer
+// `ErrOutboxDeferred`; the entry is then retried later, without
+// counting it as a failed attempt.  Deferred entries are retried after
+// a delay as long as their age, up to `OutboxConfig.MaxBackoff`, so
+// that entries waiting for a handler do not crowd due ones out of the
+// batches of the relay.
+type OutboxHandler func(ctx context.Context, tx *sql.Tx, e *OutboxEntry) error
+
+// OutboxConfig holds the settings of the outbox relay.
+type OutboxConfig struct {
+	PollInterval time.Duration // Defaults to 1 second
+	MaxAttempts  int           // Defaults to 10
+	Backoff      time.Duration // Delay before the first retry; doubles thereafter.  Defaults to 10 seconds
+	MaxBackoff   time.Duration // Longest delay between attempts; defaults to 1 hour
+	BatchSize    int           // Entries dispatched per round; defaults to 100
+	OnError      func(error)   // Receives errors of the relay, if given
+}
+
+var outboxMu sync.RWMutex
+var outboxHandlers = map[string]OutboxHandler{}
+var outboxConfig OutboxConfig
+var outboxWake = make(chan struct{}, 1)
+
+// Unexported type, only for convenience methods.
+type _Outbox struct{}
+
+// Outbox provides a resource-like interface to the transactional
+// outbox of external side effects.
+//
+// Entries are written in the same transaction as the change that
+// causes them, and are dispatched by `RunOutbox` only after that
+// transaction commits.  Nothing is dispatched for a transaction that
+// rolls back.
+var Outbox _Outbox
+
+// SetHandler registers the handler for entries of the given kind.  A
+// `nil` handler unregisters the current one.  Entries of kinds having
+// no handler remain pending.
+func (_Outbox) SetHandler(kind string, h OutboxHandler) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	if h == nil {
+		delete(outboxHandlers, kind)
+		return
+	}
+	outboxHandlers[kind] = h
+}
+
+// handler answers the handler registered for the given kind, if any.
+func (_Outbox) handler(kind string) OutboxHandler {
+	outboxMu.RLock()
+	defer outboxMu.RUnlock()
+	return outboxHandlers[kind]
+}
+
+// SetConfig alters the settings of the outbox relay.  Zero values
+// select the defaults.
+func (_Outbox) SetConfig(c OutboxConfig) {
+	outboxMu.Lock()
+	outboxConfig = c
+	outboxMu.Unlock()
+}
+
+// config answers the effective settings of the outbox relay.
+func (_Outbox) config() OutboxConfig {
+	outboxMu.RLock()
+	c := outboxConfig
+	outboxMu.RUnlock()
+
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 10
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = 10 * time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = time.Hour
+	}
+	if c.MaxBackoff < c.Backoff {
+		c.MaxBackoff = c.Backoff
+	}
+	return c
+}
+
+// retryDelay answers the delay before retrying an entry that has
+// failed the given number of times: `Backoff`, doubled for each
+// further failure, up to `MaxBackoff`.
+func (c OutboxConfig) retryDelay(failures int) time.Duration {
+	d := c.Backoff
+	for i := 1; i < failures && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	return d
+}
+
+// deferDelay answers the delay before retrying a deferred entry of the
+// given age: the age itself, so that successive deferrals back off
+// exponentially, but at least `PollInterval`, and at most
+// `MaxBackoff`.
+func (c OutboxConfig) deferDelay(age time.Duration) time.Duration {
+	switch {
+	case age < c.PollInterval:
+		return c.PollInterval
+
+	case age > c.MaxBackoff:
+		return c.MaxBackoff
+	}
+	return age
+}
+
+// Enqueue records an entry of the given kind, with the JSON encoding
+// of `v` as its payload, in the given transaction.
+func (_Outbox) Enqueue(otx *sql.Tx, kind string, v interface{}) (OutboxEntryID, error) {
+	if otx == nil {
+		return 0, newError(CodeValidation, "outbox entries must be recorded in the causing transaction")
+	}
+	kind = strings.TrimSpace(kind)
+	if kind == "" {
+		return 0, newError(CodeValidation, "kind should be non-empty")
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	q := `
+	INSERT INTO wf_outbox(kind, payload, status, attempts, next_attempt, ctime, mtime)
+	VALUES(?, ?, 'P', 0, NOW(), NOW(), NOW())
+	`
+	res, err := otx.Exec(q, kind, string(payload))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return OutboxEntryID(id), nil
+}
+
+// Notify wakes up the relay running in this process, if it is
+// waiting.  Call this after committing a transaction that recorded
+// outbox entries, to have them dispatched without delay.
+func (_Outbox) Notify() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// Dispatch makes one round of dispatching due entries, in the order in
+// which they were recorded.  It answers the number of entries
+// successfully dispatched.
+//
+// Each entry is claimed before it is dispatched, so that multiple
+// relays can run concurrently without dispatching an entry twice.
+func (_Outbox) Dispatch(ctx context.Context) (int, error) {
+	c := Outbox.config()
+	args := []interface{}{}
+	outboxMu.RLock()
+	for kind := range outboxHandlers {
+		args = append(args, kind)
+	}
+	outboxMu.RUnlock()
+	if len(args) == 0 {
+		return 0, nil
+	}
+
+	q := `
+	SELECT id, kind, payload, attempts, ctime
+	FROM wf_outbox
+	WHERE status = 'P'
+	AND next_attempt <= NOW()
+	AND kind IN (?` + strings.Repeat(",?", len(args)-1) + `)
+	ORDER BY id
+	LIMIT ?
+	`
+	args = append(args, c.BatchSize)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	ary := make([]*OutboxEntry, 0, c.BatchSize)
+	for rows.Next() {
+		var elem OutboxEntry
+		var payload string
+		err = rows.Scan(&elem.ID, &elem.Kind, &payload, &elem.Attempts, &elem.Ctime)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		elem.Payload = json.RawMessage(payload)
+		elem.Status = OutboxStatusPending
+		ary = append(ary, &elem)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, elem := range ary {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		h := Outbox.handler(elem.Kind)
+		if h == nil {
+			continue
+		}
+
+		ok, err := Outbox.dispatchOne(ctx, c, h, elem)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// dispatchOne claims the given entry, and dispatches it using the
+// given handler.  It answers `true` if the entry was dispatched.
+// Handler failures are recorded against the entry, and are not
+// answered as errors.
+func (_Outbox) dispatchOne(ctx context.Context, c OutboxConfig, h OutboxHandler, elem *OutboxEntry) (bool, error) {
+	// Claim this entry for the duration of one backoff.
+	q := `
+	UPDATE wf_outbox
+	SET next_attempt = ?
+	WHERE id = ?
+	AND status = 'P'
+	AND attempts = ?
+	AND next_attempt <= NOW()
+	`
+	res, err := db.Exec(q, time.Now().Add(c.Backoff), elem.ID, elem.Attempts)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return false, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	herr := h(ctx, tx, elem)
+	if herr == nil {
+		q = `
+		UPDATE wf_outbox
+		SET status = 'D', attempts = attempts + 1, last_error = NULL, mtime = NOW()
+		WHERE id = ?
+		AND status = 'P'
+		`
+		res, err = tx.Exec(q, elem.ID)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return false, errorf(CodeConflict, "outbox entry %d : lost claim during dispatch", elem.ID)
+		}
+		if err = tx.Commit(); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	tx.Rollback()
+
+	if herr == ErrOutboxDeferred {
+		next := time.Now().Add(c.deferDelay(time.Since(elem.Ctime)))
+		_, err = db.Exec("UPDATE wf_outbox SET next_attempt = ? WHERE id = ?", next, elem.ID)
+		return false, err
+	}
+
+	status := "P"
+	level := LogWarn
+	if elem.Attempts+1 >= c.MaxAttempts {
+		status = "F"
+		level = LogError
+	}
+	writeLog(level, "outbox handler failed", F("entry", elem.ID), F("kind", elem.Kind), F("attempt", elem.Attempts+1), F("error", herr))
+	errText := herr.Error()
+	if len(errText) > 500 {
+		errText = errText[:500]
+	}
+	q = `
+	UPDATE wf_outbox
+	SET status = ?, attempts = attempts + 1, next_attempt = ?, last_error = ?, mtime = NOW()
+	WHERE id = ?
+	`
+	_, err = db.Exec(q, status, time.Now().Add(c.retryDelay(elem.Attempts+1)), errText, elem.ID)
+	return false, err
+}
+
+// RunOutbox relays outbox entries to their handlers, until the given
+// context is cancelled.  It polls for due entries as per the outbox
+// configuration, and also whenever `Outbox.Notify` is called.  Errors
+// are reported to `OutboxConfig.OnError`, if given, or logged
+// otherwise.  They do not stop the relay.
+func RunOutbox(ctx context.Context) error {
+	for {
+		for {
+			n, err := Outbox.Dispatch(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if fn := Outbox.config().OnError; fn != nil {
+					fn(err)
+				} else {
+					writeLog(LogError, "outbox relay failed", F("error", err))
+				}
+				break
+			}
+			if n == 0 {
+				break
+			}
+		}
+
+		timer := time.NewTimer(Outbox.config().PollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+
+		case <-outboxWake:
+			timer.Stop()
+
+		case <-timer.C:
+		}
+	}
+}
+
+// OutboxListInput specifies a set of filtering criteria on outbox
+// entries.
+type OutboxListInput struct {
+	Kind   string       // Entries of this kind are listed, if non-empty
+	Status OutboxStatus // Entries in this status are listed, if non-zero
+}
+
+// List answers a subset of the outbox entries, based on the input
+// specification.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_Outbox) List(input *OutboxListInput, offset, limit int64) ([]*OutboxEntry, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if input != nil {
+		if input.Kind != "" {
+			where = append(where, `kind = ?`)
+			args = append(args, input.Kind)
+		}
+		switch input.Status {
+		case 0:
+			// Nothing to do
+
+		case OutboxStatusPending:
+			where = append(where, `status = 'P'`)
+
+		case OutboxStatusDone:
+			where = append(where, `status = 'D'`)
+
+		case OutboxStatusFailed:
+			where = append(where, `status = 'F'`)
+
+		default:
+			return nil, newError(CodeValidation, "unknown outbox entry status")
+		}
+	}
+
+	q := `
+	SELECT id, kind, payload, status, attempts, next_attempt, last_error, ctime, mtime
+	FROM wf_outbox
+	`
+	if len(where) > 0 {
+		q += `WHERE ` + strings.Join(where, ` AND `)
+	}
+	q += `
+	ORDER BY id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+
+	rows, err := readDB().Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*OutboxEntry, 0, 10)
+	for rows.Next() {
+		var elem OutboxEntry
+		var payload, status string
+		var lerr sql.NullString
+		err = rows.Scan(&elem.ID, &elem.Kind, &payload, &status, &elem.Attempts,
+			&elem.NextAttempt, &lerr, &elem.Ctime, &elem.Mtime)
+		if err != nil {
+			return nil, err
+		}
+		elem.Payload = json.RawMessage(payload)
+		switch status {
+		case "P":
+			elem.Status = OutboxStatusPending
+
+		case "D":
+			elem.Status = OutboxStatusDone
+
+		case "F":
+			elem.Status = OutboxStatusFailed
+
+		default:
+			return nil, errorf(CodeInternal, "unknown outbox entry status : %s", status)
+		}
+		if lerr.Valid {
+			elem.LastError = lerr.String
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Replay resets the given failed or done entry, so that it is
+// dispatched afresh by the relay.
+func (_Outbox) Replay(otx *sql.Tx, id OutboxEntryID) error {
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_outbox
+		SET status = 'P', attempts = 0, next_attempt = NOW(), mtime = NOW()
+		WHERE id = ?
+		AND status IN ('D', 'F')
+		`
+		res, err := tx.Exec(q, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return newError(CodeNotFound, "no completed outbox entry with the given ID")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,330 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// QuotaID is the type of unique identifiers of quotas.
+type QuotaID int64
+
+// QuotaPeriod enumerates the periods over which quotas are counted.
+// Periods are calendar periods, as per the database's clock.
+type QuotaPeriod uint8
+
+const (
+	// QuotaDay : the current day
+	QuotaDay QuotaPeriod = iota + 1
+	// QuotaWeek : the current week, starting on Monday
+	QuotaWeek
+	// QuotaMonth : the current month
+	QuotaMonth
+)
+
+// quotaPeriods maps the quota periods to their representations in the
+// database.
+var quotaPeriods = map[QuotaPeriod]string{
+	QuotaDay:   "D",
+	QuotaWeek:  "W",
+	QuotaMonth: "M",
+}
+
+// quotaStart is the SQL expression answering the start of the current
+// period of a quota, whose period is in the column `period`.
+const quotaStart = `
+	CASE period
+	WHEN 'D' THEN CURDATE()
+	WHEN 'W' THEN DATE_SUB(CURDATE(), INTERVAL WEEKDAY(CURDATE()) DAY)
+	ELSE DATE_FORMAT(CURDATE(), '%Y-%m-01')
+	END`
+
+// Quota caps the number of open documents of a type that the members
+// of a group may create in a period.  Open documents are those not in
+// the state of an end node of their workflow.  A quota on a singleton
+// group applies to its user alone; one on a general group applies to
+// its members together.
+type Quota struct {
+	ID      QuotaID     `json:"ID"`      // Unique identifier of this quota
+	DocType DocTypeID   `json:"DocType"` // Type of the documents counted
+	Group   GroupID     `json:"Group"`   // Group whose members' documents are counted
+	Max     int         `json:"Max"`     // Maximum number of open documents created in a period
+	Period  QuotaPeriod `json:"Period"`  // Period over which documents are counted
+}
+
+// QuotaUsage is the consumption of a quota in its current period.
+type QuotaUsage struct {
+	Quota *Quota    `json:"Quota"` // The quota
+	Used  int       `json:"Used"`  // Number of open documents created in the period
+	Since time.Time `json:"Since"` // Start of the current period
+}
+
+// QuotaExceededError is answered by `Documents.New` when creating the
+// document would exceed a quota of its creator.  It matches
+// `ErrQuotaExceeded`, and has the code `CodeRateLimited`.
+type QuotaExceededError struct {
+	Quota QuotaID // Quota that would be exceeded
+	Group GroupID // Group of the quota
+	Max   int     // Limit of the quota
+	Used  int     // Open documents created in the current period
+}
+
+// Error implements the `error` interface.
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s : quota %d allows %d, used %d", ErrQuotaExceeded, e.Quota, e.Max, e.Used)
+}
+
+// Is answers `true` if the target is `ErrQuotaExceeded`, or its code.
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded || target == CodeRateLimited
+}
+
+// Unexported type, only for convenience methods.
+type _Quotas struct{}
+
+// Quotas provides a resource-like interface to the quotas on the
+// creation of documents.
+var Quotas _Quotas
+
+// Set creates or replaces the quota of the given group on documents of
+// the given type, and answers its ID.  The new limit applies to the
+// documents created thereafter.
+func (_Quotas) Set(otx *sql.Tx, q *Quota) (QuotaID, error) {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return 0, err
+	}
+
+	var v validator
+	if q == nil {
+		v.fail("quota", "should be given")
+	} else {
+		v.positive("DocType", int64(q.DocType))
+		v.positive("Group", int64(q.Group))
+		v.positive("Max", int64(q.Max))
+		if _, ok := quotaPeriods[q.Period]; !ok {
+			v.fail("Period", "unknown period")
+		}
+	}
+	if err := v.result(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		qry := `
+		INSERT INTO wf_quotas(doctype_id, group_id, max_docs, period)
+		VALUES(?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), max_docs = VALUES(max_docs), period = VALUES(period)
+		`
+		res, err := tx.Exec(qry, q.DocType, q.Group, q.Max, quotaPeriods[q.Period])
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return QuotaID(id), nil
+}
+
+// scanQuota reads one quota using the given scanner.
+func scanQuota(scan func(...interface{}) error) (*Quota, error) {
+	var elem Quota
+	var period string
+	if err := scan(&elem.ID, &elem.DocType, &elem.Group, &elem.Max, &period); err != nil {
+		return nil, err
+	}
+	for p, str := range quotaPeriods {
+		if str == period {
+			elem.Period = p
+		}
+	}
+	if elem.Period == 0 {
+		return nil, errorf(CodeInternal, "unknown quota period : %s", period)
+	}
+	return &elem, nil
+}
+
+// Get answers the requested quota.
+func (_Quotas) Get(id QuotaID) (*Quota, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "quota ID must be a positive integer")
+	}
+
+	q := `
+	SELECT id, doctype_id, group_id, max_docs, period
+	FROM wf_quotas
+	WHERE id = ?
+	`
+	elem, err := scanQuota(readDB().QueryRow(q, id).Scan)
+	if err != nil {
+		return nil, notFound(err, ErrQuotaNotFound)
+	}
+	return elem, nil
+}
+
+// List answers the quotas on documents of the given type, or on those
+// of all types if it is `0`.
+func (_Quotas) List(dtype DocTypeID) ([]*Quota, error) {
+	if dtype < 0 {
+		return nil, newError(CodeValidation, "document type must be a non-negative integer")
+	}
+
+	q := `
+	SELECT id, doctype_id, group_id, max_docs, period
+	FROM wf_quotas
+	WHERE ? = 0 OR doctype_id = ?
+	ORDER BY doctype_id, group_id
+	`
+	ary := []*Quota{}
+	err := scanEach(readDB().Query, q, []interface{}{dtype, dtype}, func(scan func(...interface{}) error) error {
+		elem, err := scanQuota(scan)
+		if err != nil {
+			return err
+		}
+		ary = append(ary, elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Delete removes the given quota.
+func (_Quotas) Delete(otx *sql.Tx, id QuotaID) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+	if id <= 0 {
+		return newError(CodeValidation, "quota ID must be a positive integer")
+	}
+
+	res, err := stmts.exec(otx, "DELETE FROM wf_quotas WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrQuotaNotFound
+	}
+	return nil
+}
+
+// Usage answers the consumption of the given quota in its current
+// period.
+func (_Quotas) Usage(id QuotaID) (*QuotaUsage, error) {
+	quota, err := Quotas.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+	q := `SELECT ` + quotaStart + ` FROM wf_quotas WHERE id = ?`
+	if err = readDB().QueryRow(q, id).Scan(&since); err != nil {
+		return nil, notFound(err, ErrQuotaNotFound)
+	}
+	used, err := quotaUsed(readDB().QueryRow, quota, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuotaUsage{Quota: quota, Used: used, Since: since}, nil
+}
+
+// quotaUsed counts the open root documents of the quota's type, created
+// since the given time by the members of its group.
+func quotaUsed(queryRow func(string, ...interface{}) *sql.Row, quota *Quota, since time.Time) (int, error) {
+	q := `
+	SELECT COUNT(*)
+	FROM ` + DocTypes.docStorName(quota.DocType) + ` docs
+	WHERE docs.path = ''
+	AND docs.ctime >= ?
+	AND docs.group_id IN (
+		SELECT gm.id
+		FROM wf_groups_master gm
+		JOIN wf_group_users gus ON gus.group_id = gm.id
+		JOIN wf_group_users mem ON mem.user_id = gus.user_id
+		WHERE mem.group_id = ?
+		AND gm.group_type = ` + GroupSingleton.sql() + `
+	)
+	AND docs.docstate_id NOT IN (
+		SELECT docstate_id
+		FROM wf_workflow_nodes
+		WHERE doctype_id = ?
+		AND type = 'end'
+	)
+	`
+	var n int
+	err := queryRow(q, since, quota.Group, quota.DocType).Scan(&n)
+	return n, err
+}
+
+// checkQuotas enforces the quotas applicable to the creation of a
+// document of the given type by the given (singleton) group: those on
+// the group itself, and on the general groups of which its user is a
+// member.
+func checkQuotas(tx *sql.Tx, dtype DocTypeID, gid GroupID) error {
+	// Locking the quotas serialises the creation of documents that
+	// they govern.
+	q := `
+	SELECT id, doctype_id, group_id, max_docs, period, ` + quotaStart + `
+	FROM wf_quotas
+	WHERE doctype_id = ?
+	AND group_id IN (
+		SELECT mem.group_id
+		FROM wf_group_users gus
+		JOIN wf_group_users mem ON mem.user_id = gus.user_id
+		WHERE gus.group_id = ?
+	)
+	ORDER BY id
+	FOR UPDATE
+	`
+	type entry struct {
+		quota *Quota
+		since time.Time
+	}
+	ary := []entry{}
+	err := scanEach(tx.Query, q, []interface{}{dtype, gid}, func(scan func(...interface{}) error) error {
+		var since time.Time
+		elem, err := scanQuota(func(dest ...interface{}) error {
+			return scan(append(dest, &since)...)
+		})
+		if err != nil {
+			return err
+		}
+		ary = append(ary, entry{elem, since})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range ary {
+		used, err := quotaUsed(tx.QueryRow, e.quota, e.since)
+		if err != nil {
+			return err
+		}
+		if used >= e.quota.Max {
+			return &QuotaExceededError{Quota: e.quota.ID, Group: e.quota.Group, Max: e.quota.Max, Used: used}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Operations that are rate limited separately.
+const (
+	rateOpNew   = "new"
+	rateOpApply = "apply"
+)
+
+// rateKey identifies the bucket of an operation by a group in an
+// access context.
+type rateKey struct {
+	op   string
+	gid  GroupID
+	acid AccessContextID
+}
+
+// rateBucket is a token bucket, refilled continuously.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter throttles the events raised, and applied, by each
+// singleton group in each access context.
+type rateLimiter struct {
+	mu      sync.Mutex
+	def     int                     // Events per minute, unless overridden; `0` is unlimited
+	perAC   map[AccessContextID]int // Overrides by access context
+	buckets map[rateKey]*rateBucket
+	swept   time.Time
+}
+
+var limiter = &rateLimiter{
+	perAC:   map[AccessContextID]int{},
+	buckets: map[rateKey]*rateBucket{},
+}
+
+// SetRateLimit limits the number of events that a single user -- i.e.
+// singleton group -- can raise using `DocEvents.New` per minute, in
+// any one access context.  The same number of events can be applied
+// using `Workflow.ApplyEvent` per minute.  Further requests fail with
+// `ErrRateLimited`, until the allowance is replenished.  The allowance
+// is replenished continuously, and can be used in bursts.
+//
+// An access context of `0` sets the default limit; others override it
+// in that access context.  A limit of `0` removes the limit, or the
+// override, as applicable.  There is no limit by default.
+//
+// N.B. Limits are enforced per process.  Applications running several
+// instances should divide the intended limit among them.
+func SetRateLimit(acid AccessContextID, perMinute int) {
+	if perMinute < 0 {
+		perMinute = 0
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if acid == 0 {
+		limiter.def = perMinute
+		return
+	}
+	if perMinute == 0 {
+		delete(limiter.perAC, acid)
+		return
+	}
+	limiter.perAC[acid] = perMinute
+}
+
+// allow takes one unit of the allowance of the given operation, by the
+// given group, in the given access context.  It answers
+// `ErrRateLimited` if none is available.
+func (l *rateLimiter) allow(op string, gid GroupID, acid AccessContextID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, ok := l.perAC[acid]
+	if !ok {
+		limit = l.def
+	}
+	if limit == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	l.sweep(now)
+
+	k := rateKey{op, gid, acid}
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &rateBucket{tokens: float64(limit), last: now}
+		l.buckets[k] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * float64(limit)
+	if b.tokens > float64(limit) {
+		b.tokens = float64(limit)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return ErrRateLimited
+	}
+	b.tokens--
+	return nil
+}
+
+// sweep discards the buckets not used for over a minute, since they
+// would be full anyway.  It runs at most once a minute.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > time.Minute {
+			delete(l.buckets, k)
+		}
+	}
+	l.swept = now
+}
+
+// limitApply takes one unit of the allowance of the group of the given
+// event for applying events, in the access context of its document.
+func (l *rateLimiter) limitApply(otx *sql.Tx, event *DocEvent) error {
+	l.mu.Lock()
+	limited := l.def > 0 || len(l.perAC) > 0
+	l.mu.Unlock()
+	if !limited {
+		return nil
+	}
+
+	tbl := DocTypes.docStorName(event.DocType)
+	q := `SELECT ac_id FROM ` + tbl + ` WHERE id = ?`
+	row, err := stmts.queryRow(otx, q, event.DocID)
+	if err != nil {
+		return err
+	}
+	var acid int64
+	if err = row.Scan(&acid); err != nil {
+		return notFound(err, ErrDocumentNotFound)
+	}
+
+	return l.allow(rateOpApply, event.Group, AccessContextID(acid))
+}
@@ -0,0 +1,249 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redactor hides the given fields of the given document, in place.
+// Fields are named as configured in redaction policies; their meaning
+// is up to the redactor.
+type Redactor func(doc *Document, fields []string) error
+
+// RedactJSON is the default redactor.  It treats the data of the
+// document as a JSON object, and removes the given fields from it.
+// Nested fields are named by their dotted paths, e.g. `amount.total`.
+//
+// Should the data not be a JSON object, all of it is hidden.
+func RedactJSON(doc *Document, fields []string) error {
+	if doc.Data == "" || len(fields) == 0 {
+		return nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(doc.Data), &obj); err != nil || obj == nil {
+		doc.Data = ""
+		return nil
+	}
+	for _, f := range fields {
+		m := obj
+		path := strings.Split(f, ".")
+		for _, p := range path[:len(path)-1] {
+			next, ok := m[p].(map[string]interface{})
+			if !ok {
+				m = nil
+				break
+			}
+			m = next
+		}
+		if m != nil {
+			delete(m, path[len(path)-1])
+		}
+	}
+
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	doc.Data = string(bs)
+	return nil
+}
+
+// redactionKey identifies the policy of a role for a document type.
+type redactionKey struct {
+	dtype DocTypeID
+	role  RoleID
+}
+
+var redactMu sync.RWMutex
+var redactors = map[DocTypeID]Redactor{}
+var redactPolicies = map[redactionKey][]string{}
+
+// Unexported type, only for convenience methods.
+type _Redactions struct{}
+
+// Redactions provides a resource-like interface to the policies that
+// hide parts of documents from users holding certain roles.
+//
+// Policies apply to documents read by `Documents.Get` and
+// `Documents.List` on behalf of a user, i.e. with the option `AsUser`.
+// A field is hidden from a user unless at least one of the roles of
+// the user in the access context of the document is free to see it.
+// A role having no policy for a document type sees all its fields.
+// Users holding no roles in the access context see none of the
+// fields named in the policies of the document type.
+var Redactions _Redactions
+
+// SetRedactor registers the redactor of documents of the given type.
+// A `nil` redactor restores the default, `RedactJSON`.
+func (_Redactions) SetRedactor(dtype DocTypeID, r Redactor) {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+
+	if r == nil {
+		delete(redactors, dtype)
+		return
+	}
+	redactors[dtype] = r
+}
+
+// SetPolicy configures the fields of documents of the given type that
+// are hidden from the given role.  Giving no fields removes the policy.
+func (_Redactions) SetPolicy(dtype DocTypeID, rid RoleID, fields ...string) {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+
+	k := redactionKey{dtype, rid}
+	if len(fields) == 0 {
+		delete(redactPolicies, k)
+		return
+	}
+	redactPolicies[k] = append([]string{}, fields...)
+}
+
+// Policy answers the fields of documents of the given type that are
+// hidden from the given role.
+func (_Redactions) Policy(dtype DocTypeID, rid RoleID) []string {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	return append([]string{}, redactPolicies[redactionKey{dtype, rid}]...)
+}
+
+// hidden answers the fields of documents of the given type that are
+// hidden from a user holding the given roles.
+func (_Redactions) hidden(dtype DocTypeID, roles []RoleID) []string {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+
+	counts := map[string]int{}
+	if len(roles) == 0 {
+		for k, fs := range redactPolicies {
+			if k.dtype == dtype {
+				for _, f := range fs {
+					counts[f] = 0
+				}
+			}
+		}
+	}
+	for _, rid := range roles {
+		for _, f := range redactPolicies[redactionKey{dtype, rid}] {
+			counts[f]++
+		}
+	}
+
+	fields := []string{}
+	for f, n := range counts {
+		if n == len(roles) {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// redactor answers the redactor of documents of the given type.
+func (_Redactions) redactor(dtype DocTypeID) Redactor {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+
+	if r, ok := redactors[dtype]; ok {
+		return r
+	}
+	return RedactJSON
+}
+
+// configured answers `true` if any policy applies to the given
+// document type.
+func (_Redactions) configured(dtype DocTypeID) bool {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+
+	for k := range redactPolicies {
+		if k.dtype == dtype {
+			return true
+		}
+	}
+	return false
+}
+
+// roles answers the roles that the given user holds in the given
+// access context, through the groups of the user.
+func (_Redactions) roles(otx *sql.Tx, acid AccessContextID, uid UserID) ([]RoleID, error) {
+	q := `
+	SELECT DISTINCT acgr.role_id
+	FROM wf_ac_group_roles acgr
+	JOIN wf_group_users gu ON gu.group_id = acgr.group_id
+	WHERE acgr.ac_id = ?
+	AND gu.user_id = ?
+	`
+	var rows *sql.Rows
+	var err error
+	if otx == nil {
+		rows, err = readDB().Query(q, acid, uid)
+	} else {
+		rows, err = otx.Query(q, acid, uid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := []RoleID{}
+	for rows.Next() {
+		var rid int64
+		if err = rows.Scan(&rid); err != nil {
+			return nil, err
+		}
+		ary = append(ary, RoleID(rid))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ary, nil
+}
+
+// apply redacts the given documents, all of the given type, for the
+// given user.  Roles are looked up once per access context.
+func (rs _Redactions) apply(otx *sql.Tx, dtype DocTypeID, uid UserID, docs ...*Document) error {
+	if uid <= 0 || !rs.configured(dtype) {
+		return nil
+	}
+
+	r := rs.redactor(dtype)
+	hidden := map[AccessContextID][]string{}
+	for _, doc := range docs {
+		fields, ok := hidden[doc.AccCtx.ID]
+		if !ok {
+			roles, err := rs.roles(otx, doc.AccCtx.ID, uid)
+			if err != nil {
+				return err
+			}
+			fields = rs.hidden(dtype, roles)
+			hidden[doc.AccCtx.ID] = fields
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if err := r(doc, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ReminderPolicy specifies the reminders sent about documents waiting
+// at a node.
+//
+// When a document arrives at a node having a reminder policy, the
+// recipients of the message posted are reminded of it after `After`,
+// and every `After` thereafter, up to `MaxCount` times.  A reminder
+// marks the message unread again, and re-sends its e-mails and webhook
+// notifications.  Reminders stop as soon as the document transitions
+// out of the node's state.
+//
+// Unlike escalation, reminders do not change the audience: only the
+// original recipients are reminded.
+type ReminderPolicy struct {
+	After      time.Duration `json:"After"`      // Delay before each reminder; at least a minute
+	MaxCount   int           `json:"MaxCount"`   // Maximum number of reminders
+	UnreadOnly bool          `json:"UnreadOnly"` // Remind only the groups that have not read the message?
+}
+
+func init() {
+	registerTimer("reminders", sendReminders)
+}
+
+// SetReminder sets the reminder policy of the given node.  A `nil`
+// policy removes it, and cancels the pending reminders of the node.
+func (_Nodes) SetReminder(otx *sql.Tx, id NodeID, p *ReminderPolicy) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	if p != nil {
+		if p.After < time.Minute {
+			v.fail("After", "should be at least a minute")
+		}
+		v.positive("MaxCount", int64(p.MaxCount))
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if p == nil {
+			_, err := tx.Exec("DELETE FROM wf_node_reminders WHERE node_id = ?", id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("UPDATE wf_reminders SET active = 0 WHERE node_id = ? AND active = 1", id)
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_node_reminders(node_id, after_secs, max_count, unread_only)
+		VALUES(?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE after_secs = VALUES(after_secs), max_count = VALUES(max_count),
+			unread_only = VALUES(unread_only)
+		`
+		_, err := tx.Exec(q, id, int64(p.After/time.Second), p.MaxCount, p.UnreadOnly)
+		return err
+	})
+}
+
+// Reminder answers the reminder policy of the given node, if any;
+// `nil` otherwise.
+func (_Nodes) Reminder(id NodeID) (*ReminderPolicy, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	q := `
+	SELECT after_secs, max_count, unread_only
+	FROM wf_node_reminders
+	WHERE node_id = ?
+	`
+	var p ReminderPolicy
+	var secs int64
+	err := readDB().QueryRow(q, id).Scan(&secs, &p.MaxCount, &p.UnreadOnly)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	p.After = time.Duration(secs) * time.Second
+	return &p, nil
+}
+
+// scheduleReminders schedules the reminders of the given message,
+// posted upon the document's arrival at the given node, as per the
+// node's reminder policy, if any.
+func scheduleReminders(otx *sql.Tx, n *Node, msg *Message) error {
+	q := `
+	INSERT INTO wf_reminders(message_id, node_id, doctype_id, doc_id, docstate_id, sent, next_at, active, ctime)
+	SELECT ?, node_id, ?, ?, ?, 0, DATE_ADD(NOW(), INTERVAL after_secs SECOND), 1, NOW()
+	FROM wf_node_reminders
+	WHERE node_id = ?
+	`
+	_, err := stmts.exec(otx, q, msg.ID, n.DocType, msg.DocID, n.State, n.ID)
+	return err
+}
+
+// cancelReminders cancels the pending reminders about the given
+// document, which is transitioning.
+func cancelReminders(otx *sql.Tx, dtype DocTypeID, did DocumentID) error {
+	q := `
+	UPDATE wf_reminders
+	SET active = 0
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND active = 1
+	`
+	_, err := stmts.exec(otx, q, dtype, did)
+	return err
+}
+
+// dueReminder is a reminder that is due, together with its policy.
+type dueReminder struct {
+	id     int64
+	msg    MessageID
+	dtype  DocTypeID
+	did    DocumentID
+	state  DocStateID
+	sent   int
+	max    int
+	after  int64
+	unread bool
+}
+
+// sendReminders is the timer task of reminders.  It sends those
+// reminders that are due.
+func sendReminders(ctx context.Context) (int, error) {
+	q := `
+	SELECT rms.id, rms.message_id, rms.doctype_id, rms.doc_id, rms.docstate_id, rms.sent,
+		nrs.max_count, nrs.after_secs, nrs.unread_only
+	FROM wf_reminders rms
+	JOIN wf_node_reminders nrs ON nrs.node_id = rms.node_id
+	WHERE rms.active = 1
+	AND rms.next_at <= NOW()
+	ORDER BY rms.next_at
+	LIMIT 100
+	`
+	rows, err := db.Query(q)
+	if err != nil {
+		return 0, err
+	}
+	ary := []*dueReminder{}
+	for rows.Next() {
+		var elem dueReminder
+		err = rows.Scan(&elem.id, &elem.msg, &elem.dtype, &elem.did, &elem.state, &elem.sent,
+			&elem.max, &elem.after, &elem.unread)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ary = append(ary, &elem)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, elem := range ary {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		var ok bool
+		err = withTx(nil, func(tx *sql.Tx) error {
+			var err error
+			ok, err = elem.send(tx)
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// send claims this reminder, and re-notifies the recipients of its
+// message, unless the document has moved on meanwhile.  It answers
+// `true` if the reminder was sent.
+func (r *dueReminder) send(tx *sql.Tx) (bool, error) {
+	q := `
+	UPDATE wf_reminders
+	SET sent = ?, next_at = DATE_ADD(NOW(), INTERVAL ? SECOND), active = ?
+	WHERE id = ?
+	AND active = 1
+	AND sent = ?
+	`
+	res, err := tx.Exec(q, r.sent+1, r.after, r.sent+1 < r.max, r.id, r.sent)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return false, nil
+	}
+
+	// Suppress the reminder should the document have transitioned
+	// without its cancellation, e.g. through a direct state change.
+	tbl := DocTypes.docStorName(r.dtype)
+	var state int64
+	err = tx.QueryRow(`SELECT docstate_id FROM `+tbl+` WHERE id = ?`, r.did).Scan(&state)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if err == sql.ErrNoRows || DocStateID(state) != r.state {
+		_, err = tx.Exec("UPDATE wf_reminders SET active = 0 WHERE id = ?", r.id)
+		return false, err
+	}
+
+	q = `
+	SELECT group_id
+	FROM wf_mailboxes
+	WHERE message_id = ?
+	`
+	if r.unread {
+		q += `AND unread = 1`
+	}
+	rows, err := tx.Query(q, r.msg)
+	if err != nil {
+		return false, err
+	}
+	gids := []GroupID{}
+	args := []interface{}{r.msg}
+	for rows.Next() {
+		var gid int64
+		if err = rows.Scan(&gid); err != nil {
+			rows.Close()
+			return false, err
+		}
+		gids = append(gids, GroupID(gid))
+		args = append(args, gid)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	if len(gids) == 0 {
+		_, err = tx.Exec("UPDATE wf_reminders SET active = 0 WHERE id = ?", r.id)
+		return false, err
+	}
+
+	q = `
+	UPDATE wf_mailboxes
+	SET unread = 1
+	WHERE message_id = ?
+	AND group_id IN ` + inPlaceholders(len(gids))
+	if _, err = tx.Exec(q, args...); err != nil {
+		return false, err
+	}
+	q = `
+	UPDATE wf_email_deliveries eds
+	JOIN wf_mailboxes mbs ON mbs.id = eds.mailbox_id
+	SET eds.status = 'P', eds.attempts = 0, eds.next_attempt = NOW(), eds.last_error = NULL, eds.mtime = NOW()
+	WHERE mbs.message_id = ?
+	AND mbs.group_id IN ` + inPlaceholders(len(gids)) + `
+	AND eds.status <> 'P'
+	`
+	if _, err = tx.Exec(q, args...); err != nil {
+		return false, err
+	}
+	if err = NotificationPrefs.enqueueWebhooks(tx, r.msg, gids, r.sent+1); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,276 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RenderFormat enumerates the formats of printable renditions of
+// documents.
+type RenderFormat uint8
+
+const (
+	// RenderHTML : an HTML page
+	RenderHTML RenderFormat = iota + 1
+	// RenderPDF : a PDF file; needs a registered renderer
+	RenderPDF
+)
+
+// String answers the name of this format, which is also the extension
+// of rendition blobs.
+func (f RenderFormat) String() string {
+	switch f {
+	case RenderHTML:
+		return "html"
+
+	case RenderPDF:
+		return "pdf"
+
+	default:
+		return fmt.Sprintf("RenderFormat(%d)", uint8(f))
+	}
+}
+
+// RenderStep is one transition in the state history of a rendered
+// document.
+type RenderStep struct {
+	Event     DocEventID `json:"Event"`     // Event that caused the transition
+	FromState DocState   `json:"FromState"` // State before the transition
+	Action    DocAction  `json:"Action"`    // Action performed
+	Group     Group      `json:"Group"`     // (Singleton) group that performed the action
+	ToState   DocState   `json:"ToState"`   // State after the transition
+	Text      string     `json:"Text"`      // Comments of the event
+	Signed    bool       `json:"Signed"`    // Does the event carry a signature?
+	Ctime     time.Time  `json:"Ctime"`     // Time of the event
+}
+
+// RenderSource is what a renderer renders: the document, with its
+// tags, blobs and children, and its state history, oldest first.
+type RenderSource struct {
+	Document *Document      `json:"Document"` // The document being rendered
+	History  []*RenderStep  `json:"History"`  // Transitions of the document, oldest first
+	Fields   []*RenderField `json:"Fields"`   // Top-level fields of the document's data, ordered by name
+}
+
+// RenderField is a top-level field of the data of a rendered document.
+// Values other than strings are given as JSON.
+type RenderField struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// Renderer writes a printable rendition of the given source to the
+// given writer, in the given format.  It should answer an error for
+// formats that it does not support.
+type Renderer func(w io.Writer, src *RenderSource, format RenderFormat) error
+
+var renderMu sync.RWMutex
+var renderers = map[DocTypeID]Renderer{}
+
+// RegisterRenderer registers the given renderer for documents of the
+// given type.  A `nil` renderer unregisters the current one.
+//
+// Documents of types having no renderer are rendered as HTML using a
+// plain built-in layout; PDF renditions need a registered renderer.
+func (_Documents) RegisterRenderer(dtype DocTypeID, fn Renderer) {
+	renderMu.Lock()
+	defer renderMu.Unlock()
+
+	if fn == nil {
+		delete(renderers, dtype)
+		return
+	}
+	renderers[dtype] = fn
+}
+
+// renderer answers the renderer registered for the given document
+// type, if any.
+func renderer(dtype DocTypeID) Renderer {
+	renderMu.RLock()
+	defer renderMu.RUnlock()
+	return renderers[dtype]
+}
+
+// Render answers a printable rendition of the given document, in the
+// given format, combining its title, data fields, state history and
+// the signatures of its events.
+func (_Documents) Render(dtype DocTypeID, id DocumentID, format RenderFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := renderDocument(&buf, dtype, id, format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderBlob renders the given document as `Render` does, and adds the
+// rendition to the document as a blob with the given name.  An empty
+// name defaults to one based on the document and the format.
+func (_Documents) RenderBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, format RenderFormat,
+	name string) (*Blob, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = fmt.Sprintf("document-%d.%s", id, format)
+	}
+
+	// The rendition is written in the blobs directory, so that
+	// `AddBlob` can move it into place.
+	f, err := ioutil.TempFile(blobsDir, "render-")
+	if err != nil {
+		return nil, err
+	}
+	h := sha1.New()
+	err = renderDocument(io.MultiWriter(f, h), dtype, id, format)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	blob := &Blob{Name: name, Path: f.Name(), SHA1Sum: fmt.Sprintf("%x", h.Sum(nil))}
+	if err = Documents.AddBlob(otx, dtype, id, blob); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	blob.Path = ""
+	return blob, nil
+}
+
+// renderDocument writes the rendition of the given document to the
+// given writer.
+func renderDocument(w io.Writer, dtype DocTypeID, id DocumentID, format RenderFormat) error {
+	if dtype <= 0 || id <= 0 {
+		return newError(CodeValidation, "document type and document ID must be positive integers")
+	}
+	if format != RenderHTML && format != RenderPDF {
+		return errorf(CodeValidation, "unknown render format : %d", format)
+	}
+	fn := renderer(dtype)
+	if fn == nil && format != RenderHTML {
+		return errorf(CodeValidation, "no renderer is registered for document type %d", dtype)
+	}
+
+	src, err := renderSource(dtype, id)
+	if err != nil {
+		return err
+	}
+	if fn == nil {
+		return defaultRenderTmpl.Execute(w, src)
+	}
+	return fn(w, src, format)
+}
+
+// renderSource gathers the document and its history for rendering.
+func renderSource(dtype DocTypeID, id DocumentID) (*RenderSource, error) {
+	doc, err := Documents.Get(nil, dtype, id, WithTags(), WithBlobs(), WithChildren())
+	if err != nil {
+		return nil, err
+	}
+	src := &RenderSource{Document: doc, History: []*RenderStep{}, Fields: renderFields(doc.Data)}
+
+	q := `
+	SELECT dea.docevent_id, dea.from_state_id, dsm1.name, de.docaction_id, dam.name, de.group_id, gm.name,
+		dea.to_state_id, dsm2.name, de.data, des.docevent_id IS NOT NULL, de.ctime
+	FROM wf_docevent_application dea
+	JOIN wf_docevents de ON de.id = dea.docevent_id
+	JOIN wf_docstates_master dsm1 ON dsm1.id = dea.from_state_id
+	JOIN wf_docstates_master dsm2 ON dsm2.id = dea.to_state_id
+	JOIN wf_docactions_master dam ON dam.id = de.docaction_id
+	JOIN wf_groups_master gm ON gm.id = de.group_id
+	LEFT JOIN wf_docevent_signatures des ON des.docevent_id = de.id
+	WHERE dea.doctype_id = ?
+	AND dea.doc_id = ?
+	ORDER BY dea.id
+	`
+	err = scanEach(readDB().Query, q, []interface{}{dtype, id}, func(scan func(...interface{}) error) error {
+		var elem RenderStep
+		var text sql.NullString
+		err := scan(&elem.Event, &elem.FromState.ID, &elem.FromState.Name, &elem.Action.ID, &elem.Action.Name,
+			&elem.Group.ID, &elem.Group.Name, &elem.ToState.ID, &elem.ToState.Name, &text, &elem.Signed, &elem.Ctime)
+		if err != nil {
+			return err
+		}
+		elem.Text = text.String
+		src.History = append(src.History, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return src, nil
+}
+
+// renderFields answers the top-level fields of the given data, should
+// it be a JSON object, ordered by name.  Other data is answered as a
+// single field.
+func renderFields(data string) []*RenderField {
+	if strings.TrimSpace(data) == "" {
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		return []*RenderField{{Name: "Data", Value: data}}
+	}
+
+	ary := make([]*RenderField, 0, len(obj))
+	for k, v := range obj {
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			s = string(v)
+		}
+		ary = append(ary, &RenderField{Name: k, Value: s})
+	}
+	sort.Slice(ary, func(i, j int) bool { return ary[i].Name < ary[j].Name })
+	return ary
+}
+
+// defaultRenderTmpl is the layout of documents of types having no
+// renderer.
+var defaultRenderTmpl = template.Must(template.New("render").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Document.Title}}</title>
+</head>
+<body>
+<h1>{{.Document.Title}}</h1>
+<p>{{.Document.DocType.Name}} {{.Document.ID}} &middot; {{.Document.State.Name}} &middot; {{.Document.Ctime.Format "2006-01-02 15:04:05"}}</p>
+{{if .Fields}}<table>
+{{range .Fields}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Document.Tags}}<p>{{range $i, $t := .Document.Tags}}{{if $i}}, {{end}}{{$t}}{{end}}</p>
+{{end}}<h2>History</h2>
+<table>
+<tr><th>Time</th><th>From</th><th>Action</th><th>By</th><th>To</th><th>Comments</th><th>Signed</th></tr>
+{{range .History}}<tr><td>{{.Ctime.Format "2006-01-02 15:04:05"}}</td><td>{{.FromState.Name}}</td><td>{{.Action.Name}}</td><td>{{.Group.Name}}</td><td>{{.ToState.Name}}</td><td>{{.Text}}</td><td>{{if .Signed}}yes{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,218 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaConfig holds the settings of a read replica.
+type ReplicaConfig struct {
+	// MaxLag, if positive, is the maximum replication lag tolerated.
+	// Reads go to the primary while the replica lags further behind,
+	// or while its lag cannot be determined.
+	MaxLag time.Duration
+
+	// CheckInterval is the minimum time between successive
+	// measurements of the lag; defaults to 5 seconds.  The lag is
+	// measured in the background, one measurement at a time; reads go
+	// to the primary while a measurement takes longer than this.
+	CheckInterval time.Duration
+
+	// Lag measures the replication lag of the given replica.  The
+	// default uses `Seconds_Behind_Master` of `SHOW SLAVE STATUS`,
+	// which needs the `REPLICATION CLIENT` privilege.
+	Lag func(rdb *sql.DB) (time.Duration, error)
+}
+
+// replicaState tracks the read replica, and whether it is currently
+// fresh enough to serve reads.  Reads consult it without contending
+// with one another; only registration takes the write lock.
+type replicaState struct {
+	mu  sync.RWMutex
+	rdb *sql.DB
+	cfg ReplicaConfig
+	gen int64 // Registration whose lag is being measured
+
+	checked int64 // Time of the most recent measurement, in Unix nanoseconds
+	probing int64 // Time at which the measurement in progress began, if any
+	healthy int32 // Was the replica within the tolerated lag, as measured?
+}
+
+var replica replicaState
+
+// RegisterReplica provides a handle to a read-only replica of the
+// primary database registered using `RegisterDB`.  Pure reads --
+// `Get`, `List`, `Count` and permission checks -- that are not part of
+// a transaction are then served by the replica.  Reads inside
+// transactions, and those made by mutating methods, continue to use
+// the primary.
+//
+// The handle should be opened with `parseTime=true`, as for the
+// primary.  A `nil` handle stops routing reads to the replica.
+//
+// N.B. Since replication is asynchronous, a read from the replica can
+// miss changes made an instant ago.  Applications that need to read
+// their own writes immediately should pass the transaction, or set a
+// suitable `MaxLag`.
+func RegisterReplica(rdb *sql.DB, cfg *ReplicaConfig) error {
+	var c ReplicaConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.MaxLag < 0 {
+		return newError(CodeValidation, "maximum lag should not be negative")
+	}
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = 5 * time.Second
+	}
+	if c.Lag == nil {
+		c.Lag = slaveStatusLag
+	}
+
+	replica.mu.Lock()
+	defer replica.mu.Unlock()
+	replica.rdb = rdb
+	replica.cfg = c
+	replica.gen++
+	atomic.StoreInt64(&replica.checked, 0)
+	atomic.StoreInt64(&replica.probing, 0)
+	// Until its lag is first measured, the replica serves no reads.
+	atomic.StoreInt32(&replica.healthy, 0)
+	rstmts.reset(rdb)
+
+	return nil
+}
+
+// readDB answers the handle to use for a pure read.
+func readDB() *sql.DB {
+	if rdb := replica.handle(); rdb != nil {
+		return rdb
+	}
+	return db
+}
+
+// readStmts answers the statement cache to use for a pure read.
+func readStmts() *stmtCache {
+	if rdb := replica.handle(); rdb != nil {
+		return rstmts
+	}
+	return stmts
+}
+
+// handle answers the replica, if one is registered, and is within the
+// tolerated lag as of its most recent measurement; `nil` otherwise.
+// It begins a new measurement when the most recent one is due, but
+// does not wait for it.
+func (r *replicaState) handle() *sql.DB {
+	r.mu.RLock()
+	rdb, cfg, gen := r.rdb, r.cfg, r.gen
+	r.mu.RUnlock()
+	if rdb == nil {
+		return nil
+	}
+	if cfg.MaxLag == 0 {
+		return rdb
+	}
+
+	now := time.Now().UnixNano()
+	if started := atomic.LoadInt64(&r.probing); started != 0 {
+		// A replica slow to report its lag is not trusted meanwhile.
+		if time.Duration(now-started) >= cfg.CheckInterval {
+			return nil
+		}
+	} else if time.Duration(now-atomic.LoadInt64(&r.checked)) >= cfg.CheckInterval {
+		if atomic.CompareAndSwapInt64(&r.probing, 0, now) {
+			go r.probe(rdb, cfg, gen, now)
+		}
+	}
+
+	if atomic.LoadInt32(&r.healthy) == 0 {
+		return nil
+	}
+	return rdb
+}
+
+// probe measures the lag of the given registration of the replica, and
+// records whether it is within the tolerated lag.
+func (r *replicaState) probe(rdb *sql.DB, cfg ReplicaConfig, gen, started int64) {
+	defer atomic.CompareAndSwapInt64(&r.probing, started, 0)
+
+	lag, err := cfg.Lag(rdb)
+	healthy := err == nil && lag <= cfg.MaxLag
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.gen != gen {
+		// The replica has been registered afresh since.
+		return
+	}
+	atomic.StoreInt64(&r.checked, time.Now().UnixNano())
+	var h int32
+	if healthy {
+		h = 1
+	}
+	if atomic.SwapInt32(&r.healthy, h) != h {
+		if healthy {
+			writeLog(LogInfo, "read replica caught up; routing reads to it", F("lag", lag))
+		} else {
+			writeLog(LogWarn, "read replica lagging; routing reads to the primary", F("lag", lag), F("error", err))
+		}
+	}
+}
+
+// slaveStatusLag answers the replication lag reported by the given
+// replica.  A server that is not replicating has no lag.
+func slaveStatusLag(rdb *sql.DB) (time.Duration, error) {
+	rows, err := rdb.Query(`SHOW SLAVE STATUS`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+
+	vals := make([]sql.RawBytes, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if err = rows.Scan(ptrs...); err != nil {
+		return 0, err
+	}
+	for i, c := range cols {
+		if c != "Seconds_Behind_Master" {
+			continue
+		}
+		if vals[i] == nil {
+			return 0, newError(CodeInternal, "replication is not running")
+		}
+		d, err := time.ParseDuration(string(vals[i]) + "s")
+		if err != nil {
+			return 0, err
+		}
+		return d, nil
+	}
+	return 0, newError(CodeInternal, "replication lag is not reported")
+}
@@ -0,0 +1,277 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AccessReviewID is the type of unique identifiers of access reviews.
+type AccessReviewID int64
+
+// AccessPermission is the permission to perform an action on documents
+// of a type.
+type AccessPermission struct {
+	DocType DocType   `json:"DocType"` // Type of the documents
+	Action  DocAction `json:"Action"`  // Action permitted
+}
+
+// AccessReviewEntry lists the access of one user in an access context.
+type AccessReviewEntry struct {
+	User        UserID             `json:"User"`        // User under review
+	Email       string             `json:"Email"`       // E-mail address of the user
+	Groups      []Group            `json:"Groups"`      // Groups of the user that hold roles in the access context
+	Roles       []Role             `json:"Roles"`       // Roles held through those groups
+	Permissions []AccessPermission `json:"Permissions"` // Distinct permissions that those roles resolve to
+}
+
+// AccessChangeKind enumerates the kinds of changes in access.
+type AccessChangeKind uint8
+
+const (
+	// AccessGranted : the user gained the access since the previous review
+	AccessGranted AccessChangeKind = iota + 1
+	// AccessRevoked : the user lost the access since the previous review
+	AccessRevoked
+)
+
+// AccessChange is a change in the access of a user, since the previous
+// review.  Exactly one of group, role and permission is given.
+type AccessChange struct {
+	User       UserID            `json:"User"`                 // User whose access changed
+	Kind       AccessChangeKind  `json:"Kind"`                 // Gained or lost
+	Group      *Group            `json:"Group,omitempty"`      // Group membership that changed
+	Role       *Role             `json:"Role,omitempty"`       // Role that changed
+	Permission *AccessPermission `json:"Permission,omitempty"` // Permission that changed
+}
+
+// AccessReview is a report of the access of all users in an access
+// context, for periodic certification of that access.
+type AccessReview struct {
+	ID       AccessReviewID       `json:"ID"`                 // Unique identifier of this run
+	AccCtx   AccessContextID      `json:"AccCtx"`             // Access context reviewed
+	Previous AccessReviewID       `json:"Previous,omitempty"` // Previous run for the access context, if any
+	Ctime    time.Time            `json:"Ctime"`              // Time of this run
+	Entries  []*AccessReviewEntry `json:"Entries"`            // Access of each user, in the order of user IDs
+	Changes  []*AccessChange      `json:"Changes"`            // Changes since the previous run
+}
+
+// Unexported type, only for convenience methods.
+type _Reports struct{}
+
+// Reports provides reports spanning the master data and documents.
+var Reports _Reports
+
+// AccessReview reports, for each user having access to the given
+// access context: the groups through which the access is held, the
+// roles of those groups, and the permissions on document types that
+// they resolve to.  Changes since the previous run for the access
+// context are answered, too; the first run answers none.
+//
+// Each run is recorded, to serve as the baseline of the next.
+func (_Reports) AccessReview(acID AccessContextID) (*AccessReview, error) {
+	if acID <= 0 {
+		return nil, newError(CodeValidation, "access context ID should be a positive integer")
+	}
+
+	var rev *AccessReview
+	err := withTx(nil, func(tx *sql.Tx) error {
+		var err error
+		rev, err = accessReviewEntries(tx, acID)
+		if err != nil {
+			return err
+		}
+
+		var prevID int64
+		var snapshot string
+		q := `
+		SELECT id, snapshot
+		FROM wf_access_reviews
+		WHERE ac_id = ?
+		ORDER BY id DESC
+		LIMIT 1
+		`
+		err = tx.QueryRow(q, acID).Scan(&prevID, &snapshot)
+		switch {
+		case err == sql.ErrNoRows:
+			rev.Changes = []*AccessChange{}
+
+		case err != nil:
+			return err
+
+		default:
+			var prev []*AccessReviewEntry
+			if err = json.Unmarshal([]byte(snapshot), &prev); err != nil {
+				return err
+			}
+			rev.Previous = AccessReviewID(prevID)
+			rev.Changes = diffAccess(prev, rev.Entries)
+		}
+
+		bs, err := json.Marshal(rev.Entries)
+		if err != nil {
+			return err
+		}
+		q = `INSERT INTO wf_access_reviews(ac_id, snapshot, ctime) VALUES(?, ?, ?)`
+		res, err := tx.Exec(q, acID, string(bs), rev.Ctime)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		rev.ID = AccessReviewID(id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rev, nil
+}
+
+// accessReviewEntries assembles the access of all users in the given
+// access context.
+func accessReviewEntries(tx *sql.Tx, acID AccessContextID) (*AccessReview, error) {
+	q := `
+	SELECT um.id, um.email, gm.id, gm.name, gm.group_type, rm.id, rm.name,
+		rdas.doctype_id, dtm.name, rdas.docaction_id, dam.name, dam.reconfirm
+	FROM wf_ac_group_roles agrs
+	JOIN wf_group_users gu ON gu.group_id = agrs.group_id
+	JOIN wf_users_master um ON um.id = gu.user_id
+	JOIN wf_groups_master gm ON gm.id = agrs.group_id
+	JOIN wf_roles_master rm ON rm.id = agrs.role_id
+	LEFT JOIN wf_role_perms_v rdas ON rdas.role_id = agrs.role_id
+	LEFT JOIN wf_doctypes_master dtm ON dtm.id = rdas.doctype_id
+	LEFT JOIN wf_docactions_master dam ON dam.id = rdas.docaction_id
+	WHERE agrs.ac_id = ?
+	ORDER BY um.id, gm.id, rm.id, rdas.doctype_id, rdas.docaction_id
+	`
+	rows, err := tx.Query(q, acID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rev := &AccessReview{
+		AccCtx:  acID,
+		Ctime:   time.Now().Truncate(time.Second),
+		Entries: []*AccessReviewEntry{},
+	}
+	var elem *AccessReviewEntry
+	var seen map[string]bool
+	for rows.Next() {
+		var uid UserID
+		var email string
+		var g Group
+		var r Role
+		var dtid, daid sql.NullInt64
+		var dtname, daname sql.NullString
+		var reconfirm sql.NullBool
+		err = rows.Scan(&uid, &email, &g.ID, &g.Name, &g.GroupType, &r.ID, &r.Name,
+			&dtid, &dtname, &daid, &daname, &reconfirm)
+		if err != nil {
+			return nil, err
+		}
+
+		if elem == nil || elem.User != uid {
+			elem = &AccessReviewEntry{
+				User:        uid,
+				Email:       email,
+				Groups:      []Group{},
+				Roles:       []Role{},
+				Permissions: []AccessPermission{},
+			}
+			seen = map[string]bool{}
+			rev.Entries = append(rev.Entries, elem)
+		}
+		if k := fmt.Sprintf("g:%d", g.ID); !seen[k] {
+			seen[k] = true
+			elem.Groups = append(elem.Groups, g)
+		}
+		if k := fmt.Sprintf("r:%d", r.ID); !seen[k] {
+			seen[k] = true
+			elem.Roles = append(elem.Roles, r)
+		}
+		if !dtid.Valid {
+			continue
+		}
+		if k := fmt.Sprintf("p:%d:%d", dtid.Int64, daid.Int64); !seen[k] {
+			seen[k] = true
+			elem.Permissions = append(elem.Permissions, AccessPermission{
+				DocType: DocType{ID: DocTypeID(dtid.Int64), Name: dtname.String},
+				Action:  DocAction{ID: DocActionID(daid.Int64), Name: daname.String, Reconfirm: reconfirm.Bool},
+			})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rev, nil
+}
+
+// accessFacets answers the groups, roles and permissions of the given
+// entries as changes, keyed by user and facet.  The keys are also
+// answered in the order of the entries.
+func accessFacets(entries []*AccessReviewEntry) ([]string, map[string]*AccessChange) {
+	keys := []string{}
+	res := map[string]*AccessChange{}
+	add := func(k string, c *AccessChange) {
+		keys = append(keys, k)
+		res[k] = c
+	}
+	for _, e := range entries {
+		for i := range e.Groups {
+			add(fmt.Sprintf("%d:g:%d", e.User, e.Groups[i].ID), &AccessChange{User: e.User, Group: &e.Groups[i]})
+		}
+		for i := range e.Roles {
+			add(fmt.Sprintf("%d:r:%d", e.User, e.Roles[i].ID), &AccessChange{User: e.User, Role: &e.Roles[i]})
+		}
+		for i := range e.Permissions {
+			p := &e.Permissions[i]
+			add(fmt.Sprintf("%d:p:%d:%d", e.User, p.DocType.ID, p.Action.ID), &AccessChange{User: e.User, Permission: p})
+		}
+	}
+	return keys, res
+}
+
+// diffAccess answers the changes from the previous entries to the
+// current ones: revocations first, then grants.
+func diffAccess(prev, cur []*AccessReviewEntry) []*AccessChange {
+	pk, pf := accessFacets(prev)
+	ck, cf := accessFacets(cur)
+
+	ary := []*AccessChange{}
+	for _, k := range pk {
+		if _, ok := cf[k]; !ok {
+			c := pf[k]
+			c.Kind = AccessRevoked
+			ary = append(ary, c)
+		}
+	}
+	for _, k := range ck {
+		if _, ok := pf[k]; !ok {
+			c := cf[k]
+			c.Kind = AccessGranted
+			ary = append(ary, c)
+		}
+	}
+	return ary
+}
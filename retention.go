@@ -0,0 +1,495 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy specifies how long the data of documents of a type
+// is retained.  A zero duration retains the corresponding data
+// indefinitely.
+//
+// `flow` does not keep revisions of documents: their data is updated
+// in place.  Their history is that of their events.
+type RetentionPolicy struct {
+	DocType   DocTypeID     `json:"DocType"`   // Type of the documents
+	Events    time.Duration `json:"Events"`    // Applied events older than this are purged
+	Messages  time.Duration `json:"Messages"`  // Messages of events older than this are purged
+	Documents time.Duration `json:"Documents"` // Documents resting in end states, untouched for longer than this, are purged
+}
+
+// RetentionProgress reports the progress of a retention run, after each
+// batch purged.
+type RetentionProgress struct {
+	DocType DocTypeID `json:"DocType"` // Type of the documents being purged
+	Kind    string    `json:"Kind"`    // One of "documents", "events" and "messages"
+	Deleted int64     `json:"Deleted"` // Running count of items of this kind purged
+}
+
+// Unexported type, only for convenience methods.
+type _Retention struct{}
+
+// Retention provides a resource-like interface to retention policies,
+// and purges the data that they no longer retain.
+var Retention _Retention
+
+var retentionMu sync.RWMutex
+var retentionBatch = 500
+var retentionProgress func(*RetentionProgress)
+
+// SetBatchSize sets the maximum number of items purged per
+// transaction.  The default is 500.
+func (_Retention) SetBatchSize(n int) error {
+	if n <= 0 {
+		return newError(CodeValidation, "batch size should be a positive integer")
+	}
+
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	retentionBatch = n
+	return nil
+}
+
+// OnProgress registers the given function to be called after each
+// batch purged.  By default, progress is logged.
+func (_Retention) OnProgress(fn func(*RetentionProgress)) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	retentionProgress = fn
+}
+
+// Set creates or replaces the retention policy of the policy's
+// document type.
+func (_Retention) Set(otx *sql.Tx, p *RetentionPolicy) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
+	var v validator
+	if p == nil {
+		v.fail("policy", "should be given")
+	} else {
+		v.positive("DocType", int64(p.DocType))
+		if p.Events < 0 || p.Messages < 0 || p.Documents < 0 {
+			v.fail("policy", "durations should be non-negative")
+		}
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_retention_policies(doctype_id, events_secs, messages_secs, documents_secs, mtime)
+		VALUES(?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE events_secs = VALUES(events_secs), messages_secs = VALUES(messages_secs),
+			documents_secs = VALUES(documents_secs), mtime = VALUES(mtime)
+		`
+		_, err := tx.Exec(q, p.DocType, int64(p.Events/time.Second), int64(p.Messages/time.Second),
+			int64(p.Documents/time.Second))
+		return err
+	})
+}
+
+// Get answers the retention policy of the given document type.  Should
+// the type not have one, `ErrRetentionPolicyNotFound` is answered.
+func (_Retention) Get(dtype DocTypeID) (*RetentionPolicy, error) {
+	if dtype <= 0 {
+		return nil, newError(CodeValidation, "document type should be a positive integer")
+	}
+
+	ary, err := retentionPolicies(dtype)
+	if err != nil {
+		return nil, err
+	}
+	if len(ary) == 0 {
+		return nil, ErrRetentionPolicyNotFound
+	}
+	return ary[0], nil
+}
+
+// List answers the retention policies of all document types having
+// one.
+func (_Retention) List() ([]*RetentionPolicy, error) {
+	return retentionPolicies(0)
+}
+
+// Delete removes the retention policy of the given document type,
+// whose data is retained indefinitely thereafter.
+func (_Retention) Delete(otx *sql.Tx, dtype DocTypeID) error {
+	if err := checkAdmin(otx, AdminDocTypes); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_retention_policies WHERE doctype_id = ?", dtype)
+		return err
+	})
+}
+
+// retentionPolicies answers the policy of the given document type, or
+// of all types if it is `0`.
+func retentionPolicies(dtype DocTypeID) ([]*RetentionPolicy, error) {
+	q := `
+	SELECT doctype_id, events_secs, messages_secs, documents_secs
+	FROM wf_retention_policies
+	WHERE ? = 0 OR doctype_id = ?
+	ORDER BY doctype_id
+	`
+	rows, err := readDB().Query(q, dtype, dtype)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := []*RetentionPolicy{}
+	for rows.Next() {
+		var elem RetentionPolicy
+		var evs, msgs, docs int64
+		if err = rows.Scan(&elem.DocType, &evs, &msgs, &docs); err != nil {
+			return nil, err
+		}
+		elem.Events = time.Duration(evs) * time.Second
+		elem.Messages = time.Duration(msgs) * time.Second
+		elem.Documents = time.Duration(docs) * time.Second
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Run purges, for each document type having a retention policy, the
+// documents, events and messages that the policy no longer retains --
+// in that order.  Purging happens in batches, each in its own
+// transaction, so that the database is not locked for long; progress
+// is reported after each batch.  Run answers the number of items
+// purged.
+//
+// Purging a document removes its events, messages, tags, keys, blobs
+// and links to its parent and children.  Purging an event removes its
+// messages.  Pending events are never purged on their own.
+//
+// Run stops between batches when the given context is cancelled.  It
+// is meant to be scheduled by the application, typically during
+// off-peak hours.
+func (_Retention) Run(ctx context.Context) (int64, error) {
+	ps, err := Retention.List()
+	if err != nil {
+		return 0, err
+	}
+
+	retentionMu.RLock()
+	batch := retentionBatch
+	progress := retentionProgress
+	retentionMu.RUnlock()
+	if progress == nil {
+		progress = func(p *RetentionProgress) {
+			writeLog(LogInfo, "retention purged", F("doctype", p.DocType), F("kind", p.Kind), F("deleted", p.Deleted))
+		}
+	}
+
+	var total int64
+	for _, p := range ps {
+		now := time.Now()
+		kinds := []struct {
+			name  string
+			after time.Duration
+			fn    func(*sql.Tx, DocTypeID, time.Time, int) (int, []string, error)
+		}{
+			{"documents", p.Documents, purgeDocuments},
+			{"events", p.Events, purgeAppliedEvents},
+			{"messages", p.Messages, purgeOldMessages},
+		}
+
+		for _, k := range kinds {
+			if k.after <= 0 {
+				continue
+			}
+			cutoff := now.Add(-k.after)
+			prog := &RetentionProgress{DocType: p.DocType, Kind: k.name}
+			for {
+				if err = ctx.Err(); err != nil {
+					return total, err
+				}
+
+				var n int
+				var files []string
+				err = withTx(nil, func(tx *sql.Tx) error {
+					var err error
+					n, files, err = k.fn(tx, p.DocType, cutoff, batch)
+					return err
+				})
+				if err != nil {
+					return total, err
+				}
+				for _, f := range files {
+					if err = os.Remove(f); err != nil && !os.IsNotExist(err) {
+						writeLog(LogWarn, "could not remove purged blob", F("path", f), F("error", err))
+					}
+				}
+
+				if n > 0 {
+					total += int64(n)
+					prog.Deleted += int64(n)
+					progress(prog)
+				}
+				if n < batch {
+					break
+				}
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// purgeIDs answers the first column of the rows of the given query, as
+// identifiers.
+func purgeIDs(tx *sql.Tx, q string, args ...interface{}) ([]interface{}, error) {
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []interface{}{}
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// purgeExec runs each of the given statements, having an `IN` list of
+// the given identifiers appended.
+func purgeExec(tx *sql.Tx, ids []interface{}, qs ...string) error {
+	in := inPlaceholders(len(ids))
+	for _, q := range qs {
+		if _, err := tx.Exec(q+in, ids...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeMessages removes the given messages, together with their
+// mailbox entries, reads, mentions, deliveries and reminders.
+func purgeMessages(tx *sql.Tx, ids []interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return purgeExec(tx, ids,
+		"DELETE FROM wf_mentions WHERE message_id IN ",
+		"DELETE FROM wf_mailbox_reads WHERE message_id IN ",
+		"DELETE FROM wf_email_deliveries WHERE message_id IN ",
+		"DELETE FROM wf_reminders WHERE message_id IN ",
+		"DELETE FROM wf_mailboxes WHERE message_id IN ",
+		"DELETE FROM wf_messages WHERE id IN ")
+}
+
+// purgeEvents removes the given events, together with their messages,
+// applications, signatures, return targets and webhook deliveries.
+func purgeEvents(tx *sql.Tx, ids []interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	mids, err := purgeIDs(tx, "SELECT id FROM wf_messages WHERE docevent_id IN "+inPlaceholders(len(ids)), ids...)
+	if err != nil {
+		return err
+	}
+	if err = purgeMessages(tx, mids); err != nil {
+		return err
+	}
+	return purgeExec(tx, ids,
+		"DELETE FROM wf_docevent_application WHERE docevent_id IN ",
+		"DELETE FROM wf_docevent_signatures WHERE docevent_id IN ",
+		"DELETE FROM wf_docevent_targets WHERE docevent_id IN ",
+		"DELETE FROM wf_webhook_deliveries WHERE docevent_id IN ",
+		"DELETE FROM wf_docevents WHERE id IN ")
+}
+
+// purgeOldMessages purges a batch of the messages of the given
+// document type, whose events are older than the given time.
+func purgeOldMessages(tx *sql.Tx, dtype DocTypeID, cutoff time.Time, batch int) (int, []string, error) {
+	q := `
+	SELECT msgs.id
+	FROM wf_messages msgs
+	JOIN wf_docevents de ON de.id = msgs.docevent_id
+	WHERE msgs.doctype_id = ?
+	AND de.ctime < ?
+	ORDER BY msgs.id
+	LIMIT ?
+	`
+	ids, err := purgeIDs(tx, q, dtype, cutoff, batch)
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(ids), nil, purgeMessages(tx, ids)
+}
+
+// purgeAppliedEvents purges a batch of the applied events of the given
+// document type, that are older than the given time.
+func purgeAppliedEvents(tx *sql.Tx, dtype DocTypeID, cutoff time.Time, batch int) (int, []string, error) {
+	q := `
+	SELECT id
+	FROM wf_docevents
+	WHERE doctype_id = ?
+	AND status = 'A'
+	AND ctime < ?
+	ORDER BY id
+	LIMIT ?
+	`
+	ids, err := purgeIDs(tx, q, dtype, cutoff, batch)
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(ids), nil, purgeEvents(tx, ids)
+}
+
+// docTable is a table holding rows about individual documents, keyed
+// by the given columns of document type and document ID.
+type docTable struct {
+	name    string
+	typeCol string
+	idCol   string
+}
+
+// docTables lists all the tables holding rows about individual
+// documents, other than their events, from which purged documents are
+// removed.  Tables added for documents should be listed here, lest
+// purged documents leave rows behind in them.
+var docTables = []docTable{
+	{"wf_document_blobs", "doctype_id", "doc_id"},
+	{"wf_document_tags", "doctype_id", "doc_id"},
+	{"wf_document_keys", "doctype_id", "doc_id"},
+	{"wf_document_changes", "doctype_id", "doc_id"},
+	{"wf_reminders", "doctype_id", "doc_id"},
+	{"wf_auto_actions", "doctype_id", "doc_id"},
+	{"wf_capacity_queue", "doctype_id", "doc_id"},
+	{"wf_sla_breaches", "doctype_id", "doc_id"},
+	{"wf_sla_timers", "doctype_id", "doc_id"},
+	{"wf_forced_states", "doctype_id", "doc_id"},
+	{"wf_share_accesses", "doctype_id", "doc_id"},
+	{"wf_share_tokens", "doctype_id", "doc_id"},
+	{"wf_votes", "doctype_id", "doc_id"},
+	{"wf_external_tasks", "doctype_id", "doc_id"},
+	{"wf_adhoc_steps", "doctype_id", "doc_id"},
+	{"wf_doc_branches", "doctype_id", "doc_id"},
+	{"wf_document_children", "parent_doctype_id", "parent_id"},
+	{"wf_document_children", "child_doctype_id", "child_id"},
+}
+
+// purgeDocuments purges a batch of the documents of the given type,
+// that rest in the states of the end nodes of their workflow, and
+// have not been touched since the given time.  It answers the paths
+// of the blobs no longer referenced, for removal once the transaction
+// commits.
+func purgeDocuments(tx *sql.Tx, dtype DocTypeID, cutoff time.Time, batch int) (int, []string, error) {
+	tbl := DocTypes.docStorName(dtype)
+	q := `
+	SELECT docs.id
+	FROM ` + tbl + ` docs
+	WHERE docs.docstate_id IN (
+		SELECT docstate_id
+		FROM wf_workflow_nodes
+		WHERE doctype_id = ?
+		AND type = 'end'
+	)
+	AND docs.ctime < ?
+	AND NOT EXISTS (
+		SELECT 1
+		FROM wf_docevents de
+		WHERE de.doctype_id = ?
+		AND de.doc_id = docs.id
+		AND de.ctime >= ?
+	)
+	ORDER BY docs.id
+	LIMIT ?
+	`
+	dids, err := purgeIDs(tx, q, dtype, cutoff, dtype, cutoff, batch)
+	if err != nil || len(dids) == 0 {
+		return 0, nil, err
+	}
+	in := inPlaceholders(len(dids))
+	args := append([]interface{}{dtype}, dids...)
+
+	eids, err := purgeIDs(tx, "SELECT id FROM wf_docevents WHERE doctype_id = ? AND doc_id IN "+in, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = purgeEvents(tx, eids); err != nil {
+		return 0, nil, err
+	}
+
+	// Blobs are shared by checksum; their files are removed only when
+	// no other document refers to them.
+	q = `
+	SELECT DISTINCT sha1sum, path
+	FROM wf_document_blobs
+	WHERE doctype_id = ?
+	AND doc_id IN ` + in
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	type blob struct{ sha1, path string }
+	blobs := []blob{}
+	for rows.Next() {
+		var b blob
+		if err = rows.Scan(&b.sha1, &b.path); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	for _, t := range docTables {
+		q := "DELETE FROM " + t.name + " WHERE " + t.typeCol + " = ? AND " + t.idCol + " IN " + in
+		if _, err = tx.Exec(q, args...); err != nil {
+			return 0, nil, err
+		}
+	}
+	if _, err = tx.Exec("DELETE FROM "+tbl+" WHERE id IN "+in, dids...); err != nil {
+		return 0, nil, err
+	}
+
+	files := []string{}
+	for _, b := range blobs {
+		var n int64
+		if err = tx.QueryRow("SELECT COUNT(*) FROM wf_document_blobs WHERE sha1sum = ?", b.sha1).Scan(&n); err != nil {
+			return 0, nil, err
+		}
+		if n == 0 {
+			files = append(files, b.path)
+		}
+	}
+
+	return len(dids), files, nil
+}
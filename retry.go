@@ -0,0 +1,164 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers of transient failures, upon which the whole
+// transaction can be retried.
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+// RetryPolicy governs the retrying of transactions that fail because
+// of deadlocks or lock wait time-outs.
+type RetryPolicy struct {
+	MaxAttempts int           // Including the first; defaults to 3.  A value of 1 disables retries
+	Backoff     time.Duration // Delay before the first retry; doubles thereafter.  Defaults to 20 milliseconds
+	MaxBackoff  time.Duration // Upper bound of the delay; defaults to 1 second
+}
+
+var retryMu sync.RWMutex
+var retryPolicy RetryPolicy
+
+// SetRetryPolicy configures the retrying of transactions run using
+// `RetryTx`.  Of the transactions that `flow` begins on its own, those
+// applying events are run so: in `Workflow.ApplyEvent`,
+// `ApplyEventOutcome`, `Workflows.Act`, `Documents.Vote` and
+// `Workflows.Compensate`, and in the timer tasks of external task
+// time-outs, automatic actions and node capacity queues.  Others are
+// not retried.  Zero values select the defaults.
+func SetRetryPolicy(p RetryPolicy) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	retryPolicy = p
+}
+
+// currentRetryPolicy answers the retry policy, with defaults applied.
+func currentRetryPolicy() RetryPolicy {
+	retryMu.RLock()
+	p := retryPolicy
+	retryMu.RUnlock()
+
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = 20 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = time.Second
+	}
+	return p
+}
+
+// IsTransientError answers `true` if the given error is a deadlock or
+// a lock wait time-out reported by MySQL, possibly wrapped.  The
+// transaction in which such an error occurs should be rolled back, and
+// can be retried in its entirety.
+func IsTransientError(err error) bool {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) {
+		return false
+	}
+	switch me.Number {
+	case mysqlErrDeadlock, mysqlErrLockWaitTimeout:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// RetryTx runs the given function in a new transaction, and commits
+// it.  Should the function or the commit fail with a transient error,
+// the transaction is rolled back, and the function is run afresh in a
+// new transaction, as per the retry policy.  Other errors are answered
+// immediately.
+//
+// Applications that compose several `flow` operations in a
+// transaction of their own should run it using this.  The function
+// should, therefore, be safe to run more than once; in particular, it
+// should not have side effects outside the transaction.
+func RetryTx(fn func(tx *sql.Tx) error) error {
+	p := currentRetryPolicy()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = runTx(fn)
+		if err == nil || !IsTransientError(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		delay := p.Backoff << uint(attempt-1)
+		if delay > p.MaxBackoff || delay <= 0 {
+			delay = p.MaxBackoff
+		}
+		// Jitter keeps competing transactions from colliding again.
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		writeLog(LogWarn, "retrying transaction", F("attempt", attempt), F("delay", delay), F("error", err))
+		time.Sleep(delay)
+	}
+}
+
+// retryWithTx runs the given function in the given transaction, if one
+// is given, as `withTx` does.  Otherwise, it runs the function using
+// `RetryTx`; the function should, therefore, be safe to run more than
+// once.
+func retryWithTx(otx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if otx != nil {
+		return fn(otx)
+	}
+	return RetryTx(fn)
+}
+
+// runTx runs the given function in a new transaction, committing it if
+// the function succeeds.
+func runTx(fn func(tx *sql.Tx) error) error {
+	return withTx(nil, fn)
+}
+
+// withTx runs the given function in the given transaction, if one is
+// given.  Committing or rolling back such a transaction is left to its
+// owner.
+//
+// Otherwise, it runs the function in a new transaction, which is
+// committed if the function succeeds, and rolled back if it fails or
+// panics.
+func withTx(otx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if otx != nil {
+		return fn(otx)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
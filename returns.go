@@ -0,0 +1,199 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+)
+
+// SetReturnAction designates the given action as the return action of
+// the given node.  An event performing the return action sends the
+// document back to the state named in its `Target`, which should be a
+// state that the document visited earlier.  An action of `0` removes
+// the designation.
+//
+// The return action should not be one of the actions defined on the
+// node's state, since those have fixed target states.
+func (_Nodes) SetReturnAction(otx *sql.Tx, id NodeID, action DocActionID) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	if action < 0 {
+		v.fail("action", "should be non-negative")
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	if action > 0 {
+		n, err := Nodes.Get(id)
+		if err != nil {
+			return err
+		}
+		ts, err := n.Transitions()
+		if err != nil {
+			return err
+		}
+		if _, ok := ts[action]; ok {
+			return errorf(CodeValidation, "action %d already has a transition from the state of node %d", action, id)
+		}
+		if _, err = DocActions.Get(action); err != nil {
+			return err
+		}
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if action == 0 {
+			_, err := tx.Exec("DELETE FROM wf_node_returns WHERE node_id = ?", id)
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_node_returns(node_id, docaction_id)
+		VALUES(?, ?)
+		ON DUPLICATE KEY UPDATE docaction_id = VALUES(docaction_id)
+		`
+		_, err := tx.Exec(q, id, action)
+		return err
+	})
+}
+
+// ReturnAction answers the return action of the given node, if any;
+// `0` otherwise.
+func (_Nodes) ReturnAction(id NodeID) (DocActionID, error) {
+	if id <= 0 {
+		return 0, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	return nodeReturnAction(nil, id)
+}
+
+// nodeReturnAction answers the return action of the given node, if
+// any; `0` otherwise.
+func nodeReturnAction(otx *sql.Tx, id NodeID) (DocActionID, error) {
+	row, err := stmts.queryRow(otx, "SELECT docaction_id FROM wf_node_returns WHERE node_id = ?", id)
+	if err != nil {
+		return 0, err
+	}
+	var action DocActionID
+	err = row.Scan(&action)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, nil
+
+	case err != nil:
+		return 0, err
+	}
+	return action, nil
+}
+
+// returnTarget answers the state to which the given event, which
+// performs an action without a transition from this node's state,
+// returns the document.  The action should be the node's return
+// action, and the target should be a state that the document visited
+// earlier.
+func (n *Node) returnTarget(otx *sql.Tx, event *DocEvent) (DocStateID, error) {
+	action, err := nodeReturnAction(otx, n.ID)
+	if err != nil {
+		return 0, err
+	}
+	if action == 0 || action != event.Action {
+		return 0, ErrWorkflowInvalidAction
+	}
+	if event.Target <= 0 {
+		return 0, newError(CodeValidation, "return action needs a target state")
+	}
+	if event.Target == event.State {
+		return 0, newError(CodeValidation, "target state should differ from the current state")
+	}
+
+	ok, err := visitedState(otx, event.DocType, event.DocID, event.Target)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errorf(CodeValidation, "document has not been in state %d", event.Target)
+	}
+	return event.Target, nil
+}
+
+// visitedState answers `true` if the given document has left the
+// given state at least once.
+func visitedState(otx *sql.Tx, dtype DocTypeID, did DocumentID, state DocStateID) (bool, error) {
+	q := `
+	SELECT COUNT(*)
+	FROM wf_docevent_application
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND from_state_id = ?
+	`
+	row, err := stmts.queryRow(otx, q, dtype, did, state)
+	if err != nil {
+		return false, err
+	}
+	var count int64
+	if err = row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// recordReturnTarget records the target state of the given event,
+// which performs a return action.
+func recordReturnTarget(tx *sql.Tx, eid DocEventID, target DocStateID) error {
+	_, err := tx.Exec("INSERT INTO wf_docevent_targets(docevent_id, to_state_id) VALUES(?, ?)", eid, target)
+	return err
+}
+
+// ReturnTargets answers the states to which the given document can be
+// returned: those that it visited earlier, other than its current
+// state.  They are ordered by name.
+func (_Documents) ReturnTargets(dtype DocTypeID, did DocumentID) ([]*DocState, error) {
+	if dtype <= 0 || did <= 0 {
+		return nil, newError(CodeValidation, "document type and document ID must be positive integers")
+	}
+
+	doc, err := Documents.Get(nil, dtype, did)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT DISTINCT dsm.id, dsm.name
+	FROM wf_docevent_application dea
+	JOIN wf_docstates_master dsm ON dsm.id = dea.from_state_id
+	WHERE dea.doctype_id = ?
+	AND dea.doc_id = ?
+	AND dea.from_state_id <> ?
+	ORDER BY dsm.name
+	`
+	ary := make([]*DocState, 0, 4)
+	err = scanEach(readDB().Query, q, []interface{}{dtype, did, doc.State.ID}, func(scan func(...interface{}) error) error {
+		var elem DocState
+		if err := scan(&elem.ID, &elem.Name); err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
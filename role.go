@@ -16,8 +16,6 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
-	"fmt"
 	"math"
 	"strings"
 )
@@ -41,39 +39,35 @@ var Roles _Roles
 
 // New creates a role with the given name.
 func (_Roles) New(otx *sql.Tx, name string) (RoleID, error) {
+	if err := checkAdmin(otx, AdminRoles); err != nil {
+		return 0, err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, errors.New("name cannot not be empty")
+	var v validator
+	v.name("name", name, maxRoleNameLen)
+	v.unique(otx, "name", "wf_roles_master", name, 0)
+	if err := v.result(); err != nil {
+		return 0, err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT INTO wf_roles_master(name) VALUES(?)", name)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	res, err := tx.Exec("INSERT INTO wf_roles_master(name) VALUES(?)", name)
-	if err != nil {
-		return 0, err
-	}
-	id, err := res.LastInsertId()
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
-		}
-	}
-
 	return RoleID(id), nil
 }
 
@@ -85,7 +79,7 @@ func (_Roles) New(otx *sql.Tx, name string) (RoleID, error) {
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_Roles) List(offset, limit int64) ([]*Role, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -97,7 +91,7 @@ func (_Roles) List(offset, limit int64) ([]*Role, error) {
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := readDB().Query(q, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -123,16 +117,22 @@ func (_Roles) List(offset, limit int64) ([]*Role, error) {
 // the database, and answers that.
 func (_Roles) Get(id RoleID) (*Role, error) {
 	if id <= 0 {
-		return nil, errors.New("ID must be a positive integer")
+		return nil, newError(CodeValidation, "ID must be a positive integer")
+	}
+
+	if v, ok := masters.get(masterRoles, id); ok {
+		elem := v.(Role)
+		return &elem, nil
 	}
 
 	var elem Role
-	row := db.QueryRow("SELECT id, name FROM wf_roles_master WHERE id = ?", id)
+	row := readDB().QueryRow("SELECT id, name FROM wf_roles_master WHERE id = ?", id)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrRoleNotFound)
 	}
 
+	masters.put(masterRoles, elem, elem.ID, elem.Name)
 	return &elem, nil
 }
 
@@ -141,188 +141,416 @@ func (_Roles) Get(id RoleID) (*Role, error) {
 func (_Roles) GetByName(name string) (*Role, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
-		return nil, errors.New("role cannot be empty")
+		return nil, newError(CodeValidation, "role cannot be empty")
+	}
+
+	if v, ok := masters.get(masterRoles, name); ok {
+		elem := v.(Role)
+		return &elem, nil
 	}
 
 	var elem Role
-	row := db.QueryRow("SELECT id, name FROM wf_roles_master WHERE name = ?", name)
+	row := readDB().QueryRow("SELECT id, name FROM wf_roles_master WHERE name = ?", name)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrRoleNotFound)
 	}
 
+	masters.put(masterRoles, elem, elem.ID, elem.Name)
 	return &elem, nil
 }
 
 // Rename renames the given role.
 func (_Roles) Rename(otx *sql.Tx, id RoleID, name string) error {
+	if err := checkAdmin(otx, AdminRoles); err != nil {
+		return err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return errors.New("name cannot be empty")
+	var v validator
+	v.positive("id", int64(id))
+	v.name("name", name, maxRoleNameLen)
+	v.unique(otx, "name", "wf_roles_master", name, int64(id))
+	if err := v.result(); err != nil {
+		return err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	defer masters.changed(otx, masterRoles)
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE wf_roles_master SET name = ? WHERE id = ?", name, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	_, err = tx.Exec("UPDATE wf_roles_master SET name = ? WHERE id = ?", name, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
 // Delete deletes the given role from the system, if no access context
 // is actively using it.
 func (_Roles) Delete(otx *sql.Tx, id RoleID) error {
+	if err := checkAdmin(otx, AdminRoles); err != nil {
+		return err
+	}
+
 	if id <= 0 {
-		return errors.New("role ID must be a positive integer")
+		return newError(CodeValidation, "role ID must be a positive integer")
 	}
 
 	row := db.QueryRow("SELECT COUNT(*) FROM wf_ac_group_roles WHERE role_id = ?", id)
 	var n int64
 	err := row.Scan(&n)
 	if n > 0 {
-		return errors.New("role is being used in at least one access context; cannot delete")
+		return newError(CodeConflict, "role is being used in at least one access context; cannot delete")
 	}
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err = db.Begin()
+	defer masters.changed(otx, masterRoles)
+	err = withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_role_docactions WHERE role_id = ?", id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
+		_, err = tx.Exec("DELETE FROM wf_role_all_docactions WHERE role_id = ?", id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM wf_admin_role_areas WHERE role_id = ?", id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM wf_admin_group_roles WHERE role_id = ?", id)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("DELETE FROM wf_roles_master WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		if n != 1 {
+			return errorf(CodeNotFound, "expected number of affected rows : 1; actual affected : %d", n)
+		}
 
-	_, err = tx.Exec("DELETE FROM wf_role_docactions WHERE role_id = ?", id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	res, err := tx.Exec("DELETE FROM wf_roles_master WHERE id = ?", id)
-	if err != nil {
+
+	return nil
+}
+
+// AddPermissions adds the given actions to this role, for the given
+// document type.
+func (_Roles) AddPermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error {
+	if err := checkAdmin(otx, AdminRoles); err != nil {
 		return err
 	}
-	n, err = res.RowsAffected()
-	if n != 1 {
-		return fmt.Errorf("expected number of affected rows : 1; actual affected : %d", n)
-	}
 
-	if otx == nil {
-		err = tx.Commit()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		rows := make([][]interface{}, 0, len(actions))
+		for _, action := range actions {
+			rows = append(rows, []interface{}{rid, dtype, action})
+		}
+		q := `INSERT INTO wf_role_docactions(role_id, doctype_id, docaction_id) VALUES`
+		err := insertRows(tx, q, `(?, ?, ?)`, rows)
 		if err != nil {
 			return err
 		}
-	}
 
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
-// AddPermissions adds the given actions to this role, for the given
-// document type.
-func (_Roles) AddPermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error {
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return err
+// RemovePermissions removes the given actions from this role, for the
+// given document type.
+func (_Roles) RemovePermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error {
+	if err := checkAdmin(otx, AdminRoles); err != nil {
+		return err
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		var err error
+		for len(actions) > 0 {
+			n := len(actions)
+			if n > maxBatchRows {
+				n = maxBatchRows
+			}
+
+			q := `
+			DELETE FROM wf_role_docactions
+			WHERE role_id = ?
+			AND doctype_id = ?
+			AND docaction_id IN ` + inPlaceholders(n)
+			args := []interface{}{rid, dtype}
+			for _, action := range actions[:n] {
+				args = append(args, action)
+			}
+			_, err = tx.Exec(q, args...)
+			if err != nil {
+				return err
+			}
+
+			actions = actions[n:]
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	return nil
+}
 
-	q := `
-	INSERT INTO wf_role_docactions(role_id, doctype_id, docaction_id)
-	VALUES(?, ?, ?)
+// PermissionLoss describes the documents, in a state and an access
+// context, on which a user would no longer be able to perform an
+// action.
+type PermissionLoss struct {
+	AccCtx    AccessContextID `json:"AccCtx"`    // Access context of the documents
+	User      UserID          `json:"User"`      // User losing the action
+	DocState  DocStateID      `json:"DocState"`  // Current state of the documents
+	Action    DocActionID     `json:"Action"`    // Action no longer permitted
+	Documents int64           `json:"Documents"` // Number of such documents
+}
+
+// PreviewRemovePermissions answers the losses that removing the given
+// actions from this role, for the given document type, would cause,
+// without removing them.  Removing permissions blind can stall
+// in-flight workflows.
+//
+// Only losses affecting current documents are reported: those of the
+// users who would be left with no other role permitting the action in
+// an access context having documents in a state from which the action
+// is possible.  Nothing is lost while the role has all the actions on
+// the document type (see `GrantAllActions`).
+func (_Roles) PreviewRemovePermissions(rid RoleID, dtype DocTypeID, actions []DocActionID) ([]*PermissionLoss, error) {
+	var v validator
+	v.positive("role ID", int64(rid))
+	v.positive("document type ID", int64(dtype))
+	if len(actions) == 0 {
+		v.fail("actions", "should be non-empty")
+	}
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+
+	rdb := readDB()
+	var n int64
+	q := `SELECT COUNT(*) FROM wf_role_all_docactions WHERE role_id = ? AND doctype_id = ?`
+	if err := rdb.QueryRow(q, rid, dtype).Scan(&n); err != nil {
+		return nil, err
+	}
+	ary := []*PermissionLoss{}
+	if n > 0 {
+		return ary, nil
+	}
+
+	q = `
+	SELECT DISTINCT acpv.ac_id, acpv.user_id, dst.from_state_id, acpv.docaction_id, docs.n
+	FROM wf_ac_perms_v acpv
+	JOIN wf_docstate_transitions dst ON dst.doctype_id = acpv.doctype_id AND dst.docaction_id = acpv.docaction_id
+	JOIN (
+		SELECT ac_id, docstate_id, COUNT(*) AS n
+		FROM ` + DocTypes.docStorName(dtype) + `
+		GROUP BY ac_id, docstate_id
+	) docs ON docs.ac_id = acpv.ac_id AND docs.docstate_id = dst.from_state_id
+	WHERE acpv.role_id = ?
+	AND acpv.doctype_id = ?
+	AND acpv.docaction_id IN ` + inPlaceholders(len(actions)) + `
+	AND NOT EXISTS (
+		SELECT 1
+		FROM wf_ac_perms_v other
+		WHERE other.ac_id = acpv.ac_id
+		AND other.user_id = acpv.user_id
+		AND other.doctype_id = acpv.doctype_id
+		AND other.docaction_id = acpv.docaction_id
+		AND other.role_id <> acpv.role_id
+	)
+	ORDER BY acpv.ac_id, acpv.user_id, dst.from_state_id, acpv.docaction_id
 	`
+	args := []interface{}{rid, dtype}
 	for _, action := range actions {
-		_, err = tx.Exec(q, rid, dtype, action)
-		if err != nil {
+		args = append(args, action)
+	}
+	err := scanEach(rdb.Query, q, args, func(scan func(...interface{}) error) error {
+		var elem PermissionLoss
+		if err := scan(&elem.AccCtx, &elem.User, &elem.DocState, &elem.Action, &elem.Documents); err != nil {
 			return err
 		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
+	return ary, nil
+}
+
+// SetPermissions reconciles the entire set of permissions of this role
+// with the given matrix of document types and their actions: missing
+// permissions are added, and those not in the matrix -- including all
+// those of the document types absent from it -- are removed, in one
+// transaction.  An empty matrix removes all the permissions of the
+// role.  Grants of all actions (see `GrantAllActions`) are retained.
+func (_Roles) SetPermissions(otx *sql.Tx, rid RoleID, matrix map[DocTypeID][]DocActionID) error {
+	if err := checkAdmin(otx, AdminRoles); err != nil {
+		return err
+	}
+
+	type perm struct {
+		dtype  DocTypeID
+		action DocActionID
+	}
+	want := map[perm]struct{}{}
+	var v validator
+	v.positive("role ID", int64(rid))
+	for dtype, actions := range matrix {
+		if dtype <= 0 {
+			v.fail("matrix", "document type IDs should be positive integers")
+		}
+		for _, action := range actions {
+			if action <= 0 {
+				v.fail("matrix", "action IDs should be positive integers")
+			}
+			want[perm{dtype, action}] = struct{}{}
 		}
 	}
-	return nil
-}
+	if err := v.result(); err != nil {
+		return err
+	}
 
-// RemovePermissions removes the given actions from this role, for the
-// given document type.
-func (_Roles) RemovePermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error {
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	return withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		SELECT id, doctype_id, docaction_id
+		FROM wf_role_docactions
+		WHERE role_id = ?
+		FOR UPDATE
+		`
+		extra := []interface{}{}
+		err := scanEach(tx.Query, q, []interface{}{rid}, func(scan func(...interface{}) error) error {
+			var id int64
+			var p perm
+			if err := scan(&id, &p.dtype, &p.action); err != nil {
+				return err
+			}
+			if _, ok := want[p]; ok {
+				delete(want, p)
+			} else {
+				extra = append(extra, id)
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
+
+		for len(extra) > 0 {
+			n := len(extra)
+			if n > maxBatchRows {
+				n = maxBatchRows
+			}
+			_, err = tx.Exec(`DELETE FROM wf_role_docactions WHERE id IN `+inPlaceholders(n), extra[:n]...)
+			if err != nil {
+				return err
+			}
+			extra = extra[n:]
+		}
+
+		rows := make([][]interface{}, 0, len(want))
+		for p := range want {
+			rows = append(rows, []interface{}{rid, p.dtype, p.action})
+		}
+		q = `INSERT INTO wf_role_docactions(role_id, doctype_id, docaction_id) VALUES`
+		return insertRows(tx, q, `(?, ?, ?)`, rows)
+	})
+}
+
+// GrantAllActions grants this role all the actions on documents of
+// the given type: those in its workflow now, and those added to it
+// later.  The grant is expanded when permissions are checked, and
+// coexists with the explicit permissions of the role.
+func (_Roles) GrantAllActions(otx *sql.Tx, rid RoleID, dtype DocTypeID) error {
+	if err := checkAdmin(otx, AdminRoles); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("role ID", int64(rid))
+	v.positive("document type ID", int64(dtype))
+	if err := v.result(); err != nil {
+		return err
 	}
 
+	_, err := stmts.exec(otx, "INSERT IGNORE INTO wf_role_all_docactions(role_id, doctype_id) VALUES(?, ?)", rid, dtype)
+	return err
+}
+
+// RevokeAllActions withdraws the grant of all the actions on documents
+// of the given type from this role.  Its explicit permissions on that
+// document type are retained.
+func (_Roles) RevokeAllActions(otx *sql.Tx, rid RoleID, dtype DocTypeID) error {
+	if err := checkAdmin(otx, AdminRoles); err != nil {
+		return err
+	}
+
+	_, err := stmts.exec(otx, "DELETE FROM wf_role_all_docactions WHERE role_id = ? AND doctype_id = ?", rid, dtype)
+	return err
+}
+
+// AllActionsDocTypes answers the document types on which this role
+// has been granted all the actions.
+func (_Roles) AllActionsDocTypes(rid RoleID) ([]DocTypeID, error) {
 	q := `
-	DELETE FROM wf_role_docactions
+	SELECT doctype_id
+	FROM wf_role_all_docactions
 	WHERE role_id = ?
-	AND doctype_id = ?
-	AND docaction_id = ?
+	ORDER BY doctype_id
 	`
-	for _, action := range actions {
-		_, err = tx.Exec(q, rid, dtype, action)
-		if err != nil {
+	ary := []DocTypeID{}
+	err := scanEach(readDB().Query, q, []interface{}{rid}, func(scan func(...interface{}) error) error {
+		var dtype DocTypeID
+		if err := scan(&dtype); err != nil {
 			return err
 		}
+		ary = append(ary, dtype)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return ary, nil
 }
 
 // Permissions answers the current set of permissions this role has.
 // It answers `nil` in case the given document type does not have any
-// permissions set in this role.
+// permissions set in this role.  Inactive actions are omitted.  Grants
+// of all actions are expanded to the actions currently in the
+// workflow of the document type.
 func (_Roles) Permissions(rid RoleID) (map[string]struct {
 	DocTypeID DocTypeID
 	Actions   []*DocAction
 }, error) {
 	q := `
-	SELECT dtm.id, dtm.name, dam.id, dam.name, dam.reconfirm
+	SELECT dtm.id, dtm.name, dam.id, dam.name, dam.reconfirm, dam.active
 	FROM wf_doctypes_master dtm
-	JOIN wf_role_docactions rdas ON dtm.id = rdas.doctype_id
-	JOIN wf_docactions_master dam ON dam.id = rdas.docaction_id
-	WHERE rdas.role_id = ?
+	JOIN wf_role_perms_v rps ON dtm.id = rps.doctype_id
+	JOIN wf_docactions_master dam ON dam.id = rps.docaction_id
+	WHERE rps.role_id = ?
+	AND dam.active = 1
 	`
-	rows, err := db.Query(q, rid)
+	rows, err := readDB().Query(q, rid)
 	if err != nil {
 		return nil, err
 	}
@@ -335,7 +563,7 @@ func (_Roles) Permissions(rid RoleID) (map[string]struct {
 	for rows.Next() {
 		var dt DocType
 		var da DocAction
-		err = rows.Scan(&dt.ID, &dt.Name, &da.ID, &da.Name, &da.Reconfirm)
+		err = rows.Scan(&dt.ID, &dt.Name, &da.ID, &da.Name, &da.Reconfirm, &da.Active)
 		if err != nil {
 			return nil, err
 		}
@@ -355,19 +583,19 @@ func (_Roles) Permissions(rid RoleID) (map[string]struct {
 }
 
 // HasPermission answers `true` if this role has the queried
-// permission for the given document type.
+// permission for the given document type, explicitly or through a
+// grant of all actions.
 func (_Roles) HasPermission(rid RoleID, dtype DocTypeID, action DocActionID) (bool, error) {
 	q := `
-	SELECT rdas.id FROM wf_role_docactions rdas
-	JOIN wf_doctypes_master dtm ON rdas.doctype_id = dtm.id
-	JOIN wf_docactions_master dam ON rdas.docaction_id = dam.id
-	WHERE rdas.role_id = ?
+	SELECT 1 FROM wf_role_perms_v rps
+	JOIN wf_doctypes_master dtm ON rps.doctype_id = dtm.id
+	JOIN wf_docactions_master dam ON rps.docaction_id = dam.id
+	WHERE rps.role_id = ?
 	AND dtm.id = ?
 	AND dam.id = ?
-	ORDER BY rdas.id
 	LIMIT 1
 	`
-	row := db.QueryRow(q, rid, dtype, action)
+	row := readDB().QueryRow(q, rid, dtype, action)
 	var n int64
 	err := row.Scan(&n)
 	if err != nil {
@@ -0,0 +1,310 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefRunnerLock is the default name of the lock that elects the
+// leader among the runners of several instances; see `Locks`.
+const DefRunnerLock = "flow.runner"
+
+// runnerElectInterval is the time between attempts to acquire, or to
+// confirm, the leadership.
+const runnerElectInterval = 10 * time.Second
+
+// RunnerTask performs one round of background work.  It answers the
+// number of items processed.
+type RunnerTask func(ctx context.Context) (int, error)
+
+// RunnerJob is a background subsystem scheduled by a `Runner`.
+type RunnerJob struct {
+	Name        string        // Unique name of this job
+	Task        RunnerTask    // One round of the work of this job
+	Interval    time.Duration // Time between the rounds of each worker
+	Concurrency int           // Number of workers running rounds concurrently; defaults to 1
+	LeaderOnly  bool          // Run only in the instance holding the leadership?
+
+	wake <-chan struct{} // Starts a round early, if given
+}
+
+// Runner schedules the background subsystems of `flow` -- timers,
+// outbox relay and retention -- and any jobs added by the
+// application, such as `EmailDispatcher.Dispatch`, in one place.
+//
+// Each job has its own interval and number of workers.  Jobs whose
+// items are claimed before processing -- all those of `flow` except
+// retention -- can run in all the instances of an application at
+// once.  Other jobs should be marked `LeaderOnly`: they run only in
+// the instance holding a lock (see `Locks`), which is taken over by
+// another instance should the leader stop.
+type Runner struct {
+	LockName string                      // Lock electing the leader; defaults to `DefRunnerLock`
+	OnError  func(job string, err error) // Receives the errors of rounds; they are logged otherwise
+
+	mu     sync.Mutex
+	jobs   []*RunnerJob
+	leader int32
+}
+
+// NewRunner answers a runner having the standard jobs of `flow`:
+//
+//   - `timers`: `FireTimers` every 30 seconds;
+//   - `outbox`: the outbox relay, as per `OutboxConfig.PollInterval`,
+//     and whenever `Outbox.Notify` is called; and
+//   - `retention`: `Retention.Run` every hour, in the leader only.
+//
+// Their intervals and concurrency can be changed using `Add`.
+func NewRunner() *Runner {
+	r := &Runner{}
+	r.Add(RunnerJob{Name: "timers", Task: FireTimers, Interval: 30 * time.Second})
+	r.Add(RunnerJob{Name: "outbox", Task: drainOutbox, Interval: Outbox.config().PollInterval, wake: outboxWake})
+	r.Add(RunnerJob{Name: "retention", Task: retentionTask, Interval: time.Hour, LeaderOnly: true})
+	return r
+}
+
+// drainOutbox relays outbox entries until none is due.
+func drainOutbox(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := Outbox.Dispatch(ctx)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+// retentionTask runs the retention policies.
+func retentionTask(ctx context.Context) (int, error) {
+	n, err := Retention.Run(ctx)
+	return int(n), err
+}
+
+// Add schedules the given job, replacing the one having the same name,
+// if any.  Jobs added after `Run` begins take effect when it is run
+// again.
+func (r *Runner) Add(job RunnerJob) error {
+	var v validator
+	job.Name = strings.TrimSpace(job.Name)
+	v.required("name", job.Name)
+	if job.Task == nil {
+		v.fail("task", "is required")
+	}
+	if job.Interval <= 0 {
+		v.fail("interval", "should be a positive duration")
+	}
+	if job.Concurrency < 0 {
+		v.fail("concurrency", "should be non-negative")
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+	if job.Concurrency == 0 {
+		job.Concurrency = 1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, j := range r.jobs {
+		if j.Name == job.Name {
+			if job.wake == nil {
+				job.wake = j.wake
+			}
+			r.jobs[i] = &job
+			return nil
+		}
+	}
+	r.jobs = append(r.jobs, &job)
+	return nil
+}
+
+// Remove unschedules the job having the given name, if any.
+func (r *Runner) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, j := range r.jobs {
+		if j.Name == name {
+			r.jobs = append(r.jobs[:i], r.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Leader answers `true` if this runner currently holds the leadership.
+func (r *Runner) Leader() bool {
+	return atomic.LoadInt32(&r.leader) == 1
+}
+
+// Run runs the scheduled jobs until the given context is cancelled.
+// Rounds in progress are then allowed to finish -- tasks observe the
+// cancellation between their items -- and the leadership, if held, is
+// released before Run returns.
+func (r *Runner) Run(ctx context.Context) error {
+	r.mu.Lock()
+	jobs := make([]*RunnerJob, len(r.jobs))
+	copy(jobs, r.jobs)
+	r.mu.Unlock()
+
+	// The leadership outlives the rounds in progress.
+	ectx, cancel := context.WithCancel(context.Background())
+	var ewg sync.WaitGroup
+	for _, j := range jobs {
+		if j.LeaderOnly {
+			ewg.Add(1)
+			go func() {
+				defer ewg.Done()
+				r.elect(ectx)
+			}()
+			break
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		for i := 0; i < j.Concurrency; i++ {
+			wg.Add(1)
+			go func(j *RunnerJob) {
+				defer wg.Done()
+				r.work(ctx, j)
+			}(j)
+		}
+	}
+	wg.Wait()
+
+	cancel()
+	ewg.Wait()
+	return ctx.Err()
+}
+
+// work runs the rounds of the given job, until the given context is
+// cancelled.
+func (r *Runner) work(ctx context.Context, j *RunnerJob) {
+	for {
+		interval := j.Interval
+		if j.LeaderOnly && !r.Leader() {
+			// Check back soon, rather than a whole interval later.
+			if interval > runnerElectInterval {
+				interval = runnerElectInterval
+			}
+		} else {
+			_, err := j.Task(ctx)
+			if err != nil && ctx.Err() == nil {
+				if r.OnError != nil {
+					r.OnError(j.Name, err)
+				} else {
+					writeLog(LogError, "runner job failed", F("job", j.Name), F("error", err))
+				}
+			}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+
+		case <-j.wake:
+			timer.Stop()
+
+		case <-timer.C:
+		}
+	}
+}
+
+// elect acquires the leadership when it is free, and refreshes it
+// periodically thereafter, until the given context is cancelled.  The
+// leadership is a lease of `Locks`, outliving a few failed refreshes;
+// it is given up once the lease may have expired unrefreshed.
+func (r *Runner) elect(ctx context.Context) {
+	name := r.LockName
+	if name == "" {
+		name = DefRunnerLock
+	}
+	ttl := 3 * runnerElectInterval
+
+	var l *Lock
+	var renewed time.Time // Most recent acquisition or refresh
+	defer func() {
+		if l == nil {
+			return
+		}
+		atomic.StoreInt32(&r.leader, 0)
+		if err := l.Release(); err != nil {
+			writeLog(LogWarn, "runner leadership not released", F("lock", name), F("error", err))
+			return
+		}
+		writeLog(LogInfo, "runner leadership released", F("lock", name))
+	}()
+
+	for {
+		var err error
+		at := time.Now()
+		switch {
+		case l == nil:
+			l, err = Locks.Acquire(name, ttl)
+			switch {
+			case err == nil:
+				renewed = at
+				atomic.StoreInt32(&r.leader, 1)
+				writeLog(LogInfo, "runner elected leader", F("lock", name))
+
+			case err == ErrLockHeld:
+				// Another instance leads.
+
+			default:
+				writeLog(LogWarn, "runner election failed", F("lock", name), F("error", err))
+			}
+
+		default:
+			err = l.Refresh(ttl)
+			switch {
+			case err == nil:
+				renewed = at
+
+			case err == ErrLockLost:
+				l = nil
+				atomic.StoreInt32(&r.leader, 0)
+				writeLog(LogWarn, "runner leadership lost", F("lock", name))
+
+			case time.Since(renewed)+runnerElectInterval >= ttl:
+				// The lease may expire before the next refresh, after
+				// which another instance can acquire the lock.
+				l = nil
+				atomic.StoreInt32(&r.leader, 0)
+				writeLog(LogWarn, "runner leadership expired", F("lock", name), F("error", err))
+
+			default:
+				writeLog(LogWarn, "runner leadership not refreshed", F("lock", name), F("error", err))
+			}
+		}
+
+		timer := time.NewTimer(runnerElectInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+
+		case <-timer.C:
+		}
+	}
+}
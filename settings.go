@@ -0,0 +1,386 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The following are the settings that `flow` itself consults.
+// Applications can define their own, with keys outside the `flow.`
+// namespace.
+const (
+	// SettingACRoleLimit : maximum number of roles that a group can
+	// have in an access context; `0`, the default, imposes no limit.
+	// Consulted in the scope of the access context.
+	SettingACRoleLimit = "flow.ac.role_limit"
+	// SettingDocMaxDepth : maximum nesting depth of child documents;
+	// `DefDocMaxDepth` by default, and no limit if not positive.
+	// Consulted in the scope of the child's document type.
+	SettingDocMaxDepth = "flow.doc.max_depth"
+	// SettingACDelimiter : the single character delimiting the levels
+	// of hierarchical names of access contexts; `DefACDelimiter` by
+	// default.  Consulted globally.
+	SettingACDelimiter = "flow.ac.delimiter"
+)
+
+// featurePrefix is the namespace of the keys of feature flags.
+const featurePrefix = "feature."
+
+// maxSettingLen is the maximum length of the value of a setting.
+const maxSettingLen = 10000
+
+var reSettingKey = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z0-9_]+)*$`)
+
+// SettingScope identifies where a setting applies: globally when both
+// the identifiers are `0`, or else to the given access context or to
+// the given document type.
+//
+// When a setting is looked up for a scope, the value of the document
+// type is preferred, then that of the access context, and then the
+// global one.
+type SettingScope struct {
+	AccCtx  AccessContextID `json:"AccCtx,omitempty"`  // Access context, if specific to one
+	DocType DocTypeID       `json:"DocType,omitempty"` // Document type, if specific to one
+}
+
+// Setting is a named value tuning the engine or the application.
+type Setting struct {
+	Key   string       `json:"Key"`   // Dotted name of this setting
+	Scope SettingScope `json:"Scope"` // Where this value applies
+	Value string       `json:"Value"` // The value, as text
+	Mtime time.Time    `json:"Mtime"` // Time of the last change
+}
+
+// SettingChange records a change to a setting.
+type SettingChange struct {
+	Key      string       `json:"Key"`            // Name of the setting
+	Scope    SettingScope `json:"Scope"`          // Scope of the value changed
+	OldValue *string      `json:"OldValue"`       // Previous value; `nil` if there was none
+	NewValue *string      `json:"NewValue"`       // New value; `nil` if the value was deleted
+	User     UserID       `json:"User,omitempty"` // Acting user, if the change was made through `AsAdmin`
+	Ctime    time.Time    `json:"Ctime"`          // Time of the change
+}
+
+// Unexported type, only for convenience methods.
+type _Settings struct{}
+
+// Settings provides a resource-like interface to the settings of the
+// engine and of the application, including feature flags.  All
+// changes to settings are recorded; see `History`.
+var Settings _Settings
+
+// validateSetting checks the given key and scope.
+func validateSetting(v *validator, key string, scope SettingScope) {
+	if len(key) > maxNameLen || !reSettingKey.MatchString(key) {
+		v.fail("key", "should be lower-case words separated by dots")
+	}
+	if scope.AccCtx < 0 || scope.DocType < 0 {
+		v.fail("scope", "identifiers should be non-negative")
+	}
+	if scope.AccCtx > 0 && scope.DocType > 0 {
+		v.fail("scope", "should be global, or specific to either an access context or a document type")
+	}
+}
+
+// Set assigns the given value to the given setting in the given
+// scope, replacing the existing one, if any.
+func (_Settings) Set(otx *sql.Tx, key string, scope SettingScope, value string) error {
+	if err := checkAdmin(otx, AdminSettings); err != nil {
+		return err
+	}
+
+	key = strings.TrimSpace(key)
+	var v validator
+	validateSetting(&v, key, scope)
+	if len(value) > maxSettingLen {
+		v.fail("value", "too long")
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		return storeSetting(tx, key, scope, &value)
+	})
+}
+
+// Delete removes the value of the given setting in the given scope,
+// if any.  Look-ups in the scope fall back to the broader scopes
+// thereafter.
+func (_Settings) Delete(otx *sql.Tx, key string, scope SettingScope) error {
+	if err := checkAdmin(otx, AdminSettings); err != nil {
+		return err
+	}
+
+	key = strings.TrimSpace(key)
+	var v validator
+	validateSetting(&v, key, scope)
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		return storeSetting(tx, key, scope, nil)
+	})
+}
+
+// storeSetting assigns the given value to the given setting in the
+// given scope, or removes its value if `nil`, recording the change.
+func storeSetting(tx *sql.Tx, key string, scope SettingScope, value *string) error {
+	old, err := lockSetting(tx, key, scope)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case value == nil && old == nil, value != nil && old != nil && *old == *value:
+		return nil
+
+	case value == nil:
+		q := `
+		DELETE FROM wf_settings
+		WHERE name = ?
+		AND ac_id = ?
+		AND doctype_id = ?
+		`
+		_, err = tx.Exec(q, key, scope.AccCtx, scope.DocType)
+
+	default:
+		q := `
+		INSERT INTO wf_settings(name, ac_id, doctype_id, value, mtime)
+		VALUES(?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE value = VALUES(value), mtime = VALUES(mtime)
+		`
+		_, err = tx.Exec(q, key, scope.AccCtx, scope.DocType, *value)
+	}
+	if err != nil {
+		return err
+	}
+	return recordSettingChange(tx, key, scope, old, value)
+}
+
+// lockSetting answers the current value of the given setting in
+// exactly the given scope, locking it; `nil` if it has none.
+func lockSetting(tx *sql.Tx, key string, scope SettingScope) (*string, error) {
+	q := `
+	SELECT value
+	FROM wf_settings
+	WHERE name = ?
+	AND ac_id = ?
+	AND doctype_id = ?
+	FOR UPDATE
+	`
+	var val string
+	err := tx.QueryRow(q, key, scope.AccCtx, scope.DocType).Scan(&val)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	return &val, nil
+}
+
+// recordSettingChange records a change to the given setting, on behalf
+// of the acting user of the transaction, if any.
+func recordSettingChange(tx *sql.Tx, key string, scope SettingScope, old, val *string) error {
+	var uid sql.NullInt64
+	actorsMu.RLock()
+	if id, ok := actors[tx]; ok {
+		uid = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+	actorsMu.RUnlock()
+
+	q := `
+	INSERT INTO wf_setting_changes(name, ac_id, doctype_id, old_value, new_value, user_id, ctime)
+	VALUES(?, ?, ?, ?, ?, ?, NOW())
+	`
+	_, err := tx.Exec(q, key, scope.AccCtx, scope.DocType, old, val, uid)
+	return err
+}
+
+// settingValue looks the given setting up for the given scope, falling
+// back to the broader scopes.  It answers `nil` if the setting has no
+// applicable value.
+func settingValue(queryRow func(string, ...interface{}) *sql.Row, key string,
+	scope SettingScope) (*string, error) {
+	q := `
+	SELECT value
+	FROM wf_settings
+	WHERE name = ?
+	AND ((ac_id = 0 AND doctype_id = 0) OR (ac_id = ? AND doctype_id = 0) OR (ac_id = 0 AND doctype_id = ?))
+	ORDER BY doctype_id DESC, ac_id DESC
+	LIMIT 1
+	`
+	var val string
+	err := queryRow(q, strings.TrimSpace(key), scope.AccCtx, scope.DocType).Scan(&val)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+	return &val, nil
+}
+
+// String answers the value of the given setting applicable to the
+// given scope, or the given default if it has none.
+func (_Settings) String(key string, scope SettingScope, def string) (string, error) {
+	val, err := settingValue(readDB().QueryRow, key, scope)
+	if err != nil || val == nil {
+		return def, err
+	}
+	return *val, nil
+}
+
+// Int answers the value of the given setting applicable to the given
+// scope as an integer, or the given default if it has none.
+func (_Settings) Int(key string, scope SettingScope, def int64) (int64, error) {
+	return settingInt(readDB().QueryRow, key, scope, def)
+}
+
+// settingInt answers the value of the given setting as an integer.
+func settingInt(queryRow func(string, ...interface{}) *sql.Row, key string, scope SettingScope,
+	def int64) (int64, error) {
+	val, err := settingValue(queryRow, key, scope)
+	if err != nil || val == nil {
+		return def, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(*val), 10, 64)
+	if err != nil {
+		return def, errorf(CodeValidation, "setting %s is not an integer : %q", key, *val)
+	}
+	return n, nil
+}
+
+// Bool answers the value of the given setting applicable to the given
+// scope as a boolean, or the given default if it has none.  Values are
+// parsed as by `strconv.ParseBool`.
+func (_Settings) Bool(key string, scope SettingScope, def bool) (bool, error) {
+	val, err := settingValue(readDB().QueryRow, key, scope)
+	if err != nil || val == nil {
+		return def, err
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(*val))
+	if err != nil {
+		return def, errorf(CodeValidation, "setting %s is not a boolean : %q", key, *val)
+	}
+	return b, nil
+}
+
+// Duration answers the value of the given setting applicable to the
+// given scope as a duration, or the given default if it has none.
+// Values are parsed as by `time.ParseDuration`.
+func (_Settings) Duration(key string, scope SettingScope, def time.Duration) (time.Duration, error) {
+	val, err := settingValue(readDB().QueryRow, key, scope)
+	if err != nil || val == nil {
+		return def, err
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(*val))
+	if err != nil {
+		return def, errorf(CodeValidation, "setting %s is not a duration : %q", key, *val)
+	}
+	return d, nil
+}
+
+// SetFeature turns the given feature flag on or off in the given
+// scope.  Feature flags are settings under the `feature.` namespace.
+func (_Settings) SetFeature(otx *sql.Tx, name string, scope SettingScope, on bool) error {
+	return Settings.Set(otx, featurePrefix+strings.TrimSpace(name), scope, strconv.FormatBool(on))
+}
+
+// Enabled answers `true` if the given feature flag is on in the given
+// scope.  Flags are off unless turned on.
+func (_Settings) Enabled(name string, scope SettingScope) (bool, error) {
+	return Settings.Bool(featurePrefix+strings.TrimSpace(name), scope, false)
+}
+
+// List answers the values of all the settings whose keys begin with
+// the given prefix, in all scopes, ordered by key.
+func (_Settings) List(prefix string) ([]*Setting, error) {
+	q := `
+	SELECT name, ac_id, doctype_id, value, mtime
+	FROM wf_settings
+	WHERE name LIKE CONCAT(?, '%')
+	ORDER BY name, doctype_id, ac_id
+	`
+	prefix = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.TrimSpace(prefix))
+	ary := []*Setting{}
+	err := scanEach(readDB().Query, q, []interface{}{prefix}, func(scan func(...interface{}) error) error {
+		var elem Setting
+		if err := scan(&elem.Key, &elem.Scope.AccCtx, &elem.Scope.DocType, &elem.Value, &elem.Mtime); err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// History answers the changes made to the given setting, in all
+// scopes, newest first.
+func (_Settings) History(key string, offset, limit int64) ([]*SettingChange, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT name, ac_id, doctype_id, old_value, new_value, user_id, ctime
+	FROM wf_setting_changes
+	WHERE name = ?
+	ORDER BY id DESC
+	LIMIT ? OFFSET ?
+	`
+	ary := []*SettingChange{}
+	args := []interface{}{strings.TrimSpace(key), limit, offset}
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
+		var elem SettingChange
+		var old, val sql.NullString
+		var uid sql.NullInt64
+		err := scan(&elem.Key, &elem.Scope.AccCtx, &elem.Scope.DocType, &old, &val, &uid, &elem.Ctime)
+		if err != nil {
+			return err
+		}
+		if old.Valid {
+			elem.OldValue = &old.String
+		}
+		if val.Valid {
+			elem.NewValue = &val.String
+		}
+		elem.User = UserID(uid.Int64)
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
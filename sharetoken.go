@@ -0,0 +1,359 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ShareTokenID is the type of unique identifiers of share tokens.
+type ShareTokenID int64
+
+// ShareScope enumerates what a share token grants access to.
+type ShareScope uint8
+
+const (
+	// ShareDocument : the document only
+	ShareDocument ShareScope = iota + 1
+	// ShareWithBlobs : the document and its blobs
+	ShareWithBlobs
+)
+
+// maxShareTTL is the longest that a share token can remain valid.
+const maxShareTTL = 90 * 24 * time.Hour
+
+// minShareKeyLen is the minimum length of the key signing share
+// tokens.
+const minShareKeyLen = 32
+
+// maxAccessorLen is the length to which accessors are truncated in
+// the access log.
+const maxAccessorLen = 200
+
+var shareKey []byte
+
+// SetShareKey sets the secret key with which share tokens are signed.
+// It should be at least 32 bytes long, and should be kept secret.
+//
+// N.B. Changing the key invalidates all the share tokens issued
+// earlier.
+func SetShareKey(key []byte) error {
+	if len(key) < minShareKeyLen {
+		return errorf(CodeValidation, "share key should be at least %d bytes long", minShareKeyLen)
+	}
+	shareKey = append([]byte(nil), key...)
+
+	return nil
+}
+
+// ShareToken records a grant of time-limited read access to a
+// document, for parties that do not have user accounts.
+type ShareToken struct {
+	ID      ShareTokenID `json:"ID"`      // Unique identifier of this token
+	DocType DocTypeID    `json:"DocType"` // Type of the shared document
+	DocID   DocumentID   `json:"DocID"`   // The shared document
+	Scope   ShareScope   `json:"Scope"`   // What is shared
+	Expires time.Time    `json:"Expires"` // Time when this token expires
+	Revoked bool         `json:"Revoked"` // Has this token been revoked?
+	Ctime   time.Time    `json:"Ctime"`   // Time when this token was created
+}
+
+// ShareAccess records one access made using a share token.
+type ShareAccess struct {
+	Token ShareTokenID `json:"Token"`          // Token used
+	Blob  string       `json:"Blob,omitempty"` // SHA1 sum of the blob read, if any; the document otherwise
+	From  string       `json:"From"`           // Accessor, as identified by the application
+	Ctime time.Time    `json:"Ctime"`          // Time of access
+}
+
+// signShareToken answers the signature of the given token.
+func signShareToken(id ShareTokenID, dtype DocTypeID, did DocumentID, scope string) string {
+	mac := hmac.New(sha256.New, shareKey)
+	fmt.Fprintf(mac, "%d:%d:%d:%s", id, dtype, did, scope)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateShareToken creates a token granting read access to the given
+// document -- and to its blobs, should the scope so specify -- for the
+// given duration.  The token answered should be handed over to the
+// external party; it is not stored, and cannot be retrieved later.
+//
+// `SetShareKey` should have been called earlier.
+func (_Documents) CreateShareToken(otx *sql.Tx, dtype DocTypeID, id DocumentID, ttl time.Duration,
+	scope ShareScope) (string, error) {
+	var v validator
+	v.positive("dtype", int64(dtype))
+	v.positive("docID", int64(id))
+	if ttl < time.Minute || ttl > maxShareTTL {
+		v.fail("ttl", "should be between a minute and 90 days")
+	}
+	var sc string
+	switch scope {
+	case ShareDocument:
+		sc = "D"
+
+	case ShareWithBlobs:
+		sc = "B"
+
+	default:
+		v.fail("scope", "unknown scope")
+	}
+	if err := v.result(); err != nil {
+		return "", err
+	}
+	if len(shareKey) == 0 {
+		return "", newError(CodeInternal, "share key is not set")
+	}
+
+	var tid int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		if _, err := Documents.Get(tx, dtype, id, WithoutData()); err != nil {
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_share_tokens(doctype_id, doc_id, scope, expires_at, revoked, ctime)
+		VALUES(?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND), 0, NOW())
+		`
+		res, err := tx.Exec(q, dtype, id, sc, int64(ttl/time.Second))
+		if err != nil {
+			return err
+		}
+		tid, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d.%s", tid, signShareToken(ShareTokenID(tid), dtype, id, sc)), nil
+}
+
+// checkShareToken verifies the given token, and answers what it
+// grants access to.  Malformed, forged, expired and revoked tokens are
+// all answered `ErrShareTokenInvalid`, alike.
+func checkShareToken(token string) (*ShareToken, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || len(shareKey) == 0 {
+		return nil, ErrShareTokenInvalid
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || id <= 0 {
+		return nil, ErrShareTokenInvalid
+	}
+
+	// Revocations should take effect immediately; hence, the primary.
+	q := `
+	SELECT doctype_id, doc_id, scope, expires_at, ctime, expires_at > NOW() AND revoked = 0
+	FROM wf_share_tokens
+	WHERE id = ?
+	`
+	st := ShareToken{ID: ShareTokenID(id)}
+	var sc string
+	var live bool
+	err = db.QueryRow(q, id).Scan(&st.DocType, &st.DocID, &sc, &st.Expires, &st.Ctime, &live)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrShareTokenInvalid
+
+	case err != nil:
+		return nil, err
+	}
+	sig := signShareToken(st.ID, st.DocType, st.DocID, sc)
+	if !hmac.Equal([]byte(sig), []byte(parts[1])) || !live {
+		return nil, ErrShareTokenInvalid
+	}
+	st.Scope = ShareDocument
+	if sc == "B" {
+		st.Scope = ShareWithBlobs
+	}
+	return &st, nil
+}
+
+// logShareAccess records an access made using the given token.
+func logShareAccess(st *ShareToken, blob, from string) error {
+	if len(from) > maxAccessorLen {
+		from = from[:maxAccessorLen]
+	}
+	var b sql.NullString
+	if blob != "" {
+		b = sql.NullString{String: blob, Valid: true}
+	}
+	q := `
+	INSERT INTO wf_share_accesses(token_id, doctype_id, doc_id, blob_sha1, accessor, ctime)
+	VALUES(?, ?, ?, ?, ?, NOW())
+	`
+	_, err := db.Exec(q, st.ID, st.DocType, st.DocID, b, from)
+	return err
+}
+
+// GetShared answers the document to which the given share token grants
+// access.  Its blobs are listed, should the token's scope include
+// them.  `from` identifies the accessor -- e.g. by the network address
+// -- in the access log.
+func (_Documents) GetShared(token, from string) (*Document, error) {
+	st, err := checkShareToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []ReadOption{WithTags()}
+	if st.Scope == ShareWithBlobs {
+		opts = append(opts, WithBlobs())
+	}
+	doc, err := Documents.Get(nil, st.DocType, st.DocID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range doc.Blobs {
+		b.Path = ""
+	}
+
+	if err = logShareAccess(st, "", from); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// GetSharedBlob copies the requested blob of the document to which the
+// given share token grants access into the path specified, as
+// `Documents.GetBlob` does.  The token's scope should include blobs.
+func (_Documents) GetSharedBlob(token, from string, blob *Blob) error {
+	if blob == nil {
+		return newError(CodeValidation, "blob should be non-nil")
+	}
+
+	st, err := checkShareToken(token)
+	if err != nil {
+		return err
+	}
+	if st.Scope != ShareWithBlobs {
+		return ErrPermissionDenied
+	}
+
+	if err = Documents.GetBlob(st.DocType, st.DocID, blob); err != nil {
+		return err
+	}
+	return logShareAccess(st, blob.SHA1Sum, from)
+}
+
+// RevokeShareToken revokes the given share token, with immediate
+// effect.
+func (_Documents) RevokeShareToken(otx *sql.Tx, id ShareTokenID) error {
+	if id <= 0 {
+		return newError(CodeValidation, "token ID must be a positive integer")
+	}
+
+	res, err := stmts.exec(otx, "UPDATE wf_share_tokens SET revoked = 1 WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var count int64
+		row, err := stmts.queryRow(otx, "SELECT COUNT(*) FROM wf_share_tokens WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if err = row.Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrShareTokenNotFound
+		}
+	}
+	return nil
+}
+
+// ShareTokens answers the share tokens created for the given document,
+// including those that have expired or been revoked, newest first.
+func (_Documents) ShareTokens(dtype DocTypeID, id DocumentID) ([]*ShareToken, error) {
+	if dtype <= 0 || id <= 0 {
+		return nil, newError(CodeValidation, "document type and document ID must be positive integers")
+	}
+
+	q := `
+	SELECT id, doctype_id, doc_id, scope, expires_at, revoked, ctime
+	FROM wf_share_tokens
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	ORDER BY id DESC
+	`
+	ary := make([]*ShareToken, 0, 2)
+	err := scanEach(readDB().Query, q, []interface{}{dtype, id}, func(scan func(...interface{}) error) error {
+		var elem ShareToken
+		var sc string
+		if err := scan(&elem.ID, &elem.DocType, &elem.DocID, &sc, &elem.Expires, &elem.Revoked, &elem.Ctime); err != nil {
+			return err
+		}
+		elem.Scope = ShareDocument
+		if sc == "B" {
+			elem.Scope = ShareWithBlobs
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// ShareAccesses answers the log of the accesses made using the share
+// tokens of the given document, newest first.
+func (_Documents) ShareAccesses(dtype DocTypeID, id DocumentID, offset, limit int64) ([]*ShareAccess, error) {
+	if dtype <= 0 || id <= 0 {
+		return nil, newError(CodeValidation, "document type and document ID must be positive integers")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT token_id, blob_sha1, accessor, ctime
+	FROM wf_share_accesses
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	ORDER BY id DESC
+	LIMIT ? OFFSET ?
+	`
+	ary := make([]*ShareAccess, 0, 10)
+	err := scanEach(readDB().Query, q, []interface{}{dtype, id, limit, offset}, func(scan func(...interface{}) error) error {
+		var elem ShareAccess
+		var b sql.NullString
+		if err := scan(&elem.Token, &b, &elem.From, &elem.Ctime); err != nil {
+			return err
+		}
+		elem.Blob = b.String
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
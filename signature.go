@@ -0,0 +1,124 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+)
+
+// maxSignatureLen is the maximum length of a detached signature, in
+// bytes.  It accommodates RSA keys of up to 8192 bits.
+const maxSignatureLen = 1024
+
+// canonicalEvent is the serialised form of an event, over which its
+// signature is made.  The order of its fields is fixed.
+type canonicalEvent struct {
+	DocType DocTypeID   `json:"DocType"`
+	DocID   DocumentID  `json:"DocID"`
+	State   DocStateID  `json:"DocState"`
+	Action  DocActionID `json:"DocAction"`
+	Group   GroupID     `json:"Group"`
+	Text    string      `json:"Text"`
+}
+
+// Canonical answers the canonical serialisation of the event that this
+// input creates.  Callers sign these bytes, and pass the detached
+// signature as `Signature`.
+//
+// The serialisation is the compact JSON object having the fields
+// `DocType`, `DocID`, `DocState`, `DocAction`, `Group` and `Text`, in
+// that order.  The document is the one given, even if the event is
+// recorded against its root document.
+func (input *DocEventsNewInput) Canonical() []byte {
+	bs, _ := json.Marshal(&canonicalEvent{
+		DocType: input.DocTypeID,
+		DocID:   input.DocumentID,
+		State:   input.DocStateID,
+		Action:  input.DocActionID,
+		Group:   input.GroupID,
+		Text:    input.Text,
+	})
+	return bs
+}
+
+// recordSignature stores the signature of the given new event, made
+// over the canonical serialisation of the given input.
+func recordSignature(tx *sql.Tx, eid DocEventID, input *DocEventsNewInput) error {
+	q := `
+	INSERT INTO wf_docevent_signatures(docevent_id, doctype_id, doc_id, signature, ctime)
+	VALUES(?, ?, ?, ?, NOW())
+	`
+	_, err := tx.Exec(q, eid, input.DocTypeID, input.DocumentID, input.Signature)
+	return err
+}
+
+// VerifySignature checks the signature of the given event using the
+// given public key.  It answers `nil` if the signature is valid,
+// `ErrDocEventUnsigned` if the event carries no signature, and
+// `ErrDocEventBadSignature` if the signature does not verify.
+//
+// Ed25519 (`ed25519.PublicKey`), ECDSA (`*ecdsa.PublicKey`; ASN.1
+// signatures of SHA-256 digests) and RSA (`*rsa.PublicKey`; PKCS #1
+// v1.5 signatures of SHA-256 digests) keys are supported.
+func (_DocEvents) VerifySignature(eid DocEventID, pubkey crypto.PublicKey) error {
+	if eid <= 0 {
+		return newError(CodeValidation, "event ID should be a positive integer")
+	}
+
+	q := `
+	SELECT des.doctype_id, des.doc_id, de.docstate_id, de.docaction_id, de.group_id, de.data, des.signature
+	FROM wf_docevent_signatures des
+	JOIN wf_docevents de ON de.id = des.docevent_id
+	WHERE des.docevent_id = ?
+	`
+	var ce canonicalEvent
+	var text sql.NullString
+	var sig []byte
+	err := readDB().QueryRow(q, eid).Scan(&ce.DocType, &ce.DocID, &ce.State, &ce.Action, &ce.Group, &text, &sig)
+	if err != nil {
+		return notFound(err, ErrDocEventUnsigned)
+	}
+	ce.Text = text.String
+	msg, err := json.Marshal(&ce)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(msg)
+	ok := false
+	switch key := pubkey.(type) {
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, msg, sig)
+
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest[:], sig)
+
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+
+	default:
+		return newError(CodeValidation, "unsupported type of public key")
+	}
+	if !ok {
+		return ErrDocEventBadSignature
+	}
+	return nil
+}
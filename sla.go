@@ -0,0 +1,242 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SetSLA sets the service level of the given node: the time within
+// which documents arriving at the node should move on.  A zero
+// duration removes it, and stops the clocks of the documents waiting
+// at the node.
+//
+// Documents staying at the node beyond its service level are recorded
+// as breaches by the timer pump; see `Analytics.SLABreaches`.  A new
+// service level applies to documents arriving thereafter.
+func (_Nodes) SetSLA(otx *sql.Tx, id NodeID, d time.Duration) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	if d != 0 && d < time.Minute {
+		v.fail("SLA", "should be at least a minute")
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		if d == 0 {
+			_, err := tx.Exec("DELETE FROM wf_node_slas WHERE node_id = ?", id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("UPDATE wf_sla_timers SET active = 0 WHERE node_id = ? AND active = 1", id)
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_node_slas(node_id, sla_secs)
+		VALUES(?, ?)
+		ON DUPLICATE KEY UPDATE sla_secs = VALUES(sla_secs)
+		`
+		_, err := tx.Exec(q, id, int64(d/time.Second))
+		return err
+	})
+}
+
+// SLA answers the service level of the given node, if any; `0`
+// otherwise.
+func (_Nodes) SLA(id NodeID) (time.Duration, error) {
+	if id <= 0 {
+		return 0, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	var secs int64
+	err := readDB().QueryRow("SELECT sla_secs FROM wf_node_slas WHERE node_id = ?", id).Scan(&secs)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, nil
+
+	case err != nil:
+		return 0, err
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+func init() {
+	registerTimer("sla", recordSLABreaches)
+}
+
+// scheduleSLA starts the service-level clock of the given node, if it
+// has a service level, for the given document, which has arrived at
+// the node through the given event.
+func scheduleSLA(otx *sql.Tx, n *Node, did DocumentID, eid DocEventID) error {
+	q := `
+	INSERT INTO wf_sla_timers(node_id, doctype_id, doc_id, docstate_id, docevent_id, sla_secs, due_at, active, ctime)
+	SELECT node_id, ?, ?, ?, ?, sla_secs, DATE_ADD(NOW(), INTERVAL sla_secs SECOND), 1, NOW()
+	FROM wf_node_slas
+	WHERE node_id = ?
+	`
+	_, err := stmts.exec(otx, q, n.DocType, did, n.State, eid, n.ID)
+	return err
+}
+
+// stopSLA stops the service-level clocks of the given document, which
+// is transitioning, and records when it left the breached state, if
+// any.
+func stopSLA(otx *sql.Tx, dtype DocTypeID, did DocumentID) error {
+	q := `
+	UPDATE wf_sla_timers
+	SET active = 0
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND active = 1
+	`
+	if _, err := stmts.exec(otx, q, dtype, did); err != nil {
+		return err
+	}
+
+	q = `
+	UPDATE wf_sla_breaches
+	SET left_at = NOW()
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND left_at IS NULL
+	`
+	_, err := stmts.exec(otx, q, dtype, did)
+	return err
+}
+
+// recordSLABreaches is the timer task of service levels.  It records
+// the breaches of those documents that have stayed at their nodes
+// beyond the nodes' service levels.
+func recordSLABreaches(ctx context.Context) (int, error) {
+	q := `
+	SELECT id
+	FROM wf_sla_timers
+	WHERE active = 1
+	AND due_at <= NOW()
+	ORDER BY due_at
+	LIMIT 100
+	`
+	ids := []int64{}
+	err := scanEach(db.Query, q, nil, func(scan func(...interface{}) error) error {
+		var id int64
+		if err := scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		var ok bool
+		err = withTx(nil, func(tx *sql.Tx) error {
+			var err error
+			ok, err = recordSLABreach(tx, id)
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// recordSLABreach claims the given timer, and records the breach of
+// its document -- one row for each group to which the document's
+// arrival was notified, or a single row without a group if it was not
+// -- unless the document has moved on meanwhile.  It answers `true`
+// if the breach was recorded.
+func recordSLABreach(tx *sql.Tx, id int64) (bool, error) {
+	q := `
+	SELECT node_id, doctype_id, doc_id, docstate_id, docevent_id, sla_secs, ctime
+	FROM wf_sla_timers
+	WHERE id = ?
+	AND active = 1
+	FOR UPDATE
+	`
+	var nid NodeID
+	var dtype DocTypeID
+	var did DocumentID
+	var state DocStateID
+	var eid DocEventID
+	var secs int64
+	var entered time.Time
+	err := tx.QueryRow(q, id).Scan(&nid, &dtype, &did, &state, &eid, &secs, &entered)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+
+	case err != nil:
+		return false, err
+	}
+	if _, err = tx.Exec("UPDATE wf_sla_timers SET active = 0 WHERE id = ?", id); err != nil {
+		return false, err
+	}
+
+	// Suppress the breach should the document have transitioned
+	// without stopping the clock, e.g. through a direct state change.
+	doc, err := Documents.Get(tx, dtype, did, WithoutData())
+	if err != nil && CodeOf(err) != CodeNotFound {
+		return false, err
+	}
+	if err != nil || doc.State.ID != state {
+		return false, nil
+	}
+
+	q = `
+	INSERT INTO wf_sla_breaches(timer_id, node_id, doctype_id, doc_id, docstate_id, ac_id, group_id, sla_secs,
+		entered_at, breached_at)
+	SELECT ?, ?, ?, ?, ?, ?, mbs.group_id, ?, ?, NOW()
+	FROM wf_messages msgs
+	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
+	WHERE msgs.doctype_id = ?
+	AND msgs.doc_id = ?
+	AND msgs.docevent_id = ?
+	`
+	res, err := tx.Exec(q, id, nid, dtype, did, state, doc.AccCtx.ID, secs, entered, dtype, did, eid)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+
+	q = `
+	INSERT INTO wf_sla_breaches(timer_id, node_id, doctype_id, doc_id, docstate_id, ac_id, group_id, sla_secs,
+		entered_at, breached_at)
+	VALUES(?, ?, ?, ?, ?, ?, NULL, ?, ?, NOW())
+	`
+	_, err = tx.Exec(q, id, nid, dtype, did, state, doc.AccCtx.ID, secs, entered)
+	return err == nil, err
+}
@@ -0,0 +1,148 @@
+-- Brings a database created by the original `setup_db.sh` up to date
+-- with the schema that introduced message threads, read receipts,
+-- templates, localised labels, e-mail and webhook deliveries, and the
+-- transactional outbox.
+--
+-- Apply using `flowctl migrate`.
+
+ALTER TABLE wf_messages
+    ADD COLUMN thread_id INT NOT NULL DEFAULT 0 AFTER docevent_id,
+    ADD INDEX (thread_id);
+
+--
+
+-- The first message about a document starts its conversation.
+UPDATE wf_messages msgs
+JOIN (
+    SELECT doctype_id, doc_id, MIN(id) AS first_id
+    FROM wf_messages
+    GROUP BY doctype_id, doc_id
+) firsts ON firsts.doctype_id = msgs.doctype_id AND firsts.doc_id = msgs.doc_id
+SET msgs.thread_id = firsts.first_id;
+
+--
+
+ALTER TABLE wf_messages
+    ALTER COLUMN thread_id DROP DEFAULT;
+
+--
+
+ALTER TABLE wf_mailboxes
+    ADD INDEX (group_id, ctime);
+
+--
+
+ALTER TABLE wf_workflow_nodes
+    ADD COLUMN template_name VARCHAR(100) AFTER type;
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_i18n (
+    id INT NOT NULL AUTO_INCREMENT,
+    entity ENUM('docstate', 'docaction') NOT NULL,
+    entity_id INT NOT NULL,
+    locale VARCHAR(20) NOT NULL,
+    label VARCHAR(250) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (entity, entity_id, locale)
+);
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_message_templates (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    locale VARCHAR(20) NOT NULL,
+    title VARCHAR(250) NOT NULL,
+    body TEXT NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (name, locale)
+);
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_mailbox_reads (
+    id INT NOT NULL AUTO_INCREMENT,
+    group_id INT NOT NULL,
+    message_id INT NOT NULL,
+    user_id INT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+    UNIQUE (group_id, message_id, user_id)
+);
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_email_deliveries (
+    id INT NOT NULL AUTO_INCREMENT,
+    mailbox_id INT NOT NULL,
+    message_id INT NOT NULL,
+    user_id INT NOT NULL,
+    email VARCHAR(100) NOT NULL,
+    status ENUM('P', 'S', 'F') NOT NULL,
+    attempts INT NOT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error VARCHAR(500),
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (mailbox_id) REFERENCES wf_mailboxes(id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+    UNIQUE (mailbox_id, user_id),
+    INDEX (status, next_attempt),
+    INDEX (message_id)
+);
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_webhooks (
+    id INT NOT NULL AUTO_INCREMENT,
+    url VARCHAR(500) NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    doctype_id INT,
+    ac_id INT,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id)
+);
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_webhook_deliveries (
+    id INT NOT NULL AUTO_INCREMENT,
+    webhook_id INT NOT NULL,
+    docevent_id INT NOT NULL,
+    payload TEXT NOT NULL,
+    status ENUM('P', 'S', 'D') NOT NULL,
+    attempts INT NOT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error VARCHAR(500),
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (webhook_id) REFERENCES wf_webhooks(id),
+    FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+    UNIQUE (webhook_id, docevent_id),
+    INDEX (status, next_attempt)
+);
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_outbox (
+    id INT NOT NULL AUTO_INCREMENT,
+    kind VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status ENUM('P', 'D', 'F') NOT NULL,
+    attempts INT NOT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error VARCHAR(500),
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    INDEX (status, next_attempt),
+    INDEX (kind, status)
+);
@@ -0,0 +1,14 @@
+-- Adds the keys of documents recorded under duplicate policies.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_document_keys (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    dkey CHAR(40) NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    UNIQUE (doctype_id, dkey)
+);
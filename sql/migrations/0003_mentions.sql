@@ -0,0 +1,19 @@
+-- Adds the record of users mentioned in the text of document events.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_mentions (
+    id INT NOT NULL AUTO_INCREMENT,
+    message_id INT NOT NULL,
+    group_id INT NOT NULL,
+    user_id INT NOT NULL,
+    author_id INT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (author_id) REFERENCES wf_groups_master(id),
+    UNIQUE (message_id, group_id),
+    INDEX (user_id)
+);
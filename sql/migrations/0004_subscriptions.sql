@@ -0,0 +1,25 @@
+-- Adds the saved searches that groups subscribe to.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_subscriptions (
+    id INT NOT NULL AUTO_INCREMENT,
+    group_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    doctype_id INT NOT NULL,
+    ac_id INT,
+    docstate_id INT,
+    creator_id INT,
+    title_contains VARCHAR(250) NOT NULL,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (creator_id) REFERENCES wf_groups_master(id),
+    UNIQUE (group_id, name),
+    INDEX (doctype_id, active)
+);
@@ -0,0 +1,24 @@
+-- Adds the notification preferences of users, and digest e-mail
+-- deliveries.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_notification_prefs (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    channels TINYINT NOT NULL,
+    digest TINYINT(1) NOT NULL,
+    muted TINYINT(1) NOT NULL,
+    muted_until TIMESTAMP NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES wf_users_master(id),
+    UNIQUE (user_id, doctype_id)
+);
+
+--
+
+ALTER TABLE wf_email_deliveries
+    ADD COLUMN digest TINYINT(1) NOT NULL DEFAULT 0 AFTER status;
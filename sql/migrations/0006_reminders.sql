@@ -0,0 +1,36 @@
+-- Adds the reminder policies of workflow nodes, and the reminders
+-- scheduled as per them.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+CREATE TABLE IF NOT EXISTS wf_node_reminders (
+    node_id INT NOT NULL,
+    after_secs INT NOT NULL,
+    max_count INT NOT NULL,
+    unread_only TINYINT(1) NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id)
+);
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_reminders (
+    id INT NOT NULL AUTO_INCREMENT,
+    message_id INT NOT NULL,
+    node_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    docstate_id INT NOT NULL,
+    sent INT NOT NULL,
+    next_at TIMESTAMP NOT NULL,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    INDEX (active, next_at),
+    INDEX (doctype_id, doc_id, active)
+);
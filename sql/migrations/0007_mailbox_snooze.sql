@@ -0,0 +1,7 @@
+-- Adds the snoozing of messages in mailboxes.
+--
+-- Apply using `flowctl migrate`.
+
+ALTER TABLE wf_mailboxes
+    ADD COLUMN snoozed_until TIMESTAMP NULL AFTER unread,
+    ADD INDEX (snoozed_until);
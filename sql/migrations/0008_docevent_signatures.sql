@@ -0,0 +1,15 @@
+-- Adds the detached signatures of document events.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_docevent_signatures (
+    docevent_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    signature VARBINARY(1024) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (docevent_id),
+    FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id)
+);
@@ -0,0 +1,21 @@
+-- Adds administration roles, and their assignment to groups.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+CREATE TABLE IF NOT EXISTS wf_admin_role_areas (
+    role_id INT NOT NULL,
+    area TINYINT NOT NULL,
+    PRIMARY KEY (role_id, area),
+    FOREIGN KEY (role_id) REFERENCES wf_roles_master(id)
+);
+
+--
+
+CREATE TABLE IF NOT EXISTS wf_admin_group_roles (
+    group_id INT NOT NULL,
+    role_id INT NOT NULL,
+    PRIMARY KEY (group_id, role_id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (role_id) REFERENCES wf_roles_master(id)
+);
@@ -0,0 +1,14 @@
+-- Adds the recorded runs of access reviews.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_access_reviews (
+    id INT NOT NULL AUTO_INCREMENT,
+    ac_id INT NOT NULL,
+    snapshot MEDIUMTEXT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id),
+    INDEX (ac_id, id)
+);
@@ -0,0 +1,14 @@
+-- Adds retention policies of document types.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_retention_policies (
+    doctype_id INT NOT NULL,
+    events_secs BIGINT NOT NULL,
+    messages_secs BIGINT NOT NULL,
+    documents_secs BIGINT NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (doctype_id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id)
+);
@@ -0,0 +1,16 @@
+-- Adds the checkpoints of imports from legacy systems.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_import_checkpoints (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    record_key VARCHAR(250) NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    UNIQUE (name, record_key)
+);
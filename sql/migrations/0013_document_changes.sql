@@ -0,0 +1,16 @@
+-- Adds the change log of documents.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_document_changes (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    kind TINYINT NOT NULL,
+    delta TEXT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    INDEX (doctype_id, doc_id, id)
+);
@@ -0,0 +1,43 @@
+-- Adds the node type `external`, together with the policies and the
+-- tasks of external nodes.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+ALTER TABLE wf_workflow_nodes
+    MODIFY type ENUM('begin', 'end', 'linear', 'branch', 'joinany', 'joinall', 'external') NOT NULL;
+
+CREATE TABLE IF NOT EXISTS wf_node_external_tasks (
+    node_id INT NOT NULL,
+    kind VARCHAR(100) NOT NULL,
+    group_id INT NOT NULL,
+    timeout_secs INT NOT NULL,
+    max_attempts INT NOT NULL,
+    timeout_action_id INT,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (timeout_action_id) REFERENCES wf_docactions_master(id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_external_tasks (
+    id INT NOT NULL AUTO_INCREMENT,
+    kind VARCHAR(100) NOT NULL,
+    node_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    docstate_id INT NOT NULL,
+    status ENUM('P', 'D', 'F', 'C') NOT NULL,
+    attempts INT NOT NULL,
+    deadline TIMESTAMP NOT NULL,
+    result_action_id INT,
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (result_action_id) REFERENCES wf_docactions_master(id),
+    INDEX (status, deadline),
+    INDEX (doctype_id, doc_id, status)
+);
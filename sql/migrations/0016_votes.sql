@@ -0,0 +1,50 @@
+-- Adds the node type `vote`, together with the vote policies of nodes
+-- and the votes cast on documents.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+ALTER TABLE wf_workflow_nodes
+    MODIFY type ENUM('begin', 'end', 'linear', 'branch', 'joinany', 'joinall', 'external', 'vote') NOT NULL;
+
+CREATE TABLE IF NOT EXISTS wf_node_vote_policies (
+    node_id INT NOT NULL,
+    rule TINYINT NOT NULL,
+    threshold DOUBLE NOT NULL,
+    approve_action_id INT NOT NULL,
+    reject_action_id INT NOT NULL,
+    group_id INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (approve_action_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (reject_action_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_node_voters (
+    node_id INT NOT NULL,
+    group_id INT NOT NULL,
+    weight INT NOT NULL,
+    veto TINYINT(1) NOT NULL,
+    PRIMARY KEY (node_id, group_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_votes (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    node_id INT NOT NULL,
+    group_id INT NOT NULL,
+    user_id INT NOT NULL,
+    approve TINYINT(1) NOT NULL,
+    data TEXT NOT NULL,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    INDEX (doctype_id, doc_id, active)
+);
@@ -0,0 +1,24 @@
+-- Adds the node type `fork`, together with the branches of forked
+-- documents.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+ALTER TABLE wf_workflow_nodes
+    MODIFY type ENUM('begin', 'end', 'linear', 'branch', 'joinany', 'joinall', 'external', 'vote', 'fork') NOT NULL;
+
+CREATE TABLE IF NOT EXISTS wf_doc_branches (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    fork_node_id INT NOT NULL,
+    docstate_id INT NOT NULL,
+    status ENUM('A', 'J', 'C') NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (fork_node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    INDEX (doctype_id, doc_id, status)
+);
@@ -0,0 +1,21 @@
+-- Adds the return actions of workflow nodes, and the targets of
+-- events performing them.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+CREATE TABLE IF NOT EXISTS wf_node_returns (
+    node_id INT NOT NULL,
+    docaction_id INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_docevent_targets (
+    docevent_id INT NOT NULL,
+    to_state_id INT NOT NULL,
+    PRIMARY KEY (docevent_id),
+    FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+    FOREIGN KEY (to_state_id) REFERENCES wf_docstates_master(id)
+);
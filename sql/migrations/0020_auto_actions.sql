@@ -0,0 +1,34 @@
+-- Adds the automatic actions of workflow nodes, and their schedule.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+CREATE TABLE IF NOT EXISTS wf_node_auto_actions (
+    node_id INT NOT NULL,
+    after_secs INT NOT NULL,
+    docaction_id INT NOT NULL,
+    group_id INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_auto_actions (
+    id INT NOT NULL AUTO_INCREMENT,
+    node_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    docstate_id INT NOT NULL,
+    due_at TIMESTAMP NOT NULL,
+    status ENUM('P', 'D', 'F', 'C') NOT NULL,
+    docevent_id INT,
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    INDEX (status, due_at),
+    INDEX (doctype_id, doc_id, status)
+);
@@ -0,0 +1,31 @@
+-- Adds share tokens granting read access to documents, and the log of
+-- their use.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+CREATE TABLE IF NOT EXISTS wf_share_tokens (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    scope ENUM('D', 'B') NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    INDEX (doctype_id, doc_id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_share_accesses (
+    id INT NOT NULL AUTO_INCREMENT,
+    token_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    blob_sha1 CHAR(40),
+    accessor VARCHAR(200) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    INDEX (doctype_id, doc_id)
+);
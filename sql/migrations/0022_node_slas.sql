@@ -0,0 +1,11 @@
+-- Adds the service levels of workflow nodes.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_node_slas (
+    node_id INT NOT NULL,
+    sla_secs INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id)
+);
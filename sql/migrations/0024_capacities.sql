@@ -0,0 +1,38 @@
+-- Adds the capacity limits of workflow nodes and workflows, and the
+-- queue of events held back by them.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+CREATE TABLE IF NOT EXISTS wf_node_capacities (
+    node_id INT NOT NULL,
+    max_docs INT NOT NULL,
+    mode ENUM('R', 'Q', 'N') NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_workflow_capacities (
+    workflow_id INT NOT NULL,
+    max_docs INT NOT NULL,
+    mode ENUM('R', 'N') NOT NULL,
+    PRIMARY KEY (workflow_id),
+    FOREIGN KEY (workflow_id) REFERENCES wf_workflows(id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_capacity_queue (
+    id INT NOT NULL AUTO_INCREMENT,
+    node_id INT NOT NULL,
+    docevent_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    recipients TEXT NOT NULL,
+    status ENUM('Q', 'D', 'X') NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    INDEX (status, docevent_id),
+    INDEX (node_id, status)
+);
@@ -0,0 +1,16 @@
+-- Adds the quotas on the creation of documents.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+CREATE TABLE IF NOT EXISTS wf_quotas (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    group_id INT NOT NULL,
+    max_docs INT NOT NULL,
+    period ENUM('D', 'W', 'M') NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    UNIQUE (doctype_id, group_id)
+);
@@ -0,0 +1,6 @@
+-- Adds the deactivation of document actions.
+--
+-- Apply using `flowctl migrate`.
+
+ALTER TABLE wf_docactions_master
+    ADD COLUMN active TINYINT(1) NOT NULL DEFAULT 1 AFTER reconfirm;
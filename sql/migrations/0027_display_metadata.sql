@@ -0,0 +1,13 @@
+-- Adds the presentation metadata of document states and actions.
+--
+-- Apply using `flowctl migrate`.
+
+ALTER TABLE wf_docstates_master
+    ADD COLUMN color VARCHAR(30) NOT NULL DEFAULT '' AFTER name,
+    ADD COLUMN icon VARCHAR(50) NOT NULL DEFAULT '' AFTER color,
+    ADD COLUMN description VARCHAR(500) NOT NULL DEFAULT '' AFTER icon;
+
+ALTER TABLE wf_docactions_master
+    ADD COLUMN color VARCHAR(30) NOT NULL DEFAULT '' AFTER active,
+    ADD COLUMN icon VARCHAR(50) NOT NULL DEFAULT '' AFTER color,
+    ADD COLUMN description VARCHAR(500) NOT NULL DEFAULT '' AFTER icon;
@@ -0,0 +1,32 @@
+-- Adds the settings of the engine and of applications, and the log of
+-- their changes.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new tables.
+
+-- `ac_id` and `doctype_id` are `0` for values not specific to an
+-- access context or a document type, respectively.
+CREATE TABLE IF NOT EXISTS wf_settings (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    ac_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    value TEXT NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (name, ac_id, doctype_id)
+);
+
+CREATE TABLE IF NOT EXISTS wf_setting_changes (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    ac_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    user_id INT,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES wf_users_master(id),
+    INDEX (name, id)
+);
@@ -0,0 +1,102 @@
+-- Widens the identifiers of documents, events and messages, and the
+-- columns referring to them, to `BIGINT`, so that they can hold
+-- application-generated identifiers; see `SetIDGenerator`.
+--
+-- Apply using `flowctl migrate`.  Then, widen the identifiers of the
+-- existing document types using `flowctl ids -widen`.
+
+SET FOREIGN_KEY_CHECKS = 0;
+
+ALTER TABLE wf_docevents
+    MODIFY id BIGINT NOT NULL AUTO_INCREMENT,
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_messages
+    MODIFY id BIGINT NOT NULL AUTO_INCREMENT,
+    MODIFY doc_id BIGINT NOT NULL,
+    MODIFY docevent_id BIGINT NOT NULL,
+    MODIFY thread_id BIGINT NOT NULL;
+
+ALTER TABLE wf_adhoc_steps
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_auto_actions
+    MODIFY doc_id BIGINT NOT NULL,
+    MODIFY docevent_id BIGINT;
+
+ALTER TABLE wf_capacity_queue
+    MODIFY docevent_id BIGINT NOT NULL,
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_doc_branches
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_docevent_application
+    MODIFY doc_id BIGINT NOT NULL,
+    MODIFY docevent_id BIGINT NOT NULL;
+
+ALTER TABLE wf_docevent_signatures
+    MODIFY docevent_id BIGINT NOT NULL,
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_docevent_targets
+    MODIFY docevent_id BIGINT NOT NULL;
+
+ALTER TABLE wf_document_blobs
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_document_changes
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_document_children
+    MODIFY parent_id BIGINT NOT NULL,
+    MODIFY child_id BIGINT NOT NULL;
+
+ALTER TABLE wf_document_keys
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_document_tags
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_email_deliveries
+    MODIFY message_id BIGINT NOT NULL;
+
+ALTER TABLE wf_external_tasks
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_import_checkpoints
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_mailbox_reads
+    MODIFY message_id BIGINT NOT NULL;
+
+ALTER TABLE wf_mailboxes
+    MODIFY message_id BIGINT NOT NULL;
+
+ALTER TABLE wf_mentions
+    MODIFY message_id BIGINT NOT NULL;
+
+ALTER TABLE wf_reminders
+    MODIFY message_id BIGINT NOT NULL,
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_share_accesses
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_share_tokens
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_sla_breaches
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_sla_timers
+    MODIFY doc_id BIGINT NOT NULL,
+    MODIFY docevent_id BIGINT NOT NULL;
+
+ALTER TABLE wf_votes
+    MODIFY doc_id BIGINT NOT NULL;
+
+ALTER TABLE wf_webhook_deliveries
+    MODIFY docevent_id BIGINT NOT NULL;
+
+SET FOREIGN_KEY_CHECKS = 1;
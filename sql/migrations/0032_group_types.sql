@@ -0,0 +1,17 @@
+-- Adds the dynamic and the distribution groups.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+ALTER TABLE wf_groups_master
+    MODIFY group_type ENUM('G', 'S', 'R', 'D');
+
+CREATE TABLE IF NOT EXISTS wf_dynamic_groups (
+    group_id INT NOT NULL,
+    role_id INT NOT NULL,
+    ac_id INT NOT NULL,
+    PRIMARY KEY (group_id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (role_id) REFERENCES wf_roles_master(id),
+    INDEX (role_id)
+);
@@ -0,0 +1,27 @@
+-- Adds the grants of all the actions on a document type to a role,
+-- and expands them in the permissions views.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_role_all_docactions (
+    role_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    PRIMARY KEY (role_id, doctype_id),
+    FOREIGN KEY (role_id) REFERENCES wf_roles_master(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id)
+);
+
+CREATE OR REPLACE VIEW wf_role_perms_v AS
+SELECT rdas.role_id, rdas.doctype_id, rdas.docaction_id
+FROM wf_role_docactions rdas
+UNION
+SELECT DISTINCT rads.role_id, rads.doctype_id, dst.docaction_id
+FROM wf_role_all_docactions rads
+JOIN wf_docstate_transitions dst ON dst.doctype_id = rads.doctype_id;
+
+CREATE OR REPLACE VIEW wf_ac_perms_v AS
+SELECT ac_grs.ac_id, ac_grs.group_id, gu.user_id, ac_grs.role_id, rps.doctype_id, rps.docaction_id
+FROM wf_ac_group_roles ac_grs
+JOIN wf_group_users gu ON ac_grs.group_id = gu.group_id
+JOIN wf_role_perms_v rps ON ac_grs.role_id = rps.role_id;
@@ -0,0 +1,21 @@
+-- Adds the audit trail of document states set by administrators.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_forced_states (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    from_state_id INT NOT NULL,
+    to_state_id INT NOT NULL,
+    user_id INT,
+    reason TEXT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (from_state_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (to_state_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (user_id) REFERENCES wf_users_master(id),
+    INDEX (doctype_id, doc_id)
+);
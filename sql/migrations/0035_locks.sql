@@ -0,0 +1,12 @@
+-- Adds the leases of the distributed locks.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_locks (
+    name VARCHAR(100) NOT NULL,
+    owner CHAR(32) NOT NULL,
+    expires TIMESTAMP NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (name)
+);
@@ -0,0 +1,10 @@
+-- Adds the time of application, and the applying workflow and node,
+-- to the transitions of documents.  Transitions applied earlier have
+-- none of these.
+--
+-- Apply using `flowctl migrate`.
+
+ALTER TABLE wf_docevent_application
+    ADD COLUMN workflow_id INT AFTER to_state_id,
+    ADD COLUMN node_id INT AFTER workflow_id,
+    ADD COLUMN ctime TIMESTAMP NULL AFTER node_id;
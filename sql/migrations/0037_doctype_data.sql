@@ -0,0 +1,12 @@
+-- Adds the configuration of the storage of the data of documents.
+--
+-- Apply using `flowctl migrate`.  In a multi-tenant database, run
+-- `flowctl tenancy -enable` thereafter, to scope the new table.
+
+CREATE TABLE IF NOT EXISTS wf_doctype_data (
+    doctype_id INT NOT NULL,
+    storage ENUM('text', 'mediumtext', 'longtext', 'external') NOT NULL,
+    max_size BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (doctype_id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id)
+);
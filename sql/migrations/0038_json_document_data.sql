@@ -0,0 +1,8 @@
+-- Adds the storage of the data of documents as JSON, validated
+-- against a schema.
+--
+-- Apply using `flowctl migrate`.
+
+ALTER TABLE wf_doctype_data
+    MODIFY storage ENUM('text', 'mediumtext', 'longtext', 'external', 'json') NOT NULL,
+    ADD COLUMN json_schema TEXT AFTER max_size;
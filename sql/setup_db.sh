@@ -19,6 +19,7 @@ fi
 mysql -u $user $db < ./sql/wf_doctypes_master.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docstates_master.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docactions_master.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_i18n.sql >> err.log 2>&1
 
 # Create a local users master, if in test mode.
 if [ "$1" = "-t" ]; then
@@ -31,17 +32,67 @@ mysql -u $user $db < ./sql/wf_groups_master.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_roles_master.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_group_users.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_role_docactions.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_role_all_docactions.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_admin_role_areas.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_admin_group_roles.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_access_contexts.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_ac_group_roles.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_ac_group_hierarchy.sql >> err.log 2>&1
-mysql -u $user $db < ./sql/wf_ac_perms_v.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_access_reviews.sql >> err.log 2>&1
 
 # Workflow related.
 mysql -u $user $db < ./sql/wf_documents.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docstate_transitions.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_role_perms_v.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_ac_perms_v.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docevents.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_docevent_signatures.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docevent_application.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_workflows.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_message_templates.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_workflow_nodes.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_reminders.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_messages.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_mailboxes.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_mailbox_reads.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_mentions.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_subscriptions.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_reminders.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_notification_prefs.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_email_deliveries.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_webhooks.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_webhook_deliveries.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_outbox.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_retention_policies.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_import_checkpoints.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_document_changes.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_external_tasks.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_external_tasks.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_compensations.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_vote_policies.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_voters.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_votes.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_doc_branches.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_adhoc_steps.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_returns.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_docevent_targets.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_auto_actions.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_auto_actions.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_share_tokens.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_share_accesses.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_slas.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_sla_timers.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_sla_breaches.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_capacities.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_workflow_capacities.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_capacity_queue.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_quotas.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_action_policies.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_settings.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_setting_changes.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_node_webhooks.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_dynamic_groups.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_forced_states.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_locks.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_doctype_data.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_derived_fields.sql >> err.log 2>&1
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS wf_access_reviews;
+
+--
+
+CREATE TABLE wf_access_reviews (
+    id INT NOT NULL AUTO_INCREMENT,
+    ac_id INT NOT NULL,
+    snapshot MEDIUMTEXT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id),
+    INDEX (ac_id, id)
+);
@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS wf_action_policies;
+
+--
+
+-- `ac_id` and `role_id` are `0` for policies not specific to an
+-- access context or a role, respectively.
+CREATE TABLE wf_action_policies (
+    id INT NOT NULL AUTO_INCREMENT,
+    docaction_id INT NOT NULL,
+    ac_id INT NOT NULL,
+    role_id INT NOT NULL,
+    reconfirm TINYINT(1) NOT NULL,
+    reason_required TINYINT(1) NOT NULL,
+    min_reason_len INT NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+    UNIQUE (docaction_id, ac_id, role_id)
+);
@@ -0,0 +1,24 @@
+DROP TABLE IF EXISTS wf_adhoc_steps;
+
+--
+
+CREATE TABLE wf_adhoc_steps (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    docstate_id INT NOT NULL,
+    docaction_id INT NOT NULL,
+    group_id INT NOT NULL,
+    status ENUM('P', 'A', 'R') NOT NULL,
+    user_id INT,
+    data TEXT,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    INDEX (doctype_id, doc_id, active)
+);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS wf_admin_group_roles;
+
+--
+
+CREATE TABLE wf_admin_group_roles (
+    group_id INT NOT NULL,
+    role_id INT NOT NULL,
+    PRIMARY KEY (group_id, role_id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (role_id) REFERENCES wf_roles_master(id)
+);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS wf_admin_role_areas;
+
+--
+
+CREATE TABLE wf_admin_role_areas (
+    role_id INT NOT NULL,
+    area TINYINT NOT NULL,
+    PRIMARY KEY (role_id, area),
+    FOREIGN KEY (role_id) REFERENCES wf_roles_master(id)
+);
@@ -0,0 +1,20 @@
+DROP TABLE IF EXISTS wf_capacity_queue;
+
+--
+
+CREATE TABLE wf_capacity_queue (
+    id INT NOT NULL AUTO_INCREMENT,
+    node_id INT NOT NULL,
+    docevent_id BIGINT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    recipients TEXT NOT NULL,
+    status ENUM('Q', 'D', 'X') NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    INDEX (status, docevent_id),
+    INDEX (node_id, status)
+);
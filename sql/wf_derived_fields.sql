@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS wf_derived_fields;
+
+--
+
+CREATE TABLE wf_derived_fields (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    kind ENUM('number', 'text', 'time') NOT NULL,
+    expr VARCHAR(250),
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    UNIQUE (doctype_id, name)
+);
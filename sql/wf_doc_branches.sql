@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS wf_doc_branches;
+
+--
+
+CREATE TABLE wf_doc_branches (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    fork_node_id INT NOT NULL,
+    docstate_id INT NOT NULL,
+    status ENUM('A', 'J', 'C') NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (fork_node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    INDEX (doctype_id, doc_id, status)
+);
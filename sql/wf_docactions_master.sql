@@ -6,6 +6,10 @@ CREATE TABLE wf_docactions_master (
     id INT NOT NULL AUTO_INCREMENT,
     name VARCHAR(100) NOT NULL,
     reconfirm TINYINT(1) NOT NULL,
+    active TINYINT(1) NOT NULL DEFAULT 1,
+    color VARCHAR(30) NOT NULL DEFAULT '',
+    icon VARCHAR(50) NOT NULL DEFAULT '',
+    description VARCHAR(500) NOT NULL DEFAULT '',
     PRIMARY KEY (id),
     UNIQUE (name)
 );
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS wf_docevent_signatures;
+
+--
+
+CREATE TABLE wf_docevent_signatures (
+    docevent_id BIGINT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    signature VARBINARY(1024) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (docevent_id),
+    FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id)
+);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS wf_docevent_targets;
+
+--
+
+CREATE TABLE wf_docevent_targets (
+    docevent_id BIGINT NOT NULL,
+    to_state_id INT NOT NULL,
+    PRIMARY KEY (docevent_id),
+    FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+    FOREIGN KEY (to_state_id) REFERENCES wf_docstates_master(id)
+);
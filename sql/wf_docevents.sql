@@ -3,9 +3,9 @@ DROP TABLE IF EXISTS wf_docevents;
 --
 
 CREATE TABLE wf_docevents (
-    id INT NOT NULL AUTO_INCREMENT,
+    id BIGINT NOT NULL AUTO_INCREMENT,
     doctype_id INT NOT NULL,
-    doc_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
     docstate_id INT NOT NULL,
     docaction_id INT NOT NULL,
     group_id INT NOT NULL,
@@ -5,6 +5,9 @@ DROP TABLE IF EXISTS wf_docstates_master;
 CREATE TABLE wf_docstates_master (
     id INT NOT NULL AUTO_INCREMENT,
     name VARCHAR(100) NOT NULL,
+    color VARCHAR(30) NOT NULL DEFAULT '',
+    icon VARCHAR(50) NOT NULL DEFAULT '',
+    description VARCHAR(500) NOT NULL DEFAULT '',
     PRIMARY KEY (id),
     UNIQUE (name)
 );
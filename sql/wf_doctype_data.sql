@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS wf_doctype_data;
+
+--
+
+CREATE TABLE wf_doctype_data (
+    doctype_id INT NOT NULL,
+    storage ENUM('text', 'mediumtext', 'longtext', 'external', 'json') NOT NULL,
+    max_size BIGINT NOT NULL DEFAULT 0,
+    json_schema TEXT,
+    PRIMARY KEY (doctype_id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id)
+);
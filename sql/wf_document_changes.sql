@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS wf_document_changes;
+
+--
+
+CREATE TABLE wf_document_changes (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    kind TINYINT NOT NULL,
+    delta TEXT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    INDEX (doctype_id, doc_id, id)
+);
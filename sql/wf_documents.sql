@@ -1,5 +1,5 @@
 -- CREATE TABLE wf_documents_<DOCTYPE_ID> (
---     id INT NOT NULL AUTO_INCREMENT,
+--     id BIGINT NOT NULL AUTO_INCREMENT,
 --     path VARCHAR(1000) NOT NULL,
 --     ac_id INT NOT NULL,
 --     docstate_id INT NOT NULL,
@@ -20,9 +20,9 @@ DROP TABLE IF EXISTS wf_document_children;
 CREATE TABLE wf_document_children (
     id INT NOT NULL AUTO_INCREMENT,
     parent_doctype_id INT NOT NULL,
-    parent_id INT NOT NULL,
+    parent_id BIGINT NOT NULL,
     child_doctype_id INT NOT NULL,
-    child_id INT NOT NULL,
+    child_id BIGINT NOT NULL,
     PRIMARY KEY (id),
     FOREIGN KEY (parent_doctype_id) REFERENCES wf_doctypes_master(id),
     FOREIGN KEY (child_doctype_id) REFERENCES wf_doctypes_master(id),
@@ -36,7 +36,7 @@ DROP TABLE IF EXISTS wf_document_blobs;
 CREATE TABLE wf_document_blobs (
     id INT NOT NULL AUTO_INCREMENT,
     doctype_id INT NOT NULL,
-    doc_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
     sha1sum CHAR(40) NOT NULL,
     name TEXT NOT NULL,
     path TEXT NOT NULL,
@@ -52,9 +52,23 @@ DROP TABLE IF EXISTS wf_document_tags;
 CREATE TABLE wf_document_tags (
     id INT NOT NULL AUTO_INCREMENT,
     doctype_id INT NOT NULL,
-    doc_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
     tag VARCHAR(50) NOT NULL,
     PRIMARY KEY (id),
     FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
     UNIQUE (doctype_id, doc_id, tag)
 );
+
+--
+
+DROP TABLE IF EXISTS wf_document_keys;
+
+CREATE TABLE wf_document_keys (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    dkey CHAR(40) NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    UNIQUE (doctype_id, dkey)
+);
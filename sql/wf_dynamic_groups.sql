@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS wf_dynamic_groups;
+
+--
+
+CREATE TABLE wf_dynamic_groups (
+    group_id INT NOT NULL,
+    role_id INT NOT NULL,
+    ac_id INT NOT NULL,
+    PRIMARY KEY (group_id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (role_id) REFERENCES wf_roles_master(id),
+    INDEX (role_id)
+);
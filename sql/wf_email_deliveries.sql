@@ -0,0 +1,24 @@
+DROP TABLE IF EXISTS wf_email_deliveries;
+
+--
+
+CREATE TABLE wf_email_deliveries (
+    id INT NOT NULL AUTO_INCREMENT,
+    mailbox_id INT NOT NULL,
+    message_id BIGINT NOT NULL,
+    user_id INT NOT NULL,
+    email VARCHAR(100) NOT NULL,
+    status ENUM('P', 'S', 'F') NOT NULL,
+    digest TINYINT(1) NOT NULL,
+    attempts INT NOT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error VARCHAR(500),
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (mailbox_id) REFERENCES wf_mailboxes(id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+    UNIQUE (mailbox_id, user_id),
+    INDEX (status, next_attempt),
+    INDEX (message_id)
+);
@@ -0,0 +1,25 @@
+DROP TABLE IF EXISTS wf_external_tasks;
+
+--
+
+CREATE TABLE wf_external_tasks (
+    id INT NOT NULL AUTO_INCREMENT,
+    kind VARCHAR(100) NOT NULL,
+    node_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    docstate_id INT NOT NULL,
+    status ENUM('P', 'D', 'F', 'C') NOT NULL,
+    attempts INT NOT NULL,
+    deadline TIMESTAMP NOT NULL,
+    result_action_id INT,
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (result_action_id) REFERENCES wf_docactions_master(id),
+    INDEX (status, deadline),
+    INDEX (doctype_id, doc_id, status)
+);
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS wf_i18n;
+
+--
+
+-- Localised display labels of master data.  `entity_id` refers to
+-- the master table implied by `entity`.
+CREATE TABLE wf_i18n (
+    id INT NOT NULL AUTO_INCREMENT,
+    entity ENUM('docstate', 'docaction') NOT NULL,
+    entity_id INT NOT NULL,
+    locale VARCHAR(20) NOT NULL,
+    label VARCHAR(250) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (entity, entity_id, locale)
+);
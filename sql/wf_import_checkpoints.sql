@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS wf_import_checkpoints;
+
+--
+
+CREATE TABLE wf_import_checkpoints (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    record_key VARCHAR(250) NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    UNIQUE (name, record_key)
+);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS wf_locks;
+
+--
+
+CREATE TABLE wf_locks (
+    name VARCHAR(100) NOT NULL,
+    owner CHAR(32) NOT NULL,
+    expires TIMESTAMP NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (name)
+);
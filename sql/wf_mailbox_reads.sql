@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS wf_mailbox_reads;
+
+--
+
+CREATE TABLE wf_mailbox_reads (
+    id INT NOT NULL AUTO_INCREMENT,
+    group_id INT NOT NULL,
+    message_id BIGINT NOT NULL,
+    user_id INT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+    UNIQUE (group_id, message_id, user_id)
+);
@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS wf_mentions;
+
+--
+
+CREATE TABLE wf_mentions (
+    id INT NOT NULL AUTO_INCREMENT,
+    message_id BIGINT NOT NULL,
+    group_id INT NOT NULL,
+    user_id INT NOT NULL,
+    author_id INT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (author_id) REFERENCES wf_groups_master(id),
+    UNIQUE (message_id, group_id),
+    INDEX (user_id)
+);
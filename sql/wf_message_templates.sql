@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS wf_message_templates;
+
+--
+
+-- An empty `locale` denotes the default variant of a template.
+CREATE TABLE wf_message_templates (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    locale VARCHAR(20) NOT NULL,
+    title VARCHAR(250) NOT NULL,
+    body TEXT NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (name, locale)
+);
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS wf_node_auto_actions;
+
+--
+
+CREATE TABLE wf_node_auto_actions (
+    node_id INT NOT NULL,
+    after_secs INT NOT NULL,
+    docaction_id INT NOT NULL,
+    group_id INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
+);
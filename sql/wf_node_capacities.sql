@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS wf_node_capacities;
+
+--
+
+CREATE TABLE wf_node_capacities (
+    node_id INT NOT NULL,
+    max_docs INT NOT NULL,
+    mode ENUM('R', 'Q', 'N') NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id)
+);
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS wf_node_compensations;
+
+--
+
+CREATE TABLE wf_node_compensations (
+    node_id INT NOT NULL,
+    docaction_id INT NOT NULL,
+    group_id INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
+);
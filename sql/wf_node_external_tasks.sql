@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS wf_node_external_tasks;
+
+--
+
+CREATE TABLE wf_node_external_tasks (
+    node_id INT NOT NULL,
+    kind VARCHAR(100) NOT NULL,
+    group_id INT NOT NULL,
+    timeout_secs INT NOT NULL,
+    max_attempts INT NOT NULL,
+    timeout_action_id INT,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (timeout_action_id) REFERENCES wf_docactions_master(id)
+);
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS wf_node_reminders;
+
+--
+
+CREATE TABLE wf_node_reminders (
+    node_id INT NOT NULL,
+    after_secs INT NOT NULL,
+    max_count INT NOT NULL,
+    unread_only TINYINT(1) NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id)
+);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS wf_node_returns;
+
+--
+
+CREATE TABLE wf_node_returns (
+    node_id INT NOT NULL,
+    docaction_id INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id)
+);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS wf_node_slas;
+
+--
+
+CREATE TABLE wf_node_slas (
+    node_id INT NOT NULL,
+    sla_secs INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id)
+);
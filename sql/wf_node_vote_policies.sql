@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS wf_node_vote_policies;
+
+--
+
+CREATE TABLE wf_node_vote_policies (
+    node_id INT NOT NULL,
+    rule TINYINT NOT NULL,
+    threshold DOUBLE NOT NULL,
+    approve_action_id INT NOT NULL,
+    reject_action_id INT NOT NULL,
+    group_id INT NOT NULL,
+    PRIMARY KEY (node_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (approve_action_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (reject_action_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
+);
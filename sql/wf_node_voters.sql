@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS wf_node_voters;
+
+--
+
+CREATE TABLE wf_node_voters (
+    node_id INT NOT NULL,
+    group_id INT NOT NULL,
+    weight INT NOT NULL,
+    veto TINYINT(1) NOT NULL,
+    PRIMARY KEY (node_id, group_id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
+);
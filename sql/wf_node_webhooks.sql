@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS wf_node_webhooks;
+
+--
+
+CREATE TABLE wf_node_webhooks (
+    id INT NOT NULL AUTO_INCREMENT,
+    node_id INT NOT NULL,
+    url VARCHAR(500) NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    on_entry TINYINT(1) NOT NULL,
+    on_exit TINYINT(1) NOT NULL,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    INDEX (node_id)
+);
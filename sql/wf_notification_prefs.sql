@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS wf_notification_prefs;
+
+--
+
+CREATE TABLE wf_notification_prefs (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    channels TINYINT NOT NULL,
+    digest TINYINT(1) NOT NULL,
+    muted TINYINT(1) NOT NULL,
+    muted_until TIMESTAMP NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES wf_users_master(id),
+    UNIQUE (user_id, doctype_id)
+);
@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS wf_outbox;
+
+--
+
+CREATE TABLE wf_outbox (
+    id INT NOT NULL AUTO_INCREMENT,
+    kind VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status ENUM('P', 'D', 'F') NOT NULL,
+    attempts INT NOT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error VARCHAR(500),
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    INDEX (status, next_attempt),
+    INDEX (kind, status)
+);
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS wf_quotas;
+
+--
+
+CREATE TABLE wf_quotas (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    group_id INT NOT NULL,
+    max_docs INT NOT NULL,
+    period ENUM('D', 'W', 'M') NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    UNIQUE (doctype_id, group_id)
+);
@@ -0,0 +1,23 @@
+DROP TABLE IF EXISTS wf_reminders;
+
+--
+
+CREATE TABLE wf_reminders (
+    id INT NOT NULL AUTO_INCREMENT,
+    message_id BIGINT NOT NULL,
+    node_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    docstate_id INT NOT NULL,
+    sent INT NOT NULL,
+    next_at TIMESTAMP NOT NULL,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    INDEX (active, next_at),
+    INDEX (doctype_id, doc_id, active)
+);
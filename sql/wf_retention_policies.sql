@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS wf_retention_policies;
+
+--
+
+CREATE TABLE wf_retention_policies (
+    doctype_id INT NOT NULL,
+    events_secs BIGINT NOT NULL,
+    messages_secs BIGINT NOT NULL,
+    documents_secs BIGINT NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (doctype_id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id)
+);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS wf_role_all_docactions;
+
+--
+
+CREATE TABLE wf_role_all_docactions (
+    role_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    PRIMARY KEY (role_id, doctype_id),
+    FOREIGN KEY (role_id) REFERENCES wf_roles_master(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id)
+);
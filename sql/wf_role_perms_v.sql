@@ -0,0 +1,7 @@
+CREATE OR REPLACE VIEW wf_role_perms_v AS
+SELECT rdas.role_id, rdas.doctype_id, rdas.docaction_id
+FROM wf_role_docactions rdas
+UNION
+SELECT DISTINCT rads.role_id, rads.doctype_id, dst.docaction_id
+FROM wf_role_all_docactions rads
+JOIN wf_docstate_transitions dst ON dst.doctype_id = rads.doctype_id;
@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS wf_setting_changes;
+
+--
+
+CREATE TABLE wf_setting_changes (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    ac_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    user_id INT,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES wf_users_master(id),
+    INDEX (name, id)
+);
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS wf_settings;
+
+--
+
+-- `ac_id` and `doctype_id` are `0` for values not specific to an
+-- access context or a document type, respectively.
+CREATE TABLE wf_settings (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    ac_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    value TEXT NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (name, ac_id, doctype_id)
+);
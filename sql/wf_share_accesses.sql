@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS wf_share_accesses;
+
+--
+
+CREATE TABLE wf_share_accesses (
+    id INT NOT NULL AUTO_INCREMENT,
+    token_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    blob_sha1 CHAR(40),
+    accessor VARCHAR(200) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    INDEX (doctype_id, doc_id)
+);
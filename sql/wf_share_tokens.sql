@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS wf_share_tokens;
+
+--
+
+CREATE TABLE wf_share_tokens (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    scope ENUM('D', 'B') NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    INDEX (doctype_id, doc_id)
+);
@@ -0,0 +1,26 @@
+DROP TABLE IF EXISTS wf_sla_breaches;
+
+--
+
+CREATE TABLE wf_sla_breaches (
+    id INT NOT NULL AUTO_INCREMENT,
+    timer_id INT NOT NULL,
+    node_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    docstate_id INT NOT NULL,
+    ac_id INT NOT NULL,
+    group_id INT,
+    sla_secs INT NOT NULL,
+    entered_at TIMESTAMP NOT NULL,
+    breached_at TIMESTAMP NOT NULL,
+    left_at TIMESTAMP NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    INDEX (ac_id, breached_at),
+    INDEX (doctype_id, doc_id, left_at)
+);
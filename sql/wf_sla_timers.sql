@@ -0,0 +1,22 @@
+DROP TABLE IF EXISTS wf_sla_timers;
+
+--
+
+CREATE TABLE wf_sla_timers (
+    id INT NOT NULL AUTO_INCREMENT,
+    node_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    docstate_id INT NOT NULL,
+    docevent_id BIGINT NOT NULL,
+    sla_secs INT NOT NULL,
+    due_at TIMESTAMP NOT NULL,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    INDEX (active, due_at),
+    INDEX (doctype_id, doc_id, active)
+);
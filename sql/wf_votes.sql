@@ -0,0 +1,21 @@
+DROP TABLE IF EXISTS wf_votes;
+
+--
+
+CREATE TABLE wf_votes (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id BIGINT NOT NULL,
+    node_id INT NOT NULL,
+    group_id INT NOT NULL,
+    user_id INT NOT NULL,
+    approve TINYINT(1) NOT NULL,
+    data TEXT NOT NULL,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    INDEX (doctype_id, doc_id, active)
+);
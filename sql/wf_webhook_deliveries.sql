@@ -0,0 +1,21 @@
+DROP TABLE IF EXISTS wf_webhook_deliveries;
+
+--
+
+CREATE TABLE wf_webhook_deliveries (
+    id INT NOT NULL AUTO_INCREMENT,
+    webhook_id INT NOT NULL,
+    docevent_id BIGINT NOT NULL,
+    payload TEXT NOT NULL,
+    status ENUM('P', 'S', 'D') NOT NULL,
+    attempts INT NOT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error VARCHAR(500),
+    ctime TIMESTAMP NOT NULL,
+    mtime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (webhook_id) REFERENCES wf_webhooks(id),
+    FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+    UNIQUE (webhook_id, docevent_id),
+    INDEX (status, next_attempt)
+);
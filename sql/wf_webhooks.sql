@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS wf_webhooks;
+
+--
+
+CREATE TABLE wf_webhooks (
+    id INT NOT NULL AUTO_INCREMENT,
+    url VARCHAR(500) NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    doctype_id INT,
+    ac_id INT,
+    active TINYINT(1) NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id)
+);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS wf_workflow_capacities;
+
+--
+
+CREATE TABLE wf_workflow_capacities (
+    workflow_id INT NOT NULL,
+    max_docs INT NOT NULL,
+    mode ENUM('R', 'N') NOT NULL,
+    PRIMARY KEY (workflow_id),
+    FOREIGN KEY (workflow_id) REFERENCES wf_workflows(id)
+);
@@ -9,7 +9,8 @@ CREATE TABLE wf_workflow_nodes (
     ac_id INT,
     workflow_id INT NOT NULL,
     name VARCHAR(100) NOT NULL,
-    type ENUM('begin', 'end', 'linear', 'branch', 'joinany', 'joinall') NOT NULL,
+    type ENUM('begin', 'end', 'linear', 'branch', 'joinany', 'joinall', 'external', 'vote', 'fork') NOT NULL,
+    template_name VARCHAR(100),
     PRIMARY KEY (id),
     FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
     FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
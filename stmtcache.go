@@ -0,0 +1,218 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+)
+
+// stmtCache holds prepared statements of frequently-run queries on a
+// database handle, keyed by their text.  Queries on document storage
+// tables include the table name in their text; they are, therefore,
+// cached per document type.
+type stmtCache struct {
+	mu    sync.RWMutex
+	db    *sql.DB
+	max   int
+	stmts map[string]*cachedStmt
+}
+
+// cachedStmt is a retained statement, together with the number of its
+// users.  A statement evicted from the cache is closed when its last
+// user is done with it.
+type cachedStmt struct {
+	st      *sql.Stmt
+	users   int32
+	evicted int32
+}
+
+// release records that a user is done with this statement, closing it
+// if it has been evicted.
+func (cs *cachedStmt) release() {
+	if atomic.AddInt32(&cs.users, -1) == 0 && atomic.LoadInt32(&cs.evicted) == 1 {
+		cs.st.Close()
+	}
+}
+
+// evict closes this statement, or has its last user close it.
+func (cs *cachedStmt) evict() {
+	atomic.StoreInt32(&cs.evicted, 1)
+	if atomic.LoadInt32(&cs.users) == 0 {
+		cs.st.Close()
+	}
+}
+
+// Statements on the primary database, and on the read replica.
+var stmts = &stmtCache{max: 256, stmts: map[string]*cachedStmt{}}
+var rstmts = &stmtCache{max: 256, stmts: map[string]*cachedStmt{}}
+
+// SetStatementCacheSize sets the maximum number of prepared statements
+// that `flow` retains.  Queries beyond that number are executed
+// without being prepared in advance.  A size of `0` disables the cache,
+// closing the statements retained so far, once they are not in use.
+// The default is 256.
+func SetStatementCacheSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	for _, c := range []*stmtCache{stmts, rstmts} {
+		c.mu.Lock()
+		c.max = n
+		if n == 0 {
+			c.closeAll()
+		}
+		c.mu.Unlock()
+	}
+}
+
+// reset closes all the retained statements, and switches to the given
+// database handle.
+func (c *stmtCache) reset(sdb *sql.DB) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeAll()
+	c.db = sdb
+}
+
+// closeAll evicts all the retained statements; those in use are closed
+// when their users are done.  The caller should hold the write lock.
+func (c *stmtCache) closeAll() {
+	for q, cs := range c.stmts {
+		delete(c.stmts, q)
+		cs.evict()
+	}
+}
+
+// handle answers the database handle of this cache.
+func (c *stmtCache) handle() *sql.DB {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.db
+}
+
+// get answers the prepared statement for the given query, preparing
+// and retaining it if necessary.  It answers `nil` if the cache is
+// full or disabled.  A statement answered should be released once the
+// query has been run.
+func (c *stmtCache) get(q string) (*cachedStmt, error) {
+	c.mu.RLock()
+	cs, ok := c.stmts[q]
+	if ok {
+		atomic.AddInt32(&cs.users, 1)
+	}
+	size := c.max
+	c.mu.RUnlock()
+	if ok {
+		return cs, nil
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cs, ok = c.stmts[q]; ok {
+		atomic.AddInt32(&cs.users, 1)
+		return cs, nil
+	}
+	if len(c.stmts) >= c.max {
+		return nil, nil
+	}
+	st, err := c.db.Prepare(q)
+	if err != nil {
+		return nil, err
+	}
+	cs = &cachedStmt{st: st, users: 1}
+	c.stmts[q] = cs
+	return cs, nil
+}
+
+// queryRow runs the given single-row query using a cached statement,
+// within the given transaction, if any.
+func (c *stmtCache) queryRow(otx *sql.Tx, q string, args ...interface{}) (*sql.Row, error) {
+	cs, err := c.get(q)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cs == nil && otx == nil:
+		return c.handle().QueryRow(q, args...), nil
+
+	case cs == nil:
+		return otx.QueryRow(q, args...), nil
+
+	case otx == nil:
+		defer cs.release()
+		return cs.st.QueryRow(args...), nil
+
+	default:
+		// Statements bound to a transaction are closed when it ends.
+		defer cs.release()
+		return otx.Stmt(cs.st).QueryRow(args...), nil
+	}
+}
+
+// query runs the given query using a cached statement, within the
+// given transaction, if any.
+func (c *stmtCache) query(otx *sql.Tx, q string, args ...interface{}) (*sql.Rows, error) {
+	cs, err := c.get(q)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cs == nil && otx == nil:
+		return c.handle().Query(q, args...)
+
+	case cs == nil:
+		return otx.Query(q, args...)
+
+	case otx == nil:
+		defer cs.release()
+		return cs.st.Query(args...)
+
+	default:
+		defer cs.release()
+		return otx.Stmt(cs.st).Query(args...)
+	}
+}
+
+// exec runs the given statement using a cached statement, within the
+// given transaction, if any.
+func (c *stmtCache) exec(otx *sql.Tx, q string, args ...interface{}) (sql.Result, error) {
+	cs, err := c.get(q)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cs == nil && otx == nil:
+		return c.handle().Exec(q, args...)
+
+	case cs == nil:
+		return otx.Exec(q, args...)
+
+	case otx == nil:
+		defer cs.release()
+		return cs.st.Exec(args...)
+
+	default:
+		defer cs.release()
+		return otx.Stmt(cs.st).Exec(args...)
+	}
+}
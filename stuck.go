@@ -0,0 +1,226 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// StuckReason enumerates the reasons for which a document cannot
+// progress in its workflow.
+type StuckReason string
+
+// Reasons for which documents get stuck.
+const (
+	// StuckNoNode : the current state of the document has no node
+	StuckNoNode StuckReason = "no_node"
+	// StuckNoTransitions : the node of the current state, not being an end node, has no way out
+	StuckNoTransitions StuckReason = "no_transitions"
+	// StuckWorkflowInactive : the workflow of the document type is inactive, or missing
+	StuckWorkflowInactive StuckReason = "workflow_inactive"
+	// StuckAccCtxInactive : the access context of the document is inactive
+	StuckAccCtxInactive StuckReason = "ac_inactive"
+)
+
+// StuckDocument is a document that cannot progress in its workflow,
+// together with the reasons for that.
+type StuckDocument struct {
+	DocType DocTypeID       `json:"DocType"` // Type of the document
+	ID      DocumentID      `json:"ID"`      // The document
+	State   DocStateID      `json:"State"`   // Current state of the document
+	AccCtx  AccessContextID `json:"AccCtx"`  // Current access context of the document
+	Reasons []StuckReason   `json:"Reasons"` // Why the document is stuck
+}
+
+// FindStuckDocuments answers the root documents of the given type that
+// cannot progress in their workflow: those whose current state has no
+// node, or a node other than an end node with neither transitions nor
+// a return action out of it, and those whose workflow or access
+// context is inactive.
+//
+// Such documents usually result from editing a workflow while
+// documents are in flight.  See `ForceSetState` to move them.
+func (_Admins) FindStuckDocuments(dtype DocTypeID) ([]*StuckDocument, error) {
+	if dtype <= 0 {
+		return nil, newError(CodeValidation, "document type ID should be a positive integer")
+	}
+
+	q := `
+	SELECT docs.id, docs.docstate_id, docs.ac_id,
+		wn.id IS NULL AS no_node,
+		wn.id IS NOT NULL AND wn.type <> 'end'
+			AND NOT EXISTS (
+				SELECT 1
+				FROM wf_docstate_transitions dst
+				WHERE dst.doctype_id = ?
+				AND dst.from_state_id = docs.docstate_id
+			)
+			AND NOT EXISTS (SELECT 1 FROM wf_node_returns nr WHERE nr.node_id = wn.id) AS no_trans,
+		COALESCE(wf.active, 0) = 0 AS wf_inactive,
+		ac.active = 0 AS ac_inactive
+	FROM ` + DocTypes.docStorName(dtype) + ` docs
+	JOIN wf_access_contexts ac ON ac.id = docs.ac_id
+	LEFT JOIN wf_workflow_nodes wn ON wn.doctype_id = ? AND wn.docstate_id = docs.docstate_id
+	LEFT JOIN wf_workflows wf ON wf.doctype_id = ?
+	WHERE docs.path = ''
+	HAVING no_node OR no_trans OR wf_inactive OR ac_inactive
+	ORDER BY docs.id
+	`
+	ary := []*StuckDocument{}
+	err := scanEach(readDB().Query, q, []interface{}{dtype, dtype, dtype}, func(scan func(...interface{}) error) error {
+		elem := StuckDocument{DocType: dtype, Reasons: []StuckReason{}}
+		var noNode, noTrans, wfInactive, acInactive bool
+		err := scan(&elem.ID, &elem.State, &elem.AccCtx, &noNode, &noTrans, &wfInactive, &acInactive)
+		if err != nil {
+			return err
+		}
+		if noNode {
+			elem.Reasons = append(elem.Reasons, StuckNoNode)
+		}
+		if noTrans {
+			elem.Reasons = append(elem.Reasons, StuckNoTransitions)
+		}
+		if wfInactive {
+			elem.Reasons = append(elem.Reasons, StuckWorkflowInactive)
+		}
+		if acInactive {
+			elem.Reasons = append(elem.Reasons, StuckAccCtxInactive)
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// ForcedState records a document state set by an administrator, using
+// `ForceSetState`.
+type ForcedState struct {
+	DocType   DocTypeID  `json:"DocType"`   // Type of the document
+	DocID     DocumentID `json:"DocID"`     // The document
+	FromState DocStateID `json:"FromState"` // State of the document before
+	ToState   DocStateID `json:"ToState"`   // State set
+	User      UserID     `json:"User"`      // Acting user, if known
+	Reason    string     `json:"Reason"`    // Why the state was set
+	Ctime     time.Time  `json:"Ctime"`     // Time of intervention
+}
+
+// ForceSetState moves the given root document into the given state,
+// and optionally into the given access context, bypassing its
+// workflow.  A reason is mandatory; it is recorded in an audit entry,
+// together with the acting user of the transaction, if any (see
+// `AsAdmin`).
+//
+// No event is raised, and no messages are posted.  The pending
+// reminders, external tasks, votes, ad-hoc steps, automatic actions
+// and SLA timers of the document are cancelled, as they are when it
+// transitions.  This is meant for controlled manual intervention on
+// stuck documents; see `FindStuckDocuments`.
+func (_Admins) ForceSetState(otx *sql.Tx, dtype DocTypeID, did DocumentID, state DocStateID,
+	acid AccessContextID, reason string) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("document type ID", int64(dtype))
+	v.positive("document ID", int64(did))
+	v.positive("state ID", int64(state))
+	if acid < 0 {
+		v.fail("access context ID", "should be non-negative")
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		v.fail("reason", "is required")
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		doc, err := Documents.Get(tx, dtype, did)
+		if err != nil {
+			return err
+		}
+		if doc.Path != "" {
+			return ErrDocumentIsChild
+		}
+
+		var uid sql.NullInt64
+		actorsMu.RLock()
+		if id, ok := actors[tx]; ok {
+			uid = sql.NullInt64{Int64: int64(id), Valid: true}
+		}
+		actorsMu.RUnlock()
+
+		q := `
+		INSERT INTO wf_forced_states(doctype_id, doc_id, from_state_id, to_state_id, user_id, reason, ctime)
+		VALUES(?, ?, ?, ?, ?, ?, NOW())
+		`
+		if _, err = tx.Exec(q, dtype, did, doc.State.ID, state, uid, reason); err != nil {
+			return err
+		}
+		if err = Documents.setState(tx, dtype, did, state, acid); err != nil {
+			return err
+		}
+
+		cleanups := []func(*sql.Tx, DocTypeID, DocumentID) error{
+			cancelReminders, ExternalTasks.cancel, closeVotes, lapseAdHocSteps, cancelAutoActions, stopSLA,
+		}
+		for _, fn := range cleanups {
+			if err = fn(tx, dtype, did); err != nil {
+				return err
+			}
+		}
+
+		writeLog(LogWarn, "document state forced", F("doctype", dtype), F("doc", did),
+			F("from", doc.State.ID), F("to", state), F("user", uid.Int64), F("reason", reason))
+		return nil
+	})
+}
+
+// ForcedStates answers the states set by administrators on the given
+// document, oldest first.
+func (_Admins) ForcedStates(dtype DocTypeID, did DocumentID) ([]*ForcedState, error) {
+	q := `
+	SELECT doctype_id, doc_id, from_state_id, to_state_id, user_id, reason, ctime
+	FROM wf_forced_states
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	ORDER BY id
+	`
+	ary := []*ForcedState{}
+	err := scanEach(readDB().Query, q, []interface{}{dtype, did}, func(scan func(...interface{}) error) error {
+		var elem ForcedState
+		var uid sql.NullInt64
+		err := scan(&elem.DocType, &elem.DocID, &elem.FromState, &elem.ToState, &uid, &elem.Reason, &elem.Ctime)
+		if err != nil {
+			return err
+		}
+		elem.User = UserID(uid.Int64)
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
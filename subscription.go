@@ -0,0 +1,253 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// SubscriptionID is the type of unique identifiers of subscriptions.
+type SubscriptionID int64
+
+// Subscription is a saved search of documents, watched by a group.
+//
+// Whenever an event moves a document such that it newly matches the
+// search, the message about that event is posted to the mailbox of the
+// subscribing group.  A document matches when it is of the given type,
+// and all the other non-zero criteria hold.
+//
+// Only the documents in access contexts that include the subscribing
+// group, or the user of a subscribing singleton group, are considered.
+type Subscription struct {
+	ID            SubscriptionID  `json:"ID"`                      // Unique identifier of this subscription
+	Name          string          `json:"Name"`                    // Name of this subscription, unique within its group
+	Group         GroupID         `json:"Group"`                   // Subscribing group, whose mailbox receives notifications
+	DocType       DocTypeID       `json:"DocType"`                 // Type of the documents watched
+	AccCtx        AccessContextID `json:"AccCtx,omitempty"`        // Access context of the documents, if non-zero
+	State         DocStateID      `json:"DocState,omitempty"`      // State of the documents, if non-zero
+	Creator       GroupID         `json:"Creator,omitempty"`       // (Singleton) group that created the documents, if non-zero
+	TitleContains string          `json:"TitleContains,omitempty"` // Text in the titles of the documents, if non-empty
+	Active        bool            `json:"Active"`                  // Is this subscription enabled?
+	Ctime         time.Time       `json:"Ctime"`                   // Time of subscription
+}
+
+// Unexported type, only for convenience methods.
+type _Subscriptions struct{}
+
+// Subscriptions provides a resource-like interface to the saved
+// searches watched by groups.
+var Subscriptions _Subscriptions
+
+// New subscribes the given group to the documents matching the given
+// search.  Of the search, the document type is required.  The access
+// context, group, state and title criteria are optional.  The time,
+// root-only and visibility criteria are not applicable to
+// subscriptions, and should not be given.
+func (_Subscriptions) New(otx *sql.Tx, gid GroupID, name string, input *DocumentsListInput) (SubscriptionID, error) {
+	name = strings.TrimSpace(name)
+	var v validator
+	v.positive("gid", int64(gid))
+	v.name("name", name, maxNameLen)
+	v.positive("DocTypeID", int64(input.DocTypeID))
+	if input.AccessContextID < 0 {
+		v.fail("AccessContextID", "should be a non-negative integer")
+	}
+	if input.GroupID < 0 {
+		v.fail("GroupID", "should be a non-negative integer")
+	}
+	if input.DocStateID < 0 {
+		v.fail("DocStateID", "should be a non-negative integer")
+	}
+	v.maxLen("TitleContains", input.TitleContains, maxTitleLen)
+	if !input.CtimeStarting.IsZero() || !input.CtimeBefore.IsZero() {
+		v.fail("Ctime", "not applicable to subscriptions")
+	}
+	if input.RootOnly {
+		v.fail("RootOnly", "not applicable to subscriptions")
+	}
+	if input.Visibility != VisibilityAll {
+		v.fail("Visibility", "not applicable to subscriptions")
+	}
+	if err := v.result(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_subscriptions(group_id, name, doctype_id, ac_id, docstate_id, creator_id, title_contains, active, ctime)
+		VALUES(?, ?, ?, ?, ?, ?, ?, 1, NOW())
+		`
+		res, err := tx.Exec(q, gid, name, input.DocTypeID,
+			sql.NullInt64{Int64: int64(input.AccessContextID), Valid: input.AccessContextID > 0},
+			sql.NullInt64{Int64: int64(input.DocStateID), Valid: input.DocStateID > 0},
+			sql.NullInt64{Int64: int64(input.GroupID), Valid: input.GroupID > 0},
+			input.TitleContains)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return SubscriptionID(id), nil
+}
+
+// scanSubscription reads one subscription from the given row.
+func scanSubscription(scan func(...interface{}) error) (*Subscription, error) {
+	var elem Subscription
+	var acid, dsid, cid sql.NullInt64
+	err := scan(&elem.ID, &elem.Name, &elem.Group, &elem.DocType, &acid, &dsid, &cid, &elem.TitleContains, &elem.Active, &elem.Ctime)
+	if err != nil {
+		return nil, err
+	}
+	elem.AccCtx = AccessContextID(acid.Int64)
+	elem.State = DocStateID(dsid.Int64)
+	elem.Creator = GroupID(cid.Int64)
+	return &elem, nil
+}
+
+// ListByGroup answers the subscriptions of the given group.
+func (_Subscriptions) ListByGroup(gid GroupID) ([]*Subscription, error) {
+	if gid <= 0 {
+		return nil, newError(CodeValidation, "group ID should be a positive integer")
+	}
+
+	q := `
+	SELECT id, name, group_id, doctype_id, ac_id, docstate_id, creator_id, title_contains, active, ctime
+	FROM wf_subscriptions
+	WHERE group_id = ?
+	ORDER BY id
+	`
+	rows, err := readDB().Query(q, gid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*Subscription, 0, 4)
+	for rows.Next() {
+		elem, err := scanSubscription(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Get retrieves the subscription with the given ID.
+func (_Subscriptions) Get(id SubscriptionID) (*Subscription, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "ID should be a positive integer")
+	}
+
+	q := `
+	SELECT id, name, group_id, doctype_id, ac_id, docstate_id, creator_id, title_contains, active, ctime
+	FROM wf_subscriptions
+	WHERE id = ?
+	`
+	elem, err := scanSubscription(readDB().QueryRow(q, id).Scan)
+	if err != nil {
+		return nil, notFound(err, ErrSubscriptionNotFound)
+	}
+	return elem, nil
+}
+
+// SetActive enables or disables the given subscription.
+func (_Subscriptions) SetActive(otx *sql.Tx, id SubscriptionID, active bool) error {
+	return withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE wf_subscriptions SET active = ? WHERE id = ?", active, id)
+		return err
+	})
+}
+
+// Delete removes the given subscription.
+func (_Subscriptions) Delete(otx *sql.Tx, id SubscriptionID) error {
+	return withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_subscriptions WHERE id = ?", id)
+		return err
+	})
+}
+
+// matches answers the groups subscribed to searches that the given
+// document newly matches, upon moving from the given state and access
+// context to the given ones.  The document's type, creator and title
+// do not change with transitions; hence, only the state and access
+// context criteria need be evaluated for the earlier position.
+func (_Subscriptions) matches(otx *sql.Tx, doc *Document, fstate DocStateID, facid AccessContextID,
+	tstate DocStateID, tacid AccessContextID) ([]GroupID, error) {
+	q := `
+	SELECT DISTINCT subs.group_id
+	FROM wf_subscriptions subs
+	WHERE subs.doctype_id = ?
+	AND subs.active = 1
+	AND (subs.creator_id IS NULL OR subs.creator_id = ?)
+	AND (subs.title_contains = '' OR LOCATE(subs.title_contains, ?) > 0)
+	AND (subs.ac_id IS NULL OR subs.ac_id = ?)
+	AND (subs.docstate_id IS NULL OR subs.docstate_id = ?)
+	AND NOT (
+		(subs.ac_id IS NULL OR subs.ac_id = ?)
+		AND (subs.docstate_id IS NULL OR subs.docstate_id = ?)
+	)
+	AND (
+		EXISTS (
+			SELECT 1
+			FROM wf_ac_group_hierarchy agh
+			WHERE agh.ac_id = ?
+			AND agh.group_id = subs.group_id
+		)
+		OR EXISTS (
+			SELECT 1
+			FROM wf_ac_group_hierarchy agh
+			JOIN wf_group_users gu ON gu.group_id = agh.group_id
+			JOIN wf_group_users sgu ON sgu.user_id = gu.user_id
+			JOIN wf_groups_master sgm ON sgm.id = sgu.group_id
+			WHERE agh.ac_id = ?
+			AND sgm.group_type = ` + GroupSingleton.sql() + `
+			AND sgm.id = subs.group_id
+		)
+	)
+	`
+	rows, err := stmts.query(otx, q, doc.DocType.ID, doc.Group.ID, doc.Title,
+		tacid, tstate, facid, fstate, tacid, tacid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := []GroupID{}
+	for rows.Next() {
+		var gid int64
+		if err = rows.Scan(&gid); err != nil {
+			return nil, err
+		}
+		ary = append(ary, GroupID(gid))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
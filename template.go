@@ -0,0 +1,385 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"bytes"
+	"database/sql"
+	"math"
+	"strings"
+	"text/template"
+)
+
+// MessageTemplateID is the type of unique identifiers of message
+// templates.
+type MessageTemplateID int64
+
+// MessageTemplate is a named, possibly localised, template from which
+// notification messages are rendered.
+//
+// Both title and body are Go `text/template`s, executed with a
+// `TemplateData` value.  For example:
+//
+//     {{.Actor}} moved '{{.Title}}' to {{.State}}: {{.Comment}}
+//
+// A template can have several variants, one per locale.  The variant
+// with an empty locale is the default.
+type MessageTemplate struct {
+	ID     MessageTemplateID `json:"ID"`               // Unique identifier of this template variant
+	Name   string            `json:"Name"`             // Name shared by all variants of this template
+	Locale string            `json:"Locale,omitempty"` // E.g. `en`, `hi-IN`; empty for the default variant
+	Title  string            `json:"Title"`            // Template of the message subject
+	Body   string            `json:"Body"`             // Template of the message body
+}
+
+// TemplateData holds the values available to message templates.
+type TemplateData struct {
+	DocType   string     // Name of the document type
+	DocID     DocumentID // Document in the workflow
+	Title     string     // Title of the document
+	Actor     string     // Name of the (singleton) group that caused the event
+	Action    string     // Name of the action performed
+	PrevState string     // State of the document before the event
+	State     string     // State of the document after the event
+	Comment   string     // Text of the event
+	URL       string     // Deep link to the document, if a link function is set
+}
+
+// LinkFunc answers the URL at which the given document can be viewed
+// in the consuming application.
+type LinkFunc func(dtype DocTypeID, id DocumentID) string
+
+var tmplLinkFunc LinkFunc
+var tmplDefLocale string
+
+// Unexported type, only for convenience methods.
+type _Templates struct{}
+
+// Templates provides a resource-like interface to message templates.
+var Templates _Templates
+
+// SetLinkFunc registers the function used to fill in the `URL` of
+// template data.
+func (_Templates) SetLinkFunc(fn LinkFunc) {
+	tmplLinkFunc = fn
+}
+
+// SetDefaultLocale specifies the locale in which templates are
+// rendered when messages are posted.
+func (_Templates) SetDefaultLocale(locale string) {
+	tmplDefLocale = strings.TrimSpace(locale)
+}
+
+// parseTemplate verifies that the given title and body templates can
+// be parsed.
+func parseTemplate(name, title, body string) (*template.Template, *template.Template, error) {
+	tt, err := template.New(name + ":title").Parse(title)
+	if err != nil {
+		return nil, nil, err
+	}
+	bt, err := template.New(name + ":body").Parse(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tt, bt, nil
+}
+
+// New creates a variant of the named template for the given locale.
+func (_Templates) New(otx *sql.Tx, name, locale, title, body string) (MessageTemplateID, error) {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return 0, err
+	}
+
+	name = strings.TrimSpace(name)
+	locale = strings.TrimSpace(locale)
+	var v validator
+	v.name("name", name, maxNameLen)
+	v.maxLen("locale", locale, maxLocaleLen)
+	v.name("title", title, maxTitleLen)
+	if err := v.result(); err != nil {
+		return 0, err
+	}
+	if _, _, err := parseTemplate(name, title, body); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_message_templates(name, locale, title, body)
+		VALUES(?, ?, ?, ?)
+		`
+		res, err := tx.Exec(q, name, locale, title, body)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return MessageTemplateID(id), nil
+}
+
+// List answers a subset of the template variants, based on the input
+// specification.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+//
+// `WithLocale` answers only the best-matching variant of each
+// template, for the given locale.
+func (_Templates) List(offset, limit int64, opts ...ReadOption) ([]*MessageTemplate, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT id, name, locale, title, body
+	FROM wf_message_templates
+	ORDER BY id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := readDB().Query(q, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*MessageTemplate, 0, 10)
+	for rows.Next() {
+		var elem MessageTemplate
+		err = rows.Scan(&elem.ID, &elem.Name, &elem.Locale, &elem.Title, &elem.Body)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	o := applyReadOptions(opts)
+	if o.locale == "" {
+		return ary, nil
+	}
+	return bestTemplateVariants(ary, o.locale), nil
+}
+
+// bestTemplateVariants answers, for each template name, the variant
+// that best matches the given locale.  Names without a matching
+// variant are dropped.  Order of first appearance is retained.
+func bestTemplateVariants(ary []*MessageTemplate, locale string) []*MessageTemplate {
+	cands := localeCandidates(locale)
+	rank := func(l string) int {
+		for i, c := range cands {
+			if c == l {
+				return i
+			}
+		}
+		return -1
+	}
+
+	best := map[string]*MessageTemplate{}
+	names := []string{}
+	for _, mt := range ary {
+		r := rank(mt.Locale)
+		if r < 0 {
+			continue
+		}
+		cur, ok := best[mt.Name]
+		if !ok {
+			names = append(names, mt.Name)
+		}
+		if !ok || r < rank(cur.Locale) {
+			best[mt.Name] = mt
+		}
+	}
+
+	res := make([]*MessageTemplate, 0, len(names))
+	for _, name := range names {
+		res = append(res, best[name])
+	}
+	return res
+}
+
+// Get retrieves the variant of the named template for exactly the
+// given locale.
+func (_Templates) Get(name, locale string) (*MessageTemplate, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, newError(CodeValidation, "template name should be non-empty")
+	}
+
+	q := `
+	SELECT id, name, locale, title, body
+	FROM wf_message_templates
+	WHERE name = ?
+	AND locale = ?
+	`
+	var elem MessageTemplate
+	row := readDB().QueryRow(q, name, strings.TrimSpace(locale))
+	err := row.Scan(&elem.ID, &elem.Name, &elem.Locale, &elem.Title, &elem.Body)
+	if err != nil {
+		return nil, notFound(err, ErrTemplateNotFound)
+	}
+
+	return &elem, nil
+}
+
+// Resolve retrieves the variant of the named template that best
+// matches the given locale.  It tries the full locale (`hi-IN`), then
+// its language (`hi`), and then the default variant.
+func (_Templates) Resolve(name, locale string) (*MessageTemplate, error) {
+	var err error
+	for _, l := range localeCandidates(locale) {
+		var elem *MessageTemplate
+		elem, err = Templates.Get(name, l)
+		if err == nil {
+			return elem, nil
+		}
+		if err != ErrTemplateNotFound {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// Update replaces the title and body of the given template variant.
+func (_Templates) Update(otx *sql.Tx, id MessageTemplateID, title, body string) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("id", int64(id))
+	v.name("title", title, maxTitleLen)
+	if err := v.result(); err != nil {
+		return err
+	}
+	if _, _, err := parseTemplate("", title, body); err != nil {
+		return err
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE wf_message_templates SET title = ?, body = ? WHERE id = ?", title, body, id)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the given template variant.
+func (_Templates) Delete(otx *sql.Tx, id MessageTemplateID) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	if id <= 0 {
+		return newError(CodeValidation, "template ID should be a positive integer")
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_message_templates WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Render renders the variant of the named template that best matches
+// the given locale, using the given data.  It answers the rendered
+// title and body.
+func (_Templates) Render(name, locale string, data *TemplateData) (string, string, error) {
+	mt, err := Templates.Resolve(name, locale)
+	if err != nil {
+		return "", "", err
+	}
+	return mt.Render(data)
+}
+
+// Render executes this template variant with the given data, and
+// answers the rendered title and body.
+func (mt *MessageTemplate) Render(data *TemplateData) (string, string, error) {
+	tt, bt, err := parseTemplate(mt.Name, mt.Title, mt.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	var title, body bytes.Buffer
+	if err = tt.Execute(&title, data); err != nil {
+		return "", "", err
+	}
+	if err = bt.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+
+	return title.String(), body.String(), nil
+}
+
+// templateData gathers the values that templates can refer to, for
+// the given event that moves the given document into `tstate`.
+func templateData(otx *sql.Tx, doc *Document, event *DocEvent, tstate DocStateID) (*TemplateData, error) {
+	data := &TemplateData{
+		DocType:   doc.DocType.Name,
+		DocID:     doc.ID,
+		Title:     doc.Title,
+		PrevState: doc.State.Name,
+		Comment:   event.Text,
+	}
+
+	q := `
+	SELECT gm.name, dam.name, dsm.name
+	FROM wf_groups_master gm, wf_docactions_master dam, wf_docstates_master dsm
+	WHERE gm.id = ?
+	AND dam.id = ?
+	AND dsm.id = ?
+	`
+	row := otx.QueryRow(q, event.Group, event.Action, tstate)
+	err := row.Scan(&data.Actor, &data.Action, &data.State)
+	if err != nil {
+		return nil, err
+	}
+
+	if tmplLinkFunc != nil {
+		data.URL = tmplLinkFunc(doc.DocType.ID, doc.ID)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,348 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// TenantID is the type of unique identifiers of tenants.
+//
+// A tenant is one of several isolated customers of an application,
+// whose data share a single database.  Tenant `0` is the default
+// tenant, which owns all the data of a database in which multi-tenant
+// mode is not enabled.
+type TenantID int64
+
+// tenantVar is the MySQL session variable that holds the tenant of a
+// connection.
+const tenantVar = "@flow_tenant_id"
+
+// tenantTables lists the tables that are scoped by tenant, in
+// addition to the document storage tables.
+var tenantTables = []string{
+	"wf_access_contexts",
+	"wf_access_reviews",
+	"wf_ac_group_hierarchy",
+	"wf_ac_group_roles",
+	"wf_action_policies",
+	"wf_adhoc_steps",
+	"wf_admin_group_roles",
+	"wf_admin_role_areas",
+	"wf_auto_actions",
+	"wf_capacity_queue",
+	"wf_derived_fields",
+	"wf_doc_branches",
+	"wf_docactions_master",
+	"wf_docevent_application",
+	"wf_docevent_signatures",
+	"wf_docevent_targets",
+	"wf_docevents",
+	"wf_docstate_transitions",
+	"wf_docstates_master",
+	"wf_doctype_data",
+	"wf_doctypes_master",
+	"wf_document_blobs",
+	"wf_document_changes",
+	"wf_document_children",
+	"wf_document_keys",
+	"wf_document_tags",
+	"wf_dynamic_groups",
+	"wf_email_deliveries",
+	"wf_external_tasks",
+	"wf_forced_states",
+	"wf_group_users",
+	"wf_groups_master",
+	"wf_i18n",
+	"wf_import_checkpoints",
+	"wf_locks",
+	"wf_mailbox_reads",
+	"wf_mailboxes",
+	"wf_mentions",
+	"wf_message_templates",
+	"wf_messages",
+	"wf_node_auto_actions",
+	"wf_node_capacities",
+	"wf_node_compensations",
+	"wf_node_external_tasks",
+	"wf_node_reminders",
+	"wf_node_returns",
+	"wf_node_slas",
+	"wf_node_vote_policies",
+	"wf_node_voters",
+	"wf_node_webhooks",
+	"wf_notification_prefs",
+	"wf_outbox",
+	"wf_quotas",
+	"wf_reminders",
+	"wf_retention_policies",
+	"wf_role_all_docactions",
+	"wf_role_docactions",
+	"wf_roles_master",
+	"wf_setting_changes",
+	"wf_settings",
+	"wf_share_accesses",
+	"wf_share_tokens",
+	"wf_sla_breaches",
+	"wf_sla_timers",
+	"wf_subscriptions",
+	"wf_votes",
+	"wf_webhook_deliveries",
+	"wf_webhooks",
+	"wf_workflow_capacities",
+	"wf_workflow_nodes",
+	"wf_workflows",
+}
+
+// tenantUniques lists the unique keys on names, which are unique only
+// within a tenant in multi-tenant mode.  Other unique keys comprise
+// identifiers, which are unique across tenants.
+var tenantUniques = map[string]string{
+	"wf_access_contexts":   "name",
+	"wf_docactions_master": "name",
+	"wf_docstates_master":  "name",
+	"wf_doctypes_master":   "name",
+	"wf_groups_master":     "name",
+	"wf_message_templates": "name, locale",
+	"wf_roles_master":      "name",
+	"wf_settings":          "name, ac_id, doctype_id",
+	"wf_workflows":         "name",
+}
+
+// TenantDSN answers the given MySQL data source name, amended so that
+// connections opened using it act on behalf of the given tenant.
+//
+// In multi-tenant mode, `flow` reads and writes the data of only the
+// tenant of the registered database handle.  An application that
+// serves several tenants should, therefore, run a separate instance
+// per tenant.  A read replica, if any, should be opened with the same
+// tenant.
+func TenantDSN(dsn string, tid TenantID) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s%s=%d", dsn, sep, tenantVar, tid)
+}
+
+// CurrentTenant answers the tenant on whose behalf the registered
+// database handle acts.
+func CurrentTenant() (TenantID, error) {
+	var tid sql.NullInt64
+	row := db.QueryRow("SELECT " + tenantVar)
+	if err := row.Scan(&tid); err != nil {
+		return 0, err
+	}
+	return TenantID(tid.Int64), nil
+}
+
+// TenancyEnabled answers `true` if multi-tenant mode is enabled in the
+// registered database.
+func TenancyEnabled() (bool, error) {
+	return tenancyEnabled(nil)
+}
+
+// tenancyEnabled answers `true` if the tenant-scoped view of document
+// types exists.
+func tenancyEnabled(otx *sql.Tx) (bool, error) {
+	q := `
+	SELECT COUNT(*)
+	FROM information_schema.views
+	WHERE table_schema = DATABASE()
+	AND table_name = 'wf_doctypes_master'
+	`
+	var row *sql.Row
+	if otx == nil {
+		row = db.QueryRow(q)
+	} else {
+		row = otx.QueryRow(q)
+	}
+	var n int64
+	if err := row.Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// EnableTenancy converts the registered database to multi-tenant
+// mode.  Each `flow` table is renamed with a suffix of `_all`, and
+// gains a `tenant_id` column.  A view by its original name answers
+// only the rows of the tenant of the current connection, and a
+// trigger assigns that tenant to new rows.  Existing data belong to
+// the default tenant.  Names of document types, states, actions,
+// etc., become unique per tenant.
+//
+// Since every query of `flow` goes through these views, all of them
+// are scoped to the tenant automatically.  The users master is
+// provided by the application; it is not scoped by `flow`.
+//
+// This needs privileges to create stored functions, views and
+// triggers.  It is idempotent: should it fail midway, it can be run
+// again once the cause is addressed.
+//
+// N.B. MySQL commits data definition statements implicitly.  Stop all
+// instances of the application before running this.
+func EnableTenancy() error {
+	qs := []string{
+		`DROP FUNCTION IF EXISTS flow_tenant_id`,
+		`CREATE FUNCTION flow_tenant_id() RETURNS INT DETERMINISTIC NO SQL RETURN IFNULL(` + tenantVar + `, 0)`,
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	tbls := append([]string{}, tenantTables...)
+	rows, err := db.Query(`
+	SELECT table_name
+	FROM information_schema.tables
+	WHERE table_schema = DATABASE()
+	AND table_type = 'BASE TABLE'
+	AND table_name LIKE 'wf\_documents\_%'
+	AND table_name NOT LIKE '%\_all'
+	`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var tbl string
+		if err = rows.Scan(&tbl); err != nil {
+			rows.Close()
+			return err
+		}
+		tbls = append(tbls, tbl)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, tbl := range tbls {
+		if err = scopeTable(tbl); err != nil {
+			return &CodedError{Code: CodeOf(err), Msg: tbl, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// scopeTable converts the given table to multi-tenant mode, skipping
+// the steps already done.
+func scopeTable(tbl string) error {
+	var typ string
+	q := `
+	SELECT table_type
+	FROM information_schema.tables
+	WHERE table_schema = DATABASE()
+	AND table_name = ?
+	`
+	err := db.QueryRow(q, tbl).Scan(&typ)
+	switch {
+	case err == sql.ErrNoRows:
+		// Renamed already.
+
+	case err != nil:
+		return err
+
+	case typ == "BASE TABLE":
+		var n int64
+		q = `
+		SELECT COUNT(*)
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE()
+		AND table_name = ?
+		AND column_name = 'tenant_id'
+		`
+		if err = db.QueryRow(q, tbl).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			q = `ALTER TABLE ` + tbl + ` ADD COLUMN tenant_id INT NOT NULL DEFAULT 0 AFTER id, ADD INDEX (tenant_id)`
+			if cols, ok := tenantUniques[tbl]; ok {
+				q += `, DROP INDEX name, ADD UNIQUE tenant_name (tenant_id, ` + cols + `)`
+			}
+			if _, err = db.Exec(q); err != nil {
+				return err
+			}
+		}
+		if _, err = db.Exec(`RENAME TABLE ` + tbl + ` TO ` + tbl + `_all`); err != nil {
+			return err
+		}
+	}
+
+	return tenantScope(nil, tbl)
+}
+
+// tenantScope creates the tenant-scoped view and the insert trigger
+// of the given table, whose rows are held in its `_all` counterpart.
+func tenantScope(otx *sql.Tx, tbl string) error {
+	exec := db.Exec
+	query := db.Query
+	if otx != nil {
+		exec = otx.Exec
+		query = otx.Query
+	}
+
+	q := `
+	SELECT column_name
+	FROM information_schema.columns
+	WHERE table_schema = DATABASE()
+	AND table_name = ?
+	AND column_name <> 'tenant_id'
+	ORDER BY ordinal_position
+	`
+	rows, err := query(q, tbl+"_all")
+	if err != nil {
+		return err
+	}
+	cols := []string{}
+	for rows.Next() {
+		var col string
+		if err = rows.Scan(&col); err != nil {
+			rows.Close()
+			return err
+		}
+		cols = append(cols, "`"+col+"`")
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if len(cols) == 0 {
+		return newError(CodeInternal, "table not found")
+	}
+
+	where := `tenant_id = flow_tenant_id()`
+	if tbl == "wf_docstates_master" {
+		// The reserved state of child documents is common to all
+		// tenants.
+		where += ` OR id = 1`
+	}
+	qs := []string{
+		`CREATE OR REPLACE VIEW ` + tbl + ` AS SELECT ` + strings.Join(cols, ", ") + ` FROM ` + tbl + `_all WHERE ` + where,
+		`DROP TRIGGER IF EXISTS ` + tbl + `_tenant`,
+		`CREATE TRIGGER ` + tbl + `_tenant BEFORE INSERT ON ` + tbl + `_all FOR EACH ROW SET NEW.tenant_id = flow_tenant_id()`,
+	}
+	for _, q := range qs {
+		if _, err = exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
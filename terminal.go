@@ -0,0 +1,124 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TerminalHook is invoked after a document enters a terminal state --
+// the state of an end node of its workflow.  It receives the document,
+// together with its tags, blobs and children, as of the time of the
+// invocation, and the event that completed the workflow.
+//
+// Typical uses are pushing the document to an ERP, generating a PDF of
+// it, or archiving its blobs.
+type TerminalHook func(ctx context.Context, doc *Document, event DocEventID) error
+
+// terminalEntry is the payload of outbox entries of terminal-state
+// hooks.
+type terminalEntry struct {
+	Hook    string     `json:"Hook"`
+	DocType DocTypeID  `json:"DocType"`
+	DocID   DocumentID `json:"DocID"`
+	Event   DocEventID `json:"Event"`
+}
+
+var terminalMu sync.RWMutex
+var terminalHooks = map[string]TerminalHook{}
+
+func init() {
+	Outbox.SetHandler(OutboxKindTerminal, runTerminalHook)
+}
+
+// OnTerminal registers the given hook under the given name.  A `nil`
+// hook unregisters the one currently having the name.
+//
+// Hooks are invoked by the outbox relay (see `RunOutbox`), only after
+// the transaction that moved the document into its terminal state
+// commits.  Each hook is invoked separately, and is retried as per the
+// outbox configuration, should it fail.  Invocations are, therefore,
+// at-least-once; hooks should be idempotent.
+//
+// Hooks are looked up by name when they are invoked.  Invocations
+// recorded for a name having no hook remain pending until a hook is
+// registered under it again.
+func (_Documents) OnTerminal(name string, fn TerminalHook) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return newError(CodeValidation, "hook name should be non-empty")
+	}
+
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+
+	if fn == nil {
+		delete(terminalHooks, name)
+		return nil
+	}
+	terminalHooks[name] = fn
+	return nil
+}
+
+// terminalHook answers the hook registered under the given name, if
+// any.
+func terminalHook(name string) TerminalHook {
+	terminalMu.RLock()
+	defer terminalMu.RUnlock()
+	return terminalHooks[name]
+}
+
+// enqueueTerminalHooks records an invocation of each registered hook,
+// for the given event having moved its document into a terminal state.
+func enqueueTerminalHooks(otx *sql.Tx, event *DocEvent) error {
+	terminalMu.RLock()
+	names := make([]string, 0, len(terminalHooks))
+	for name := range terminalHooks {
+		names = append(names, name)
+	}
+	terminalMu.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		e := &terminalEntry{Hook: name, DocType: event.DocType, DocID: event.DocID, Event: event.ID}
+		if _, err := Outbox.Enqueue(otx, OutboxKindTerminal, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTerminalHook is the outbox handler of terminal-state hooks.
+func runTerminalHook(ctx context.Context, tx *sql.Tx, e *OutboxEntry) error {
+	var te terminalEntry
+	if err := json.Unmarshal(e.Payload, &te); err != nil {
+		return err
+	}
+	fn := terminalHook(te.Hook)
+	if fn == nil {
+		return ErrOutboxDeferred
+	}
+
+	doc, err := Documents.Get(tx, te.DocType, te.DocID, WithTags(), WithBlobs(), WithChildren())
+	if err != nil {
+		return err
+	}
+	return fn(ctx, doc, te.Event)
+}
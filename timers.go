@@ -0,0 +1,100 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// timerTask performs one round of the timed work of one kind, that is
+// due.  It answers the number of items processed.
+//
+// Tasks should claim each item before processing it, so that multiple
+// timer pumps can run concurrently.
+type timerTask func(ctx context.Context) (int, error)
+
+var timersMu sync.RWMutex
+var timerTasks = map[string]timerTask{}
+
+// registerTimer adds the given task to the timer pump, under the given
+// name.
+func registerTimer(name string, fn timerTask) {
+	timersMu.Lock()
+	defer timersMu.Unlock()
+	timerTasks[name] = fn
+}
+
+// FireTimers makes one round of all the timed work that is due, such
+// as reminders.  It answers the number of items processed.
+func FireTimers(ctx context.Context) (int, error) {
+	timersMu.RLock()
+	names := make([]string, 0, len(timerTasks))
+	for name := range timerTasks {
+		names = append(names, name)
+	}
+	timersMu.RUnlock()
+	sort.Strings(names)
+
+	n := 0
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		timersMu.RLock()
+		fn := timerTasks[name]
+		timersMu.RUnlock()
+
+		k, err := fn(ctx)
+		n += k
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// RunTimers is the timer pump.  It fires the timers every `interval`,
+// until the given context is cancelled.  Errors are reported to
+// `onErr`, if given, or logged otherwise.  They do not stop the pump.
+func RunTimers(ctx context.Context, interval time.Duration, onErr func(error)) error {
+	if interval <= 0 {
+		return newError(CodeValidation, "interval should be a positive duration")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := FireTimers(ctx)
+		if err != nil && ctx.Err() == nil {
+			if onErr != nil {
+				onErr(err)
+			} else {
+				writeLog(LogError, "timer pump failed", F("error", err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+		}
+	}
+}
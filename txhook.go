@@ -0,0 +1,183 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TransitionValidator is invoked in the transaction applying an event,
+// just before the event moves its document from its current state to
+// `tstate`.  Answering an error vetoes the transition -- e.g. upon a
+// failed budget check -- and the transaction is rolled back.
+//
+// Validators can read the database using the given transaction, so
+// that they see the document as the transition does.  They should not
+// have side effects outside it; the transaction may be retried.
+type TransitionValidator func(tx *sql.Tx, doc *Document, event *DocEvent, tstate DocStateID) error
+
+// TransitionListener is invoked after the transaction that applied an
+// event commits.  It receives the event, and the state into which it
+// moved its document.
+type TransitionListener func(ctx context.Context, event *DocEvent, tstate DocStateID) error
+
+// transitionEntry is the payload of outbox entries of transition
+// listeners.
+type transitionEntry struct {
+	Listener string     `json:"Listener"`
+	Event    DocEventID `json:"Event"`
+	ToState  DocStateID `json:"ToState"`
+}
+
+var txHookMu sync.RWMutex
+var txValidators = map[string]TransitionValidator{}
+var txListeners = map[string]TransitionListener{}
+
+func init() {
+	Outbox.SetHandler(OutboxKindTransition, runTransitionListener)
+}
+
+// SetValidator registers the given transition validator under the
+// given name.  A `nil` validator unregisters the one currently having
+// the name.
+//
+// Validators are invoked in the order of their names, for every event
+// applied, until one of them answers an error.  That error is answered
+// by the application of the event.  Errors that are not `*CodedError`s
+// are answered wrapped in one having `CodeConflict`.
+func (_Workflows) SetValidator(name string, fn TransitionValidator) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return newError(CodeValidation, "validator name should be non-empty")
+	}
+
+	txHookMu.Lock()
+	defer txHookMu.Unlock()
+
+	if fn == nil {
+		delete(txValidators, name)
+		return nil
+	}
+	txValidators[name] = fn
+	return nil
+}
+
+// OnTransition registers the given transition listener under the given
+// name.  A `nil` listener unregisters the one currently having the
+// name.
+//
+// Listeners are invoked by the outbox relay (see `RunOutbox`), only
+// after the transaction that applied the event commits; events rolled
+// back are never seen.  Each listener is invoked separately, and is
+// retried as per the outbox configuration, should it fail.
+// Invocations are, therefore, at-least-once; listeners should be
+// idempotent.  As with terminal-state hooks, invocations recorded for
+// a name having no listener remain pending until one is registered
+// under it again.
+func (_Workflows) OnTransition(name string, fn TransitionListener) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return newError(CodeValidation, "listener name should be non-empty")
+	}
+
+	txHookMu.Lock()
+	defer txHookMu.Unlock()
+
+	if fn == nil {
+		delete(txListeners, name)
+		return nil
+	}
+	txListeners[name] = fn
+	return nil
+}
+
+// validateTransition runs the registered validators on the given
+// transition, and answers the first veto, if any.
+func validateTransition(tx *sql.Tx, doc *Document, event *DocEvent, tstate DocStateID) error {
+	txHookMu.RLock()
+	names := make([]string, 0, len(txValidators))
+	for name := range txValidators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fns := make([]TransitionValidator, len(names))
+	for i, name := range names {
+		fns[i] = txValidators[name]
+	}
+	txHookMu.RUnlock()
+
+	for i, name := range names {
+		err := fns[i](tx, doc, event, tstate)
+		if err == nil {
+			continue
+		}
+		writeLog(LogInfo, "transition vetoed", F("validator", name), F("event", event.ID), F("to_state", tstate))
+		if _, ok := err.(*CodedError); ok {
+			return err
+		}
+		return &CodedError{Code: CodeConflict, Msg: "transition vetoed by " + name, Err: err}
+	}
+	return nil
+}
+
+// enqueueTransitionListeners records an invocation of each registered
+// listener, for the given event having moved its document into the
+// given state.
+func enqueueTransitionListeners(otx *sql.Tx, event *DocEvent, tstate DocStateID) error {
+	txHookMu.RLock()
+	names := make([]string, 0, len(txListeners))
+	for name := range txListeners {
+		names = append(names, name)
+	}
+	txHookMu.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		e := &transitionEntry{Listener: name, Event: event.ID, ToState: tstate}
+		if _, err := Outbox.Enqueue(otx, OutboxKindTransition, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTransitionListener is the outbox handler of transition listeners.
+func runTransitionListener(ctx context.Context, tx *sql.Tx, e *OutboxEntry) error {
+	var te transitionEntry
+	if err := json.Unmarshal(e.Payload, &te); err != nil {
+		return err
+	}
+	txHookMu.RLock()
+	fn := txListeners[te.Listener]
+	txHookMu.RUnlock()
+	if fn == nil {
+		return ErrOutboxDeferred
+	}
+
+	event, err := getDocEvent(tx.QueryRow, te.Event)
+	if err == ErrDocEventNotFound {
+		// Purged since.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fn(ctx, event, te.ToState)
+}
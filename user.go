@@ -16,7 +16,6 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
 	"math"
 	"strings"
 )
@@ -45,78 +44,124 @@ type _Users struct{}
 var Users _Users
 
 // List answers a subset of the users, based on the input
-// specification.
+// specification.  Users whose first or last names begin with the given
+// prefix are answered.  See `Search` for more filters.
 //
 // Result set begins with ID >= `offset`, and has not more than
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_Users) List(prefix string, offset, limit int64) ([]*User, error) {
-	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+	return Users.Search(&UsersListInput{NamePrefix: prefix}, offset, limit)
+}
+
+// UsersListInput specifies a set of filtering criteria on users.  All
+// the given criteria should be met.
+type UsersListInput struct {
+	NamePrefix  string // List users whose first or last names begin with this
+	EmailPrefix string // List users whose e-mail addresses begin with this
+	EmailDomain string // List users whose e-mail addresses are in this domain, e.g. `example.com`
+	Active      *bool  // List only the active users, or only the inactive ones, if given
+	GroupID            // List the members of this group
+}
+
+// where answers the SQL condition and the arguments of this
+// specification.
+func (input *UsersListInput) where() (string, []interface{}) {
+	conds := []string{`1 = 1`}
+	args := []interface{}{}
+
+	if prefix := strings.TrimSpace(input.NamePrefix); prefix != "" {
+		conds = append(conds, `(um.first_name LIKE ? OR um.last_name LIKE ?)`)
+		args = append(args, likeEscape(prefix)+"%", likeEscape(prefix)+"%")
 	}
-	if limit == 0 {
-		limit = math.MaxInt64
+	if prefix := strings.TrimSpace(input.EmailPrefix); prefix != "" {
+		conds = append(conds, `um.email LIKE ?`)
+		args = append(args, likeEscape(prefix)+"%")
+	}
+	if domain := strings.TrimPrefix(strings.TrimSpace(input.EmailDomain), "@"); domain != "" {
+		conds = append(conds, `um.email LIKE ?`)
+		args = append(args, "%@"+likeEscape(domain))
+	}
+	if input.Active != nil {
+		conds = append(conds, `um.active = ?`)
+		args = append(args, *input.Active)
+	}
+	if input.GroupID > 0 {
+		conds = append(conds, `um.id IN (SELECT user_id FROM wf_group_users WHERE group_id = ?)`)
+		args = append(args, input.GroupID)
 	}
 
-	var q string
-	var rows *sql.Rows
-	var err error
+	return strings.Join(conds, ` AND `), args
+}
 
-	prefix = strings.TrimSpace(prefix)
-	if prefix == "" {
-		q = `
-		SELECT id, first_name, last_name, email, active
-		FROM wf_users_master
-		ORDER BY id
-		LIMIT ? OFFSET ?
-		`
-		rows, err = db.Query(q, limit, offset)
-	} else {
-		q = `
-		SELECT id, first_name, last_name, email, active
-		FROM wf_users_master
-		WHERE first_name LIKE ?
-		UNION
-		SELECT id, first_name, last_name, email, active
-		FROM wf_users_master
-		WHERE last_name LIKE ?
-		ORDER BY id
-		LIMIT ? OFFSET ?
-		`
-		rows, err = db.Query(q, prefix+"%", prefix+"%", limit, offset)
+// Search answers a subset of the users meeting the given criteria.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_Users) Search(input *UsersListInput, offset, limit int64) ([]*User, error) {
+	if input == nil {
+		return nil, newError(CodeValidation, "input should be non-nil")
 	}
-	if err != nil {
-		return nil, err
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
-	defer rows.Close()
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	where, args := input.where()
+	q := `
+	SELECT um.id, um.first_name, um.last_name, um.email, um.active
+	FROM wf_users_master um
+	WHERE ` + where + `
+	ORDER BY um.id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
 
 	ary := make([]*User, 0, 10)
-	for rows.Next() {
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
 		var elem User
-		err = rows.Scan(&elem.ID, &elem.FirstName, &elem.LastName, &elem.Email, &elem.Active)
-		if err != nil {
-			return nil, err
+		if err := scan(&elem.ID, &elem.FirstName, &elem.LastName, &elem.Email, &elem.Active); err != nil {
+			return err
 		}
 		ary = append(ary, &elem)
-	}
-	if err = rows.Err(); err != nil {
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return ary, nil
 }
 
+// Count answers the number of users meeting the given criteria.
+func (_Users) Count(input *UsersListInput) (int64, error) {
+	if input == nil {
+		return 0, newError(CodeValidation, "input should be non-nil")
+	}
+
+	where, args := input.where()
+	var n int64
+	err := readDB().QueryRow(`SELECT COUNT(*) FROM wf_users_master um WHERE `+where, args...).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 // Get instantiates a user instance by reading the database.
 func (_Users) Get(uid UserID) (*User, error) {
 	if uid <= 0 {
-		return nil, errors.New("user ID should be a positive integer")
+		return nil, newError(CodeValidation, "user ID should be a positive integer")
 	}
 
 	var elem User
-	row := db.QueryRow("SELECT id, first_name, last_name, email, active FROM wf_users_master WHERE id = ?", uid)
+	row := readDB().QueryRow("SELECT id, first_name, last_name, email, active FROM wf_users_master WHERE id = ?", uid)
 	err := row.Scan(&elem.ID, &elem.FirstName, &elem.LastName, &elem.Email, &elem.Active)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrUserNotFound)
 	}
 
 	return &elem, nil
@@ -127,14 +172,14 @@ func (_Users) Get(uid UserID) (*User, error) {
 func (_Users) GetByEmail(email string) (*User, error) {
 	email = strings.TrimSpace(email)
 	if email == "" {
-		return nil, errors.New("e-mail address should be non-empty")
+		return nil, newError(CodeValidation, "e-mail address should be non-empty")
 	}
 
 	var elem User
-	row := db.QueryRow("SELECT id, first_name, last_name, email, active FROM wf_users_master WHERE email = ?", email)
+	row := readDB().QueryRow("SELECT id, first_name, last_name, email, active FROM wf_users_master WHERE email = ?", email)
 	err := row.Scan(&elem.ID, &elem.FirstName, &elem.LastName, &elem.Email, &elem.Active)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrUserNotFound)
 	}
 
 	return &elem, nil
@@ -142,7 +187,7 @@ func (_Users) GetByEmail(email string) (*User, error) {
 
 // IsActive answers `true` if the given user's account is enabled.
 func (_Users) IsActive(uid UserID) (bool, error) {
-	row := db.QueryRow("SELECT active FROM wf_users_master WHERE id = ?", uid)
+	row := readDB().QueryRow("SELECT active FROM wf_users_master WHERE id = ?", uid)
 	var active bool
 	err := row.Scan(&active)
 	if err != nil {
@@ -162,7 +207,7 @@ func (_Users) GroupsOf(uid UserID) ([]*Group, error) {
 	JOIN wf_users_master um ON um.id = gus.user_id
 	WHERE um.id = ?
 	`
-	rows, err := db.Query(q, uid)
+	rows, err := readDB().Query(q, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -193,10 +238,10 @@ func (_Users) SingletonGroupOf(uid UserID) (*Group, error) {
 	FROM wf_groups_master gm
 	JOIN wf_group_users gu ON gu.group_id = gm.id
 	WHERE gu.user_id = ?
-	AND gm.group_type = 'S'
+	AND gm.group_type = ` + GroupSingleton.sql() + `
 	`
 	var elem Group
-	row := db.QueryRow(q, uid)
+	row := readDB().QueryRow(q, uid)
 	err := row.Scan(&elem.ID, &elem.Name, &elem.GroupType)
 	if err != nil {
 		return nil, err
@@ -204,3 +249,128 @@ func (_Users) SingletonGroupOf(uid UserID) (*Group, error) {
 
 	return &elem, nil
 }
+
+// SyncEmail changes the e-mail address of the given user, renaming
+// the user's singleton group -- which is linked by it -- in the same
+// transaction.  Directory integrations should use this when a user's
+// address changes in the directory.  A singleton group is created for
+// the user, should it not exist.
+func (_Users) SyncEmail(otx *sql.Tx, uid UserID, email string) error {
+	if err := checkAdmin(otx, AdminGroups); err != nil {
+		return err
+	}
+
+	email = strings.TrimSpace(email)
+	var v validator
+	v.positive("uid", int64(uid))
+	v.required("email", email)
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		var old string
+		row := tx.QueryRow("SELECT email FROM wf_users_master WHERE id = ? FOR UPDATE", uid)
+		if err := row.Scan(&old); err != nil {
+			return notFound(err, ErrUserNotFound)
+		}
+
+		var n int64
+		q := `
+		SELECT COUNT(*)
+		FROM wf_users_master
+		WHERE email = ?
+		AND id <> ?
+		`
+		if err := tx.QueryRow(q, email, uid).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return newError(CodeConflict, "e-mail address is in use by another user")
+		}
+
+		var gid int64
+		q = `
+		SELECT gm.id
+		FROM wf_groups_master gm
+		JOIN wf_group_users gu ON gu.group_id = gm.id
+		WHERE gu.user_id = ?
+		AND gm.group_type = ` + GroupSingleton.sql() + `
+		FOR UPDATE
+		`
+		err := tx.QueryRow(q, uid).Scan(&gid)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		q = `
+		SELECT COUNT(*)
+		FROM wf_groups_master
+		WHERE name = ?
+		AND id <> ?
+		`
+		if err = tx.QueryRow(q, email, gid).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return newError(CodeConflict, "e-mail address is in use as the name of another group")
+		}
+
+		_, err = tx.Exec("UPDATE wf_users_master SET email = ? WHERE id = ?", email, uid)
+		if err != nil {
+			return err
+		}
+		if gid == 0 {
+			_, err = Groups.NewSingleton(tx, uid)
+		} else {
+			_, err = tx.Exec("UPDATE wf_groups_master SET name = ? WHERE id = ?", email, gid)
+		}
+		if err != nil {
+			return err
+		}
+
+		writeLog(LogInfo, "user e-mail address changed", F("user", uid), F("old", old), F("new", email))
+		return nil
+	})
+}
+
+// SingletonMismatch reports a user whose singleton group is not in
+// step with the user's e-mail address.
+type SingletonMismatch struct {
+	User      UserID  `json:"User"`      // The user
+	Email     string  `json:"Email"`     // Current e-mail address of the user
+	Group     GroupID `json:"Group"`     // Singleton group of the user; `0` if there is none
+	GroupName string  `json:"GroupName"` // Name of the singleton group, if any
+}
+
+// SingletonMismatches answers the users whose singleton groups are
+// missing, or are not named by their e-mail addresses.  Each can be
+// repaired using `SyncEmail`, with the user's current address.
+func (_Users) SingletonMismatches() ([]*SingletonMismatch, error) {
+	q := `
+	SELECT um.id, um.email, IFNULL(sg.id, 0), IFNULL(sg.name, '')
+	FROM wf_users_master um
+	LEFT JOIN (
+		SELECT gu.user_id, gm.id, gm.name
+		FROM wf_groups_master gm
+		JOIN wf_group_users gu ON gu.group_id = gm.id
+		WHERE gm.group_type = ` + GroupSingleton.sql() + `
+	) sg ON sg.user_id = um.id
+	WHERE sg.id IS NULL
+	OR sg.name <> um.email
+	ORDER BY um.id
+	`
+	ary := []*SingletonMismatch{}
+	err := scanEach(readDB().Query, q, nil, func(scan func(...interface{}) error) error {
+		var elem SingletonMismatch
+		if err := scan(&elem.User, &elem.Email, &elem.Group, &elem.GroupName); err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
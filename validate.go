@@ -0,0 +1,156 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Maximum lengths, in characters, of the text columns of `flow`.
+const (
+	maxNameLen     = 100
+	maxRoleNameLen = 50
+	maxTitleLen    = 250
+	maxTagLen      = 50
+	maxLocaleLen   = 20
+)
+
+// FieldError describes a single problem with an input field.
+type FieldError struct {
+	Field   string // Name of the input field
+	Problem string // What is wrong with its value
+}
+
+// Error implements the `error` interface.
+func (e *FieldError) Error() string {
+	return e.Field + " : " + e.Problem
+}
+
+// ValidationErrors lists every problem found with an input, so that
+// all of them can be reported at once.  It has the code
+// `CodeValidation`.
+type ValidationErrors []*FieldError
+
+// Error implements the `error` interface.
+func (ve ValidationErrors) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("invalid input : ")
+	for i, fe := range ve {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString(fe.Error())
+	}
+	return buf.String()
+}
+
+// Is answers `true` if the target is `CodeValidation`.
+func (ve ValidationErrors) Is(target error) bool {
+	return target == CodeValidation
+}
+
+// validator accumulates the problems found while checking the fields
+// of an input.
+type validator struct {
+	errs ValidationErrors
+	err  error // Failure of a database check, if any
+}
+
+// fail records a problem with the given field.
+func (v *validator) fail(field, problem string) {
+	v.errs = append(v.errs, &FieldError{Field: field, Problem: problem})
+}
+
+// failed answers `true` if a problem has already been recorded for the
+// given field.  Further checks of such a field are skipped.
+func (v *validator) failed(field string) bool {
+	for _, fe := range v.errs {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// positive checks that the given identifier is a positive integer.
+func (v *validator) positive(field string, id int64) {
+	if id <= 0 {
+		v.fail(field, "should be a positive integer")
+	}
+}
+
+// required checks that the given text is non-empty.
+func (v *validator) required(field, s string) {
+	if s == "" {
+		v.fail(field, "is required")
+	}
+}
+
+// maxLen checks that the given text has not more than `n` characters.
+func (v *validator) maxLen(field, s string, n int) {
+	if v.failed(field) {
+		return
+	}
+	if utf8.RuneCountInString(s) > n {
+		v.fail(field, fmt.Sprintf("should not exceed %d characters", n))
+	}
+}
+
+// name checks that the given name is non-empty, and within the given
+// length.
+func (v *validator) name(field, s string, n int) {
+	v.required(field, s)
+	v.maxLen(field, s, n)
+}
+
+// unique checks that no row of the given table, other than that with
+// the given ID, has the given name.  It looks in the given
+// transaction, if any.
+func (v *validator) unique(otx *sql.Tx, field, tbl, name string, except int64) {
+	if v.failed(field) || v.err != nil {
+		return
+	}
+
+	q := `SELECT COUNT(*) FROM ` + tbl + ` WHERE name = ? AND id <> ?`
+	var row *sql.Row
+	if otx == nil {
+		row = db.QueryRow(q, name, except)
+	} else {
+		row = otx.QueryRow(q, name, except)
+	}
+	var n int64
+	if err := row.Scan(&n); err != nil {
+		v.err = err
+		return
+	}
+	if n > 0 {
+		v.fail(field, fmt.Sprintf("%q is already in use", name))
+	}
+}
+
+// result answers the failure of a database check, if any; else, the
+// problems found, if any; `nil` otherwise.
+func (v *validator) result() error {
+	if v.err != nil {
+		return v.err
+	}
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
@@ -0,0 +1,469 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VoteRule enumerates the rules deciding the outcome of votes.
+type VoteRule uint8
+
+const (
+	// VoteMajority : approved when more than half of the voters approve
+	VoteMajority VoteRule = iota + 1
+	// VoteWeighted : approved when the weight of the approvals reaches the threshold
+	VoteWeighted
+)
+
+// Voter is a group entitled to vote at a voting node.
+type Voter struct {
+	Group  GroupID `json:"Group"`  // Voting group; any of its members can vote on its behalf
+	Weight int     `json:"Weight"` // Weight of the group's vote; used by `VoteWeighted`
+	Veto   bool    `json:"Veto"`   // Does a rejection by this group reject outright?
+}
+
+// VotePolicy specifies the voting at a node of type `NodeTypeVote`.
+//
+// Votes are cast using `Documents.Vote`.  As soon as the outcome is
+// decided by the rule, `ApproveAction` or `RejectAction` is applied on
+// behalf of `Actor`, moving the document on.  A rejection by a voter
+// having a veto decides the outcome immediately, irrespective of the
+// rule.
+//
+// Under `VoteWeighted`, the votes are approved once the weight of the
+// approvals is at least `Threshold` -- a fraction in (0, 1] -- of the
+// total weight of all the voters, and rejected once that can no longer
+// happen.
+type VotePolicy struct {
+	Rule          VoteRule    `json:"Rule"`                // Rule deciding the outcome
+	Threshold     float64     `json:"Threshold,omitempty"` // Fraction of the total weight required; `VoteWeighted` only
+	Voters        []Voter     `json:"Voters"`              // Groups entitled to vote
+	ApproveAction DocActionID `json:"ApproveAction"`       // Action applied upon approval
+	RejectAction  DocActionID `json:"RejectAction"`        // Action applied upon rejection
+	Actor         GroupID     `json:"Actor"`               // Singleton group on whose behalf the outcome is applied
+}
+
+// SetVotePolicy sets the vote policy of the given node, which should
+// be of type `NodeTypeVote`.  A `nil` policy removes it.
+func (_Nodes) SetVotePolicy(otx *sql.Tx, id NodeID, p *VotePolicy) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	var v validator
+	v.positive("node ID", int64(id))
+	if p != nil {
+		switch p.Rule {
+		case VoteMajority:
+			// Nothing to check
+
+		case VoteWeighted:
+			if p.Threshold <= 0 || p.Threshold > 1 {
+				v.fail("Threshold", "should be in (0, 1]")
+			}
+
+		default:
+			v.fail("Rule", "unknown vote rule")
+		}
+		if len(p.Voters) == 0 {
+			v.fail("Voters", "should be non-empty")
+		}
+		seen := map[GroupID]bool{}
+		for i, vr := range p.Voters {
+			v.positive(fmt.Sprintf("Voters[%d].Group", i), int64(vr.Group))
+			v.positive(fmt.Sprintf("Voters[%d].Weight", i), int64(vr.Weight))
+			if seen[vr.Group] {
+				v.fail(fmt.Sprintf("Voters[%d].Group", i), "is repeated")
+			}
+			seen[vr.Group] = true
+		}
+		v.positive("ApproveAction", int64(p.ApproveAction))
+		v.positive("RejectAction", int64(p.RejectAction))
+		v.positive("Actor", int64(p.Actor))
+	}
+	if err := v.result(); err != nil {
+		return err
+	}
+
+	n, err := Nodes.Get(id)
+	if err != nil {
+		return err
+	}
+	if n.NodeType != NodeTypeVote {
+		return errorf(CodeValidation, "node %d is not of type %s", id, NodeTypeVote)
+	}
+	if p != nil {
+		ts, err := n.Transitions()
+		if err != nil {
+			return err
+		}
+		for _, action := range []DocActionID{p.ApproveAction, p.RejectAction} {
+			if _, ok := ts[action]; !ok {
+				return errorf(CodeValidation, "action %d is not defined on the state of node %d", action, id)
+			}
+		}
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_node_voters WHERE node_id = ?", id)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			_, err = tx.Exec("DELETE FROM wf_node_vote_policies WHERE node_id = ?", id)
+			return err
+		}
+
+		q := `
+		INSERT INTO wf_node_vote_policies(node_id, rule, threshold, approve_action_id, reject_action_id, group_id)
+		VALUES(?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE rule = VALUES(rule), threshold = VALUES(threshold),
+			approve_action_id = VALUES(approve_action_id), reject_action_id = VALUES(reject_action_id),
+			group_id = VALUES(group_id)
+		`
+		_, err = tx.Exec(q, id, p.Rule, p.Threshold, p.ApproveAction, p.RejectAction, p.Actor)
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]interface{}, 0, len(p.Voters))
+		for _, vr := range p.Voters {
+			rows = append(rows, []interface{}{id, vr.Group, vr.Weight, vr.Veto})
+		}
+		q = `INSERT INTO wf_node_voters(node_id, group_id, weight, veto) VALUES`
+		return insertRows(tx, q, `(?, ?, ?, ?)`, rows)
+	})
+}
+
+// VotePolicy answers the vote policy of the given node, if any; `nil`
+// otherwise.
+func (_Nodes) VotePolicy(id NodeID) (*VotePolicy, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "node ID must be a positive integer")
+	}
+
+	return votePolicy(nil, id)
+}
+
+// votePolicy answers the vote policy of the given node, if any; `nil`
+// otherwise.
+func votePolicy(otx *sql.Tx, id NodeID) (*VotePolicy, error) {
+	q := `
+	SELECT rule, threshold, approve_action_id, reject_action_id, group_id
+	FROM wf_node_vote_policies
+	WHERE node_id = ?
+	`
+	row, err := stmts.queryRow(otx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	var p VotePolicy
+	err = row.Scan(&p.Rule, &p.Threshold, &p.ApproveAction, &p.RejectAction, &p.Actor)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+
+	q = `
+	SELECT group_id, weight, veto
+	FROM wf_node_voters
+	WHERE node_id = ?
+	ORDER BY group_id
+	`
+	query := readDB().Query
+	if otx != nil {
+		query = otx.Query
+	}
+	err = scanEach(query, q, []interface{}{id}, func(scan func(...interface{}) error) error {
+		var vr Voter
+		if err := scan(&vr.Group, &vr.Weight, &vr.Veto); err != nil {
+			return err
+		}
+		p.Voters = append(p.Voters, vr)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// VoteOutcome enumerates the outcomes of votes.
+type VoteOutcome uint8
+
+const (
+	// VotePending : not yet decided
+	VotePending VoteOutcome = iota + 1
+	// VoteApproved : decided in favour
+	VoteApproved
+	// VoteRejected : decided against
+	VoteRejected
+)
+
+// Vote is a vote cast by a group on a document at a voting node.
+type Vote struct {
+	Group   GroupID   `json:"Group"`   // Voting group
+	User    UserID    `json:"User"`    // Member who cast the vote on behalf of the group
+	Approve bool      `json:"Approve"` // Approval, or rejection?
+	Weight  int       `json:"Weight"`  // Weight of the vote
+	Text    string    `json:"Text"`    // Comments, if any
+	Ctime   time.Time `json:"Ctime"`   // Time of the vote
+}
+
+// VoteTally summarises the votes cast on a document at its current,
+// voting, node.
+type VoteTally struct {
+	Node           NodeID      `json:"Node"`           // Voting node
+	State          DocStateID  `json:"DocState"`       // State of the node
+	Rule           VoteRule    `json:"Rule"`           // Rule deciding the outcome
+	Votes          []*Vote     `json:"Votes"`          // Votes cast, in order
+	Voters         int         `json:"Voters"`         // Number of groups entitled to vote
+	Approvals      int         `json:"Approvals"`      // Number of approvals
+	Rejections     int         `json:"Rejections"`     // Number of rejections
+	ApprovedWeight int         `json:"ApprovedWeight"` // Total weight of the approvals
+	RejectedWeight int         `json:"RejectedWeight"` // Total weight of the rejections
+	TotalWeight    int         `json:"TotalWeight"`    // Total weight of all the voters
+	Vetoed         bool        `json:"Vetoed"`         // Was a veto exercised?
+	Outcome        VoteOutcome `json:"Outcome"`        // Outcome as per the rule
+}
+
+// decide computes the outcome of this tally under the given policy.
+func (t *VoteTally) decide(p *VotePolicy) {
+	t.Outcome = VotePending
+	switch {
+	case t.Vetoed:
+		t.Outcome = VoteRejected
+
+	case p.Rule == VoteMajority && t.Approvals*2 > t.Voters:
+		t.Outcome = VoteApproved
+
+	case p.Rule == VoteMajority && t.Rejections*2 >= t.Voters:
+		t.Outcome = VoteRejected
+
+	case p.Rule == VoteWeighted && float64(t.ApprovedWeight) >= p.Threshold*float64(t.TotalWeight):
+		t.Outcome = VoteApproved
+
+	case p.Rule == VoteWeighted && float64(t.TotalWeight-t.RejectedWeight) < p.Threshold*float64(t.TotalWeight):
+		t.Outcome = VoteRejected
+	}
+}
+
+// votingNode answers the node at which the given document currently
+// is, which should be a voting node, together with its policy.
+func votingNode(otx *sql.Tx, dtype DocTypeID, did DocumentID) (*Document, *Node, *VotePolicy, error) {
+	doc, err := Documents.Get(otx, dtype, did)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	n, err := Nodes.GetByState(dtype, doc.State.ID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if n.NodeType != NodeTypeVote {
+		return nil, nil, nil, errorf(CodeConflict, "document is not at a voting node")
+	}
+	p, err := votePolicy(otx, n.ID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if p == nil {
+		return nil, nil, nil, errorf(CodeValidation, "node %d : no vote policy is set", n.ID)
+	}
+	return doc, n, p, nil
+}
+
+// tally counts the current votes on the given document at the given
+// voting node.
+func tally(otx *sql.Tx, dtype DocTypeID, did DocumentID, n *Node, p *VotePolicy) (*VoteTally, error) {
+	t := &VoteTally{Node: n.ID, State: n.State, Rule: p.Rule, Voters: len(p.Voters), Votes: []*Vote{}}
+	voters := map[GroupID]Voter{}
+	for _, vr := range p.Voters {
+		voters[vr.Group] = vr
+		t.TotalWeight += vr.Weight
+	}
+
+	q := `
+	SELECT group_id, user_id, approve, data, ctime
+	FROM wf_votes
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND node_id = ?
+	AND active = 1
+	ORDER BY id
+	`
+	query := readDB().Query
+	if otx != nil {
+		query = otx.Query
+	}
+	err := scanEach(query, q, []interface{}{dtype, did, n.ID}, func(scan func(...interface{}) error) error {
+		var elem Vote
+		if err := scan(&elem.Group, &elem.User, &elem.Approve, &elem.Text, &elem.Ctime); err != nil {
+			return err
+		}
+		// Groups removed from the policy meanwhile do not count.
+		vr, ok := voters[elem.Group]
+		if !ok {
+			return nil
+		}
+		elem.Weight = vr.Weight
+		t.Votes = append(t.Votes, &elem)
+		if elem.Approve {
+			t.Approvals++
+			t.ApprovedWeight += vr.Weight
+		} else {
+			t.Rejections++
+			t.RejectedWeight += vr.Weight
+			t.Vetoed = t.Vetoed || vr.Veto
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.decide(p)
+	return t, nil
+}
+
+// Votes answers the tally of the votes cast on the given document at
+// its current node, which should be a voting node.
+func (_Documents) Votes(dtype DocTypeID, did DocumentID) (*VoteTally, error) {
+	if dtype <= 0 || did <= 0 {
+		return nil, newError(CodeValidation, "document type and document ID must be positive integers")
+	}
+
+	_, n, p, err := votingNode(nil, dtype, did)
+	if err != nil {
+		return nil, err
+	}
+	return tally(nil, dtype, did, n, p)
+}
+
+// DocumentsVoteInput specifies a vote on a document.
+type DocumentsVoteInput struct {
+	DocTypeID         // Type of the document; required
+	DocumentID        // Document being voted on; required
+	GroupID           // Voting group; required
+	UserID            // Member of the group casting the vote; required
+	Approve    bool   // Approval, or rejection?
+	Text       string // Comments, if any
+}
+
+// Vote casts the given vote on the given document, which should be at
+// a voting node.  A group that has already voted can change its vote,
+// until the outcome is decided.  Once it is, the corresponding action
+// of the node's policy is applied in the same transaction.  It answers
+// the tally after the vote.
+func (_Documents) Vote(otx *sql.Tx, input *DocumentsVoteInput) (*VoteTally, error) {
+	var v validator
+	v.positive("DocTypeID", int64(input.DocTypeID))
+	v.positive("DocumentID", int64(input.DocumentID))
+	v.positive("GroupID", int64(input.GroupID))
+	v.positive("UserID", int64(input.UserID))
+	input.Text = strings.TrimSpace(input.Text)
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+
+	ok, err := Groups.HasUser(input.GroupID, input.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrPermissionDenied
+	}
+
+	var t *VoteTally
+	err = retryWithTx(otx, func(tx *sql.Tx) error {
+		doc, n, p, err := votingNode(tx, input.DocTypeID, input.DocumentID)
+		if err != nil {
+			return err
+		}
+		entitled := false
+		for _, vr := range p.Voters {
+			entitled = entitled || vr.Group == input.GroupID
+		}
+		if !entitled {
+			return ErrPermissionDenied
+		}
+
+		q := `
+		UPDATE wf_votes
+		SET active = 0
+		WHERE doctype_id = ?
+		AND doc_id = ?
+		AND node_id = ?
+		AND group_id = ?
+		AND active = 1
+		`
+		if _, err = tx.Exec(q, input.DocTypeID, input.DocumentID, n.ID, input.GroupID); err != nil {
+			return err
+		}
+		q = `
+		INSERT INTO wf_votes(doctype_id, doc_id, node_id, group_id, user_id, approve, data, active, ctime)
+		VALUES(?, ?, ?, ?, ?, ?, ?, 1, NOW())
+		`
+		_, err = tx.Exec(q, input.DocTypeID, input.DocumentID, n.ID, input.GroupID, input.UserID, input.Approve, input.Text)
+		if err != nil {
+			return err
+		}
+
+		t, err = tally(tx, input.DocTypeID, input.DocumentID, n, p)
+		if err != nil || t.Outcome == VotePending {
+			return err
+		}
+
+		w, err := Workflows.GetByDocType(input.DocTypeID)
+		if err != nil {
+			return err
+		}
+		action, text := p.ApproveAction, "approved by vote"
+		if t.Outcome == VoteRejected {
+			action, text = p.RejectAction, "rejected by vote"
+		}
+		_, err = w.applySystemEvent(tx, input.DocumentID, doc.State.ID, action, p.Actor, text)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if otx == nil && t.Outcome != VotePending {
+		Outbox.Notify()
+	}
+	return t, nil
+}
+
+// closeVotes retires the votes cast on the given document, which is
+// transitioning.  A later return to the voting node begins afresh.
+func closeVotes(otx *sql.Tx, dtype DocTypeID, did DocumentID) error {
+	q := `
+	UPDATE wf_votes
+	SET active = 0
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND active = 1
+	`
+	_, err := stmts.exec(otx, q, dtype, did)
+	return err
+}
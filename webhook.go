@@ -0,0 +1,667 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebhookSignatureHeader is the HTTP header carrying the HMAC-SHA256
+// signature of a webhook payload, computed using the secret of the
+// webhook, in the form `sha256=<hex digest>`.
+const WebhookSignatureHeader = "X-Flow-Signature"
+
+// WebhookID is the type of unique identifiers of webhooks.
+type WebhookID int64
+
+// Webhook is an HTTP endpoint to which workflow events are delivered.
+//
+// A webhook can receive all events, or only those on documents of a
+// given type, or only those on documents in a given access context.
+// When both are specified, both should match.
+type Webhook struct {
+	ID      WebhookID       `json:"ID"`                // Unique identifier of this webhook
+	URL     string          `json:"URL"`               // Endpoint to which events are POSTed
+	Secret  string          `json:"-"`                 // Key used to sign payloads
+	DocType DocTypeID       `json:"DocType,omitempty"` // Restrict to this document type, if non-zero
+	AccCtx  AccessContextID `json:"AccCtx,omitempty"`  // Restrict to this access context, if non-zero
+	Active  bool            `json:"Active"`            // Is this webhook enabled?
+	Ctime   time.Time       `json:"Ctime"`             // Time of registration
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks, when an event is
+// successfully applied to a document.
+type WebhookPayload struct {
+	Event     DocEventID      `json:"Event"`     // The applied event
+	DocType   DocTypeID       `json:"DocType"`   // Type of the affected document
+	DocID     DocumentID      `json:"DocID"`     // The affected document
+	Title     string          `json:"Title"`     // Title of the affected document
+	Action    DocActionID     `json:"Action"`    // Action performed
+	Actor     GroupID         `json:"Actor"`     // Singleton group of the performing user
+	FromState DocStateID      `json:"FromState"` // State before the event
+	ToState   DocStateID      `json:"ToState"`   // State after the event
+	AccCtx    AccessContextID `json:"AccCtx"`    // Access context of the document after the event
+	Comment   string          `json:"Comment"`   // Text of the event, if any
+	Ctime     time.Time       `json:"Ctime"`     // Time of the event
+}
+
+// Unexported type, only for convenience methods.
+type _Webhooks struct{}
+
+// Webhooks provides a resource-like interface to the webhooks
+// registered in the system.
+var Webhooks _Webhooks
+
+// New registers a webhook with the given endpoint and signing secret.
+// A value of `0` for `dtype` or `acid` does not restrict deliveries by
+// that criterion.
+func (_Webhooks) New(otx *sql.Tx, endpoint, secret string, dtype DocTypeID, acid AccessContextID) (WebhookID, error) {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return 0, err
+	}
+
+	endpoint = strings.TrimSpace(endpoint)
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return 0, newError(CodeValidation, "endpoint should be an absolute HTTP(S) URL")
+	}
+	if secret == "" {
+		return 0, newError(CodeValidation, "secret should be non-empty")
+	}
+	if dtype < 0 || acid < 0 {
+		return 0, newError(CodeValidation, "document type and access context IDs should be non-negative integers")
+	}
+
+	var id int64
+	err = withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_webhooks(url, secret, doctype_id, ac_id, active, ctime)
+		VALUES(?, ?, ?, ?, 1, NOW())
+		`
+		res, err := tx.Exec(q, endpoint, secret, sql.NullInt64{Int64: int64(dtype), Valid: dtype > 0},
+			sql.NullInt64{Int64: int64(acid), Valid: acid > 0})
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return WebhookID(id), nil
+}
+
+// scanWebhook reads one webhook from the given row.
+func scanWebhook(scan func(...interface{}) error) (*Webhook, error) {
+	var elem Webhook
+	var dtype, acid sql.NullInt64
+	err := scan(&elem.ID, &elem.URL, &elem.Secret, &dtype, &acid, &elem.Active, &elem.Ctime)
+	if err != nil {
+		return nil, err
+	}
+	elem.DocType = DocTypeID(dtype.Int64)
+	elem.AccCtx = AccessContextID(acid.Int64)
+	return &elem, nil
+}
+
+// List answers a subset of the registered webhooks.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_Webhooks) List(offset, limit int64) ([]*Webhook, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT id, url, secret, doctype_id, ac_id, active, ctime
+	FROM wf_webhooks
+	ORDER BY id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := readDB().Query(q, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*Webhook, 0, 10)
+	for rows.Next() {
+		elem, err := scanWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Get retrieves the webhook with the given ID.
+func (_Webhooks) Get(id WebhookID) (*Webhook, error) {
+	if id <= 0 {
+		return nil, newError(CodeValidation, "ID should be a positive integer")
+	}
+
+	q := `
+	SELECT id, url, secret, doctype_id, ac_id, active, ctime
+	FROM wf_webhooks
+	WHERE id = ?
+	`
+	elem, err := scanWebhook(readDB().QueryRow(q, id).Scan)
+	if err != nil {
+		return nil, notFound(err, ErrWebhookNotFound)
+	}
+	return elem, nil
+}
+
+// SetActive enables or disables the given webhook.  Deliveries are not
+// enqueued for disabled webhooks.
+func (_Webhooks) SetActive(otx *sql.Tx, id WebhookID, active bool) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		var flag int
+		if active {
+			flag = 1
+		}
+		_, err := tx.Exec("UPDATE wf_webhooks SET active = ? WHERE id = ?", flag, id)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete unregisters the given webhook, together with its delivery
+// history.
+func (_Webhooks) Delete(otx *sql.Tx, id WebhookID) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM wf_webhook_deliveries WHERE webhook_id = ?", id)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("DELETE FROM wf_webhooks WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if n != 1 {
+			return errorf(CodeNotFound, "expected number of affected rows : 1; actual affected : %d", n)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// enqueue records a pending delivery of the given applied event to
+// every matching active webhook.  It runs in the transaction applying
+// the event, so that nothing is delivered if that is rolled back.
+func (_Webhooks) enqueue(otx *sql.Tx, doc *Document, event *DocEvent, tstate DocStateID, acid AccessContextID) error {
+	payload, err := json.Marshal(&WebhookPayload{
+		Event:     event.ID,
+		DocType:   event.DocType,
+		DocID:     event.DocID,
+		Title:     doc.Title,
+		Action:    event.Action,
+		Actor:     event.Group,
+		FromState: event.State,
+		ToState:   tstate,
+		AccCtx:    acid,
+		Comment:   event.Text,
+		Ctime:     event.Ctime,
+	})
+	if err != nil {
+		return err
+	}
+
+	q := `
+	INSERT INTO wf_webhook_deliveries(webhook_id, docevent_id, payload, status, attempts, next_attempt, ctime, mtime)
+	SELECT id, ?, ?, 'P', 0, NOW(), NOW(), NOW()
+	FROM wf_webhooks
+	WHERE active = 1
+	AND (doctype_id IS NULL OR doctype_id = ?)
+	AND (ac_id IS NULL OR ac_id = ?)
+	`
+	_, err = otx.Exec(q, event.ID, string(payload), event.DocType, acid)
+	return err
+}
+
+// SignWebhookPayload answers the value of `WebhookSignatureHeader` for
+// the given payload.  Receivers can use this to verify deliveries.
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookStatus enumerates the states of a webhook delivery.
+type WebhookStatus uint8
+
+const (
+	// WebhookStatusPending : not yet delivered; may be awaiting a retry
+	WebhookStatusPending WebhookStatus = iota + 1
+	// WebhookStatusDelivered : acknowledged by the endpoint with a 2xx status
+	WebhookStatusDelivered
+	// WebhookStatusDead : abandoned after exhausting all attempts
+	WebhookStatusDead
+)
+
+// WebhookDeliveryID is the type of unique identifiers of webhook
+// deliveries.
+type WebhookDeliveryID int64
+
+// WebhookDelivery tracks the delivery of one event to one webhook.
+type WebhookDelivery struct {
+	ID          WebhookDeliveryID `json:"ID"`          // Unique identifier of this delivery
+	Webhook     WebhookID         `json:"Webhook"`     // Target webhook
+	Event       DocEventID        `json:"Event"`       // Event being delivered
+	Payload     string            `json:"Payload"`     // JSON body of the delivery
+	Status      WebhookStatus     `json:"Status"`      // Current status of this delivery
+	Attempts    int               `json:"Attempts"`    // Number of attempts made so far
+	NextAttempt time.Time         `json:"NextAttempt"` // Earliest time of the next attempt, if pending
+	LastError   string            `json:"LastError"`   // Error of the most recent failed attempt
+	Ctime       time.Time         `json:"Ctime"`       // Time when this delivery was enqueued
+	Mtime       time.Time         `json:"Mtime"`       // Time of the most recent status change
+}
+
+// WebhookDispatcher delivers enqueued webhook payloads.
+//
+// Failed attempts are retried with exponential backoff.  Deliveries
+// that fail `MaxAttempts` times are dead-lettered; they can be
+// inspected and redelivered using `WebhookDeliveries`.
+type WebhookDispatcher struct {
+	Client      *http.Client  // Defaults to a client with a 30-second timeout
+	MaxAttempts int           // Defaults to 8
+	Backoff     time.Duration // Delay before the first retry; doubles thereafter.  Defaults to 30 seconds
+	MaxBackoff  time.Duration // Upper bound of the delay; defaults to a day
+	BatchSize   int           // Deliveries attempted per round; defaults to 100
+}
+
+// config answers the effective settings of this dispatcher.
+func (d *WebhookDispatcher) config() WebhookDispatcher {
+	c := *d
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 8
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = 30 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 24 * time.Hour
+	}
+	if c.MaxBackoff < c.Backoff {
+		c.MaxBackoff = c.Backoff
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	return c
+}
+
+// retryDelay answers the delay before retrying a delivery that has
+// failed the given number of times: `Backoff`, doubled for each
+// further failure, up to `MaxBackoff`.
+func (d *WebhookDispatcher) retryDelay(failures int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < failures && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
+
+// Dispatch attempts those pending deliveries that are due.  It answers
+// the number of payloads successfully delivered.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context) (int, error) {
+	c := d.config()
+	client, maxAttempts, backoff, batch := c.Client, c.MaxAttempts, c.Backoff, c.BatchSize
+
+	q := `
+	SELECT whd.id, whd.attempts, whd.payload, wh.url, wh.secret
+	FROM wf_webhook_deliveries whd
+	JOIN wf_webhooks wh ON wh.id = whd.webhook_id
+	WHERE whd.status = 'P'
+	AND whd.next_attempt <= NOW()
+	ORDER BY whd.next_attempt
+	LIMIT ?
+	`
+	rows, err := db.Query(q, batch)
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		id       WebhookDeliveryID
+		attempts int
+		payload  string
+		url      string
+		secret   string
+	}
+	ary := make([]due, 0, batch)
+	for rows.Next() {
+		var elem due
+		err = rows.Scan(&elem.id, &elem.attempts, &elem.payload, &elem.url, &elem.secret)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ary = append(ary, elem)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, elem := range ary {
+		if err = ctx.Err(); err != nil {
+			return sent, err
+		}
+
+		// Claim this delivery for the duration of one backoff.
+		q = `
+		UPDATE wf_webhook_deliveries
+		SET next_attempt = ?
+		WHERE id = ?
+		AND status = 'P'
+		AND attempts = ?
+		AND next_attempt <= NOW()
+		`
+		res, err := db.Exec(q, time.Now().Add(backoff), elem.id, elem.attempts)
+		if err != nil {
+			return sent, err
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			continue
+		}
+
+		perr := d.post(ctx, client, elem.url, elem.secret, elem.id, []byte(elem.payload))
+		if perr == nil {
+			_, err = db.Exec("UPDATE wf_webhook_deliveries SET status = 'S', attempts = attempts + 1, last_error = NULL, mtime = NOW() WHERE id = ?", elem.id)
+			if err != nil {
+				return sent, err
+			}
+			sent++
+			continue
+		}
+
+		status := "P"
+		level := LogWarn
+		if elem.attempts+1 >= maxAttempts {
+			status = "D"
+			level = LogError
+		}
+		writeLog(level, "webhook delivery failed", F("delivery", elem.id), F("attempt", elem.attempts+1), F("error", perr))
+		errText := perr.Error()
+		if len(errText) > 500 {
+			errText = errText[:500]
+		}
+		q = `
+		UPDATE wf_webhook_deliveries
+		SET status = ?, attempts = attempts + 1, next_attempt = ?, last_error = ?, mtime = NOW()
+		WHERE id = ?
+		`
+		_, err = db.Exec(q, status, time.Now().Add(c.retryDelay(elem.attempts+1)), errText, elem.id)
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// post makes one delivery attempt.
+func (d *WebhookDispatcher) post(ctx context.Context, client *http.Client, endpoint, secret string,
+	id WebhookDeliveryID, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Flow-Delivery", fmt.Sprintf("%d", id))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(secret, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errorf(CodeInternal, "unexpected HTTP status : %s", resp.Status)
+	}
+	return nil
+}
+
+// Run dispatches webhook deliveries every `interval`, until the given
+// context is cancelled.  Errors are reported to `onErr`, if given, or
+// logged otherwise.  They do not stop the dispatcher.
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration, onErr func(error)) error {
+	if interval <= 0 {
+		return newError(CodeValidation, "interval should be a positive duration")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := d.Dispatch(ctx)
+		if err != nil {
+			if onErr != nil {
+				onErr(err)
+			} else {
+				writeLog(LogError, "webhook dispatch failed", F("error", err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// Unexported type, only for convenience methods.
+type _WebhookDeliveries struct{}
+
+// WebhookDeliveries provides a resource-like interface to the
+// deliveries of webhook payloads.
+var WebhookDeliveries _WebhookDeliveries
+
+// WebhookDeliveriesListInput specifies a set of filtering criteria on
+// webhook deliveries.
+type WebhookDeliveriesListInput struct {
+	WebhookID                // Deliveries to this webhook are listed, if non-zero
+	DocEventID               // Deliveries of this event are listed, if non-zero
+	Status     WebhookStatus // Deliveries in this status are listed, if non-zero
+}
+
+// List answers a subset of the webhook deliveries, based on the input
+// specification.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_WebhookDeliveries) List(input *WebhookDeliveriesListInput, offset, limit int64) ([]*WebhookDelivery, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if input != nil {
+		if input.WebhookID > 0 {
+			where = append(where, `webhook_id = ?`)
+			args = append(args, input.WebhookID)
+		}
+		if input.DocEventID > 0 {
+			where = append(where, `docevent_id = ?`)
+			args = append(args, input.DocEventID)
+		}
+		switch input.Status {
+		case 0:
+			// Nothing to do
+
+		case WebhookStatusPending:
+			where = append(where, `status = 'P'`)
+
+		case WebhookStatusDelivered:
+			where = append(where, `status = 'S'`)
+
+		case WebhookStatusDead:
+			where = append(where, `status = 'D'`)
+
+		default:
+			return nil, newError(CodeValidation, "unknown webhook delivery status")
+		}
+	}
+
+	q := `
+	SELECT id, webhook_id, docevent_id, payload, status, attempts, next_attempt, last_error, ctime, mtime
+	FROM wf_webhook_deliveries
+	`
+	if len(where) > 0 {
+		q += `WHERE ` + strings.Join(where, ` AND `)
+	}
+	q += `
+	ORDER BY id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+
+	rows, err := readDB().Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*WebhookDelivery, 0, 10)
+	for rows.Next() {
+		var elem WebhookDelivery
+		var status string
+		var lerr sql.NullString
+		err = rows.Scan(&elem.ID, &elem.Webhook, &elem.Event, &elem.Payload, &status, &elem.Attempts,
+			&elem.NextAttempt, &lerr, &elem.Ctime, &elem.Mtime)
+		if err != nil {
+			return nil, err
+		}
+		switch status {
+		case "P":
+			elem.Status = WebhookStatusPending
+
+		case "S":
+			elem.Status = WebhookStatusDelivered
+
+		case "D":
+			elem.Status = WebhookStatusDead
+
+		default:
+			return nil, errorf(CodeInternal, "unknown webhook delivery status : %s", status)
+		}
+		if lerr.Valid {
+			elem.LastError = lerr.String
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Redeliver resets the given delivery, so that it is attempted afresh
+// by the next dispatch.  Both dead-lettered and already delivered
+// payloads can be redelivered.
+func (_WebhookDeliveries) Redeliver(otx *sql.Tx, id WebhookDeliveryID) error {
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_webhook_deliveries
+		SET status = 'P', attempts = 0, next_attempt = NOW(), mtime = NOW()
+		WHERE id = ?
+		AND status IN ('S', 'D')
+		`
+		res, err := tx.Exec(q, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return newError(CodeNotFound, "no completed delivery with the given ID")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -16,9 +16,9 @@ package flow
 
 import (
 	"database/sql"
-	"errors"
 	"math"
 	"strings"
+	"time"
 )
 
 // WorkflowID is the type of unique workflow identifiers.
@@ -50,57 +50,213 @@ type Workflow struct {
 // applies its document action to the given document.  This results in
 // a possibly new document state.  This method also prepares a message
 // that is posted to applicable mailboxes.
+//
+// The group of the event should be the singleton group of the user
+// who caused it.  The registered `Authorizer` should allow that user
+// the action of the event in the access context of the document;
+// else, `ErrPermissionDenied` is answered.  Should that user have
+// applied too many events recently, `ErrRateLimited` is answered; see
+// `SetRateLimit`.  Events performing inactive actions are answered
+// `ErrDocActionInactive`; see `DocActions.SetActive`.
+//
+// When no transaction is given, the one begun here is retried upon
+// deadlocks, as per the retry policy.  Callers supplying their own
+// transaction can use `RetryTx` to the same effect.
+//
+// Use `ApplyEventOutcome` to learn of the messages posted, too.
 func (w *Workflow) ApplyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (DocStateID, error) {
+	out, err := w.ApplyEventOutcome(otx, event, recipients)
+	if out == nil {
+		return 0, err
+	}
+	return out.State, err
+}
+
+// EventOutcome describes the application of an event: the node that
+// processed it, the resulting document state, and the messages posted
+// about it, together with their recipients.
+type EventOutcome struct {
+	Node       NodeID      `json:"Node"`       // Node that processed the event
+	State      DocStateID  `json:"State"`      // State of the document thereafter
+	Messages   []MessageID `json:"Messages"`   // Messages posted, if any
+	Recipients []GroupID   `json:"Recipients"` // Groups to whose mailboxes they were posted
+}
+
+// ApplyEventOutcome applies the given event as `ApplyEvent` does, and
+// answers its outcome, so that callers can correlate the notifications
+// posted with the event, e.g. for logging, or for confirmation in user
+// interfaces.  Redundant events are recorded as applied, whether or
+// not a transaction is given, and answer their outcome together with
+// `ErrDocEventRedundant`.
+func (w *Workflow) ApplyEventOutcome(otx *sql.Tx, event *DocEvent, recipients []GroupID) (*EventOutcome, error) {
 	if !w.Active {
-		return 0, ErrWorkflowInactive
+		return nil, ErrWorkflowInactive
 	}
 	if event.Status == EventStatusApplied {
-		return 0, ErrDocEventAlreadyApplied
+		return nil, ErrDocEventAlreadyApplied
 	}
 	if w.DocType.ID != event.DocType {
-		return 0, ErrDocEventDocTypeMismatch
+		return nil, ErrDocEventDocTypeMismatch
+	}
+	da, err := DocActions.Get(event.Action)
+	if err != nil {
+		return nil, err
+	}
+	if !da.Active {
+		return nil, ErrDocActionInactive
 	}
 
 	n, err := Nodes.GetByState(w.DocType.ID, event.State)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if err = limiter.limitApply(otx, event); err != nil {
+		return nil, err
 	}
 
-	var gt string
-	tq := `SELECT group_type FROM wf_groups_master WHERE id = ?`
-	row := db.QueryRow(tq, event.Group)
-	err = row.Scan(&gt)
+	if otx != nil {
+		out := &EventOutcome{Messages: []MessageID{}, Recipients: []GroupID{}}
+		out.State, err = n.applyEvent(otx, event, recipients, out)
+		if err != nil && err != ErrDocEventRedundant {
+			return nil, err
+		}
+		return out, err
+	}
+
+	// Concurrent applications can deadlock on the rows of the
+	// document and of the mailboxes; such failures are retried.
+	var out *EventOutcome
+	var redundant bool
+	err = RetryTx(func(tx *sql.Tx) error {
+		var err error
+		out = &EventOutcome{Messages: []MessageID{}, Recipients: []GroupID{}}
+		out.State, err = n.applyEvent(tx, event, recipients, out)
+		redundant = err == ErrDocEventRedundant
+		if redundant {
+			// Have the event recorded as applied nonetheless.
+			return nil
+		}
+		return err
+	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if gt != "S" {
-		return 0, errors.New("group must be singleton")
+
+	// Have the side effects recorded in the outbox dispatched without
+	// delay.
+	Outbox.Notify()
+
+	if redundant {
+		return out, ErrDocEventRedundant
 	}
+	return out, nil
+}
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return 0, err
+// ActResult is the outcome of `Workflows.Act`.
+type ActResult struct {
+	Event DocEventID `json:"Event"` // The event raised
+	EventOutcome
+}
+
+// Act raises an event as per the given input, and applies it to its
+// document using the workflow of the document type, in one
+// transaction: either both succeed, or neither does.  This replaces
+// the sequence of `DocEvents.New`, `DocEvents.Get` and
+// `Workflow.ApplyEvent`, and answers the same errors that they do.
+//
+// Redundant events are recorded as applied; their outcomes are
+// answered together with `ErrDocEventRedundant`.  Events held back by
+// node capacity remain pending, and answer the current state of the
+// document.
+func (_Workflows) Act(otx *sql.Tx, input *DocEventsNewInput, recipients []GroupID) (*ActResult, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	w, err := Workflows.GetByDocType(input.DocTypeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if otx != nil {
+		return w.act(otx, input, recipients)
+	}
+
+	var res *ActResult
+	var redundant bool
+	err = RetryTx(func(tx *sql.Tx) error {
+		var err error
+		res, err = w.act(tx, input, recipients)
+		redundant = err == ErrDocEventRedundant
+		if redundant {
+			// Have the event recorded as applied nonetheless.
+			return nil
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	Outbox.Notify()
+
+	if redundant {
+		return res, ErrDocEventRedundant
 	}
+	return res, nil
+}
 
-	nstate, err := n.applyEvent(tx, event, recipients)
+// act raises and applies the event of the given input in the given
+// transaction.
+func (w *Workflow) act(tx *sql.Tx, input *DocEventsNewInput, recipients []GroupID) (*ActResult, error) {
+	eid, err := DocEvents.New(tx, input)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	event, err := getDocEvent(tx.QueryRow, eid)
+	if err != nil {
+		return nil, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
-		}
+	out, err := w.ApplyEventOutcome(tx, event, recipients)
+	if out == nil {
+		return nil, err
+	}
+	return &ActResult{Event: eid, EventOutcome: *out}, err
+}
+
+// applySystemEvent raises an event performing the given action on the
+// given document, in the given state, on behalf of the given group,
+// and applies it in the given transaction.  It answers the event.
+func (w *Workflow) applySystemEvent(tx *sql.Tx, did DocumentID, state DocStateID, action DocActionID,
+	gid GroupID, text string) (DocEventID, error) {
+	input := &DocEventsNewInput{
+		DocTypeID:   w.DocType.ID,
+		DocumentID:  did,
+		DocStateID:  state,
+		DocActionID: action,
+		GroupID:     gid,
+		Text:        text,
+	}
+	eid, err := DocEvents.New(tx, input)
+	if err != nil {
+		return 0, err
 	}
 
-	return nstate, nil
+	event := &DocEvent{
+		ID:      eid,
+		DocType: w.DocType.ID,
+		DocID:   did,
+		State:   state,
+		Action:  action,
+		Group:   gid,
+		Text:    text,
+		Ctime:   time.Now(),
+		Status:  EventStatusPending,
+	}
+	if _, err = w.ApplyEvent(tx, event, nil); err != nil {
+		return 0, err
+	}
+	return eid, nil
 }
 
 // Unexported type, only for convenience methods.
@@ -117,49 +273,43 @@ var Workflows _Workflows
 //
 // N.B.  Workflow names must be globally-unique.
 func (_Workflows) New(otx *sql.Tx, name string, dtype DocTypeID, state DocStateID) (WorkflowID, error) {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, errors.New("name should not be empty")
-	}
-	if dtype <= 0 {
-		return 0, errors.New("document type should be a positive integer")
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return 0, err
 	}
+
+	name = strings.TrimSpace(name)
+	var v validator
+	v.name("name", name, maxNameLen)
+	v.positive("dtype", int64(dtype))
 	if state <= 1 {
-		return 0, errors.New("initial document state should be an integer > 1")
+		v.fail("state", "should be an integer > 1")
+	}
+	v.unique(otx, "name", "wf_workflows", name, 0)
+	if err := v.result(); err != nil {
+		return 0, err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_workflows(name, doctype_id, docstate_id, active)
+		VALUES(?, ?, ?, 1)
+		`
+		res, err := tx.Exec(q, name, dtype, state)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `
-	INSERT INTO wf_workflows(name, doctype_id, docstate_id, active)
-	VALUES(?, ?, ?, 1)
-	`
-	res, err := tx.Exec(q, name, dtype, state)
-	if err != nil {
-		return 0, err
-	}
-	id, err := res.LastInsertId()
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
-		}
-	}
-
 	return WorkflowID(id), nil
 }
 
@@ -171,7 +321,7 @@ func (_Workflows) New(otx *sql.Tx, name string, dtype DocTypeID, state DocStateI
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_Workflows) List(offset, limit int64) ([]*Workflow, error) {
 	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
 	}
 	if limit == 0 {
 		limit = math.MaxInt64
@@ -185,7 +335,7 @@ func (_Workflows) List(offset, limit int64) ([]*Workflow, error) {
 	ORDER BY wf.id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := readDB().Query(q, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -208,6 +358,125 @@ func (_Workflows) List(offset, limit int64) ([]*Workflow, error) {
 	return ary, nil
 }
 
+// WorkflowsListInput specifies a set of filtering criteria on
+// workflows.  All the given criteria should be met.
+type WorkflowsListInput struct {
+	DocTypeID         // List the workflow of this document type
+	Active     *bool  // List only the active workflows, or only the inactive ones, if given
+	NamePrefix string // List workflows whose names begin with this
+}
+
+// where answers the SQL condition and the arguments of this
+// specification.
+func (input *WorkflowsListInput) where() (string, []interface{}) {
+	conds := []string{`1 = 1`}
+	args := []interface{}{}
+
+	if input.DocTypeID > 0 {
+		conds = append(conds, `wf.doctype_id = ?`)
+		args = append(args, input.DocTypeID)
+	}
+	if input.Active != nil {
+		conds = append(conds, `wf.active = ?`)
+		args = append(args, *input.Active)
+	}
+	if prefix := strings.TrimSpace(input.NamePrefix); prefix != "" {
+		conds = append(conds, `wf.name LIKE ?`)
+		args = append(args, likeEscape(prefix)+"%")
+	}
+
+	return strings.Join(conds, ` AND `), args
+}
+
+// WorkflowSummary is a workflow, together with figures describing its
+// definition.
+type WorkflowSummary struct {
+	Workflow
+	Nodes   int64 `json:"Nodes"`   // Number of nodes of this workflow
+	Covered bool  `json:"Covered"` // Can every transition out of its nodes other than end nodes be performed by someone?
+}
+
+// Search answers a subset of the workflows meeting the given criteria,
+// with the number of their nodes, and whether their transitions are
+// covered.  A transition is covered if its action is granted to some
+// group having members, in the access context of its node, if the
+// node has one; in any access context, otherwise.  Use `Coverage` to
+// find the transitions not covered in a specific access context.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_Workflows) Search(input *WorkflowsListInput, offset, limit int64) ([]*WorkflowSummary, error) {
+	if input == nil {
+		return nil, newError(CodeValidation, "input should be non-nil")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, newError(CodeValidation, "offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	where, args := input.where()
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active,
+		(SELECT COUNT(*) FROM wf_workflow_nodes wn WHERE wn.workflow_id = wf.id),
+		NOT EXISTS (
+			SELECT 1
+			FROM wf_workflow_nodes wn
+			JOIN wf_docstate_transitions dst ON dst.doctype_id = wn.doctype_id AND dst.from_state_id = wn.docstate_id
+			WHERE wn.workflow_id = wf.id
+			AND wn.type <> 'end'
+			AND NOT EXISTS (
+				SELECT 1
+				FROM wf_ac_perms_v acpv
+				WHERE acpv.doctype_id = dst.doctype_id
+				AND acpv.docaction_id = dst.docaction_id
+				AND (wn.ac_id IS NULL OR acpv.ac_id = wn.ac_id)
+			)
+		)
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	WHERE ` + where + `
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+
+	ary := make([]*WorkflowSummary, 0, 10)
+	err := scanEach(readDB().Query, q, args, func(scan func(...interface{}) error) error {
+		var elem WorkflowSummary
+		err := scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
+			&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active, &elem.Nodes, &elem.Covered)
+		if err != nil {
+			return err
+		}
+		ary = append(ary, &elem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// Count answers the number of workflows meeting the given criteria.
+func (_Workflows) Count(input *WorkflowsListInput) (int64, error) {
+	if input == nil {
+		return 0, newError(CodeValidation, "input should be non-nil")
+	}
+
+	where, args := input.where()
+	var n int64
+	err := readDB().QueryRow(`SELECT COUNT(*) FROM wf_workflows wf WHERE `+where, args...).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 // Get retrieves the details of the requested workflow from the
 // database.
 //
@@ -222,12 +491,12 @@ func (_Workflows) Get(id WorkflowID) (*Workflow, error) {
 	JOIN wf_docstates_master dsm ON dsm.id = wf.docstate_id
 	WHERE wf.id = ?
 	`
-	row := db.QueryRow(q, id)
+	row := readDB().QueryRow(q, id)
 	var elem Workflow
 	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
 		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrWorkflowNotFound)
 	}
 
 	return &elem, nil
@@ -247,12 +516,12 @@ func (_Workflows) GetByDocType(dtid DocTypeID) (*Workflow, error) {
 	JOIN wf_docstates_master dsm ON dsm.id = wf.docstate_id
 	WHERE wf.doctype_id = ?
 	`
-	row := db.QueryRow(q, dtid)
+	row := readDB().QueryRow(q, dtid)
 	var elem Workflow
 	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
 		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrWorkflowNotFound)
 	}
 
 	return &elem, nil
@@ -272,12 +541,12 @@ func (_Workflows) GetByName(name string) (*Workflow, error) {
 	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
 	WHERE wf.name = ?
 	`
-	row := db.QueryRow(q, name)
+	row := readDB().QueryRow(q, name)
 	var elem Workflow
 	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
 		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrWorkflowNotFound)
 	}
 
 	return &elem, nil
@@ -285,78 +554,125 @@ func (_Workflows) GetByName(name string) (*Workflow, error) {
 
 // Rename assigns a new name to the given workflow.
 func (_Workflows) Rename(otx *sql.Tx, id WorkflowID, name string) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
 	name = strings.TrimSpace(name)
-	if name == "" {
-		return errors.New("name should be non-empty")
+	var v validator
+	v.positive("id", int64(id))
+	v.name("name", name, maxNameLen)
+	v.unique(otx, "name", "wf_workflows", name, int64(id))
+	if err := v.result(); err != nil {
+		return err
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE wf_workflows SET name = ?
+		WHERE id = ?
+		`
+		_, err := tx.Exec(q, name, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `
-	UPDATE wf_workflows SET name = ?
-	WHERE id = ?
-	`
-	_, err = tx.Exec(q, name, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
 // SetActive sets the status of the workflow as either active or
 // inactive, helping in workflow management and deprecation.
 func (_Workflows) SetActive(otx *sql.Tx, id WorkflowID, active bool) error {
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+
+	err := withTx(otx, func(tx *sql.Tx) error {
+		var flag int
+		if active {
+			flag = 1
+		}
+		q := `
+		UPDATE wf_workflows SET active = ?
+		WHERE id = ?
+		`
+		_, err := tx.Exec(q, flag, id)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	var flag int
-	if active {
-		flag = 1
-	}
-	q := `
-	UPDATE wf_workflows SET active = ?
-	WHERE id = ?
-	`
-	_, err = tx.Exec(q, flag, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	return nil
+}
+
+// Delete deletes the given workflow together with its nodes and their
+// configuration, provided that no document of its type exists, and no
+// event on such a document is on record -- i.e. the workflow has never
+// been used, or its documents have been purged since.  Otherwise,
+// `ErrWorkflowInUse` is answered; such a workflow can only be
+// deactivated.
+func (_Workflows) Delete(otx *sql.Tx, id WorkflowID) error {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return err
+	}
+	if id <= 0 {
+		return newError(CodeValidation, "workflow ID must be a positive integer")
+	}
+
+	return withTx(otx, func(tx *sql.Tx) error {
+		var dtype DocTypeID
+		err := tx.QueryRow("SELECT doctype_id FROM wf_workflows WHERE id = ? FOR UPDATE", id).Scan(&dtype)
 		if err != nil {
+			return notFound(err, ErrWorkflowNotFound)
+		}
+
+		var n int64
+		q := `SELECT COUNT(*) FROM ` + DocTypes.docStorName(dtype)
+		if err = tx.QueryRow(q).Scan(&n); err != nil {
 			return err
 		}
-	}
+		if n == 0 {
+			err = tx.QueryRow("SELECT COUNT(*) FROM wf_docevents WHERE doctype_id = ?", dtype).Scan(&n)
+			if err != nil {
+				return err
+			}
+		}
+		if n > 0 {
+			return ErrWorkflowInUse
+		}
 
-	return nil
+		// The configuration of the nodes goes first.
+		for _, tbl := range []string{"wf_node_auto_actions", "wf_node_capacities", "wf_node_compensations",
+			"wf_node_external_tasks", "wf_node_reminders", "wf_node_returns", "wf_node_slas",
+			"wf_node_vote_policies", "wf_node_voters", "wf_node_webhooks"} {
+			q = `
+			DELETE FROM ` + tbl + `
+			WHERE node_id IN (SELECT id FROM wf_workflow_nodes WHERE workflow_id = ?)
+			`
+			if _, err = tx.Exec(q, id); err != nil {
+				return err
+			}
+		}
+		if _, err = tx.Exec("DELETE FROM wf_workflow_capacities WHERE workflow_id = ?", id); err != nil {
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM wf_workflow_nodes WHERE workflow_id = ?", id); err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM wf_workflows WHERE id = ?", id)
+		return err
+	})
 }
 
 // AddNode maps the given document state to the specified node.  This
@@ -364,43 +680,41 @@ func (_Workflows) SetActive(otx *sql.Tx, id WorkflowID, active bool) error {
 // of the system.
 func (_Workflows) AddNode(otx *sql.Tx, dtype DocTypeID, state DocStateID,
 	ac AccessContextID, wid WorkflowID, name string, ntype NodeType) (NodeID, error) {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
+		return 0, err
+	}
+
 	name = strings.TrimSpace(name)
 	if name == "" {
-		return 0, errors.New("name should not be empty")
+		return 0, newError(CodeValidation, "name should not be empty")
 	}
 
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
+	var id int64
+	err := withTx(otx, func(tx *sql.Tx) error {
+		// A node need not have its own access context.
+		var acID sql.NullInt64
+		if ac > 0 {
+			acID = sql.NullInt64{Int64: int64(ac), Valid: true}
+		}
+		q := `
+		INSERT INTO wf_workflow_nodes(doctype_id, docstate_id, ac_id, workflow_id, name, type)
+		VALUES(?, ?, ?, ?, ?, ?)
+		`
+		res, err := tx.Exec(q, dtype, state, acID, wid, name, string(ntype))
 		if err != nil {
-			return 0, err
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
 
-	q := `
-	INSERT INTO wf_workflow_nodes(doctype_id, docstate_id, ac_id, workflow_id, name, type)
-	VALUES(?, ?, ?, ?, ?, ?)
-	`
-	res, err := tx.Exec(q, dtype, state, ac, wid, name, string(ntype))
-	if err != nil {
-		return 0, err
-	}
-	id, err := res.LastInsertId()
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
-		}
-	}
-
 	return NodeID(id), nil
 }
 
@@ -408,33 +722,25 @@ func (_Workflows) AddNode(otx *sql.Tx, dtype DocTypeID, state DocStateID,
 // This map is consulted by the workflow when performing a state
 // transition of the system.
 func (_Workflows) RemoveNode(otx *sql.Tx, wid WorkflowID, nid NodeID) error {
-	var tx *sql.Tx
-	var err error
-	if otx == nil {
-		tx, err = db.Begin()
-		if err != nil {
-			return err
-		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
-	q := `
-	DELETE FROM wf_workflow_nodes
-	WHERE workflow_id = ?
-	AND id = ?
-	`
-	_, err = tx.Exec(q, wid, nid)
-	if err != nil {
+	if err := checkAdmin(otx, AdminWorkflows); err != nil {
 		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
+	err := withTx(otx, func(tx *sql.Tx) error {
+		q := `
+		DELETE FROM wf_workflow_nodes
+		WHERE workflow_id = ?
+		AND id = ?
+		`
+		_, err := tx.Exec(q, wid, nid)
 		if err != nil {
 			return err
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil